@@ -0,0 +1,309 @@
+// Package accessory drives outputs other than the LEDs, such as a
+// refugium pump's relay, a 0-10 V accessory port or a canopy fan, which
+// lighting tables schedule by name as they do channels. Each has its
+// own safety limits, which no table can take it past.
+package accessory
+
+import (
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"math"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/theatrus/ledbrick/controller/alert"
+	"github.com/theatrus/ledbrick/controller/ble"
+	"github.com/theatrus/ledbrick/controller/clock"
+	"github.com/theatrus/ledbrick/controller/gpio"
+)
+
+var configFile string
+var pwmDir string
+
+func init() {
+	flag.StringVar(&configFile, "accessory.config", "",
+		"JSON file listing accessory outputs, such as a pump relay, which lighting tables can schedule by name")
+	flag.StringVar(&pwmDir, "accessory.pwm.dir", "/sys/class/pwm",
+		"Directory of the sysfs PWM interface driving 0-10 V accessory ports")
+}
+
+// Converters from PWM to 0-10 V expect around 1 kHz
+const pwmPeriod = time.Millisecond
+
+// Types of accessory
+const (
+	// Relay switches a GPIO pin, on at or above its On percent
+	Relay = "relay"
+	// Voltage drives a sysfs PWM output through a 0-10 V converter
+	Voltage = "voltage"
+	// Fan overrides the fixtures' fans, never below their fan curve
+	Fan = "fan"
+)
+
+// Config describes one accessory output.
+type Config struct {
+	// Name is how tables give the accessory, and can't be a channel's
+	Name string `json:"name"`
+	// Type is relay, voltage or fan
+	Type string `json:"type"`
+	// Pin is a relay's GPIO pin, and Invert is set for a relay board
+	// switched on by driving the pin low
+	Pin    int  `json:"pin"`
+	Invert bool `json:"invert"`
+	// Chip and Output are the sysfs PWM driving a 0-10 V port
+	Chip   int `json:"chip"`
+	Output int `json:"output"`
+	// Min and Max bound the percent a table can set, 0 to 100 if Max
+	// isn't given
+	Min float64 `json:"min"`
+	Max float64 `json:"max"`
+	// On is the percent at or above which a relay closes, 50 if not
+	// given
+	On float64 `json:"on"`
+	// Hold is the shortest time a relay stays switched, so a table
+	// hovering around On can't cycle a pump hard
+	Hold ble.Duration `json:"hold"`
+}
+
+// State is an accessory's last setting.
+type State struct {
+	Name    string  `json:"name"`
+	Type    string  `json:"type"`
+	Percent float64 `json:"percent"`
+	// On is whether a relay is closed
+	On *bool `json:"on,omitempty"`
+	// Since is when the setting last changed
+	Since time.Time `json:"since"`
+	Error string    `json:"error,omitempty"`
+}
+
+// accessory is an output and what it was last set to.
+type accessory struct {
+	Config
+	write func(percent float64) error
+
+	set      bool
+	percent  float64
+	on       bool
+	switched time.Time
+	failed   string
+}
+
+// Accessories drives the configured accessory outputs.
+type Accessories struct {
+	clock  clock.Clock
+	byName map[string]*accessory
+	lock   sync.Mutex
+}
+
+var configs []Config
+var accessories *Accessories
+
+// Load reads and checks the -accessory.config, so tables can name the
+// accessories. Without one there are none.
+func Load() error {
+	configs = nil
+	if configFile == "" {
+		return nil
+	}
+	data, err := ioutil.ReadFile(configFile)
+	if err != nil {
+		return err
+	}
+	var loaded []Config
+	if err := json.Unmarshal(data, &loaded); err != nil {
+		return fmt.Errorf("%s: %v", configFile, err)
+	}
+	seen := make(map[string]bool)
+	for i := range loaded {
+		c := &loaded[i]
+		if err := c.check(); err != nil {
+			return fmt.Errorf("%s: %v", configFile, err)
+		}
+		if seen[c.Name] {
+			return fmt.Errorf("%s: accessory %q is listed twice", configFile, c.Name)
+		}
+		seen[c.Name] = true
+	}
+	configs = loaded
+	return nil
+}
+
+// check validates a config and fills in its defaults.
+func (c *Config) check() error {
+	if c.Name == "" {
+		return errors.New("accessories need a name")
+	}
+	if _, err := ble.ChannelNumber(c.Name); err == nil {
+		return fmt.Errorf("accessory %q can't share a channel's name", c.Name)
+	}
+	switch c.Type {
+	case Relay, Voltage, Fan:
+	default:
+		return fmt.Errorf("accessory %s: unknown type %q, expected relay, voltage or fan", c.Name, c.Type)
+	}
+	if c.Max == 0 {
+		c.Max = 100
+	}
+	if c.On == 0 {
+		c.On = 50
+	}
+	if c.Min < 0 || c.Max > 100 || c.Min > c.Max {
+		return fmt.Errorf("accessory %s: limits %.1f-%.1f%% should be within 0-100%%", c.Name, c.Min, c.Max)
+	}
+	return nil
+}
+
+// Known reports whether an accessory is configured.
+func Known(name string) bool {
+	for _, c := range configs {
+		if c.Name == name {
+			return true
+		}
+	}
+	return false
+}
+
+// Start opens the accessories loaded, fans going through the fixtures
+// on b. Nothing is written until a table sets them, so an accessory no
+// table gives is left as it is.
+func Start(b ble.BLEChannel) error {
+	a := newAccessories(clock.Real)
+	for _, c := range configs {
+		acc := &accessory{Config: c}
+		switch c.Type {
+		case Relay:
+			pin, invert := c.Pin, c.Invert
+			acc.write = func(percent float64) error {
+				return gpio.Write(pin, (percent > 0) != invert)
+			}
+		case Voltage:
+			write, err := openPWM(c.Chip, c.Output)
+			if err != nil {
+				return fmt.Errorf("accessory %s: %v", c.Name, err)
+			}
+			acc.write = write
+		case Fan:
+			acc.write = b.SetFanOverride
+		}
+		a.byName[c.Name] = acc
+		log.Printf("Accessory %s is a %s output", c.Name, c.Type)
+	}
+	accessories = a
+	return nil
+}
+
+func newAccessories(c clock.Clock) *Accessories {
+	return &Accessories{clock: c, byName: make(map[string]*accessory)}
+}
+
+// Set drives an accessory to a percent within its limits, if it has
+// been started. A relay is switched on or off, but not again until it
+// has held for its Hold. Failures are alerted once, and retried on the
+// next setting.
+func Set(name string, percent float64) {
+	if accessories != nil {
+		accessories.Set(name, percent)
+	}
+}
+
+func (a *Accessories) Set(name string, percent float64) {
+	a.lock.Lock()
+	defer a.lock.Unlock()
+	acc, ok := a.byName[name]
+	if !ok {
+		return
+	}
+	now := a.clock.Now()
+	percent = math.Max(acc.Min, math.Min(acc.Max, percent))
+	if acc.Type == Relay {
+		on := percent >= acc.On
+		switch {
+		case !acc.set || acc.failed != "":
+			// Written whatever it was, to be sure of it
+		case on == acc.on, now.Sub(acc.switched) < acc.Hold.Duration:
+			return
+		}
+		percent = 0
+		if on {
+			percent = 100
+		}
+		acc.on = on
+	} else if acc.set && acc.failed == "" && math.Abs(percent-acc.percent) < 0.1 {
+		return
+	}
+	if !acc.set || percent != acc.percent {
+		acc.switched = now
+	}
+	acc.set = true
+	acc.percent = percent
+
+	err := acc.write(percent)
+	switch {
+	case err != nil && acc.failed == "":
+		alert.Raise(alert.Warning, name, "accessory.failed", "failed to set accessory %s: %v", name, err)
+		acc.failed = err.Error()
+	case err != nil:
+		acc.failed = err.Error()
+	case acc.failed != "":
+		alert.Raise(alert.Info, name, "accessory.failed", "accessory %s working again", name)
+		acc.failed = ""
+	}
+}
+
+// States returns every accessory's last setting, by name.
+func States() []State {
+	if accessories == nil {
+		return make([]State, 0)
+	}
+	return accessories.States()
+}
+
+func (a *Accessories) States() []State {
+	a.lock.Lock()
+	defer a.lock.Unlock()
+	states := make([]State, 0, len(a.byName))
+	for name, acc := range a.byName {
+		s := State{Name: name, Type: acc.Type, Percent: acc.percent, Since: acc.switched, Error: acc.failed}
+		if acc.Type == Relay && acc.set {
+			on := acc.on
+			s.On = &on
+		}
+		states = append(states, s)
+	}
+	sort.Slice(states, func(i, j int) bool { return states[i].Name < states[j].Name })
+	return states
+}
+
+// openPWM exports and enables a sysfs PWM output, returning how to set
+// its duty cycle in percent.
+func openPWM(chip, output int) (func(percent float64) error, error) {
+	chipDir := filepath.Join(pwmDir, fmt.Sprintf("pwmchip%d", chip))
+	dir := filepath.Join(chipDir, fmt.Sprintf("pwm%d", output))
+	if _, err := os.Stat(dir); os.IsNotExist(err) {
+		if err := ioutil.WriteFile(filepath.Join(chipDir, "export"), []byte(fmt.Sprint(output)), 0200); err != nil {
+			return nil, err
+		}
+	}
+	period := int64(pwmPeriod / time.Nanosecond)
+	for _, w := range []struct{ file, value string }{
+		{"period", fmt.Sprint(period)},
+		{"duty_cycle", "0"},
+		{"enable", "1"},
+	} {
+		if err := ioutil.WriteFile(filepath.Join(dir, w.file), []byte(w.value), 0644); err != nil {
+			return nil, err
+		}
+	}
+	return func(percent float64) error {
+		duty := int64(float64(period) * percent / 100)
+		return ioutil.WriteFile(filepath.Join(dir, "duty_cycle"), []byte(fmt.Sprint(duty)), 0644)
+	}, nil
+}
@@ -0,0 +1,112 @@
+package accessory
+
+import (
+	"errors"
+	"io/ioutil"
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/theatrus/ledbrick/controller/ble"
+	"github.com/theatrus/ledbrick/controller/clock"
+)
+
+func TestRelay(t *testing.T) {
+	c := clock.NewFake(time.Date(2016, 1, 1, 12, 0, 0, 0, time.UTC))
+	a := newAccessories(c)
+	var writes []float64
+	config := Config{Name: "pump", Type: Relay, Hold: ble.Duration{Duration: 10 * time.Minute}}
+	config.check()
+	a.byName["pump"] = &accessory{Config: config, write: func(percent float64) error {
+		writes = append(writes, percent)
+		return nil
+	}}
+
+	a.Set("pump", 80)
+	a.Set("pump", 90)
+	// Too soon after switching on to switch off
+	c.Advance(time.Minute)
+	a.Set("pump", 20)
+	c.Advance(10 * time.Minute)
+	a.Set("pump", 20)
+	if len(writes) != 2 || writes[0] != 100 || writes[1] != 0 {
+		t.Errorf("Expected the relay on then off once held, got %v", writes)
+	}
+	states := a.States()
+	if len(states) != 1 || states[0].On == nil || *states[0].On {
+		t.Errorf("Expected the relay off, got %+v", states)
+	}
+}
+
+func TestLimits(t *testing.T) {
+	a := newAccessories(clock.NewFake(time.Now()))
+	var writes []float64
+	failing := errors.New("no PWM")
+	var fail error
+	config := Config{Name: "port", Type: Voltage, Min: 10, Max: 60}
+	config.check()
+	a.byName["port"] = &accessory{Config: config, write: func(percent float64) error {
+		writes = append(writes, percent)
+		return fail
+	}}
+
+	a.Set("port", 0)
+	a.Set("port", 100)
+	a.Set("port", 100)
+	if len(writes) != 2 || writes[0] != 10 || writes[1] != 60 {
+		t.Errorf("Expected settings kept within 10-60%%, got %v", writes)
+	}
+	fail = failing
+	a.Set("port", 30)
+	if s := a.States(); s[0].Error != "no PWM" {
+		t.Errorf("Expected the failure in the state, got %+v", s)
+	}
+	// A failed write is retried even without a change
+	fail = nil
+	a.Set("port", 30)
+	if len(writes) != 4 || a.States()[0].Error != "" {
+		t.Errorf("Expected the write retried and working, got %v %+v", writes, a.States())
+	}
+	// Accessories not configured are ignored
+	a.Set("skimmer", 50)
+}
+
+func TestLoad(t *testing.T) {
+	f, err := ioutil.TempFile("", "accessories")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.Remove(f.Name())
+	defer func() { configFile, configs = "", nil }()
+	configFile = f.Name()
+
+	for _, tc := range []struct {
+		config string
+		err    string
+	}{
+		{`[{"name": "pump", "type": "relay", "pin": 17}, {"name": "exhaust", "type": "fan"}]`, ""},
+		{`[{"name": "Blue", "type": "relay"}]`, "can't share a channel's name"},
+		{`[{"name": "pump", "type": "valve"}]`, "unknown type"},
+		{`[{"name": "pump", "type": "relay", "min": 50, "max": 20}]`, "should be within 0-100%"},
+		{`[{"name": "pump", "type": "relay"}, {"name": "pump", "type": "fan"}]`, "listed twice"},
+	} {
+		ioutil.WriteFile(f.Name(), []byte(tc.config), 0644)
+		err := Load()
+		if tc.err == "" {
+			if err != nil {
+				t.Errorf("%s: %v", tc.config, err)
+			}
+			if !Known("pump") || !Known("exhaust") || Known("skimmer") {
+				t.Errorf("Expected pump and exhaust known, got %+v", configs)
+			}
+			if configs[0].Max != 100 || configs[0].On != 50 {
+				t.Errorf("Expected defaults filled in, got %+v", configs[0])
+			}
+			continue
+		}
+		if err == nil || !strings.Contains(err.Error(), tc.err) {
+			t.Errorf("%s: expected %q, got %v", tc.config, tc.err, err)
+		}
+	}
+}
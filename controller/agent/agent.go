@@ -0,0 +1,504 @@
+// Package agent splits the controller in two: a thin agent next to the
+// fixtures doing only the bluetooth I/O, and a server anywhere on the
+// network running the schedule, API and everything else. They talk over
+// a TLS link of JSON lines, plain TCP only on loopback. The server drives the agent's channels and
+// the agent reports the fixtures' state back every -agent.interval. If
+// the server goes quiet for -agent.failsafe, the agent runs the last
+// table the server sent it on its own until the server is back.
+package agent
+
+import (
+	"bufio"
+	"crypto/subtle"
+	"crypto/tls"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"math"
+	"net"
+	"sync"
+	"time"
+
+	"github.com/theatrus/ledbrick/controller/alert"
+	"github.com/theatrus/ledbrick/controller/ble"
+	"github.com/theatrus/ledbrick/controller/clock"
+)
+
+var interval time.Duration
+var failsafe time.Duration
+var token string
+var certFile, keyFile, caFile string
+
+func init() {
+	flag.DurationVar(&interval, "agent.interval", time.Second,
+		"How often the agent reports fixture state to the server, and the server checks in")
+	flag.DurationVar(&failsafe, "agent.failsafe", 30*time.Second,
+		"How long the agent waits without hearing from the server before running the last table on its own")
+	flag.StringVar(&token, "agent.token", "",
+		"Shared secret the server must present to the agent, needed to listen on anything but loopback")
+	flag.StringVar(&certFile, "agent.cert", "",
+		"PEM certificate the agent serves TLS with, needed to listen on anything but loopback")
+	flag.StringVar(&keyFile, "agent.key", "",
+		"PEM private key of -agent.cert")
+	flag.StringVar(&caFile, "agent.ca", "",
+		"PEM certificate the server checks the agent's against, the agent's own if self-signed, needed to reach an agent on anything but loopback")
+}
+
+// maxHello is the longest hello line taken, so a client can't have the
+// agent buffer without end before it presents the token.
+const maxHello = 4096
+
+// hello is the first line the server sends.
+type hello struct {
+	Token string `json:"token"`
+}
+
+// request is a call from the server to one of the agent's tanks, ""
+// for the whole controller.
+type request struct {
+	ID       int             `json:"id"`
+	Tank     string          `json:"tank,omitempty"`
+	Op       string          `json:"op"`
+	Channel  int             `json:"channel,omitempty"`
+	Percent  float64         `json:"percent,omitempty"`
+	Name     string          `json:"name,omitempty"`
+	Fixture  string          `json:"fixture,omitempty"`
+	Percents map[int]float64 `json:"percents,omitempty"`
+	Program  *program        `json:"program,omitempty"`
+}
+
+// program is a ble.Program on the wire, with its zone by name.
+type program struct {
+	Zone   string             `json:"zone,omitempty"`
+	Points []ble.ProgramPoint `json:"points"`
+}
+
+func toWire(prog ble.Program) *program {
+	p := &program{Points: prog.Points}
+	if prog.Location != nil {
+		p.Zone = prog.Location.String()
+	}
+	return p
+}
+
+func (p *program) program() (ble.Program, error) {
+	prog := ble.Program{Points: p.Points}
+	if p.Zone != "" && p.Zone != "Local" {
+		loc, err := time.LoadLocation(p.Zone)
+		if err != nil {
+			return prog, err
+		}
+		prog.Location = loc
+	}
+	return prog, nil
+}
+
+// message is the agent's reply to a request, or its state report with
+// an ID of 0.
+type message struct {
+	ID    int     `json:"id,omitempty"`
+	Error string  `json:"error,omitempty"`
+	Watts float64 `json:"watts,omitempty"`
+	State *state  `json:"state,omitempty"`
+}
+
+// state is what the agent knows of the fixtures.
+type state struct {
+	Tanks           map[string]tankState      `json:"tanks"`
+	Fixtures        []fixtureState            `json:"fixtures"`
+	Degraded        bool                      `json:"degraded"`
+	DisconnectCount int                       `json:"disconnect_count"`
+	WriteStats      map[string]ble.WriteStats `json:"write_stats"`
+}
+
+// tankState is the state of the whole controller, or one tank.
+type tankState struct {
+	Channels         map[int]float64             `json:"channels"`
+	Limits           map[string]float64          `json:"limits"`
+	Scales           map[string]float64          `json:"scales"`
+	Exposure         map[int]time.Duration       `json:"exposure"`
+	Availability     map[string]ble.Availability `json:"availability"`
+	EffectsSuspended bool                        `json:"effects_suspended"`
+	// Fixtures are the IDs of the connected fixtures
+	Fixtures []string `json:"fixtures"`
+}
+
+// fixtureState is a connected fixture, with its latest sample.
+type fixtureState struct {
+	ID              string         `json:"id"`
+	Name            string         `json:"name,omitempty"`
+	Active          bool           `json:"active"`
+	Temperature     int            `json:"temperature"`
+	FanRPM          int            `json:"fan_rpm"`
+	FanFailed       bool           `json:"fan_failed"`
+	Watts           float64        `json:"watts"`
+	EnergyToday     float64        `json:"energy_today"`
+	EnergyPrevDay   float64        `json:"energy_prev_day"`
+	EnergyMonth     float64        `json:"energy_month"`
+	EnergyPrevMonth float64        `json:"energy_prev_month"`
+	ChannelCurrents []int          `json:"channel_currents"`
+	ChannelFaults   map[int]string `json:"channel_faults"`
+	Sample          *ble.Sample    `json:"sample,omitempty"`
+}
+
+// Agent serves a channel's fixtures to a server.
+type Agent struct {
+	ble   ble.BLEChannel
+	clock clock.Clock
+	// interval is how often state is reported and the failsafe checked
+	interval time.Duration
+
+	mu sync.Mutex
+	// programs are the tables last sent by the server, by tank
+	programs  map[string]ble.Program
+	lastHeard time.Time
+	// heard is whether a server has ever connected, and alone whether
+	// the agent is running the tables on its own
+	heard bool
+	alone bool
+	// released is set when the server let go of the fixtures, so the
+	// tables run on their own without waiting out the failsafe
+	released bool
+	// serving is the connection of the server being served, if any
+	serving net.Conn
+}
+
+// NewAgent returns an agent for a channel, waiting for a server.
+func NewAgent(b ble.BLEChannel) *Agent {
+	return &Agent{ble: b, clock: clock.Real, interval: interval, programs: make(map[string]ble.Program)}
+}
+
+// Serve accepts servers on a listener, one at a time, a new one taking
+// over from the last once it has presented the token. It only returns
+// if the listener fails.
+func (a *Agent) Serve(l net.Listener) error {
+	go a.watch()
+	for {
+		conn, err := l.Accept()
+		if err != nil {
+			return err
+		}
+		go a.serveConn(conn)
+	}
+}
+
+// takeOver makes conn the server served, closing the last one's.
+func (a *Agent) takeOver(conn net.Conn) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	if a.serving != nil {
+		a.serving.Close()
+	}
+	a.serving = conn
+}
+
+// ListenAndServe listens on a TCP address and serves servers on it.
+// Anything but a loopback address needs -agent.token, as whoever
+// reaches the agent drives the lights, and -agent.cert so the token
+// doesn't cross the network in the clear.
+func (a *Agent) ListenAndServe(addr string) error {
+	l, err := listen(addr)
+	if err != nil {
+		return err
+	}
+	log.Printf("Agent waiting for a server on %s", l.Addr())
+	return a.Serve(l)
+}
+
+// listen listens on a TCP address, with TLS if -agent.cert is set.
+func listen(addr string) (net.Listener, error) {
+	if !loopback(addr) && (token == "" || certFile == "") {
+		return nil, fmt.Errorf("listening on %s needs -agent.token and -agent.cert", addr)
+	}
+	var config *tls.Config
+	if certFile != "" {
+		cert, err := tls.LoadX509KeyPair(certFile, keyFile)
+		if err != nil {
+			return nil, err
+		}
+		config = &tls.Config{Certificates: []tls.Certificate{cert}, MinVersion: tls.VersionTLS12}
+	}
+	l, err := net.Listen("tcp", addr)
+	if err != nil || config == nil {
+		return l, err
+	}
+	return tls.NewListener(l, config), nil
+}
+
+// loopback reports whether a listen address only takes connections
+// from this host.
+func loopback(addr string) bool {
+	host, _, err := net.SplitHostPort(addr)
+	if err != nil {
+		return false
+	}
+	if host == "localhost" {
+		return true
+	}
+	ip := net.ParseIP(host)
+	return ip != nil && ip.IsLoopback()
+}
+
+func (a *Agent) serveConn(conn net.Conn) {
+	defer conn.Close()
+	lr := &io.LimitedReader{R: conn, N: maxHello}
+	r := bufio.NewReader(lr)
+	conn.SetReadDeadline(time.Now().Add(10 * time.Second))
+	var h hello
+	line, err := r.ReadBytes('\n')
+	if err == nil {
+		err = json.Unmarshal(line, &h)
+	}
+	if err == nil && subtle.ConstantTimeCompare([]byte(h.Token), []byte(token)) != 1 {
+		err = errors.New("wrong -agent.token")
+	}
+	if err != nil {
+		log.Printf("Agent refused server %s: %v", conn.RemoteAddr(), err)
+		return
+	}
+	conn.SetReadDeadline(time.Time{})
+	lr.N = math.MaxInt64
+	a.takeOver(conn)
+	log.Printf("Agent serving server %s", conn.RemoteAddr())
+
+	var wmu sync.Mutex
+	enc := json.NewEncoder(conn)
+	send := func(m message) error {
+		wmu.Lock()
+		defer wmu.Unlock()
+		conn.SetWriteDeadline(time.Now().Add(10 * time.Second))
+		return enc.Encode(m)
+	}
+	done := make(chan struct{})
+	defer close(done)
+	go func() {
+		ticker := a.clock.NewTicker(a.interval)
+		defer ticker.Stop()
+		for {
+			if err := send(message{State: a.state()}); err != nil {
+				conn.Close()
+				return
+			}
+			select {
+			case <-ticker.C():
+			case <-done:
+				return
+			}
+		}
+	}()
+
+	for {
+		line, err := r.ReadBytes('\n')
+		if err != nil {
+			log.Printf("Agent lost server %s: %v", conn.RemoteAddr(), err)
+			return
+		}
+		var req request
+		if err := json.Unmarshal(line, &req); err != nil {
+			log.Printf("Agent got a bad request: %v", err)
+			return
+		}
+		a.heardFrom()
+		reply := a.handle(req)
+		reply.ID = req.ID
+		if err := send(reply); err != nil {
+			return
+		}
+	}
+}
+
+// heardFrom notes the server is there, ending any failsafe.
+func (a *Agent) heardFrom() {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	a.lastHeard = a.clock.Now()
+	a.heard = true
+	a.released = false
+	if a.alone {
+		log.Printf("Agent back under the server's control")
+		a.alone = false
+	}
+}
+
+// handle runs a request on the channel it is for.
+func (a *Agent) handle(req request) message {
+	b := a.ble.Tank(req.Tank)
+	var err error
+	switch req.Op {
+	case "ping":
+	case "release":
+		a.mu.Lock()
+		a.released = true
+		a.mu.Unlock()
+	case "set":
+		err = b.SetChannel(req.Channel, req.Percent)
+	case "immediate":
+		err = b.SetChannelImmediate(req.Channel, req.Percent)
+	case "limit":
+		err = b.SetLimit(req.Name, req.Percent)
+	case "clear_limit":
+		b.ClearLimit(req.Name)
+	case "scale":
+		err = b.SetScale(req.Name, req.Percent)
+	case "clear_scale":
+		b.ClearScale(req.Name)
+	case "suspend":
+		b.SuspendEffects(req.Name)
+	case "resume":
+		b.ResumeEffects(req.Name)
+	case "burnin":
+		err = b.BurnIn(req.Name, req.Percent)
+	case "end_burnin":
+		b.EndBurnIn(req.Name)
+	case "name":
+		err = b.SetName(req.Fixture, req.Name)
+	case "fan":
+		err = b.SetFanOverride(req.Percent)
+	case "power":
+		return message{Watts: b.Power(req.Percents)}
+	case "program":
+		if req.Program == nil {
+			err = errors.New("no program")
+			break
+		}
+		var prog ble.Program
+		if prog, err = req.Program.program(); err == nil {
+			a.mu.Lock()
+			a.programs[req.Tank] = prog
+			a.mu.Unlock()
+			b.SetProgram(prog)
+		}
+	default:
+		err = fmt.Errorf("unknown op %q", req.Op)
+	}
+	if err != nil {
+		return message{Error: err.Error()}
+	}
+	return message{}
+}
+
+// state gathers the channel's state to report.
+func (a *Agent) state() *state {
+	s := &state{
+		Tanks:           make(map[string]tankState),
+		Degraded:        a.ble.Degraded(),
+		DisconnectCount: a.ble.DisconnectCount(),
+		WriteStats:      a.ble.WriteStats(),
+	}
+	for _, name := range append([]string{""}, a.ble.Tanks()...) {
+		b := a.ble.Tank(name)
+		t := tankState{
+			Channels:         b.Channels(),
+			Limits:           b.Limits(),
+			Scales:           b.Scales(),
+			Exposure:         b.Exposure(),
+			Availability:     b.Availability(),
+			EffectsSuspended: b.EffectsSuspended(),
+			Fixtures:         []string{},
+		}
+		for _, p := range b.Perhipherals() {
+			t.Fixtures = append(t.Fixtures, p.ID())
+		}
+		s.Tanks[name] = t
+	}
+	for _, p := range a.ble.Perhipherals() {
+		f := fixtureState{
+			ID:              p.ID(),
+			Name:            p.Name(),
+			Active:          p.Active(),
+			Temperature:     p.Temperature(),
+			FanRPM:          p.FanRPM(),
+			FanFailed:       p.FanFailed(),
+			Watts:           p.Watts(),
+			EnergyToday:     p.EnergyToday(),
+			EnergyPrevDay:   p.EnergyPrevDay(),
+			EnergyMonth:     p.EnergyMonth(),
+			EnergyPrevMonth: p.EnergyPrevMonth(),
+			ChannelCurrents: p.ChannelCurrents(),
+			ChannelFaults:   p.ChannelFaults(),
+		}
+		if h := p.History(); len(h) > 0 {
+			f.Sample = &h[len(h)-1]
+		}
+		s.Fixtures = append(s.Fixtures, f)
+	}
+	return s
+}
+
+// watch runs the tables the server last sent while it is away.
+func (a *Agent) watch() {
+	ticker := a.clock.NewTicker(a.interval)
+	for now := range ticker.C() {
+		a.mu.Lock()
+		if !a.heard || !a.released && now.Sub(a.lastHeard) < failsafe {
+			a.mu.Unlock()
+			continue
+		}
+		if !a.alone {
+			a.alone = true
+			if a.released {
+				log.Printf("Server released the fixtures, running the last table on its own")
+			} else {
+				alert.Raise(alert.Critical, "agent", "agent.server",
+					"agent hasn't heard from the server for %s, running the last table on its own", now.Sub(a.lastHeard))
+			}
+		}
+		programs := make(map[string]ble.Program)
+		for tank, prog := range a.programs {
+			programs[tank] = prog
+		}
+		a.mu.Unlock()
+		for tank, prog := range programs {
+			b := a.ble.Tank(tank)
+			for channel, percent := range percentsAt(prog, now) {
+				if err := b.SetChannel(channel, percent); err != nil {
+					log.Printf("Agent failsafe channel %d: %v", channel, err)
+				}
+			}
+		}
+	}
+}
+
+// percentsAt interpolates a program's percents at a time, as fixtures
+// running it do.
+func percentsAt(prog ble.Program, t time.Time) []float64 {
+	if len(prog.Points) == 0 {
+		return nil
+	}
+	if prog.Location != nil {
+		t = t.In(prog.Location)
+	} else {
+		t = t.Local()
+	}
+	minute := float64(t.Hour()*60+t.Minute()) + float64(t.Second())/60
+	const day = 24 * 60
+	// The last point before now and the next, wrapping over midnight
+	prev, next := len(prog.Points)-1, 0
+	for i, p := range prog.Points {
+		if float64(p.Minute) <= minute {
+			prev, next = i, (i+1)%len(prog.Points)
+		}
+	}
+	a, b := prog.Points[prev], prog.Points[next]
+	span := float64((b.Minute - a.Minute + day) % day)
+	f := 0.0
+	if span > 0 {
+		f = (minute - float64(a.Minute) + day)
+		for f >= day {
+			f -= day
+		}
+		f /= span
+	}
+	percents := make([]float64, len(a.Percents))
+	for i := range percents {
+		to := 0.0
+		if i < len(b.Percents) {
+			to = b.Percents[i]
+		}
+		percents[i] = a.Percents[i] + f*(to-a.Percents[i])
+	}
+	return percents
+}
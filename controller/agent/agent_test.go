@@ -0,0 +1,416 @@
+package agent
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"errors"
+	"io/ioutil"
+	"math"
+	"math/big"
+	"net"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/theatrus/ledbrick/controller/ble"
+	"github.com/theatrus/ledbrick/controller/clock"
+)
+
+// fakeChannel records what it is set to. Any call it doesn't implement
+// panics on the nil embedded interface.
+type fakeChannel struct {
+	ble.BLEChannel
+
+	mu       sync.Mutex
+	channels map[int]float64
+	limits   map[string]float64
+	program  *ble.Program
+}
+
+func newFakeChannel() *fakeChannel {
+	return &fakeChannel{channels: make(map[int]float64), limits: make(map[string]float64)}
+}
+
+func (f *fakeChannel) Tank(name string) ble.BLEChannel           { return f }
+func (f *fakeChannel) Tanks() []string                           { return nil }
+func (f *fakeChannel) Perhipherals() []ble.BLEPeripheral         { return nil }
+func (f *fakeChannel) Scales() map[string]float64                { return nil }
+func (f *fakeChannel) Exposure() map[int]time.Duration           { return nil }
+func (f *fakeChannel) Availability() map[string]ble.Availability { return nil }
+func (f *fakeChannel) EffectsSuspended() bool                    { return false }
+func (f *fakeChannel) Degraded() bool                            { return false }
+func (f *fakeChannel) DisconnectCount() int                      { return 3 }
+func (f *fakeChannel) WriteStats() map[string]ble.WriteStats     { return nil }
+
+func (f *fakeChannel) SetChannel(channel int, percent float64) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.channels[channel] = percent
+	return nil
+}
+
+func (f *fakeChannel) Channels() map[int]float64 {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	c := make(map[int]float64)
+	for channel, percent := range f.channels {
+		c[channel] = percent
+	}
+	return c
+}
+
+func (f *fakeChannel) SetLimit(name string, percent float64) error {
+	if percent > 100 {
+		return errors.New("limit over 100")
+	}
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.limits[name] = percent
+	return nil
+}
+
+func (f *fakeChannel) Limits() map[string]float64 {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	l := make(map[string]float64)
+	for name, percent := range f.limits {
+		l[name] = percent
+	}
+	return l
+}
+
+func (f *fakeChannel) SetProgram(prog ble.Program) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.program = &prog
+}
+
+// eventually waits for a condition, failing the test if it never holds.
+func eventually(t *testing.T, what string, cond func() bool) {
+	t.Helper()
+	for deadline := time.Now().Add(5 * time.Second); time.Now().Before(deadline); time.Sleep(10 * time.Millisecond) {
+		if cond() {
+			return
+		}
+	}
+	t.Fatalf("Timed out waiting for %s", what)
+}
+
+func TestRemote(t *testing.T) {
+	f := newFakeChannel()
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+	a := NewAgent(f)
+	a.interval = 20 * time.Millisecond
+	go a.Serve(l)
+
+	r := dial(l.Addr().String(), 20*time.Millisecond)
+	eventually(t, "the first report", func() bool { return r.DisconnectCount() == 3 })
+
+	if err := r.SetChannel(2, 40); err != nil {
+		t.Fatal(err)
+	}
+	if err := r.SetLimit("heat", 80); err != nil {
+		t.Fatal(err)
+	}
+	if err := r.SetLimit("bad", 120); err == nil || err.Error() != "limit over 100" {
+		t.Errorf("Expected the agent's error, got %v", err)
+	}
+	r.SetProgram(ble.Program{Location: time.UTC, Points: []ble.ProgramPoint{{Minute: 0, Percents: ble.Percents{10}}}})
+	if f.Channels()[2] != 40 {
+		t.Errorf("Expected channel 2 set on the agent, got %v", f.Channels())
+	}
+	f.mu.Lock()
+	if f.program == nil || f.program.Location != time.UTC {
+		t.Errorf("Expected the program with its zone, got %+v", f.program)
+	}
+	f.mu.Unlock()
+	eventually(t, "the channels reported", func() bool { return r.Channels()[2] == 40 && r.Limits()["heat"] == 80 })
+
+	// A restarted agent gets what the server set again
+	f.mu.Lock()
+	f.channels, f.limits = make(map[int]float64), make(map[string]float64)
+	f.mu.Unlock()
+	r.link.mu.Lock()
+	r.link.conn.Close()
+	r.link.mu.Unlock()
+	eventually(t, "the settings replayed", func() bool { return f.Channels()[2] == 40 && f.Limits()["heat"] == 80 })
+
+	r.Release()
+	a.mu.Lock()
+	released := a.released
+	a.mu.Unlock()
+	if !released {
+		t.Error("Expected the release to reach the agent")
+	}
+}
+
+func TestUnauthenticatedKeepsServer(t *testing.T) {
+	defer func(s string) { token = s }(token)
+	token = "secret"
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+	a := NewAgent(newFakeChannel())
+	a.interval = 20 * time.Millisecond
+	go a.Serve(l)
+	serving := func() net.Conn {
+		a.mu.Lock()
+		defer a.mu.Unlock()
+		return a.serving
+	}
+
+	r := dial(l.Addr().String(), 20*time.Millisecond)
+	eventually(t, "the server served", func() bool { return serving() != nil && r.DisconnectCount() == 3 })
+	server := serving()
+
+	// Neither a wrong token nor no hello at all kicks the server off
+	wrong, err := net.Dial("tcp", l.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer wrong.Close()
+	wrong.Write([]byte(`{"token": "guess"}` + "\n"))
+	silent, err := net.Dial("tcp", l.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer silent.Close()
+	time.Sleep(100 * time.Millisecond)
+	if serving() != server {
+		t.Error("Expected a connection without the token to leave the server served")
+	}
+	if err := r.SetChannel(0, 10); err != nil {
+		t.Errorf("Expected the server still connected, got %v", err)
+	}
+
+	// A server with the token takes over
+	next, err := net.Dial("tcp", l.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer next.Close()
+	next.Write([]byte(`{"token": "secret"}` + "\n"))
+	eventually(t, "the new server served", func() bool { return serving() != server })
+}
+
+func TestFailsafe(t *testing.T) {
+	f := newFakeChannel()
+	a := NewAgent(f)
+	fake := clock.NewFake(time.Date(2016, 1, 1, 6, 0, 0, 0, time.UTC))
+	a.clock = fake
+	a.handle(request{Op: "program", Program: &program{Zone: "UTC", Points: []ble.ProgramPoint{
+		{Minute: 0, Percents: ble.Percents{0}}, {Minute: 12 * 60, Percents: ble.Percents{100}},
+	}}})
+	a.heardFrom()
+	go a.watch()
+
+	// Nothing happens while the server is in touch
+	fake.Advance(failsafe / 2)
+	time.Sleep(50 * time.Millisecond)
+	if len(f.Channels()) != 0 {
+		t.Fatalf("Expected no failsafe yet, got %v", f.Channels())
+	}
+	eventually(t, "the failsafe", func() bool {
+		fake.Advance(a.interval)
+		_, ok := f.Channels()[0]
+		return ok
+	})
+	a.mu.Lock()
+	if !a.alone {
+		t.Errorf("Expected the agent to be running alone")
+	}
+	a.mu.Unlock()
+	a.heardFrom()
+	if a.alone {
+		t.Errorf("Expected the server back in control")
+	}
+}
+
+func TestReleaseRunsAlone(t *testing.T) {
+	f := newFakeChannel()
+	a := NewAgent(f)
+	fake := clock.NewFake(time.Date(2016, 1, 1, 6, 0, 0, 0, time.UTC))
+	a.clock = fake
+	a.handle(request{Op: "program", Program: &program{Zone: "UTC", Points: []ble.ProgramPoint{
+		{Minute: 0, Percents: ble.Percents{50}},
+	}}})
+	a.heardFrom()
+	a.handle(request{Op: "release"})
+	go a.watch()
+
+	// No waiting out the failsafe once released
+	eventually(t, "the table run alone", func() bool {
+		fake.Advance(a.interval)
+		return f.Channels()[0] == 50
+	})
+	a.heardFrom()
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	if a.alone || a.released {
+		t.Error("Expected a server heard from again to take the fixtures back")
+	}
+}
+
+func TestListenNeedsToken(t *testing.T) {
+	defer func(s string) { token = s }(token)
+	token = ""
+	for _, tt := range []struct {
+		addr string
+		ok   bool
+	}{
+		{"127.0.0.1:0", true},
+		{"[::1]:0", true},
+		{"localhost:0", true},
+		{":0", false},
+		{"0.0.0.0:0", false},
+		{"192.168.1.10:0", false},
+	} {
+		if got := loopback(tt.addr); got != tt.ok {
+			t.Errorf("loopback(%q) = %v, expected %v", tt.addr, got, tt.ok)
+		}
+	}
+	if err := NewAgent(newFakeChannel()).ListenAndServe(":0"); err == nil {
+		t.Error("Expected listening on every address without a token refused")
+	}
+	token = "secret"
+	if err := NewAgent(newFakeChannel()).ListenAndServe(":0"); err == nil {
+		t.Error("Expected listening on every address without TLS refused")
+	}
+	if _, err := (&link{addr: "192.168.1.10:1"}).connect(); err == nil {
+		t.Error("Expected reaching an agent elsewhere without TLS refused")
+	}
+}
+
+// writeCert writes a self-signed certificate for 127.0.0.1 and its key
+// to dir.
+func writeCert(t *testing.T, dir string) (string, string) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tmpl := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{CommonName: "agent"},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		IPAddresses:  []net.IP{net.ParseIP("127.0.0.1")},
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, tmpl, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	keyDer, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cert, keyFile := filepath.Join(dir, "agent.pem"), filepath.Join(dir, "agent.key")
+	ioutil.WriteFile(cert, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}), 0600)
+	ioutil.WriteFile(keyFile, pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDer}), 0600)
+	return cert, keyFile
+}
+
+func TestTLS(t *testing.T) {
+	defer func(s, c, k, ca string) { token, certFile, keyFile, caFile = s, c, k, ca }(token, certFile, keyFile, caFile)
+	dir, err := ioutil.TempDir("", "agent")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	token = "secret"
+	certFile, keyFile = writeCert(t, dir)
+	caFile = certFile
+
+	l, err := listen("127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+	f := newFakeChannel()
+	a := NewAgent(f)
+	a.interval = 20 * time.Millisecond
+	go a.Serve(l)
+
+	r := dial(l.Addr().String(), 20*time.Millisecond)
+	eventually(t, "the first report", func() bool { return r.DisconnectCount() == 3 })
+	if err := r.SetChannel(2, 40); err != nil {
+		t.Fatal(err)
+	}
+	if f.Channels()[2] != 40 {
+		t.Errorf("Expected channel 2 set over TLS, got %v", f.Channels())
+	}
+
+	// Plain TCP doesn't get as far as the token
+	plain, err := net.Dial("tcp", l.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer plain.Close()
+	plain.Write([]byte(`{"token": "secret"}` + "\n"))
+	plain.SetReadDeadline(time.Now().Add(time.Second))
+	if b, err := bufio.NewReader(plain).ReadBytes('\n'); err == nil {
+		t.Errorf("Expected a plain connection refused, got %q", b)
+	}
+}
+
+func TestLongHello(t *testing.T) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+	a := NewAgent(newFakeChannel())
+	go a.Serve(l)
+
+	conn, err := net.Dial("tcp", l.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	go conn.Write(bytes.Repeat([]byte("x"), 2*maxHello))
+	conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+	_, err = conn.Read(make([]byte, 1))
+	if ne, ok := err.(net.Error); err == nil || ok && ne.Timeout() {
+		t.Errorf("Expected a hello past %d bytes to be hung up on, got %v", maxHello, err)
+	}
+}
+
+func TestPercentsAt(t *testing.T) {
+	prog := ble.Program{Location: time.UTC, Points: []ble.ProgramPoint{
+		{Minute: 8 * 60, Percents: ble.Percents{0, 20}},
+		{Minute: 12 * 60, Percents: ble.Percents{100, 20}},
+		{Minute: 22 * 60, Percents: ble.Percents{0, 0}},
+	}}
+	for _, test := range []struct {
+		hour float64
+		want []float64
+	}{
+		{10, []float64{50, 20}},
+		{12, []float64{100, 20}},
+		{17, []float64{50, 10}},
+		// Overnight from the last point to the first
+		{3, []float64{0, 10}},
+	} {
+		at := time.Date(2016, 1, 1, 0, 0, 0, 0, time.UTC).Add(time.Duration(test.hour * float64(time.Hour)))
+		got := percentsAt(prog, at)
+		for i := range test.want {
+			if math.Abs(got[i]-test.want[i]) > 0.01 {
+				t.Errorf("At %v expected %v, got %v", test.hour, test.want, got)
+				break
+			}
+		}
+	}
+}
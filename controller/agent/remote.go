@@ -0,0 +1,500 @@
+package agent
+
+import (
+	"bufio"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"net"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/theatrus/ledbrick/controller/ble"
+)
+
+// callTimeout is how long a call waits for the agent's reply.
+const callTimeout = 5 * time.Second
+
+var errTimeout = errors.New("agent didn't reply in time")
+
+// link is the server's connection to an agent, shared by the channels
+// for each tank. What the server has set is kept, and sent again after
+// reconnecting, so a restarted agent picks up where it was.
+type link struct {
+	addr string
+	// interval is how often the agent is pinged
+	interval time.Duration
+
+	mu      sync.Mutex
+	conn    net.Conn
+	enc     *json.Encoder
+	nextID  int
+	pending map[int]chan message
+	state   *state
+	// fixtures are the fixtures the agent has reported, keeping their
+	// history here
+	fixtures map[string]*fixture
+	// tanks are the settings made for each tank
+	tanks map[string]*settings
+	// released stops the pings once the fixtures are left to the agent
+	released bool
+}
+
+// settings are what the server has set on one tank.
+type settings struct {
+	channels  map[int]float64
+	limits    map[string]float64
+	scales    map[string]float64
+	suspended map[string]bool
+	program   *ble.Program
+}
+
+// Remote is a BLEChannel driving the fixtures of an agent elsewhere on
+// the network, for a server running the schedule without a radio.
+type Remote struct {
+	link *link
+	tank string
+}
+
+// Dial returns a channel driving the agent at addr, connecting in the
+// background and reconnecting whenever the link drops.
+func Dial(addr string) *Remote {
+	return dial(addr, interval)
+}
+
+func dial(addr string, interval time.Duration) *Remote {
+	l := &link{addr: addr,
+		interval: interval,
+		pending:  make(map[int]chan message),
+		fixtures: make(map[string]*fixture),
+		tanks:    make(map[string]*settings),
+	}
+	go l.run()
+	return &Remote{link: l}
+}
+
+// run keeps the link up, and checks in with the agent every
+// -agent.interval so it knows the server is there.
+func (l *link) run() {
+	for {
+		conn, err := l.connect()
+		if err != nil {
+			log.Printf("Agent at %s not reached: %v", l.addr, err)
+			time.Sleep(5 * time.Second)
+			continue
+		}
+		log.Printf("Connected to agent at %s", l.addr)
+		done := make(chan struct{})
+		go func() {
+			ticker := time.NewTicker(l.interval)
+			defer ticker.Stop()
+			for {
+				select {
+				case <-ticker.C:
+					l.mu.Lock()
+					released := l.released
+					l.mu.Unlock()
+					if !released {
+						l.call(request{Op: "ping"})
+					}
+				case <-done:
+					return
+				}
+			}
+		}()
+		err = l.serve(conn)
+		close(done)
+		log.Printf("Agent at %s lost: %v", l.addr, err)
+		time.Sleep(time.Second)
+	}
+}
+
+// connect dials the agent, over TLS checked against -agent.ca if it's
+// set, as it must be for an agent on anything but loopback.
+func (l *link) connect() (net.Conn, error) {
+	dialer := &net.Dialer{Timeout: 10 * time.Second}
+	if caFile == "" {
+		if !loopback(l.addr) {
+			return nil, fmt.Errorf("reaching an agent on %s needs -agent.ca", l.addr)
+		}
+		return dialer.Dial("tcp", l.addr)
+	}
+	pem, err := ioutil.ReadFile(caFile)
+	if err != nil {
+		return nil, err
+	}
+	roots := x509.NewCertPool()
+	if !roots.AppendCertsFromPEM(pem) {
+		return nil, fmt.Errorf("no certificates in %s", caFile)
+	}
+	host, _, err := net.SplitHostPort(l.addr)
+	if err != nil {
+		return nil, err
+	}
+	return tls.DialWithDialer(dialer, "tcp", l.addr, &tls.Config{RootCAs: roots, ServerName: host, MinVersion: tls.VersionTLS12})
+}
+
+// serve says hello, replays the settings and reads the agent's messages
+// until the connection fails.
+func (l *link) serve(conn net.Conn) error {
+	defer conn.Close()
+	enc := json.NewEncoder(conn)
+	if err := enc.Encode(hello{Token: token}); err != nil {
+		return err
+	}
+	l.mu.Lock()
+	l.conn, l.enc = conn, enc
+	replay := l.replay()
+	l.mu.Unlock()
+	defer func() {
+		l.mu.Lock()
+		l.conn, l.enc = nil, nil
+		for id, c := range l.pending {
+			close(c)
+			delete(l.pending, id)
+		}
+		l.mu.Unlock()
+	}()
+	go func() {
+		for _, req := range replay {
+			if err := l.call(req); err != nil {
+				log.Printf("Agent refused %s replayed: %v", req.Op, err)
+			}
+		}
+	}()
+
+	r := bufio.NewReader(conn)
+	for {
+		conn.SetReadDeadline(time.Now().Add(10*l.interval + 10*time.Second))
+		line, err := r.ReadBytes('\n')
+		if err != nil {
+			return err
+		}
+		var m message
+		if err := json.Unmarshal(line, &m); err != nil {
+			return err
+		}
+		l.mu.Lock()
+		if m.State != nil {
+			l.update(m.State)
+		} else if c, ok := l.pending[m.ID]; ok {
+			c <- m
+			delete(l.pending, m.ID)
+		}
+		l.mu.Unlock()
+	}
+}
+
+// replay returns the calls putting back every setting, programs first.
+// The caller must hold the lock.
+func (l *link) replay() []request {
+	var reqs []request
+	for tank, s := range l.tanks {
+		if s.program != nil {
+			reqs = append(reqs, request{Tank: tank, Op: "program", Program: toWire(*s.program)})
+		}
+		for name, percent := range s.limits {
+			reqs = append(reqs, request{Tank: tank, Op: "limit", Name: name, Percent: percent})
+		}
+		for name, factor := range s.scales {
+			reqs = append(reqs, request{Tank: tank, Op: "scale", Name: name, Percent: factor})
+		}
+		for name := range s.suspended {
+			reqs = append(reqs, request{Tank: tank, Op: "suspend", Name: name})
+		}
+		for channel, percent := range s.channels {
+			reqs = append(reqs, request{Tank: tank, Op: "set", Channel: channel, Percent: percent})
+		}
+	}
+	return reqs
+}
+
+// update takes in a state report. The caller must hold the lock.
+func (l *link) update(s *state) {
+	l.state = s
+	seen := make(map[string]bool)
+	for _, fs := range s.Fixtures {
+		seen[fs.ID] = true
+		f, ok := l.fixtures[fs.ID]
+		if !ok {
+			f = &fixture{}
+			l.fixtures[fs.ID] = f
+		}
+		f.fixtureState = fs
+		if fs.Sample != nil && (len(f.history) == 0 || fs.Sample.At.After(f.history[len(f.history)-1].At)) {
+			f.history = append(f.history, *fs.Sample)
+			for len(f.history) > 0 && fs.Sample.At.Sub(f.history[0].At) > 25*time.Hour {
+				f.history = f.history[1:]
+			}
+		}
+	}
+	for id, f := range l.fixtures {
+		f.fixtureState.Active = f.fixtureState.Active && seen[id]
+	}
+}
+
+// call sends a request and waits for the reply. Nothing is sent while
+// the agent is unreachable, as the settings are replayed on reconnecting.
+func (l *link) call(req request) error {
+	_, err := l.exchange(req)
+	return err
+}
+
+// exchange sends a request and returns the reply, an empty one if the
+// agent is unreachable.
+func (l *link) exchange(req request) (message, error) {
+	l.mu.Lock()
+	if l.enc == nil {
+		l.mu.Unlock()
+		return message{}, nil
+	}
+	l.nextID++
+	req.ID = l.nextID
+	c := make(chan message, 1)
+	l.pending[req.ID] = c
+	l.conn.SetWriteDeadline(time.Now().Add(callTimeout))
+	err := l.enc.Encode(req)
+	l.mu.Unlock()
+	if err != nil {
+		return message{}, err
+	}
+	select {
+	case m := <-c:
+		if m.Error != "" {
+			return m, errors.New(m.Error)
+		}
+		return m, nil
+	case <-time.After(callTimeout):
+		l.mu.Lock()
+		delete(l.pending, req.ID)
+		l.mu.Unlock()
+		return message{}, errTimeout
+	}
+}
+
+// settings returns a tank's settings. The caller must hold the lock.
+func (l *link) settings(tank string) *settings {
+	s, ok := l.tanks[tank]
+	if !ok {
+		s = &settings{channels: make(map[int]float64),
+			limits:    make(map[string]float64),
+			scales:    make(map[string]float64),
+			suspended: make(map[string]bool),
+		}
+		l.tanks[tank] = s
+	}
+	return s
+}
+
+// set sends a setting, and records it unless the agent refused it.
+func (r *Remote) set(req request, record func(s *settings)) error {
+	req.Tank = r.tank
+	if err := r.link.call(req); err != nil {
+		return err
+	}
+	r.link.mu.Lock()
+	record(r.link.settings(r.tank))
+	r.link.mu.Unlock()
+	return nil
+}
+
+// tankState returns the tank's last reported state.
+func (r *Remote) tankState() tankState {
+	r.link.mu.Lock()
+	defer r.link.mu.Unlock()
+	if r.link.state == nil {
+		return tankState{}
+	}
+	return r.link.state.Tanks[r.tank]
+}
+
+func (r *Remote) Perhipherals() []ble.BLEPeripheral {
+	ids := r.tankState().Fixtures
+	r.link.mu.Lock()
+	defer r.link.mu.Unlock()
+	p := make([]ble.BLEPeripheral, 0, len(ids))
+	for _, id := range ids {
+		if f, ok := r.link.fixtures[id]; ok {
+			c := *f
+			c.history = append([]ble.Sample(nil), f.history...)
+			p = append(p, &c)
+		}
+	}
+	return p
+}
+
+func (r *Remote) SetChannel(channel int, percent float64) error {
+	return r.set(request{Op: "set", Channel: channel, Percent: percent},
+		func(s *settings) { s.channels[channel] = percent })
+}
+
+func (r *Remote) SetChannelImmediate(channel int, percent float64) error {
+	return r.set(request{Op: "immediate", Channel: channel, Percent: percent},
+		func(s *settings) { s.channels[channel] = percent })
+}
+
+func (r *Remote) SetLimit(name string, percent float64) error {
+	return r.set(request{Op: "limit", Name: name, Percent: percent},
+		func(s *settings) { s.limits[name] = percent })
+}
+
+func (r *Remote) ClearLimit(name string) {
+	r.set(request{Op: "clear_limit", Name: name}, func(s *settings) { delete(s.limits, name) })
+}
+
+func (r *Remote) Limits() map[string]float64 { return r.tankState().Limits }
+
+func (r *Remote) SetScale(name string, factor float64) error {
+	return r.set(request{Op: "scale", Name: name, Percent: factor},
+		func(s *settings) { s.scales[name] = factor })
+}
+
+func (r *Remote) ClearScale(name string) {
+	r.set(request{Op: "clear_scale", Name: name}, func(s *settings) { delete(s.scales, name) })
+}
+
+func (r *Remote) Scales() map[string]float64 { return r.tankState().Scales }
+
+func (r *Remote) SuspendEffects(name string) {
+	r.set(request{Op: "suspend", Name: name}, func(s *settings) { s.suspended[name] = true })
+}
+
+func (r *Remote) ResumeEffects(name string) {
+	r.set(request{Op: "resume", Name: name}, func(s *settings) { delete(s.suspended, name) })
+}
+
+func (r *Remote) EffectsSuspended() bool { return r.tankState().EffectsSuspended }
+
+func (r *Remote) Degraded() bool {
+	r.link.mu.Lock()
+	defer r.link.mu.Unlock()
+	return r.link.state != nil && r.link.state.Degraded
+}
+
+func (r *Remote) Exposure() map[int]time.Duration { return r.tankState().Exposure }
+
+func (r *Remote) Channels() map[int]float64 {
+	if c := r.tankState().Channels; c != nil {
+		return c
+	}
+	return make(map[int]float64)
+}
+
+func (r *Remote) DisconnectCount() int {
+	r.link.mu.Lock()
+	defer r.link.mu.Unlock()
+	if r.link.state == nil {
+		return 0
+	}
+	return r.link.state.DisconnectCount
+}
+
+func (r *Remote) Availability() map[string]ble.Availability {
+	if a := r.tankState().Availability; a != nil {
+		return a
+	}
+	return make(map[string]ble.Availability)
+}
+
+func (r *Remote) WriteStats() map[string]ble.WriteStats {
+	r.link.mu.Lock()
+	defer r.link.mu.Unlock()
+	if r.link.state == nil {
+		return make(map[string]ble.WriteStats)
+	}
+	return r.link.state.WriteStats
+}
+
+// Power asks the agent, which has the fixtures' wattages, giving 0
+// while it can't be reached.
+func (r *Remote) Power(percents map[int]float64) float64 {
+	m, err := r.link.exchange(request{Tank: r.tank, Op: "power", Percents: percents})
+	if err != nil {
+		return 0
+	}
+	return m.Watts
+}
+
+func (r *Remote) Tanks() []string {
+	r.link.mu.Lock()
+	defer r.link.mu.Unlock()
+	var names []string
+	if r.link.state != nil {
+		for name := range r.link.state.Tanks {
+			if name != "" {
+				names = append(names, name)
+			}
+		}
+	}
+	sort.Strings(names)
+	return names
+}
+
+func (r *Remote) Tank(name string) ble.BLEChannel {
+	return &Remote{link: r.link, tank: name}
+}
+
+func (r *Remote) SetProgram(prog ble.Program) {
+	err := r.set(request{Op: "program", Program: toWire(prog)}, func(s *settings) { s.program = &prog })
+	if err != nil {
+		log.Printf("Agent program not set: %v", err)
+	}
+}
+
+// Release leaves the fixtures to the agent, which runs the last table
+// on its own straight away rather than waiting out -agent.failsafe.
+// The agent hands them back to the fixtures' own schedules only when
+// it stops itself.
+func (r *Remote) Release() {
+	r.link.mu.Lock()
+	r.link.released = true
+	r.link.mu.Unlock()
+	if err := r.link.call(request{Op: "release"}); err != nil {
+		log.Printf("Agent not told of the release: %v", err)
+	}
+}
+
+func (r *Remote) BurnIn(id string, percent float64) error {
+	return r.link.call(request{Tank: r.tank, Op: "burnin", Name: id, Percent: percent})
+}
+
+func (r *Remote) EndBurnIn(id string) {
+	r.link.call(request{Tank: r.tank, Op: "end_burnin", Name: id})
+}
+
+// SetFanOverride overrides the fans of the agent's fixtures.
+func (r *Remote) SetFanOverride(percent float64) error {
+	return r.link.call(request{Tank: r.tank, Op: "fan", Percent: percent})
+}
+
+// SetName names a fixture on the agent, which keeps the names.
+func (r *Remote) SetName(id, name string) error {
+	return r.link.call(request{Tank: r.tank, Op: "name", Fixture: id, Name: name})
+}
+
+// fixture is a fixture the agent reports, with the history built up
+// from its samples.
+type fixture struct {
+	fixtureState
+	history []ble.Sample
+}
+
+func (f *fixture) ID() string                    { return f.fixtureState.ID }
+func (f *fixture) Name() string                  { return f.fixtureState.Name }
+func (f *fixture) Active() bool                  { return f.fixtureState.Active }
+func (f *fixture) Temperature() int              { return f.fixtureState.Temperature }
+func (f *fixture) FanRPM() int                   { return f.fixtureState.FanRPM }
+func (f *fixture) FanFailed() bool               { return f.fixtureState.FanFailed }
+func (f *fixture) History() []ble.Sample         { return f.history }
+func (f *fixture) Watts() float64                { return f.fixtureState.Watts }
+func (f *fixture) EnergyToday() float64          { return f.fixtureState.EnergyToday }
+func (f *fixture) EnergyPrevDay() float64        { return f.fixtureState.EnergyPrevDay }
+func (f *fixture) EnergyMonth() float64          { return f.fixtureState.EnergyMonth }
+func (f *fixture) EnergyPrevMonth() float64      { return f.fixtureState.EnergyPrevMonth }
+func (f *fixture) ChannelCurrents() []int        { return f.fixtureState.ChannelCurrents }
+func (f *fixture) ChannelFaults() map[int]string { return f.fixtureState.ChannelFaults }
@@ -0,0 +1,222 @@
+package alert
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"sync"
+	"time"
+)
+
+type Severity int
+
+const (
+	Info Severity = iota
+	Warning
+	Critical
+)
+
+func (s Severity) String() string {
+	switch s {
+	case Info:
+		return "info"
+	case Warning:
+		return "warning"
+	case Critical:
+		return "critical"
+	}
+	return fmt.Sprintf("severity(%d)", int(s))
+}
+
+// Alert is a single notification about the state of the system.
+type Alert struct {
+	ID       int       `json:"id"`
+	Severity Severity  `json:"severity"`
+	Source   string    `json:"source"`
+	Kind     string    `json:"kind"`
+	Message  string    `json:"message"`
+	At       time.Time `json:"at"`
+	Acked    bool      `json:"acked"`
+	// AckedBy names who acknowledged the alert, if known
+	AckedBy string `json:"acked_by,omitempty"`
+}
+
+// Notifier delivers alerts somewhere a human will see them.
+type Notifier interface {
+	Notify(a Alert) error
+}
+
+type logNotifier struct{}
+
+func (logNotifier) Notify(a Alert) error {
+	log.Printf("ALERT [%s] %s %s: %s", a.Severity, a.Source, a.Kind, a.Message)
+	return nil
+}
+
+// How many delivered alerts to remember for Recent
+const recentSize = 200
+
+// How many alerts a notifier may have waiting before more are dropped
+const queueSize = 32
+
+var notifiers = []Notifier{logNotifier{}}
+var escalations []Notifier
+var recent []*Alert
+var nextID = 1
+var lock sync.Mutex
+var now = time.Now
+
+// queue delivers alerts to a notifier in order from a goroutine of its
+// own, so a slow notifier holds up neither Raise, which may be called
+// with the channel lock held, nor the other notifiers.
+type queue struct {
+	n      Notifier
+	alerts chan Alert
+}
+
+func newQueue(n Notifier) *queue {
+	q := &queue{n: n, alerts: make(chan Alert, queueSize)}
+	go func() {
+		for a := range q.alerts {
+			if err := q.n.Notify(a); err != nil {
+				log.Printf("Failed to deliver alert: %v", err)
+			}
+		}
+	}()
+	return q
+}
+
+func (q *queue) Notify(a Alert) error {
+	select {
+	case q.alerts <- a:
+		return nil
+	default:
+		return fmt.Errorf("%T queue full, dropped alert %d", q.n, a.ID)
+	}
+}
+
+// Register adds a notifier which will receive every delivered alert,
+// through a queue of its own.
+func Register(n Notifier) {
+	lock.Lock()
+	defer lock.Unlock()
+	notifiers = append(notifiers, newQueue(n))
+}
+
+// RegisterSync adds a notifier which receives every delivered alert
+// before Raise returns. It must never block, as logging and handing
+// off to a queue don't.
+func RegisterSync(n Notifier) {
+	lock.Lock()
+	defer lock.Unlock()
+	notifiers = append(notifiers, n)
+}
+
+// RegisterEscalation adds a notifier which only receives critical
+// alerts that have gone unacknowledged for the escalation period.
+func RegisterEscalation(n Notifier) {
+	lock.Lock()
+	defer lock.Unlock()
+	escalations = append(escalations, newQueue(n))
+}
+
+// SetClock replaces the clock alerts are stamped with, and so the
+// clock deduplication and flapping are judged by. Replays of recorded
+// telemetry set it to the time of each sample.
+func SetClock(clock func() time.Time) {
+	lock.Lock()
+	defer lock.Unlock()
+	now = clock
+}
+
+// Raise sends an alert to all registered notifiers, subject to the
+// deduplication and flapping policy.
+func Raise(severity Severity, source, kind, format string, args ...interface{}) {
+	a := Alert{Severity: severity,
+		Source:  source,
+		Kind:    kind,
+		Message: fmt.Sprintf(format, args...),
+	}
+
+	lock.Lock()
+	a.At = now()
+	deliver, ok := policy.admit(a)
+	if !ok {
+		lock.Unlock()
+		return
+	}
+	deliver.ID = nextID
+	nextID++
+	stored := deliver
+	recent = append(recent, &stored)
+	if len(recent) > recentSize {
+		recent = recent[len(recent)-recentSize:]
+	}
+	ns := append([]Notifier(nil), notifiers...)
+	lock.Unlock()
+
+	if deliver.Severity == Critical && escalateAfter > 0 {
+		time.AfterFunc(escalateAfter, func() { escalate(deliver.ID) })
+	}
+	send(ns, deliver)
+}
+
+func send(ns []Notifier, a Alert) {
+	for _, n := range ns {
+		if err := n.Notify(a); err != nil {
+			log.Printf("Failed to deliver alert: %v", err)
+		}
+	}
+}
+
+// escalate sends a critical alert to the escalation notifiers if it
+// still hasn't been acknowledged.
+func escalate(id int) {
+	lock.Lock()
+	var a *Alert
+	for _, r := range recent {
+		if r.ID == id {
+			a = r
+		}
+	}
+	if a == nil || a.Acked {
+		lock.Unlock()
+		return
+	}
+	e := *a
+	ns := append([]Notifier(nil), escalations...)
+	lock.Unlock()
+
+	e.Message = fmt.Sprintf("unacknowledged for %s: %s", escalateAfter, e.Message)
+	send(ns, e)
+}
+
+// Recent returns the most recently delivered alerts, oldest first.
+func Recent() []Alert {
+	lock.Lock()
+	defer lock.Unlock()
+	alerts := make([]Alert, 0, len(recent))
+	for _, a := range recent {
+		alerts = append(alerts, *a)
+	}
+	return alerts
+}
+
+// Ack marks a delivered alert as acknowledged, stopping escalation.
+func Ack(id int) error {
+	return AckBy(id, "")
+}
+
+// AckBy acknowledges an alert on behalf of someone, recording who.
+func AckBy(id int, by string) error {
+	lock.Lock()
+	defer lock.Unlock()
+	for _, a := range recent {
+		if a.ID == id {
+			a.Acked = true
+			a.AckedBy = by
+			return nil
+		}
+	}
+	return errors.New("no such alert")
+}
@@ -0,0 +1,96 @@
+package alert
+
+import (
+	"flag"
+	"fmt"
+	"time"
+)
+
+var dedupeWindow time.Duration
+var flapWindow time.Duration
+var flapCount int
+var escalateAfter time.Duration
+
+func init() {
+	flag.DurationVar(&dedupeWindow, "alert.dedupe", 15*time.Minute,
+		"Suppress repeats of an alert with the same source, kind and severity within this window")
+	flag.DurationVar(&flapWindow, "alert.flap.window", 10*time.Minute,
+		"Window over which repeated alerts from one source are counted as flapping")
+	flag.IntVar(&flapCount, "alert.flap.count", 6,
+		"Number of alerts of one kind from one source within the flap window which counts as flapping")
+	flag.DurationVar(&escalateAfter, "alert.escalate", 30*time.Minute,
+		"Send unacknowledged critical alerts to escalation notifiers after this long, 0 to disable")
+}
+
+type flapState struct {
+	times      []time.Time
+	severities []Severity
+	flapping   bool
+}
+
+// alertPolicy decides which raised alerts are delivered.
+type alertPolicy struct {
+	delivered map[string]time.Time
+	flaps     map[string]*flapState
+}
+
+var policy = &alertPolicy{
+	delivered: make(map[string]time.Time),
+	flaps:     make(map[string]*flapState),
+}
+
+// admit returns the alert to deliver, which may be a summary in place of
+// the raised alert, and whether anything should be delivered at all.
+func (p *alertPolicy) admit(a Alert) (Alert, bool) {
+	// Flapping is counted per source and kind regardless of severity, so
+	// an offline/online storm groups into a single notice
+	flapKey := a.Source + "|" + a.Kind
+	f, ok := p.flaps[flapKey]
+	if !ok {
+		f = &flapState{}
+		p.flaps[flapKey] = f
+	}
+	cutoff := a.At.Add(-flapWindow)
+	times, severities := f.times[:0], f.severities[:0]
+	worst := a.Severity
+	for i, t := range f.times {
+		if t.After(cutoff) {
+			times = append(times, t)
+			severities = append(severities, f.severities[i])
+			if f.severities[i] > worst {
+				worst = f.severities[i]
+			}
+		}
+	}
+	f.times, f.severities = append(times, a.At), append(severities, a.Severity)
+
+	if flapCount > 0 && len(f.times) >= flapCount {
+		if !f.flapping {
+			// The summary is as severe as the worst alert it stands for,
+			// so a flapping critical alert can still be escalated
+			f.flapping = true
+			summary := Alert{Severity: worst,
+				Source:  a.Source,
+				Kind:    a.Kind,
+				Message: fmt.Sprintf("flapping, %d alerts in %s, suppressing until quiet: %s", len(f.times), flapWindow, a.Message),
+				At:      a.At,
+			}
+			p.delivered[flapKey+"|"+worst.String()] = a.At
+			return summary, true
+		}
+		// Critical alerts are never suppressed as flapping, only
+		// deduplicated, so they go on being escalated
+		if a.Severity != Critical {
+			return a, false
+		}
+	} else {
+		f.flapping = false
+	}
+
+	key := flapKey + "|" + a.Severity.String()
+	if last, ok := p.delivered[key]; ok && a.At.Sub(last) < dedupeWindow {
+		return a, false
+	}
+	p.delivered[key] = a.At
+	return a, true
+}
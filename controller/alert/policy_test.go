@@ -0,0 +1,158 @@
+package alert
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+func newPolicy() *alertPolicy {
+	return &alertPolicy{
+		delivered: make(map[string]time.Time),
+		flaps:     make(map[string]*flapState),
+	}
+}
+
+func TestDedupe(t *testing.T) {
+	p := newPolicy()
+	now := time.Now()
+	a := Alert{Severity: Warning, Source: "a", Kind: "fan.low", At: now}
+	if _, ok := p.admit(a); !ok {
+		t.Error("First alert was suppressed")
+	}
+	a.At = now.Add(time.Minute)
+	if _, ok := p.admit(a); ok {
+		t.Error("Duplicate alert was delivered")
+	}
+	a.Severity = Critical
+	if _, ok := p.admit(a); !ok {
+		t.Error("Alert with a new severity was suppressed")
+	}
+	a.At = now.Add(dedupeWindow + time.Minute)
+	a.Severity = Warning
+	if _, ok := p.admit(a); !ok {
+		t.Error("Alert after the dedupe window was suppressed")
+	}
+}
+
+func TestFlapping(t *testing.T) {
+	p := newPolicy()
+	now := time.Now()
+	delivered := 0
+	flapped := 0
+	for i := 0; i < flapCount*2; i++ {
+		severity := Warning
+		if i%2 == 1 {
+			severity = Info
+		}
+		a := Alert{Severity: severity, Source: "a", Kind: "fixture.offline",
+			At: now.Add(time.Duration(i) * time.Second)}
+		d, ok := p.admit(a)
+		if !ok {
+			continue
+		}
+		delivered++
+		if d.Message != a.Message {
+			flapped++
+		}
+	}
+	if flapped != 1 {
+		t.Errorf("Expected one flapping notice, got %d", flapped)
+	}
+	// Two before dedupe kicks in, then the flap summary
+	if delivered != 3 {
+		t.Errorf("Expected 3 delivered alerts, got %d", delivered)
+	}
+}
+
+func TestAck(t *testing.T) {
+	Raise(Critical, "test", "ack", "something broke")
+	alerts := Recent()
+	id := alerts[len(alerts)-1].ID
+	if err := Ack(id); err != nil {
+		t.Fatal(err)
+	}
+	alerts = Recent()
+	if !alerts[len(alerts)-1].Acked {
+		t.Error("Alert was not acknowledged")
+	}
+	if Ack(-1) == nil {
+		t.Error("Expected error acking an unknown alert")
+	}
+	if err := AckBy(id, "tablet"); err != nil {
+		t.Fatal(err)
+	}
+	if by := Recent()[len(alerts)-1].AckedBy; by != "tablet" {
+		t.Errorf("Expected the ack attributed to tablet, got %q", by)
+	}
+}
+
+// blockedNotifier holds each alert until released.
+type blockedNotifier struct {
+	release chan struct{}
+	got     chan Alert
+}
+
+func (b blockedNotifier) Notify(a Alert) error {
+	<-b.release
+	b.got <- a
+	return nil
+}
+
+func TestQueue(t *testing.T) {
+	b := blockedNotifier{release: make(chan struct{}), got: make(chan Alert, queueSize+1)}
+	q := newQueue(b)
+	// The queue takes alerts without waiting on the notifier, until full
+	sent := 0
+	for ; sent < queueSize; sent++ {
+		if err := q.Notify(Alert{ID: sent}); err != nil {
+			t.Fatalf("Alert %d not queued: %v", sent, err)
+		}
+	}
+	for ; q.Notify(Alert{ID: sent}) == nil; sent++ {
+		if sent > queueSize+1 {
+			t.Fatal("Expected a full queue to drop alerts")
+		}
+	}
+	close(b.release)
+	for i := 0; i < sent; i++ {
+		if a := <-b.got; a.ID != i {
+			t.Fatalf("Expected alert %d delivered in order, got %d", i, a.ID)
+		}
+	}
+}
+
+func TestCriticalFlapping(t *testing.T) {
+	p := newPolicy()
+	now := time.Now()
+	var delivered []Alert
+	raise := func(i int, severity Severity) {
+		a := Alert{Severity: severity, Source: "a", Kind: "temperature.high",
+			At: now.Add(time.Duration(i) * time.Minute)}
+		if d, ok := p.admit(a); ok {
+			delivered = append(delivered, d)
+		}
+	}
+	// Overtemperature oscillating around the threshold
+	for i := 0; i < flapCount; i++ {
+		severity := Critical
+		if i%2 == 1 {
+			severity = Warning
+		}
+		raise(i, severity)
+	}
+	last := delivered[len(delivered)-1]
+	if !strings.HasPrefix(last.Message, "flapping") || last.Severity != Critical {
+		t.Fatalf("Expected a critical flapping notice, got %+v", last)
+	}
+
+	// Still flapping, criticals are deduplicated against the summary but
+	// delivered again once the dedupe window is over
+	delivered = nil
+	for i := flapCount; i < flapCount+int(dedupeWindow/time.Minute)+2; i++ {
+		raise(i, Critical)
+	}
+	if len(delivered) != 1 || delivered[0].Severity != Critical {
+		t.Errorf("Expected one critical alert delivered while flapping, got %+v", delivered)
+	}
+}
@@ -0,0 +1,156 @@
+package ambient
+
+import (
+	"errors"
+	"flag"
+	"math"
+	"os"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/theatrus/ledbrick/controller/alert"
+	"github.com/theatrus/ledbrick/controller/ble"
+)
+
+var i2cDevice string
+var i2cAddress int
+var gain float64
+var reference float64
+var minPercent float64
+var maxPercent float64
+var pollInterval time.Duration
+
+func init() {
+	flag.StringVar(&i2cDevice, "ambient.i2c", "",
+		"I2C bus device with a BH1750 ambient light sensor, e.g. /dev/i2c-1, disabled if empty")
+	flag.IntVar(&i2cAddress, "ambient.address", 0x23, "I2C address of the ambient light sensor")
+	flag.Float64Var(&gain, "ambient.gain", 0,
+		"Output change in percent per 1000 lux above the reference, negative to dim in a bright room")
+	flag.Float64Var(&reference, "ambient.reference", 0,
+		"Ambient lux at which output is left unchanged")
+	flag.Float64Var(&minPercent, "ambient.min", 80,
+		"Lowest percent of the scheduled output ambient compensation may reduce to")
+	flag.Float64Var(&maxPercent, "ambient.max", 120,
+		"Highest percent of the scheduled output ambient compensation may boost to")
+	flag.DurationVar(&pollInterval, "ambient.interval", 30*time.Second,
+		"How often to read the ambient light sensor")
+}
+
+const scaleName = "ambient"
+
+// Reading is the last value read from the sensor.
+type Reading struct {
+	Lux   float64   `json:"lux"`
+	Scale float64   `json:"scale"`
+	At    time.Time `json:"at"`
+	Error string    `json:"error,omitempty"`
+}
+
+// Sensor polls an ambient light sensor on the controller host and
+// scales output to compensate for room light or sunlight.
+type Sensor struct {
+	ble     ble.BLEChannel
+	read    func() (float64, error)
+	reading Reading
+	failed  bool
+	lock    sync.Mutex
+}
+
+// Start begins polling the ambient light sensor. It returns nil if no
+// sensor is configured.
+func Start(b ble.BLEChannel) *Sensor {
+	if i2cDevice == "" {
+		return nil
+	}
+	s := &Sensor{ble: b, read: readBH1750}
+	go func() {
+		s.poll()
+		for _ = range time.Tick(pollInterval) {
+			s.poll()
+		}
+	}()
+	return s
+}
+
+// Reading returns the last sensor reading.
+func (s *Sensor) Reading() Reading {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	return s.reading
+}
+
+func (s *Sensor) poll() {
+	lux, err := s.read()
+
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	now := time.Now()
+	if err != nil {
+		// Fall back to the plain schedule rather than a stale correction
+		s.reading = Reading{At: now, Scale: 1, Error: err.Error()}
+		s.ble.ClearScale(scaleName)
+		if !s.failed {
+			s.failed = true
+			alert.Raise(alert.Warning, scaleName, "ambient.failed", "failed to read ambient light sensor: %v", err)
+		}
+		return
+	}
+	s.failed = false
+	f := scale(lux)
+	s.reading = Reading{Lux: lux, Scale: f, At: now}
+	if f == 1 {
+		s.ble.ClearScale(scaleName)
+	} else {
+		s.ble.SetScale(scaleName, f)
+	}
+}
+
+// scale returns the factor to apply to scheduled output for an ambient
+// light level, within the configured bounds.
+func scale(lux float64) float64 {
+	f := 1 + gain/100*(lux-reference)/1000
+	f = math.Max(f, minPercent/100)
+	f = math.Min(f, maxPercent/100)
+	// Round so small fluctuations in the reading don't keep moving
+	// output around
+	return math.Floor(f*100+0.5) / 100
+}
+
+const (
+	i2cSlave = 0x0703
+
+	// BH1750 one time high resolution measurement, 1 lux resolution
+	// with the result ready within 180ms
+	bh1750OneTimeHigh = 0x20
+)
+
+// readBH1750 takes a single measurement from a BH1750 over i2c-dev.
+func readBH1750() (float64, error) {
+	f, err := os.OpenFile(i2cDevice, os.O_RDWR, 0)
+	if err != nil {
+		return 0, err
+	}
+	defer f.Close()
+	if _, _, errno := syscall.Syscall(syscall.SYS_IOCTL, f.Fd(), i2cSlave, uintptr(i2cAddress)); errno != 0 {
+		return 0, errno
+	}
+	if _, err := f.Write([]byte{bh1750OneTimeHigh}); err != nil {
+		return 0, err
+	}
+	time.Sleep(180 * time.Millisecond)
+	buf := make([]byte, 2)
+	n, err := f.Read(buf)
+	if err != nil {
+		return 0, err
+	}
+	if n != 2 {
+		return 0, errors.New("short read from ambient light sensor")
+	}
+	return bh1750Lux(buf), nil
+}
+
+// bh1750Lux converts the big endian raw count to lux.
+func bh1750Lux(buf []byte) float64 {
+	return float64(uint16(buf[0])<<8|uint16(buf[1])) / 1.2
+}
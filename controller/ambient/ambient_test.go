@@ -0,0 +1,31 @@
+package ambient
+
+import "testing"
+
+func TestScale(t *testing.T) {
+	gain, reference, minPercent, maxPercent = -10, 200, 80, 120
+	defer func() { gain, reference, minPercent, maxPercent = 0, 0, 80, 120 }()
+
+	cases := map[float64]float64{
+		200:   1,
+		1200:  0.9,
+		20000: 0.8,
+		0:     1.02,
+	}
+	for lux, want := range cases {
+		if got := scale(lux); got != want {
+			t.Errorf("%.0f lux: expected %.2f, got %.2f", lux, want, got)
+		}
+	}
+
+	gain = 50
+	if got := scale(5000); got != 1.2 {
+		t.Errorf("Expected boost capped at 1.2, got %.2f", got)
+	}
+}
+
+func TestBH1750Lux(t *testing.T) {
+	if lux := bh1750Lux([]byte{0x01, 0x2c}); lux != 250 {
+		t.Errorf("Expected 250 lux, got %f", lux)
+	}
+}
@@ -0,0 +1,89 @@
+package api
+
+import (
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/theatrus/ledbrick/controller/alert"
+	"github.com/theatrus/ledbrick/controller/push"
+)
+
+func (s *Server) handleAlerts(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "GET" {
+		writeError(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	alerts := alert.Recent()
+	if s.tank != "" {
+		alerts = s.tankAlerts(alerts)
+	}
+	writeJson(w, alerts)
+}
+
+// handleAlert serves POST /alerts/<id>/ack
+func (s *Server) handleAlert(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "POST" {
+		writeError(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	parts := strings.Split(strings.TrimPrefix(r.URL.Path, "/alerts/"), "/")
+	if len(parts) != 2 || parts[1] != "ack" {
+		writeError(w, "not found", http.StatusNotFound)
+		return
+	}
+	id, err := strconv.Atoi(parts[0])
+	if err != nil {
+		writeError(w, "bad alert id", http.StatusBadRequest)
+		return
+	}
+	if s.tank != "" && !s.tankAlert(id) {
+		writeError(w, "no such alert", http.StatusNotFound)
+		return
+	}
+	if err := alert.AckBy(id, requester(r)); err != nil {
+		writeError(w, "no such alert", http.StatusNotFound)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// handlePushAction serves POST /push/<action>/<id>, the signed URLs
+// behind a phone alert's buttons. The signature stands in for an API
+// key, so each works once and only for the action and alert it names.
+func (s *Server) handlePushAction(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "POST" {
+		writeError(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	parts := strings.Split(strings.TrimPrefix(r.URL.Path, "/push/"), "/")
+	if len(parts) != 2 {
+		writeError(w, "not found", http.StatusNotFound)
+		return
+	}
+	id, err := strconv.Atoi(parts[1])
+	if err != nil {
+		writeError(w, "bad alert id", http.StatusBadRequest)
+		return
+	}
+	q := r.URL.Query()
+	if err := push.Redeem(parts[0], id, q.Get("expires"), q.Get("sig")); err != nil {
+		writeError(w, err.Error(), http.StatusForbidden)
+		return
+	}
+	by := "push"
+	switch parts[0] {
+	case push.Ack:
+		err = alert.AckBy(id, by)
+	case push.Maintenance:
+		err = s.startMaintenance(by)
+	default:
+		writeError(w, "not found", http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		writeError(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
@@ -0,0 +1,709 @@
+package api
+
+import (
+	"encoding/json"
+	"flag"
+	"io/ioutil"
+	"log"
+	"math"
+	"net/http"
+	"reflect"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/theatrus/ledbrick/controller/accessory"
+	"github.com/theatrus/ledbrick/controller/ambient"
+	"github.com/theatrus/ledbrick/controller/ble"
+	"github.com/theatrus/ledbrick/controller/gpio"
+	"github.com/theatrus/ledbrick/controller/journal"
+	"github.com/theatrus/ledbrick/controller/ltable"
+	"github.com/theatrus/ledbrick/controller/probe"
+	"github.com/theatrus/ledbrick/controller/report"
+	"github.com/theatrus/ledbrick/controller/spectrum"
+	"github.com/theatrus/ledbrick/controller/units"
+	"github.com/theatrus/ledbrick/controller/ups"
+	"github.com/theatrus/ledbrick/controller/wan"
+)
+
+var listenAddr string
+
+func init() {
+	flag.StringVar(&listenAddr, "api.listen", "",
+		"Address for the HTTP API to listen on, disabled if empty")
+}
+
+type peripheralStatus struct {
+	ID          string `json:"id"`
+	Name        string `json:"name,omitempty"`
+	Active      bool   `json:"active"`
+	Temperature int    `json:"temperature"`
+	FanRPM      int    `json:"fan_rpm"`
+	FanFailed   bool   `json:"fan_failed"`
+
+	Watts           float64 `json:"watts"`
+	EnergyToday     float64 `json:"energy_today_wh"`
+	EnergyPrevDay   float64 `json:"energy_prev_day_wh"`
+	EnergyMonth     float64 `json:"energy_month_wh"`
+	EnergyPrevMonth float64 `json:"energy_prev_month_wh"`
+
+	ChannelCurrents []int          `json:"channel_currents_ma,omitempty"`
+	ChannelFaults   map[int]string `json:"channel_faults,omitempty"`
+}
+
+type channelStatus struct {
+	Channel int     `json:"channel"`
+	Name    string  `json:"name"`
+	Percent float64 `json:"percent"`
+}
+
+type statusResponse struct {
+	Channels         []channelStatus    `json:"channels"`
+	Degraded         bool               `json:"degraded"`
+	EffectsSuspended bool               `json:"effects_suspended"`
+	OnBattery        bool               `json:"on_battery"`
+	Limits           map[string]float64 `json:"limits"`
+	Scales           map[string]float64 `json:"scales"`
+	// Seconds each channel has spent above the exposure threshold today
+	Exposure map[int]float64 `json:"exposure_seconds"`
+	Color    *colorStatus    `json:"color,omitempty"`
+	// TemperatureUnit is how temperatures are shown, C or F. Those in
+	// the API are always C.
+	TemperatureUnit string `json:"temperature_unit"`
+	// Accessories are the outputs other than the LEDs tables schedule
+	Accessories []accessory.State `json:"accessories"`
+	// Offline is set while any internet-dependent feature can't reach
+	// its server, Network saying how each is getting by. The lights run
+	// on regardless.
+	Offline bool          `json:"offline"`
+	Network []wan.Service `json:"network"`
+}
+
+type evalChannel struct {
+	Channel int    `json:"channel"`
+	Name    string `json:"name"`
+	// Setting is the table's value, Output what would be written with
+	// the scales and limits active now
+	Setting float64 `json:"setting"`
+	Output  float64 `json:"output"`
+}
+
+type evalResponse struct {
+	At       time.Time     `json:"at"`
+	Channels []evalChannel `json:"channels"`
+	// Profile is the calendar profile run then, "" for the default
+	// table, and Effects what changes the table's settings
+	Profile          string             `json:"profile"`
+	Effects          []string           `json:"effects"`
+	Limits           map[string]float64 `json:"limits"`
+	Scales           map[string]float64 `json:"scales"`
+	EffectsSuspended bool               `json:"effects_suspended"`
+}
+
+type scheduleResponse struct {
+	Applied bool          `json:"applied"`
+	Impact  ltable.Impact `json:"impact"`
+	// Undo and Redo are how many edits can be undone and redone
+	Undo int `json:"undo"`
+	Redo int `json:"redo"`
+}
+
+type captureResponse struct {
+	Table json.RawMessage `json:"table"`
+}
+
+type powerResponse struct {
+	Fixtures        []peripheralStatus `json:"fixtures"`
+	Watts           float64            `json:"watts"`
+	EnergyToday     float64            `json:"energy_today_wh"`
+	EnergyPrevDay   float64            `json:"energy_prev_day_wh"`
+	EnergyMonth     float64            `json:"energy_month_wh"`
+	EnergyPrevMonth float64            `json:"energy_prev_month_wh"`
+	// Cost estimates at the -report.rate, absent if it isn't set
+	Cost *costResponse `json:"cost,omitempty"`
+}
+
+type costResponse struct {
+	Today     float64 `json:"today"`
+	PrevDay   float64 `json:"prev_day"`
+	Month     float64 `json:"month"`
+	PrevMonth float64 `json:"prev_month"`
+}
+
+type historyResponse struct {
+	ID      string            `json:"id"`
+	Samples []ble.Sample      `json:"samples"`
+	Hourly  []ble.HourSummary `json:"hourly"`
+}
+
+// Server exposes controller state over HTTP as JSON. Optional
+// subsystems are attached by setting their fields before serving.
+type Server struct {
+	Probes     *probe.Probes
+	Interlocks *gpio.Interlocks
+	Reporter   *report.Reporter
+	UPS        *ups.Monitor
+	Ambient    *ambient.Sensor
+	Lights     *ltable.LightDriver
+	Spectra    *spectrum.Spectra
+	Journal    *journal.Journal
+	// Version of the controller, shown in support bundles
+	Version string
+
+	ble ble.BLEChannel
+	mux *http.ServeMux
+	// tank is the name of the tank the server is scoped to, and tanks
+	// the servers for each tank scoped from this one
+	tank  string
+	tanks map[string]*Server
+}
+
+func NewServer(ble ble.BLEChannel) *Server {
+	s := &Server{ble: ble, mux: http.NewServeMux(), tanks: make(map[string]*Server)}
+	s.mux.HandleFunc("/peripherals", s.handlePeripherals)
+	s.mux.HandleFunc("/peripherals/", s.handlePeripheral)
+	s.mux.HandleFunc("/power", s.handlePower)
+	s.mux.HandleFunc("/metrics", s.handleMetrics)
+	s.mux.HandleFunc("/alerts", s.handleAlerts)
+	s.mux.HandleFunc("/alerts/", s.handleAlert)
+	s.mux.HandleFunc("/events", s.handleEvents)
+	s.mux.HandleFunc("/journal", s.handleJournal)
+	s.mux.HandleFunc("/journal/", s.handleJournalEntry)
+	s.mux.HandleFunc("/probes", s.handleProbes)
+	s.mux.HandleFunc("/limits", s.handleLimits)
+	s.mux.HandleFunc("/status", s.handleStatus)
+	s.mux.HandleFunc("/interlocks", s.handleInterlocks)
+	s.mux.HandleFunc("/reports", s.handleReports)
+	s.mux.HandleFunc("/availability", s.handleAvailability)
+	s.mux.HandleFunc("/ambient", s.handleAmbient)
+	s.mux.HandleFunc("/support/bundle", s.handleSupport)
+	s.mux.HandleFunc("/schedule", s.handleSchedule)
+	s.mux.HandleFunc("/schedule/chart", s.handleChart)
+	s.mux.HandleFunc("/schedule/eval", s.handleEval)
+	s.mux.HandleFunc("/schedule/capture", s.handleCapture)
+	s.mux.HandleFunc("/schedule/undo", s.handleUndo)
+	s.mux.HandleFunc("/schedule/redo", s.handleUndo)
+	s.mux.HandleFunc("/schedule/ical", s.handleICal)
+	s.mux.HandleFunc("/tanks", s.handleTanks)
+	s.mux.HandleFunc("/spectrum", s.handleSpectrum)
+	s.mux.HandleFunc("/color", s.handleColor)
+	s.mux.HandleFunc("/export", s.handleExport)
+	s.mux.HandleFunc("/maintenance", s.handleMaintenance)
+	s.mux.HandleFunc("/push/", s.handlePushAction)
+	s.mux.HandleFunc("/auth/login", s.handleLogin)
+	s.mux.HandleFunc("/auth/callback", s.handleCallback)
+	s.mux.HandleFunc("/auth/logout", s.handleLogout)
+	return s
+}
+
+func (s *Server) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r = authorize(w, r); r == nil {
+		return
+	}
+	s.mux.ServeHTTP(w, r)
+}
+
+// ListenAndServe starts the API in the background if a listen address
+// has been configured.
+func ListenAndServe(s *Server) {
+	if listenAddr == "" {
+		return
+	}
+	if err := loadKeys(); err != nil {
+		log.Printf("API not started, bad keys: %v", err)
+		return
+	}
+	if err := loadOIDC(); err != nil {
+		log.Printf("API not started, bad OpenID Connect login: %v", err)
+		return
+	}
+	if err := advertiseAPI(); err != nil {
+		log.Printf("API not advertised with mDNS: %v", err)
+	}
+	go func() {
+		log.Printf("API listening on %s", listenAddr)
+		if err := http.ListenAndServe(listenAddr, s); err != nil {
+			log.Printf("API server failed: %v", err)
+		}
+	}()
+}
+
+// envelope wraps every JSON response, so clients always find the result
+// in data or the reason for a failure in error.
+type envelope struct {
+	Data  interface{} `json:"data,omitempty"`
+	Error string      `json:"error,omitempty"`
+	Meta  meta        `json:"meta"`
+}
+
+type meta struct {
+	Time time.Time `json:"time"`
+	// Count is the number of items when data is a list
+	Count *int `json:"count,omitempty"`
+}
+
+func writeJson(w http.ResponseWriter, v interface{}) {
+	m := meta{Time: time.Now()}
+	if rv := reflect.ValueOf(v); rv.Kind() == reflect.Slice {
+		n := rv.Len()
+		m.Count = &n
+	}
+	writeEnvelope(w, http.StatusOK, envelope{Data: v, Meta: m})
+}
+
+// writeError replies with an error in the envelope, replacing
+// http.Error so failures are JSON too.
+func writeError(w http.ResponseWriter, message string, code int) {
+	writeEnvelope(w, code, envelope{Error: message, Meta: meta{Time: time.Now()}})
+}
+
+func writeEnvelope(w http.ResponseWriter, code int, e envelope) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(code)
+	if err := json.NewEncoder(w).Encode(e); err != nil {
+		log.Printf("Failed to write API response: %v", err)
+	}
+}
+
+func (s *Server) peripheral(id string) ble.BLEPeripheral {
+	for _, p := range s.ble.Perhipherals() {
+		if p.ID() == id {
+			return p
+		}
+	}
+	return nil
+}
+
+func (s *Server) handlePeripherals(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "GET" {
+		writeError(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	writeJson(w, s.status())
+}
+
+func (s *Server) status() []peripheralStatus {
+	status := make([]peripheralStatus, 0)
+	for _, p := range s.ble.Perhipherals() {
+		status = append(status, peripheralStatus{
+			ID:              p.ID(),
+			Name:            p.Name(),
+			Active:          p.Active(),
+			Temperature:     p.Temperature(),
+			FanRPM:          p.FanRPM(),
+			FanFailed:       p.FanFailed(),
+			Watts:           p.Watts(),
+			EnergyToday:     p.EnergyToday(),
+			EnergyPrevDay:   p.EnergyPrevDay(),
+			EnergyMonth:     p.EnergyMonth(),
+			EnergyPrevMonth: p.EnergyPrevMonth(),
+
+			ChannelCurrents: p.ChannelCurrents(),
+			ChannelFaults:   p.ChannelFaults(),
+		})
+	}
+	return status
+}
+
+func (s *Server) handleProbes(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "GET" {
+		writeError(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	if s.Probes == nil {
+		writeJson(w, []probe.Reading{})
+		return
+	}
+	writeJson(w, s.Probes.Readings())
+}
+
+func (s *Server) handleInterlocks(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "GET" {
+		writeError(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	if s.Interlocks == nil {
+		writeJson(w, []gpio.Input{})
+		return
+	}
+	writeJson(w, s.Interlocks.Inputs())
+}
+
+func (s *Server) handleAmbient(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "GET" {
+		writeError(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	if s.Ambient == nil {
+		writeError(w, "no ambient light sensor", http.StatusNotFound)
+		return
+	}
+	writeJson(w, s.Ambient.Reading())
+}
+
+// handleSchedule returns the running lighting table with GET, and
+// applies a new one with POST, which is kept only if it runs without
+// errors for a grace window. The response gives its impact, and
+// ?dry_run=true only works that out.
+func (s *Server) handleSchedule(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "GET" && r.Method != "POST" {
+		writeError(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	if s.Lights == nil {
+		writeError(w, "no schedule", http.StatusNotFound)
+		return
+	}
+	if r.Method == "GET" {
+		table, err := s.Lights.Table()
+		if err != nil {
+			writeError(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		writeJson(w, captureResponse{Table: table})
+		return
+	}
+	data, err := ioutil.ReadAll(http.MaxBytesReader(w, r.Body, 1<<20))
+	if err != nil {
+		writeError(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	impact, err := s.Lights.Impact(data, report.PAR())
+	if err != nil {
+		writeError(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	resp := scheduleResponse{Impact: impact}
+	if r.URL.Query().Get("dry_run") == "true" {
+		writeJson(w, resp)
+		return
+	}
+	// Big changes must be resent with ?confirm=true, so a slip can't
+	// bleach a tank
+	if len(impact.Confirm) > 0 && r.URL.Query().Get("confirm") != "true" {
+		writeEnvelope(w, http.StatusConflict, envelope{Data: resp,
+			Error: "change needs confirming: " + strings.Join(impact.Confirm, "; "),
+			Meta:  meta{Time: time.Now()},
+		})
+		return
+	}
+	if err := s.Lights.Edit(data); err != nil {
+		writeError(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	resp.Applied = true
+	resp.Undo, resp.Redo = s.Lights.Edits()
+	writeJson(w, resp)
+}
+
+// handleCapture adds the channels' current settings to the running
+// table as a point at this minute with POST. The response is the new
+// table, to save over the config file so it survives a restart.
+func (s *Server) handleCapture(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "POST" {
+		writeError(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	if s.Lights == nil {
+		writeError(w, "no schedule", http.StatusNotFound)
+		return
+	}
+	table, err := s.Lights.Capture()
+	if err != nil {
+		writeError(w, err.Error(), http.StatusConflict)
+		return
+	}
+	writeJson(w, captureResponse{Table: table})
+}
+
+// handleUndo undoes the last schedule edit with POST to /schedule/undo,
+// or redoes the last undone with /schedule/redo. The table put back is
+// on trial as a newly applied one is.
+func (s *Server) handleUndo(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "POST" {
+		writeError(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	if s.Lights == nil {
+		writeError(w, "no schedule", http.StatusNotFound)
+		return
+	}
+	step := s.Lights.Undo
+	if strings.HasSuffix(r.URL.Path, "/redo") {
+		step = s.Lights.Redo
+	}
+	if err := step(); err != nil {
+		writeError(w, err.Error(), http.StatusConflict)
+		return
+	}
+	resp := scheduleResponse{Applied: true}
+	resp.Undo, resp.Redo = s.Lights.Edits()
+	writeJson(w, resp)
+}
+
+// handleEval shows what the schedule gives at ?at=, as RFC 3339 or
+// hours:minutes today, assuming the overrides active now still are.
+func (s *Server) handleEval(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "GET" {
+		writeError(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	if s.Lights == nil {
+		writeError(w, "no schedule", http.StatusNotFound)
+		return
+	}
+	at, err := ltable.ParseTime(r.URL.Query().Get("at"), time.Now())
+	if err != nil {
+		writeError(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	e, err := s.Lights.Eval(at)
+	if err != nil {
+		writeError(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	resp := evalResponse{At: at,
+		Profile:          e.Profile,
+		Effects:          e.Effects,
+		Limits:           s.ble.Limits(),
+		Scales:           s.ble.Scales(),
+		EffectsSuspended: s.ble.EffectsSuspended(),
+	}
+	if resp.Effects == nil {
+		resp.Effects = []string{}
+	}
+	for channel, v := range s.outputs(at, e.Percents) {
+		resp.Channels = append(resp.Channels, evalChannel{Channel: channel,
+			Name:    ltable.ChannelNames[channel],
+			Setting: e.Percents[channel],
+			Output:  v,
+		})
+	}
+	writeJson(w, resp)
+}
+
+// outputs are what settings at a time are written as with the limits
+// and scales active now, and the exposure budget spent so far if it is
+// today.
+func (s *Server) outputs(at time.Time, settings []float64) []float64 {
+	limits, scales := s.ble.Limits(), s.ble.Scales()
+	exposure := s.ble.Exposure()
+	now := time.Now()
+	today := at.Local().Format("2006-01-02") == now.Format("2006-01-02")
+	outputs := make([]float64, len(settings))
+	for channel, v := range settings {
+		above := time.Duration(0)
+		if today {
+			above = exposure[channel]
+		}
+		outputs[channel] = ble.Output(v, limits, scales, above)
+	}
+	return outputs
+}
+
+// output is what a setting is written as with the given limits and
+// scales.
+func output(setting float64, limits, scales map[string]float64) float64 {
+	limit := 100.0
+	for _, l := range limits {
+		limit = math.Min(limit, l)
+	}
+	scale := 1.0
+	for _, f := range scales {
+		scale *= f
+	}
+	return math.Min(math.Min(setting*scale, 100), limit)
+}
+
+// handleChart draws the schedule as an SVG, or a PNG with ?format=png.
+func (s *Server) handleChart(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "GET" {
+		writeError(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	if s.Lights == nil {
+		writeError(w, "no schedule", http.StatusNotFound)
+		return
+	}
+	format := r.URL.Query().Get("format")
+	switch format {
+	case "", "svg":
+		format = "svg"
+		w.Header().Set("Content-Type", "image/svg+xml")
+	case "png":
+		w.Header().Set("Content-Type", "image/png")
+	default:
+		writeError(w, "format must be svg or png", http.StatusBadRequest)
+		return
+	}
+	if err := s.Lights.Chart(format, w); err != nil {
+		log.Printf("Failed to draw schedule chart: %v", err)
+	}
+}
+
+// handleICal serves the lighting program's events for ?days= days, 14
+// by default, as an iCalendar feed to subscribe to.
+func (s *Server) handleICal(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "GET" {
+		writeError(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	if s.Lights == nil {
+		writeError(w, "no schedule", http.StatusNotFound)
+		return
+	}
+	days := 14
+	if v := r.URL.Query().Get("days"); v != "" {
+		var err error
+		if days, err = strconv.Atoi(v); err != nil || days < 1 || days > 366 {
+			writeError(w, "days must be from 1 to 366", http.StatusBadRequest)
+			return
+		}
+	}
+	name := "LEDBrick"
+	if s.tank != "" {
+		name += " " + s.tank
+	}
+	w.Header().Set("Content-Type", "text/calendar; charset=utf-8")
+	if err := ltable.WriteICal(w, name, s.Lights.Events(time.Now(), days)); err != nil {
+		log.Printf("Failed to write iCal feed: %v", err)
+	}
+}
+
+func (s *Server) handleStatus(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "GET" {
+		writeError(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	exposure := make(map[int]float64)
+	for channel, d := range s.ble.Exposure() {
+		exposure[channel] = d.Seconds()
+	}
+	channels := s.ble.Channels()
+	status := make([]channelStatus, 0, len(channels))
+	for channel := 0; channel < ltable.Channels; channel++ {
+		if v, ok := channels[channel]; ok {
+			status = append(status, channelStatus{Channel: channel,
+				Name:    ltable.ChannelNames[channel],
+				Percent: v,
+			})
+		}
+	}
+	writeJson(w, statusResponse{Channels: status,
+		Degraded:         s.ble.Degraded(),
+		EffectsSuspended: s.ble.EffectsSuspended(),
+		OnBattery:        s.UPS != nil && s.UPS.OnBattery(),
+		Limits:           s.ble.Limits(),
+		Scales:           s.ble.Scales(),
+		Exposure:         exposure,
+		Color:            s.color(channels),
+		TemperatureUnit:  units.Temperature(),
+		Accessories:      accessory.States(),
+		Offline:          wan.Offline(),
+		Network:          wan.Services(),
+	})
+}
+
+func (s *Server) handleReports(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "GET" {
+		writeError(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	if s.Reporter == nil {
+		writeJson(w, []report.Report{})
+		return
+	}
+	writeJson(w, s.Reporter.Reports())
+}
+
+func (s *Server) handleAvailability(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "GET" {
+		writeError(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	writeJson(w, s.ble.Availability())
+}
+
+func (s *Server) handleLimits(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "GET" {
+		writeError(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	writeJson(w, s.ble.Limits())
+}
+
+func (s *Server) handlePower(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "GET" {
+		writeError(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	resp := powerResponse{Fixtures: s.status()}
+	for _, f := range resp.Fixtures {
+		resp.Watts += f.Watts
+		resp.EnergyToday += f.EnergyToday
+		resp.EnergyPrevDay += f.EnergyPrevDay
+		resp.EnergyMonth += f.EnergyMonth
+		resp.EnergyPrevMonth += f.EnergyPrevMonth
+	}
+	if report.Cost(1000) > 0 {
+		resp.Cost = &costResponse{
+			Today:     report.Cost(resp.EnergyToday),
+			PrevDay:   report.Cost(resp.EnergyPrevDay),
+			Month:     report.Cost(resp.EnergyMonth),
+			PrevMonth: report.Cost(resp.EnergyPrevMonth),
+		}
+	}
+	writeJson(w, resp)
+}
+
+// nameRequest names a fixture, or clears its name if empty.
+type nameRequest struct {
+	Name string `json:"name"`
+}
+
+// handlePeripheral serves /peripherals/<id>/<resource>: GET history, or
+// PUT name with a nameRequest.
+func (s *Server) handlePeripheral(w http.ResponseWriter, r *http.Request) {
+	parts := strings.Split(strings.TrimPrefix(r.URL.Path, "/peripherals/"), "/")
+	if len(parts) == 2 && parts[1] == "name" {
+		s.handleName(w, r, parts[0])
+		return
+	}
+	if r.Method != "GET" {
+		writeError(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	if len(parts) != 2 || parts[1] != "history" {
+		writeError(w, "not found", http.StatusNotFound)
+		return
+	}
+	p := s.peripheral(parts[0])
+	if p == nil {
+		writeError(w, "no such peripheral", http.StatusNotFound)
+		return
+	}
+	samples := p.History()
+	writeJson(w, historyResponse{ID: p.ID(),
+		Samples: samples,
+		Hourly:  ble.Summarize(samples),
+	})
+}
+
+// handleName names a fixture, keeping the name on it if it can.
+func (s *Server) handleName(w http.ResponseWriter, r *http.Request, id string) {
+	if r.Method != "PUT" {
+		writeError(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	var req nameRequest
+	if err := json.NewDecoder(http.MaxBytesReader(w, r.Body, 1<<20)).Decode(&req); err != nil {
+		writeError(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if err := s.ble.SetName(id, req.Name); err != nil {
+		writeError(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	writeJson(w, req)
+}
@@ -0,0 +1,231 @@
+package api
+
+import (
+	"context"
+	"crypto/subtle"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"strings"
+)
+
+var adminToken string
+var viewerToken string
+var keysFile string
+
+func init() {
+	flag.StringVar(&adminToken, "api.token", "",
+		"Bearer token required for full API access, open to anyone if no tokens or keys are set")
+	flag.StringVar(&viewerToken, "api.viewer.token", "",
+		"Bearer token allowing read-only API access, for a tank-sitter or a dashboard")
+	flag.StringVar(&keysFile, "api.keys", "",
+		"JSON file of named API keys, each with a token and a scope of read, control, configure or admin")
+}
+
+// role is what a token may do, each allowing everything the ones
+// before it do.
+type role int
+
+const (
+	noRole role = iota
+	// readRole may only read, and not support bundles
+	readRole
+	// controlRole may also act on the lights and alerts, such as holding
+	// a colour or acknowledging an alert
+	controlRole
+	// configureRole may also change the lighting table
+	configureRole
+	adminRole
+)
+
+var roleNames = map[string]role{
+	"read":      readRole,
+	"control":   controlRole,
+	"configure": configureRole,
+	"admin":     adminRole,
+}
+
+func (r role) String() string {
+	for name, named := range roleNames {
+		if named == r {
+			return name
+		}
+	}
+	return "none"
+}
+
+func (r role) MarshalJSON() ([]byte, error) {
+	return json.Marshal(r.String())
+}
+
+func (r *role) UnmarshalJSON(data []byte) error {
+	var name string
+	if err := json.Unmarshal(data, &name); err != nil {
+		return err
+	}
+	named, ok := roleNames[name]
+	if !ok {
+		return fmt.Errorf("unknown scope %q, expected read, control, configure or admin", name)
+	}
+	*r = named
+	return nil
+}
+
+// key is a named API key from -api.keys.
+type key struct {
+	Token string `json:"token"`
+	Scope role   `json:"scope"`
+}
+
+// principal is who a request is from, named for attributing changes.
+type principal struct {
+	name string
+	role role
+}
+
+// keys are the named keys, with -api.token and -api.viewer.token as
+// "admin" and "viewer".
+var keys map[string]key
+
+// loadKeys reads -api.keys and adds the single tokens to them.
+func loadKeys() error {
+	loaded := make(map[string]key)
+	if keysFile != "" {
+		data, err := ioutil.ReadFile(keysFile)
+		if err != nil {
+			return err
+		}
+		if err := json.Unmarshal(data, &loaded); err != nil {
+			return fmt.Errorf("%s: %v", keysFile, err)
+		}
+		for name, k := range loaded {
+			if k.Token == "" || k.Scope == noRole {
+				return fmt.Errorf("%s: key %q needs a token and a scope", keysFile, name)
+			}
+		}
+	}
+	if adminToken != "" {
+		loaded["admin"] = key{Token: adminToken, Scope: adminRole}
+	}
+	if viewerToken != "" {
+		loaded["viewer"] = key{Token: viewerToken, Scope: readRole}
+	}
+	keys = loaded
+	return nil
+}
+
+// principalFor returns who a request's bearer token, or failing that
+// its dashboard session, is from. Calendar apps can't send a bearer
+// token, so the iCal feed also takes one as ?token=. Without any keys
+// or login configured everyone is an admin, as before tokens existed.
+func principalFor(r *http.Request) principal {
+	if len(keys) == 0 && login == nil {
+		return principal{name: "anonymous", role: adminRole}
+	}
+	token := strings.TrimPrefix(r.Header.Get("Authorization"), "Bearer ")
+	if token == "" && strings.HasSuffix(r.URL.Path, "/schedule/ical") {
+		token = r.URL.Query().Get("token")
+	}
+	if token == "" {
+		if login != nil {
+			if p, ok := login.sessionFor(r); ok {
+				return p
+			}
+		}
+		return principal{}
+	}
+	for name, k := range keys {
+		if subtle.ConstantTimeCompare([]byte(token), []byte(k.Token)) == 1 {
+			return principal{name: name, role: k.Scope}
+		}
+	}
+	return principal{}
+}
+
+// adminOnly are paths only admins can read, as they hold logs and
+// configuration.
+var adminOnly = map[string]bool{
+	"/support/bundle": true,
+}
+
+// configures are paths which change the lighting table.
+var configures = map[string]bool{
+	"/schedule":         true,
+	"/schedule/capture": true,
+	"/schedule/undo":    true,
+	"/schedule/redo":    true,
+}
+
+// configuring reports whether a path changes configuration: the
+// lighting table, or a fixture's name, which is kept on the fixture and
+// in the names file.
+func configuring(path string) bool {
+	if strings.HasPrefix(path, "/peripherals/") && strings.HasSuffix(path, "/name") {
+		return true
+	}
+	return configures[path]
+}
+
+// needs returns the role a request needs. Tank scoped paths need what
+// the same path does for the whole controller.
+func needs(r *http.Request) role {
+	path := r.URL.Path
+	if strings.HasPrefix(path, "/tanks/") {
+		if i := strings.Index(path[len("/tanks/"):], "/"); i >= 0 {
+			path = path[len("/tanks/")+i:]
+		}
+	}
+	switch {
+	case strings.HasPrefix(path, "/auth/"):
+		// Logging in is how a browser gets a role
+		return noRole
+	case strings.HasPrefix(path, "/push/"):
+		// Push buttons carry their own signature
+		return noRole
+	case adminOnly[path]:
+		return adminRole
+	case r.Method == "GET" || r.Method == "HEAD":
+		return readRole
+	case configuring(path):
+		return configureRole
+	}
+	return controlRole
+}
+
+type principalKey struct{}
+
+// requester returns who a request authorized by ServeHTTP is from.
+func requester(r *http.Request) string {
+	if p, ok := r.Context().Value(principalKey{}).(principal); ok {
+		return p.name
+	}
+	return ""
+}
+
+// authorize checks a request against its principal's role, writing the
+// error and returning nil if it isn't allowed. Requests which change
+// anything are logged with who made them.
+func authorize(w http.ResponseWriter, r *http.Request) *http.Request {
+	p := principalFor(r)
+	need := needs(r)
+	switch {
+	case need == noRole:
+		return r
+	case p.role == noRole:
+		w.Header().Set("WWW-Authenticate", `Bearer realm="ledbrick"`)
+		writeError(w, "unauthorized", http.StatusUnauthorized)
+		return nil
+	case p.role < need:
+		writeError(w, fmt.Sprintf("%s has %s scope, %s needs %s", p.name, p.role, r.URL.Path, need),
+			http.StatusForbidden)
+		return nil
+	}
+	// Tank scoped requests are authorized again by the tank's server
+	if _, seen := r.Context().Value(principalKey{}).(principal); !seen && r.Method != "GET" && r.Method != "HEAD" {
+		log.Printf("API %s %s by %s", r.Method, r.URL.Path, p.name)
+	}
+	return r.WithContext(context.WithValue(r.Context(), principalKey{}, p))
+}
@@ -0,0 +1,230 @@
+package api
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestNeeds(t *testing.T) {
+	for _, tt := range []struct {
+		method, path string
+		want         role
+	}{
+		{"GET", "/channels", readRole},
+		{"HEAD", "/schedule", readRole},
+		{"POST", "/channels", controlRole},
+		{"POST", "/alerts/ack", controlRole},
+		{"PUT", "/schedule", configureRole},
+		{"POST", "/schedule/undo", configureRole},
+		{"POST", "/schedule/capture", configureRole},
+		{"PUT", "/peripherals/aa:bb:cc/name", configureRole},
+		{"GET", "/peripherals/aa:bb:cc/name", readRole},
+		{"GET", "/peripherals/aa:bb:cc/history", readRole},
+		{"GET", "/support/bundle", adminRole},
+		{"POST", "/support/bundle", adminRole},
+		{"GET", "/auth/login", noRole},
+		{"GET", "/auth/callback", noRole},
+		{"POST", "/push/ack/3", noRole},
+		// Tank scoped paths need what the controller's path does
+		{"GET", "/tanks/sump/channels", readRole},
+		{"POST", "/tanks/sump/channels", controlRole},
+		{"PUT", "/tanks/sump/schedule", configureRole},
+		{"GET", "/tanks/sump/support/bundle", adminRole},
+		{"GET", "/tanks/", readRole},
+	} {
+		r := httptest.NewRequest(tt.method, tt.path, nil)
+		if got := needs(r); got != tt.want {
+			t.Errorf("%s %s needs %s, expected %s", tt.method, tt.path, got, tt.want)
+		}
+	}
+}
+
+func TestLoadKeys(t *testing.T) {
+	defer func(file, admin, viewer string) {
+		keysFile, adminToken, viewerToken = file, admin, viewer
+		loadKeys()
+	}(keysFile, adminToken, viewerToken)
+	dir, err := ioutil.TempDir("", "keys")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	for _, tt := range []struct {
+		name, keys string
+		ok         bool
+	}{
+		{"good", `{"sitter": {"token": "s", "scope": "control"}, "ci": {"token": "c", "scope": "configure"}}`, true},
+		{"not json", `{"sitter": `, false},
+		{"unknown scope", `{"sitter": {"token": "s", "scope": "owner"}}`, false},
+		{"no token", `{"sitter": {"scope": "read"}}`, false},
+		{"no scope", `{"sitter": {"token": "s"}}`, false},
+	} {
+		keysFile = filepath.Join(dir, tt.name+".json")
+		ioutil.WriteFile(keysFile, []byte(tt.keys), 0600)
+		adminToken, viewerToken = "", ""
+		if err := loadKeys(); (err == nil) != tt.ok {
+			t.Errorf("%s: expected ok %v, got %v", tt.name, tt.ok, err)
+		}
+	}
+
+	keysFile = filepath.Join(dir, "missing.json")
+	if err := loadKeys(); err == nil {
+		t.Error("Expected a missing keys file to fail")
+	}
+
+	keysFile = filepath.Join(dir, "good.json")
+	adminToken, viewerToken = "a", "v"
+	if err := loadKeys(); err != nil {
+		t.Fatal(err)
+	}
+	want := map[string]key{
+		"sitter": {Token: "s", Scope: controlRole},
+		"ci":     {Token: "c", Scope: configureRole},
+		"admin":  {Token: "a", Scope: adminRole},
+		"viewer": {Token: "v", Scope: readRole},
+	}
+	if len(keys) != len(want) {
+		t.Fatalf("Expected %v, got %v", want, keys)
+	}
+	for name, k := range want {
+		if keys[name] != k {
+			t.Errorf("Expected key %s to be %+v, got %+v", name, k, keys[name])
+		}
+	}
+}
+
+// withKeys runs with the given keys and no login.
+func withKeys(k map[string]key) func() {
+	saved, savedLogin := keys, login
+	keys, login = k, nil
+	return func() { keys, login = saved, savedLogin }
+}
+
+func TestAuthorize(t *testing.T) {
+	defer withKeys(map[string]key{
+		"viewer": {Token: "v", Scope: readRole},
+		"sitter": {Token: "s", Scope: controlRole},
+		"admin":  {Token: "a", Scope: adminRole},
+	})()
+
+	for _, tt := range []struct {
+		method, path, token string
+		// want is the status written, 0 if the request is allowed
+		want int
+		who  string
+	}{
+		{"GET", "/channels", "v", 0, "viewer"},
+		{"POST", "/channels", "v", http.StatusForbidden, ""},
+		{"POST", "/channels", "s", 0, "sitter"},
+		{"PUT", "/schedule", "s", http.StatusForbidden, ""},
+		{"PUT", "/tanks/sump/schedule", "s", http.StatusForbidden, ""},
+		{"PUT", "/schedule", "a", 0, "admin"},
+		{"GET", "/support/bundle", "v", http.StatusForbidden, ""},
+		{"GET", "/channels", "", http.StatusUnauthorized, ""},
+		{"GET", "/channels", "wrong", http.StatusUnauthorized, ""},
+		{"GET", "/auth/login", "", 0, ""},
+		{"POST", "/push/ack/3", "", 0, ""},
+		// Only the iCal feed takes its token in the query
+		{"GET", "/schedule/ical?token=v", "", 0, "viewer"},
+		{"GET", "/tanks/sump/schedule/ical?token=v", "", 0, "viewer"},
+		{"GET", "/channels?token=v", "", http.StatusUnauthorized, ""},
+		{"POST", "/channels?token=a", "", http.StatusUnauthorized, ""},
+	} {
+		r := httptest.NewRequest(tt.method, tt.path, nil)
+		if tt.token != "" {
+			r.Header.Set("Authorization", "Bearer "+tt.token)
+		}
+		w := httptest.NewRecorder()
+		authorized := authorize(w, r)
+		switch {
+		case tt.want == 0 && authorized == nil:
+			t.Errorf("%s %s with %q: expected allowed, got %d", tt.method, tt.path, tt.token, w.Code)
+		case tt.want != 0 && (authorized != nil || w.Code != tt.want):
+			t.Errorf("%s %s with %q: expected %d, got %d", tt.method, tt.path, tt.token, tt.want, w.Code)
+		case authorized != nil && requester(authorized) != tt.who:
+			t.Errorf("%s %s with %q: expected it from %q, got %q",
+				tt.method, tt.path, tt.token, tt.who, requester(authorized))
+		}
+	}
+}
+
+func TestAuthorizeWithoutKeys(t *testing.T) {
+	defer withKeys(nil)()
+	r := authorize(httptest.NewRecorder(), httptest.NewRequest("GET", "/support/bundle", nil))
+	if r == nil || requester(r) != "anonymous" {
+		t.Error("Expected everyone to be an admin without keys or a login")
+	}
+}
+
+func TestSessionAuth(t *testing.T) {
+	defer withKeys(map[string]key{"admin": {Token: "a", Scope: adminRole}})()
+	login = &oidc{sessionKey: []byte("secret")}
+
+	cookie := func(s session, sign func(string) string) *http.Cookie {
+		data, _ := json.Marshal(s)
+		payload := base64.RawURLEncoding.EncodeToString(data)
+		return &http.Cookie{Name: sessionCookie, Value: payload + "." + sign(payload)}
+	}
+	// tampered raises a signed session's scope, keeping its signature
+	tampered := func(c *http.Cookie) *http.Cookie {
+		parts := strings.Split(c.Value, ".")
+		data, _ := base64.RawURLEncoding.DecodeString(parts[0])
+		var s session
+		json.Unmarshal(data, &s)
+		s.Scope = adminRole
+		data, _ = json.Marshal(s)
+		c.Value = base64.RawURLEncoding.EncodeToString(data) + "." + parts[1]
+		return c
+	}
+	later := time.Now().Add(time.Hour).Unix()
+	forged := &oidc{sessionKey: []byte("guess")}
+	for _, tt := range []struct {
+		name   string
+		cookie *http.Cookie
+		want   principal
+	}{
+		{"valid", cookie(session{Name: "oidc:sam", Scope: controlRole, Expiry: later}, login.sign),
+			principal{name: "oidc:sam", role: controlRole}},
+		{"expired", cookie(session{Name: "oidc:sam", Scope: controlRole, Expiry: time.Now().Add(-time.Hour).Unix()}, login.sign),
+			principal{}},
+		{"forged", cookie(session{Name: "oidc:sam", Scope: adminRole, Expiry: later}, forged.sign),
+			principal{}},
+		{"tampered", tampered(cookie(session{Name: "oidc:sam", Scope: readRole, Expiry: later}, login.sign)),
+			principal{}},
+		{"garbled", &http.Cookie{Name: sessionCookie, Value: "nonsense"}, principal{}},
+		{"none", nil, principal{}},
+	} {
+		r := httptest.NewRequest("GET", "/channels", nil)
+		if tt.cookie != nil {
+			r.AddCookie(tt.cookie)
+		}
+		if got := principalFor(r); got != tt.want {
+			t.Errorf("%s session: expected %+v, got %+v", tt.name, tt.want, got)
+		}
+	}
+
+	// A bearer token wins over the session
+	r := httptest.NewRequest("GET", "/channels", nil)
+	r.Header.Set("Authorization", "Bearer a")
+	r.AddCookie(cookie(session{Name: "oidc:sam", Scope: readRole, Expiry: later}, login.sign))
+	if p := principalFor(r); p.name != "admin" {
+		t.Errorf("Expected the bearer token's principal, got %+v", p)
+	}
+
+	// A session only gets as far as its scope
+	r = httptest.NewRequest("PUT", "/schedule", nil)
+	r.AddCookie(cookie(session{Name: "oidc:sam", Scope: controlRole, Expiry: later}, login.sign))
+	w := httptest.NewRecorder()
+	if authorize(w, r) != nil || w.Code != http.StatusForbidden {
+		t.Errorf("Expected a control session refused the schedule, got %d", w.Code)
+	}
+}
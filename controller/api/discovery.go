@@ -0,0 +1,41 @@
+package api
+
+import (
+	"flag"
+	"fmt"
+	"net"
+	"os"
+	"strconv"
+
+	"github.com/theatrus/ledbrick/controller/mdns"
+)
+
+// The service the API is advertised as, for ledbrickctl discover
+const apiService = "_ledbrick._tcp.local"
+
+var advertise bool
+var advertiseName string
+
+func init() {
+	host, _ := os.Hostname()
+	flag.BoolVar(&advertise, "api.mdns", true,
+		"Advertise the API on the local network with mDNS, so ledbrickctl can discover it")
+	flag.StringVar(&advertiseName, "api.mdns.name", host,
+		"Name the API is advertised under, e.g. the room or tank")
+}
+
+// advertiseAPI advertises the API's port with mDNS.
+func advertiseAPI() error {
+	if !advertise {
+		return nil
+	}
+	_, p, err := net.SplitHostPort(listenAddr)
+	if err != nil {
+		return err
+	}
+	port, err := strconv.Atoi(p)
+	if err != nil {
+		return fmt.Errorf("can't advertise port %q", p)
+	}
+	return mdns.Advertise(advertiseName, apiService, port)
+}
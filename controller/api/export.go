@@ -0,0 +1,161 @@
+package api
+
+import (
+	"encoding/csv"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/theatrus/ledbrick/controller/ltable"
+	"github.com/theatrus/ledbrick/controller/report"
+)
+
+// exportSeries are the telemetry series which can be exported.
+var exportSeries = []string{"temperature", "fan", "channels", "dli"}
+
+// exportRow is one value of a series.
+type exportRow struct {
+	at      time.Time
+	fixture string
+	series  string
+	value   float64
+}
+
+// parseDay reads a time as RFC 3339, or a whole local day as
+// YYYY-MM-DD, returning its start, or its end if end is set.
+func parseDay(s string, end bool) (time.Time, error) {
+	if t, err := time.Parse(time.RFC3339, s); err == nil {
+		return t, nil
+	}
+	t, err := time.ParseInLocation("2006-01-02", s, time.Local)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("bad time %q, expected RFC 3339 or YYYY-MM-DD", s)
+	}
+	if end {
+		t = t.AddDate(0, 0, 1)
+	}
+	return t, nil
+}
+
+// handleExport serves telemetry as CSV, one value per row, for analysis
+// in a spreadsheet. ?series= picks from temperature, fan, channels and
+// dli, all by default, and ?from= and ?to= bound the range, the last
+// day by default. Temperature, fan and channels come from each
+// fixture's history, so only reach back as far as -ble.history.window,
+// and dli from the daily reports.
+func (s *Server) handleExport(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "GET" {
+		writeError(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	q := r.URL.Query()
+	to := time.Now()
+	from := to.Add(-24 * time.Hour)
+	var err error
+	if v := q.Get("from"); v != "" {
+		if from, err = parseDay(v, false); err != nil {
+			writeError(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+	}
+	if v := q.Get("to"); v != "" {
+		if to, err = parseDay(v, true); err != nil {
+			writeError(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+	}
+	if !from.Before(to) {
+		writeError(w, "from must be before to", http.StatusBadRequest)
+		return
+	}
+	series := make(map[string]bool)
+	if v := q.Get("series"); v != "" {
+		for _, name := range strings.Split(v, ",") {
+			if !contains(exportSeries, name) {
+				writeError(w, fmt.Sprintf("unknown series %q, expected one of %s",
+					name, strings.Join(exportSeries, ", ")), http.StatusBadRequest)
+				return
+			}
+			series[name] = true
+		}
+	} else {
+		for _, name := range exportSeries {
+			series[name] = true
+		}
+	}
+
+	var rows []exportRow
+	for _, p := range s.ble.Perhipherals() {
+		for _, sample := range p.History() {
+			if sample.At.Before(from) || !sample.At.Before(to) {
+				continue
+			}
+			if series["temperature"] {
+				rows = append(rows, exportRow{sample.At, p.ID(), "temperature", float64(sample.Temperature)})
+			}
+			if series["fan"] {
+				rows = append(rows, exportRow{sample.At, p.ID(), "fan_rpm", float64(sample.FanRPM)})
+			}
+			if series["channels"] {
+				for channel, percent := range sample.Channels {
+					rows = append(rows, exportRow{sample.At, p.ID(), channelSeries(channel), percent})
+				}
+			}
+		}
+	}
+	if series["dli"] && s.Reporter != nil {
+		for _, day := range s.Reporter.Reports() {
+			rows = append(rows, dliRows(day, from, to)...)
+		}
+	}
+	sort.SliceStable(rows, func(i, j int) bool {
+		if !rows[i].at.Equal(rows[j].at) {
+			return rows[i].at.Before(rows[j].at)
+		}
+		if rows[i].fixture != rows[j].fixture {
+			return rows[i].fixture < rows[j].fixture
+		}
+		return rows[i].series < rows[j].series
+	})
+
+	w.Header().Set("Content-Type", "text/csv")
+	w.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=\"ledbrick-%s.csv\"",
+		from.Format("20060102")))
+	if err := writeExport(w, rows); err != nil {
+		log.Printf("Failed to write export: %v", err)
+	}
+}
+
+// channelSeries names a channel's series, e.g. channel_2_pc_amber.
+func channelSeries(channel int) string {
+	name := fmt.Sprintf("channel_%d", channel)
+	if channel < len(ltable.ChannelNames) {
+		name += "_" + strings.ToLower(strings.Replace(ltable.ChannelNames[channel], " ", "_", -1))
+	}
+	return name
+}
+
+// dliRows returns a daily report's DLI if its day starts in range.
+func dliRows(day report.Report, from, to time.Time) []exportRow {
+	at, err := time.ParseInLocation("2006-01-02", day.Day, time.Local)
+	if err != nil || at.Before(from) || !at.Before(to) {
+		return nil
+	}
+	return []exportRow{{at, "", "dli", day.DLI}}
+}
+
+func writeExport(w io.Writer, rows []exportRow) error {
+	c := csv.NewWriter(w)
+	c.Write([]string{"time", "fixture", "series", "value"})
+	for _, row := range rows {
+		c.Write([]string{row.at.Format(time.RFC3339), row.fixture, row.series,
+			strconv.FormatFloat(row.value, 'g', -1, 64)})
+	}
+	c.Flush()
+	return c.Error()
+}
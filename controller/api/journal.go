@@ -0,0 +1,126 @@
+package api
+
+import (
+	"encoding/json"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/theatrus/ledbrick/controller/journal"
+	"github.com/theatrus/ledbrick/controller/report"
+)
+
+// How far back before a note its lighting context reaches by default
+const journalDays = 7
+
+// lightingContext is the lighting leading up to a journal note.
+type lightingContext struct {
+	// DLI is that of the day of the note, if a report covers it, and
+	// AverageDLI that of the days before it in the context
+	DLI        *float64 `json:"dli,omitempty"`
+	AverageDLI *float64 `json:"average_dli,omitempty"`
+	// ScheduleChanges are when lighting tables were applied in the
+	// days before the note, up to it
+	ScheduleChanges []time.Time `json:"schedule_changes"`
+}
+
+type journalEntry struct {
+	journal.Entry
+	Lighting lightingContext `json:"lighting"`
+}
+
+// handleJournal lists the tank journal with GET, each note with the
+// lighting leading up to it, the ?days= before it, a week by default.
+// ?tag= lists only notes with a tag. POST writes a note.
+func (s *Server) handleJournal(w http.ResponseWriter, r *http.Request) {
+	if s.Journal == nil {
+		writeError(w, "no journal", http.StatusNotFound)
+		return
+	}
+	switch r.Method {
+	case "GET":
+		days := journalDays
+		if v := r.URL.Query().Get("days"); v != "" {
+			var err error
+			if days, err = strconv.Atoi(v); err != nil || days < 0 {
+				writeError(w, "days must be a whole number of days", http.StatusBadRequest)
+				return
+			}
+		}
+		var reports []report.Report
+		if s.Reporter != nil {
+			reports = s.Reporter.Reports()
+		}
+		entries := make([]journalEntry, 0)
+		for _, e := range s.Journal.Entries(r.URL.Query().Get("tag"), s.tank) {
+			entries = append(entries, journalEntry{e, s.lightingBefore(e.At, days, reports)})
+		}
+		writeJson(w, entries)
+	case "POST":
+		var e journal.Entry
+		if err := json.NewDecoder(http.MaxBytesReader(w, r.Body, 1<<20)).Decode(&e); err != nil {
+			writeError(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		e.Tank = s.tank
+		e, err := s.Journal.Add(e)
+		if err != nil {
+			writeError(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		writeJson(w, e)
+	default:
+		writeError(w, "method not allowed", http.StatusMethodNotAllowed)
+	}
+}
+
+// handleJournalEntry deletes a note with DELETE /journal/<id>.
+func (s *Server) handleJournalEntry(w http.ResponseWriter, r *http.Request) {
+	if s.Journal == nil {
+		writeError(w, "no journal", http.StatusNotFound)
+		return
+	}
+	if r.Method != "DELETE" {
+		writeError(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	id, err := strconv.Atoi(strings.TrimPrefix(r.URL.Path, "/journal/"))
+	if err != nil {
+		writeError(w, "bad journal entry id", http.StatusBadRequest)
+		return
+	}
+	if err := s.Journal.Delete(id); err != nil {
+		writeError(w, err.Error(), http.StatusNotFound)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// lightingBefore gathers the lighting of the days up to a time from the
+// daily reports and the journal's record of tables applied.
+func (s *Server) lightingBefore(at time.Time, days int, reports []report.Report) lightingContext {
+	from := at.AddDate(0, 0, -days)
+	c := lightingContext{ScheduleChanges: s.Journal.Changes(from, at)}
+	// Days are YYYY-MM-DD, so compare as strings
+	day, first := dayKeyOf(at), dayKeyOf(from)
+	sum, n := 0.0, 0
+	for i := range reports {
+		switch d := reports[i].Day; {
+		case d == day:
+			c.DLI = &reports[i].DLI
+		case d >= first && d < day:
+			sum += reports[i].DLI
+			n++
+		}
+	}
+	if n > 0 {
+		avg := sum / float64(n)
+		c.AverageDLI = &avg
+	}
+	return c
+}
+
+func dayKeyOf(t time.Time) string {
+	return t.Local().Format("2006-01-02")
+}
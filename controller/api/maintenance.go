@@ -0,0 +1,61 @@
+package api
+
+import (
+	"flag"
+	"net/http"
+
+	"github.com/theatrus/ledbrick/controller/alert"
+	"github.com/theatrus/ledbrick/controller/plugs"
+)
+
+var maintenancePercent float64
+
+func init() {
+	flag.Float64Var(&maintenancePercent, "api.maintenance.percent", 30,
+		"Output percent to limit all fixtures to in maintenance mode")
+}
+
+const maintenanceName = "maintenance"
+
+type maintenanceResponse struct {
+	On bool `json:"on"`
+}
+
+// handleMaintenance puts the tank into maintenance mode with POST, as
+// feed mode does: output limited, effects held off and the maintenance
+// scene's plugs switched. DELETE ends it. It is what a phone alert's
+// maintenance button calls. The maintenance scene's plugs are the
+// controller's, so a tank's maintenance mode leaves them be.
+func (s *Server) handleMaintenance(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case "POST":
+		if err := s.startMaintenance(requester(r)); err != nil {
+			writeError(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		writeJson(w, maintenanceResponse{On: true})
+	case "DELETE":
+		s.ble.ClearLimit(maintenanceName)
+		s.ble.ResumeEffects(maintenanceName)
+		if s.tank == "" {
+			plugs.Deactivate(maintenanceName)
+		}
+		alert.Raise(alert.Info, maintenanceName, "maintenance.mode", "maintenance mode off by %s", requester(r))
+		writeJson(w, maintenanceResponse{})
+	default:
+		writeError(w, "method not allowed", http.StatusMethodNotAllowed)
+	}
+}
+
+func (s *Server) startMaintenance(by string) error {
+	if err := s.ble.SetLimit(maintenanceName, maintenancePercent); err != nil {
+		return err
+	}
+	s.ble.SuspendEffects(maintenanceName)
+	if s.tank == "" {
+		plugs.Activate(maintenanceName)
+	}
+	alert.Raise(alert.Info, maintenanceName, "maintenance.mode", "maintenance mode on by %s, limiting output to %.0f%%",
+		by, maintenancePercent)
+	return nil
+}
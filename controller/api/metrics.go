@@ -0,0 +1,126 @@
+package api
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"sort"
+
+	"github.com/theatrus/ledbrick/controller/ble"
+	"github.com/theatrus/ledbrick/controller/probe"
+)
+
+type metric struct {
+	name  string
+	help  string
+	value func(p peripheralStatus) float64
+}
+
+var fixtureMetrics = []metric{
+	{"ledbrick_temperature_celsius", "Fixture temperature.",
+		func(p peripheralStatus) float64 { return float64(p.Temperature) }},
+	{"ledbrick_fan_rpm", "Fixture fan speed.",
+		func(p peripheralStatus) float64 { return float64(p.FanRPM) }},
+	{"ledbrick_power_watts", "Estimated fixture power draw.",
+		func(p peripheralStatus) float64 { return p.Watts }},
+	{"ledbrick_energy_today_watt_hours", "Estimated fixture energy used today.",
+		func(p peripheralStatus) float64 { return p.EnergyToday }},
+	{"ledbrick_energy_month_watt_hours", "Estimated fixture energy used this month.",
+		func(p peripheralStatus) float64 { return p.EnergyMonth }},
+}
+
+// handleMetrics serves fixture state in the Prometheus text format.
+func (s *Server) handleMetrics(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4")
+	s.writeAllMetrics(w)
+}
+
+// writeAllMetrics writes every metric in the Prometheus text format.
+func (s *Server) writeAllMetrics(w io.Writer) {
+	writeMetrics(w, s.status())
+	writeAvailabilityMetrics(w, s.ble.Availability())
+	if s.Probes != nil {
+		writeProbeMetrics(w, s.Probes.Readings())
+	}
+	if c := s.color(s.ble.Channels()); c != nil {
+		writeColorMetrics(w, c)
+	}
+	var tanks []string
+	if s.tank == "" {
+		tanks = s.ble.Tanks()
+	}
+	writeChannelMetrics(w, s.ble, tanks)
+}
+
+// writeChannelMetrics writes each channel's setting, and each tank's
+// labelled with its name.
+func writeChannelMetrics(w io.Writer, b ble.BLEChannel, tanks []string) {
+	name := "ledbrick_channel_percent"
+	fmt.Fprintf(w, "# HELP %s Channel output setting.\n# TYPE %s gauge\n", name, name)
+	write := func(channels map[int]float64, tank string) {
+		var ids []int
+		for channel := range channels {
+			ids = append(ids, channel)
+		}
+		sort.Ints(ids)
+		for _, channel := range ids {
+			fmt.Fprintf(w, "%s{channel=\"%d\",name=%q", name, channel, channelName(channel))
+			if tank != "" {
+				fmt.Fprintf(w, ",tank=%q", tank)
+			}
+			fmt.Fprintf(w, "} %g\n", channels[channel])
+		}
+	}
+	write(b.Channels(), "")
+	for _, tank := range tanks {
+		write(b.Tank(tank).Channels(), tank)
+	}
+}
+
+func channelName(channel int) string {
+	if channel >= 0 && channel < len(ble.ChannelNames) {
+		return ble.ChannelNames[channel]
+	}
+	return ""
+}
+
+func writeColorMetrics(w io.Writer, c *colorStatus) {
+	for _, m := range []struct {
+		name, help string
+		value      float64
+	}{
+		{"ledbrick_cct_kelvin", "Correlated colour temperature of the output mix.", c.CCT},
+		{"ledbrick_duv", "Distance of the output mix from the black body locus.", c.Duv},
+	} {
+		fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s gauge\n%s %g\n", m.name, m.help, m.name, m.name, m.value)
+	}
+}
+
+func writeAvailabilityMetrics(w io.Writer, availability map[string]ble.Availability) {
+	name := "ledbrick_availability_ratio"
+	fmt.Fprintf(w, "# HELP %s Fraction of the window a fixture was connected.\n# TYPE %s gauge\n", name, name)
+	for id, a := range availability {
+		fmt.Fprintf(w, "%s{fixture=%q,window=\"24h\"} %g\n", name, id, a.Day)
+		fmt.Fprintf(w, "%s{fixture=%q,window=\"7d\"} %g\n", name, id, a.Week)
+	}
+}
+
+func writeProbeMetrics(w io.Writer, readings []probe.Reading) {
+	name := "ledbrick_probe_temperature_celsius"
+	fmt.Fprintf(w, "# HELP %s Host temperature probe reading.\n# TYPE %s gauge\n", name, name)
+	for _, r := range readings {
+		if r.Error != "" {
+			continue
+		}
+		fmt.Fprintf(w, "%s{probe=%q} %g\n", name, r.Name, r.Celsius)
+	}
+}
+
+func writeMetrics(w io.Writer, status []peripheralStatus) {
+	for _, m := range fixtureMetrics {
+		fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s gauge\n", m.name, m.help, m.name)
+		for _, p := range status {
+			fmt.Fprintf(w, "%s{fixture=%q} %g\n", m.name, p.ID, m.value(p))
+		}
+	}
+}
@@ -0,0 +1,441 @@
+package api
+
+import (
+	"crypto"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"math/big"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/theatrus/ledbrick/controller/wan"
+)
+
+var oidcIssuer string
+var oidcClient string
+var oidcSecret string
+var oidcRedirect string
+var oidcRoles string
+var oidcSession time.Duration
+var oidcScopes string
+
+func init() {
+	flag.StringVar(&oidcIssuer, "api.oidc.issuer", "",
+		"OpenID Connect issuer URL to log in to the dashboard with, disabled if empty")
+	flag.StringVar(&oidcClient, "api.oidc.client", "", "OpenID Connect client ID")
+	flag.StringVar(&oidcSecret, "api.oidc.secret", "", "OpenID Connect client secret")
+	flag.StringVar(&oidcRedirect, "api.oidc.redirect", "",
+		"URL of this API's /auth/callback as registered with the issuer")
+	flag.StringVar(&oidcRoles, "api.oidc.roles", "",
+		"Comma separated group=scope pairs giving members of each group a scope, e.g. reefers=configure,family=read")
+	flag.StringVar(&oidcScopes, "api.oidc.scopes", "openid profile email groups",
+		"OpenID Connect scopes to ask for, which must get the groups claim into the ID token")
+	flag.DurationVar(&oidcSession, "api.oidc.session", 12*time.Hour, "How long a dashboard login lasts")
+}
+
+const (
+	sessionCookie = "ledbrick_session"
+	stateCookie   = "ledbrick_oidc_state"
+)
+
+// oidc logs dashboard users in with an OpenID Connect provider by the
+// authorization code flow, giving each a session cookie with the
+// highest scope any of their groups maps to.
+type oidc struct {
+	roles map[string]role
+	// sessionKey signs session cookies. It is made afresh each start, so
+	// restarting logs everyone out.
+	sessionKey []byte
+
+	lock     sync.Mutex
+	provider *oidcProvider
+	keys     map[string]*rsa.PublicKey
+	// fetched is when the key set was last fetched
+	fetched time.Time
+}
+
+// How long after fetching the key set a token signed with an unknown
+// key is refused rather than the set fetched again, so anyone can't
+// make the controller hammer the provider with made up key IDs
+const jwksRefetch = time.Minute
+
+// oidcProvider is the issuer's discovery document.
+type oidcProvider struct {
+	Issuer                string `json:"issuer"`
+	AuthorizationEndpoint string `json:"authorization_endpoint"`
+	TokenEndpoint         string `json:"token_endpoint"`
+	JWKSURI               string `json:"jwks_uri"`
+}
+
+// login is the OIDC login, or nil if it isn't configured.
+var login *oidc
+
+// loadOIDC sets up the login from the -api.oidc flags.
+func loadOIDC() error {
+	if oidcIssuer == "" {
+		login = nil
+		return nil
+	}
+	if oidcClient == "" || oidcRedirect == "" {
+		return errors.New("-api.oidc.issuer needs -api.oidc.client and -api.oidc.redirect")
+	}
+	o := &oidc{roles: make(map[string]role), sessionKey: make([]byte, 32)}
+	if _, err := rand.Read(o.sessionKey); err != nil {
+		return err
+	}
+	for _, pair := range strings.Split(oidcRoles, ",") {
+		if strings.TrimSpace(pair) == "" {
+			continue
+		}
+		parts := strings.SplitN(pair, "=", 2)
+		r, ok := roleNames[strings.TrimSpace(parts[len(parts)-1])]
+		if len(parts) != 2 || !ok {
+			return fmt.Errorf("bad -api.oidc.roles entry %q, expected group=scope", pair)
+		}
+		o.roles[strings.TrimSpace(parts[0])] = r
+	}
+	if len(o.roles) == 0 {
+		return errors.New("-api.oidc.issuer needs -api.oidc.roles, or nobody could log in")
+	}
+	login = o
+	return nil
+}
+
+// discover fetches the provider's endpoints the first time they are
+// needed, so the API starts even if the provider is down.
+func (o *oidc) discover() (*oidcProvider, error) {
+	o.lock.Lock()
+	defer o.lock.Unlock()
+	if o.provider != nil {
+		return o.provider, nil
+	}
+	var p oidcProvider
+	if err := getJSON(strings.TrimSuffix(oidcIssuer, "/")+"/.well-known/openid-configuration", &p); err != nil {
+		return nil, err
+	}
+	if p.Issuer != oidcIssuer || p.AuthorizationEndpoint == "" || p.TokenEndpoint == "" || p.JWKSURI == "" {
+		return nil, errors.New("incomplete OpenID Connect discovery document")
+	}
+	o.provider = &p
+	return &p, nil
+}
+
+var oidcHTTP = &http.Client{Timeout: 10 * time.Second}
+
+// getJSON fetches from the provider, reporting whether it was reached.
+// Without it no one can sign in, but API keys still work.
+func getJSON(u string, v interface{}) error {
+	err := fetchJSON(u, v)
+	wan.Report("oidc", err, "only API keys accepted")
+	return err
+}
+
+func fetchJSON(u string, v interface{}) error {
+	resp, err := oidcHTTP.Get(u)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("GET %s: %s", u, resp.Status)
+	}
+	return json.NewDecoder(resp.Body).Decode(v)
+}
+
+// key returns the provider's signing key with an ID, fetching the key
+// set again if it isn't known, as providers rotate keys, but at most
+// once every jwksRefetch.
+func (o *oidc) key(p *oidcProvider, kid string) (*rsa.PublicKey, error) {
+	o.lock.Lock()
+	defer o.lock.Unlock()
+	if k, ok := o.keys[kid]; ok {
+		return k, nil
+	}
+	if time.Since(o.fetched) < jwksRefetch {
+		return nil, fmt.Errorf("no signing key %q", kid)
+	}
+	o.fetched = time.Now()
+	var set struct {
+		Keys []struct {
+			Kid string `json:"kid"`
+			Kty string `json:"kty"`
+			N   string `json:"n"`
+			E   string `json:"e"`
+		} `json:"keys"`
+	}
+	if err := getJSON(p.JWKSURI, &set); err != nil {
+		return nil, err
+	}
+	o.keys = make(map[string]*rsa.PublicKey)
+	for _, k := range set.Keys {
+		if k.Kty != "RSA" {
+			continue
+		}
+		n, err1 := base64.RawURLEncoding.DecodeString(k.N)
+		e, err2 := base64.RawURLEncoding.DecodeString(k.E)
+		if err1 != nil || err2 != nil {
+			continue
+		}
+		o.keys[k.Kid] = &rsa.PublicKey{N: new(big.Int).SetBytes(n), E: int(new(big.Int).SetBytes(e).Int64())}
+	}
+	k, ok := o.keys[kid]
+	if !ok {
+		return nil, fmt.Errorf("no signing key %q", kid)
+	}
+	return k, nil
+}
+
+// idClaims are the claims read from an ID token.
+type idClaims struct {
+	Issuer   string          `json:"iss"`
+	Subject  string          `json:"sub"`
+	Audience json.RawMessage `json:"aud"`
+	Expiry   int64           `json:"exp"`
+	Nonce    string          `json:"nonce"`
+	Email    string          `json:"email"`
+	Name     string          `json:"preferred_username"`
+	Groups   []string        `json:"groups"`
+}
+
+// verify checks an ID token's RS256 signature, issuer, audience,
+// expiry and nonce, returning its claims.
+func (o *oidc) verify(p *oidcProvider, token, nonce string) (idClaims, error) {
+	var c idClaims
+	parts := strings.Split(token, ".")
+	if len(parts) != 3 {
+		return c, errors.New("malformed ID token")
+	}
+	var header struct {
+		Alg string `json:"alg"`
+		Kid string `json:"kid"`
+	}
+	if err := decodeSegment(parts[0], &header); err != nil {
+		return c, err
+	}
+	if header.Alg != "RS256" {
+		return c, fmt.Errorf("unsupported ID token algorithm %q", header.Alg)
+	}
+	key, err := o.key(p, header.Kid)
+	if err != nil {
+		return c, err
+	}
+	sig, err := base64.RawURLEncoding.DecodeString(parts[2])
+	if err != nil {
+		return c, err
+	}
+	sum := sha256.Sum256([]byte(parts[0] + "." + parts[1]))
+	if err := rsa.VerifyPKCS1v15(key, crypto.SHA256, sum[:], sig); err != nil {
+		return c, errors.New("bad ID token signature")
+	}
+	if err := decodeSegment(parts[1], &c); err != nil {
+		return c, err
+	}
+
+	var audiences []string
+	if json.Unmarshal(c.Audience, &audiences) != nil {
+		var single string
+		json.Unmarshal(c.Audience, &single)
+		audiences = []string{single}
+	}
+	switch {
+	case c.Issuer != p.Issuer:
+		return c, errors.New("ID token from the wrong issuer")
+	case !contains(audiences, oidcClient):
+		return c, errors.New("ID token for another client")
+	case time.Now().Unix() >= c.Expiry:
+		return c, errors.New("ID token expired")
+	case differ(c.Nonce, nonce):
+		return c, errors.New("ID token nonce mismatch")
+	}
+	return c, nil
+}
+
+// differ reports whether two secrets differ, in constant time.
+func differ(a, b string) bool {
+	return !hmac.Equal([]byte(a), []byte(b))
+}
+
+func decodeSegment(s string, v interface{}) error {
+	data, err := base64.RawURLEncoding.DecodeString(s)
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(data, v)
+}
+
+// role returns the highest scope any of a user's groups has.
+func (o *oidc) role(groups []string) role {
+	best := noRole
+	for _, g := range groups {
+		if r := o.roles[g]; r > best {
+			best = r
+		}
+	}
+	return best
+}
+
+// session is what a session cookie holds, before its signature.
+type session struct {
+	Name   string `json:"name"`
+	Scope  role   `json:"scope"`
+	Expiry int64  `json:"exp"`
+}
+
+func (o *oidc) sign(payload string) string {
+	mac := hmac.New(sha256.New, o.sessionKey)
+	mac.Write([]byte(payload))
+	return base64.RawURLEncoding.EncodeToString(mac.Sum(nil))
+}
+
+// sessionFor returns who a request's session cookie is from, or false
+// if it has no valid one.
+func (o *oidc) sessionFor(r *http.Request) (principal, bool) {
+	cookie, err := r.Cookie(sessionCookie)
+	if err != nil {
+		return principal{}, false
+	}
+	parts := strings.Split(cookie.Value, ".")
+	if len(parts) != 2 || differ(o.sign(parts[0]), parts[1]) {
+		return principal{}, false
+	}
+	var s session
+	if decodeSegment(parts[0], &s) != nil || time.Now().Unix() >= s.Expiry {
+		return principal{}, false
+	}
+	return principal{name: s.Name, role: s.Scope}, true
+}
+
+func randomString() (string, error) {
+	b := make([]byte, 24)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return base64.RawURLEncoding.EncodeToString(b), nil
+}
+
+// secure is whether cookies should only be sent over HTTPS, as they
+// are when the callback is.
+func secure() bool {
+	return strings.HasPrefix(oidcRedirect, "https://")
+}
+
+// handleLogin sends the browser to the provider to log in, remembering
+// a random state to check on the way back.
+func (s *Server) handleLogin(w http.ResponseWriter, r *http.Request) {
+	if login == nil {
+		writeError(w, "no OpenID Connect login", http.StatusNotFound)
+		return
+	}
+	p, err := login.discover()
+	if err != nil {
+		writeError(w, err.Error(), http.StatusBadGateway)
+		return
+	}
+	state, err := randomString()
+	if err != nil {
+		writeError(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	http.SetCookie(w, &http.Cookie{Name: stateCookie, Value: state, Path: "/auth/",
+		MaxAge: 600, HttpOnly: true, Secure: secure(), SameSite: http.SameSiteLaxMode})
+	q := url.Values{
+		"response_type": {"code"},
+		"client_id":     {oidcClient},
+		"redirect_uri":  {oidcRedirect},
+		"scope":         {oidcScopes},
+		"state":         {state},
+		"nonce":         {state},
+	}
+	http.Redirect(w, r, p.AuthorizationEndpoint+"?"+q.Encode(), http.StatusFound)
+}
+
+// handleCallback finishes a login: it swaps the code for an ID token,
+// checks it, and gives the browser a session with the user's scope.
+func (s *Server) handleCallback(w http.ResponseWriter, r *http.Request) {
+	if login == nil {
+		writeError(w, "no OpenID Connect login", http.StatusNotFound)
+		return
+	}
+	state, err := r.Cookie(stateCookie)
+	q := r.URL.Query()
+	if err != nil || q.Get("state") == "" || differ(state.Value, q.Get("state")) {
+		writeError(w, "login state mismatch, try again", http.StatusBadRequest)
+		return
+	}
+	if e := q.Get("error"); e != "" {
+		writeError(w, "login failed: "+e, http.StatusUnauthorized)
+		return
+	}
+	p, err := login.discover()
+	if err != nil {
+		writeError(w, err.Error(), http.StatusBadGateway)
+		return
+	}
+	resp, err := oidcHTTP.PostForm(p.TokenEndpoint, url.Values{
+		"grant_type":    {"authorization_code"},
+		"code":          {q.Get("code")},
+		"redirect_uri":  {oidcRedirect},
+		"client_id":     {oidcClient},
+		"client_secret": {oidcSecret},
+	})
+	if err != nil {
+		writeError(w, err.Error(), http.StatusBadGateway)
+		return
+	}
+	defer resp.Body.Close()
+	var tokens struct {
+		IDToken string `json:"id_token"`
+	}
+	if resp.StatusCode != http.StatusOK || json.NewDecoder(resp.Body).Decode(&tokens) != nil {
+		writeError(w, "token exchange failed: "+resp.Status, http.StatusBadGateway)
+		return
+	}
+	claims, err := login.verify(p, tokens.IDToken, state.Value)
+	if err != nil {
+		writeError(w, err.Error(), http.StatusUnauthorized)
+		return
+	}
+	scope := login.role(claims.Groups)
+	if scope == noRole {
+		writeError(w, "none of your groups may use this controller", http.StatusForbidden)
+		return
+	}
+
+	name := claims.Name
+	if name == "" {
+		name = claims.Email
+	}
+	if name == "" {
+		name = claims.Subject
+	}
+	expiry := time.Now().Add(oidcSession)
+	data, _ := json.Marshal(session{Name: "oidc:" + name, Scope: scope, Expiry: expiry.Unix()})
+	payload := base64.RawURLEncoding.EncodeToString(data)
+	// Lax keeps the cookie off requests from other sites which change
+	// anything
+	http.SetCookie(w, &http.Cookie{Name: sessionCookie, Value: payload + "." + login.sign(payload),
+		Path: "/", Expires: expiry, HttpOnly: true, Secure: secure(), SameSite: http.SameSiteLaxMode})
+	http.SetCookie(w, &http.Cookie{Name: stateCookie, Path: "/auth/", MaxAge: -1})
+	http.Redirect(w, r, "/", http.StatusFound)
+}
+
+// handleLogout ends the browser's session. It only takes a POST, so
+// other sites can't log users out with a link or an image.
+func (s *Server) handleLogout(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "POST" {
+		writeError(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	http.SetCookie(w, &http.Cookie{Name: sessionCookie, Path: "/", MaxAge: -1})
+	w.WriteHeader(http.StatusNoContent)
+}
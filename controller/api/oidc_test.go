@@ -0,0 +1,133 @@
+package api
+
+import (
+	"crypto"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// signToken makes an RS256 ID token signed with key under a key ID.
+func signToken(t *testing.T, key *rsa.PrivateKey, kid string, claims map[string]interface{}) string {
+	header, _ := json.Marshal(map[string]string{"alg": "RS256", "kid": kid})
+	payload, _ := json.Marshal(claims)
+	signed := base64.RawURLEncoding.EncodeToString(header) + "." + base64.RawURLEncoding.EncodeToString(payload)
+	sum := sha256.Sum256([]byte(signed))
+	sig, err := rsa.SignPKCS1v15(rand.Reader, key, crypto.SHA256, sum[:])
+	if err != nil {
+		t.Fatal(err)
+	}
+	return signed + "." + base64.RawURLEncoding.EncodeToString(sig)
+}
+
+func TestVerifyIDToken(t *testing.T) {
+	defer func(client string) { oidcClient = client }(oidcClient)
+	oidcClient = "ledbrick"
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	other, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var fetches int32
+	jwks := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&fetches, 1)
+		json.NewEncoder(w).Encode(map[string]interface{}{"keys": []map[string]string{{
+			"kid": "k1",
+			"kty": "RSA",
+			"n":   base64.RawURLEncoding.EncodeToString(key.N.Bytes()),
+			"e":   base64.RawURLEncoding.EncodeToString(big.NewInt(int64(key.E)).Bytes()),
+		}}})
+	}))
+	defer jwks.Close()
+	p := &oidcProvider{Issuer: "https://id.example.com", JWKSURI: jwks.URL}
+	o := &oidc{}
+
+	claims := func(change func(map[string]interface{})) map[string]interface{} {
+		c := map[string]interface{}{
+			"iss":   p.Issuer,
+			"sub":   "1234",
+			"aud":   "ledbrick",
+			"exp":   time.Now().Add(time.Hour).Unix(),
+			"nonce": "n0nce",
+		}
+		if change != nil {
+			change(c)
+		}
+		return c
+	}
+	c, err := o.verify(p, signToken(t, key, "k1", claims(nil)), "n0nce")
+	if err != nil || c.Subject != "1234" {
+		t.Fatalf("Expected a good token verified, got %+v, %v", c, err)
+	}
+	c, err = o.verify(p, signToken(t, key, "k1", claims(func(c map[string]interface{}) {
+		c["aud"] = []string{"other", "ledbrick"}
+	})), "n0nce")
+	if err != nil {
+		t.Errorf("Expected a token for several audiences verified, got %v", err)
+	}
+
+	for _, tt := range []struct {
+		name  string
+		token string
+		want  string
+	}{
+		{"bad signature", signToken(t, other, "k1", claims(nil)), "bad ID token signature"},
+		{"expired", signToken(t, key, "k1", claims(func(c map[string]interface{}) {
+			c["exp"] = time.Now().Add(-time.Minute).Unix()
+		})), "ID token expired"},
+		{"wrong audience", signToken(t, key, "k1", claims(func(c map[string]interface{}) {
+			c["aud"] = "someone-else"
+		})), "ID token for another client"},
+		{"wrong issuer", signToken(t, key, "k1", claims(func(c map[string]interface{}) {
+			c["iss"] = "https://evil.example.com"
+		})), "ID token from the wrong issuer"},
+		{"wrong nonce", signToken(t, key, "k1", claims(func(c map[string]interface{}) {
+			c["nonce"] = "replayed"
+		})), "ID token nonce mismatch"},
+		{"malformed", "a.b", "malformed ID token"},
+	} {
+		if _, err := o.verify(p, tt.token, "n0nce"); err == nil || err.Error() != tt.want {
+			t.Errorf("%s: expected %q, got %v", tt.name, tt.want, err)
+		}
+	}
+
+	// Unknown key IDs fetch the key set again, but not every time
+	if n := atomic.LoadInt32(&fetches); n != 1 {
+		t.Fatalf("Expected the key set fetched once, got %d", n)
+	}
+	o.fetched = time.Now().Add(-jwksRefetch)
+	for i := 0; i < 5; i++ {
+		if _, err := o.verify(p, signToken(t, key, "made-up", claims(nil)), "n0nce"); err == nil {
+			t.Error("Expected an unknown key refused")
+		}
+	}
+	if n := atomic.LoadInt32(&fetches); n != 2 {
+		t.Errorf("Expected unknown keys to fetch the key set once more, got %d fetches", n-1)
+	}
+}
+
+func TestLogout(t *testing.T) {
+	s := &Server{}
+	w := httptest.NewRecorder()
+	s.handleLogout(w, httptest.NewRequest("GET", "/auth/logout", nil))
+	if w.Code != http.StatusMethodNotAllowed || w.Header().Get("Set-Cookie") != "" {
+		t.Errorf("Expected a GET not to log out, got %d", w.Code)
+	}
+	w = httptest.NewRecorder()
+	s.handleLogout(w, httptest.NewRequest("POST", "/auth/logout", nil))
+	if cookie := w.Header().Get("Set-Cookie"); w.Code != http.StatusNoContent || !strings.HasPrefix(cookie, sessionCookie+"=;") {
+		t.Errorf("Expected a POST to clear the session, got %d %q", w.Code, cookie)
+	}
+}
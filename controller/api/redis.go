@@ -0,0 +1,202 @@
+package api
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/theatrus/ledbrick/controller/alert"
+)
+
+var redisAddr string
+var redisPassword string
+var redisDB int
+var redisPrefix string
+var redisInterval time.Duration
+
+func init() {
+	flag.StringVar(&redisAddr, "api.redis", "",
+		"host:port of a Redis server to mirror controller state and events into, for scripts which already use Redis")
+	flag.StringVar(&redisPassword, "api.redis.password", "", "Password for -api.redis")
+	flag.IntVar(&redisDB, "api.redis.db", 0, "Redis database number for -api.redis")
+	flag.StringVar(&redisPrefix, "api.redis.prefix", "ledbrick:",
+		"Prefix of the Redis keys and channels, <prefix>state for the hash of current values and <prefix>events for events")
+	flag.DurationVar(&redisInterval, "api.redis.interval", 10*time.Second,
+		"How often the state in -api.redis is updated")
+}
+
+// redisEvent is published on the events channel: an alert, or a value
+// in the state hash changing.
+type redisEvent struct {
+	Type  string       `json:"type"`
+	Alert *alert.Alert `json:"alert,omitempty"`
+	Field string       `json:"field,omitempty"`
+	Value float64      `json:"value"`
+	At    time.Time    `json:"at"`
+}
+
+// redisNotifier queues alerts to be published, dropping them rather
+// than holding up other notifiers if Redis is slow.
+type redisNotifier chan redisEvent
+
+func (n redisNotifier) Notify(a alert.Alert) error {
+	select {
+	case n <- redisEvent{Type: "alert", Alert: &a, At: a.At}:
+		return nil
+	default:
+		return errors.New("redis events queue full")
+	}
+}
+
+// StartRedis mirrors every metric into a Redis hash, if -api.redis is
+// set, with fields named as in /metrics such as
+// fan_rpm{fixture="..."}. Alerts, and values as they change, are
+// published as JSON events. A lost connection is made again on the next
+// update.
+func StartRedis(s *Server) error {
+	if redisAddr == "" {
+		return nil
+	}
+	events := make(redisNotifier, 100)
+	alert.Register(events)
+	go func() {
+		var conn *redisConn
+		last := make(map[string]float64)
+		ticker := time.NewTicker(redisInterval)
+		for {
+			var batch []redisEvent
+			select {
+			case now := <-ticker.C:
+				args := []string{"HSET", redisPrefix + "state", "updated", now.Format(time.RFC3339)}
+				for _, sm := range s.gather(now) {
+					field := redisField(sm)
+					args = append(args, field, strconv.FormatFloat(sm.value, 'g', -1, 64))
+					if v, ok := last[field]; !ok || v != sm.value {
+						batch = append(batch, redisEvent{Type: "change", Field: field, Value: sm.value, At: now})
+						last[field] = sm.value
+					}
+				}
+				if conn, _ = redisSend(conn, args); conn == nil {
+					// Publish every value again once reconnected
+					last = make(map[string]float64)
+					continue
+				}
+			case e := <-events:
+				batch = append(batch, e)
+			}
+			for _, e := range batch {
+				data, _ := json.Marshal(e)
+				if conn, _ = redisSend(conn, []string{"PUBLISH", redisPrefix + "events", string(data)}); conn == nil {
+					break
+				}
+			}
+		}
+	}()
+	log.Printf("Mirroring state into Redis at %s every %s", redisAddr, redisInterval)
+	return nil
+}
+
+// redisField names a sample's field in the state hash.
+func redisField(sm sample) string {
+	name := strings.TrimPrefix(sm.name, "ledbrick_")
+	if len(sm.labels) == 0 {
+		return name
+	}
+	var labels []string
+	for _, l := range sm.labels {
+		labels = append(labels, fmt.Sprintf("%s=%q", l[0], l[1]))
+	}
+	return name + "{" + strings.Join(labels, ",") + "}"
+}
+
+// redisSend sends a command, connecting first if need be. It returns
+// the connection to use next, nil if it failed.
+func redisSend(conn *redisConn, args []string) (*redisConn, error) {
+	var err error
+	if conn == nil {
+		if conn, err = dialRedis(); err != nil {
+			log.Printf("Redis connection to %s failed: %v", redisAddr, err)
+			return nil, err
+		}
+	}
+	if _, err = conn.do(args...); err != nil {
+		log.Printf("Redis %s failed: %v", args[0], err)
+		conn.Close()
+		return nil, err
+	}
+	return conn, nil
+}
+
+// redisConn speaks just enough of the Redis protocol to send commands
+// and read their replies.
+type redisConn struct {
+	net.Conn
+	r *bufio.Reader
+}
+
+func dialRedis() (*redisConn, error) {
+	c, err := net.DialTimeout("tcp", redisAddr, 10*time.Second)
+	if err != nil {
+		return nil, err
+	}
+	conn := &redisConn{Conn: c, r: bufio.NewReader(c)}
+	if redisPassword != "" {
+		if _, err := conn.do("AUTH", redisPassword); err != nil {
+			conn.Close()
+			return nil, err
+		}
+	}
+	if redisDB != 0 {
+		if _, err := conn.do("SELECT", strconv.Itoa(redisDB)); err != nil {
+			conn.Close()
+			return nil, err
+		}
+	}
+	return conn, nil
+}
+
+// do sends a command and returns its reply, which must be a status, an
+// integer or a string.
+func (c *redisConn) do(args ...string) (string, error) {
+	c.SetDeadline(time.Now().Add(10 * time.Second))
+	cmd := fmt.Sprintf("*%d\r\n", len(args))
+	for _, arg := range args {
+		cmd += fmt.Sprintf("$%d\r\n%s\r\n", len(arg), arg)
+	}
+	if _, err := io.WriteString(c, cmd); err != nil {
+		return "", err
+	}
+	line, err := c.r.ReadString('\n')
+	if err != nil {
+		return "", err
+	}
+	line = strings.TrimRight(line, "\r\n")
+	if line == "" {
+		return "", errors.New("empty reply")
+	}
+	switch line[0] {
+	case '+', ':':
+		return line[1:], nil
+	case '-':
+		return "", errors.New(line[1:])
+	case '$':
+		n, err := strconv.Atoi(line[1:])
+		if err != nil || n < 0 {
+			return "", err
+		}
+		data := make([]byte, n+2)
+		if _, err := io.ReadFull(c.r, data); err != nil {
+			return "", err
+		}
+		return string(data[:n]), nil
+	}
+	return "", fmt.Errorf("unexpected reply %q", line)
+}
@@ -0,0 +1,60 @@
+package api
+
+import (
+	"bufio"
+	"bytes"
+	"net"
+	"strings"
+	"testing"
+	"time"
+)
+
+// fakeRedis is a connection to a Redis server which sends back canned
+// replies and records the commands written to it.
+type fakeRedis struct {
+	net.Conn
+	replies *strings.Reader
+	written bytes.Buffer
+}
+
+func (f *fakeRedis) Read(b []byte) (int, error)    { return f.replies.Read(b) }
+func (f *fakeRedis) Write(b []byte) (int, error)   { return f.written.Write(b) }
+func (f *fakeRedis) SetDeadline(t time.Time) error { return nil }
+func (f *fakeRedis) Close() error                  { return nil }
+
+func newFakeRedis(replies string) (*redisConn, *fakeRedis) {
+	f := &fakeRedis{replies: strings.NewReader(replies)}
+	return &redisConn{Conn: f, r: bufio.NewReader(f)}, f
+}
+
+func TestRedisCommand(t *testing.T) {
+	c, f := newFakeRedis(":2\r\n")
+	got, err := c.do("HSET", "ledbrick:state", "temperature{fixture=\"a\"}", "31.5", "note", "café")
+	if err != nil || got != "2" {
+		t.Errorf("Expected the integer reply 2, got %q, %v", got, err)
+	}
+	want := "*6\r\n$4\r\nHSET\r\n$14\r\nledbrick:state\r\n$24\r\ntemperature{fixture=\"a\"}\r\n" +
+		"$4\r\n31.5\r\n$4\r\nnote\r\n$5\r\ncafé\r\n"
+	if f.written.String() != want {
+		t.Errorf("Expected the command\n%q\ngot\n%q", want, f.written.String())
+	}
+}
+
+func TestRedisReplies(t *testing.T) {
+	c, _ := newFakeRedis("+OK\r\n$5\r\nhello\r\n$-1\r\n-ERR wrong number of arguments\r\n+PONG\r\n")
+	for _, want := range []string{"OK", "hello", ""} {
+		if got, err := c.do("GET", "k"); err != nil || got != want {
+			t.Errorf("Expected %q, got %q, %v", want, got, err)
+		}
+	}
+	if _, err := c.do("HSET"); err == nil || err.Error() != "ERR wrong number of arguments" {
+		t.Errorf("Expected the error reply returned, got %v", err)
+	}
+	// and the connection is still in step after it
+	if got, err := c.do("PING"); err != nil || got != "PONG" {
+		t.Errorf("Expected PONG after an error reply, got %q, %v", got, err)
+	}
+	if _, err := c.do("PING"); err == nil {
+		t.Error("Expected a closed connection to fail")
+	}
+}
@@ -0,0 +1,265 @@
+package api
+
+import (
+	"bytes"
+	"encoding/binary"
+	"flag"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"log"
+	"math"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/theatrus/ledbrick/controller/alert"
+	"github.com/theatrus/ledbrick/controller/wan"
+)
+
+var remoteURL string
+var remoteFormat string
+var remoteUser string
+var remotePassword string
+var remoteInterval time.Duration
+var remoteBuffer time.Duration
+
+func init() {
+	flag.StringVar(&remoteURL, "api.remote.url", "",
+		"URL to push metrics to, such as a Prometheus remote_write endpoint on Grafana Cloud, for those without a local monitoring stack")
+	flag.StringVar(&remoteFormat, "api.remote.format", "remote_write",
+		"Format metrics are pushed in, remote_write or influx for the InfluxDB line protocol")
+	flag.StringVar(&remoteUser, "api.remote.user", "",
+		"Basic auth user for -api.remote.url, such as a Grafana Cloud instance ID")
+	flag.StringVar(&remotePassword, "api.remote.password", "",
+		"Basic auth password or API key for -api.remote.url, or a bearer token without a user")
+	flag.DurationVar(&remoteInterval, "api.remote.interval", 30*time.Second,
+		"How often metrics are pushed to -api.remote.url")
+	flag.DurationVar(&remoteBuffer, "api.remote.buffer", time.Hour,
+		"How long metrics are held to push again while -api.remote.url can't be reached")
+}
+
+// sample is one value of a metric, with its labels sorted by name.
+type sample struct {
+	name   string
+	labels [][2]string
+	value  float64
+	at     time.Time
+}
+
+// gather reads the metrics back from the scrape, all taken at one time.
+func (s *Server) gather(at time.Time) []sample {
+	var buf bytes.Buffer
+	s.writeAllMetrics(&buf)
+	samples, err := parseMetrics(buf.String(), at)
+	if err != nil {
+		log.Printf("Metrics not gathered: %v", err)
+	}
+	return samples
+}
+
+// parseMetrics reads the samples from the Prometheus text format as
+// writeAllMetrics writes it.
+func parseMetrics(text string, at time.Time) ([]sample, error) {
+	var samples []sample
+	for _, line := range strings.Split(text, "\n") {
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		sm := sample{at: at}
+		end := strings.IndexAny(line, "{ ")
+		if end < 0 {
+			return samples, fmt.Errorf("bad metric %q", line)
+		}
+		sm.name, line = line[:end], line[end:]
+		if strings.HasPrefix(line, "{") {
+			line = line[1:]
+			for !strings.HasPrefix(line, "}") {
+				eq := strings.Index(line, "=")
+				if eq < 0 {
+					return samples, fmt.Errorf("bad labels on %s", sm.name)
+				}
+				quoted, err := strconv.QuotedPrefix(line[eq+1:])
+				if err != nil {
+					return samples, fmt.Errorf("bad labels on %s: %v", sm.name, err)
+				}
+				value, _ := strconv.Unquote(quoted)
+				sm.labels = append(sm.labels, [2]string{line[:eq], value})
+				line = strings.TrimPrefix(line[eq+1+len(quoted):], ",")
+			}
+			line = line[1:]
+		}
+		value, err := strconv.ParseFloat(strings.TrimSpace(line), 64)
+		if err != nil {
+			return samples, fmt.Errorf("bad value for %s: %v", sm.name, err)
+		}
+		sm.value = value
+		sort.Slice(sm.labels, func(i, j int) bool { return sm.labels[i][0] < sm.labels[j][0] })
+		samples = append(samples, sm)
+	}
+	return samples, nil
+}
+
+// StartRemoteWrite pushes the metrics to -api.remote.url, if it is set,
+// every -api.remote.interval. While the remote can't be reached metrics
+// are held, for up to -api.remote.buffer, and pushed once it can.
+func StartRemoteWrite(s *Server) error {
+	if remoteURL == "" {
+		return nil
+	}
+	var encode func([]sample) []byte
+	switch remoteFormat {
+	case "remote_write":
+		encode = encodeRemoteWrite
+	case "influx":
+		encode = encodeInflux
+	default:
+		return fmt.Errorf("-api.remote.format must be remote_write or influx, not %q", remoteFormat)
+	}
+	go func() {
+		var held [][]sample
+		failing := false
+		for now := range time.Tick(remoteInterval) {
+			if samples := s.gather(now); len(samples) > 0 {
+				held = append(held, samples)
+			}
+			for len(held) > 0 && now.Sub(held[0][0].at) > remoteBuffer {
+				held = held[1:]
+			}
+			for len(held) > 0 {
+				err := pushMetrics(encode(held[0]))
+				// A rejected push still reached the remote
+				reached := err
+				if err == errRejected {
+					reached = nil
+				}
+				wan.Report("metrics.remote", reached, fmt.Sprintf("holding metrics for up to %s", remoteBuffer))
+				if err == errRejected {
+					log.Printf("Metrics from %s rejected by %s, dropped", held[0][0].at.Format(time.RFC3339), remoteURL)
+				} else if err != nil {
+					if !failing {
+						alert.Raise(alert.Warning, "metrics", "metrics.remote",
+							"metrics push failed, holding them for up to %s: %v", remoteBuffer, err)
+					}
+					failing = true
+					break
+				}
+				held = held[1:]
+				if failing && len(held) == 0 {
+					log.Printf("Metrics pushing to %s again", remoteURL)
+					failing = false
+				}
+			}
+		}
+	}()
+	log.Printf("Pushing metrics to %s every %s", remoteURL, remoteInterval)
+	return nil
+}
+
+// errRejected is a push the remote won't ever take, so isn't retried.
+var errRejected = fmt.Errorf("rejected")
+
+func pushMetrics(body []byte) error {
+	req, err := http.NewRequest("POST", remoteURL, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	if remoteFormat == "remote_write" {
+		req.Header.Set("Content-Type", "application/x-protobuf")
+		req.Header.Set("Content-Encoding", "snappy")
+		req.Header.Set("X-Prometheus-Remote-Write-Version", "0.1.0")
+	} else {
+		req.Header.Set("Content-Type", "text/plain; charset=utf-8")
+	}
+	switch {
+	case remoteUser != "":
+		req.SetBasicAuth(remoteUser, remotePassword)
+	case remotePassword != "":
+		req.Header.Set("Authorization", "Bearer "+remotePassword)
+	}
+	client := http.Client{Timeout: 30 * time.Second}
+	resp, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	io.Copy(ioutil.Discard, resp.Body)
+	switch {
+	case resp.StatusCode/100 == 2:
+		return nil
+	case resp.StatusCode/100 == 4 && resp.StatusCode != http.StatusTooManyRequests:
+		return errRejected
+	}
+	return fmt.Errorf("%s", resp.Status)
+}
+
+// encodeRemoteWrite encodes samples as a snappy compressed protobuf
+// WriteRequest, written out by hand as it is so small.
+func encodeRemoteWrite(samples []sample) []byte {
+	var req []byte
+	for _, sm := range samples {
+		var series []byte
+		labels := append([][2]string{{"__name__", sm.name}}, sm.labels...)
+		for _, l := range labels {
+			var label []byte
+			label = appendBytes(label, 1, []byte(l[0]))
+			label = appendBytes(label, 2, []byte(l[1]))
+			series = appendBytes(series, 1, label)
+		}
+		var value []byte
+		value = append(value, 1<<3|1)
+		value = binary.LittleEndian.AppendUint64(value, math.Float64bits(sm.value))
+		value = append(value, 2<<3)
+		value = binary.AppendUvarint(value, uint64(sm.at.UnixNano()/int64(time.Millisecond)))
+		series = appendBytes(series, 2, value)
+		req = appendBytes(req, 1, series)
+	}
+	return snappyBlock(req)
+}
+
+// appendBytes appends a length delimited protobuf field.
+func appendBytes(b []byte, field int, value []byte) []byte {
+	b = binary.AppendUvarint(b, uint64(field<<3|2))
+	b = binary.AppendUvarint(b, uint64(len(value)))
+	return append(b, value...)
+}
+
+// snappyBlock frames data as a snappy block of literals. It isn't any
+// smaller, but is what remote_write takes.
+func snappyBlock(data []byte) []byte {
+	b := binary.AppendUvarint(nil, uint64(len(data)))
+	for len(data) > 0 {
+		n := len(data)
+		if n > 65536 {
+			n = 65536
+		}
+		if n <= 60 {
+			b = append(b, byte(n-1)<<2)
+		} else {
+			b = append(b, 62<<2, byte(n-1), byte((n-1)>>8), byte((n-1)>>16))
+		}
+		b = append(b, data[:n]...)
+		data = data[n:]
+	}
+	return b
+}
+
+var influxEscape = strings.NewReplacer(",", `\,`, " ", `\ `, "=", `\=`)
+
+// encodeInflux encodes samples in the InfluxDB line protocol, with
+// labels as tags.
+func encodeInflux(samples []sample) []byte {
+	var b bytes.Buffer
+	for _, sm := range samples {
+		b.WriteString(influxEscape.Replace(sm.name))
+		for _, l := range sm.labels {
+			if l[1] != "" {
+				fmt.Fprintf(&b, ",%s=%s", influxEscape.Replace(l[0]), influxEscape.Replace(l[1]))
+			}
+		}
+		fmt.Fprintf(&b, " value=%g %d\n", sm.value, sm.at.UnixNano())
+	}
+	return b.Bytes()
+}
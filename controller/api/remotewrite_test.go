@@ -0,0 +1,69 @@
+package api
+
+import (
+	"bytes"
+	"testing"
+	"time"
+)
+
+func TestEncodeRemoteWrite(t *testing.T) {
+	at := time.Unix(1, 0)
+	got := encodeRemoteWrite([]sample{{name: "up", labels: [][2]string{{"job", "x"}}, value: 1, at: at}})
+
+	// WriteRequest{timeseries: [TimeSeries{labels: [__name__=up, job=x],
+	// samples: [Sample{value: 1, timestamp: 1000}]}]}
+	var req []byte
+	req = append(req, 0x0a, 40)
+	req = append(req, 0x0a, 14, 0x0a, 8)
+	req = append(req, "__name__"...)
+	req = append(req, 0x12, 2, 'u', 'p')
+	req = append(req, 0x0a, 8, 0x0a, 3, 'j', 'o', 'b', 0x12, 1, 'x')
+	req = append(req, 0x12, 12, 0x09, 0, 0, 0, 0, 0, 0, 0xf0, 0x3f, 0x10, 0xe8, 0x07)
+	// as one snappy literal
+	want := append([]byte{42, 41 << 2}, req...)
+	if !bytes.Equal(got, want) {
+		t.Errorf("Expected\n% x\ngot\n% x", want, got)
+	}
+}
+
+func TestSnappyBlock(t *testing.T) {
+	for _, tc := range []struct {
+		n      int
+		header []byte
+	}{
+		{1, []byte{1, 0 << 2}},
+		{60, []byte{60, 59 << 2}},
+		{61, []byte{61, 62 << 2, 60, 0, 0}},
+		{256, []byte{0x80, 0x02, 62 << 2, 0xff, 0, 0}},
+		{300, []byte{0xac, 0x02, 62 << 2, 0x2b, 0x01, 0}},
+	} {
+		data := bytes.Repeat([]byte{'a'}, tc.n)
+		got := snappyBlock(data)
+		if want := append(tc.header, data...); !bytes.Equal(got, want) {
+			t.Errorf("%d bytes: expected header % x, got % x", tc.n, tc.header, got[:len(got)-tc.n])
+		}
+	}
+
+	// Literals are at most 64k each
+	data := bytes.Repeat([]byte{'a'}, 65537)
+	got := snappyBlock(data)
+	want := []byte{0x81, 0x80, 0x04, 62 << 2, 0xff, 0xff, 0}
+	if !bytes.Equal(got[:len(want)], want) {
+		t.Errorf("Expected the first literal header % x, got % x", want, got[:len(want)])
+	}
+	if rest := got[len(want)+65536:]; !bytes.Equal(rest, []byte{0 << 2, 'a'}) {
+		t.Errorf("Expected a second literal of one byte, got % x", rest)
+	}
+}
+
+func TestEncodeInflux(t *testing.T) {
+	got := string(encodeInflux([]sample{
+		{name: "ledbrick temp", labels: [][2]string{{"fixture", "a b,c=d"}, {"tank", ""}}, value: 1.5, at: time.Unix(1, 0)},
+		{name: "ledbrick_up", value: 1, at: time.Unix(2, 0)},
+	}))
+	want := "ledbrick\\ temp,fixture=a\\ b\\,c\\=d value=1.5 1000000000\n" +
+		"ledbrick_up value=1 2000000000\n"
+	if got != want {
+		t.Errorf("Expected\n%s\ngot\n%s", want, got)
+	}
+}
@@ -0,0 +1,191 @@
+package api
+
+import (
+	"encoding/json"
+	"math"
+	"net/http"
+	"time"
+
+	"github.com/theatrus/ledbrick/controller/ltable"
+	"github.com/theatrus/ledbrick/controller/spectrum"
+)
+
+type spectrumResponse struct {
+	At time.Time `json:"at"`
+	// Units are W/m2/nm where -report.par is calibrated, otherwise
+	// relative, with each channel's photon flux at full output 1
+	Units       string          `json:"units"`
+	Wavelengths []float64       `json:"wavelengths_nm"`
+	Values      spectrum.SPD    `json:"values"`
+	PPFD        float64         `json:"ppfd"`
+	Channels    map[int]float64 `json:"channels"`
+	Color       *colorStatus    `json:"color,omitempty"`
+}
+
+// colorStatus is the colour of the output mix, as CIE 1931 xy and its
+// correlated colour temperature and Duv.
+type colorStatus struct {
+	X   float64 `json:"x"`
+	Y   float64 `json:"y"`
+	CCT float64 `json:"cct_kelvin"`
+	Duv float64 `json:"duv"`
+}
+
+// color works out the colour of channels at the given percents, or nil
+// if there are no spectra or the channels are dark.
+func (s *Server) color(channels map[int]float64) *colorStatus {
+	if s.Spectra == nil {
+		return nil
+	}
+	mix := s.Spectra.Combine(channels)
+	x, y, err := mix.Chromaticity()
+	if err != nil {
+		return nil
+	}
+	cct, duv := spectrum.CCT(x, y)
+	return &colorStatus{X: x, Y: y, CCT: cct, Duv: duv}
+}
+
+// handleSpectrum shows the combined spectrum of the output now, or with
+// ?at= what the schedule gives then with the overrides active now, as
+// for /schedule/eval.
+func (s *Server) handleSpectrum(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "GET" {
+		writeError(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	if s.Spectra == nil {
+		writeError(w, "no spectra", http.StatusNotFound)
+		return
+	}
+	resp := spectrumResponse{At: time.Now(), Units: "relative", Channels: s.ble.Channels()}
+	if at := r.URL.Query().Get("at"); at != "" {
+		if s.Lights == nil {
+			writeError(w, "no schedule", http.StatusNotFound)
+			return
+		}
+		t, err := ltable.ParseTime(at, time.Now())
+		if err != nil {
+			writeError(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		e, err := s.Lights.Eval(t)
+		if err != nil {
+			writeError(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		resp.At = t
+		resp.Channels = make(map[int]float64)
+		for channel, v := range s.outputs(t, e.Percents) {
+			resp.Channels[channel] = v
+		}
+	}
+	if s.Spectra.Absolute {
+		resp.Units = "W/m2/nm"
+	}
+	resp.Values = s.Spectra.Combine(resp.Channels)
+	resp.PPFD = resp.Values.PPFD()
+	resp.Color = s.color(resp.Channels)
+	for i := range resp.Values {
+		resp.Wavelengths = append(resp.Wavelengths, spectrum.Wavelength(i))
+	}
+	writeJson(w, resp)
+}
+
+// colorRequest asks for a colour as x and y, or as kelvin and a tint
+// in Duv, at an intensity, held for a number of minutes.
+type colorRequest struct {
+	X         float64 `json:"x"`
+	Y         float64 `json:"y"`
+	Kelvin    float64 `json:"kelvin"`
+	Tint      float64 `json:"tint"`
+	Intensity float64 `json:"intensity"`
+	Minutes   float64 `json:"minutes"`
+}
+
+type colorResponse struct {
+	Applied bool `json:"applied"`
+	// Target is the colour asked for, Achieved what the mix gives
+	Target    colorStatus     `json:"target"`
+	Achieved  colorStatus     `json:"achieved"`
+	Intensity float64         `json:"intensity"`
+	Residual  float64         `json:"residual_uv"`
+	Channels  map[int]float64 `json:"channels"`
+	Until     *time.Time      `json:"until,omitempty"`
+}
+
+// handleColor solves for the channel mix giving a colour at an
+// intensity with POST, within the limits active now, and holds the
+// channels there instead of the schedule, such as for white balanced
+// photographs. ?dry_run=true only solves. DELETE goes back to the
+// schedule.
+func (s *Server) handleColor(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "POST" && r.Method != "DELETE" {
+		writeError(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	if s.Lights == nil || s.Spectra == nil {
+		writeError(w, "no schedule or spectra", http.StatusNotFound)
+		return
+	}
+	if r.Method == "DELETE" {
+		s.Lights.ClearHold()
+		writeJson(w, colorResponse{})
+		return
+	}
+	req := colorRequest{Minutes: 30}
+	if err := json.NewDecoder(http.MaxBytesReader(w, r.Body, 1<<20)).Decode(&req); err != nil {
+		writeError(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if req.Kelvin != 0 {
+		x, y, err := spectrum.Target(req.Kelvin, req.Tint)
+		if err != nil {
+			writeError(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		req.X, req.Y = x, y
+	}
+	limits, scales := s.ble.Limits(), s.ble.Scales()
+	var max [ltable.Channels]float64
+	for channel := range max {
+		max[channel] = output(100, limits, scales)
+	}
+	sol, err := s.Spectra.Solve(req.X, req.Y, req.Intensity, max)
+	if err != nil {
+		writeError(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	resp := colorResponse{Intensity: sol.Intensity,
+		Residual: sol.Residual,
+		Channels: make(map[int]float64),
+	}
+	resp.Target.X, resp.Target.Y = req.X, req.Y
+	resp.Target.CCT, resp.Target.Duv = spectrum.CCT(req.X, req.Y)
+	resp.Achieved.X, resp.Achieved.Y = sol.X, sol.Y
+	resp.Achieved.CCT, resp.Achieved.Duv = spectrum.CCT(sol.X, sol.Y)
+	// Settings are scaled on the way out, so undo that to get the
+	// solved outputs
+	scale := output(1, nil, scales)
+	settings := make([]float64, ltable.Channels)
+	for channel, p := range sol.Percents {
+		resp.Channels[channel] = p
+		if scale > 0 {
+			settings[channel] = math.Min(p/scale, 100)
+		}
+	}
+	if r.URL.Query().Get("dry_run") == "true" {
+		writeJson(w, resp)
+		return
+	}
+	d := time.Duration(req.Minutes * float64(time.Minute))
+	if err := s.Lights.Hold(settings, d); err != nil {
+		writeError(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	until := time.Now().Add(d)
+	resp.Applied = true
+	resp.Until = &until
+	writeJson(w, resp)
+}
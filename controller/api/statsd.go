@@ -0,0 +1,102 @@
+package api
+
+import (
+	"bytes"
+	"flag"
+	"fmt"
+	"log"
+	"net"
+	"strings"
+	"time"
+)
+
+var statsdAddr string
+var statsdPrefix string
+var statsdTags bool
+var statsdInterval time.Duration
+
+func init() {
+	flag.StringVar(&statsdAddr, "api.statsd", "",
+		"host:port of a StatsD agent, such as Telegraf or the Datadog agent, to send metrics to")
+	flag.StringVar(&statsdPrefix, "api.statsd.prefix", "ledbrick.",
+		"Prefix of every metric sent to -api.statsd")
+	flag.BoolVar(&statsdTags, "api.statsd.tags", true,
+		"Send labels as DogStatsD tags, rather than in the metric names for plain StatsD")
+	flag.DurationVar(&statsdInterval, "api.statsd.interval", 10*time.Second,
+		"How often metrics are sent to -api.statsd")
+}
+
+// statsdPacket is the most sent in one datagram, to fit a typical MTU.
+const statsdPacket = 1432
+
+// StartStatsD sends every metric as a gauge to -api.statsd, if set,
+// every -api.statsd.interval. Fixtures are tagged with their zone, the
+// tank they light, and channels with their number and name.
+func StartStatsD(s *Server) error {
+	if statsdAddr == "" {
+		return nil
+	}
+	conn, err := net.Dial("udp", statsdAddr)
+	if err != nil {
+		return err
+	}
+	go func() {
+		for now := range time.Tick(statsdInterval) {
+			zones := s.zones()
+			var packet bytes.Buffer
+			for _, sm := range s.gather(now) {
+				line := statsdLine(sm, zones)
+				if packet.Len() > 0 && packet.Len()+len(line) > statsdPacket {
+					conn.Write(packet.Bytes())
+					packet.Reset()
+				}
+				packet.WriteString(line)
+			}
+			if packet.Len() > 0 {
+				conn.Write(packet.Bytes())
+			}
+		}
+	}()
+	log.Printf("Sending metrics to StatsD at %s every %s", statsdAddr, statsdInterval)
+	return nil
+}
+
+// zones returns the tank each fixture in one lights.
+func (s *Server) zones() map[string]string {
+	zones := make(map[string]string)
+	for _, tank := range s.ble.Tanks() {
+		for id := range s.ble.Tank(tank).Availability() {
+			zones[id] = tank
+		}
+	}
+	return zones
+}
+
+var statsdEscape = strings.NewReplacer(":", "_", "|", "_", ",", "_", "#", "_", " ", "_", "@", "_")
+
+// statsdLine formats a sample as a gauge, with its labels as tags, or
+// in the name without them.
+func statsdLine(sm sample, zones map[string]string) string {
+	name := statsdPrefix + strings.TrimPrefix(sm.name, "ledbrick_")
+	var tags []string
+	for _, l := range sm.labels {
+		key := l[0]
+		if key == "tank" {
+			key = "zone"
+		}
+		tags = append(tags, key+":"+statsdEscape.Replace(l[1]))
+		if l[0] == "fixture" && zones[l[1]] != "" {
+			tags = append(tags, "zone:"+statsdEscape.Replace(zones[l[1]]))
+		}
+	}
+	if !statsdTags {
+		for _, tag := range tags {
+			name += "." + strings.Replace(strings.Replace(tag, ":", "_", 1), ".", "_", -1)
+		}
+		return fmt.Sprintf("%s:%g|g\n", name, sm.value)
+	}
+	if len(tags) == 0 {
+		return fmt.Sprintf("%s:%g|g\n", name, sm.value)
+	}
+	return fmt.Sprintf("%s:%g|g|#%s\n", name, sm.value, strings.Join(tags, ","))
+}
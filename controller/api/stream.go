@@ -0,0 +1,271 @@
+package api
+
+import (
+	"bufio"
+	"crypto/sha1"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"io"
+	"log"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/theatrus/ledbrick/controller/alert"
+	"github.com/theatrus/ledbrick/controller/events"
+)
+
+// Appended to a client's key to prove the server speaks WebSocket
+const websocketGUID = "258EAFA5-E914-47DA-95CA-C5AB0DC85B11"
+
+// How many events may wait for a slow client before they're dropped,
+// and how often an idle stream is pinged to keep it open
+const (
+	streamQueue = 256
+	streamPing  = 30 * time.Second
+)
+
+// eventFilter picks the events a stream sends.
+type eventFilter struct {
+	// severity is the least an event may be. Only alerts have one, the
+	// rest count as info.
+	severity alert.Severity
+	devices  map[string]bool
+	types    map[string]bool
+}
+
+func parseFilter(r *http.Request) (eventFilter, error) {
+	q := r.URL.Query()
+	f := eventFilter{}
+	switch q.Get("severity") {
+	case "", "info":
+	case "warning":
+		f.severity = alert.Warning
+	case "critical":
+		f.severity = alert.Critical
+	default:
+		return f, errors.New("severity must be info, warning or critical")
+	}
+	split := func(s string) map[string]bool {
+		if s == "" {
+			return nil
+		}
+		m := make(map[string]bool)
+		for _, v := range strings.Split(s, ",") {
+			m[v] = true
+		}
+		return m
+	}
+	f.devices = split(q.Get("device"))
+	f.types = split(q.Get("type"))
+	return f, nil
+}
+
+// matches reports whether an event passes the filter.
+func (f eventFilter) matches(e events.Event) bool {
+	if f.types != nil && !f.types[e.Type] {
+		return false
+	}
+	severity, device := alert.Info, ""
+	switch d := e.Data.(type) {
+	case alert.Alert:
+		severity, device = d.Severity, d.Source
+	case events.ConnectionData:
+		device = d.Fixture
+	}
+	if severity < f.severity {
+		return false
+	}
+	return f.devices == nil || f.devices[device]
+}
+
+// stream is an event sink feeding one WebSocket client.
+type stream struct {
+	filter eventFilter
+	events chan events.Event
+}
+
+func (s *stream) Publish(e events.Event) {
+	if !s.filter.matches(e) {
+		return
+	}
+	select {
+	case s.events <- e:
+	default:
+		// A client too slow to keep up misses events rather than
+		// holding up the controller
+	}
+}
+
+// handleEvents streams the event bus to a WebSocket client as JSON text
+// messages, filtered by ?severity=, ?device= and ?type=, the last two
+// comma separated.
+func (s *Server) handleEvents(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "GET" {
+		writeError(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	filter, err := parseFilter(r)
+	if err != nil {
+		writeError(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	key := r.Header.Get("Sec-WebSocket-Key")
+	if !strings.EqualFold(r.Header.Get("Upgrade"), "websocket") || key == "" {
+		writeError(w, "expected a WebSocket upgrade", http.StatusBadRequest)
+		return
+	}
+	if !sameOrigin(r) {
+		writeError(w, "cross origin WebSocket refused", http.StatusForbidden)
+		return
+	}
+	hj, ok := w.(http.Hijacker)
+	if !ok {
+		writeError(w, "streaming not supported", http.StatusInternalServerError)
+		return
+	}
+	conn, rw, err := hj.Hijack()
+	if err != nil {
+		log.Printf("Event stream to %s failed: %v", r.RemoteAddr, err)
+		return
+	}
+	defer conn.Close()
+
+	sum := sha1.Sum([]byte(key + websocketGUID))
+	rw.WriteString("HTTP/1.1 101 Switching Protocols\r\nUpgrade: websocket\r\nConnection: Upgrade\r\n" +
+		"Sec-WebSocket-Accept: " + base64.StdEncoding.EncodeToString(sum[:]) + "\r\n\r\n")
+	if err := rw.Flush(); err != nil {
+		return
+	}
+
+	st := &stream{filter: filter, events: make(chan events.Event, streamQueue)}
+	events.Register(st)
+	defer events.Unregister(st)
+
+	// The client only ever closes, answers pings or pings itself. Its
+	// pings are handed to the loop below to answer, as the only writer.
+	closed := make(chan struct{})
+	done := make(chan struct{})
+	defer close(done)
+	pings := make(chan []byte)
+	go func() {
+		defer close(closed)
+		for {
+			op, payload, err := readFrame(rw.Reader)
+			if err != nil || op == opClose {
+				return
+			}
+			if op == opPing {
+				select {
+				case pings <- payload:
+				case <-done:
+					return
+				}
+			}
+		}
+	}()
+
+	ping := time.NewTicker(streamPing)
+	defer ping.Stop()
+	for {
+		var err error
+		select {
+		case e := <-st.events:
+			var data []byte
+			if data, err = json.Marshal(e); err == nil {
+				err = writeFrame(conn, opText, data)
+			}
+		case payload := <-pings:
+			err = writeFrame(conn, opPong, payload)
+		case <-ping.C:
+			err = writeFrame(conn, opPing, nil)
+		case <-closed:
+			writeFrame(conn, opClose, nil)
+			return
+		}
+		if err != nil {
+			return
+		}
+	}
+}
+
+// sameOrigin reports whether a browser's upgrade comes from a page the
+// API served, so another site can't open a stream with the browser's
+// session. Clients which aren't browsers send no Origin.
+func sameOrigin(r *http.Request) bool {
+	origin := r.Header.Get("Origin")
+	if origin == "" {
+		return true
+	}
+	u, err := url.Parse(origin)
+	return err == nil && strings.EqualFold(u.Host, r.Host)
+}
+
+// WebSocket opcodes
+const (
+	opText  = 0x1
+	opClose = 0x8
+	opPing  = 0x9
+	opPong  = 0xA
+)
+
+// writeFrame writes an unmasked frame, as a server does.
+func writeFrame(conn net.Conn, op byte, payload []byte) error {
+	conn.SetWriteDeadline(time.Now().Add(10 * time.Second))
+	b := []byte{0x80 | op}
+	switch n := len(payload); {
+	case n < 126:
+		b = append(b, byte(n))
+	case n < 1<<16:
+		b = append(b, 126, byte(n>>8), byte(n))
+	default:
+		b = append(b, 127, 0, 0, 0, 0, byte(n>>24), byte(n>>16), byte(n>>8), byte(n))
+	}
+	_, err := conn.Write(append(b, payload...))
+	return err
+}
+
+// readFrame reads a frame, unmasking it as a client's are. Control
+// frames from the client are small, so anything large is refused.
+func readFrame(r *bufio.Reader) (byte, []byte, error) {
+	var head [2]byte
+	if _, err := io.ReadFull(r, head[:]); err != nil {
+		return 0, nil, err
+	}
+	n := uint64(head[1] & 0x7f)
+	switch n {
+	case 126:
+		var ext [2]byte
+		if _, err := io.ReadFull(r, ext[:]); err != nil {
+			return 0, nil, err
+		}
+		n = uint64(binary.BigEndian.Uint16(ext[:]))
+	case 127:
+		var ext [8]byte
+		if _, err := io.ReadFull(r, ext[:]); err != nil {
+			return 0, nil, err
+		}
+		n = binary.BigEndian.Uint64(ext[:])
+	}
+	if n > 1<<16 {
+		return 0, nil, errors.New("frame too large")
+	}
+	var mask [4]byte
+	if head[1]&0x80 != 0 {
+		if _, err := io.ReadFull(r, mask[:]); err != nil {
+			return 0, nil, err
+		}
+	}
+	payload := make([]byte, n)
+	if _, err := io.ReadFull(r, payload); err != nil {
+		return 0, nil, err
+	}
+	for i := range payload {
+		payload[i] ^= mask[i%4]
+	}
+	return head[0] & 0x0f, payload, nil
+}
@@ -0,0 +1,69 @@
+package api
+
+import (
+	"bufio"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/theatrus/ledbrick/controller/ble/bletest"
+)
+
+func TestSameOrigin(t *testing.T) {
+	for _, tt := range []struct {
+		origin string
+		ok     bool
+	}{
+		{"", true},
+		{"http://ledbrick.local:8080", true},
+		{"https://LEDBRICK.local:8080", true},
+		{"http://evil.example", false},
+		{"http://ledbrick.local", false},
+		{"null", false},
+	} {
+		r := httptest.NewRequest("GET", "http://ledbrick.local:8080/events", nil)
+		if tt.origin != "" {
+			r.Header.Set("Origin", tt.origin)
+		}
+		if got := sameOrigin(r); got != tt.ok {
+			t.Errorf("Origin %q: expected %v, got %v", tt.origin, tt.ok, got)
+		}
+	}
+}
+
+func TestEventsOrigin(t *testing.T) {
+	s := NewServer(bletest.NewChannel())
+	srv := httptest.NewServer(http.HandlerFunc(s.handleEvents))
+	defer srv.Close()
+	host := strings.TrimPrefix(srv.URL, "http://")
+
+	upgrade := func(origin string) string {
+		conn, err := net.Dial("tcp", host)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer conn.Close()
+		req := "GET /events HTTP/1.1\r\nHost: " + host + "\r\nUpgrade: websocket\r\nConnection: Upgrade\r\n" +
+			"Sec-WebSocket-Key: dGhlIHNhbXBsZSBub25jZQ==\r\nSec-WebSocket-Version: 13\r\n"
+		if origin != "" {
+			req += "Origin: " + origin + "\r\n"
+		}
+		conn.Write([]byte(req + "\r\n"))
+		status, err := bufio.NewReader(conn).ReadString('\n')
+		if err != nil {
+			t.Fatal(err)
+		}
+		return status
+	}
+	if status := upgrade("http://evil.example"); !strings.Contains(status, "403") {
+		t.Errorf("Expected a cross origin upgrade refused, got %q", status)
+	}
+	if status := upgrade("http://" + host); !strings.Contains(status, "101") {
+		t.Errorf("Expected a same origin upgrade, got %q", status)
+	}
+	if status := upgrade(""); !strings.Contains(status, "101") {
+		t.Errorf("Expected an upgrade without an Origin, got %q", status)
+	}
+}
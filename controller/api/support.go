@@ -0,0 +1,80 @@
+package api
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"runtime"
+	"time"
+
+	"github.com/theatrus/ledbrick/controller/alert"
+	"github.com/theatrus/ledbrick/controller/ble"
+	"github.com/theatrus/ledbrick/controller/support"
+)
+
+// started is when the API was created, for uptime in support bundles.
+var started = time.Now()
+
+type versionInfo struct {
+	Version string    `json:"version"`
+	Go      string    `json:"go"`
+	OS      string    `json:"os"`
+	Arch    string    `json:"arch"`
+	Started time.Time `json:"started"`
+	Uptime  string    `json:"uptime"`
+}
+
+type bleStats struct {
+	Disconnects      int                         `json:"disconnects"`
+	Degraded         bool                        `json:"degraded"`
+	EffectsSuspended bool                        `json:"effects_suspended"`
+	Limits           map[string]float64          `json:"limits"`
+	Scales           map[string]float64          `json:"scales"`
+	Channels         map[int]float64             `json:"channels"`
+	Availability     map[string]ble.Availability `json:"availability"`
+}
+
+// handleSupport serves a support bundle: the version, the config with
+// secrets redacted, recent logs and alerts, and the state of every
+// fixture and the link, as a gzipped tar.
+func (s *Server) handleSupport(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "GET" {
+		writeError(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	b := support.NewBundle()
+	add := func(name string, v interface{}) {
+		data, err := json.MarshalIndent(v, "", "  ")
+		if err != nil {
+			data = []byte(err.Error())
+		}
+		b.Add(name, data)
+	}
+	add("version.json", versionInfo{Version: s.Version,
+		Go:      runtime.Version(),
+		OS:      runtime.GOOS,
+		Arch:    runtime.GOARCH,
+		Started: started,
+		Uptime:  time.Since(started).String(),
+	})
+	b.AddConfig()
+	b.Add("logs.txt", support.Logs.Bytes())
+	add("alerts.json", alert.Recent())
+	add("peripherals.json", s.status())
+	add("ble.json", bleStats{Disconnects: s.ble.DisconnectCount(),
+		Degraded:         s.ble.Degraded(),
+		EffectsSuspended: s.ble.EffectsSuspended(),
+		Limits:           s.ble.Limits(),
+		Scales:           s.ble.Scales(),
+		Channels:         s.ble.Channels(),
+		Availability:     s.ble.Availability(),
+	})
+
+	w.Header().Set("Content-Type", "application/gzip")
+	w.Header().Set("Content-Disposition",
+		fmt.Sprintf("attachment; filename=ledbrick-support-%s.tar.gz", time.Now().Format("20060102-150405")))
+	if err := b.Write(w); err != nil {
+		log.Printf("Failed to write support bundle: %v", err)
+	}
+}
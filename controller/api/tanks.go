@@ -0,0 +1,101 @@
+package api
+
+import (
+	"net/http"
+	"sort"
+
+	"github.com/theatrus/ledbrick/controller/alert"
+	"github.com/theatrus/ledbrick/controller/ble"
+	"github.com/theatrus/ledbrick/controller/ltable"
+	"github.com/theatrus/ledbrick/controller/report"
+)
+
+type tankResponse struct {
+	Name     string   `json:"name"`
+	Fixtures []string `json:"fixtures"`
+	// Schedule is whether the tank has its own lighting table
+	Schedule bool `json:"schedule"`
+	// Reverse is whether the tank is lit while the display is dark
+	Reverse bool `json:"reverse"`
+}
+
+// AddTank serves the API scoped to a tank under /tanks/<name>/, with
+// the same endpoints limited to the tank's fixtures, overrides, alerts
+// and lighting table. Set the server's optional fields first, as the
+// tank shares them. The tank's own reporter, if it has one, covers its
+// daily reports.
+func (s *Server) AddTank(name string, lights *ltable.LightDriver, reporter *report.Reporter) {
+	t := NewServer(s.ble.Tank(name))
+	t.Probes = s.Probes
+	t.Interlocks = s.Interlocks
+	t.UPS = s.UPS
+	t.Ambient = s.Ambient
+	t.Spectra = s.Spectra
+	t.Journal = s.Journal
+	t.Version = s.Version
+	t.Lights = lights
+	t.Reporter = reporter
+	t.tank = name
+	s.tanks[name] = t
+	s.mux.Handle("/tanks/"+name+"/", http.StripPrefix("/tanks/"+name, t))
+}
+
+func (s *Server) handleTanks(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "GET" {
+		writeError(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	names := s.ble.Tanks()
+	for name := range s.tanks {
+		if !contains(names, name) {
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+	tanks := make([]tankResponse, 0, len(names))
+	for _, name := range names {
+		t := tankResponse{Name: name, Fixtures: []string{}}
+		for id := range s.ble.Tank(name).Availability() {
+			t.Fixtures = append(t.Fixtures, id)
+		}
+		sort.Strings(t.Fixtures)
+		t.Schedule = s.tanks[name] != nil && s.tanks[name].Lights != nil
+		t.Reverse = t.Schedule && s.tanks[name].Lights.Reversed()
+		tanks = append(tanks, t)
+	}
+	writeJson(w, tanks)
+}
+
+// tankAlerts keeps the alerts raised by a tank's fixtures.
+func (s *Server) tankAlerts(alerts []alert.Alert) []alert.Alert {
+	fixtures := s.ble.Availability()
+	for _, p := range s.ble.Perhipherals() {
+		fixtures[p.ID()] = ble.Availability{}
+	}
+	kept := make([]alert.Alert, 0, len(alerts))
+	for _, a := range alerts {
+		if _, ok := fixtures[a.Source]; ok {
+			kept = append(kept, a)
+		}
+	}
+	return kept
+}
+
+// tankAlert reports whether an alert was raised by the tank's fixtures.
+func (s *Server) tankAlert(id int) bool {
+	for _, a := range s.tankAlerts(alert.Recent()) {
+		if a.ID == id {
+			return true
+		}
+	}
+	return false
+}
+
+func contains(names []string, name string) bool {
+	for _, n := range names {
+		if n == name {
+			return true
+		}
+	}
+	return false
+}
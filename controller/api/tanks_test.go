@@ -0,0 +1,51 @@
+package api
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/theatrus/ledbrick/controller/alert"
+	"github.com/theatrus/ledbrick/controller/ble/bletest"
+)
+
+func TestTankScope(t *testing.T) {
+	defer withKeys(map[string]key{"admin": {Token: "a", Scope: adminRole}})()
+	b := bletest.NewChannel()
+	b.TankChannel("frag").Connect(bletest.NewPeripheral("frag1"))
+	b.TankChannel("sump").Connect(bletest.NewPeripheral("sump1"))
+	s := NewServer(b)
+	s.AddTank("frag", nil, nil)
+	s.AddTank("sump", nil, nil)
+
+	do := func(method, path, body string) int {
+		r := httptest.NewRequest(method, path, strings.NewReader(body))
+		r.Header.Set("Authorization", "Bearer a")
+		w := httptest.NewRecorder()
+		s.ServeHTTP(w, r)
+		return w.Code
+	}
+
+	if code := do("POST", "/tanks/frag/maintenance", ""); code != http.StatusOK {
+		t.Fatalf("Expected maintenance mode on, got %d", code)
+	}
+	if !b.TankChannel("frag").EffectsSuspended() || b.TankChannel("sump").EffectsSuspended() || b.EffectsSuspended() {
+		t.Error("Expected maintenance on the frag tank to leave the others' effects")
+	}
+
+	if code := do("PUT", "/tanks/frag/peripherals/sump1/name", `{"name": "Left"}`); code == http.StatusOK {
+		t.Error("Expected the frag tank not to rename the sump's fixture")
+	}
+
+	alert.Raise(alert.Warning, "sump1", "test.scope", "sump alert")
+	alerts := alert.Recent()
+	id := alerts[len(alerts)-1].ID
+	if code := do("POST", fmt.Sprintf("/tanks/frag/alerts/%d/ack", id), ""); code != http.StatusNotFound {
+		t.Errorf("Expected the frag tank not to ack the sump's alert, got %d", code)
+	}
+	if code := do("POST", fmt.Sprintf("/tanks/sump/alerts/%d/ack", id), ""); code != http.StatusNoContent {
+		t.Errorf("Expected the sump tank to ack its alert, got %d", code)
+	}
+}
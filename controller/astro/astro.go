@@ -0,0 +1,266 @@
+// Package astro works out where the sun and moon are from a low
+// precision ephemeris, good to around a hundredth of a degree, and so
+// when eclipses are seen from a location on the earth.
+package astro
+
+import (
+	"flag"
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+	"time"
+)
+
+var location string
+
+func init() {
+	flag.StringVar(&location, "astro.location", "",
+		"Latitude and longitude of the tank, or the place it follows, in degrees, e.g. -17.7,178.1 for Fiji")
+}
+
+const (
+	earthRadius = 6378.14     // km
+	sunRadius   = 696000.0    // km
+	moonRadius  = 1737.4      // km
+	au          = 149597870.7 // km
+)
+
+// Location is a place on the earth, in degrees north and east.
+type Location struct {
+	Latitude  float64 `json:"latitude"`
+	Longitude float64 `json:"longitude"`
+}
+
+func (l Location) String() string {
+	return fmt.Sprintf("%.4f,%.4f", l.Latitude, l.Longitude)
+}
+
+// ParseLocation reads a location as latitude,longitude.
+func ParseLocation(s string) (Location, error) {
+	parts := strings.Split(s, ",")
+	if len(parts) != 2 {
+		return Location{}, fmt.Errorf("bad location %q, expected latitude,longitude", s)
+	}
+	lat, err := strconv.ParseFloat(strings.TrimSpace(parts[0]), 64)
+	if err != nil || lat < -90 || lat > 90 {
+		return Location{}, fmt.Errorf("bad latitude %q, expected -90 to 90", parts[0])
+	}
+	lon, err := strconv.ParseFloat(strings.TrimSpace(parts[1]), 64)
+	if err != nil || lon < -180 || lon > 180 {
+		return Location{}, fmt.Errorf("bad longitude %q, expected -180 to 180", parts[1])
+	}
+	return Location{Latitude: lat, Longitude: lon}, nil
+}
+
+// Here returns the -astro.location.
+func Here() (Location, error) {
+	if location == "" {
+		return Location{}, fmt.Errorf("-astro.location is not set")
+	}
+	return ParseLocation(location)
+}
+
+// vector is a position in km, along the equator at 0h right ascension,
+// the equator at 6h, and the north pole.
+type vector [3]float64
+
+func (v vector) sub(o vector) vector { return vector{v[0] - o[0], v[1] - o[1], v[2] - o[2]} }
+func (v vector) dot(o vector) float64 {
+	return v[0]*o[0] + v[1]*o[1] + v[2]*o[2]
+}
+func (v vector) length() float64 { return math.Sqrt(v.dot(v)) }
+
+// angle returns the angle between two vectors in radians.
+func (v vector) angle(o vector) float64 {
+	cross := vector{v[1]*o[2] - v[2]*o[1], v[2]*o[0] - v[0]*o[2], v[0]*o[1] - v[1]*o[0]}
+	return math.Atan2(cross.length(), v.dot(o))
+}
+
+func rad(deg float64) float64 { return deg * math.Pi / 180 }
+
+// centuries returns the Julian centuries from J2000, and the days.
+func centuries(t time.Time) (float64, float64) {
+	days := float64(t.UnixNano())/float64(24*time.Hour) + 2440587.5 - 2451545.0
+	return days / 36525, days
+}
+
+// dynamical returns the centuries from J2000 in terrestrial time, which
+// the ephemeris runs on, from NASA's fit of the earth's slowing
+// rotation for 2005 to 2050.
+func dynamical(t time.Time) float64 {
+	y := float64(t.Year()) - 2000
+	delta := 62.92 + 0.32217*y + 0.005589*y*y
+	T, _ := centuries(t.Add(time.Duration(delta * float64(time.Second))))
+	return T
+}
+
+// obliquity returns the tilt of the earth's axis, and the nutation in
+// longitude, in degrees.
+func obliquity(T float64) (float64, float64) {
+	omega := rad(125.04 - 1934.136*T)
+	e0 := 23 + 26.0/60 + (21.448-46.8150*T)/3600
+	return e0 + 0.00256*math.Cos(omega), -0.00478 * math.Sin(omega)
+}
+
+// equatorial turns ecliptic longitude and latitude, in degrees, and a
+// distance into a vector.
+func equatorial(lambda, beta, dist, eps float64) vector {
+	l, b, e := rad(lambda), rad(beta), rad(eps)
+	x := math.Cos(b) * math.Cos(l)
+	y := math.Cos(b) * math.Sin(l)
+	z := math.Sin(b)
+	return vector{dist * x, dist * (y*math.Cos(e) - z*math.Sin(e)), dist * (y*math.Sin(e) + z*math.Cos(e))}
+}
+
+// sun returns the sun's position from the earth's centre.
+func sun(t time.Time) vector {
+	T := dynamical(t)
+	L0 := 280.46646 + 36000.76983*T + 0.0003032*T*T
+	M := rad(357.52911 + 35999.05029*T - 0.0001537*T*T)
+	e := 0.016708634 - 0.000042037*T
+	C := (1.914602-0.004817*T-0.000014*T*T)*math.Sin(M) +
+		(0.019993-0.000101*T)*math.Sin(2*M) + 0.000289*math.Sin(3*M)
+	nu := M + rad(C)
+	R := 1.000001018 * (1 - e*e) / (1 + e*math.Cos(nu)) * au
+	eps, nutation := obliquity(T)
+	return equatorial(L0+C-0.00569+nutation, 0, R, eps)
+}
+
+// Periodic terms of the moon's longitude and distance, then latitude:
+// multiples of D, M, M' and F, then the coefficient
+var moonLongitude = [][6]float64{
+	{0, 0, 1, 0, 6288774, -20905355},
+	{2, 0, -1, 0, 1274027, -3699111},
+	{2, 0, 0, 0, 658314, -2955968},
+	{0, 0, 2, 0, 213618, -569925},
+	{0, 1, 0, 0, -185116, 48888},
+	{0, 0, 0, 2, -114332, -3149},
+	{2, 0, -2, 0, 58793, 246158},
+	{2, -1, -1, 0, 57066, -152138},
+	{2, 0, 1, 0, 53322, -170733},
+	{2, -1, 0, 0, 45758, -204586},
+	{0, 1, -1, 0, -40923, -129620},
+	{1, 0, 0, 0, -34720, 108743},
+	{0, 1, 1, 0, -30383, 104755},
+	{2, 0, 0, -2, 15327, 10321},
+	{0, 0, 1, 2, -12528, 0},
+	{0, 0, 1, -2, 10980, 79661},
+	{4, 0, -1, 0, 10675, -34782},
+	{0, 0, 3, 0, 10034, -23210},
+	{4, 0, -2, 0, 8548, -21636},
+}
+
+var moonLatitude = [][5]float64{
+	{0, 0, 0, 1, 5128122},
+	{0, 0, 1, 1, 280602},
+	{0, 0, 1, -1, 277693},
+	{2, 0, 0, -1, 173237},
+	{2, 0, -1, 1, 55413},
+	{2, 0, -1, -1, 46271},
+	{2, 0, 0, 1, 32573},
+	{0, 0, 2, 1, 17198},
+	{2, 0, 1, -1, 9266},
+	{0, 0, 2, -1, 8822},
+}
+
+// moon returns the moon's position from the earth's centre, from the
+// largest terms of Meeus' series.
+func moon(t time.Time) vector {
+	T := dynamical(t)
+	L := 218.3164477 + 481267.88123421*T
+	D := rad(297.8501921 + 445267.1114034*T)
+	M := rad(357.5291092 + 35999.0502909*T)
+	Mp := rad(134.9633964 + 477198.8675055*T)
+	F := rad(93.2720950 + 483202.0175233*T)
+	// Terms with the sun's anomaly shrink as the earth's orbit rounds
+	E := 1 - 0.002516*T
+	scale := func(m float64) float64 { return math.Pow(E, math.Abs(m)) }
+	var l, r, b float64
+	for _, term := range moonLongitude {
+		arg := term[0]*D + term[1]*M + term[2]*Mp + term[3]*F
+		l += term[4] * scale(term[1]) * math.Sin(arg)
+		r += term[5] * scale(term[1]) * math.Cos(arg)
+	}
+	for _, term := range moonLatitude {
+		arg := term[0]*D + term[1]*M + term[2]*Mp + term[3]*F
+		b += term[4] * scale(term[1]) * math.Sin(arg)
+	}
+	eps, nutation := obliquity(T)
+	return equatorial(L+l/1e6+nutation, b/1e6, 385000.56+r/1000, eps)
+}
+
+// observer returns the location's position from the earth's centre,
+// allowing for the earth's flattening.
+func (l Location) observer(t time.Time) vector {
+	T, days := centuries(t)
+	sidereal := rad(280.46061837 + 360.98564736629*days + 0.000387933*T*T + l.Longitude)
+	u := math.Atan(0.99664719 * math.Tan(rad(l.Latitude)))
+	x, z := earthRadius*math.Cos(u), earthRadius*0.99664719*math.Sin(u)
+	return vector{x * math.Cos(sidereal), x * math.Sin(sidereal), z}
+}
+
+// up returns the direction straight up from the location.
+func (l Location) up(t time.Time) vector {
+	T, days := centuries(t)
+	sidereal := rad(280.46061837 + 360.98564736629*days + 0.000387933*T*T + l.Longitude)
+	lat := rad(l.Latitude)
+	return vector{math.Cos(lat) * math.Cos(sidereal), math.Cos(lat) * math.Sin(sidereal), math.Sin(lat)}
+}
+
+// altitude returns how far a position from the earth's centre is above
+// the horizon, in degrees.
+func (l Location) altitude(t time.Time, v vector) float64 {
+	return 90 - l.up(t).angle(v.sub(l.observer(t)))*180/math.Pi
+}
+
+// SunAltitude returns how far the sun is above the horizon, in degrees.
+func (l Location) SunAltitude(t time.Time) float64 {
+	return l.altitude(t, sun(t))
+}
+
+// MoonAltitude returns how far the moon is above the horizon, in
+// degrees.
+func (l Location) MoonAltitude(t time.Time) float64 {
+	return l.altitude(t, moon(t))
+}
+
+// SolarEclipse returns how much of the sun's disc the moon covers, from
+// 0 to 1, as seen from the location. It is 0 while the sun is down.
+func (l Location) SolarEclipse(t time.Time) float64 {
+	if l.SunAltitude(t) < -0.83 {
+		return 0
+	}
+	o := l.observer(t)
+	s, m := sun(t).sub(o), moon(t).sub(o)
+	rs, rm := math.Asin(sunRadius/s.length()), math.Asin(moonRadius/m.length())
+	return covered(rs, rm, s.angle(m))
+}
+
+// covered returns the fraction of a disc of radius rs covered by one of
+// radius rm, d apart.
+func covered(rs, rm, d float64) float64 {
+	switch {
+	case d >= rs+rm:
+		return 0
+	case d <= math.Abs(rs-rm):
+		return math.Min(1, rm*rm/(rs*rs))
+	}
+	lens := rs*rs*math.Acos((d*d+rs*rs-rm*rm)/(2*d*rs)) + rm*rm*math.Acos((d*d+rm*rm-rs*rs)/(2*d*rm)) -
+		0.5*math.Sqrt((-d+rs+rm)*(d+rs-rm)*(d-rs+rm)*(d+rs+rm))
+	return lens / (math.Pi * rs * rs)
+}
+
+// LunarEclipse returns how much of the moon's diameter is in the
+// earth's umbra, from 0 to 1. Whether it can be seen depends on the
+// moon being up.
+func LunarEclipse(t time.Time) float64 {
+	s, m := sun(t), moon(t)
+	anti := vector{-s[0], -s[1], -s[2]}
+	parallaxMoon, parallaxSun := math.Asin(earthRadius/m.length()), math.Asin(earthRadius/s.length())
+	radiusSun, radiusMoon := math.Asin(sunRadius/s.length()), math.Asin(moonRadius/m.length())
+	// Enlarged by the atmosphere, as is traditional
+	umbra := 1.02 * (parallaxMoon + parallaxSun - radiusSun)
+	magnitude := (umbra + radiusMoon - m.angle(anti)) / (2 * radiusMoon)
+	return math.Max(0, math.Min(1, magnitude))
+}
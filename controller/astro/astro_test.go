@@ -0,0 +1,63 @@
+package astro
+
+import (
+	"math"
+	"testing"
+	"time"
+)
+
+func TestSun(t *testing.T) {
+	// The June solstice of 2017
+	s := sun(time.Date(2017, 6, 21, 4, 24, 0, 0, time.UTC))
+	dec := math.Asin(s[2]/s.length()) * 180 / math.Pi
+	if math.Abs(dec-23.44) > 0.01 {
+		t.Errorf("Expected the sun at 23.44 declination on the solstice, got %.3f", dec)
+	}
+	greenwich := Location{Latitude: 51.48, Longitude: 0}
+	if alt := greenwich.SunAltitude(time.Date(2017, 6, 21, 12, 2, 0, 0, time.UTC)); math.Abs(alt-61.96) > 0.1 {
+		t.Errorf("Expected the sun at 61.96 at midsummer noon in Greenwich, got %.2f", alt)
+	}
+}
+
+func TestSolarEclipse(t *testing.T) {
+	// Totality of the 2017 eclipse in Madras, Oregon
+	madras := Location{Latitude: 44.63, Longitude: -121.13}
+	for _, tc := range []struct {
+		at       time.Time
+		min, max float64
+	}{
+		{time.Date(2017, 8, 21, 15, 30, 0, 0, time.UTC), 0, 0},
+		{time.Date(2017, 8, 21, 16, 30, 0, 0, time.UTC), 0.1, 0.4},
+		{time.Date(2017, 8, 21, 17, 20, 30, 0, time.UTC), 0.95, 1},
+		{time.Date(2017, 8, 22, 17, 20, 30, 0, time.UTC), 0, 0},
+	} {
+		if got := madras.SolarEclipse(tc.at); got < tc.min || got > tc.max {
+			t.Errorf("%s: expected %.2f to %.2f of the sun covered, got %.3f", tc.at, tc.min, tc.max, got)
+		}
+	}
+}
+
+func TestLunarEclipse(t *testing.T) {
+	// The total lunar eclipse of January 2019
+	if got := LunarEclipse(time.Date(2019, 1, 21, 5, 12, 0, 0, time.UTC)); got != 1 {
+		t.Errorf("Expected the whole moon in the umbra at greatest eclipse, got %.3f", got)
+	}
+	if got := LunarEclipse(time.Date(2019, 1, 21, 4, 0, 0, 0, time.UTC)); got <= 0 || got >= 1 {
+		t.Errorf("Expected the moon partly in the umbra, got %.3f", got)
+	}
+	if got := LunarEclipse(time.Date(2019, 1, 21, 2, 0, 0, 0, time.UTC)); got != 0 {
+		t.Errorf("Expected no eclipse before it began, got %.3f", got)
+	}
+}
+
+func TestParseLocation(t *testing.T) {
+	l, err := ParseLocation("-17.7, 178.1")
+	if err != nil || l.Latitude != -17.7 || l.Longitude != 178.1 {
+		t.Errorf("Expected Fiji, got %v, %v", l, err)
+	}
+	for _, bad := range []string{"", "91,0", "0,181", "north,east"} {
+		if _, err := ParseLocation(bad); err == nil {
+			t.Errorf("Expected %q to fail", bad)
+		}
+	}
+}
@@ -0,0 +1,28 @@
+// Package atomicfile saves the files the controller keeps across
+// restarts so that a crash or power cut part way through leaves either
+// the old contents or the new, never a mix of the two.
+package atomicfile
+
+import "os"
+
+// WriteFile writes data to a file beside path, syncs it to disk, and
+// renames it over path.
+func WriteFile(path string, data []byte, perm os.FileMode) error {
+	tmp := path + ".tmp"
+	f, err := os.OpenFile(tmp, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, perm)
+	if err != nil {
+		return err
+	}
+	_, err = f.Write(data)
+	if err == nil {
+		err = f.Sync()
+	}
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		os.Remove(tmp)
+		return err
+	}
+	return os.Rename(tmp, path)
+}
@@ -0,0 +1,43 @@
+package atomicfile
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestWriteFile(t *testing.T) {
+	dir, err := ioutil.TempDir("", "atomicfile")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	file := filepath.Join(dir, "state.json")
+
+	for _, data := range []string{"first", "second"} {
+		if err := WriteFile(file, []byte(data), 0600); err != nil {
+			t.Fatal(err)
+		}
+		if got, err := ioutil.ReadFile(file); err != nil || string(got) != data {
+			t.Errorf("Expected %q, got %q, %v", data, got, err)
+		}
+	}
+	if fi, err := os.Stat(file); err != nil || fi.Mode().Perm() != 0600 {
+		t.Errorf("Expected the file only its owner may read, got %v", fi.Mode())
+	}
+	if _, err := os.Stat(file + ".tmp"); !os.IsNotExist(err) {
+		t.Errorf("Expected nothing left beside the file, got %v", err)
+	}
+
+	// A file which can't be written aside leaves the old one alone
+	if err := os.Mkdir(file+".tmp", 0700); err != nil {
+		t.Fatal(err)
+	}
+	if err := WriteFile(file, []byte("third"), 0600); err == nil {
+		t.Error("Expected the write to fail")
+	}
+	if got, _ := ioutil.ReadFile(file); string(got) != "second" {
+		t.Errorf("Expected the old contents kept, got %q", got)
+	}
+}
@@ -0,0 +1,95 @@
+package ble
+
+import (
+	"flag"
+	"log"
+	"sort"
+	"time"
+
+	"github.com/theatrus/ledbrick/controller/alert"
+	"github.com/theatrus/ledbrick/controller/units"
+)
+
+var riseLimit int
+var riseWindow time.Duration
+var deviationLimit int
+
+func init() {
+	flag.IntVar(&riseLimit, "ble.anomaly.rise", 5,
+		"Temperature rise in C within the rise window which suggests a cooling problem, 0 to disable")
+	flag.DurationVar(&riseWindow, "ble.anomaly.window", 10*time.Minute,
+		"Window over which the temperature rise is measured")
+	flag.IntVar(&deviationLimit, "ble.anomaly.deviation", 8,
+		"Temperature in C above the same time yesterday which suggests a cooling problem, 0 to disable")
+}
+
+// nearest returns the sample closest to t, if there is one within the
+// tolerance. Samples are added in time order, so only the ones either
+// side of t need comparing.
+func (h *history) nearest(t time.Time, tolerance time.Duration) (Sample, bool) {
+	h.lock.Lock()
+	defer h.lock.Unlock()
+
+	i := sort.Search(len(h.samples), func(i int) bool { return !h.samples[i].At.Before(t) })
+	var best Sample
+	found := false
+	for _, j := range []int{i - 1, i} {
+		if j < 0 || j >= len(h.samples) {
+			continue
+		}
+		s := h.samples[j]
+		d := s.At.Sub(t)
+		if d < 0 {
+			d = -d
+		}
+		if d > tolerance {
+			continue
+		}
+		bd := best.At.Sub(t)
+		if bd < 0 {
+			bd = -bd
+		}
+		if !found || d < bd {
+			best = s
+			found = true
+		}
+	}
+	return best, found
+}
+
+// checkTemperatureTrend looks for a fast rise in temperature, or a
+// temperature well above the same time yesterday, either of which can
+// point to a fouled heatsink or a failing fan before hard limits trip.
+func (p *blePeriph) checkTemperatureTrend(now time.Time) {
+	anomaly := false
+
+	if riseLimit > 0 {
+		if s, ok := p.history.nearest(now.Add(-riseWindow), riseWindow/2); ok {
+			if rise := p.temperature - s.Temperature; rise >= riseLimit {
+				anomaly = true
+				p.trendAlert("rose %s in %s", units.FormatChange(float64(rise), 0), now.Sub(s.At))
+			}
+		}
+	}
+	if !anomaly && deviationLimit > 0 {
+		if s, ok := p.history.nearest(now.Add(-24*time.Hour), 15*time.Minute); ok {
+			if d := p.temperature - s.Temperature; d >= deviationLimit {
+				anomaly = true
+				p.trendAlert("%s above this time yesterday (%s)",
+					units.FormatChange(float64(d), 0), units.Format(float64(s.Temperature), 0))
+			}
+		}
+	}
+	if !anomaly && p.trendAnomaly {
+		log.Printf("%s: temperature trend back to normal at %s", p.gp.ID(), units.Format(float64(p.temperature), 0))
+	}
+	p.trendAnomaly = anomaly
+}
+
+func (p *blePeriph) trendAlert(format string, args ...interface{}) {
+	if p.trendAnomaly {
+		return
+	}
+	alert.Raise(alert.Info, p.gp.ID(), "cooling.check",
+		"check cooling, temperature "+format, args...)
+}
@@ -0,0 +1,72 @@
+package ble
+
+import (
+	"testing"
+	"time"
+
+	"github.com/theatrus/ledbrick/controller/alert"
+)
+
+func TestTemperatureTrend(t *testing.T) {
+	rec := &recordNotifier{}
+	alert.RegisterSync(rec)
+
+	now := time.Date(2016, 1, 2, 12, 0, 0, 0, time.UTC)
+	p := &blePeriph{gp: fakeID("trend"), history: &history{}}
+	p.history.add(Sample{At: now.Add(-24 * time.Hour), Temperature: 30})
+	p.history.add(Sample{At: now.Add(-riseWindow), Temperature: 36})
+
+	// Steady compared to 10 minutes ago, but well above yesterday
+	p.temperature = 38
+	p.checkTemperatureTrend(now)
+	if !p.trendAnomaly || len(rec.alerts) != 1 {
+		t.Fatalf("Expected a deviation alert, got %v", rec.alerts)
+	}
+
+	// Still anomalous, no repeat
+	p.checkTemperatureTrend(now)
+	if len(rec.alerts) != 1 {
+		t.Errorf("Repeated alert: %v", rec.alerts)
+	}
+
+	p.temperature = 32
+	p.checkTemperatureTrend(now)
+	if p.trendAnomaly {
+		t.Error("Expected recovery")
+	}
+
+	// Fast rise
+	p.temperature = 41
+	p.checkTemperatureTrend(now)
+	if !p.trendAnomaly {
+		t.Error("Expected a rate of rise anomaly")
+	}
+}
+
+func TestNearest(t *testing.T) {
+	start := time.Date(2016, 1, 1, 0, 0, 0, 0, time.UTC)
+	h := &history{}
+	for i := 0; i < 10; i++ {
+		h.add(Sample{At: start.Add(time.Duration(i) * time.Minute), Temperature: i})
+	}
+	for _, tt := range []struct {
+		at        time.Duration
+		tolerance time.Duration
+		want      int
+		ok        bool
+	}{
+		{0, time.Second, 0, true},
+		{4*time.Minute + 20*time.Second, time.Minute, 4, true},
+		{4*time.Minute + 40*time.Second, time.Minute, 5, true},
+		{-30 * time.Second, time.Minute, 0, true},
+		{9*time.Minute + 30*time.Second, time.Minute, 9, true},
+		{4*time.Minute + 30*time.Second, 10 * time.Second, 0, false},
+		{-time.Hour, time.Minute, 0, false},
+		{time.Hour, time.Minute, 0, false},
+	} {
+		s, ok := h.nearest(start.Add(tt.at), tt.tolerance)
+		if ok != tt.ok || ok && s.Temperature != tt.want {
+			t.Errorf("nearest(%s, %s) = %d %v, expected %d %v", tt.at, tt.tolerance, s.Temperature, ok, tt.want, tt.ok)
+		}
+	}
+}
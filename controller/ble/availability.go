@@ -0,0 +1,107 @@
+package ble
+
+import "time"
+
+const availabilityKeep = 7 * 24 * time.Hour
+
+// Availability is the fraction of time a fixture was connected over
+// rolling windows, counted from when the controller started if that is
+// more recent.
+type Availability struct {
+	Day  float64 `json:"day"`
+	Week float64 `json:"week"`
+}
+
+type span struct {
+	from, to time.Time
+}
+
+// availability records the connected spans of one fixture.
+type availability struct {
+	spans []span
+	up    bool
+}
+
+func (a *availability) connected(now time.Time) {
+	if a.up {
+		return
+	}
+	a.up = true
+	a.spans = append(a.spans, span{from: now})
+}
+
+func (a *availability) disconnected(now time.Time) {
+	if !a.up {
+		return
+	}
+	a.up = false
+	a.spans[len(a.spans)-1].to = now
+
+	// Forget spans which can't affect any window
+	cutoff := now.Add(-availabilityKeep)
+	i := 0
+	for i < len(a.spans) && a.spans[i].to.Before(cutoff) {
+		i++
+	}
+	a.spans = a.spans[i:]
+}
+
+// ratio returns the connected fraction of the window ending now, which
+// starts no earlier than since.
+func (a *availability) ratio(window time.Duration, since, now time.Time) float64 {
+	start := now.Add(-window)
+	if since.After(start) {
+		start = since
+	}
+	total := now.Sub(start)
+	if total <= 0 {
+		return 0
+	}
+	var up time.Duration
+	for _, s := range a.spans {
+		from, to := s.from, s.to
+		if to.IsZero() {
+			to = now
+		}
+		if from.Before(start) {
+			from = start
+		}
+		if to.After(from) {
+			up += to.Sub(from)
+		}
+	}
+	return float64(up) / float64(total)
+}
+
+// availabilityFor returns the tracker for a peripheral ID, creating it
+// if needed. The caller must hold the channel lock.
+func (ble *bleChannel) availabilityFor(id string) *availability {
+	a, ok := ble.availability[id]
+	if !ok {
+		a = &availability{}
+		ble.availability[id] = a
+	}
+	return a
+}
+
+// Availability returns the availability of every fixture which has
+// connected or is expected.
+func (ble *bleChannel) Availability() map[string]Availability {
+	ble.lock.Lock()
+	defer ble.lock.Unlock()
+
+	for id, c := range ble.fixtures {
+		if c.Expected {
+			ble.availabilityFor(id)
+		}
+	}
+	now := ble.clock.Now()
+	result := make(map[string]Availability)
+	for id, a := range ble.availability {
+		result[id] = Availability{
+			Day:  a.ratio(24*time.Hour, ble.started, now),
+			Week: a.ratio(7*24*time.Hour, ble.started, now),
+		}
+	}
+	return result
+}
@@ -0,0 +1,27 @@
+package ble
+
+import (
+	"testing"
+	"time"
+)
+
+func TestAvailabilityRatio(t *testing.T) {
+	var a availability
+	start := time.Date(2016, 1, 1, 0, 0, 0, 0, time.UTC)
+
+	a.connected(start)
+	a.disconnected(start.Add(6 * time.Hour))
+	a.connected(start.Add(12 * time.Hour))
+	now := start.Add(24 * time.Hour)
+
+	if r := a.ratio(24*time.Hour, start, now); r != 0.75 {
+		t.Errorf("Expected 0.75 over the day, got %f", r)
+	}
+	// Controller only started at hour 12, and has been up since
+	if r := a.ratio(24*time.Hour, start.Add(12*time.Hour), now); r != 1 {
+		t.Errorf("Expected 1 since start, got %f", r)
+	}
+	if r := a.ratio(6*time.Hour, start, now); r != 1 {
+		t.Errorf("Expected 1 over the last 6 h, got %f", r)
+	}
+}
@@ -2,11 +2,17 @@ package ble
 
 import (
 	"errors"
+	"flag"
+	"fmt"
 	"github.com/paypal/gatt"
+	"github.com/theatrus/ledbrick/controller/clock"
+	"github.com/theatrus/ledbrick/controller/events"
+	"github.com/theatrus/ledbrick/controller/units"
 	"log"
+	"math"
+	"strings"
 	"sync"
 	"time"
-	"fmt"
 )
 
 const (
@@ -14,51 +20,272 @@ const (
 	pwmLedChar  = "000015251212efde1523785feabcd123"
 	pwmTempChar = "000015261212efde1523785feabcd123"
 	pwmFanChar  = "000015241212efde1523785feabcd123"
+
+	// Optional, firmware which accepts a fan duty byte
+	pwmFanConfigChar = "000015271212efde1523785feabcd123"
+	// Optional, firmware which reports per-channel current and faults
+	pwmStatusChar = "000015281212efde1523785feabcd123"
+	// Optional, firmware which can run a schedule on its own
+	pwmScheduleChar = "000015291212efde1523785feabcd123"
+	// Optional, firmware which reports what it supports as a bitmask
+	pwmCapsChar = "0000152a1212efde1523785feabcd123"
+	// Optional, firmware which takes a PWM frequency and dithering
+	pwmConfigChar = "0000152b1212efde1523785feabcd123"
+	// Optional, firmware which keeps a name for the fixture
+	pwmNameChar = "0000152c1212efde1523785feabcd123"
 )
 
+var expectFixtures int
+
+func init() {
+	flag.IntVar(&expectFixtures, "ble.expect", 0,
+		"Fixtures which must be connected five minutes after starting, restarting otherwise, none checked if 0")
+}
+
 var DefaultClientOptions = []gatt.Option{
 	gatt.LnxMaxConnections(10),
 	gatt.LnxDeviceID(-1, true),
 }
 
 type bleChannel struct {
-	device           gatt.Device
+	device           device
+	clock            clock.Clock
 	connectedPeriph  map[string]*blePeriph
 	knownPeriph      map[string]bool
 	ignoredPeriph    map[string]bool
-	connectingPeriph map[string]gatt.Peripheral
-	idleTicker       *time.Ticker
-
-	channelSetting map[int]float64
+	connectingPeriph map[string]peripheral
+	idleTicker       clock.Ticker
+
+	// The default tank, with any named ones in tanks
+	*tank
+	tanks        map[string]*tank
+	suspended    map[string]bool
+	outputs      map[string]*output
+	history      map[string]*history
+	fixtures     map[string]FixtureConfig
+	offline      map[string]*offline
+	degraded     degraded
+	disconnects  int
+	availability map[string]*availability
+	writeStats   map[string]*WriteStats
+	profiles     []DeviceProfile
+	started      time.Time
+	restored     restored
+	// measuring is how many callers have channel writes acknowledged
+	measuring int
+	// released is set once fixtures are handed back to their own
+	// schedules, after which nothing more is written
+	released bool
+	// burnIns are the percents fixtures burning in are held at
+	burnIns map[string]float64
+	// names are the fixtures' names by ID
+	names map[string]string
 
 	lock sync.Mutex
 }
 
 type blePeriph struct {
 	active   bool
-	gp       gatt.Peripheral
+	gp       peripheral
+	profile  *DeviceProfile
 	ledChar  *gatt.Characteristic
 	fanChar  *gatt.Characteristic
 	tempChar *gatt.Characteristic
 
+	fanConfigChar *gatt.Characteristic
+	statusChar    *gatt.Characteristic
+	scheduleChar  *gatt.Characteristic
+	pwmConfigChar *gatt.Characteristic
+	nameChar      *gatt.Characteristic
+	// name is the fixture's name, as kept on it if it can
+	name string
+	// framed is set for fixtures which take framed writes, and seq is
+	// the last sequence number sent
+	framed bool
+	seq    byte
+	// seqLock keeps framed writes in sequence, as table uploads write
+	// off the channel lock
+	seqLock sync.Mutex
+	// compact is set for fixtures which take compact program uploads,
+	// and an interrupted upload of the program with resumeSum carries
+	// on from chunk resumeFrom
+	compact    bool
+	resumeSum  uint32
+	resumeFrom int
+
 	temperature int
 	fanRpm      int
+	fanSeen     bool
+	fanFailed   bool
 	lastUpdate  time.Time
+	// notifies is set for fixtures which notify anything, and so
+	// are expected to keep updating lastUpdate
+	notifies bool
+	clock    clock.Clock
+	history  *history
+	output   *output
+	config   FixtureConfig
+	status   channelStatus
+
+	fanSteadyRpm   int
+	fanSteadySince time.Time
+	fanLow         bool
+	fanHigh        bool
+	fanStuck       bool
+	fanDuty        int
+	derating       float64
+	trendAnomaly   bool
+	failsafeSent   time.Time
+	// The tank program version last sent, and whether it was verified
+	programVersion int
+	programSent    time.Time
+	programOK      bool
+	programFailed  bool
+	heldUntil      time.Time
+	// upload is closed once the table upload in progress, if any,
+	// is done and recorded
+	upload         chan struct{}
+	pwmConfigSent  bool
+	fanCurveWarned bool
+	tempSeen       bool
+	// When telemetry last arrived
+	tempAt time.Time
+	fanAt  time.Time
+
+	// Notifications are queued rather than handled in the gatt
+	// callback, which must not block on the channel lock while a
+	// write holding it waits on the same connection.
+	notifications chan notification
+	done          chan struct{}
+	// lock is the channel lock, held while the channel updates the
+	// fixture, and by accessors reading it from other goroutines
+	lock *sync.Mutex
 }
 
+// guard holds the channel lock, if the fixture has one, returning the
+// function releasing it.
+func (p *blePeriph) guard() func() {
+	if p.lock == nil {
+		return func() {}
+	}
+	p.lock.Lock()
+	return p.lock.Unlock
+}
+
+type notification struct {
+	uuid string
+	b    []byte
+	at   time.Time
+}
+
+// How many notifications may be queued per fixture before dropping
+const notificationQueue = 32
+
 type BLEPeripheral interface {
+	ID() string
+	// Name is the fixture's given name, empty if it has none
+	Name() string
 	Active() bool
 	Temperature() int
 	FanRPM() int
+	FanFailed() bool
+	History() []Sample
+	// Estimated power draw in watts, and energy used in watt hours
+	Watts() float64
+	EnergyToday() float64
+	EnergyPrevDay() float64
+	EnergyMonth() float64
+	EnergyPrevMonth() float64
+	ChannelCurrents() []int
+	ChannelFaults() map[int]string
+}
+
+func (p *blePeriph) ID() string        { return p.gp.ID() }
+func (p *blePeriph) History() []Sample { return p.history.snapshot() }
+
+// Active and the telemetry are read under the channel lock, as
+// notifications are handled and disconnections noted under it.
+func (p *blePeriph) Active() bool {
+	defer p.guard()()
+	return p.active
+}
+
+func (p *blePeriph) Temperature() int {
+	defer p.guard()()
+	return p.temperature
 }
 
-func (p *blePeriph) Active() bool     { return p.active }
-func (p *blePeriph) Temperature() int { return p.temperature }
-func (p *blePeriph) FanRPM() int      { return p.fanRpm }
+func (p *blePeriph) FanRPM() int {
+	defer p.guard()()
+	return p.fanRpm
+}
+
+func (p *blePeriph) FanFailed() bool {
+	defer p.guard()()
+	return p.fanFailed
+}
 
 type BLEChannel interface {
 	Perhipherals() []BLEPeripheral
 	SetChannel(channel int, percent float64) error
+	// SetChannelImmediate sets a channel bypassing the output slew
+	// limiter, for effects such as lightning which must be abrupt.
+	SetChannelImmediate(channel int, percent float64) error
+	// SetLimit caps the output of every channel on every fixture at a
+	// percent until cleared. Each named limit is independent, and the
+	// lowest one wins.
+	SetLimit(name string, percent float64) error
+	ClearLimit(name string)
+	Limits() map[string]float64
+	// SetScale multiplies every channel's setting by a factor until
+	// cleared, before limits apply. Named scales multiply together.
+	SetScale(name string, factor float64) error
+	ClearScale(name string)
+	Scales() map[string]float64
+	// SuspendEffects makes immediate writes go through the slew
+	// limiter like any other until every named suspension is resumed.
+	SuspendEffects(name string)
+	ResumeEffects(name string)
+	EffectsSuspended() bool
+	// Degraded reports whether the link has been unstable enough that
+	// writes are slowed and outputs held.
+	Degraded() bool
+	// Exposure reports today's time spent above the exposure threshold
+	// by channel.
+	Exposure() map[int]time.Duration
+	// Channels returns the percent each channel was last driven to,
+	// after limits but before per-fixture caps.
+	Channels() map[int]float64
+	// DisconnectCount is the total number of disconnects since start.
+	DisconnectCount() int
+	Availability() map[string]Availability
+	// WriteStats returns channel write counts and latency by fixture ID.
+	WriteStats() map[string]WriteStats
+	// Power estimates the watts the fixtures would draw with channels
+	// at the given percents.
+	Power(percents map[int]float64) float64
+	// Tanks names the tanks fixtures are assigned to, and Tank returns
+	// a channel scoped to one, or the whole controller for "".
+	Tanks() []string
+	Tank(name string) BLEChannel
+	// SetProgram sets the table uploaded to fixtures which can run it
+	// on their own, kept in sync as it changes.
+	SetProgram(prog Program)
+	// Release hands fixtures running the table back to their own
+	// schedules and stops writing to them, for a graceful shutdown.
+	Release()
+	// BurnIn drives every channel of one fixture at a percent, apart
+	// from its tank, until EndBurnIn.
+	BurnIn(id string, percent float64) error
+	EndBurnIn(id string)
+	// SetName names a fixture, or clears its name if empty. The name is
+	// kept on fixtures which can, so it survives the controller being
+	// reinstalled.
+	SetName(id, name string) error
+	// SetFanOverride runs the fans of fixtures with a fan curve at
+	// least at a duty percent, for a fan scheduled as an accessory, or
+	// on their curve alone at 0. It never runs a fan slower.
+	SetFanOverride(percent float64) error
 }
 
 func NewBLEChannel() BLEChannel {
@@ -67,47 +294,63 @@ func NewBLEChannel() BLEChannel {
 		log.Fatalf("Failed to open the bluetooth HCI device: %s\n", err)
 		return nil
 	}
-
-	ble := &bleChannel{device: d,
-		connectedPeriph:  make(map[string]*blePeriph),
-		knownPeriph:      make(map[string]bool),
-		ignoredPeriph:    make(map[string]bool),
-		connectingPeriph: make(map[string]gatt.Peripheral),
-		idleTicker:       time.NewTicker(1000 * time.Millisecond),
-		channelSetting:   make(map[int]float64),
+	fixtures, err := loadFixtures()
+	if err != nil {
+		log.Fatalf("Failed to load fixture settings: %s\n", err)
+		return nil
+	}
+	names, err := loadNames()
+	if err != nil {
+		log.Fatalf("Failed to load fixture names: %s\n", err)
+		return nil
+	}
+	profiles, err := loadProfiles()
+	if err != nil {
+		log.Fatalf("Failed to load device profiles: %s\n", err)
+		return nil
 	}
 
+	ble := newBLEChannel(gattDevice{d}, fixtures)
+	ble.profiles = profiles
+	ble.names = names
+	ble.idleTicker = ble.clock.NewTicker(refresh)
+	ble.loadHistory()
+	ble.loadState(ble.clock.Now())
+
 	d.Handle(
-		gatt.PeripheralDiscovered(ble.onPeriphDiscovered),
-		gatt.PeripheralConnected(ble.onPeriphConnected),
-		gatt.PeripheralDisconnected(ble.onPeriphDisconnected),
+		gatt.PeripheralDiscovered(func(p gatt.Peripheral, a *gatt.Advertisement, rssi int) {
+			ble.onPeriphDiscovered(p, a, rssi)
+		}),
+		gatt.PeripheralConnected(func(p gatt.Peripheral, err error) {
+			ble.onPeriphConnected(p, err)
+		}),
+		gatt.PeripheralDisconnected(func(p gatt.Peripheral, err error) {
+			ble.onPeriphDisconnected(p, err)
+		}),
 	)
 
 	d.Init(ble.onStateChanged)
-
-	// Green CYan PCAmber Blue Red DeepBlue White UV
-	// Percents
-	initPower := []int{10, 30, 10, 40, 10, 40, 30, 40}
-	for i, v := range initPower {
-		ble.channelSetting[i] = float64(v)
-	}
+	ble.startTransports()
 
 	go func() {
-		startTime := time.Now()
-		for _ = range ble.idleTicker.C {
-			// Check for four units (hack)
-			if startTime.Add(5 * time.Minute).Before(time.Now()) {
-				if len(ble.connectedPeriph) < 4 {
-					panic(fmt.Sprintf("PANIC: Not four lights connected"))
-				}
+		startTime := ble.clock.Now()
+		lastSave := startTime
+		lastStateSave := startTime
+		for _ = range ble.idleTicker.C() {
+			if lastSave.Add(10 * time.Minute).Before(ble.clock.Now()) {
+				ble.saveHistory()
+				lastSave = ble.clock.Now()
 			}
-			// Check for active units
-			for _, bp := range ble.connectedPeriph {
-				if bp.lastUpdate.Add(5 * time.Minute).Before(time.Now()) {
-					// Uhoh, no update
-					panic(fmt.Sprintf("PANIC: No updates from %v", bp.gp))
-				}
+			if lastStateSave.Add(stateInterval).Before(ble.clock.Now()) {
+				ble.saveState(ble.clock.Now())
+				lastStateSave = ble.clock.Now()
 			}
+			ble.lock.Lock()
+			ble.checkExpected(startTime, ble.clock.Now())
+			ble.checkStalled(ble.clock.Now())
+			ble.checkOffline(ble.clock.Now())
+			ble.expireRestored(ble.clock.Now())
+			ble.lock.Unlock()
 			_ = ble.writeLedState()
 		}
 	}()
@@ -115,27 +358,154 @@ func NewBLEChannel() BLEChannel {
 	return ble
 }
 
+// checkExpected panics when fewer than -ble.expect fixtures are
+// connected five minutes after start, so the controller restarts and
+// scans again. The caller must hold the channel lock.
+func (ble *bleChannel) checkExpected(start, now time.Time) {
+	if expectFixtures > 0 && start.Add(5*time.Minute).Before(now) && len(ble.connectedPeriph) < expectFixtures {
+		panic(fmt.Sprintf("PANIC: %d of %d fixtures connected", len(ble.connectedPeriph), expectFixtures))
+	}
+}
+
+// checkStalled panics when a fixture which sends telemetry has sent
+// none for five minutes, so the controller restarts and reconnects.
+// Fixtures with nothing to notify, such as those behind a gateway, are
+// left to their transport to notice lost. The caller must hold the
+// channel lock.
+func (ble *bleChannel) checkStalled(now time.Time) {
+	for _, bp := range ble.connectedPeriph {
+		if bp.notifies && bp.lastUpdate.Add(5*time.Minute).Before(now) {
+			// Uhoh, no update
+			panic(fmt.Sprintf("PANIC: No updates from %v", bp.gp))
+		}
+	}
+}
+
+// newBLEChannel sets up a channel around a device without starting
+// anything, which is left to NewBLEChannel.
+func newBLEChannel(d device, fixtures map[string]FixtureConfig) *bleChannel {
+	ble := &bleChannel{device: d,
+		clock:            clock.Real,
+		connectedPeriph:  make(map[string]*blePeriph),
+		knownPeriph:      make(map[string]bool),
+		ignoredPeriph:    make(map[string]bool),
+		connectingPeriph: make(map[string]peripheral),
+		tank:             newTank(),
+		tanks:            make(map[string]*tank),
+		burnIns:          make(map[string]float64),
+		names:            make(map[string]string),
+		suspended:        make(map[string]bool),
+		outputs:          make(map[string]*output),
+		history:          make(map[string]*history),
+		fixtures:         fixtures,
+		offline:          make(map[string]*offline),
+		availability:     make(map[string]*availability),
+		writeStats:       make(map[string]*WriteStats),
+		profiles:         []DeviceProfile{v1Profile, wifiProfile},
+		started:          clock.Real.Now(),
+	}
+
+	// Green CYan PCAmber Blue Red DeepBlue White UV
+	// Percents
+	initPower := []int{10, 30, 10, 40, 10, 40, 30, 40}
+	for i, v := range initPower {
+		ble.channelSetting[i] = float64(v)
+	}
+	return ble
+}
+
 func (ble *bleChannel) writeLedState() error {
 
 	ble.lock.Lock()
 	defer ble.lock.Unlock()
 
+	if ble.released {
+		return nil
+	}
+	now := ble.clock.Now()
+	if !ble.checkDegraded(now) {
+		return nil
+	}
+
+	// Work out every tank with fixtures connected, and the default
+	// tank always as it reports the controller's channels
+	outputs := map[*tank]float64{ble.tank: ble.tank.update(ble.tank, now)}
+	for _, p := range ble.connectedPeriph {
+		t := ble.tankFor(p.config.Tank)
+		if _, ok := outputs[t]; !ok {
+			outputs[t] = t.update(ble.tank, now)
+		}
+	}
+
+	// The highest percent each tank's channels were written to, which
+	// counts against their exposure budget
+	written := make(map[*tank]map[int]float64)
 	for _, p := range ble.connectedPeriph {
+		t := ble.tankFor(p.config.Tank)
+		if written[t] == nil {
+			written[t] = make(map[int]float64)
+		}
+		p.checkFan(outputs[t], now)
+		p.checkDerating()
+		p.checkHeatSoak()
+		o := p.output
+		stats := ble.writeStatsFor(p.gp.ID())
 		for channel := 0; channel <= 7; channel++ {
-			// Max intensity limit is about 0xfa
-			value := int((ble.channelSetting[channel] / 100.0) * 250.0)
-			err := p.gp.WriteCharacteristic(p.ledChar,
-				[]byte{byte(channel), byte(value)}, true)
+			output := p.config.output(channel)
+			if output >= p.profile.Channels {
+				continue
+			}
+			want := ble.want(p, t.wants[channel])
+			immediate := t.immediate[channel] && !ble.effectsSuspended() && len(t.suspended) == 0 ||
+				t.forcing && want < o.percents[channel]
+			percent := o.next(channel, want, immediate, now)
+			start := ble.clock.Now()
+			err := p.write(p.ledChar, p.profile.encode(output, percent), ble.measuring == 0)
+			stats.record(ble.clock.Now().Sub(start), err)
 			if err != nil {
-				log.Println("Command send error: %s", err)
+				log.Printf("Command send error: %s", err)
+			} else if percent > written[t][channel] {
+				written[t][channel] = percent
 			}
 		}
-
+		p.writeFanCurve(math.Max(ble.tank.fanOverride, t.fanOverride))
+		p.writePWMConfig()
+		if !p.writeProgram(t, now) {
+			p.writeFailsafe(now)
+		}
+		p.checkChannels(o.percents, now)
+		p.recordHeatSoak(now.Sub(o.at), now)
+		o.record(p.config.ChannelWatts, now)
+	}
+	for t, w := range written {
+		t.exposure.record(w)
+	}
+	for t := range outputs {
+		t.immediate = make(map[int]bool)
 	}
 	return nil
 }
 
+func (ble *bleChannel) Channels() map[int]float64 {
+	ble.lock.Lock()
+	defer ble.lock.Unlock()
+	channels := make(map[int]float64)
+	for channel, v := range ble.wants {
+		channels[channel] = v
+	}
+	return channels
+}
+
+func (ble *bleChannel) DisconnectCount() int {
+	ble.lock.Lock()
+	defer ble.lock.Unlock()
+	return ble.disconnects
+}
+
 func (ble *bleChannel) Perhipherals() []BLEPeripheral {
+	ble.lock.Lock()
+	defer ble.lock.Unlock()
+
 	p := make([]BLEPeripheral, 0)
 	for _, periph := range ble.connectedPeriph {
 		p = append(p, periph)
@@ -147,10 +517,153 @@ func (ble *bleChannel) SetChannel(channel int, percent float64) error {
 	if percent < 0 || percent > 100 {
 		return errors.New("Out of range percent (0-100)")
 	}
+	ble.lock.Lock()
+	defer ble.lock.Unlock()
+	ble.channelSetting[channel] = percent
+	return nil
+}
+
+func (ble *bleChannel) SetChannelImmediate(channel int, percent float64) error {
+	if percent < 0 || percent > 100 {
+		return errors.New("Out of range percent (0-100)")
+	}
+	ble.lock.Lock()
+	defer ble.lock.Unlock()
 	ble.channelSetting[channel] = percent
+	ble.immediate[channel] = true
+	return nil
+}
+
+func (ble *bleChannel) SetFanOverride(percent float64) error {
+	if percent < 0 || percent > 100 {
+		return errors.New("Out of range percent (0-100)")
+	}
+	ble.lock.Lock()
+	defer ble.lock.Unlock()
+	ble.tank.fanOverride = percent
+	return nil
+}
+
+func (ble *bleChannel) SetLimit(name string, percent float64) error {
+	if percent < 0 || percent > 100 {
+		return errors.New("Out of range percent (0-100)")
+	}
+	ble.lock.Lock()
+	defer ble.lock.Unlock()
+	if _, ok := ble.limits[name]; !ok {
+		log.Printf("Output limited to %.1f%% by %s", percent, name)
+	}
+	ble.limits[name] = percent
+	delete(ble.restored.limits, name)
+	return nil
+}
+
+func (ble *bleChannel) ClearLimit(name string) {
+	ble.lock.Lock()
+	defer ble.lock.Unlock()
+	if _, ok := ble.limits[name]; ok {
+		log.Printf("Output limit from %s cleared", name)
+	}
+	delete(ble.limits, name)
+	delete(ble.forced, name)
+	delete(ble.restored.limits, name)
+}
+
+// A Forcer applies limits at once rather than through the output slew
+// limiter, for interlocks which must make the tank safe immediately.
+// Forced limits are cleared with ClearLimit, and output comes back up
+// at the slew rate.
+type Forcer interface {
+	ForceLimit(name string, percent float64) error
+}
+
+func (ble *bleChannel) ForceLimit(name string, percent float64) error {
+	if err := ble.SetLimit(name, percent); err != nil {
+		return err
+	}
+	ble.lock.Lock()
+	defer ble.lock.Unlock()
+	ble.forced[name] = true
 	return nil
 }
 
+func (ble *bleChannel) Limits() map[string]float64 {
+	ble.lock.Lock()
+	defer ble.lock.Unlock()
+	limits := make(map[string]float64)
+	for name, l := range ble.limits {
+		limits[name] = l
+	}
+	return limits
+}
+
+func (ble *bleChannel) SetScale(name string, factor float64) error {
+	if factor < 0 || factor > 2 {
+		return errors.New("Out of range scale (0-2)")
+	}
+	ble.lock.Lock()
+	defer ble.lock.Unlock()
+	if _, ok := ble.scales[name]; !ok {
+		log.Printf("Output scaled by %.2f from %s", factor, name)
+	}
+	ble.scales[name] = factor
+	delete(ble.restored.scales, name)
+	return nil
+}
+
+func (ble *bleChannel) ClearScale(name string) {
+	ble.lock.Lock()
+	defer ble.lock.Unlock()
+	if _, ok := ble.scales[name]; ok {
+		log.Printf("Output scale from %s cleared", name)
+	}
+	delete(ble.scales, name)
+	delete(ble.restored.scales, name)
+}
+
+func (ble *bleChannel) Scales() map[string]float64 {
+	ble.lock.Lock()
+	defer ble.lock.Unlock()
+	scales := make(map[string]float64)
+	for name, f := range ble.scales {
+		scales[name] = f
+	}
+	return scales
+}
+
+func (ble *bleChannel) SuspendEffects(name string) {
+	ble.lock.Lock()
+	defer ble.lock.Unlock()
+	if !ble.suspended[name] {
+		log.Printf("Effects suspended by %s", name)
+		events.Publish(events.Effects, events.EffectsData{By: name, Suspended: true})
+	}
+	ble.suspended[name] = true
+	delete(ble.restored.suspended, name)
+}
+
+func (ble *bleChannel) ResumeEffects(name string) {
+	ble.lock.Lock()
+	defer ble.lock.Unlock()
+	if ble.suspended[name] {
+		log.Printf("Effects resumed by %s", name)
+		events.Publish(events.Effects, events.EffectsData{By: name})
+	}
+	delete(ble.suspended, name)
+	delete(ble.restored.suspended, name)
+}
+
+func (ble *bleChannel) EffectsSuspended() bool {
+	ble.lock.Lock()
+	defer ble.lock.Unlock()
+	return ble.effectsSuspended()
+}
+
+// effectsSuspended must be called with the channel lock held.
+func (ble *bleChannel) effectsSuspended() bool {
+	return ble.degraded.active || len(ble.suspended) > 0
+}
+
 // Force Gatt to enter scanning mode
 func (ble *bleChannel) onStateChanged(d gatt.Device, s gatt.State) {
 	log.Println("State:", s)
@@ -165,19 +678,80 @@ func (ble *bleChannel) onStateChanged(d gatt.Device, s gatt.State) {
 	}
 }
 
-func (ble *bleChannel) onPeriphConnected(p gatt.Peripheral, err error) {
+func (ble *bleChannel) onPeriphConnected(p peripheral, err error) {
+	if err != nil {
+		log.Printf("Failed to connect to %s: %s", p.ID(), err)
+		ble.lock.Lock()
+		delete(ble.connectingPeriph, p.ID())
+		ble.lock.Unlock()
+		return
+	}
 
 	log.Println("Connected, starting interrogation of ", p.ID())
-	bp := blePeriph{gp: p,
-		active: true,
-		lastUpdate: time.Now(),
+	bp := &blePeriph{gp: p,
+		profile:       ble.profileFor(p.Name()),
+		active:        true,
+		fanDuty:       -1,
+		derating:      100,
+		lastUpdate:    ble.clock.Now(),
+		clock:         ble.clock,
+		notifications: make(chan notification, notificationQueue),
+		done:          make(chan struct{}),
+		lock:          &ble.lock,
+	}
+	ble.lock.Lock()
+	bp.history = ble.historyFor(p.ID())
+	bp.output = ble.outputFor(p.ID())
+	bp.output.resume(ble.clock.Now())
+	bp.config = ble.fixtureConfig(p.ID())
+	ble.lock.Unlock()
+
+	if err := bp.interrogate(); err != nil {
+		log.Printf("Interrogation of %s failed: %s", p.ID(), err)
+		ble.lock.Lock()
+		delete(ble.connectingPeriph, p.ID())
+		ble.lock.Unlock()
+		ble.device.CancelConnection(p)
+		return
+	}
+
+	ble.lock.Lock()
+	defer ble.lock.Unlock()
+
+	// A disconnect or the pending timeout while interrogating takes the
+	// peripheral out of the connecting pool, and it must not then be
+	// treated as connected.
+	if ble.connectingPeriph[p.ID()] != p {
+		log.Printf("Peripheral %s went away during interrogation", p.ID())
+		ble.device.CancelConnection(p)
+		return
+	}
+	delete(ble.connectingPeriph, p.ID())
+
+	if old := ble.connectedPeriph[p.ID()]; old != nil {
+		old.active = false
+		close(old.done)
+	}
+	ble.connectedPeriph[p.ID()] = bp
+	ble.syncName(bp)
+	ble.availabilityFor(p.ID()).connected(ble.clock.Now())
+	events.Publish(events.Connection, events.ConnectionData{Fixture: p.ID(), Connected: true})
+	go ble.handleNotifications(bp)
+	log.Printf("Peripheral connection complete: %s", p.ID())
+}
+
+// interrogate discovers the fixture's characteristics and subscribes to
+// its notifications.
+func (bp *blePeriph) interrogate() error {
+	p := bp.gp
+	if bp.profile == nil {
+		return errors.New("no device profile")
 	}
 
 	// Discovery services
 	ss, err := p.DiscoverServices(nil)
 	if err != nil {
-		log.Printf("Failed to discover services, err: %s\n", err)
-		return
+		return fmt.Errorf("failed to discover services: %s", err)
 	}
 
 	for _, s := range ss {
@@ -190,22 +764,32 @@ func (ble *bleChannel) onPeriphConnected(p gatt.Peripheral, err error) {
 		// Discovery characteristics
 		cs, err := p.DiscoverCharacteristics(nil, s)
 		if err != nil {
-			log.Printf("Failed to discover characteristics, err: %s\n", err)
-			return
+			return fmt.Errorf("failed to discover characteristics: %s", err)
 		}
 
 		for _, c := range cs {
 			msg := "  Characteristic  " + c.UUID().String()
 
-			// Grab and store the three characteristics we
-			// case about by matching by UUID
+			// Grab and store the characteristics we care
+			// about by matching by UUID
+			d := bp.profile
 			switch c.UUID().String() {
-			case pwmLedChar:
+			case d.LedChar:
 				bp.ledChar = c
-			case pwmTempChar:
+			case d.TempChar:
 				bp.tempChar = c
-			case pwmFanChar:
+			case d.FanChar:
 				bp.fanChar = c
+			case d.FanConfigChar:
+				bp.fanConfigChar = c
+			case d.StatusChar:
+				bp.statusChar = c
+			case d.ScheduleChar:
+				bp.scheduleChar = c
+			case d.PWMConfigChar:
+				bp.pwmConfigChar = c
+			case d.NameChar:
+				bp.nameChar = c
 			}
 
 			if len(c.Name()) > 0 {
@@ -218,17 +802,22 @@ func (ble *bleChannel) onPeriphConnected(p gatt.Peripheral, err error) {
 			if (c.Properties() & gatt.CharRead) != 0 {
 				b, err := p.ReadCharacteristic(c)
 				if err != nil {
-					log.Printf("Failed to read characteristic, err: %s\n", err)
-					return
+					return fmt.Errorf("failed to read characteristic: %s", err)
 				}
 				log.Printf("    value         %x | %q\n", b, b)
+				if c.UUID().String() == bp.profile.CapsChar && len(b) > 0 {
+					bp.framed = framedWrites && b[0]&capFramed != 0
+					bp.compact = b[0]&capCompact != 0
+				}
+				if c.UUID().String() == bp.profile.NameChar {
+					bp.name = strings.TrimRight(string(b), "\x00")
+				}
 			}
 
 			// Discovery descriptors
 			ds, err := p.DiscoverDescriptors(nil, c)
 			if err != nil {
-				log.Printf("Failed to discover descriptors, err: %s\n", err)
-				return
+				return fmt.Errorf("failed to discover descriptors: %s", err)
 			}
 
 			for _, d := range ds {
@@ -241,48 +830,106 @@ func (ble *bleChannel) onPeriphConnected(p gatt.Peripheral, err error) {
 				// Read descriptor (could fail, if it's not readable)
 				b, err := p.ReadDescriptor(d)
 				if err != nil {
-					log.Printf("Failed to read descriptor, err: %s\n", err)
-					return
+					return fmt.Errorf("failed to read descriptor: %s", err)
 				}
 				log.Printf("    value         %x | %q\n", b, b)
 			}
 
 			// Subscribe the characteristic, if possible.
 			if (c.Properties() & (gatt.CharNotify | gatt.CharIndicate)) != 0 {
-				f := func(c *gatt.Characteristic, b []byte, err error) {
-					//log.Printf("%s: % X | %q\n", p.ID(), b, b)
-					bp.lastUpdate = time.Now()
-					switch c.UUID().String() {
-					case pwmTempChar:
-						bp.temperature = int(b[0])
-						log.Printf("%s: temperature: %d C", p.ID(), bp.temperature)
-					case pwmFanChar:
-						bp.fanRpm = int(b[0]) | (int(b[1]) << 8)
-						log.Printf("%s: fan speed: %d rpm", p.ID(), bp.fanRpm)
-					default:
-						log.Printf("unknown notification from %s", p.ID())
-					}
-				}
-				if err := p.SetNotifyValue(c, f); err != nil {
-					log.Printf("Failed to subscribe characteristic, err: %s\n", err)
-					return
+				if err := p.SetNotifyValue(c, bp.queueNotification); err != nil {
+					return fmt.Errorf("failed to subscribe characteristic: %s", err)
 				}
+				bp.notifies = true
 			}
-
 		}
 	}
 
-	ble.lock.Lock()
-	defer ble.lock.Unlock()
+	if bp.ledChar == nil {
+		return errors.New("no LED characteristic")
+	}
+	return nil
+}
 
-	// Remove from the connecting pool
-	delete(ble.connectingPeriph, p.ID())
+// queueNotification is the gatt notification callback.
+func (bp *blePeriph) queueNotification(c *gatt.Characteristic, b []byte, err error) {
+	if err != nil {
+		log.Printf("%s: notification error: %s", bp.gp.ID(), err)
+		return
+	}
+	n := notification{uuid: c.UUID().String(),
+		b:  append([]byte(nil), b...),
+		at: bp.clock.Now(),
+	}
+	select {
+	case bp.notifications <- n:
+	default:
+		log.Printf("%s: notification queue full, dropping", bp.gp.ID())
+	}
+}
 
-	ble.connectedPeriph[p.ID()] = &bp
-	log.Printf("Peripheral connection complete: %s", p.ID())
+func (ble *bleChannel) handleNotifications(bp *blePeriph) {
+	for {
+		select {
+		case n := <-bp.notifications:
+			ble.lock.Lock()
+			bp.notify(n)
+			ble.lock.Unlock()
+		case <-bp.done:
+			return
+		}
+	}
 }
 
-func (ble *bleChannel) onPeriphDiscovered(p gatt.Peripheral, a *gatt.Advertisement, rssi int) {
+// notify applies a notification. The caller must hold the channel lock.
+func (bp *blePeriph) notify(n notification) {
+	//log.Printf("%s: % X | %q\n", bp.gp.ID(), n.b, n.b)
+	bp.lastUpdate = n.at
+	b := n.b
+	d := bp.profile
+	switch n.uuid {
+	case d.TempChar:
+		if len(b) < 1 {
+			log.Printf("%s: short temperature notification", bp.gp.ID())
+			return
+		}
+		bp.temperature = int(b[0])
+		bp.tempSeen = true
+		bp.tempAt = n.at
+		log.Printf("%s: temperature: %s", bp.gp.ID(), units.Format(float64(bp.temperature), 0))
+	case d.FanChar:
+		if len(b) < 2 {
+			log.Printf("%s: short fan notification", bp.gp.ID())
+			return
+		}
+		bp.fanReport(int(b[0])|(int(b[1])<<8), n.at)
+		log.Printf("%s: fan speed: %d rpm", bp.gp.ID(), bp.fanRpm)
+	case d.StatusChar:
+		if err := bp.status.report(b, n.at); err != nil {
+			log.Printf("%s: %s", bp.gp.ID(), err)
+		}
+		return
+	default:
+		log.Printf("unknown notification from %s", bp.gp.ID())
+		return
+	}
+	s := Sample{At: n.at,
+		Temperature: bp.temperature,
+		FanRPM:      bp.fanRpm,
+	}
+	if bp.output != nil && len(bp.output.percents) > 0 {
+		s.Channels = make(map[int]float64, len(bp.output.percents))
+		for channel, percent := range bp.output.percents {
+			s.Channels[channel] = percent
+		}
+	}
+	bp.history.add(s)
+	if n.uuid == d.TempChar {
+		bp.checkTemperatureTrend(n.at)
+	}
+}
+
+func (ble *bleChannel) onPeriphDiscovered(p peripheral, a *gatt.Advertisement, rssi int) {
 	ble.lock.Lock()
 	defer ble.lock.Unlock()
 
@@ -295,6 +942,9 @@ func (ble *bleChannel) onPeriphDiscovered(p gatt.Peripheral, a *gatt.Advertiseme
 		log.Printf("Peripheral is in connecting state: %s", p.ID())
 		return
 	}
+	if _, ok := ble.connectedPeriph[p.ID()]; ok {
+		return
+	}
 
 	log.Printf("Peripheral ID:%s, NAME:(%s)\n", p.ID(), p.Name())
 	log.Println("  Local Name        =", a.LocalName)
@@ -303,27 +953,31 @@ func (ble *bleChannel) onPeriphDiscovered(p gatt.Peripheral, a *gatt.Advertiseme
 	log.Println("  Service Data      =", a.ServiceData)
 	log.Println("")
 
-	if p.Name() != "LEDBrick-PWM" {
+	d := ble.profileFor(p.Name())
+	if d == nil {
 		ble.ignoredPeriph[p.ID()] = true
 		log.Println("Ignoring this device.")
 		return
 	}
 
-	log.Printf("Connecting to %s", p.ID())
+	log.Printf("Connecting to %s, a %s", p.ID(), d.Name)
 	ble.connectingPeriph[p.ID()] = p
-	go func() {
-		time.Sleep(30 * time.Second)
-		if _, ok := ble.connectedPeriph[p.ID()]; ok {
-			return
-		} else {
+	ble.clock.AfterFunc(connectTimeout, func() {
+		ble.lock.Lock()
+		defer ble.lock.Unlock()
+		// Only give up on this attempt, not a later one
+		if ble.connectingPeriph[p.ID()] == p {
 			delete(ble.connectingPeriph, p.ID())
 			log.Printf("Haven't heard back about connection to %s, removing from pending pool", p.ID())
 		}
-	}()
-	p.Device().Connect(p)
+	})
+	ble.device.Connect(p)
 }
 
-func (ble *bleChannel) onPeriphDisconnected(p gatt.Peripheral, err error) {
+// How long a connection attempt, including interrogation, may take
+var connectTimeout = 30 * time.Second
+
+func (ble *bleChannel) onPeriphDisconnected(p peripheral, err error) {
 	ble.lock.Lock()
 	defer ble.lock.Unlock()
 
@@ -335,10 +989,20 @@ func (ble *bleChannel) onPeriphDisconnected(p gatt.Peripheral, err error) {
 	// boolean suffices.
 	if localPeriph != nil {
 		localPeriph.active = false
+		close(localPeriph.done)
+		if localPeriph.output != nil {
+			localPeriph.output.disconnected()
+		}
 	}
 
 	delete(ble.connectedPeriph, p.ID())
+	// A disconnect during interrogation abandons the attempt
+	delete(ble.connectingPeriph, p.ID())
+	ble.recordDisconnect(ble.clock.Now())
+	ble.disconnects++
+	ble.availabilityFor(p.ID()).disconnected(ble.clock.Now())
+	events.Publish(events.Connection, events.ConnectionData{Fixture: p.ID()})
 	// We re-cancel the connection here, which will free any associated
 	// channels if this disconnect is due to the peripheral initiating the disconnect
-	p.Device().CancelConnection(p)
+	ble.device.CancelConnection(p)
 }
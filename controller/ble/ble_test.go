@@ -0,0 +1,393 @@
+package ble
+
+import (
+	"errors"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/paypal/gatt"
+	"github.com/theatrus/ledbrick/controller/clock"
+)
+
+// fakeDevice records connection requests.
+type fakeDevice struct {
+	connects []string
+	cancels  []string
+}
+
+func (d *fakeDevice) Connect(p peripheral)          { d.connects = append(d.connects, p.ID()) }
+func (d *fakeDevice) CancelConnection(p peripheral) { d.cancels = append(d.cancels, p.ID()) }
+
+// fakeFixture is a peripheral with the LEDBrick service.
+type fakeFixture struct {
+	id    string
+	name  string
+	chars []*gatt.Characteristic
+
+	discoverErr error
+	writeErr    error
+	// Read back from the schedule and capabilities characteristics
+	schedule []byte
+	caps     []byte
+	label    []byte
+	// How many writes fail before they succeed again
+	writeFails int
+	// Called during interrogation, to race events against it
+	interrogating func()
+
+	lock    sync.Mutex
+	writes  [][]byte
+	notify  map[string]func(*gatt.Characteristic, []byte, error)
+	charFor map[string]*gatt.Characteristic
+	// acked counts the writes asking for a response
+	acked int
+}
+
+func newFakeFixture(id string, uuids ...string) *fakeFixture {
+	f := &fakeFixture{id: id, name: "LEDBrick-PWM",
+		notify:  make(map[string]func(*gatt.Characteristic, []byte, error)),
+		charFor: make(map[string]*gatt.Characteristic),
+	}
+	if len(uuids) == 0 {
+		uuids = []string{pwmLedChar, pwmFanChar, pwmTempChar}
+	}
+	s := gatt.NewService(gatt.MustParseUUID(pwmService))
+	for _, u := range uuids {
+		props := gatt.CharRead | gatt.CharNotify
+		if u == pwmLedChar {
+			props = gatt.CharRead | gatt.CharWrite
+		}
+		c := gatt.NewCharacteristic(gatt.MustParseUUID(u), s, props, 0, 0)
+		f.chars = append(f.chars, c)
+		f.charFor[u] = c
+	}
+	return f
+}
+
+func (f *fakeFixture) ID() string   { return f.id }
+func (f *fakeFixture) Name() string { return f.name }
+
+func (f *fakeFixture) DiscoverServices(s []gatt.UUID) ([]*gatt.Service, error) {
+	if f.interrogating != nil {
+		f.interrogating()
+	}
+	if f.discoverErr != nil {
+		return nil, f.discoverErr
+	}
+	return []*gatt.Service{gatt.NewService(gatt.MustParseUUID(pwmService))}, nil
+}
+
+func (f *fakeFixture) DiscoverCharacteristics(c []gatt.UUID, s *gatt.Service) ([]*gatt.Characteristic, error) {
+	return f.chars, nil
+}
+
+func (f *fakeFixture) DiscoverDescriptors(d []gatt.UUID, c *gatt.Characteristic) ([]*gatt.Descriptor, error) {
+	return nil, nil
+}
+
+func (f *fakeFixture) ReadCharacteristic(c *gatt.Characteristic) ([]byte, error) {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+	if c.UUID().String() == pwmScheduleChar && f.schedule != nil {
+		return f.schedule, nil
+	}
+	if c.UUID().String() == pwmCapsChar && f.caps != nil {
+		return f.caps, nil
+	}
+	if c.UUID().String() == pwmNameChar {
+		return f.label, nil
+	}
+	return []byte{0, 0}, nil
+}
+
+func (f *fakeFixture) ReadDescriptor(d *gatt.Descriptor) ([]byte, error) { return nil, nil }
+
+func (f *fakeFixture) WriteCharacteristic(c *gatt.Characteristic, b []byte, noRsp bool) error {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+	f.writes = append(f.writes, b)
+	if !noRsp {
+		f.acked++
+	}
+	if f.writeFails > 0 {
+		f.writeFails--
+		return errors.New("write not acknowledged")
+	}
+	return f.writeErr
+}
+
+func (f *fakeFixture) SetNotifyValue(c *gatt.Characteristic, fn func(*gatt.Characteristic, []byte, error)) error {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+	f.notify[c.UUID().String()] = fn
+	return nil
+}
+
+// send delivers a notification as the gatt read loop would.
+func (f *fakeFixture) send(uuid string, b []byte) {
+	f.lock.Lock()
+	fn := f.notify[uuid]
+	f.lock.Unlock()
+	fn(f.charFor[uuid], b, nil)
+}
+
+func newTestChannel() (*bleChannel, *fakeDevice) {
+	d := &fakeDevice{}
+	return newBLEChannel(d, make(map[string]FixtureConfig)), d
+}
+
+func connect(ble *bleChannel, f *fakeFixture) {
+	ble.onPeriphDiscovered(f, &gatt.Advertisement{}, -50)
+	ble.onPeriphConnected(f, nil)
+}
+
+func TestDiscoveryFiltering(t *testing.T) {
+	ble, d := newTestChannel()
+
+	other := newFakeFixture("other")
+	other.name = "Some-Watch"
+	ble.onPeriphDiscovered(other, &gatt.Advertisement{}, -50)
+	ble.onPeriphDiscovered(other, &gatt.Advertisement{}, -50)
+	if len(d.connects) != 0 {
+		t.Errorf("Expected other devices to be ignored, connected to %v", d.connects)
+	}
+	if !ble.ignoredPeriph["other"] {
+		t.Error("Expected other device to be remembered as ignored")
+	}
+
+	f := newFakeFixture("f1")
+	ble.onPeriphDiscovered(f, &gatt.Advertisement{}, -50)
+	ble.onPeriphDiscovered(f, &gatt.Advertisement{}, -50)
+	if len(d.connects) != 1 || d.connects[0] != "f1" {
+		t.Errorf("Expected a single connection to f1, got %v", d.connects)
+	}
+
+	ble.onPeriphConnected(f, nil)
+	ble.onPeriphDiscovered(f, &gatt.Advertisement{}, -50)
+	if len(d.connects) != 1 {
+		t.Errorf("Expected no reconnect to a connected fixture, got %v", d.connects)
+	}
+}
+
+func TestConnect(t *testing.T) {
+	ble, _ := newTestChannel()
+	f := newFakeFixture("f1")
+	connect(ble, f)
+
+	bp := ble.connectedPeriph["f1"]
+	if bp == nil {
+		t.Fatal("Expected f1 to be connected")
+	}
+	if _, ok := ble.connectingPeriph["f1"]; ok {
+		t.Error("Expected f1 to leave the connecting pool")
+	}
+	if bp.ledChar != f.charFor[pwmLedChar] || bp.fanChar == nil || bp.tempChar == nil {
+		t.Error("Expected characteristics to be captured")
+	}
+	if bp.fanConfigChar != nil || bp.statusChar != nil {
+		t.Error("Expected missing optional characteristics to be nil")
+	}
+
+	f.send(pwmTempChar, []byte{31, 0})
+	f.send(pwmFanChar, []byte{0xe8, 0x03})
+	deadline := time.Now().Add(time.Second)
+	for {
+		ble.lock.Lock()
+		temp, rpm := bp.temperature, bp.fanRpm
+		ble.lock.Unlock()
+		if temp == 31 && rpm == 1000 {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("Expected notifications to be applied, got %d C %d rpm", temp, rpm)
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
+
+func TestCheckExpected(t *testing.T) {
+	ble, _ := newTestChannel()
+	connect(ble, newFakeFixture("f1"))
+	start := time.Unix(1000, 0)
+	panics := func() (panicked bool) {
+		defer func() { panicked = recover() != nil }()
+		ble.checkExpected(start, start.Add(6*time.Minute))
+		return false
+	}
+	if panics() {
+		t.Error("Expected no fixture count checked by default")
+	}
+	defer func(n int) { expectFixtures = n }(expectFixtures)
+	expectFixtures = 1
+	if panics() {
+		t.Error("Expected one fixture to be enough for -ble.expect 1")
+	}
+	expectFixtures = 4
+	if !panics() {
+		t.Error("Expected a restart with one of four fixtures connected")
+	}
+}
+
+func TestConnectFailed(t *testing.T) {
+	ble, _ := newTestChannel()
+	f := newFakeFixture("f1")
+	ble.onPeriphDiscovered(f, &gatt.Advertisement{}, -50)
+	ble.onPeriphConnected(f, errors.New("timed out"))
+	if _, ok := ble.connectingPeriph["f1"]; ok {
+		t.Error("Expected a failed connection to leave the connecting pool")
+	}
+	if _, ok := ble.connectedPeriph["f1"]; ok {
+		t.Error("Expected a failed connection not to be connected")
+	}
+}
+
+func TestInterrogationFailed(t *testing.T) {
+	ble, d := newTestChannel()
+	f := newFakeFixture("f1")
+	f.discoverErr = errors.New("att error")
+	connect(ble, f)
+	if _, ok := ble.connectedPeriph["f1"]; ok {
+		t.Error("Expected a failed interrogation not to be connected")
+	}
+	if len(d.cancels) != 1 {
+		t.Errorf("Expected the connection to be cancelled, got %v", d.cancels)
+	}
+
+	// The fixture can be retried straight away
+	f.discoverErr = nil
+	connect(ble, f)
+	if len(d.connects) != 2 {
+		t.Errorf("Expected a second connection attempt, got %v", d.connects)
+	}
+	if _, ok := ble.connectedPeriph["f1"]; !ok {
+		t.Error("Expected the retry to connect")
+	}
+}
+
+func TestMissingLedChar(t *testing.T) {
+	ble, _ := newTestChannel()
+	f := newFakeFixture("f1", pwmFanChar, pwmTempChar)
+	connect(ble, f)
+	if _, ok := ble.connectedPeriph["f1"]; ok {
+		t.Error("Expected a fixture without an LED characteristic not to be connected")
+	}
+}
+
+func TestDisconnectDuringInterrogation(t *testing.T) {
+	ble, d := newTestChannel()
+	f := newFakeFixture("f1")
+	f.interrogating = func() { ble.onPeriphDisconnected(f, nil) }
+	connect(ble, f)
+	if _, ok := ble.connectedPeriph["f1"]; ok {
+		t.Error("Expected a fixture which disconnected during interrogation not to be connected")
+	}
+	if len(d.cancels) != 2 {
+		t.Errorf("Expected the stale connection to be cancelled, got %v", d.cancels)
+	}
+}
+
+func TestConnectTimeout(t *testing.T) {
+	ble, _ := newTestChannel()
+	c := clock.NewFake(time.Date(2016, 1, 1, 0, 0, 0, 0, time.UTC))
+	ble.clock = c
+	f := newFakeFixture("f1")
+	ble.onPeriphDiscovered(f, &gatt.Advertisement{}, -50)
+	c.Advance(connectTimeout - time.Second)
+	ble.lock.Lock()
+	if _, ok := ble.connectingPeriph["f1"]; !ok {
+		t.Error("Expected the connection to be pending until the timeout")
+	}
+	ble.lock.Unlock()
+	c.Advance(time.Second)
+	ble.onPeriphConnected(f, nil)
+	if _, ok := ble.connectedPeriph["f1"]; ok {
+		t.Error("Expected a connection completing after the timeout to be dropped")
+	}
+}
+
+func TestDisconnect(t *testing.T) {
+	ble, _ := newTestChannel()
+	f := newFakeFixture("f1")
+	connect(ble, f)
+	bp := ble.connectedPeriph["f1"]
+
+	ble.onPeriphDisconnected(f, nil)
+	if bp.Active() {
+		t.Error("Expected a disconnected fixture to be inactive")
+	}
+	select {
+	case <-bp.done:
+	default:
+		t.Error("Expected notification handling to stop")
+	}
+	if ble.DisconnectCount() != 1 {
+		t.Errorf("Expected 1 disconnect, got %d", ble.DisconnectCount())
+	}
+	// Late notifications are harmless
+	f.send(pwmTempChar, []byte{40, 0})
+}
+
+func TestShortNotification(t *testing.T) {
+	ble, _ := newTestChannel()
+	f := newFakeFixture("f1")
+	connect(ble, f)
+	bp := ble.connectedPeriph["f1"]
+
+	ble.lock.Lock()
+	defer ble.lock.Unlock()
+	bp.notify(notification{uuid: pwmFanChar, b: []byte{1}, at: time.Now()})
+	bp.notify(notification{uuid: pwmTempChar, at: time.Now()})
+	if bp.fanSeen || bp.tempSeen {
+		t.Error("Expected short notifications to be ignored")
+	}
+}
+
+func TestWriteErrors(t *testing.T) {
+	ble, _ := newTestChannel()
+	bad := newFakeFixture("bad")
+	bad.writeErr = errors.New("write failed")
+	good := newFakeFixture("good")
+	connect(ble, bad)
+	connect(ble, good)
+
+	if err := ble.writeLedState(); err != nil {
+		t.Fatal(err)
+	}
+	for _, f := range []*fakeFixture{bad, good} {
+		f.lock.Lock()
+		if len(f.writes) != 8 {
+			t.Errorf("%s: expected all 8 channels written, got %d", f.id, len(f.writes))
+		}
+		f.lock.Unlock()
+	}
+}
+
+func TestTelemetryConcurrent(t *testing.T) {
+	ble, _ := newTestChannel()
+	f := newFakeFixture("f1")
+	connect(ble, f)
+
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		for i := 0; i < 200; i++ {
+			f.send(pwmTempChar, []byte{byte(30 + i%5), 0})
+			f.send(pwmFanChar, []byte{byte(i), 0x03})
+		}
+	}()
+	for {
+		select {
+		case <-done:
+			return
+		default:
+		}
+		for _, p := range ble.Perhipherals() {
+			p.Name()
+			p.Active()
+			p.Temperature()
+			p.FanRPM()
+			p.FanFailed()
+		}
+	}
+}
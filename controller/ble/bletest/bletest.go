@@ -0,0 +1,642 @@
+// Package bletest drives fixtures in memory, so code which runs on a
+// ble.BLEChannel can be tested without a bluetooth adapter. Tests
+// connect and disconnect fixtures, set their telemetry, and read back
+// what was written to them.
+package bletest
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/theatrus/ledbrick/controller/ble"
+)
+
+// fleet is what every tank of a channel shares.
+type fleet struct {
+	lock        sync.Mutex
+	root        *Channel
+	fixtures    map[string]*Peripheral
+	tanks       map[string]*Channel
+	disconnects int
+	released    bool
+}
+
+// Channel is an in-memory ble.BLEChannel. Limits and scales apply to
+// the channels as the real one does, but writes reach no fixture:
+// tests read them back with Setting and Channels.
+type Channel struct {
+	*fleet
+	tank string
+
+	settings    map[int]float64
+	immediate   map[int]bool
+	limits      map[string]float64
+	scales      map[string]float64
+	suspended   map[string]bool
+	burnIns     map[string]float64
+	program     *ble.Program
+	fanOverride float64
+	degraded    bool
+	fail        error
+	// watts is each channel's draw at full power
+	watts float64
+}
+
+// NewChannel returns a channel with no fixtures connected.
+func NewChannel() *Channel {
+	f := &fleet{fixtures: make(map[string]*Peripheral), tanks: make(map[string]*Channel)}
+	f.root = newChannel(f, "")
+	return f.root
+}
+
+func newChannel(f *fleet, tank string) *Channel {
+	return &Channel{fleet: f, tank: tank,
+		settings:  make(map[int]float64),
+		immediate: make(map[int]bool),
+		limits:    make(map[string]float64),
+		scales:    make(map[string]float64),
+		suspended: make(map[string]bool),
+		burnIns:   make(map[string]float64),
+	}
+}
+
+// Connect connects a fixture, in the channel's tank.
+func (c *Channel) Connect(p *Peripheral) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	p.lock.Lock()
+	p.active = true
+	if c.tank != "" {
+		p.tank = c.tank
+	}
+	p.lock.Unlock()
+	c.fixtures[p.ID()] = p
+}
+
+// Disconnect drops a fixture, which stays known but inactive.
+func (c *Channel) Disconnect(id string) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	p, ok := c.fixtures[id]
+	if !ok {
+		return
+	}
+	p.lock.Lock()
+	if p.active {
+		c.disconnects++
+	}
+	p.active = false
+	p.lock.Unlock()
+}
+
+// SetDegraded sets whether the link counts as unstable.
+func (c *Channel) SetDegraded(degraded bool) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	c.degraded = degraded
+}
+
+// Fail makes every channel write return err, until Fail(nil).
+func (c *Channel) Fail(err error) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	c.fail = err
+}
+
+// SetChannelWatts sets what each channel draws at full power, for Power.
+func (c *Channel) SetChannelWatts(watts float64) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	c.watts = watts
+}
+
+// Setting returns the percent a channel was last set to, before limits
+// and scales, and whether it was set straight away.
+func (c *Channel) Setting(channel int) (percent float64, immediate bool) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	return c.settings[channel], c.immediate[channel]
+}
+
+// Program returns the program last set, if there was one.
+func (c *Channel) Program() (ble.Program, bool) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	if c.program == nil {
+		return ble.Program{}, false
+	}
+	return *c.program, true
+}
+
+// FanOverride returns the fan override last set.
+func (c *Channel) FanOverride() float64 {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	return c.fanOverride
+}
+
+// BurnIns returns the fixtures burning in, and their percents.
+func (c *Channel) BurnIns() map[string]float64 {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	burnIns := make(map[string]float64)
+	for id, p := range c.burnIns {
+		burnIns[id] = p
+	}
+	return burnIns
+}
+
+// Released reports whether Release was called.
+func (c *Channel) Released() bool {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	return c.released
+}
+
+// inTank reports whether a fixture is in the channel's tank, any
+// fixture being in the whole controller's.
+func (c *Channel) inTank(p *Peripheral) bool {
+	return c.tank == "" || p.Tank() == c.tank
+}
+
+func (c *Channel) Perhipherals() []ble.BLEPeripheral {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	ids := make([]string, 0, len(c.fixtures))
+	for id, p := range c.fixtures {
+		if p.Active() && c.inTank(p) {
+			ids = append(ids, id)
+		}
+	}
+	sort.Strings(ids)
+	periphs := make([]ble.BLEPeripheral, 0, len(ids))
+	for _, id := range ids {
+		periphs = append(periphs, c.fixtures[id])
+	}
+	return periphs
+}
+
+func checkPercent(percent float64) error {
+	if percent < 0 || percent > 100 {
+		return errors.New("Out of range percent (0-100)")
+	}
+	return nil
+}
+
+func (c *Channel) SetChannel(channel int, percent float64) error {
+	return c.set(channel, percent, false)
+}
+
+func (c *Channel) SetChannelImmediate(channel int, percent float64) error {
+	return c.set(channel, percent, true)
+}
+
+func (c *Channel) set(channel int, percent float64, immediate bool) error {
+	if err := checkPercent(percent); err != nil {
+		return err
+	}
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	if c.fail != nil {
+		return c.fail
+	}
+	c.settings[channel] = percent
+	c.immediate[channel] = immediate && len(c.suspended) == 0
+	for _, p := range c.fixtures {
+		if p.Active() && c.inTank(p) {
+			p.wrote()
+		}
+	}
+	return nil
+}
+
+func (c *Channel) SetLimit(name string, percent float64) error {
+	if err := checkPercent(percent); err != nil {
+		return err
+	}
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	c.limits[name] = percent
+	return nil
+}
+
+func (c *Channel) ClearLimit(name string) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	delete(c.limits, name)
+}
+
+func (c *Channel) Limits() map[string]float64 {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	return copyMap(c.limits)
+}
+
+func (c *Channel) SetScale(name string, factor float64) error {
+	if factor < 0 || factor > 2 {
+		return errors.New("Out of range scale (0-2)")
+	}
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	c.scales[name] = factor
+	return nil
+}
+
+func (c *Channel) ClearScale(name string) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	delete(c.scales, name)
+}
+
+func (c *Channel) Scales() map[string]float64 {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	return copyMap(c.scales)
+}
+
+func copyMap(m map[string]float64) map[string]float64 {
+	copied := make(map[string]float64)
+	for k, v := range m {
+		copied[k] = v
+	}
+	return copied
+}
+
+func (c *Channel) SuspendEffects(name string) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	c.suspended[name] = true
+}
+
+func (c *Channel) ResumeEffects(name string) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	delete(c.suspended, name)
+}
+
+func (c *Channel) EffectsSuspended() bool {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	return len(c.suspended) > 0
+}
+
+func (c *Channel) Degraded() bool {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	return c.degraded
+}
+
+func (c *Channel) Exposure() map[int]time.Duration {
+	return make(map[int]time.Duration)
+}
+
+// Channels returns each channel's setting with the scales and lowest
+// limit applied.
+func (c *Channel) Channels() map[int]float64 {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	scale, limit := 1.0, 100.0
+	for _, f := range c.scales {
+		scale *= f
+	}
+	for _, l := range c.limits {
+		limit = math.Min(limit, l)
+	}
+	channels := make(map[int]float64)
+	for channel, percent := range c.settings {
+		channels[channel] = math.Min(limit, math.Min(100, percent*scale))
+	}
+	return channels
+}
+
+func (c *Channel) DisconnectCount() int {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	return c.disconnects
+}
+
+// Availability gives connected fixtures as always up, and those
+// disconnected as never.
+func (c *Channel) Availability() map[string]ble.Availability {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	availability := make(map[string]ble.Availability)
+	for id, p := range c.fixtures {
+		if !c.inTank(p) {
+			continue
+		}
+		if p.Active() {
+			availability[id] = ble.Availability{Day: 1, Week: 1}
+		} else {
+			availability[id] = ble.Availability{}
+		}
+	}
+	return availability
+}
+
+// WriteStats counts the channel writes each fixture was connected for.
+func (c *Channel) WriteStats() map[string]ble.WriteStats {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	stats := make(map[string]ble.WriteStats)
+	for id, p := range c.fixtures {
+		if c.inTank(p) {
+			stats[id] = ble.WriteStats{Writes: p.Writes()}
+		}
+	}
+	return stats
+}
+
+// Power is what the channels would draw at percents, each drawing the
+// channel watts at full power.
+func (c *Channel) Power(percents map[int]float64) float64 {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	total := 0.0
+	for _, percent := range percents {
+		total += c.watts * percent / 100
+	}
+	return total
+}
+
+// Tanks names the tanks fixtures were connected in.
+func (c *Channel) Tanks() []string {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	seen := make(map[string]bool)
+	for name := range c.tanks {
+		seen[name] = true
+	}
+	for _, p := range c.fixtures {
+		if tank := p.Tank(); tank != "" {
+			seen[tank] = true
+		}
+	}
+	var names []string
+	for name := range seen {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// Tank returns a channel for one tank, the same one each time, or the
+// whole controller's for "". Fixtures connected through it are in the
+// tank.
+func (c *Channel) Tank(name string) ble.BLEChannel {
+	return c.TankChannel(name)
+}
+
+// TankChannel is Tank, returning the in-memory channel.
+func (c *Channel) TankChannel(name string) *Channel {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	if name == "" {
+		return c.root
+	}
+	tc, ok := c.tanks[name]
+	if !ok {
+		tc = newChannel(c.fleet, name)
+		c.tanks[name] = tc
+	}
+	return tc
+}
+
+func (c *Channel) SetProgram(prog ble.Program) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	c.program = &prog
+}
+
+// Release releases the fixtures on the whole controller's channel, and
+// does nothing on a tank's as the real one doesn't.
+func (c *Channel) Release() {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	if c.tank == "" {
+		c.released = true
+	}
+}
+
+func (c *Channel) BurnIn(id string, percent float64) error {
+	if err := checkPercent(percent); err != nil {
+		return err
+	}
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	if p, ok := c.fixtures[id]; ok && !c.inTank(p) {
+		return fmt.Errorf("fixture %s is not in tank %s", id, c.tank)
+	}
+	c.burnIns[id] = percent
+	return nil
+}
+
+func (c *Channel) EndBurnIn(id string) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	delete(c.burnIns, id)
+}
+
+// SetName names a fixture which has been connected, in the channel's
+// tank.
+func (c *Channel) SetName(id, name string) error {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	p, ok := c.fixtures[id]
+	if !ok {
+		return errors.New("no such fixture")
+	}
+	if !c.inTank(p) {
+		return fmt.Errorf("fixture %s is not in tank %s", id, c.tank)
+	}
+	p.SetName(name)
+	return nil
+}
+
+func (c *Channel) SetFanOverride(percent float64) error {
+	if err := checkPercent(percent); err != nil {
+		return err
+	}
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	c.fanOverride = percent
+	return nil
+}
+
+// Peripheral is an in-memory fixture, whose telemetry tests set.
+type Peripheral struct {
+	id string
+
+	lock          sync.Mutex
+	name          string
+	tank          string
+	active        bool
+	writes        int
+	temperature   int
+	fanRPM        int
+	fanFailed     bool
+	history       []ble.Sample
+	watts         float64
+	energy        [4]float64
+	currents      []int
+	channelFaults map[int]string
+}
+
+// NewPeripheral returns a fixture, to connect to a channel.
+func NewPeripheral(id string) *Peripheral {
+	return &Peripheral{id: id, channelFaults: make(map[int]string)}
+}
+
+func (p *Peripheral) ID() string { return p.id }
+
+func (p *Peripheral) Name() string {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+	return p.name
+}
+
+// SetName names the fixture, as if it had stored the name.
+func (p *Peripheral) SetName(name string) {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+	p.name = name
+}
+
+// Tank is the tank the fixture was connected in, "" for none.
+func (p *Peripheral) Tank() string {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+	return p.tank
+}
+
+func (p *Peripheral) Active() bool {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+	return p.active
+}
+
+// Writes counts the channel writes made while it was connected.
+func (p *Peripheral) Writes() int {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+	return p.writes
+}
+
+func (p *Peripheral) wrote() {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+	p.writes++
+}
+
+// SetTelemetry sets the temperature in C and fan speed the fixture
+// reports, recording them as a history sample at a time.
+func (p *Peripheral) SetTelemetry(at time.Time, temperature, fanRPM int) {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+	p.temperature, p.fanRPM = temperature, fanRPM
+	p.history = append(p.history, ble.Sample{At: at, Temperature: temperature, FanRPM: fanRPM})
+}
+
+// SetFanFailed sets whether the fixture reports its fan failed.
+func (p *Peripheral) SetFanFailed(failed bool) {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+	p.fanFailed = failed
+}
+
+// SetPower sets the watts the fixture draws, and the energy in watt
+// hours it has used today, the day before, this month and the month
+// before.
+func (p *Peripheral) SetPower(watts, today, prevDay, month, prevMonth float64) {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+	p.watts = watts
+	p.energy = [4]float64{today, prevDay, month, prevMonth}
+}
+
+// SetChannelCurrents sets each channel's current in mA.
+func (p *Peripheral) SetChannelCurrents(currents []int) {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+	p.currents = append([]int(nil), currents...)
+}
+
+// SetChannelFault faults a channel as "open" or "short", or clears it
+// for "".
+func (p *Peripheral) SetChannelFault(channel int, fault string) error {
+	switch fault {
+	case "open", "short":
+	case "":
+	default:
+		return fmt.Errorf("unknown channel fault %q, expected open or short", fault)
+	}
+	p.lock.Lock()
+	defer p.lock.Unlock()
+	if fault == "" {
+		delete(p.channelFaults, channel)
+	} else {
+		p.channelFaults[channel] = fault
+	}
+	return nil
+}
+
+func (p *Peripheral) Temperature() int {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+	return p.temperature
+}
+
+func (p *Peripheral) FanRPM() int {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+	return p.fanRPM
+}
+
+func (p *Peripheral) FanFailed() bool {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+	return p.fanFailed
+}
+
+func (p *Peripheral) History() []ble.Sample {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+	return append([]ble.Sample(nil), p.history...)
+}
+
+func (p *Peripheral) Watts() float64 {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+	return p.watts
+}
+
+func (p *Peripheral) EnergyToday() float64     { return p.energyAt(0) }
+func (p *Peripheral) EnergyPrevDay() float64   { return p.energyAt(1) }
+func (p *Peripheral) EnergyMonth() float64     { return p.energyAt(2) }
+func (p *Peripheral) EnergyPrevMonth() float64 { return p.energyAt(3) }
+
+func (p *Peripheral) energyAt(i int) float64 {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+	return p.energy[i]
+}
+
+func (p *Peripheral) ChannelCurrents() []int {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+	return append([]int(nil), p.currents...)
+}
+
+func (p *Peripheral) ChannelFaults() map[int]string {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+	faults := make(map[int]string)
+	for channel, fault := range p.channelFaults {
+		faults[channel] = fault
+	}
+	return faults
+}
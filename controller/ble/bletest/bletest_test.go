@@ -0,0 +1,98 @@
+package bletest
+
+import (
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestChannel(t *testing.T) {
+	c := NewChannel()
+	kitchen := NewPeripheral("kitchen")
+	c.Connect(kitchen)
+	if ps := c.Perhipherals(); len(ps) != 1 || ps[0].ID() != "kitchen" || !ps[0].Active() {
+		t.Fatalf("Expected kitchen connected, got %v", ps)
+	}
+
+	c.SetChannel(0, 80)
+	c.SetLimit("ups", 50)
+	c.SetScale("dim", 0.5)
+	if got := c.Channels()[0]; got != 40 {
+		t.Errorf("Expected 80%% scaled to 40%% under the limit, got %.1f", got)
+	}
+	c.SetScale("boost", 2)
+	if got := c.Channels()[0]; got != 50 {
+		t.Errorf("Expected the limit to hold at 50%%, got %.1f", got)
+	}
+	if percent, immediate := c.Setting(0); percent != 80 || immediate {
+		t.Errorf("Expected the setting kept as written, got %.1f %v", percent, immediate)
+	}
+	if err := c.SetChannel(0, 101); err == nil {
+		t.Error("Expected an out of range percent refused")
+	}
+
+	c.SuspendEffects("maintenance")
+	c.SetChannelImmediate(1, 20)
+	if _, immediate := c.Setting(1); immediate {
+		t.Error("Expected immediate writes slewed with effects suspended")
+	}
+
+	failed := errors.New("link down")
+	c.Fail(failed)
+	if err := c.SetChannel(0, 10); err != failed {
+		t.Errorf("Expected the write to fail, got %v", err)
+	}
+	c.Fail(nil)
+	if kitchen.Writes() != 2 {
+		t.Errorf("Expected the 2 good writes to reach the fixture, got %d", kitchen.Writes())
+	}
+
+	now := time.Date(2016, 1, 1, 12, 0, 0, 0, time.UTC)
+	kitchen.SetTelemetry(now, 41, 1200)
+	kitchen.SetChannelFault(3, "open")
+	if kitchen.Temperature() != 41 || kitchen.FanRPM() != 1200 || len(kitchen.History()) != 1 ||
+		kitchen.ChannelFaults()[3] != "open" {
+		t.Errorf("Expected the telemetry set, got %d C %d RPM %v", kitchen.Temperature(), kitchen.FanRPM(), kitchen.ChannelFaults())
+	}
+	if err := kitchen.SetChannelFault(3, "melted"); err == nil {
+		t.Error("Expected an unknown fault refused")
+	}
+
+	c.Disconnect("kitchen")
+	if len(c.Perhipherals()) != 0 || c.DisconnectCount() != 1 {
+		t.Errorf("Expected kitchen disconnected once, got %v", c.Perhipherals())
+	}
+	if a := c.Availability()["kitchen"]; a.Day != 0 {
+		t.Errorf("Expected kitchen unavailable, got %v", a)
+	}
+	c.Connect(kitchen)
+	if a := c.Availability()["kitchen"]; a.Day != 1 {
+		t.Errorf("Expected kitchen available again, got %v", a)
+	}
+}
+
+func TestTanks(t *testing.T) {
+	c := NewChannel()
+	c.Connect(NewPeripheral("sump"))
+	reef := c.TankChannel("reef")
+	reef.Connect(NewPeripheral("left"))
+	if c.Tank("reef") != reef || reef.Tank("") != c {
+		t.Error("Expected the same channels back for each tank")
+	}
+	if names := c.Tanks(); len(names) != 1 || names[0] != "reef" {
+		t.Errorf("Expected the reef tank, got %v", names)
+	}
+	if ps := reef.Perhipherals(); len(ps) != 1 || ps[0].ID() != "left" {
+		t.Errorf("Expected only left in the reef, got %v", ps)
+	}
+	if len(c.Perhipherals()) != 2 {
+		t.Errorf("Expected the controller to have both fixtures, got %v", c.Perhipherals())
+	}
+	reef.SetLimit("heat", 30)
+	if len(c.Limits()) != 0 {
+		t.Errorf("Expected the reef's limit kept to it, got %v", c.Limits())
+	}
+	if err := c.SetName("left", "Left bar"); err != nil || reef.Perhipherals()[0].Name() != "Left bar" {
+		t.Errorf("Expected left named, got %v", err)
+	}
+}
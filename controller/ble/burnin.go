@@ -0,0 +1,178 @@
+package ble
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"text/tabwriter"
+	"time"
+
+	"github.com/theatrus/ledbrick/controller/alert"
+	"github.com/theatrus/ledbrick/controller/clock"
+	"github.com/theatrus/ledbrick/controller/units"
+)
+
+// BurnInConfig sets how a burn-in drives a fixture.
+type BurnInConfig struct {
+	Duration time.Duration
+	// Percent every channel is driven at
+	Percent float64
+	// MaxTemperature aborts the burn-in when reached, in C
+	MaxTemperature int
+	// Sample is how often temperature and fan speed are logged
+	Sample time.Duration
+	// Silent aborts the burn-in when the fixture has sent no telemetry
+	// for this long, five minutes if zero
+	Silent time.Duration
+}
+
+// BurnInReport is the outcome of a burn-in.
+type BurnInReport struct {
+	ID      string    `json:"id"`
+	Start   time.Time `json:"start"`
+	End     time.Time `json:"end"`
+	Percent float64   `json:"percent"`
+	// Completed is set if the burn-in ran its whole duration, otherwise
+	// Aborted says why it stopped
+	Completed      bool     `json:"completed"`
+	Aborted        string   `json:"aborted,omitempty"`
+	MaxTemperature int      `json:"max_temperature"`
+	Samples        []Sample `json:"samples"`
+	// Missed counts samples taken while the fixture was disconnected
+	// or sent no telemetry
+	Missed int `json:"missed"`
+}
+
+// Write prints the report with the hottest reading of each hour.
+func (r BurnInReport) Write(w io.Writer) {
+	result := "completed"
+	if !r.Completed {
+		result = "ABORTED: " + r.Aborted
+	}
+	fmt.Fprintf(w, "Burn-in of %s at %.0f%% from %s for %s %s\n", r.ID, r.Percent,
+		r.Start.Format(time.RFC3339), r.End.Sub(r.Start), result)
+	fmt.Fprintf(w, "Max temperature %s, %d samples missed without telemetry\n", units.Format(float64(r.MaxTemperature), 0), r.Missed)
+	tw := tabwriter.NewWriter(w, 0, 8, 2, ' ', 0)
+	fmt.Fprintln(tw, "HOUR\tMAX TEMP\tMIN FAN")
+	for i := 0; i < len(r.Samples); {
+		hour := r.Samples[i].At.Sub(r.Start) / time.Hour
+		max, fan := r.Samples[i].Temperature, r.Samples[i].FanRPM
+		for ; i < len(r.Samples) && r.Samples[i].At.Sub(r.Start)/time.Hour == hour; i++ {
+			if r.Samples[i].Temperature > max {
+				max = r.Samples[i].Temperature
+			}
+			if r.Samples[i].FanRPM < fan {
+				fan = r.Samples[i].FanRPM
+			}
+		}
+		fmt.Fprintf(tw, "%d\t%s\t%d rpm\n", hour, units.Format(float64(max), 0), fan)
+	}
+	tw.Flush()
+}
+
+// BurnIn drives every channel of a fixture at a percent instead of its
+// tank's settings. Limits and derating still apply.
+func (ble *bleChannel) BurnIn(id string, percent float64) error {
+	if percent < 0 || percent > 100 {
+		return errors.New("Out of range percent (0-100)")
+	}
+	ble.lock.Lock()
+	defer ble.lock.Unlock()
+	ble.burnIns[id] = percent
+	return nil
+}
+
+// EndBurnIn puts a fixture back on its tank's settings.
+func (ble *bleChannel) EndBurnIn(id string) {
+	ble.lock.Lock()
+	defer ble.lock.Unlock()
+	delete(ble.burnIns, id)
+}
+
+// want returns what a fixture's channel should be driven to from its
+// tank's setting, unless it is burning in. The caller must hold the
+// channel lock.
+func (ble *bleChannel) want(p *blePeriph, setting float64) float64 {
+	if percent, ok := ble.burnIns[p.gp.ID()]; ok {
+		setting = percent
+	}
+	return p.limit(setting)
+}
+
+// RunBurnIn burns in one fixture: every channel held at a high output
+// for hours while its temperature and fan speed are logged, so a new
+// build's cooling is proven before it goes over livestock. It stops
+// early if the fixture reaches the maximum temperature, its fan fails
+// or its telemetry stops, raising a critical alert, as a burn-in whose
+// temperature can't be seen has proven nothing.
+func RunBurnIn(b BLEChannel, id string, cfg BurnInConfig, c clock.Clock) BurnInReport {
+	r := BurnInReport{ID: id, Start: c.Now(), Percent: cfg.Percent, Samples: []Sample{}}
+	silent := cfg.Silent
+	if silent <= 0 {
+		silent = 5 * time.Minute
+	}
+	if err := b.BurnIn(id, cfg.Percent); err != nil {
+		r.Aborted = err.Error()
+		r.End = r.Start
+		return r
+	}
+	defer b.EndBurnIn(id)
+	log.Printf("Burn-in of %s at %.0f%% for %s, aborting at %s", id, cfg.Percent, cfg.Duration, units.Format(float64(cfg.MaxTemperature), 0))
+
+	ticker := c.NewTicker(cfg.Sample)
+	defer ticker.Stop()
+	for now := range ticker.C() {
+		r.End = now
+		var p BLEPeripheral
+		for _, periph := range b.Perhipherals() {
+			if periph.ID() == id {
+				p = periph
+			}
+		}
+		// A sample needs telemetry sent since the last one
+		heard, ok := r.Start, false
+		if p != nil {
+			if h := p.History(); len(h) > 0 && !h[len(h)-1].At.Before(r.Start) {
+				heard, ok = h[len(h)-1].At, true
+			}
+		}
+		if !ok || now.Sub(heard) > cfg.Sample {
+			r.Missed++
+			if now.Sub(heard) >= silent {
+				r.Aborted = fmt.Sprintf("no telemetry for %s", now.Sub(heard))
+				alert.Raise(alert.Critical, id, "burnin.abort", "burn-in aborted after %s: %s",
+					now.Sub(r.Start), r.Aborted)
+				return r
+			}
+		} else {
+			s := Sample{At: now, Temperature: p.Temperature(), FanRPM: p.FanRPM()}
+			r.Samples = append(r.Samples, s)
+			if s.Temperature > r.MaxTemperature {
+				r.MaxTemperature = s.Temperature
+			}
+			switch {
+			case s.Temperature >= cfg.MaxTemperature:
+				r.Aborted = "reached " + units.Format(float64(s.Temperature), 0)
+			case p.FanFailed():
+				r.Aborted = "fan failed"
+			}
+			if r.Aborted != "" {
+				alert.Raise(alert.Critical, id, "burnin.abort", "burn-in aborted after %s: %s",
+					now.Sub(r.Start), r.Aborted)
+				return r
+			}
+		}
+		if now.Sub(r.Start) >= cfg.Duration {
+			break
+		}
+	}
+	if len(r.Samples) <= r.Missed {
+		r.Aborted = fmt.Sprintf("missed %d of %d samples", r.Missed, r.Missed+len(r.Samples))
+		alert.Raise(alert.Critical, id, "burnin.abort", "burn-in failed: %s", r.Aborted)
+		return r
+	}
+	r.Completed = true
+	log.Printf("Burn-in of %s completed, max temperature %s", id, units.Format(float64(r.MaxTemperature), 0))
+	return r
+}
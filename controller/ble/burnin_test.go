@@ -0,0 +1,111 @@
+package ble
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/theatrus/ledbrick/controller/clock"
+)
+
+// runBurnIn runs a burn-in on the channel's fake clock, advancing it
+// until done. The fixture reports its temperature each sample, unless
+// it is 0.
+func runBurnIn(ble *bleChannel, c *clock.Fake, id string, cfg BurnInConfig, temperature int) BurnInReport {
+	ble.lock.Lock()
+	bp := ble.connectedPeriph[id]
+	if bp != nil {
+		bp.temperature = temperature
+	}
+	ble.lock.Unlock()
+	notify := func() {
+		if temperature != 0 {
+			bp.history.add(Sample{At: c.Now(), Temperature: temperature})
+		}
+	}
+	notify()
+	done := make(chan BurnInReport)
+	go func() { done <- RunBurnIn(ble, id, cfg, c) }()
+	for {
+		select {
+		case r := <-done:
+			return r
+		case <-time.After(time.Millisecond):
+			c.Advance(cfg.Sample)
+			notify()
+		}
+	}
+}
+
+func TestBurnIn(t *testing.T) {
+	ble, _ := newTestChannel()
+	c := clock.NewFake(time.Date(2016, 1, 1, 0, 0, 0, 0, time.UTC))
+	ble.clock = c
+	hot := newFakeFixture("hot")
+	other := newFakeFixture("other")
+	connect(ble, hot)
+	connect(ble, other)
+	ble.SetChannelImmediate(0, 10)
+	ble.writeLedState()
+
+	// Burning in ramps up at the slew rate like any other change
+	ble.BurnIn("hot", 80)
+	c.Advance(time.Minute)
+	ble.writeLedState()
+	if v := lastWrite(hot, 0); v != 200 {
+		t.Errorf("Expected the burning in fixture at 80%%, got %d", v)
+	}
+	if v := lastWrite(other, 0); v != 25 {
+		t.Errorf("Expected other fixtures left on their settings, got %d", v)
+	}
+	ble.EndBurnIn("hot")
+	c.Advance(time.Minute)
+	ble.writeLedState()
+	if v := lastWrite(hot, 0); v != 25 {
+		t.Errorf("Expected the fixture back on its settings, got %d", v)
+	}
+
+	cfg := BurnInConfig{Duration: 3 * time.Minute, Percent: 80, MaxTemperature: 60, Sample: time.Minute}
+	r := runBurnIn(ble, c, "hot", cfg, 45)
+	if !r.Completed || len(r.Samples) != 3 || r.MaxTemperature != 45 {
+		t.Errorf("Expected 3 samples of a completed burn-in, got %+v", r)
+	}
+	var buf bytes.Buffer
+	r.Write(&buf)
+	if !strings.Contains(buf.String(), "completed") || !strings.Contains(buf.String(), "45 C") {
+		t.Errorf("Unexpected report:\n%s", buf.String())
+	}
+
+	r = runBurnIn(ble, c, "hot", cfg, 61)
+	if r.Completed || r.Aborted != "reached 61 C" || len(r.Samples) != 1 {
+		t.Errorf("Expected the burn-in aborted on over-temperature, got %+v", r)
+	}
+	ble.lock.Lock()
+	defer ble.lock.Unlock()
+	if len(ble.burnIns) != 0 {
+		t.Error("Expected the burn-in ended")
+	}
+}
+
+func TestBurnInWithoutTelemetry(t *testing.T) {
+	ble, _ := newTestChannel()
+	c := clock.NewFake(time.Date(2016, 1, 1, 0, 0, 0, 0, time.UTC))
+	ble.clock = c
+	connect(ble, newFakeFixture("quiet"))
+
+	cfg := BurnInConfig{Duration: time.Hour, Percent: 80, MaxTemperature: 60, Sample: time.Minute}
+	r := runBurnIn(ble, c, "quiet", cfg, 0)
+	if r.Completed || r.Aborted != "no telemetry for 5m0s" || r.Missed != 5 {
+		t.Errorf("Expected the burn-in aborted once telemetry stopped, got %+v", r)
+	}
+	cfg.Duration = 3 * time.Minute
+	if r = runBurnIn(ble, c, "quiet", cfg, 0); r.Completed || r.Aborted != "missed 3 of 3 samples" {
+		t.Errorf("Expected a burn-in without samples failed, got %+v", r)
+	}
+
+	cfg.Duration = time.Hour
+	if r = runBurnIn(ble, c, "gone", cfg, 0); r.Completed || r.Missed != 5 {
+		t.Errorf("Expected a missing fixture's burn-in failed, got %+v", r)
+	}
+}
@@ -0,0 +1,126 @@
+package ble
+
+import (
+	"flag"
+	"fmt"
+	"time"
+
+	"github.com/theatrus/ledbrick/controller/alert"
+)
+
+var faultPercent float64
+var faultCurrent int
+var faultHold time.Duration
+
+func init() {
+	flag.Float64Var(&faultPercent, "ble.fault.percent", 5,
+		"Written percent above which a channel is expected to draw current")
+	flag.IntVar(&faultCurrent, "ble.fault.current", 10,
+		"Channel current in mA below which a driven channel is considered open")
+	flag.DurationVar(&faultHold, "ble.fault.hold", time.Minute,
+		"How long a channel must look open before it is reported")
+}
+
+const (
+	channelOpen  = "open"
+	channelShort = "short"
+)
+
+// channelStatus holds the last report from the optional channel status
+// characteristic. The payload is a little endian uint16 current in mA
+// for each of the 8 channels, followed by an optional byte of driver
+// short circuit flags, one bit per channel.
+type channelStatus struct {
+	seen      bool
+	at        time.Time
+	currents  [8]int
+	shorted   [8]bool
+	openSince [8]time.Time
+	faults    map[int]string
+}
+
+func (s *channelStatus) report(b []byte, at time.Time) error {
+	if len(b) < 16 {
+		return fmt.Errorf("short channel status payload (%d bytes)", len(b))
+	}
+	for i := 0; i < 8; i++ {
+		s.currents[i] = int(b[2*i]) | (int(b[2*i+1]) << 8)
+		s.shorted[i] = len(b) > 16 && b[16]&(1<<uint(i)) != 0
+	}
+	s.seen = true
+	s.at = at
+	return nil
+}
+
+// checkChannels compares the written output of each channel against
+// the reported current, raising alerts as channels fault and recover.
+func (p *blePeriph) checkChannels(percents map[int]float64, now time.Time) {
+	s := &p.status
+	if !s.seen {
+		return
+	}
+	if s.faults == nil {
+		s.faults = make(map[int]string)
+	}
+
+	for channel := 0; channel < 8; channel++ {
+		fault := ""
+		driven := percents[channel] > faultPercent
+		// Status reports are by physical output
+		output := p.config.output(channel)
+		switch {
+		case s.shorted[output]:
+			fault = channelShort
+		case driven && s.currents[output] < faultCurrent:
+			if s.openSince[channel].IsZero() {
+				s.openSince[channel] = now
+			}
+			if now.Sub(s.openSince[channel]) >= faultHold {
+				fault = channelOpen
+			} else {
+				// Not long enough yet to call it, keep any prior state
+				fault = s.faults[channel]
+			}
+		default:
+			s.openSince[channel] = time.Time{}
+		}
+
+		if fault == s.faults[channel] {
+			continue
+		}
+		if fault == "" {
+			delete(s.faults, channel)
+			alert.Raise(alert.Info, p.gp.ID(), "channel.fault",
+				"channel %d recovered, drawing %d mA", channel, s.currents[output])
+			continue
+		}
+		s.faults[channel] = fault
+		alert.Raise(alert.Critical, p.gp.ID(), "channel.fault",
+			"channel %d is %s: %.1f%% written, %d mA", channel, fault, percents[channel], s.currents[output])
+	}
+}
+
+// ChannelCurrents returns the last reported current in mA per logical
+// channel, or nil if the fixture doesn't report currents.
+func (p *blePeriph) ChannelCurrents() []int {
+	defer p.guard()()
+	if !p.status.seen {
+		return nil
+	}
+	currents := make([]int, 8)
+	for channel := range currents {
+		currents[channel] = p.status.currents[p.config.output(channel)]
+	}
+	return currents
+}
+
+// ChannelFaults returns the channels currently faulted, as "open" or
+// "short".
+func (p *blePeriph) ChannelFaults() map[int]string {
+	defer p.guard()()
+	faults := make(map[int]string)
+	for channel, f := range p.status.faults {
+		faults[channel] = f
+	}
+	return faults
+}
@@ -0,0 +1,84 @@
+package ble
+
+import (
+	"testing"
+	"time"
+)
+
+func TestChannelFaults(t *testing.T) {
+	p := &blePeriph{gp: fakeID("a")}
+	now := time.Now()
+	payload := make([]byte, 17)
+	// Channel 0 draws 300 mA, channel 1 nothing, channel 2 shorted
+	payload[0], payload[1] = 0x2c, 0x01
+	payload[16] = 1 << 2
+	if err := p.status.report(payload, now); err != nil {
+		t.Fatal(err)
+	}
+	if p.status.currents[0] != 300 {
+		t.Errorf("Expected 300 mA, got %d", p.status.currents[0])
+	}
+
+	percents := map[int]float64{0: 50, 1: 50, 2: 50}
+	p.checkChannels(percents, now)
+	faults := p.ChannelFaults()
+	if faults[2] != channelShort || faults[1] != "" {
+		t.Errorf("Expected only a short on channel 2, got %v", faults)
+	}
+
+	p.checkChannels(percents, now.Add(faultHold))
+	faults = p.ChannelFaults()
+	if faults[1] != channelOpen || faults[0] != "" {
+		t.Errorf("Expected channel 1 to be open, got %v", faults)
+	}
+
+	// Dimmed channels don't count as open
+	percents[1] = 0
+	p.checkChannels(percents, now.Add(2*faultHold))
+	if p.ChannelFaults()[1] != "" {
+		t.Errorf("Dimmed channel still faulted: %v", p.ChannelFaults())
+	}
+
+	if err := p.status.report([]byte{1, 2}, now); err == nil {
+		t.Error("Expected error for a short payload")
+	}
+}
+
+func TestChannelFaultsConcurrent(t *testing.T) {
+	ble, _ := newTestChannel()
+	connect(ble, newFakeFixture("f1"))
+	ble.lock.Lock()
+	bp := ble.connectedPeriph["f1"]
+	ble.lock.Unlock()
+
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		now := time.Now()
+		ok, short := make([]byte, 17), make([]byte, 17)
+		short[16] = 1 << 2
+		percents := map[int]float64{2: 50}
+		for i := 0; i < 1000; i++ {
+			payload := ok
+			if i%2 == 0 {
+				payload = short
+			}
+			ble.lock.Lock()
+			bp.status.report(payload, now)
+			bp.checkChannels(percents, now)
+			ble.lock.Unlock()
+			now = now.Add(faultHold)
+		}
+	}()
+	for {
+		select {
+		case <-done:
+			return
+		default:
+		}
+		for _, p := range ble.Perhipherals() {
+			p.ChannelFaults()
+			p.ChannelCurrents()
+		}
+	}
+}
@@ -0,0 +1,92 @@
+package ble
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// ChannelNames are the emitters on each channel of a stock LEDBrick.
+var ChannelNames = [8]string{
+	"Green", "Cyan", "PC Amber", "Blue", "Red", "Deep Blue", "White", "UV",
+}
+
+// ChannelMap wires logical schedule channels, by number or name, to the
+// physical PWM outputs of a fixture assembled in a different order.
+// Names match ignoring case, spaces and underscores, so "deep_blue" is
+// "Deep Blue". Channels it doesn't mention drive the output of the same
+// number.
+type ChannelMap map[string]int
+
+// ChannelNumber resolves a logical channel given by number or name.
+func ChannelNumber(s string) (int, error) {
+	if n, err := strconv.Atoi(s); err == nil {
+		if n < 0 || n > 7 {
+			return 0, fmt.Errorf("channel %d is out of range (0-7)", n)
+		}
+		return n, nil
+	}
+	squash := func(s string) string {
+		return strings.ToLower(strings.NewReplacer(" ", "", "_", "").Replace(s))
+	}
+	for n, name := range ChannelNames {
+		if squash(name) == squash(s) {
+			return n, nil
+		}
+	}
+	return 0, fmt.Errorf("unknown channel %q", s)
+}
+
+// resolve returns the physical output for each logical channel, or nil
+// for the stock wiring. Every output may only be used once.
+func (m ChannelMap) resolve() ([]int, error) {
+	if len(m) == 0 {
+		return nil, nil
+	}
+	outputs := []int{0, 1, 2, 3, 4, 5, 6, 7}
+	mapped := make(map[int]bool)
+	for key, output := range m {
+		channel, err := ChannelNumber(key)
+		if err != nil {
+			return nil, err
+		}
+		if output < 0 || output > 7 {
+			return nil, fmt.Errorf("channel %s is mapped to output %d, out of range (0-7)", key, output)
+		}
+		if mapped[channel] {
+			return nil, fmt.Errorf("channel %s is mapped more than once", key)
+		}
+		mapped[channel] = true
+		outputs[channel] = output
+	}
+	used := make(map[int]int)
+	for channel, output := range outputs {
+		if other, ok := used[output]; ok {
+			return nil, fmt.Errorf("channels %d and %d both drive output %d", other, channel, output)
+		}
+		used[output] = channel
+	}
+	return outputs, nil
+}
+
+// output returns the physical output a logical channel drives.
+func (c FixtureConfig) output(channel int) int {
+	if c.outputs == nil {
+		return channel
+	}
+	return c.outputs[channel]
+}
+
+// physical reorders percents by logical channel into output order.
+func (c FixtureConfig) physical(percents Percents) Percents {
+	if c.outputs == nil {
+		return percents
+	}
+	reordered := make(Percents, len(c.outputs))
+	for channel, v := range percents {
+		if channel < len(c.outputs) {
+			reordered[c.output(channel)] = v
+		}
+	}
+	return reordered
+}
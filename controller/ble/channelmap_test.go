@@ -0,0 +1,60 @@
+package ble
+
+import (
+	"testing"
+)
+
+func TestChannelMapResolve(t *testing.T) {
+	outputs, err := ChannelMap{"deep_blue": 3, "Blue": 5}.resolve()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if outputs[5] != 3 || outputs[3] != 5 || outputs[0] != 0 {
+		t.Errorf("Expected deep blue and blue swapped, got %v", outputs)
+	}
+	if outputs, _ := (ChannelMap{}).resolve(); outputs != nil {
+		t.Errorf("Expected no map for stock wiring, got %v", outputs)
+	}
+
+	for _, m := range []ChannelMap{
+		{"royal_blue": 1},
+		{"8": 1},
+		{"0": 8},
+		{"0": 1},
+		{"0": 1, "green": 2},
+	} {
+		if _, err := m.resolve(); err == nil {
+			t.Errorf("Expected %v to fail", m)
+		}
+	}
+}
+
+func TestChannelMapWrites(t *testing.T) {
+	config := FixtureConfig{ChannelMap: ChannelMap{"0": 7, "7": 0}}
+	config.outputs, _ = config.ChannelMap.resolve()
+	ble := newBLEChannel(&fakeDevice{}, map[string]FixtureConfig{"f1": config})
+	ble.channelSetting = map[int]float64{0: 100}
+	f := newFakeFixture("f1")
+	connect(ble, f)
+	ble.immediate[0] = true
+
+	if err := ble.writeLedState(); err != nil {
+		t.Fatal(err)
+	}
+	f.lock.Lock()
+	defer f.lock.Unlock()
+	for _, b := range f.writes {
+		want := byte(0)
+		if b[0] == 7 {
+			want = 250
+		}
+		if b[1] != want {
+			t.Errorf("Expected output %d written %d, got %d", b[0], want, b[1])
+		}
+	}
+
+	physical := config.physical(Percents{10, 20})
+	if len(physical) != 8 || physical[7] != 10 || physical[1] != 20 {
+		t.Errorf("Expected failsafe percents reordered, got %v", physical)
+	}
+}
@@ -0,0 +1,102 @@
+package ble
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// CurvePoint maps a fixture temperature in C to a percent.
+type CurvePoint struct {
+	Temperature int     `json:"temperature"`
+	Percent     float64 `json:"percent"`
+}
+
+// TemperatureCurve is a list of points, sorted by temperature, which is
+// linearly interpolated between points and held flat beyond either end.
+// It is used both for fan duty and for output derating.
+type TemperatureCurve []CurvePoint
+
+func (c TemperatureCurve) Len() int           { return len(c) }
+func (c TemperatureCurve) Swap(i, j int)      { c[i], c[j] = c[j], c[i] }
+func (c TemperatureCurve) Less(i, j int) bool { return c[i].Temperature < c[j].Temperature }
+
+// At returns the percent for a temperature.
+func (c TemperatureCurve) At(temperature int) float64 {
+	if len(c) == 0 {
+		return 0
+	}
+	if temperature <= c[0].Temperature {
+		return c[0].Percent
+	}
+	for i := 1; i < len(c); i++ {
+		if temperature <= c[i].Temperature {
+			before, after := c[i-1], c[i]
+			lerpMult := float64(temperature-before.Temperature) /
+				float64(after.Temperature-before.Temperature)
+			return before.Percent + lerpMult*(after.Percent-before.Percent)
+		}
+	}
+	return c[len(c)-1].Percent
+}
+
+// String and Set let a TemperatureCurve be used as a flag, written as
+// temperature:percent pairs such as 30:0,42:50,55:100
+func (c *TemperatureCurve) String() string {
+	parts := make([]string, 0, len(*c))
+	for _, p := range *c {
+		parts = append(parts, fmt.Sprintf("%d:%g", p.Temperature, p.Percent))
+	}
+	return strings.Join(parts, ",")
+}
+
+func (c *TemperatureCurve) Set(value string) error {
+	curve := TemperatureCurve{}
+	for _, part := range strings.Split(value, ",") {
+		tp := strings.Split(part, ":")
+		if len(tp) != 2 {
+			return errors.New("curve points must be temperature:percent")
+		}
+		t, err := strconv.Atoi(tp[0])
+		if err != nil {
+			return err
+		}
+		v, err := strconv.ParseFloat(tp[1], 64)
+		if err != nil {
+			return err
+		}
+		curve = append(curve, CurvePoint{Temperature: t, Percent: v})
+	}
+	if err := curve.validate(); err != nil {
+		return err
+	}
+	sort.Sort(curve)
+	*c = curve
+	return nil
+}
+
+func (c *TemperatureCurve) UnmarshalJSON(data []byte) error {
+	var points []CurvePoint
+	if err := json.Unmarshal(data, &points); err != nil {
+		return err
+	}
+	curve := TemperatureCurve(points)
+	if err := curve.validate(); err != nil {
+		return err
+	}
+	sort.Sort(curve)
+	*c = curve
+	return nil
+}
+
+func (c TemperatureCurve) validate() error {
+	for _, p := range c {
+		if p.Percent < 0 || p.Percent > 100 {
+			return errors.New("Out of range curve percent (0-100)")
+		}
+	}
+	return nil
+}
@@ -0,0 +1,51 @@
+package ble
+
+import "testing"
+
+func TestTemperatureCurve(t *testing.T) {
+	var c TemperatureCurve
+	if err := c.Set("42:50,30:0,55:100"); err != nil {
+		t.Fatal(err)
+	}
+	if c[0].Temperature != 30 {
+		t.Errorf("Curve was not sorted: %v", c)
+	}
+
+	cases := map[int]float64{20: 0, 30: 0, 36: 25, 42: 50, 60: 100}
+	for temp, want := range cases {
+		if got := c.At(temp); got != want {
+			t.Errorf("Percent at %d C was not %f, got %f", temp, want, got)
+		}
+	}
+}
+
+func TestTemperatureCurveBadInput(t *testing.T) {
+	var c TemperatureCurve
+	for _, v := range []string{"30", "a:10", "30:x", "30:150"} {
+		if err := c.Set(v); err == nil {
+			t.Errorf("Expected error for %q", v)
+		}
+	}
+	if err := c.UnmarshalJSON([]byte(`[{"temperature": 50, "percent": -1}]`)); err == nil {
+		t.Error("Expected error for negative percent")
+	}
+}
+
+func TestDeratingLimit(t *testing.T) {
+	p := &blePeriph{gp: fakeID("a"), derating: 100}
+	p.config.Derating.Set("50:100,60:50,70:0")
+	p.temperature = 55
+	p.tempSeen = true
+	p.checkDerating()
+	if v := p.limit(100); v != 75 {
+		t.Errorf("Expected derating to 75, got %f", v)
+	}
+	if v := p.limit(30); v != 30 {
+		t.Errorf("Expected 30 to pass, got %f", v)
+	}
+	p.temperature = 40
+	p.checkDerating()
+	if v := p.limit(100); v != 100 {
+		t.Errorf("Expected no derating when cool, got %f", v)
+	}
+}
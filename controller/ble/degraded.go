@@ -0,0 +1,95 @@
+package ble
+
+import (
+	"flag"
+	"time"
+
+	"github.com/theatrus/ledbrick/controller/alert"
+)
+
+var degradedDisconnects int
+var degradedWindow time.Duration
+var degradedInterval time.Duration
+var degradedRecover time.Duration
+
+func init() {
+	flag.IntVar(&degradedDisconnects, "ble.degraded.disconnects", 5,
+		"Disconnects within the degraded window which put the controller in degraded mode, 0 to disable")
+	flag.DurationVar(&degradedWindow, "ble.degraded.window", 10*time.Minute,
+		"Window over which disconnects are counted for degraded mode")
+	flag.DurationVar(&degradedInterval, "ble.degraded.interval", 10*time.Second,
+		"How often to write fixture state while degraded")
+	flag.DurationVar(&degradedRecover, "ble.degraded.recover", 15*time.Minute,
+		"How long without a disconnect before leaving degraded mode")
+}
+
+// degraded tracks BLE link stability. While degraded, the controller
+// writes less often, holds outputs at the values set when instability
+// began, and treats immediate (effect) writes as ordinary ones.
+type degraded struct {
+	disconnects []time.Time
+	active      bool
+	since       time.Time
+	lastWrite   time.Time
+}
+
+// recordDisconnect notes a disconnect and enters degraded mode if there
+// have been too many recently. The caller must hold the channel lock.
+func (ble *bleChannel) recordDisconnect(now time.Time) {
+	d := &ble.degraded
+	cutoff := now.Add(-degradedWindow)
+	recent := d.disconnects[:0]
+	for _, t := range d.disconnects {
+		if t.After(cutoff) {
+			recent = append(recent, t)
+		}
+	}
+	d.disconnects = append(recent, now)
+
+	if d.active || degradedDisconnects <= 0 || len(d.disconnects) < degradedDisconnects {
+		return
+	}
+	d.active = true
+	d.since = now
+	ble.tank.hold(true)
+	for _, t := range ble.tanks {
+		t.hold(true)
+	}
+	alert.Raise(alert.Critical, "ble", "ble.degraded",
+		"%d disconnects in %s, entering degraded mode", len(d.disconnects), degradedWindow)
+}
+
+// checkDegraded leaves degraded mode once the link has been quiet, and
+// reports whether fixtures should be written on this tick. The caller
+// must hold the channel lock.
+func (ble *bleChannel) checkDegraded(now time.Time) bool {
+	d := &ble.degraded
+	if !d.active {
+		return true
+	}
+	last := d.since
+	if n := len(d.disconnects); n > 0 && d.disconnects[n-1].After(last) {
+		last = d.disconnects[n-1]
+	}
+	if now.Sub(last) >= degradedRecover {
+		d.active = false
+		ble.tank.hold(false)
+		for _, t := range ble.tanks {
+			t.hold(false)
+		}
+		alert.Raise(alert.Info, "ble", "ble.degraded",
+			"no disconnects for %s, leaving degraded mode after %s", degradedRecover, now.Sub(d.since))
+		return true
+	}
+	if now.Sub(d.lastWrite) < degradedInterval {
+		return false
+	}
+	d.lastWrite = now
+	return true
+}
+
+func (ble *bleChannel) Degraded() bool {
+	ble.lock.Lock()
+	defer ble.lock.Unlock()
+	return ble.degraded.active
+}
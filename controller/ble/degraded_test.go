@@ -0,0 +1,52 @@
+package ble
+
+import (
+	"testing"
+	"time"
+)
+
+func TestDegradedMode(t *testing.T) {
+	ble := &bleChannel{tank: &tank{channelSetting: map[int]float64{0: 40}}}
+	now := time.Now()
+	for i := 0; i < degradedDisconnects-1; i++ {
+		ble.recordDisconnect(now.Add(time.Duration(i) * time.Second))
+	}
+	if ble.degraded.active {
+		t.Fatal("Entered degraded mode too early")
+	}
+	ble.recordDisconnect(now.Add(time.Minute))
+	if !ble.degraded.active {
+		t.Fatal("Did not enter degraded mode")
+	}
+
+	// Schedule moves on, but the output is held
+	ble.channelSetting[0] = 80
+	if ble.setting(0) != 40 {
+		t.Errorf("Expected held value of 40, got %f", ble.setting(0))
+	}
+
+	if !ble.checkDegraded(now.Add(2 * time.Minute)) {
+		t.Error("Expected first degraded write to go out")
+	}
+	if ble.checkDegraded(now.Add(2*time.Minute + time.Second)) {
+		t.Error("Expected writes to be slowed")
+	}
+
+	if !ble.checkDegraded(now.Add(time.Minute+degradedRecover)) || ble.degraded.active {
+		t.Error("Did not recover from degraded mode")
+	}
+	if ble.setting(0) != 80 {
+		t.Errorf("Expected schedule value of 80 after recovery, got %f", ble.setting(0))
+	}
+}
+
+func TestDisconnectsAge(t *testing.T) {
+	ble := &bleChannel{}
+	now := time.Now()
+	for i := 0; i < degradedDisconnects; i++ {
+		ble.recordDisconnect(now.Add(time.Duration(i) * degradedWindow))
+	}
+	if ble.degraded.active {
+		t.Error("Entered degraded mode for spread out disconnects")
+	}
+}
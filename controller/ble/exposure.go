@@ -0,0 +1,89 @@
+package ble
+
+import (
+	"flag"
+	"time"
+
+	"github.com/theatrus/ledbrick/controller/alert"
+)
+
+var exposureThreshold float64
+var exposureBudget time.Duration
+
+func init() {
+	flag.Float64Var(&exposureThreshold, "ble.exposure.threshold", 80,
+		"Output percent above which time counts against the daily exposure budget")
+	flag.DurationVar(&exposureBudget, "ble.exposure.budget", 0,
+		"Most time per day any channel may spend above the exposure threshold, e.g. 5h, off if 0")
+}
+
+// exposure tracks how long each channel has been written above the
+// exposure threshold today, to any connected fixture. Once a channel's
+// budget is spent it is clamped to the threshold until local midnight,
+// as a guard against schedules or overrides which would bleach
+// livestock.
+type exposure struct {
+	day     string
+	last    time.Time
+	elapsed time.Duration
+	above   map[int]time.Duration
+	clamped map[int]bool
+}
+
+// advance moves the tracker to now, resetting budgets on a new day.
+func (e *exposure) advance(now time.Time) {
+	day := dayKey(now)
+	if e.day != day || e.above == nil {
+		e.day = day
+		e.above = make(map[int]time.Duration)
+		e.clamped = make(map[int]bool)
+		e.last = now
+	}
+	e.elapsed = now.Sub(e.last)
+	e.last = now
+}
+
+// limit returns the percent a channel may be driven to.
+func (e *exposure) limit(channel int, want float64) float64 {
+	if !spent(want, e.above[channel]) {
+		return want
+	}
+	if !e.clamped[channel] {
+		e.clamped[channel] = true
+		alert.Raise(alert.Warning, "ble", "exposure.budget",
+			"channel %d has spent %s above %.0f%% today, clamping to %.0f%%",
+			channel, exposureBudget, exposureThreshold, exposureThreshold)
+	}
+	return exposureThreshold
+}
+
+// spent reports whether a channel which has been above the threshold
+// for above today is clamped if written at want.
+func spent(want float64, above time.Duration) bool {
+	return exposureBudget > 0 && want > exposureThreshold && above >= exposureBudget
+}
+
+// record accounts the time since the last advance against the channels
+// written above the threshold, given the highest percent each was
+// written to.
+func (e *exposure) record(written map[int]float64) {
+	if exposureBudget <= 0 {
+		return
+	}
+	for channel, percent := range written {
+		if percent > exposureThreshold {
+			e.above[channel] += e.elapsed
+		}
+	}
+}
+
+// Exposure returns today's time above the exposure threshold by channel.
+func (ble *bleChannel) Exposure() map[int]time.Duration {
+	ble.lock.Lock()
+	defer ble.lock.Unlock()
+	above := make(map[int]time.Duration)
+	for channel, d := range ble.exposure.above {
+		above[channel] = d
+	}
+	return above
+}
@@ -0,0 +1,78 @@
+package ble
+
+import (
+	"testing"
+	"time"
+
+	"github.com/theatrus/ledbrick/controller/clock"
+)
+
+func TestExposureBudget(t *testing.T) {
+	defer func(d time.Duration) { exposureBudget = d }(exposureBudget)
+	exposureBudget = 5 * time.Hour
+	var e exposure
+	start := time.Date(2016, 1, 1, 8, 0, 0, 0, time.Local)
+	e.advance(start)
+
+	// Spend the whole budget above the threshold
+	e.advance(start.Add(exposureBudget - time.Minute))
+	e.record(map[int]float64{0: 100, 1: 50})
+	if v := e.limit(0, 100); v != 100 {
+		t.Errorf("Clamped before budget was spent, got %f", v)
+	}
+	e.advance(start.Add(exposureBudget))
+	e.record(map[int]float64{0: 100, 1: 50})
+	if v := e.limit(0, 100); v != exposureThreshold {
+		t.Errorf("Expected clamp to %f, got %f", exposureThreshold, v)
+	}
+	if v := e.limit(1, 100); v != 100 {
+		t.Errorf("Other channels should be unaffected, got %f", v)
+	}
+	if v := e.limit(0, 50); v != 50 {
+		t.Errorf("Values below threshold should pass, got %f", v)
+	}
+
+	// A new day resets the budget
+	e.advance(start.Add(24 * time.Hour))
+	if v := e.limit(0, 100); v != 100 {
+		t.Errorf("Budget did not reset, got %f", v)
+	}
+}
+
+func TestExposureOff(t *testing.T) {
+	var e exposure
+	start := time.Date(2016, 1, 1, 8, 0, 0, 0, time.Local)
+	e.advance(start)
+	e.advance(start.Add(23 * time.Hour))
+	e.record(map[int]float64{0: 100})
+	if v := e.limit(0, 100); v != 100 || e.above[0] != 0 {
+		t.Errorf("Expected no budget by default, got %f after %s", v, e.above[0])
+	}
+}
+
+func TestExposureCountsWrites(t *testing.T) {
+	defer func(d time.Duration) { exposureBudget = d }(exposureBudget)
+	exposureBudget = time.Hour
+	ble, _ := newTestChannel()
+	c := clock.NewFake(time.Date(2016, 1, 1, 8, 0, 0, 0, time.Local))
+	ble.clock = c
+	ble.SetChannelImmediate(0, 100)
+
+	// Nothing is counted while no fixture is connected
+	ble.writeLedState()
+	c.Advance(2 * time.Hour)
+	ble.writeLedState()
+	if d := ble.Exposure()[0]; d != 0 {
+		t.Errorf("Expected no exposure without fixtures, got %s", d)
+	}
+
+	connect(ble, newFakeFixture("f1"))
+	ble.SetChannelImmediate(0, 100)
+	ble.writeLedState()
+	c.Advance(30 * time.Minute)
+	ble.SetChannelImmediate(0, 100)
+	ble.writeLedState()
+	if d := ble.Exposure()[0]; d != 30*time.Minute {
+		t.Errorf("Expected 30m of exposure written to the fixture, got %s", d)
+	}
+}
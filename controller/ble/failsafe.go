@@ -0,0 +1,127 @@
+package ble
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"log"
+	"strconv"
+	"strings"
+	"time"
+)
+
+var failsafeOn clockTime
+var failsafeOff clockTime
+var failsafePercents Percents
+var failsafeResync time.Duration
+
+func init() {
+	flag.Var(&failsafeOn, "ble.failsafe.on",
+		"Local time fixtures switch on when running their failsafe program, e.g. 10:00")
+	flag.Var(&failsafeOff, "ble.failsafe.off",
+		"Local time fixtures switch off when running their failsafe program, e.g. 20:00")
+	flag.Var(&failsafePercents, "ble.failsafe.percents",
+		"Comma separated channel percents for the failsafe program while on")
+	flag.DurationVar(&failsafeResync, "ble.failsafe.resync", time.Hour,
+		"How often to resend the failsafe program, keeping fixture clocks in step")
+}
+
+// clockTime is a flag holding a time of day as minutes since midnight.
+type clockTime struct {
+	minutes int
+	set     bool
+}
+
+func (c *clockTime) String() string {
+	if !c.set {
+		return ""
+	}
+	return fmt.Sprintf("%02d:%02d", c.minutes/60, c.minutes%60)
+}
+
+func (c *clockTime) Set(value string) error {
+	hm := strings.Split(value, ":")
+	if len(hm) != 2 {
+		return errors.New("time must be HH:MM")
+	}
+	h, err := strconv.Atoi(hm[0])
+	if err != nil || h < 0 || h > 23 {
+		return errors.New("bad hour in time")
+	}
+	m, err := strconv.Atoi(hm[1])
+	if err != nil || m < 0 || m > 59 {
+		return errors.New("bad minute in time")
+	}
+	c.minutes = h*60 + m
+	c.set = true
+	return nil
+}
+
+// Percents is a comma separated flag of per-channel percents.
+type Percents []float64
+
+func (p *Percents) String() string {
+	parts := make([]string, 0, len(*p))
+	for _, v := range *p {
+		parts = append(parts, strconv.FormatFloat(v, 'g', -1, 64))
+	}
+	return strings.Join(parts, ",")
+}
+
+func (p *Percents) Set(value string) error {
+	percents := Percents{}
+	for _, part := range strings.Split(value, ",") {
+		v, err := strconv.ParseFloat(strings.TrimSpace(part), 64)
+		if err != nil {
+			return err
+		}
+		if v < 0 || v > 100 {
+			return errors.New("Out of range percent (0-100)")
+		}
+		percents = append(percents, v)
+	}
+	*p = percents
+	return nil
+}
+
+const failsafeVersion = 1
+
+// encodeFailsafe builds the failsafe program payload: a version byte,
+// the on and off times and the current time of day as big endian
+// minutes since midnight, then one output byte per channel.
+func encodeFailsafe(on, off int, percents Percents, now time.Time) []byte {
+	minutes := now.Hour()*60 + now.Minute()
+	b := []byte{failsafeVersion,
+		byte(on >> 8), byte(on),
+		byte(off >> 8), byte(off),
+		byte(minutes >> 8), byte(minutes),
+	}
+	for channel := 0; channel <= 7; channel++ {
+		v := 0.0
+		if channel < len(percents) {
+			v = percents[channel]
+		}
+		// Same 0xfa full scale as live writes
+		b = append(b, byte(v/100.0*250.0))
+	}
+	return b
+}
+
+// writeFailsafe uploads the failsafe program to a fixture which has a
+// schedule characteristic, if one is configured and it is due.
+func (p *blePeriph) writeFailsafe(now time.Time) {
+	if p.scheduleChar == nil || !failsafeOn.set || !failsafeOff.set {
+		return
+	}
+	if !p.failsafeSent.IsZero() && now.Sub(p.failsafeSent) < failsafeResync {
+		return
+	}
+	p.failsafeSent = now
+
+	b := encodeFailsafe(failsafeOn.minutes, failsafeOff.minutes, p.config.physical(failsafePercents), now)
+	if err := p.write(p.scheduleChar, b, false); err != nil {
+		log.Printf("%s: failsafe program write error: %s", p.gp.ID(), err)
+		return
+	}
+	log.Printf("%s: failsafe program %s-%s uploaded", p.gp.ID(), &failsafeOn, &failsafeOff)
+}
@@ -0,0 +1,36 @@
+package ble
+
+import (
+	"bytes"
+	"testing"
+	"time"
+)
+
+func TestEncodeFailsafe(t *testing.T) {
+	var on, off clockTime
+	if err := on.Set("10:00"); err != nil {
+		t.Fatal(err)
+	}
+	if err := off.Set("20:30"); err != nil {
+		t.Fatal(err)
+	}
+	now := time.Date(2016, 1, 1, 12, 15, 0, 0, time.Local)
+	b := encodeFailsafe(on.minutes, off.minutes, Percents{100, 50}, now)
+
+	want := []byte{1, 0x02, 0x58, 0x04, 0xce, 0x02, 0xdf, 250, 125, 0, 0, 0, 0, 0, 0}
+	if !bytes.Equal(b, want) {
+		t.Errorf("Expected % x, got % x", want, b)
+	}
+	if len(b) > 20 {
+		t.Errorf("Payload of %d bytes won't fit a single write", len(b))
+	}
+}
+
+func TestClockTime(t *testing.T) {
+	var c clockTime
+	for _, v := range []string{"24:00", "10", "10:60", "a:b"} {
+		if err := c.Set(v); err == nil {
+			t.Errorf("Expected error for %q", v)
+		}
+	}
+}
@@ -0,0 +1,164 @@
+package ble
+
+import (
+	"flag"
+	"log"
+	"math"
+	"time"
+
+	"github.com/theatrus/ledbrick/controller/alert"
+	"github.com/theatrus/ledbrick/controller/units"
+)
+
+var fanFailThreshold float64
+var fanFailSafePercent float64
+
+func init() {
+	flag.Float64Var(&fanFailThreshold, "ble.fanfail.threshold", 20,
+		"Output percent above which a stopped fan is treated as failed")
+	flag.Float64Var(&fanFailSafePercent, "ble.fanfail.safe", 10,
+		"Output percent to cap a fixture at while its fan has failed")
+	flag.Var(&defaultFixture.FanCurve, "ble.fan.curve",
+		"Temperature to fan duty curve pushed to fixtures, e.g. 30:0,42:50,55:100")
+	flag.Var(&defaultFixture.Derating, "ble.derating",
+		"Temperature to maximum output percent curve, e.g. 50:100,60:50,65:0")
+}
+
+// fanReport records a new fan speed notification.
+func (p *blePeriph) fanReport(rpm int, at time.Time) {
+	p.fanRpm = rpm
+	p.fanSeen = true
+	p.fanAt = at
+	if rpm != p.fanSteadyRpm || p.fanSteadySince.IsZero() {
+		p.fanSteadyRpm = rpm
+		p.fanSteadySince = at
+	}
+}
+
+// checkFan updates the fan failure state of a peripheral given the
+// highest commanded output percent. A fan reporting zero RPM while the
+// fixture is driven above the threshold is considered failed until it
+// reports a non-zero speed again.
+func (p *blePeriph) checkFan(output float64, now time.Time) {
+	if !p.fanSeen {
+		// No fan report yet, a zero RPM here means nothing
+		return
+	}
+	switch {
+	case p.fanFailed && p.fanRpm > 0:
+		p.fanFailed = false
+		alert.Raise(alert.Info, p.gp.ID(), "fan.failed",
+			"fan recovered (%d rpm), releasing output cap", p.fanRpm)
+	case !p.fanFailed && p.fanRpm == 0 && output > fanFailThreshold:
+		p.fanFailed = true
+		alert.Raise(alert.Critical, p.gp.ID(), "fan.failed",
+			"fan stopped at %.1f%% output, capping at %.1f%%", output, fanFailSafePercent)
+	}
+	p.checkFanRange(now)
+}
+
+// checkFanRange raises alerts as a running fan moves in and out of its
+// configured RPM range, or stops reporting any change in speed. Fans
+// usually degrade slowly before seizing, and a tachometer stuck on one
+// value is as suspect as a low reading.
+func (p *blePeriph) checkFanRange(now time.Time) {
+	c := p.config
+	running := p.fanRpm > 0
+
+	low := running && c.FanMinRPM > 0 && p.fanRpm < c.FanMinRPM
+	if low != p.fanLow {
+		p.fanLow = low
+		if low {
+			alert.Raise(alert.Warning, p.gp.ID(), "fan.low",
+				"fan at %d rpm, below %d rpm", p.fanRpm, c.FanMinRPM)
+		} else {
+			alert.Raise(alert.Info, p.gp.ID(), "fan.low",
+				"fan speed back to %d rpm", p.fanRpm)
+		}
+	}
+
+	high := c.FanMaxRPM > 0 && p.fanRpm > c.FanMaxRPM
+	if high != p.fanHigh {
+		p.fanHigh = high
+		if high {
+			alert.Raise(alert.Warning, p.gp.ID(), "fan.high",
+				"fan at %d rpm, above %d rpm", p.fanRpm, c.FanMaxRPM)
+		} else {
+			alert.Raise(alert.Info, p.gp.ID(), "fan.high",
+				"fan speed back to %d rpm", p.fanRpm)
+		}
+	}
+
+	stuck := running && c.FanStuck.Duration > 0 &&
+		now.Sub(p.fanSteadySince) >= c.FanStuck.Duration
+	if stuck != p.fanStuck {
+		p.fanStuck = stuck
+		if stuck {
+			alert.Raise(alert.Warning, p.gp.ID(), "fan.stuck",
+				"fan has reported exactly %d rpm for %s", p.fanRpm, c.FanStuck)
+		} else {
+			alert.Raise(alert.Info, p.gp.ID(), "fan.stuck",
+				"fan speed changing again (%d rpm)", p.fanRpm)
+		}
+	}
+}
+
+// writeFanCurve pushes the duty for the current temperature, or the
+// override if it is higher, to the fixture when it changes. Fixtures
+// without the fan config characteristic keep running their firmware
+// default, as do those without a curve, as an override ending would
+// leave them nothing to go back to.
+func (p *blePeriph) writeFanCurve(override float64) {
+	if len(p.config.FanCurve) == 0 || !p.tempSeen {
+		return
+	}
+	if p.fanConfigChar == nil {
+		if !p.fanCurveWarned {
+			log.Printf("%s: fixture does not support fan curves, using firmware default", p.gp.ID())
+			p.fanCurveWarned = true
+		}
+		return
+	}
+
+	duty := int(math.Max(p.config.FanCurve.At(p.temperature), override) / 100.0 * 255.0)
+	if duty == p.fanDuty {
+		return
+	}
+	err := p.write(p.fanConfigChar, []byte{byte(duty)}, true)
+	if err != nil {
+		log.Printf("%s: fan duty write error: %s", p.gp.ID(), err)
+		return
+	}
+	p.fanDuty = duty
+}
+
+// checkDerating updates the maximum output allowed by the fixture's
+// temperature derating curve.
+func (p *blePeriph) checkDerating() {
+	max := 100.0
+	if len(p.config.Derating) > 0 && p.tempSeen {
+		max = p.config.Derating.At(p.temperature)
+	}
+	if max < 100 && p.derating == 100 {
+		alert.Raise(alert.Warning, p.gp.ID(), "derating",
+			"derating to %.1f%% at %s", max, units.Format(float64(p.temperature), 0))
+	} else if max == 100 && p.derating < 100 {
+		alert.Raise(alert.Info, p.gp.ID(), "derating",
+			"derating released at %s", units.Format(float64(p.temperature), 0))
+	}
+	p.derating = max
+}
+
+// limit applies any per-fixture safety cap to a commanded percent.
+func (p *blePeriph) limit(percent float64) float64 {
+	if p.fanFailed && percent > fanFailSafePercent {
+		percent = fanFailSafePercent
+	}
+	if percent > p.derating {
+		percent = p.derating
+	}
+	if p.output != nil && p.output.heatSoak.capped && percent > p.config.HeatSoakBudget {
+		percent = p.config.HeatSoakBudget
+	}
+	return percent
+}
@@ -0,0 +1,43 @@
+package ble
+
+import "testing"
+
+func TestFanOverride(t *testing.T) {
+	ble, _ := newTestChannel()
+	f := newFakeFixture("f1", pwmLedChar, pwmFanChar, pwmTempChar, pwmFanConfigChar)
+	connect(ble, f)
+	p := ble.connectedPeriph["f1"]
+	p.config.FanCurve.Set("30:0,50:100")
+	p.temperature, p.tempSeen = 40, true
+
+	written := func() byte {
+		f.lock.Lock()
+		defer f.lock.Unlock()
+		return f.writes[len(f.writes)-1][0]
+	}
+	p.writeFanCurve(0)
+	if d := written(); d != 127 {
+		t.Errorf("Expected the curve's half duty, got %d", d)
+	}
+	p.writeFanCurve(80)
+	if d := written(); d != 204 {
+		t.Errorf("Expected the override's 80%% duty, got %d", d)
+	}
+	// An override never runs the fan slower than the curve
+	p.writeFanCurve(20)
+	if d := written(); d != 127 {
+		t.Errorf("Expected the curve's duty over a lower override, got %d", d)
+	}
+
+	if err := ble.SetFanOverride(120); err == nil {
+		t.Error("Expected an out of range override to be refused")
+	}
+	ble.SetFanOverride(60)
+	if err := ble.Tank("frag").SetFanOverride(90); err != nil {
+		t.Fatal(err)
+	}
+	if ble.tank.fanOverride != 60 || ble.tanks["frag"].fanOverride != 90 {
+		t.Errorf("Expected each tank's override kept apart, got %v and %v",
+			ble.tank.fanOverride, ble.tanks["frag"].fanOverride)
+	}
+}
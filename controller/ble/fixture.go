@@ -0,0 +1,186 @@
+package ble
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"time"
+)
+
+var fixtureFile string
+var modelFile string
+var defaultFixture FixtureConfig
+
+func init() {
+	flag.StringVar(&fixtureFile, "ble.fixtures", "",
+		"JSON file of per-fixture settings keyed by peripheral ID")
+	flag.StringVar(&modelFile, "ble.models", "",
+		"JSON file of per-model settings keyed by model name, referenced from fixtures")
+	flag.IntVar(&defaultFixture.FanMinRPM, "ble.fan.minrpm", 0,
+		"Alert when a running fan is below this RPM, 0 to disable")
+	flag.IntVar(&defaultFixture.FanMaxRPM, "ble.fan.maxrpm", 0,
+		"Alert when a fan is above this RPM, 0 to disable")
+	flag.DurationVar(&defaultFixture.FanStuck.Duration, "ble.fan.stuck", 30*time.Minute,
+		"Alert when a fan reports the exact same RPM for this long, 0 to disable")
+}
+
+// Duration is a time.Duration which reads and writes JSON as a string
+// such as "30m".
+type Duration struct {
+	time.Duration
+}
+
+func (d Duration) MarshalJSON() ([]byte, error) {
+	return json.Marshal(d.String())
+}
+
+func (d *Duration) UnmarshalJSON(data []byte) error {
+	var s string
+	if err := json.Unmarshal(data, &s); err != nil {
+		return err
+	}
+	v, err := time.ParseDuration(s)
+	if err != nil {
+		return err
+	}
+	d.Duration = v
+	return nil
+}
+
+// FixtureConfig holds the settings which may differ between fixtures.
+// Fixtures without an entry in the fixtures file use the flag defaults.
+type FixtureConfig struct {
+	// Expected fixtures raise alerts when they stay disconnected
+	Expected bool `json:"expected"`
+	// Tank names the system the fixture lights, empty for the default
+	Tank string `json:"tank"`
+
+	FanMinRPM int              `json:"fan_min_rpm"`
+	FanMaxRPM int              `json:"fan_max_rpm"`
+	FanStuck  Duration         `json:"fan_stuck"`
+	FanCurve  TemperatureCurve `json:"fan_curve"`
+
+	ChannelWatts Watts `json:"channel_watts"`
+
+	// Model names an entry in the models file supplying any settings
+	// not given here
+	Model    string           `json:"model"`
+	Derating TemperatureCurve `json:"derating"`
+
+	HeatSoakWindow Duration `json:"heat_soak_window"`
+	HeatSoakBudget float64  `json:"heat_soak_budget"`
+
+	ChannelMap ChannelMap `json:"channel_map"`
+
+	// PWMFrequency in Hz and PWMDither are set on fixtures which take
+	// them, left to the firmware if unset
+	PWMFrequency int   `json:"pwm_frequency"`
+	PWMDither    *bool `json:"pwm_dither"`
+	// outputs is ChannelMap resolved when loaded, nil for stock wiring
+	outputs []int
+}
+
+// ModelConfig holds the settings shared by every fixture of one build,
+// such as a passively cooled or a fan cooled design.
+type ModelConfig struct {
+	FanCurve     TemperatureCurve `json:"fan_curve"`
+	ChannelWatts Watts            `json:"channel_watts"`
+	// Derating maps temperature to the maximum allowed output percent
+	Derating TemperatureCurve `json:"derating"`
+
+	HeatSoakWindow Duration `json:"heat_soak_window"`
+	HeatSoakBudget float64  `json:"heat_soak_budget"`
+
+	PWMFrequency int   `json:"pwm_frequency"`
+	PWMDither    *bool `json:"pwm_dither"`
+}
+
+// apply fills in any settings the fixture doesn't set from its model.
+func (m ModelConfig) apply(c FixtureConfig) FixtureConfig {
+	if len(c.FanCurve) == 0 {
+		c.FanCurve = m.FanCurve
+	}
+	if len(c.ChannelWatts) == 0 {
+		c.ChannelWatts = m.ChannelWatts
+	}
+	if len(c.Derating) == 0 {
+		c.Derating = m.Derating
+	}
+	if c.HeatSoakWindow.Duration == 0 {
+		c.HeatSoakWindow = m.HeatSoakWindow
+		c.HeatSoakBudget = m.HeatSoakBudget
+	}
+	if c.PWMFrequency == 0 {
+		c.PWMFrequency = m.PWMFrequency
+	}
+	if c.PWMDither == nil {
+		c.PWMDither = m.PWMDither
+	}
+	return c
+}
+
+func loadModels() (map[string]ModelConfig, error) {
+	models := make(map[string]ModelConfig)
+	if modelFile == "" {
+		return models, nil
+	}
+	data, err := ioutil.ReadFile(modelFile)
+	if err != nil {
+		return nil, err
+	}
+	if err := json.Unmarshal(data, &models); err != nil {
+		return nil, err
+	}
+	return models, nil
+}
+
+func loadFixtures() (map[string]FixtureConfig, error) {
+	fixtures := make(map[string]FixtureConfig)
+	if fixtureFile == "" {
+		return fixtures, nil
+	}
+	data, err := ioutil.ReadFile(fixtureFile)
+	if err != nil {
+		return nil, err
+	}
+	if err := json.Unmarshal(data, &fixtures); err != nil {
+		return nil, err
+	}
+
+	models, err := loadModels()
+	if err != nil {
+		return nil, err
+	}
+	for id, c := range fixtures {
+		if c.outputs, err = c.ChannelMap.resolve(); err != nil {
+			return nil, fmt.Errorf("fixture %s channel map: %v", id, err)
+		}
+		if c.Model != "" {
+			m, ok := models[c.Model]
+			if !ok {
+				return nil, fmt.Errorf("fixture %s has unknown model %q", id, c.Model)
+			}
+			c = m.apply(c)
+		}
+		if err := c.validatePWM(); err != nil {
+			return nil, fmt.Errorf("fixture %s: %v", id, err)
+		}
+		fixtures[id] = c
+	}
+	return fixtures, nil
+}
+
+// LoadFixtures reads the fixtures and models files named by the flags,
+// so they can be checked without starting the channel.
+func LoadFixtures() (map[string]FixtureConfig, error) {
+	return loadFixtures()
+}
+
+// fixtureConfig returns the settings for a peripheral ID.
+func (ble *bleChannel) fixtureConfig(id string) FixtureConfig {
+	if c, ok := ble.fixtures[id]; ok {
+		return c
+	}
+	return defaultFixture
+}
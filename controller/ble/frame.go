@@ -0,0 +1,96 @@
+package ble
+
+import (
+	"flag"
+	"log"
+
+	"github.com/paypal/gatt"
+)
+
+var framedWrites bool
+var frameRetries int
+
+func init() {
+	flag.BoolVar(&framedWrites, "ble.framed", false,
+		"Use framed writes with sequence numbers and a CRC on fixtures which support them. No firmware in this tree does yet")
+	flag.IntVar(&frameRetries, "ble.framed.retries", 2,
+		"How many times a framed write the fixture rejects or doesn't acknowledge is retried")
+}
+
+const (
+	// Capability bits read from the capabilities characteristic
+	capFramed = 1 << 0
+	// Program uploads may use the compact encoding
+	capCompact = 1 << 1
+
+	// Bytes a frame adds to a payload, the sequence number and CRC
+	frameOverhead = 2
+)
+
+// A fixture taking framed writes sets capFramed in the first byte of its
+// capabilities characteristic, 0x152a. Every write to it is then a
+// frame: a sequence number, counting up from 1 and wrapping past 255,
+// the payload, and a CRC-8 of the two. The fixture drops a frame whose
+// CRC doesn't match. Sent with a response, it rejects one out of
+// sequence with an error response, and acknowledges a repeat of the
+// last one without applying it again.
+
+// crc8 is CRC-8 with polynomial 0x07, which the firmware checks frames
+// with.
+func crc8(b []byte) byte {
+	crc := byte(0)
+	for _, v := range b {
+		crc ^= v
+		for i := 0; i < 8; i++ {
+			if crc&0x80 != 0 {
+				crc = crc<<1 ^ 0x07
+			} else {
+				crc <<= 1
+			}
+		}
+	}
+	return crc
+}
+
+// frame wraps a payload with a sequence number before it and a CRC of
+// both after it.
+func frame(seq byte, payload []byte) []byte {
+	b := append([]byte{seq}, payload...)
+	return append(b, crc8(b))
+}
+
+// write sends a payload to one of the fixture's characteristics. Fixtures
+// with framed writes get it framed. Sent with a response, a frame the
+// fixture rejects as corrupt or out of sequence, or which is lost, is
+// retried with the same sequence number, which the fixture ignores if
+// it did apply it, so the outcome is the same whichever write got
+// through. Without one, as the channel writes are, nothing waits on the
+// fixture and a lost frame is made good by the next.
+func (p *blePeriph) write(c *gatt.Characteristic, b []byte, noRsp bool) error {
+	if !p.framed {
+		return p.gp.WriteCharacteristic(c, b, noRsp)
+	}
+	p.seqLock.Lock()
+	defer p.seqLock.Unlock()
+	p.seq++
+	f := frame(p.seq, b)
+	if noRsp {
+		return p.gp.WriteCharacteristic(c, f, true)
+	}
+	var err error
+	for attempt := 0; attempt <= frameRetries; attempt++ {
+		if err = p.gp.WriteCharacteristic(c, f, false); err == nil {
+			return nil
+		}
+		log.Printf("%s: framed write %d failed, attempt %d: %s", p.gp.ID(), p.seq, attempt+1, err)
+	}
+	return err
+}
+
+// payloadSize is how much of a 20 byte write is left for the payload.
+func (p *blePeriph) payloadSize() int {
+	if p.framed {
+		return 20 - frameOverhead
+	}
+	return 20
+}
@@ -0,0 +1,85 @@
+package ble
+
+import (
+	"bytes"
+	"testing"
+)
+
+func TestCRC8(t *testing.T) {
+	// The CRC-8 check value
+	if c := crc8([]byte("123456789")); c != 0xf4 {
+		t.Errorf("Expected 0xf4, got %#x", c)
+	}
+	f := frame(7, []byte{0, 250})
+	if len(f) != 4 || f[0] != 7 || crc8(f[:3]) != f[3] {
+		t.Errorf("Expected a sequence number and CRC around the payload, got % x", f)
+	}
+}
+
+func TestFramedWrites(t *testing.T) {
+	defer func(b bool) { framedWrites = b }(framedWrites)
+	framedWrites = true
+	ble, _ := newTestChannel()
+	f := newFakeFixture("f1", pwmLedChar, pwmFanChar, pwmTempChar, pwmCapsChar)
+	f.caps = []byte{capFramed}
+	connect(ble, f)
+	bp := ble.connectedPeriph["f1"]
+	if !bp.framed {
+		t.Fatal("Expected a fixture reporting framed writes to use them")
+	}
+
+	f.lock.Lock()
+	f.writes = nil
+	f.writeFails = 1
+	f.lock.Unlock()
+	if err := bp.write(bp.ledChar, []byte{0, 250}, false); err != nil {
+		t.Fatal(err)
+	}
+	f.lock.Lock()
+	writes := f.writes
+	f.lock.Unlock()
+	if len(writes) != 2 || !bytes.Equal(writes[0], writes[1]) {
+		t.Fatalf("Expected the rejected frame retried unchanged, got % x", writes)
+	}
+	if writes[0][0] != 1 || !bytes.Equal(writes[0][1:3], []byte{0, 250}) {
+		t.Errorf("Expected sequence 1 framing the payload, got % x", writes[0])
+	}
+
+	f.lock.Lock()
+	f.writeFails = frameRetries + 1
+	f.lock.Unlock()
+	if err := bp.write(bp.ledChar, []byte{1, 250}, false); err == nil {
+		t.Error("Expected a write failing every retry to fail")
+	}
+
+	// Channel writes don't wait on the fixture, so aren't retried
+	f.lock.Lock()
+	f.writes = nil
+	f.writeFails = 1
+	acked := f.acked
+	f.lock.Unlock()
+	bp.write(bp.ledChar, []byte{1, 250}, true)
+	f.lock.Lock()
+	if len(f.writes) != 1 || f.acked != acked {
+		t.Errorf("Expected one frame sent without a response, got % x", f.writes)
+	}
+	if last := f.writes[len(f.writes)-1]; last[0] != 3 {
+		t.Errorf("Expected the next write to move on to sequence 3, got % x", last)
+	}
+	f.lock.Unlock()
+
+	// Nor are fixtures framed without -ble.framed
+	framedWrites = false
+	off := newFakeFixture("f3", pwmLedChar, pwmFanChar, pwmTempChar, pwmCapsChar)
+	off.caps = []byte{capFramed}
+	connect(ble, off)
+	if ble.connectedPeriph["f3"].framed {
+		t.Error("Expected framing off by default")
+	}
+
+	plain := newFakeFixture("f2")
+	connect(ble, plain)
+	if ble.connectedPeriph["f2"].framed {
+		t.Error("Expected a fixture without capabilities to take plain writes")
+	}
+}
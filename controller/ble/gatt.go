@@ -0,0 +1,32 @@
+package ble
+
+import "github.com/paypal/gatt"
+
+// peripheral is the part of gatt.Peripheral the channel uses, so tests
+// can stand in for a fixture.
+type peripheral interface {
+	ID() string
+	Name() string
+	DiscoverServices(s []gatt.UUID) ([]*gatt.Service, error)
+	DiscoverCharacteristics(c []gatt.UUID, s *gatt.Service) ([]*gatt.Characteristic, error)
+	DiscoverDescriptors(d []gatt.UUID, c *gatt.Characteristic) ([]*gatt.Descriptor, error)
+	ReadCharacteristic(c *gatt.Characteristic) ([]byte, error)
+	ReadDescriptor(d *gatt.Descriptor) ([]byte, error)
+	WriteCharacteristic(c *gatt.Characteristic, b []byte, noRsp bool) error
+	SetNotifyValue(c *gatt.Characteristic, f func(*gatt.Characteristic, []byte, error)) error
+}
+
+// device is the part of gatt.Device the channel uses once running.
+type device interface {
+	Connect(p peripheral)
+	CancelConnection(p peripheral)
+}
+
+// gattDevice adapts a gatt.Device. Every peripheral handed back to it
+// came from its own callbacks, so is a gatt.Peripheral.
+type gattDevice struct {
+	d gatt.Device
+}
+
+func (g gattDevice) Connect(p peripheral)          { g.d.Connect(p.(gatt.Peripheral)) }
+func (g gattDevice) CancelConnection(p peripheral) { g.d.CancelConnection(p.(gatt.Peripheral)) }
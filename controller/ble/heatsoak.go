@@ -0,0 +1,97 @@
+package ble
+
+import (
+	"flag"
+	"log"
+	"time"
+
+	"github.com/theatrus/ledbrick/controller/alert"
+)
+
+func init() {
+	flag.DurationVar(&defaultFixture.HeatSoakWindow.Duration, "ble.heatsoak.window", 0,
+		"Window over which average output is tracked as a heat soak proxy, 0 to disable")
+	flag.Float64Var(&defaultFixture.HeatSoakBudget, "ble.heatsoak.budget", 70,
+		"Average output percent over the heat soak window above which a fixture is capped")
+}
+
+type loadSample struct {
+	at     time.Time
+	weight float64
+	dur    time.Duration
+}
+
+// heatSoak integrates a fixture's average channel output over a window.
+// For passively cooled builds, where the heatsink temperature lags well
+// behind the heat going in, this is a better early limit than the
+// temperature itself.
+type heatSoak struct {
+	samples []loadSample
+	sum     float64
+	total   time.Duration
+	capped  bool
+}
+
+// add records load percent held for elapsed, dropping samples older
+// than the window.
+func (h *heatSoak) add(load float64, elapsed time.Duration, window time.Duration, now time.Time) {
+	if elapsed <= 0 {
+		return
+	}
+	s := loadSample{at: now, weight: load * elapsed.Seconds(), dur: elapsed}
+	h.samples = append(h.samples, s)
+	h.sum += s.weight
+	h.total += s.dur
+
+	cutoff := now.Add(-window)
+	i := 0
+	for i < len(h.samples) && h.samples[i].at.Before(cutoff) {
+		h.sum -= h.samples[i].weight
+		h.total -= h.samples[i].dur
+		i++
+	}
+	h.samples = h.samples[i:]
+}
+
+// average returns the mean load percent over the window.
+func (h *heatSoak) average() float64 {
+	if h.total <= 0 {
+		return 0
+	}
+	return h.sum / h.total.Seconds()
+}
+
+// checkHeatSoak caps the fixture at the budget once its average output
+// exceeds it, releasing the cap when the average has come back down.
+func (p *blePeriph) checkHeatSoak() {
+	c := p.config
+	if c.HeatSoakWindow.Duration <= 0 {
+		return
+	}
+	h := &p.output.heatSoak
+	avg := h.average()
+	switch {
+	case !h.capped && avg > c.HeatSoakBudget:
+		h.capped = true
+		alert.Raise(alert.Warning, p.gp.ID(), "heatsoak",
+			"average output %.1f%% over %s exceeds budget, capping at %.1f%%",
+			avg, c.HeatSoakWindow, c.HeatSoakBudget)
+	case h.capped && avg < c.HeatSoakBudget*0.9:
+		h.capped = false
+		log.Printf("%s: heat soak cap released at %.1f%% average", p.gp.ID(), avg)
+	}
+}
+
+// recordHeatSoak adds the mean of the channels just written.
+func (p *blePeriph) recordHeatSoak(elapsed time.Duration, now time.Time) {
+	c := p.config
+	if c.HeatSoakWindow.Duration <= 0 {
+		return
+	}
+	o := p.output
+	total := 0.0
+	for channel := 0; channel <= 7; channel++ {
+		total += o.percents[channel]
+	}
+	o.heatSoak.add(total/8, elapsed, c.HeatSoakWindow.Duration, now)
+}
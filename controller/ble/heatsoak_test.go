@@ -0,0 +1,43 @@
+package ble
+
+import (
+	"testing"
+	"time"
+)
+
+func TestHeatSoakAverage(t *testing.T) {
+	var h heatSoak
+	now := time.Now()
+	window := time.Hour
+
+	h.add(100, 30*time.Minute, window, now)
+	h.add(0, 30*time.Minute, window, now.Add(30*time.Minute))
+	if avg := h.average(); avg != 50 {
+		t.Errorf("Expected 50%% average, got %f", avg)
+	}
+
+	// The first half hour falls out of the window
+	h.add(0, 30*time.Minute, window, now.Add(61*time.Minute))
+	if avg := h.average(); avg != 0 {
+		t.Errorf("Expected 0%% average, got %f", avg)
+	}
+}
+
+func TestHeatSoakCap(t *testing.T) {
+	p := &blePeriph{gp: fakeID("soak"), derating: 100, output: &output{percents: make(map[int]float64)}}
+	p.config.HeatSoakWindow.Duration = time.Hour
+	p.config.HeatSoakBudget = 60
+
+	now := time.Now()
+	p.output.heatSoak.add(80, time.Hour, time.Hour, now)
+	p.checkHeatSoak()
+	if v := p.limit(100); v != 60 {
+		t.Errorf("Expected cap at 60, got %f", v)
+	}
+
+	p.output.heatSoak.add(0, 2*time.Hour, time.Hour, now.Add(2*time.Hour))
+	p.checkHeatSoak()
+	if v := p.limit(100); v != 100 {
+		t.Errorf("Expected cap released, got %f", v)
+	}
+}
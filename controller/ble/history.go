@@ -0,0 +1,170 @@
+package ble
+
+import (
+	"encoding/json"
+	"flag"
+	"io/ioutil"
+	"log"
+	"sync"
+	"time"
+
+	"github.com/theatrus/ledbrick/controller/atomicfile"
+)
+
+var historyWindow time.Duration
+var historyFile string
+
+func init() {
+	// A little over a day, so there is always a sample from this time
+	// yesterday to compare against
+	flag.DurationVar(&historyWindow, "ble.history.window", 25*time.Hour,
+		"How much temperature and fan history to keep per peripheral")
+	flag.StringVar(&historyFile, "ble.history.file", "",
+		"File to persist temperature and fan history to, in-memory only if empty")
+}
+
+// Sample is a single telemetry reading from a peripheral.
+type Sample struct {
+	At          time.Time `json:"at"`
+	Temperature int       `json:"temperature"`
+	FanRPM      int       `json:"fan_rpm"`
+	// Channels is the percent last written to each channel
+	Channels map[int]float64 `json:"channels,omitempty"`
+}
+
+// HourSummary aggregates the samples falling within one clock hour.
+type HourSummary struct {
+	Hour           time.Time `json:"hour"`
+	Samples        int       `json:"samples"`
+	MinTemperature int       `json:"min_temperature"`
+	MaxTemperature int       `json:"max_temperature"`
+	AvgTemperature float64   `json:"avg_temperature"`
+	MinFanRPM      int       `json:"min_fan_rpm"`
+	MaxFanRPM      int       `json:"max_fan_rpm"`
+	AvgFanRPM      float64   `json:"avg_fan_rpm"`
+}
+
+// history is a rolling window of samples for one peripheral. It
+// outlives the peripheral connection so reconnects don't lose data.
+type history struct {
+	samples []Sample
+	lock    sync.Mutex
+}
+
+func (h *history) add(s Sample) {
+	h.lock.Lock()
+	defer h.lock.Unlock()
+
+	h.samples = append(h.samples, s)
+	// Drop everything that has fallen out of the window
+	cutoff := s.At.Add(-historyWindow)
+	i := 0
+	for i < len(h.samples) && h.samples[i].At.Before(cutoff) {
+		i++
+	}
+	if i > 0 {
+		h.samples = append([]Sample(nil), h.samples[i:]...)
+	}
+}
+
+func (h *history) snapshot() []Sample {
+	h.lock.Lock()
+	defer h.lock.Unlock()
+	return append([]Sample(nil), h.samples...)
+}
+
+// Summarize groups samples, assumed to be in time order, into hourly
+// min/max/average summaries.
+func Summarize(samples []Sample) []HourSummary {
+	summaries := make([]HourSummary, 0)
+	var cur *HourSummary
+	for _, s := range samples {
+		hour := s.At.Truncate(time.Hour)
+		if cur == nil || !cur.Hour.Equal(hour) {
+			summaries = append(summaries, HourSummary{
+				Hour:           hour,
+				MinTemperature: s.Temperature,
+				MaxTemperature: s.Temperature,
+				MinFanRPM:      s.FanRPM,
+				MaxFanRPM:      s.FanRPM,
+			})
+			cur = &summaries[len(summaries)-1]
+		}
+		if s.Temperature < cur.MinTemperature {
+			cur.MinTemperature = s.Temperature
+		}
+		if s.Temperature > cur.MaxTemperature {
+			cur.MaxTemperature = s.Temperature
+		}
+		if s.FanRPM < cur.MinFanRPM {
+			cur.MinFanRPM = s.FanRPM
+		}
+		if s.FanRPM > cur.MaxFanRPM {
+			cur.MaxFanRPM = s.FanRPM
+		}
+		// Running averages
+		cur.Samples++
+		n := float64(cur.Samples)
+		cur.AvgTemperature += (float64(s.Temperature) - cur.AvgTemperature) / n
+		cur.AvgFanRPM += (float64(s.FanRPM) - cur.AvgFanRPM) / n
+	}
+	return summaries
+}
+
+// historyFor returns the history for a peripheral ID, creating it if
+// needed. The caller must hold the channel lock.
+func (ble *bleChannel) historyFor(id string) *history {
+	h, ok := ble.history[id]
+	if !ok {
+		h = &history{}
+		ble.history[id] = h
+	}
+	return h
+}
+
+func (ble *bleChannel) loadHistory() {
+	if historyFile == "" {
+		return
+	}
+	data, err := ioutil.ReadFile(historyFile)
+	if err != nil {
+		log.Printf("Not loading history: %v", err)
+		return
+	}
+	saved := make(map[string][]Sample)
+	if err := json.Unmarshal(data, &saved); err != nil {
+		log.Printf("Bad history file %s: %v", historyFile, err)
+		return
+	}
+
+	ble.lock.Lock()
+	defer ble.lock.Unlock()
+	for id, samples := range saved {
+		h := ble.historyFor(id)
+		for _, s := range samples {
+			h.add(s)
+		}
+	}
+}
+
+func (ble *bleChannel) saveHistory() {
+	if historyFile == "" {
+		return
+	}
+
+	ble.lock.Lock()
+	saved := make(map[string][]Sample)
+	for id, h := range ble.history {
+		saved[id] = h.snapshot()
+	}
+	ble.lock.Unlock()
+
+	data, err := json.Marshal(saved)
+	if err != nil {
+		log.Printf("Failed to encode history: %v", err)
+		return
+	}
+	if err := atomicfile.WriteFile(historyFile, data, 0644); err != nil {
+		log.Printf("Failed to save history: %v", err)
+	}
+}
@@ -0,0 +1,55 @@
+package ble
+
+import (
+	"testing"
+	"time"
+)
+
+func TestSummarize(t *testing.T) {
+	base := time.Date(2016, 1, 1, 10, 0, 0, 0, time.UTC)
+	samples := []Sample{
+		{At: base, Temperature: 30, FanRPM: 1000},
+		{At: base.Add(20 * time.Minute), Temperature: 40, FanRPM: 2000},
+		{At: base.Add(70 * time.Minute), Temperature: 35, FanRPM: 1500},
+	}
+	s := Summarize(samples)
+	if len(s) != 2 {
+		t.Fatalf("Expected 2 hours, got %d", len(s))
+	}
+	if s[0].Samples != 2 || s[0].MinTemperature != 30 || s[0].MaxTemperature != 40 {
+		t.Errorf("Bad first hour: %+v", s[0])
+	}
+	if s[0].AvgTemperature != 35 || s[0].AvgFanRPM != 1500 {
+		t.Errorf("Bad averages: %+v", s[0])
+	}
+	if !s[1].Hour.Equal(base.Add(time.Hour)) || s[1].Samples != 1 {
+		t.Errorf("Bad second hour: %+v", s[1])
+	}
+}
+
+func TestHistoryWindow(t *testing.T) {
+	h := &history{}
+	base := time.Date(2016, 1, 1, 10, 0, 0, 0, time.UTC)
+	h.add(Sample{At: base})
+	h.add(Sample{At: base.Add(historyWindow + time.Minute)})
+	if len(h.snapshot()) != 1 {
+		t.Errorf("Expected old sample to be dropped, got %v", h.snapshot())
+	}
+}
+
+func TestSampleChannels(t *testing.T) {
+	ble, _ := newTestChannel()
+	f := newFakeFixture("f1")
+	connect(ble, f)
+	bp := ble.connectedPeriph["f1"]
+
+	ble.lock.Lock()
+	defer ble.lock.Unlock()
+	bp.output.percents[2] = 40
+	bp.notify(notification{uuid: pwmTempChar, b: []byte{35}, at: time.Now()})
+	bp.output.percents[2] = 50
+	samples := bp.History()
+	if len(samples) != 1 || samples[0].Channels[2] != 40 {
+		t.Errorf("Expected the sample to hold the channel at 40%%, got %+v", samples)
+	}
+}
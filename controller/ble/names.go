@@ -0,0 +1,122 @@
+package ble
+
+import (
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"os"
+	"strings"
+
+	"github.com/theatrus/ledbrick/controller/atomicfile"
+)
+
+var namesFile string
+
+func init() {
+	flag.StringVar(&namesFile, "ble.names", "",
+		"JSON file of fixture names keyed by peripheral ID, kept by the controller, names only kept on fixtures if empty")
+}
+
+// A name is written to a fixture in one write
+const maxName = 20
+
+func loadNames() (map[string]string, error) {
+	names := make(map[string]string)
+	if namesFile == "" {
+		return names, nil
+	}
+	data, err := ioutil.ReadFile(namesFile)
+	if os.IsNotExist(err) {
+		return names, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	if err := json.Unmarshal(data, &names); err != nil {
+		return nil, fmt.Errorf("%s: %v", namesFile, err)
+	}
+	return names, nil
+}
+
+// saveNames writes the names out. The caller must hold the channel lock.
+func (ble *bleChannel) saveNames() {
+	if namesFile == "" {
+		return
+	}
+	data, err := json.MarshalIndent(ble.names, "", "  ")
+	if err != nil {
+		log.Printf("Failed to encode fixture names: %v", err)
+		return
+	}
+	if err := atomicfile.WriteFile(namesFile, data, 0644); err != nil {
+		log.Printf("Failed to save fixture names: %v", err)
+	}
+}
+
+// syncName reconciles a newly connected fixture's name with the
+// controller's. The controller's wins, as it's where names are changed,
+// but a fixture with a name the controller doesn't know, such as after
+// a reinstall, gives it its name. The caller must hold the channel lock.
+func (ble *bleChannel) syncName(p *blePeriph) {
+	id := p.gp.ID()
+	name, ok := ble.names[id]
+	switch {
+	case !ok && p.name != "":
+		log.Printf("%s: named %q by the fixture", id, p.name)
+		ble.names[id] = p.name
+		ble.saveNames()
+	case ok && name != p.name:
+		if err := p.writeName(name); err != nil {
+			log.Printf("%s: failed to name the fixture %q: %v", id, name, err)
+		}
+	}
+}
+
+// writeName keeps a name on the fixture, for those which can.
+func (p *blePeriph) writeName(name string) error {
+	if p.nameChar == nil {
+		p.name = name
+		return nil
+	}
+	if err := p.write(p.nameChar, []byte(name), false); err != nil {
+		return err
+	}
+	p.name = name
+	return nil
+}
+
+// SetName names a fixture, on the fixture itself if it's connected and
+// can keep it, so the name travels with the hardware.
+func (ble *bleChannel) SetName(id, name string) error {
+	name = strings.TrimSpace(name)
+	if len(name) > maxName {
+		return fmt.Errorf("name is %d bytes, at most %d fit on a fixture", len(name), maxName)
+	}
+	ble.lock.Lock()
+	defer ble.lock.Unlock()
+	p := ble.connectedPeriph[id]
+	if p == nil && !ble.knownPeriph[id] {
+		return errors.New("no such fixture")
+	}
+	if p != nil {
+		if err := p.writeName(name); err != nil {
+			return err
+		}
+	}
+	if name == "" {
+		delete(ble.names, id)
+	} else {
+		ble.names[id] = name
+	}
+	ble.saveNames()
+	return nil
+}
+
+// Name is the fixture's name, empty if it hasn't been given one.
+func (p *blePeriph) Name() string {
+	defer p.guard()()
+	return p.name
+}
@@ -0,0 +1,62 @@
+package ble
+
+import (
+	"io/ioutil"
+	"path/filepath"
+	"testing"
+)
+
+func TestNames(t *testing.T) {
+	defer func(f string) { namesFile = f }(namesFile)
+	namesFile = filepath.Join(t.TempDir(), "names.json")
+
+	// A fixture keeping a name the controller doesn't know gives it
+	ble, _ := newTestChannel()
+	f := newFakeFixture("f1", pwmLedChar, pwmNameChar)
+	f.label = []byte("Left\x00\x00")
+	connect(ble, f)
+	if ble.names["f1"] != "Left" || ble.Perhipherals()[0].Name() != "Left" {
+		t.Errorf("Expected the fixture's name taken, got %v", ble.names)
+	}
+	if data, _ := ioutil.ReadFile(namesFile); string(data) != "{\n  \"f1\": \"Left\"\n}" {
+		t.Errorf("Expected the name saved, got %s", data)
+	}
+
+	// Renaming writes the fixture
+	if err := ble.SetName("f1", "Sump"); err != nil {
+		t.Fatal(err)
+	}
+	if w := f.writes[len(f.writes)-1]; string(w) != "Sump" {
+		t.Errorf("Expected the name written to the fixture, got %q", w)
+	}
+	if err := ble.SetName("f1", "A name far too long to keep"); err == nil {
+		t.Error("Expected a long name to fail")
+	}
+	if err := ble.SetName("nobody", "x"); err == nil {
+		t.Error("Expected naming an unknown fixture to fail")
+	}
+
+	// The controller's name wins over a fixture's stale one
+	ble, _ = newTestChannel()
+	names, err := loadNames()
+	if err != nil {
+		t.Fatal(err)
+	}
+	ble.names = names
+	f = newFakeFixture("f1", pwmLedChar, pwmNameChar)
+	f.label = []byte("Left")
+	connect(ble, f)
+	if len(f.writes) == 0 || string(f.writes[0]) != "Sump" {
+		t.Errorf("Expected the fixture renamed Sump, got %q", f.writes)
+	}
+
+	// A fixture without the characteristic still has the name
+	g := newFakeFixture("f2")
+	connect(ble, g)
+	if err := ble.SetName("f2", "Fuge"); err != nil {
+		t.Fatal(err)
+	}
+	if p := ble.connectedPeriph["f2"]; p.Name() != "Fuge" || len(g.writes) != 0 {
+		t.Errorf("Expected the name kept only by the controller, got %q and writes %q", p.Name(), g.writes)
+	}
+}
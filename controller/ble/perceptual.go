@@ -0,0 +1,25 @@
+package ble
+
+import "flag"
+
+var perceptualDimming bool
+
+func init() {
+	flag.BoolVar(&perceptualDimming, "ble.perceptual", false,
+		"Read channel settings as perceived brightness on the CIE lightness curve rather than PWM duty, so 50% looks half as bright")
+}
+
+// linear returns the PWM duty percent for a channel setting. Settings
+// are duty as they are unless -ble.perceptual is on, in which case they
+// are CIE L*, so a table ramps evenly to the eye and stays usable at
+// the bottom end where the eye is most sensitive.
+func linear(percent float64) float64 {
+	if !perceptualDimming {
+		return percent
+	}
+	if percent <= 8 {
+		return percent / 903.3 * 100
+	}
+	l := (percent + 16) / 116
+	return l * l * l * 100
+}
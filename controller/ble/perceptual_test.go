@@ -0,0 +1,49 @@
+package ble
+
+import (
+	"math"
+	"testing"
+	"time"
+)
+
+func TestLinear(t *testing.T) {
+	if linear(50) != 50 {
+		t.Error("Expected settings to be duty without -ble.perceptual")
+	}
+	perceptualDimming = true
+	defer func() { perceptualDimming = false }()
+	for _, c := range []struct{ setting, duty float64 }{
+		{0, 0}, {100, 100}, {50, 18.42}, {8, 0.886},
+	} {
+		if d := linear(c.setting); math.Abs(d-c.duty) > 0.01 {
+			t.Errorf("Expected %.0f%% to be %.2f%% duty, got %.2f%%", c.setting, c.duty, d)
+		}
+	}
+
+	tk := newTank()
+	tk.channelSetting[0] = 50
+	tk.limits["test"] = 10
+	tk.update(tk, time.Now())
+	if tk.wants[0] != 10 {
+		t.Errorf("Expected limits to cap the duty, got %.2f", tk.wants[0])
+	}
+	delete(tk.limits, "test")
+	tk.update(tk, time.Now())
+	if math.Abs(tk.wants[0]-18.42) > 0.01 {
+		t.Errorf("Expected half brightness at 18.4%% duty, got %.2f", tk.wants[0])
+	}
+}
+
+func TestPerceptualProgram(t *testing.T) {
+	perceptualDimming = true
+	defer func() { perceptualDimming = false }()
+	prog := Program{Points: []ProgramPoint{{Minute: 600, Percents: Percents{100, 50}}}}
+	data, err := encodeProgram(prog, FixtureConfig{}, &v1Profile, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Half brightness is 18.4% duty, as the controller writes it
+	if v0, v1 := data[6], data[7]; v0 != 250 || v1 != 46 {
+		t.Errorf("Expected the program uploaded as duty, got %d and %d", v0, v1)
+	}
+}
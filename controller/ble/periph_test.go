@@ -0,0 +1,12 @@
+package ble
+
+// fakePeriph is a peripheral which only knows its ID. Any other call
+// panics on the nil embedded interface.
+type fakePeriph struct {
+	peripheral
+	id string
+}
+
+func (p fakePeriph) ID() string { return p.id }
+
+func fakeID(id string) peripheral { return fakePeriph{id: id} }
@@ -0,0 +1,157 @@
+package ble
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+func init() {
+	flag.Var(&defaultFixture.ChannelWatts, "ble.watts",
+		"Comma separated watts drawn by each channel at 100%, e.g. 10,10,5,10")
+}
+
+// Watts is a per-channel list of power draw at full output.
+type Watts []float64
+
+func (w *Watts) String() string {
+	parts := make([]string, 0, len(*w))
+	for _, v := range *w {
+		parts = append(parts, strconv.FormatFloat(v, 'g', -1, 64))
+	}
+	return strings.Join(parts, ",")
+}
+
+func (w *Watts) Set(value string) error {
+	watts := Watts{}
+	for _, part := range strings.Split(value, ",") {
+		v, err := strconv.ParseFloat(strings.TrimSpace(part), 64)
+		if err != nil {
+			return err
+		}
+		if v < 0 {
+			return errors.New("channel watts must not be negative")
+		}
+		watts = append(watts, v)
+	}
+	*w = watts
+	return nil
+}
+
+// power estimates the instantaneous draw for the given channel percents.
+func (w Watts) power(percents map[int]float64) float64 {
+	total := 0.0
+	for channel, watts := range w {
+		total += percents[channel] / 100.0 * watts
+	}
+	return total
+}
+
+// energy tracks estimated consumption for the current local day and
+// month.
+type energy struct {
+	day       string
+	whToday   float64
+	whPrevDay float64
+
+	month       string
+	whMonth     float64
+	whPrevMonth float64
+}
+
+func dayKey(t time.Time) string {
+	return fmt.Sprintf("%04d-%02d-%02d", t.Year(), t.Month(), t.Day())
+}
+
+func monthKey(t time.Time) string {
+	return fmt.Sprintf("%04d-%02d", t.Year(), t.Month())
+}
+
+// add accumulates watts drawn over the elapsed time up to now. Time
+// either side of local midnight is charged to the day, and month, it
+// fell in.
+func (e *energy) add(watts float64, elapsed time.Duration, now time.Time) {
+	from := now.Add(-elapsed)
+	for {
+		y, m, d := from.Date()
+		midnight := time.Date(y, m, d+1, 0, 0, 0, 0, from.Location())
+		if !midnight.Before(now) {
+			e.charge(watts*now.Sub(from).Hours(), from)
+			return
+		}
+		e.charge(watts*midnight.Sub(from).Hours(), from)
+		from = midnight
+	}
+}
+
+// charge adds wh drawn at the given time, rolling over at local
+// midnight and the start of each month.
+func (e *energy) charge(wh float64, at time.Time) {
+	day := dayKey(at)
+	if e.day != day {
+		if e.day != "" {
+			e.whPrevDay = e.whToday
+		}
+		e.day = day
+		e.whToday = 0
+	}
+	month := monthKey(at)
+	if e.month != month {
+		if e.month != "" {
+			e.whPrevMonth = e.whMonth
+		}
+		e.month = month
+		e.whMonth = 0
+	}
+	e.whToday += wh
+	e.whMonth += wh
+}
+
+// Power estimates the watts the connected fixtures of the default tank
+// would draw with their channels at percents.
+func (ble *bleChannel) Power(percents map[int]float64) float64 {
+	return ble.power("", percents)
+}
+
+// power estimates the draw of a tank's connected fixtures.
+func (ble *bleChannel) power(tank string, percents map[int]float64) float64 {
+	ble.lock.Lock()
+	defer ble.lock.Unlock()
+	total := 0.0
+	for _, p := range ble.connectedPeriph {
+		if p.config.Tank == tank {
+			total += p.config.ChannelWatts.power(percents)
+		}
+	}
+	return total
+}
+
+// Watts and the energy totals are read under the channel lock, as
+// writeLedState updates the output under it.
+func (p *blePeriph) Watts() float64 {
+	defer p.guard()()
+	return p.output.watts
+}
+
+func (p *blePeriph) EnergyToday() float64 {
+	defer p.guard()()
+	return p.output.energy.whToday
+}
+
+func (p *blePeriph) EnergyPrevDay() float64 {
+	defer p.guard()()
+	return p.output.energy.whPrevDay
+}
+
+func (p *blePeriph) EnergyMonth() float64 {
+	defer p.guard()()
+	return p.output.energy.whMonth
+}
+
+func (p *blePeriph) EnergyPrevMonth() float64 {
+	defer p.guard()()
+	return p.output.energy.whPrevMonth
+}
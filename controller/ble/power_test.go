@@ -0,0 +1,124 @@
+package ble
+
+import (
+	"math"
+	"testing"
+	"time"
+
+	"github.com/theatrus/ledbrick/controller/clock"
+)
+
+func TestWattsPower(t *testing.T) {
+	w := Watts{10, 20}
+	p := w.power(map[int]float64{0: 50, 1: 100, 2: 100})
+	if p != 25 {
+		t.Errorf("Expected 25 W, got %f", p)
+	}
+}
+
+func TestEnergyRollover(t *testing.T) {
+	var e energy
+	day := time.Date(2016, 1, 1, 23, 0, 0, 0, time.UTC)
+	e.add(10, time.Hour, day)
+	if e.whToday != 10 {
+		t.Errorf("Expected 10 Wh, got %f", e.whToday)
+	}
+	e.add(10, 30*time.Minute, day.Add(2*time.Hour))
+	if e.whToday != 5 || e.whPrevDay != 10 {
+		t.Errorf("Bad rollover: %+v", e)
+	}
+	if e.whMonth != 15 {
+		t.Errorf("Expected 15 Wh this month, got %f", e.whMonth)
+	}
+	e.add(10, time.Hour, time.Date(2016, 2, 1, 1, 0, 0, 0, time.UTC))
+	if e.whMonth != 10 || e.whPrevMonth != 15 {
+		t.Errorf("Bad month rollover: %+v", e)
+	}
+}
+
+func TestEnergyAcrossMidnight(t *testing.T) {
+	var e energy
+	e.add(10, time.Hour, time.Date(2016, 1, 31, 23, 0, 0, 0, time.UTC))
+	e.add(10, 3*time.Hour, time.Date(2016, 2, 1, 1, 0, 0, 0, time.UTC))
+	if e.whPrevDay != 30 || e.whToday != 10 {
+		t.Errorf("Expected 30 Wh on the 31st and 10 Wh on the 1st, got %+v", e)
+	}
+	if e.whPrevMonth != 30 || e.whMonth != 10 {
+		t.Errorf("Expected the month to split at midnight, got %+v", e)
+	}
+
+	// Writes on either side of midnight
+	e = energy{}
+	day := time.Date(2016, 3, 1, 23, 59, 59, 0, time.UTC)
+	e.add(36, 0, day)
+	e.add(36, 2*time.Second, day.Add(2*time.Second))
+	if math.Abs(e.whPrevDay-0.01) > 1e-9 || math.Abs(e.whToday-0.01) > 1e-9 {
+		t.Errorf("Expected a second charged to each day, got %+v", e)
+	}
+}
+
+func TestEnergyConcurrent(t *testing.T) {
+	ble, _ := newTestChannel()
+	connect(ble, newFakeFixture("f1"))
+	ble.SetChannel(0, 50)
+
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		for i := 0; i < 200; i++ {
+			ble.writeLedState()
+		}
+	}()
+	for {
+		select {
+		case <-done:
+			return
+		default:
+		}
+		for _, p := range ble.Perhipherals() {
+			p.Watts()
+			p.EnergyToday()
+			p.EnergyPrevDay()
+			p.EnergyMonth()
+			p.EnergyPrevMonth()
+		}
+	}
+}
+
+func TestEnergyOnlyWhileConnected(t *testing.T) {
+	ble, _ := newTestChannel()
+	c := clock.NewFake(time.Date(2016, 1, 1, 12, 0, 0, 0, time.UTC))
+	ble.clock = c
+	config := defaultFixture
+	config.ChannelWatts = Watts{36}
+	ble.fixtures["f1"] = config
+	f := newFakeFixture("f1")
+	connect(ble, f)
+	ble.SetChannelImmediate(0, 100)
+	ble.writeLedState()
+	p := ble.connectedPeriph["f1"]
+
+	c.Advance(time.Second)
+	ble.writeLedState()
+	if wh := p.EnergyToday(); math.Abs(wh-0.01) > 1e-9 {
+		t.Errorf("Expected 0.01 Wh for a second at 36 W, got %f", wh)
+	}
+
+	// Nothing is charged for the hour the fixture was away
+	ble.onPeriphDisconnected(f, nil)
+	c.Advance(time.Hour)
+	connect(ble, f)
+	p = ble.connectedPeriph["f1"]
+	c.Advance(time.Second)
+	ble.writeLedState()
+	if wh := p.EnergyToday(); math.Abs(wh-0.01) > 1e-9 {
+		t.Errorf("Expected the disconnect not to be charged, got %f Wh", wh)
+	}
+
+	// nor more than a few writes' worth when writes stall
+	c.Advance(time.Hour)
+	ble.writeLedState()
+	if wh, want := p.EnergyToday(), 0.01+36*(energyGapWrites*refresh).Hours(); math.Abs(wh-want) > 1e-9 {
+		t.Errorf("Expected a stalled write charged %f Wh, got %f", want, wh)
+	}
+}
@@ -0,0 +1,145 @@
+package ble
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"math"
+)
+
+var profileFile string
+
+func init() {
+	flag.StringVar(&profileFile, "ble.profiles", "",
+		"JSON file of extra device profiles, for fixture hardware this build doesn't know")
+}
+
+// DeviceProfile describes one generation of fixture hardware: how to
+// recognise it, its GATT layout and how its outputs are driven. Empty
+// optional characteristics are features the hardware doesn't have.
+type DeviceProfile struct {
+	Name string `json:"name"`
+	// Advertised are the names the hardware advertises itself as
+	Advertised []string `json:"advertised"`
+	// Channels is how many PWM outputs it has
+	Channels int `json:"channels"`
+	// PWMBits is 8 for a byte per output write, or 16 for a little
+	// endian pair. FullScale is the value written for 100%.
+	PWMBits   int `json:"pwm_bits"`
+	FullScale int `json:"full_scale"`
+
+	LedChar  string `json:"led_char"`
+	TempChar string `json:"temp_char"`
+	FanChar  string `json:"fan_char"`
+	// Optional characteristics
+	FanConfigChar string `json:"fan_config_char"`
+	StatusChar    string `json:"status_char"`
+	ScheduleChar  string `json:"schedule_char"`
+	CapsChar      string `json:"caps_char"`
+	PWMConfigChar string `json:"pwm_config_char"`
+	NameChar      string `json:"name_char"`
+}
+
+// v1Profile is the original LEDBrick PWM board.
+var v1Profile = DeviceProfile{Name: "ledbrick-v1",
+	Advertised:    []string{"LEDBrick-PWM"},
+	Channels:      8,
+	PWMBits:       8,
+	FullScale:     250,
+	LedChar:       pwmLedChar,
+	TempChar:      pwmTempChar,
+	FanChar:       pwmFanChar,
+	FanConfigChar: pwmFanConfigChar,
+	StatusChar:    pwmStatusChar,
+	ScheduleChar:  pwmScheduleChar,
+	CapsChar:      pwmCapsChar,
+	PWMConfigChar: pwmConfigChar,
+	NameChar:      pwmNameChar,
+}
+
+// validate checks a profile can drive hardware.
+func (d DeviceProfile) validate() error {
+	switch {
+	case d.Name == "":
+		return fmt.Errorf("profile has no name")
+	case len(d.Advertised) == 0:
+		return fmt.Errorf("profile %s: no advertised names", d.Name)
+	case d.Channels < 1 || d.Channels > 256:
+		return fmt.Errorf("profile %s: channels must be 1 to 256", d.Name)
+	case d.PWMBits != 8 && d.PWMBits != 16:
+		return fmt.Errorf("profile %s: pwm_bits must be 8 or 16", d.Name)
+	case d.FullScale < 1 || d.FullScale >= 1<<uint(d.PWMBits):
+		return fmt.Errorf("profile %s: full_scale must fit in %d bits", d.Name, d.PWMBits)
+	case d.LedChar == "":
+		return fmt.Errorf("profile %s: no led_char", d.Name)
+	}
+	return nil
+}
+
+// value returns the PWM value driving an output at a percent.
+func (d *DeviceProfile) value(percent float64) int {
+	// Truncated, as writes always have been
+	return int(math.Max(percent, 0) / 100.0 * float64(d.FullScale))
+}
+
+// encode returns the LED characteristic write setting an output.
+func (d *DeviceProfile) encode(output int, percent float64) []byte {
+	value := d.value(percent)
+	if d.PWMBits == 16 {
+		return []byte{byte(output), byte(value), byte(value >> 8)}
+	}
+	return []byte{byte(output), byte(value)}
+}
+
+// loadProfiles returns the built in profiles with those from the
+// profiles file, which replace any built in profile of the same name.
+func loadProfiles() ([]DeviceProfile, error) {
+	profiles := []DeviceProfile{v1Profile, wifiProfile}
+	if profileFile == "" {
+		return profiles, nil
+	}
+	data, err := ioutil.ReadFile(profileFile)
+	if err != nil {
+		return nil, err
+	}
+	var extra []DeviceProfile
+	if err := json.Unmarshal(data, &extra); err != nil {
+		return nil, err
+	}
+	for _, d := range extra {
+		if err := d.validate(); err != nil {
+			return nil, err
+		}
+		replaced := false
+		for i := range profiles {
+			if profiles[i].Name == d.Name {
+				profiles[i] = d
+				replaced = true
+			}
+		}
+		if !replaced {
+			profiles = append(profiles, d)
+		}
+	}
+	return profiles, nil
+}
+
+// LoadProfiles reads the device profiles, so the profiles file can be
+// checked without starting the channel.
+func LoadProfiles() ([]DeviceProfile, error) {
+	return loadProfiles()
+}
+
+// profileFor returns the profile for an advertised name, or nil if it
+// isn't a fixture.
+func (ble *bleChannel) profileFor(name string) *DeviceProfile {
+	for i := range ble.profiles {
+		for _, n := range ble.profiles[i].Advertised {
+			if n == name {
+				return &ble.profiles[i]
+			}
+		}
+	}
+	return nil
+}
@@ -0,0 +1,66 @@
+package ble
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/paypal/gatt"
+)
+
+func TestProfileEncode(t *testing.T) {
+	if b := v1Profile.encode(3, 100); !bytes.Equal(b, []byte{3, 250}) {
+		t.Errorf("Expected v1 full scale of 250, got % x", b)
+	}
+	v2 := DeviceProfile{Name: "v2", Advertised: []string{"LEDBrick-2"}, Channels: 12,
+		PWMBits: 16, FullScale: 4095, LedChar: pwmLedChar}
+	if err := v2.validate(); err != nil {
+		t.Fatal(err)
+	}
+	if b := v2.encode(10, 50); !bytes.Equal(b, []byte{10, 0xff, 0x07}) {
+		t.Errorf("Expected a 16 bit half scale write, got % x", b)
+	}
+
+	bad := v2
+	bad.FullScale = 1 << 16
+	if err := bad.validate(); err == nil {
+		t.Error("Expected a full scale past the PWM width to fail")
+	}
+}
+
+func TestProfileDiscovery(t *testing.T) {
+	ble, d := newTestChannel()
+	ble.profiles = append(ble.profiles, DeviceProfile{Name: "small",
+		Advertised: []string{"LEDBrick-Mini"},
+		Channels:   4,
+		PWMBits:    8,
+		FullScale:  255,
+		LedChar:    pwmLedChar,
+		TempChar:   pwmTempChar,
+	})
+
+	f := newFakeFixture("mini")
+	f.name = "LEDBrick-Mini"
+	ble.onPeriphDiscovered(f, &gatt.Advertisement{}, -50)
+	ble.onPeriphConnected(f, nil)
+	if len(d.connects) != 1 || ble.connectedPeriph["mini"] == nil {
+		t.Fatal("Expected a fixture matching a profile to connect")
+	}
+	if bp := ble.connectedPeriph["mini"]; bp.fanChar != nil {
+		t.Error("Expected the fan characteristic the profile lacks to be left out")
+	}
+
+	ble.SetChannelImmediate(0, 100)
+	if err := ble.writeLedState(); err != nil {
+		t.Fatal(err)
+	}
+	f.lock.Lock()
+	defer f.lock.Unlock()
+	if len(f.writes) != 4 {
+		t.Errorf("Expected only the 4 outputs written, got %d", len(f.writes))
+	}
+	for _, b := range f.writes {
+		if b[0] == 0 && b[1] != 255 {
+			t.Errorf("Expected full scale 255 on output 0, got %d", b[1])
+		}
+	}
+}
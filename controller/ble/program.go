@@ -0,0 +1,336 @@
+package ble
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"flag"
+	"fmt"
+	"hash/crc32"
+	"log"
+	"time"
+
+	"github.com/theatrus/ledbrick/controller/alert"
+)
+
+var programUpload bool
+var programLease time.Duration
+
+func init() {
+	flag.BoolVar(&programUpload, "ble.program", true,
+		"Upload the running table to fixtures which can run a schedule on their own, instead of the failsafe program")
+	flag.DurationVar(&programLease, "ble.program.lease", 2*time.Minute,
+		"How long fixtures running the table leave it to the controller without hearing from it before going standalone")
+}
+
+const (
+	programVersion = 2
+	// Bytes of each chunk before the data
+	programChunkHeader = 4
+	// How long to wait before retrying a failed upload
+	programRetry = time.Minute
+)
+
+// Program is a lighting table as uploaded to fixtures, so they keep
+// running it if the controller goes away.
+type Program struct {
+	// Location the point times are in, local time if nil
+	Location *time.Location
+	Points   []ProgramPoint
+}
+
+// ProgramPoint is a setting point: the percent of each channel at a
+// time of day, interpolated between points as the controller does.
+type ProgramPoint struct {
+	Minute   int
+	Percents Percents
+}
+
+// Program data encodings, the first byte of the data
+const (
+	programRaw = iota
+	programCompact
+)
+
+// Chunk offsets and the committed length are 16 bits, so this is the
+// most program data a fixture can take
+const maxProgramSize = 0xffff
+
+// encodeProgram serializes a program for one fixture: the encoding, then
+// a byte each for the point count, the outputs per point and the bytes
+// per output, then the points. Raw points are a big endian minute since
+// midnight and the output values as the profile writes them. Compact
+// points are the minutes since the last point as a uvarint, then each
+// output's change from the last point as a zigzag varint, which shrinks
+// the usual table of a few ramps holding flat in between to a fraction
+// of the writes. Percents are settings, read as perceived brightness
+// under -ble.perceptual as the controller's own writes are.
+func encodeProgram(prog Program, config FixtureConfig, profile *DeviceProfile, compact bool) ([]byte, error) {
+	if len(prog.Points) > 255 {
+		return nil, fmt.Errorf("%d setting points, at most 255 can be uploaded", len(prog.Points))
+	}
+	outputs := profile.Channels
+	if outputs > 255 {
+		outputs = 255
+	}
+	encoding := programRaw
+	if compact {
+		encoding = programCompact
+	}
+	b := []byte{byte(encoding), byte(len(prog.Points)), byte(outputs), byte(profile.PWMBits / 8)}
+	varint := make([]byte, binary.MaxVarintLen64)
+	lastMinute := 0
+	last := make([]int, outputs)
+	for _, pt := range prog.Points {
+		if compact {
+			b = append(b, varint[:binary.PutUvarint(varint, uint64(pt.Minute-lastMinute))]...)
+			lastMinute = pt.Minute
+		} else {
+			b = append(b, byte(pt.Minute>>8), byte(pt.Minute))
+		}
+		settings := make(Percents, len(pt.Percents))
+		for channel, v := range pt.Percents {
+			settings[channel] = linear(v)
+		}
+		percents := config.physical(settings)
+		for output := 0; output < outputs; output++ {
+			v := 0.0
+			if output < len(percents) {
+				v = percents[output]
+			}
+			if compact {
+				value := profile.value(v)
+				b = append(b, varint[:binary.PutVarint(varint, int64(value-last[output]))]...)
+				last[output] = value
+			} else {
+				b = append(b, profile.encode(output, v)[1:]...)
+			}
+		}
+	}
+	if len(b) > maxProgramSize {
+		return nil, fmt.Errorf("%d bytes of program data, at most %d can be uploaded", len(b), maxProgramSize)
+	}
+	return b, nil
+}
+
+// programChunks splits a serialized program into writes of at most
+// size bytes: a version byte, 'D' and the big endian offset, then the
+// data.
+func programChunks(data []byte, size int) [][]byte {
+	n := size - programChunkHeader
+	var chunks [][]byte
+	for offset := 0; offset < len(data); offset += n {
+		end := offset + n
+		if end > len(data) {
+			end = len(data)
+		}
+		chunk := []byte{programVersion, 'D', byte(offset >> 8), byte(offset)}
+		chunks = append(chunks, append(chunk, data[offset:end]...))
+	}
+	return chunks
+}
+
+// programCommit is the write which makes the fixture check and run the
+// uploaded program: a version byte, 'C', the big endian length and
+// CRC-32 of the data, and the time of day in minutes since midnight,
+// which is resent to keep the fixture's clock in step.
+func programCommit(data []byte, now time.Time) []byte {
+	sum := crc32.ChecksumIEEE(data)
+	minutes := now.Hour()*60 + now.Minute()
+	return []byte{programVersion, 'C',
+		byte(len(data) >> 8), byte(len(data)),
+		byte(sum >> 24), byte(sum >> 16), byte(sum >> 8), byte(sum),
+		byte(minutes >> 8), byte(minutes),
+	}
+}
+
+// programRunning reads back what the fixture is running, a version byte
+// and the CRC-32 of its program, and reports whether it matches.
+func (p *blePeriph) programRunning(data []byte) (bool, error) {
+	b, err := p.gp.ReadCharacteristic(p.scheduleChar)
+	if err != nil {
+		return false, err
+	}
+	sum := crc32.ChecksumIEEE(data)
+	want := []byte{programVersion, byte(sum >> 24), byte(sum >> 16), byte(sum >> 8), byte(sum)}
+	return bytes.Equal(b, want), nil
+}
+
+// uploadProgram sends a program unless the fixture is already running
+// it, commits it and verifies the fixture took it. An upload cut off
+// part way resumes after the last chunk the fixture acknowledged, as
+// long as the program hasn't changed since; if the fixture lost what it
+// had, verifying fails and the next attempt starts over.
+func (p *blePeriph) uploadProgram(data []byte, now time.Time) error {
+	if ok, err := p.programRunning(data); err == nil && ok {
+		return p.write(p.scheduleChar, programCommit(data, now), false)
+	}
+	sum := crc32.ChecksumIEEE(data)
+	if p.resumeSum != sum {
+		p.resumeSum = sum
+		p.resumeFrom = 0
+	}
+	chunks := programChunks(data, p.payloadSize())
+	if p.resumeFrom > 0 {
+		log.Printf("%s: resuming table upload at chunk %d of %d", p.gp.ID(), p.resumeFrom+1, len(chunks))
+	}
+	for ; p.resumeFrom < len(chunks); p.resumeFrom++ {
+		if err := p.write(p.scheduleChar, chunks[p.resumeFrom], false); err != nil {
+			return err
+		}
+	}
+	p.resumeFrom = 0
+	if err := p.write(p.scheduleChar, programCommit(data, now), false); err != nil {
+		return err
+	}
+	ok, err := p.programRunning(data)
+	if err != nil {
+		return err
+	}
+	if !ok {
+		return errors.New("fixture reports a different program after upload")
+	}
+	return nil
+}
+
+// writeProgram keeps a fixture with a schedule characteristic running
+// its tank's table: uploading it when it changes or the fixture
+// connects, retrying failed uploads, and resending the time as often as
+// the failsafe program would be. Uploads run off the channel lock, so a
+// slow fixture doesn't hold up the rest. It reports whether the fixture
+// has the table verified, and until it does the failsafe program is
+// sent as well.
+func (p *blePeriph) writeProgram(t *tank, now time.Time) bool {
+	if !programUpload || p.scheduleChar == nil || len(t.program.Points) == 0 {
+		return false
+	}
+	if p.upload != nil {
+		return p.programOK
+	}
+	changed := p.programVersion != t.programVersion
+	due := now.Sub(p.programSent) >= failsafeResync || !p.programOK && now.Sub(p.programSent) >= programRetry
+	if !changed && !due {
+		p.holdProgram(now)
+		return p.programOK
+	}
+	p.programSent = now
+	p.programVersion = t.programVersion
+	if changed {
+		p.programOK = false
+	}
+
+	loc := t.program.Location
+	if loc == nil {
+		loc = time.Local
+	}
+	data, err := encodeProgram(t.program, p.config, p.profile, p.compact)
+	if err != nil {
+		p.programDone(err, len(t.program.Points))
+		return false
+	}
+	// Already verified, only the clock needs to be kept in step
+	commitOnly := p.programOK
+	points := len(t.program.Points)
+	done := make(chan struct{})
+	p.upload = done
+	go func() {
+		defer close(done)
+		var err error
+		if commitOnly {
+			err = p.write(p.scheduleChar, programCommit(data, now.In(loc)), false)
+		} else {
+			err = p.uploadProgram(data, now.In(loc))
+		}
+		unlock := p.guard()
+		defer unlock()
+		p.upload = nil
+		p.programDone(err, points)
+	}()
+	return p.programOK
+}
+
+// programDone records how an upload of a table of points went. It
+// must be called with the channel lock held.
+func (p *blePeriph) programDone(err error, points int) {
+	if err != nil {
+		if !p.programFailed {
+			alert.Raise(alert.Warning, p.gp.ID(), "schedule.upload",
+				"uploading the table failed, the fixture may not run it standalone: %v", err)
+		}
+		p.programOK = false
+		p.programFailed = true
+		return
+	}
+	if p.programFailed {
+		alert.Raise(alert.Info, p.gp.ID(), "schedule.upload", "table uploaded")
+	}
+	if !p.programOK {
+		log.Printf("%s: table of %d points uploaded and verified", p.gp.ID(), points)
+	}
+	p.programOK = true
+	p.programFailed = false
+}
+
+// holdProgram tells a fixture running the table that the controller is
+// driving it, so it suppresses its own schedule for the lease. The lease
+// is renewed halfway through, so if the controller goes away or the
+// link stays down the fixture takes over once it runs out, rather than
+// both fighting over the outputs.
+func (p *blePeriph) holdProgram(now time.Time) {
+	if !p.programOK || now.Before(p.heldUntil.Add(-programLease/2)) {
+		return
+	}
+	seconds := int(programLease / time.Second)
+	if seconds > 0xffff {
+		seconds = 0xffff
+	}
+	b := []byte{programVersion, 'H', byte(seconds >> 8), byte(seconds)}
+	if err := p.write(p.scheduleChar, b, false); err != nil {
+		log.Printf("%s: schedule hold error: %s", p.gp.ID(), err)
+		return
+	}
+	p.heldUntil = now.Add(programLease)
+}
+
+// releaseProgram hands a fixture holding for the controller back to its
+// own schedule straight away.
+func (p *blePeriph) releaseProgram() {
+	if p.heldUntil.IsZero() {
+		return
+	}
+	if err := p.write(p.scheduleChar, []byte{programVersion, 'R'}, false); err != nil {
+		log.Printf("%s: schedule release error: %s", p.gp.ID(), err)
+		return
+	}
+	p.heldUntil = time.Time{}
+	log.Printf("%s: released to run the table standalone", p.gp.ID())
+}
+
+// Release hands every fixture back to its own schedule, and stops
+// writing to them so the two don't fight.
+func (ble *bleChannel) Release() {
+	ble.lock.Lock()
+	defer ble.lock.Unlock()
+	ble.released = true
+	for _, p := range ble.connectedPeriph {
+		p.releaseProgram()
+	}
+}
+
+// SetProgram sets the table fixtures run when the controller is away.
+func (ble *bleChannel) SetProgram(prog Program) {
+	ble.lock.Lock()
+	defer ble.lock.Unlock()
+	ble.tank.setProgram(prog)
+}
+
+func (tc *tankChannel) SetProgram(prog Program) {
+	tc.lock.Lock()
+	defer tc.lock.Unlock()
+	tc.tankFor(tc.name).setProgram(prog)
+}
+
+func (t *tank) setProgram(prog Program) {
+	t.program = prog
+	t.programVersion++
+}
@@ -0,0 +1,282 @@
+package ble
+
+import (
+	"bytes"
+	"errors"
+	"hash/crc32"
+	"testing"
+	"time"
+
+	"github.com/paypal/gatt"
+	"github.com/theatrus/ledbrick/controller/clock"
+)
+
+func TestEncodeProgram(t *testing.T) {
+	prog := Program{Points: []ProgramPoint{
+		{Minute: 600, Percents: Percents{100, 50}},
+		{Minute: 1230, Percents: Percents{0, 20}},
+	}}
+	config := FixtureConfig{outputs: []int{1, 0}}
+	data, err := encodeProgram(prog, config, &v1Profile, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []byte{programRaw, 2, 8, 1,
+		0x02, 0x58, 125, 250, 0, 0, 0, 0, 0, 0,
+		0x04, 0xce, 50, 0, 0, 0, 0, 0, 0, 0,
+	}
+	if !bytes.Equal(data, want) {
+		t.Errorf("Expected % x, got % x", want, data)
+	}
+
+	chunks := programChunks(data, 20)
+	if len(chunks) != 2 {
+		t.Fatalf("Expected 2 chunks, got %d", len(chunks))
+	}
+	if !bytes.Equal(chunks[1][:4], []byte{2, 'D', 0, 16}) || len(chunks[1]) != 4+len(data)-16 {
+		t.Errorf("Expected the second chunk at offset 16, got % x", chunks[1])
+	}
+	for _, c := range chunks {
+		if len(c) > 20 {
+			t.Errorf("Chunk of %d bytes won't fit a single write", len(c))
+		}
+	}
+
+	compact, err := encodeProgram(prog, config, &v1Profile, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Minutes and changes past 63 each take two bytes
+	want = []byte{programCompact, 2, 8, 1,
+		0xd8, 0x04, 0xfa, 0x01, 0xf4, 0x03, 0, 0, 0, 0, 0, 0,
+		0xf6, 0x04, 0x95, 0x01, 0xf3, 0x03, 0, 0, 0, 0, 0, 0,
+	}
+	if !bytes.Equal(compact, want) {
+		t.Errorf("Expected % x, got % x", want, compact)
+	}
+
+	prog.Points = make([]ProgramPoint, 256)
+	if _, err := encodeProgram(prog, config, &v1Profile, false); err == nil {
+		t.Error("Expected too many points to fail")
+	}
+
+	// 255 points of 255 16 bit outputs overflow the 16 bit offsets
+	wide := DeviceProfile{Name: "wide", Channels: 255, PWMBits: 16, FullScale: 4095, LedChar: pwmLedChar}
+	prog.Points = make([]ProgramPoint, 255)
+	if _, err := encodeProgram(prog, config, &wide, false); err == nil {
+		t.Error("Expected over 64KB of program data to fail")
+	}
+	prog.Points = prog.Points[:100]
+	if _, err := encodeProgram(prog, config, &wide, false); err != nil {
+		t.Errorf("Expected 100 points to fit, got %v", err)
+	}
+}
+
+func TestWriteProgram(t *testing.T) {
+	ble, _ := newTestChannel()
+	c := clock.NewFake(time.Date(2016, 1, 1, 12, 0, 0, 0, time.UTC))
+	ble.clock = c
+	f := newFakeFixture("f1", pwmLedChar, pwmFanChar, pwmTempChar, pwmScheduleChar)
+	connect(ble, f)
+
+	writes := func() [][]byte {
+		f.lock.Lock()
+		defer f.lock.Unlock()
+		var program [][]byte
+		for _, b := range f.writes {
+			if len(b) > 2 {
+				program = append(program, b)
+			}
+		}
+		f.writes = nil
+		return program
+	}
+
+	bp := ble.connectedPeriph["f1"]
+	ble.SetProgram(Program{Location: time.UTC, Points: []ProgramPoint{{Minute: 0, Percents: Percents{40}}}})
+	if err := ble.writeLedState(); err != nil {
+		t.Fatal(err)
+	}
+	waitUpload(bp)
+	// One data chunk and the commit, which the fixture doesn't verify
+	if w := writes(); len(w) != 2 || w[1][1] != 'C' {
+		t.Fatalf("Expected the program uploaded and committed, got % x", w)
+	}
+	if bp.programOK {
+		t.Error("Expected an unverified upload not to be ok")
+	}
+	ble.writeLedState()
+	if w := writes(); len(w) != 0 {
+		t.Errorf("Expected no retry straight away, got % x", w)
+	}
+
+	// The fixture is running it now, so the retry only commits
+	data, _ := encodeProgram(ble.tank.program, FixtureConfig{}, &v1Profile, false)
+	sum := crc32.ChecksumIEEE(data)
+	f.lock.Lock()
+	f.schedule = []byte{2, byte(sum >> 24), byte(sum >> 16), byte(sum >> 8), byte(sum)}
+	f.lock.Unlock()
+	c.Advance(programRetry)
+	ble.writeLedState()
+	waitUpload(bp)
+	w := writes()
+	if len(w) != 1 || w[0][1] != 'C' {
+		t.Fatalf("Expected only a commit, got % x", w)
+	}
+	if minutes := int(w[0][8])<<8 | int(w[0][9]); minutes != 12*60+1 {
+		t.Errorf("Expected the fixture clock set to 12:01, got %d", minutes)
+	}
+	if !bp.programOK {
+		t.Error("Expected the verified program to be ok")
+	}
+	ble.writeLedState()
+	if w := writes(); len(w) != 1 || w[0][1] != 'H' {
+		t.Fatalf("Expected the verified program held, got % x", w)
+	}
+
+	// The hold is renewed halfway through the lease
+	c.Advance(programLease/2 - time.Second)
+	ble.writeLedState()
+	if w := writes(); len(w) != 0 {
+		t.Errorf("Expected the hold to last, got % x", w)
+	}
+	c.Advance(time.Second)
+	ble.writeLedState()
+	if w := writes(); len(w) != 1 || w[0][1] != 'H' {
+		t.Errorf("Expected the hold renewed, got % x", w)
+	}
+
+	// A new table is uploaded again, which the fixture fails to verify
+	ble.SetProgram(Program{Location: time.UTC, Points: []ProgramPoint{{Minute: 0, Percents: Percents{50}}}})
+	ble.writeLedState()
+	waitUpload(bp)
+	if w := writes(); len(w) != 2 {
+		t.Errorf("Expected the changed program uploaded, got % x", w)
+	}
+
+	ble.Release()
+	f.lock.Lock()
+	released := len(f.writes) == 1 && bytes.Equal(f.writes[0], []byte{2, 'R'})
+	f.lock.Unlock()
+	if !released {
+		t.Errorf("Expected the fixture released, got % x", f.writes)
+	}
+	writes()
+	ble.writeLedState()
+	f.lock.Lock()
+	defer f.lock.Unlock()
+	if len(f.writes) != 0 {
+		t.Errorf("Expected no writes once released, got % x", f.writes)
+	}
+}
+
+// waitUpload waits for a fixture's table upload, if one is running.
+func waitUpload(p *blePeriph) {
+	p.lock.Lock()
+	done := p.upload
+	p.lock.Unlock()
+	if done != nil {
+		<-done
+	}
+}
+
+func TestProgramFailsafe(t *testing.T) {
+	on, off := failsafeOn, failsafeOff
+	defer func() { failsafeOn, failsafeOff = on, off }()
+	failsafeOn.Set("10:00")
+	failsafeOff.Set("20:00")
+
+	ble, _ := newTestChannel()
+	c := clock.NewFake(time.Date(2016, 1, 1, 12, 0, 0, 0, time.UTC))
+	ble.clock = c
+	f := newFakeFixture("f1", pwmLedChar, pwmFanChar, pwmTempChar, pwmScheduleChar)
+	connect(ble, f)
+	bp := ble.connectedPeriph["f1"]
+
+	failsafes := func() int {
+		f.lock.Lock()
+		defer f.lock.Unlock()
+		n := 0
+		for _, b := range f.writes {
+			if len(b) > 2 && b[0] == failsafeVersion {
+				n++
+			}
+		}
+		f.writes = nil
+		return n
+	}
+
+	// The fixture never verifies the upload, so it gets the failsafe
+	ble.SetProgram(Program{Location: time.UTC, Points: []ProgramPoint{{Minute: 0, Percents: Percents{40}}}})
+	ble.writeLedState()
+	waitUpload(bp)
+	if n := failsafes(); n != 1 {
+		t.Fatalf("Expected the failsafe sent while the table isn't verified, got %d", n)
+	}
+
+	// Once it verifies, resyncing doesn't send the failsafe again
+	data, _ := encodeProgram(ble.tank.program, FixtureConfig{}, &v1Profile, false)
+	sum := crc32.ChecksumIEEE(data)
+	f.lock.Lock()
+	f.schedule = []byte{2, byte(sum >> 24), byte(sum >> 16), byte(sum >> 8), byte(sum)}
+	f.lock.Unlock()
+	c.Advance(programRetry)
+	ble.writeLedState()
+	waitUpload(bp)
+	if !bp.programOK {
+		t.Fatal("Expected the verified program to be ok")
+	}
+	c.Advance(failsafeResync)
+	ble.writeLedState()
+	waitUpload(bp)
+	if n := failsafes(); n != 0 {
+		t.Errorf("Expected no failsafe once the table is verified, got %d", n)
+	}
+}
+
+// failAfter is a fixture whose link drops after ok more writes.
+type failAfter struct {
+	*fakeFixture
+	ok int
+}
+
+func (f *failAfter) WriteCharacteristic(c *gatt.Characteristic, b []byte, noRsp bool) error {
+	if f.ok == 0 {
+		return errors.New("link lost")
+	}
+	f.ok--
+	return f.fakeFixture.WriteCharacteristic(c, b, noRsp)
+}
+
+func TestProgramResume(t *testing.T) {
+	ble, _ := newTestChannel()
+	f := newFakeFixture("f1", pwmLedChar, pwmFanChar, pwmTempChar, pwmScheduleChar)
+	connect(ble, f)
+	bp := ble.connectedPeriph["f1"]
+
+	var points []ProgramPoint
+	for minute := 0; minute < 24*60; minute += 60 {
+		points = append(points, ProgramPoint{Minute: minute, Percents: Percents{float64(minute % 100)}})
+	}
+	data, _ := encodeProgram(Program{Points: points}, FixtureConfig{}, &v1Profile, false)
+	chunks := programChunks(data, 20)
+
+	bp.gp = &failAfter{fakeFixture: f, ok: 2}
+	if err := bp.uploadProgram(data, time.Now()); err == nil {
+		t.Fatal("Expected the upload to fail")
+	}
+	if bp.resumeFrom != 2 {
+		t.Fatalf("Expected to resume after 2 chunks, got %d", bp.resumeFrom)
+	}
+
+	f.lock.Lock()
+	f.writes = nil
+	f.lock.Unlock()
+	bp.gp = f
+	bp.uploadProgram(data, time.Now())
+	f.lock.Lock()
+	defer f.lock.Unlock()
+	if len(f.writes) != len(chunks)-2+1 || !bytes.Equal(f.writes[0], chunks[2]) {
+		t.Errorf("Expected the upload resumed at the third chunk, got % x", f.writes)
+	}
+}
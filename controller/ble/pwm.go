@@ -0,0 +1,68 @@
+package ble
+
+import (
+	"errors"
+	"log"
+
+	"github.com/theatrus/ledbrick/controller/alert"
+)
+
+// Fixture PWM frequencies which may be asked for, in Hz
+const (
+	minPWMFrequency = 100
+	maxPWMFrequency = 40000
+)
+
+// validatePWM checks a fixture's PWM settings.
+func (c FixtureConfig) validatePWM() error {
+	if c.PWMFrequency != 0 && (c.PWMFrequency < minPWMFrequency || c.PWMFrequency > maxPWMFrequency) {
+		return errors.New("pwm_frequency must be 100-40000 Hz")
+	}
+	return nil
+}
+
+// pwmConfig is the PWM config characteristic write: a version byte, the
+// big endian frequency in Hz or 0 to keep the firmware's, and 1 to
+// dither, 0 not to or 0xff to keep the firmware's choice.
+func (c FixtureConfig) pwmConfig() []byte {
+	dither := byte(0xff)
+	if c.PWMDither != nil {
+		dither = 0
+		if *c.PWMDither {
+			dither = 1
+		}
+	}
+	f := c.PWMFrequency
+	return []byte{1, byte(f >> 24), byte(f >> 16), byte(f >> 8), byte(f), dither}
+}
+
+// writePWMConfig sends the fixture's PWM frequency and dithering once
+// connected, for those which take them. Filming a tank lit at a
+// frequency beating with the camera's shutter shows banding, which a
+// higher frequency or dithering gets rid of without reflashing.
+func (p *blePeriph) writePWMConfig() {
+	if p.pwmConfigSent || p.config.PWMFrequency == 0 && p.config.PWMDither == nil {
+		return
+	}
+	if p.pwmConfigChar == nil {
+		log.Printf("%s: fixture does not support PWM settings, using firmware default", p.gp.ID())
+		p.pwmConfigSent = true
+		return
+	}
+	if err := p.write(p.pwmConfigChar, p.config.pwmConfig(), false); err != nil {
+		alert.Raise(alert.Warning, p.gp.ID(), "pwm.config", "setting the PWM frequency and dithering failed: %v", err)
+		return
+	}
+	log.Printf("%s: PWM set to %d Hz, dithering %s", p.gp.ID(), p.config.PWMFrequency, ditherName(p.config.PWMDither))
+	p.pwmConfigSent = true
+}
+
+func ditherName(dither *bool) string {
+	switch {
+	case dither == nil:
+		return "as the firmware has it"
+	case *dither:
+		return "on"
+	}
+	return "off"
+}
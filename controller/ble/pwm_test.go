@@ -0,0 +1,41 @@
+package ble
+
+import (
+	"bytes"
+	"testing"
+)
+
+func TestPWMConfig(t *testing.T) {
+	on := true
+	c := FixtureConfig{PWMFrequency: 20000, PWMDither: &on}
+	if b := c.pwmConfig(); !bytes.Equal(b, []byte{1, 0, 0, 0x4e, 0x20, 1}) {
+		t.Errorf("Unexpected PWM config write % x", b)
+	}
+	if b := (FixtureConfig{}).pwmConfig(); !bytes.Equal(b, []byte{1, 0, 0, 0, 0, 0xff}) {
+		t.Errorf("Expected an unset config to keep the firmware's, got % x", b)
+	}
+	if err := (FixtureConfig{PWMFrequency: 50}).validatePWM(); err == nil {
+		t.Error("Expected 50 Hz to be out of range")
+	}
+}
+
+func TestWritePWMConfig(t *testing.T) {
+	ble, _ := newTestChannel()
+	ble.fixtures["fixture"] = FixtureConfig{PWMFrequency: 20000}
+	f := newFakeFixture("fixture", pwmLedChar, pwmFanChar, pwmTempChar, pwmConfigChar)
+	connect(ble, f)
+
+	ble.writeLedState()
+	ble.writeLedState()
+	sent := 0
+	f.lock.Lock()
+	for _, b := range f.writes {
+		if len(b) == 6 && b[0] == 1 && b[3] == 0x4e {
+			sent++
+		}
+	}
+	f.lock.Unlock()
+	if sent != 1 {
+		t.Errorf("Expected the PWM config sent once, got %d", sent)
+	}
+}
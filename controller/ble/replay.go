@@ -0,0 +1,68 @@
+package ble
+
+import (
+	"sort"
+	"time"
+
+	"github.com/theatrus/ledbrick/controller/alert"
+)
+
+// replayPeriph stands in for a fixture during a replay. Nothing is ever
+// written to it.
+type replayPeriph struct {
+	peripheral
+	id string
+}
+
+func (p replayPeriph) ID() string { return p.id }
+
+type replaySample struct {
+	id string
+	Sample
+}
+
+// Replay runs recorded samples, as saved to the history file, through
+// the same fan and temperature checks as live notifications, in time
+// order and with alerts stamped with the recorded times. commanded gives
+// the highest commanded channel percent at a time. Fixture settings
+// come from the fixtures file as usual.
+func Replay(samples map[string][]Sample, commanded func(time.Time) float64) error {
+	fixtures, err := loadFixtures()
+	if err != nil {
+		return err
+	}
+	ble := newBLEChannel(nil, fixtures)
+
+	var all []replaySample
+	periphs := make(map[string]*blePeriph)
+	for id, ss := range samples {
+		for _, s := range ss {
+			all = append(all, replaySample{id, s})
+		}
+		periphs[id] = &blePeriph{gp: replayPeriph{id: id},
+			fanDuty:  -1,
+			derating: 100,
+			history:  &history{},
+			output:   &output{percents: make(map[int]float64)},
+			config:   ble.fixtureConfig(id),
+		}
+	}
+	sort.SliceStable(all, func(i, j int) bool { return all[i].At.Before(all[j].At) })
+
+	var at time.Time
+	alert.SetClock(func() time.Time { return at })
+	defer alert.SetClock(time.Now)
+	for _, s := range all {
+		at = s.At
+		p := periphs[s.id]
+		p.lastUpdate = s.At
+		p.temperature = s.Temperature
+		p.tempSeen = true
+		p.fanReport(s.FanRPM, s.At)
+		p.history.add(s.Sample)
+		p.checkTemperatureTrend(s.At)
+		p.checkFan(commanded(s.At), s.At)
+		p.checkDerating()
+	}
+	return nil
+}
@@ -0,0 +1,40 @@
+package ble
+
+import (
+	"testing"
+	"time"
+
+	"github.com/theatrus/ledbrick/controller/alert"
+)
+
+func TestReplay(t *testing.T) {
+	rec := &recordNotifier{}
+	alert.RegisterSync(rec)
+
+	start := time.Date(2016, 3, 1, 14, 0, 0, 0, time.UTC)
+	var samples []Sample
+	for i := 0; i < 10; i++ {
+		rpm := 1200
+		if i >= 5 {
+			rpm = 0
+		}
+		samples = append(samples, Sample{At: start.Add(time.Duration(i) * time.Minute), Temperature: 35, FanRPM: rpm})
+	}
+	err := Replay(map[string][]Sample{"replay": samples}, func(time.Time) float64 { return 80 })
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var failed []alert.Alert
+	for _, a := range rec.alerts {
+		if a.Source == "replay" && a.Kind == "fan.failed" {
+			failed = append(failed, a)
+		}
+	}
+	if len(failed) != 1 {
+		t.Fatalf("Expected a single fan failure, got %v", failed)
+	}
+	if want := start.Add(5 * time.Minute); !failed[0].At.Equal(want) {
+		t.Errorf("Expected the alert stamped at %s, got %s", want, failed[0].At)
+	}
+}
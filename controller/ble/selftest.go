@@ -0,0 +1,200 @@
+package ble
+
+import (
+	"fmt"
+	"io"
+	"log"
+	"math"
+	"sort"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/theatrus/ledbrick/controller/alert"
+	"github.com/theatrus/ledbrick/controller/clock"
+)
+
+// SelfTestConfig sets how the startup self-test runs.
+type SelfTestConfig struct {
+	// Wait is how long to wait for fixtures to connect, cut short once
+	// every expected fixture and any other seen has
+	Wait time.Duration
+	// Ramp is how long each channel takes to ramp up to Percent and
+	// back down
+	Ramp    time.Duration
+	Percent float64
+	// Step is how often channel values are changed
+	Step time.Duration
+	// Telemetry is how long fixtures have to report their temperature
+	// and fan speed, from the start of the test
+	Telemetry time.Duration
+}
+
+// SelfTestFixture is how one fixture did in the self-test.
+type SelfTestFixture struct {
+	ID          string   `json:"id"`
+	Writes      int      `json:"writes"`
+	Errors      int      `json:"errors"`
+	Temperature bool     `json:"temperature"`
+	Fan         bool     `json:"fan"`
+	Pass        bool     `json:"pass"`
+	Problems    []string `json:"problems"`
+}
+
+// SelfTestReport is the outcome of the self-test.
+type SelfTestReport struct {
+	Start    time.Time         `json:"start"`
+	End      time.Time         `json:"end"`
+	Pass     bool              `json:"pass"`
+	Fixtures []SelfTestFixture `json:"fixtures"`
+}
+
+// Write prints the report as a table.
+func (r SelfTestReport) Write(w io.Writer) {
+	result := "passed"
+	if !r.Pass {
+		result = "FAILED"
+	}
+	fmt.Fprintf(w, "Self-test %s in %s\n", result, r.End.Sub(r.Start))
+	tw := tabwriter.NewWriter(w, 0, 8, 2, ' ', 0)
+	fmt.Fprintln(tw, "FIXTURE\tRESULT\tWRITES\tERRORS\tPROBLEMS")
+	for _, f := range r.Fixtures {
+		result := "pass"
+		if !f.Pass {
+			result = "FAIL"
+		}
+		fmt.Fprintf(tw, "%s\t%s\t%d\t%d\t%s\n", f.ID, result, f.Writes, f.Errors, strings.Join(f.Problems, "; "))
+	}
+	tw.Flush()
+}
+
+// selfTestPeripheral is a fixture which can tell when its telemetry
+// last arrived.
+type selfTestPeripheral interface {
+	BLEPeripheral
+	telemetry() (temperature, fan time.Time, hasFan bool)
+}
+
+func (p *blePeriph) telemetry() (time.Time, time.Time, bool) {
+	return p.tempAt, p.fanAt, p.fanChar != nil
+}
+
+// selfTestWave is the percent a channel is driven to during the
+// self-test: each in turn ramps up to the peak and back, with the rest
+// off, so a dead channel stands out to anyone watching.
+func selfTestWave(elapsed time.Duration, cfg SelfTestConfig, channel int) float64 {
+	start := time.Duration(channel) * cfg.Ramp
+	if elapsed < start || elapsed >= start+cfg.Ramp {
+		return 0
+	}
+	phase := float64(elapsed-start) / float64(cfg.Ramp)
+	return cfg.Percent * (1 - math.Abs(2*phase-1))
+}
+
+// newSelfTestReport works out how each fixture did from the write stats
+// taken at the start and end of the ramps and the peripherals connected
+// at the end. Known fixtures which aren't connected fail.
+func newSelfTestReport(start, end time.Time, known []string, periphs []BLEPeripheral, before, after map[string]WriteStats) SelfTestReport {
+	r := SelfTestReport{Start: start, End: end, Pass: true}
+	connected := make(map[string]bool)
+	for _, p := range periphs {
+		connected[p.ID()] = true
+		f := SelfTestFixture{ID: p.ID(), Problems: []string{}}
+		d := after[p.ID()].Since(before[p.ID()])
+		f.Writes, f.Errors = d.Writes, d.Errors
+		if d.Writes == 0 {
+			f.Problems = append(f.Problems, "no channel writes")
+		}
+		if d.Errors > 0 {
+			f.Problems = append(f.Problems, fmt.Sprintf("%d of %d writes failed", d.Errors, d.Writes))
+		}
+		if sp, ok := p.(selfTestPeripheral); ok {
+			temp, fan, hasFan := sp.telemetry()
+			f.Temperature = !temp.Before(start)
+			f.Fan = !fan.Before(start)
+			if !f.Temperature {
+				f.Problems = append(f.Problems, "no temperature reported")
+			}
+			if hasFan && !f.Fan {
+				f.Problems = append(f.Problems, "no fan speed reported")
+			}
+		}
+		f.Pass = len(f.Problems) == 0
+		r.Fixtures = append(r.Fixtures, f)
+	}
+	for _, id := range known {
+		if !connected[id] {
+			r.Fixtures = append(r.Fixtures, SelfTestFixture{ID: id, Problems: []string{"not connected"}})
+		}
+	}
+	sort.Slice(r.Fixtures, func(i, j int) bool { return r.Fixtures[i].ID < r.Fixtures[j].ID })
+	for _, f := range r.Fixtures {
+		r.Pass = r.Pass && f.Pass
+	}
+	return r
+}
+
+// known returns the fixtures which are expected or have connected.
+func known(b BLEChannel) []string {
+	var ids []string
+	for id := range b.Availability() {
+		ids = append(ids, id)
+	}
+	return ids
+}
+
+// SelfTest waits for fixtures to connect, ramps each channel of every
+// fixture in turn, and checks the writes went through and temperature
+// and fan telemetry arrived. Each fixture which fails raises an alert.
+// Nothing else should be setting channels while it runs, and it leaves
+// every channel off for the scheduler to take over.
+func SelfTest(b BLEChannel, cfg SelfTestConfig, c clock.Clock) SelfTestReport {
+	start := c.Now()
+	ticker := c.NewTicker(cfg.Step)
+	defer ticker.Stop()
+
+	log.Printf("Self-test waiting up to %s for fixtures to connect", cfg.Wait)
+	for now := range ticker.C() {
+		connected := make(map[string]bool)
+		for _, p := range b.Perhipherals() {
+			connected[p.ID()] = true
+		}
+		waiting := len(connected) == 0
+		for _, id := range known(b) {
+			waiting = waiting || !connected[id]
+		}
+		if !waiting || now.Sub(start) >= cfg.Wait {
+			break
+		}
+	}
+
+	log.Printf("Self-test ramping each channel to %.0f%%", cfg.Percent)
+	end := measure(b)
+	before := b.WriteStats()
+	rampStart := c.Now()
+	for now := range ticker.C() {
+		elapsed := now.Sub(rampStart)
+		for channel := 0; channel <= 7; channel++ {
+			b.SetChannelImmediate(channel, selfTestWave(elapsed, cfg, channel))
+		}
+		if elapsed >= 8*cfg.Ramp {
+			break
+		}
+	}
+	after := b.WriteStats()
+	end()
+
+	var r SelfTestReport
+	for now := range ticker.C() {
+		r = newSelfTestReport(start, now, known(b), b.Perhipherals(), before, after)
+		if r.Pass || now.Sub(start) >= cfg.Telemetry {
+			break
+		}
+	}
+	for _, f := range r.Fixtures {
+		if !f.Pass {
+			alert.Raise(alert.Warning, f.ID, "selftest", "self-test failed: %s", strings.Join(f.Problems, "; "))
+		}
+	}
+	return r
+}
@@ -0,0 +1,73 @@
+package ble
+
+import (
+	"errors"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestSelfTestWave(t *testing.T) {
+	cfg := SelfTestConfig{Ramp: 10 * time.Second, Percent: 40}
+	for _, c := range []struct {
+		elapsed time.Duration
+		channel int
+		want    float64
+	}{
+		{0, 0, 0},
+		{5 * time.Second, 0, 40},
+		{5 * time.Second, 1, 0},
+		{15 * time.Second, 1, 40},
+		{15 * time.Second, 0, 0},
+		{80 * time.Second, 7, 0},
+	} {
+		if got := selfTestWave(c.elapsed, cfg, c.channel); got != c.want {
+			t.Errorf("selfTestWave(%s, channel %d) = %.1f, expected %.1f", c.elapsed, c.channel, got, c.want)
+		}
+	}
+}
+
+func TestSelfTestReport(t *testing.T) {
+	ble, _ := newTestChannel()
+	start := ble.clock.Now()
+	bad := newFakeFixture("bad")
+	bad.writeErr = errors.New("write failed")
+	connect(ble, bad)
+	good := newFakeFixture("good")
+	connect(ble, good)
+	quiet := newFakeFixture("quiet")
+	connect(ble, quiet)
+	ble.fixtures["missing"] = FixtureConfig{Expected: true}
+
+	before := ble.WriteStats()
+	ble.writeLedState()
+	for _, f := range []*fakeFixture{bad, good} {
+		f.send(pwmTempChar, []byte{30})
+		f.send(pwmFanChar, []byte{0xe8, 0x03})
+	}
+	deadline := time.Now().Add(time.Second)
+	for {
+		ble.lock.Lock()
+		seen := ble.connectedPeriph["good"].fanSeen && ble.connectedPeriph["bad"].fanSeen
+		ble.lock.Unlock()
+		if seen {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatal("Expected notifications to be applied")
+		}
+		time.Sleep(time.Millisecond)
+	}
+
+	r := newSelfTestReport(start, start, known(ble), ble.Perhipherals(), before, ble.WriteStats())
+	if r.Pass || len(r.Fixtures) != 4 {
+		t.Fatalf("Expected a failed test of 4 fixtures, got %+v", r)
+	}
+	want := map[string]string{"bad": "writes failed", "good": "", "missing": "not connected", "quiet": "no temperature"}
+	for _, f := range r.Fixtures {
+		problems := strings.Join(f.Problems, "; ")
+		if f.Pass != (want[f.ID] == "") || !strings.Contains(problems, want[f.ID]) {
+			t.Errorf("%s: expected %q, got pass %v with %q", f.ID, want[f.ID], f.Pass, problems)
+		}
+	}
+}
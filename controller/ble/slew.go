@@ -0,0 +1,95 @@
+package ble
+
+import (
+	"flag"
+	"time"
+)
+
+var slewRate float64
+
+func init() {
+	flag.Float64Var(&slewRate, "ble.slew", 2,
+		"Maximum change in percent per second of any written channel, 0 to disable")
+}
+
+// output is the last value written to each channel of a fixture. It is
+// kept across reconnects so a fixture coming back resumes from where it
+// was rather than jumping.
+type output struct {
+	percents map[int]float64
+	at       time.Time
+
+	watts    float64
+	energy   energy
+	heatSoak heatSoak
+}
+
+// slew bounds the move from the last written percent towards the wanted
+// percent given the time elapsed since the last write.
+func slew(last, want float64, elapsed time.Duration) float64 {
+	if slewRate <= 0 {
+		return want
+	}
+	step := slewRate * elapsed.Seconds()
+	switch {
+	case want > last+step:
+		return last + step
+	case want < last-step:
+		return last - step
+	}
+	return want
+}
+
+// How many write intervals at most are charged to the energy totals at
+// the draw of the last write. A longer gap means writes stalled, and
+// what the fixture drew meanwhile isn't known.
+const energyGapWrites = 5
+
+// record charges the draw since the last write to the energy totals and
+// updates the power estimate once all channels have been written.
+func (o *output) record(watts Watts, now time.Time) {
+	elapsed := now.Sub(o.at)
+	if limit := energyGapWrites * refresh; elapsed > limit {
+		elapsed = limit
+	}
+	o.energy.add(o.watts, elapsed, now)
+	o.watts = watts.power(o.percents)
+	o.at = now
+}
+
+// next returns the percent to write to a channel now, and records it.
+// Immediate writes skip the limiter for effects which need it.
+func (o *output) next(channel int, want float64, immediate bool, now time.Time) float64 {
+	v := want
+	if !immediate {
+		v = slew(o.percents[channel], want, now.Sub(o.at))
+	}
+	o.percents[channel] = v
+	return v
+}
+
+// disconnected is called when the fixture goes away. Nothing it draws
+// until it is next written to is known, so none of it is charged.
+func (o *output) disconnected() {
+	o.watts = 0
+}
+
+// resume is called when the fixture connects. Output kept from before
+// a reconnect or restored from before a restart slews from when the
+// fixture comes back, not from the last write, so the time it was away
+// doesn't count towards the limit.
+func (o *output) resume(now time.Time) {
+	o.at = now
+}
+
+// outputFor returns the output state for a peripheral ID, creating it
+// if needed. The caller must hold the channel lock.
+func (ble *bleChannel) outputFor(id string) *output {
+	o, ok := ble.outputs[id]
+	if !ok {
+		// Fixtures come up dark, so ramp up from zero
+		o = &output{percents: make(map[int]float64), at: ble.clock.Now()}
+		ble.outputs[id] = o
+	}
+	return o
+}
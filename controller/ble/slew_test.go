@@ -0,0 +1,57 @@
+package ble
+
+import (
+	"testing"
+	"time"
+
+	"github.com/theatrus/ledbrick/controller/clock"
+)
+
+func TestSlew(t *testing.T) {
+	defer func(r float64) { slewRate = r }(slewRate)
+	slewRate = 2
+	if v := slew(0, 100, 5*time.Second); v != 10 {
+		t.Errorf("Expected rise to be limited to 10, got %f", v)
+	}
+	if v := slew(50, 0, time.Second); v != 48 {
+		t.Errorf("Expected fall to be limited to 48, got %f", v)
+	}
+	if v := slew(50, 51, time.Second); v != 51 {
+		t.Errorf("Expected small change to pass, got %f", v)
+	}
+}
+
+func TestOutputImmediate(t *testing.T) {
+	defer func(r float64) { slewRate = r }(slewRate)
+	slewRate = 2
+	now := time.Now()
+	o := &output{percents: make(map[int]float64), at: now}
+	if v := o.next(0, 100, true, now.Add(time.Second)); v != 100 {
+		t.Errorf("Expected immediate write to pass, got %f", v)
+	}
+	if v := o.next(1, 100, false, now.Add(time.Second)); v != 2 {
+		t.Errorf("Expected limited write, got %f", v)
+	}
+}
+
+func TestSlewAfterReconnect(t *testing.T) {
+	defer func(r float64) { slewRate = r }(slewRate)
+	slewRate = 2
+	ble, _ := newTestChannel()
+	c := clock.NewFake(time.Date(2016, 1, 1, 0, 0, 0, 0, time.UTC))
+	ble.clock = c
+	f := newFakeFixture("f1")
+	connect(ble, f)
+	ble.writeLedState()
+
+	// The schedule moves on while the fixture is away
+	ble.onPeriphDisconnected(f, nil)
+	ble.SetChannel(0, 100)
+	c.Advance(time.Hour)
+	connect(ble, f)
+	c.Advance(time.Second)
+	ble.writeLedState()
+	if v := lastWrite(f, 0); v != 5 {
+		t.Errorf("Expected the first write after reconnecting to slew to 2%%, got %d", v)
+	}
+}
@@ -0,0 +1,125 @@
+package ble
+
+import (
+	"fmt"
+	"io"
+	"log"
+	"math"
+	"sort"
+	"text/tabwriter"
+	"time"
+
+	"github.com/theatrus/ledbrick/controller/clock"
+)
+
+// SoakConfig sets how a soak test drives the fixtures.
+type SoakConfig struct {
+	// Duration is how long to run for
+	Duration time.Duration
+	// Step is how often channel values are changed
+	Step time.Duration
+	// Period is how long each channel takes to sweep 0-100-0%
+	Period time.Duration
+	// Report is how often progress is logged, 0 for never
+	Report time.Duration
+}
+
+// SoakFixture is how writes to one fixture went during a soak test.
+type SoakFixture struct {
+	ID        string  `json:"id"`
+	Writes    int     `json:"writes"`
+	Errors    int     `json:"errors"`
+	ErrorRate float64 `json:"error_rate"`
+	MeanMs    float64 `json:"mean_ms"`
+	P99Ms     float64 `json:"p99_ms"`
+	MaxMs     float64 `json:"max_ms"`
+}
+
+// SoakReport is the outcome of a soak test.
+type SoakReport struct {
+	Start       time.Time     `json:"start"`
+	End         time.Time     `json:"end"`
+	Disconnects int           `json:"disconnects"`
+	Fixtures    []SoakFixture `json:"fixtures"`
+}
+
+// Write prints the report as a table.
+func (r SoakReport) Write(w io.Writer) {
+	fmt.Fprintf(w, "Soak test from %s for %s, %d disconnects\n",
+		r.Start.Format(time.RFC3339), r.End.Sub(r.Start), r.Disconnects)
+	tw := tabwriter.NewWriter(w, 0, 8, 2, ' ', 0)
+	fmt.Fprintln(tw, "FIXTURE\tWRITES\tERRORS\tERROR RATE\tMEAN\tP99\tMAX")
+	for _, f := range r.Fixtures {
+		fmt.Fprintf(tw, "%s\t%d\t%d\t%.3f%%\t%.1fms\t%.1fms\t%.1fms\n", f.ID, f.Writes, f.Errors,
+			f.ErrorRate*100, f.MeanMs, f.P99Ms, f.MaxMs)
+	}
+	tw.Flush()
+}
+
+// soakWave is the percent a channel is driven to at a point in a soak
+// test: a triangle wave, with the channels spread evenly across its
+// period so fixture power stays roughly level.
+func soakWave(elapsed, period time.Duration, channel int) float64 {
+	phase := math.Mod(float64(elapsed)/float64(period)+float64(channel)/8, 1)
+	return 100 * (1 - math.Abs(2*phase-1))
+}
+
+// newSoakReport works out a report from the write stats and disconnect
+// count taken at the start and end of a soak test.
+func newSoakReport(start, end time.Time, before, after map[string]WriteStats, disconnects int) SoakReport {
+	r := SoakReport{Start: start, End: end, Disconnects: disconnects}
+	ms := func(d time.Duration) float64 { return float64(d) / float64(time.Millisecond) }
+	for id, s := range after {
+		d := s.Since(before[id])
+		if d.Writes == 0 {
+			continue
+		}
+		r.Fixtures = append(r.Fixtures, SoakFixture{ID: id,
+			Writes:    d.Writes,
+			Errors:    d.Errors,
+			ErrorRate: d.ErrorRate(),
+			MeanMs:    ms(d.Mean()),
+			P99Ms:     ms(d.Percentile(0.99)),
+			MaxMs:     ms(d.Max),
+		})
+	}
+	sort.Slice(r.Fixtures, func(i, j int) bool { return r.Fixtures[i].ID < r.Fixtures[j].ID })
+	return r
+}
+
+// Soak cycles every channel of every connected fixture for the
+// configured duration, to shake out link problems under sustained load,
+// and reports how the writes went. Writes are acknowledged while it
+// runs, where the channel can, so their latency is the round trip.
+// Nothing else should be setting channels while it runs.
+func Soak(b BLEChannel, cfg SoakConfig, c clock.Clock) SoakReport {
+	defer measure(b)()
+	before := b.WriteStats()
+	disconnects := b.DisconnectCount()
+	start := c.Now()
+	lastReport := start
+	log.Printf("Soak test running for %s, stepping every %s", cfg.Duration, cfg.Step)
+
+	ticker := c.NewTicker(cfg.Step)
+	defer ticker.Stop()
+	for now := range ticker.C() {
+		elapsed := now.Sub(start)
+		if elapsed >= cfg.Duration {
+			break
+		}
+		for channel := 0; channel <= 7; channel++ {
+			if err := b.SetChannelImmediate(channel, soakWave(elapsed, cfg.Period, channel)); err != nil {
+				log.Printf("Soak test failed to set channel %d: %v", channel, err)
+			}
+		}
+		if cfg.Report > 0 && now.Sub(lastReport) >= cfg.Report {
+			r := newSoakReport(start, now, before, b.WriteStats(), b.DisconnectCount()-disconnects)
+			for _, f := range r.Fixtures {
+				log.Printf("Soak %s: %d writes, %d errors, p99 %.1fms", f.ID, f.Writes, f.Errors, f.P99Ms)
+			}
+			log.Printf("Soak test %s in, %d disconnects", elapsed, r.Disconnects)
+			lastReport = now
+		}
+	}
+	return newSoakReport(start, c.Now(), before, b.WriteStats(), b.DisconnectCount()-disconnects)
+}
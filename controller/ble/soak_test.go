@@ -0,0 +1,103 @@
+package ble
+
+import (
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestSoakWave(t *testing.T) {
+	period := 8 * time.Minute
+	for _, c := range []struct {
+		elapsed time.Duration
+		channel int
+		want    float64
+	}{
+		{0, 0, 0},
+		{2 * time.Minute, 0, 50},
+		{4 * time.Minute, 0, 100},
+		{6 * time.Minute, 0, 50},
+		{8 * time.Minute, 0, 0},
+		{0, 4, 100},
+		{0, 2, 50},
+	} {
+		if got := soakWave(c.elapsed, period, c.channel); got != c.want {
+			t.Errorf("soakWave(%s, channel %d) = %.1f, expected %.1f", c.elapsed, c.channel, got, c.want)
+		}
+	}
+}
+
+func TestWriteStats(t *testing.T) {
+	var s WriteStats
+	for i := 0; i < 98; i++ {
+		s.record(3*time.Millisecond, nil)
+	}
+	s.record(40*time.Millisecond, errors.New("write failed"))
+	s.record(3*time.Second, nil)
+	if s.Writes != 100 || s.Errors != 1 {
+		t.Errorf("Expected 100 writes and 1 error, got %d and %d", s.Writes, s.Errors)
+	}
+	if p := s.Percentile(0.5); p != 5*time.Millisecond {
+		t.Errorf("Expected p50 of 5ms, got %s", p)
+	}
+	if p := s.Percentile(0.99); p != 50*time.Millisecond {
+		t.Errorf("Expected p99 of 50ms, got %s", p)
+	}
+	if p := s.Percentile(1); p != 3*time.Second {
+		t.Errorf("Expected p100 to be the max, got %s", p)
+	}
+
+	before := s
+	before.Buckets = append([]int(nil), s.Buckets...)
+	s.record(time.Millisecond, nil)
+	d := s.Since(before)
+	if d.Writes != 1 || d.Errors != 0 || d.Percentile(1) != time.Millisecond {
+		t.Errorf("Expected one 1ms write since, got %+v", d)
+	}
+}
+
+func TestSoakReport(t *testing.T) {
+	ble, _ := newTestChannel()
+	bad := newFakeFixture("bad")
+	bad.writeErr = errors.New("write failed")
+	connect(ble, bad)
+	connect(ble, newFakeFixture("good"))
+
+	before := ble.WriteStats()
+	if err := ble.writeLedState(); err != nil {
+		t.Fatal(err)
+	}
+	start := time.Date(2016, 1, 1, 0, 0, 0, 0, time.UTC)
+	r := newSoakReport(start, start.Add(time.Hour), before, ble.WriteStats(), 2)
+	if len(r.Fixtures) != 2 || r.Disconnects != 2 {
+		t.Fatalf("Expected 2 fixtures and 2 disconnects, got %+v", r)
+	}
+	if f := r.Fixtures[0]; f.ID != "bad" || f.Writes != 8 || f.ErrorRate != 1 {
+		t.Errorf("Expected 8 failed writes to bad, got %+v", f)
+	}
+	if f := r.Fixtures[1]; f.ID != "good" || f.Writes != 8 || f.Errors != 0 {
+		t.Errorf("Expected 8 good writes to good, got %+v", f)
+	}
+}
+
+func TestMeasureWrites(t *testing.T) {
+	ble, _ := newTestChannel()
+	f := newFakeFixture("f")
+	connect(ble, f)
+	acked := func() int {
+		f.lock.Lock()
+		defer f.lock.Unlock()
+		return f.acked
+	}
+
+	end := measure(ble)
+	ble.writeLedState()
+	if n := acked(); n != 8 {
+		t.Errorf("Expected the 8 channel writes acknowledged while measuring, got %d", n)
+	}
+	end()
+	ble.writeLedState()
+	if n := acked(); n != 8 {
+		t.Errorf("Expected channel writes unacknowledged after measuring, got %d more", n-8)
+	}
+}
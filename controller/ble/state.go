@@ -0,0 +1,153 @@
+package ble
+
+import (
+	"encoding/json"
+	"flag"
+	"io/ioutil"
+	"log"
+	"os"
+	"time"
+
+	"github.com/theatrus/ledbrick/controller/atomicfile"
+)
+
+var stateFile string
+var stateInterval time.Duration
+var stateHold time.Duration
+
+func init() {
+	flag.StringVar(&stateFile, "ble.state.file", "",
+		"File to persist the last output of every fixture and active overrides to, restored on start")
+	flag.DurationVar(&stateInterval, "ble.state.interval", time.Minute,
+		"How often to persist the output state")
+	flag.DurationVar(&stateHold, "ble.state.hold", 5*time.Minute,
+		"How long restored limits, scales and effect suspensions last unless set again")
+}
+
+// savedState is the output state written to the state file.
+type savedState struct {
+	At        time.Time                  `json:"at"`
+	Outputs   map[string]map[int]float64 `json:"outputs"`
+	Limits    map[string]float64         `json:"limits"`
+	Scales    map[string]float64         `json:"scales"`
+	Suspended []string                   `json:"suspended"`
+}
+
+// restored tracks the overrides read back from the state file which
+// nothing has set again since, so they can be dropped after the hold.
+type restored struct {
+	until     time.Time
+	limits    map[string]bool
+	scales    map[string]bool
+	suspended map[string]bool
+}
+
+// loadState restores the outputs and overrides saved before a restart.
+// Fixtures reconnecting start from their saved output and fade to the
+// schedule through the slew limiter rather than jumping.
+func (ble *bleChannel) loadState(now time.Time) {
+	if stateFile == "" {
+		return
+	}
+	data, err := ioutil.ReadFile(stateFile)
+	if err != nil {
+		if !os.IsNotExist(err) {
+			log.Printf("Not loading output state: %v", err)
+		}
+		return
+	}
+	var saved savedState
+	if err := json.Unmarshal(data, &saved); err != nil {
+		log.Printf("Bad output state file %s: %v", stateFile, err)
+		return
+	}
+
+	ble.lock.Lock()
+	defer ble.lock.Unlock()
+	for id, percents := range saved.Outputs {
+		o := ble.outputFor(id)
+		for channel, v := range percents {
+			o.percents[channel] = v
+		}
+	}
+	ble.restored = restored{until: now.Add(stateHold),
+		limits:    make(map[string]bool),
+		scales:    make(map[string]bool),
+		suspended: make(map[string]bool),
+	}
+	for name, l := range saved.Limits {
+		ble.limits[name] = l
+		ble.restored.limits[name] = true
+	}
+	for name, f := range saved.Scales {
+		ble.scales[name] = f
+		ble.restored.scales[name] = true
+	}
+	for _, name := range saved.Suspended {
+		ble.suspended[name] = true
+		ble.restored.suspended[name] = true
+	}
+	log.Printf("Restored output state saved at %s for %d fixtures", saved.At.Format(time.RFC3339), len(saved.Outputs))
+}
+
+func (ble *bleChannel) saveState(now time.Time) {
+	if stateFile == "" {
+		return
+	}
+
+	ble.lock.Lock()
+	saved := savedState{At: now,
+		Outputs: make(map[string]map[int]float64),
+		Limits:  make(map[string]float64),
+		Scales:  make(map[string]float64),
+	}
+	for id, o := range ble.outputs {
+		percents := make(map[int]float64)
+		for channel, v := range o.percents {
+			percents[channel] = v
+		}
+		saved.Outputs[id] = percents
+	}
+	for name, l := range ble.limits {
+		saved.Limits[name] = l
+	}
+	for name, f := range ble.scales {
+		saved.Scales[name] = f
+	}
+	for name := range ble.suspended {
+		saved.Suspended = append(saved.Suspended, name)
+	}
+	ble.lock.Unlock()
+
+	data, err := json.Marshal(saved)
+	if err != nil {
+		log.Printf("Failed to encode output state: %v", err)
+		return
+	}
+	if err := atomicfile.WriteFile(stateFile, data, 0644); err != nil {
+		log.Printf("Failed to save output state: %v", err)
+	}
+}
+
+// expireRestored drops restored overrides nothing has set again once
+// the hold is over, so one whose owner has since gone away doesn't
+// stick. The caller must hold the channel lock.
+func (ble *bleChannel) expireRestored(now time.Time) {
+	r := &ble.restored
+	if r.until.IsZero() || now.Before(r.until) {
+		return
+	}
+	for name := range r.limits {
+		log.Printf("Restored output limit from %s expired", name)
+		delete(ble.limits, name)
+	}
+	for name := range r.scales {
+		log.Printf("Restored output scale from %s expired", name)
+		delete(ble.scales, name)
+	}
+	for name := range r.suspended {
+		log.Printf("Restored effect suspension from %s expired", name)
+		delete(ble.suspended, name)
+	}
+	*r = restored{}
+}
@@ -0,0 +1,65 @@
+package ble
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestSaveAndLoadState(t *testing.T) {
+	dir, err := ioutil.TempDir("", "ledbrick")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	defer func(f string) { stateFile = f }(stateFile)
+	stateFile = filepath.Join(dir, "state.json")
+
+	now := time.Date(2016, 1, 1, 12, 0, 0, 0, time.UTC)
+	before, _ := newTestChannel()
+	before.outputFor("a").percents[3] = 42
+	before.SetLimit("ups", 20)
+	before.SetScale("ambient", 0.5)
+	before.SuspendEffects("feed")
+	before.saveState(now)
+
+	after, _ := newTestChannel()
+	after.loadState(now)
+	o := after.outputFor("a")
+	if o.percents[3] != 42 {
+		t.Errorf("Expected the output restored, got %v", o.percents)
+	}
+	if after.Limits()["ups"] != 20 || after.Scales()["ambient"] != 0.5 || !after.EffectsSuspended() {
+		t.Error("Expected the overrides restored")
+	}
+
+	// Fixtures slew from when they come back, not from the save
+	back := now.Add(time.Hour)
+	o.resume(back)
+	if !o.at.Equal(back) {
+		t.Error("Expected resuming to restart the slew")
+	}
+	if v := o.next(3, 0, false, back.Add(time.Second)); v != 40 {
+		t.Errorf("Expected to fade from the restored output, got %.1f", v)
+	}
+
+	// Overrides set again are kept, the rest expire after the hold
+	after.SetLimit("ups", 20)
+	after.lock.Lock()
+	after.expireRestored(now.Add(stateHold - time.Second))
+	after.lock.Unlock()
+	if len(after.Scales()) != 1 {
+		t.Error("Expected restored overrides to last for the hold")
+	}
+	after.lock.Lock()
+	after.expireRestored(now.Add(stateHold))
+	after.lock.Unlock()
+	if after.Limits()["ups"] != 20 {
+		t.Error("Expected a limit set again to be kept")
+	}
+	if len(after.Scales()) != 0 || after.EffectsSuspended() {
+		t.Error("Expected restored overrides to expire")
+	}
+}
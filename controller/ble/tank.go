@@ -0,0 +1,433 @@
+package ble
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"math"
+	"sort"
+	"time"
+
+	"github.com/theatrus/ledbrick/controller/events"
+)
+
+// tank is the output state of a group of fixtures lighting one system:
+// the channel settings its schedule drives, and the limits and scales
+// which apply only to it. Fixtures name their tank in the fixtures file,
+// and those which don't belong to the controller's own default tank.
+type tank struct {
+	channelSetting map[int]float64
+	immediate      map[int]bool
+	limits         map[string]float64
+	// forced are the limits which cut output at once, rather than
+	// through the slew limiter, and forcing is set while one is in
+	// force on the tank or the whole controller
+	forced  map[string]bool
+	forcing bool
+	// suspended are the suspensions of effects on the tank alone
+	suspended map[string]bool
+	scales    map[string]float64
+	wants     map[int]float64
+	exposure  exposure
+	// held are the settings kept while degraded
+	held map[int]float64
+	// program is the table uploaded to the tank's fixtures, and
+	// programVersion counts changes to it
+	program        Program
+	programVersion int
+	// fanOverride is the least fan duty in percent its fixtures with a
+	// fan curve run at, for a fan scheduled as an accessory
+	fanOverride float64
+}
+
+func newTank() *tank {
+	return &tank{channelSetting: make(map[int]float64),
+		immediate: make(map[int]bool),
+		limits:    make(map[string]float64),
+		forced:    make(map[string]bool),
+		suspended: make(map[string]bool),
+		scales:    make(map[string]float64),
+	}
+}
+
+// tankFor returns a named tank, creating it if needed, or the default
+// tank for "". The caller must hold the channel lock.
+func (ble *bleChannel) tankFor(name string) *tank {
+	if name == "" {
+		return ble.tank
+	}
+	t, ok := ble.tanks[name]
+	if !ok {
+		t = newTank()
+		ble.tanks[name] = t
+	}
+	return t
+}
+
+// hold keeps the current settings while degraded, or releases them.
+func (t *tank) hold(held bool) {
+	t.held = nil
+	if held {
+		t.held = make(map[int]float64)
+		for channel, percent := range t.channelSetting {
+			t.held[channel] = percent
+		}
+	}
+}
+
+// setting returns the percent a channel should be driven to, which is
+// the held value while degraded.
+func (t *tank) setting(channel int) float64 {
+	if t.held != nil {
+		return t.held[channel]
+	}
+	return t.channelSetting[channel]
+}
+
+// update works out the percent for each channel common to the tank's
+// fixtures, with the limits and scales of the tank and of the whole
+// controller in root. It returns the highest setting, which drives the
+// fans.
+func (t *tank) update(root *tank, now time.Time) float64 {
+	output := 0.0
+	for channel := range t.channelSetting {
+		if v := t.setting(channel); v > output {
+			output = v
+		}
+	}
+
+	tanks := []*tank{root}
+	if t != root {
+		tanks = append(tanks, t)
+	}
+	limit := 100.0
+	scale := 1.0
+	t.forcing = false
+	for _, tk := range tanks {
+		if len(tk.forced) > 0 {
+			t.forcing = true
+		}
+		for _, l := range tk.limits {
+			if l < limit {
+				limit = l
+			}
+		}
+		for _, f := range tk.scales {
+			scale *= f
+		}
+	}
+
+	t.exposure.advance(now)
+	t.wants = make(map[int]float64)
+	for channel := 0; channel <= 7; channel++ {
+		t.wants[channel] = t.exposure.limit(channel, duty(t.setting(channel), scale, limit))
+	}
+	return output
+}
+
+// duty returns the percent a channel setting is driven to with a scale
+// and limit, before the exposure budget.
+func duty(setting, scale, limit float64) float64 {
+	return math.Min(linear(math.Min(setting*scale, 100)), limit)
+}
+
+// Output returns the percent a channel setting would be driven to with
+// the given limits and scales, for a channel which has spent above
+// over the exposure threshold that day.
+func Output(setting float64, limits, scales map[string]float64, above time.Duration) float64 {
+	limit := 100.0
+	for _, l := range limits {
+		limit = math.Min(limit, l)
+	}
+	scale := 1.0
+	for _, f := range scales {
+		scale *= f
+	}
+	v := duty(setting, scale, limit)
+	if spent(v, above) {
+		return exposureThreshold
+	}
+	return v
+}
+
+// Tanks returns the names of the tanks fixtures have been assigned to.
+func (ble *bleChannel) Tanks() []string {
+	ble.lock.Lock()
+	defer ble.lock.Unlock()
+	seen := make(map[string]bool)
+	for _, c := range ble.fixtures {
+		if c.Tank != "" {
+			seen[c.Tank] = true
+		}
+	}
+	for name := range ble.tanks {
+		seen[name] = true
+	}
+	var names []string
+	for name := range seen {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// Tank returns a channel scoped to a named tank. Settings, limits and
+// scales made through it only affect the tank's fixtures, and it only
+// lists those fixtures. Limits and scales on the controller itself
+// still apply, as they come from things the tanks share such as the
+// UPS.
+func (ble *bleChannel) Tank(name string) BLEChannel {
+	if name == "" {
+		return ble
+	}
+	return &tankChannel{bleChannel: ble, name: name}
+}
+
+type tankChannel struct {
+	*bleChannel
+	name string
+}
+
+func (tc *tankChannel) Perhipherals() []BLEPeripheral {
+	tc.lock.Lock()
+	defer tc.lock.Unlock()
+	p := make([]BLEPeripheral, 0)
+	for _, periph := range tc.connectedPeriph {
+		if tc.inTank(periph.ID()) {
+			p = append(p, periph)
+		}
+	}
+	return p
+}
+
+func (tc *tankChannel) Power(percents map[int]float64) float64 {
+	return tc.power(tc.name, percents)
+}
+
+func (tc *tankChannel) SetChannel(channel int, percent float64) error {
+	if percent < 0 || percent > 100 {
+		return errors.New("Out of range percent (0-100)")
+	}
+	tc.lock.Lock()
+	defer tc.lock.Unlock()
+	tc.tankFor(tc.name).channelSetting[channel] = percent
+	return nil
+}
+
+func (tc *tankChannel) SetChannelImmediate(channel int, percent float64) error {
+	if percent < 0 || percent > 100 {
+		return errors.New("Out of range percent (0-100)")
+	}
+	tc.lock.Lock()
+	defer tc.lock.Unlock()
+	t := tc.tankFor(tc.name)
+	t.channelSetting[channel] = percent
+	t.immediate[channel] = true
+	return nil
+}
+
+func (tc *tankChannel) SetFanOverride(percent float64) error {
+	if percent < 0 || percent > 100 {
+		return errors.New("Out of range percent (0-100)")
+	}
+	tc.lock.Lock()
+	defer tc.lock.Unlock()
+	tc.tankFor(tc.name).fanOverride = percent
+	return nil
+}
+
+func (tc *tankChannel) SetLimit(name string, percent float64) error {
+	if percent < 0 || percent > 100 {
+		return errors.New("Out of range percent (0-100)")
+	}
+	tc.lock.Lock()
+	defer tc.lock.Unlock()
+	t := tc.tankFor(tc.name)
+	if _, ok := t.limits[name]; !ok {
+		log.Printf("Output of %s limited to %.1f%% by %s", tc.name, percent, name)
+	}
+	t.limits[name] = percent
+	return nil
+}
+
+func (tc *tankChannel) ForceLimit(name string, percent float64) error {
+	if err := tc.SetLimit(name, percent); err != nil {
+		return err
+	}
+	tc.lock.Lock()
+	defer tc.lock.Unlock()
+	tc.tankFor(tc.name).forced[name] = true
+	return nil
+}
+
+func (tc *tankChannel) ClearLimit(name string) {
+	tc.lock.Lock()
+	defer tc.lock.Unlock()
+	t := tc.tankFor(tc.name)
+	if _, ok := t.limits[name]; ok {
+		log.Printf("Output limit on %s from %s cleared", tc.name, name)
+	}
+	delete(t.limits, name)
+	delete(t.forced, name)
+}
+
+func (tc *tankChannel) Limits() map[string]float64 {
+	tc.lock.Lock()
+	defer tc.lock.Unlock()
+	limits := make(map[string]float64)
+	for name, l := range tc.tankFor(tc.name).limits {
+		limits[name] = l
+	}
+	return limits
+}
+
+func (tc *tankChannel) SetScale(name string, factor float64) error {
+	if factor < 0 || factor > 2 {
+		return errors.New("Out of range scale (0-2)")
+	}
+	tc.lock.Lock()
+	defer tc.lock.Unlock()
+	t := tc.tankFor(tc.name)
+	if _, ok := t.scales[name]; !ok {
+		log.Printf("Output of %s scaled by %.2f from %s", tc.name, factor, name)
+	}
+	t.scales[name] = factor
+	return nil
+}
+
+func (tc *tankChannel) ClearScale(name string) {
+	tc.lock.Lock()
+	defer tc.lock.Unlock()
+	t := tc.tankFor(tc.name)
+	if _, ok := t.scales[name]; ok {
+		log.Printf("Output scale on %s from %s cleared", tc.name, name)
+	}
+	delete(t.scales, name)
+}
+
+func (tc *tankChannel) Scales() map[string]float64 {
+	tc.lock.Lock()
+	defer tc.lock.Unlock()
+	scales := make(map[string]float64)
+	for name, f := range tc.tankFor(tc.name).scales {
+		scales[name] = f
+	}
+	return scales
+}
+
+func (tc *tankChannel) Exposure() map[int]time.Duration {
+	tc.lock.Lock()
+	defer tc.lock.Unlock()
+	above := make(map[int]time.Duration)
+	for channel, d := range tc.tankFor(tc.name).exposure.above {
+		above[channel] = d
+	}
+	return above
+}
+
+func (tc *tankChannel) Channels() map[int]float64 {
+	tc.lock.Lock()
+	defer tc.lock.Unlock()
+	channels := make(map[int]float64)
+	for channel, v := range tc.tankFor(tc.name).wants {
+		channels[channel] = v
+	}
+	return channels
+}
+
+// inTank reports whether a fixture belongs to the tank. The caller must
+// hold the channel lock.
+func (tc *tankChannel) inTank(id string) bool {
+	return tc.fixtureConfig(id).Tank == tc.name
+}
+
+func (tc *tankChannel) Availability() map[string]Availability {
+	all := tc.bleChannel.Availability()
+	tc.lock.Lock()
+	defer tc.lock.Unlock()
+	for id := range all {
+		if !tc.inTank(id) {
+			delete(all, id)
+		}
+	}
+	return all
+}
+
+func (tc *tankChannel) WriteStats() map[string]WriteStats {
+	all := tc.bleChannel.WriteStats()
+	tc.lock.Lock()
+	defer tc.lock.Unlock()
+	for id := range all {
+		if !tc.inTank(id) {
+			delete(all, id)
+		}
+	}
+	return all
+}
+
+func (tc *tankChannel) SuspendEffects(name string) {
+	tc.lock.Lock()
+	defer tc.lock.Unlock()
+	t := tc.tankFor(tc.name)
+	if !t.suspended[name] {
+		log.Printf("Effects on %s suspended by %s", tc.name, name)
+		events.Publish(events.Effects, events.EffectsData{By: name, Suspended: true, Tank: tc.name})
+	}
+	t.suspended[name] = true
+}
+
+func (tc *tankChannel) ResumeEffects(name string) {
+	tc.lock.Lock()
+	defer tc.lock.Unlock()
+	t := tc.tankFor(tc.name)
+	if t.suspended[name] {
+		log.Printf("Effects on %s resumed by %s", tc.name, name)
+		events.Publish(events.Effects, events.EffectsData{By: name, Tank: tc.name})
+	}
+	delete(t.suspended, name)
+}
+
+func (tc *tankChannel) EffectsSuspended() bool {
+	tc.lock.Lock()
+	defer tc.lock.Unlock()
+	return tc.effectsSuspended() || len(tc.tankFor(tc.name).suspended) > 0
+}
+
+// notInTank is the error for a fixture outside the tank.
+func (tc *tankChannel) notInTank(id string) error {
+	return fmt.Errorf("fixture %s is not in tank %s", id, tc.name)
+}
+
+func (tc *tankChannel) SetName(id, name string) error {
+	tc.lock.Lock()
+	in := tc.inTank(id)
+	tc.lock.Unlock()
+	if !in {
+		return tc.notInTank(id)
+	}
+	return tc.bleChannel.SetName(id, name)
+}
+
+func (tc *tankChannel) BurnIn(id string, percent float64) error {
+	tc.lock.Lock()
+	in := tc.inTank(id)
+	tc.lock.Unlock()
+	if !in {
+		return tc.notInTank(id)
+	}
+	return tc.bleChannel.BurnIn(id, percent)
+}
+
+func (tc *tankChannel) EndBurnIn(id string) {
+	tc.lock.Lock()
+	in := tc.inTank(id)
+	tc.lock.Unlock()
+	if in {
+		tc.bleChannel.EndBurnIn(id)
+	}
+}
+
+// Release is left to the whole controller, as it stops every write.
+func (tc *tankChannel) Release() {
+	log.Printf("Not releasing fixtures for tank %s, only the controller releases them", tc.name)
+}
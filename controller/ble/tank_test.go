@@ -0,0 +1,157 @@
+package ble
+
+import (
+	"testing"
+	"time"
+
+	"github.com/theatrus/ledbrick/controller/clock"
+)
+
+// lastWrite returns the last value written to a fixture's channel.
+func lastWrite(f *fakeFixture, channel int) byte {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+	v := byte(0)
+	for _, b := range f.writes {
+		if int(b[0]) == channel {
+			v = b[1]
+		}
+	}
+	return v
+}
+
+func TestTanks(t *testing.T) {
+	ble := newBLEChannel(&fakeDevice{}, map[string]FixtureConfig{"frag1": {Tank: "frag"}})
+	display := newFakeFixture("display1")
+	frag := newFakeFixture("frag1")
+	connect(ble, display)
+	connect(ble, frag)
+
+	tank := ble.Tank("frag")
+	ble.SetChannelImmediate(0, 100)
+	tank.SetChannelImmediate(0, 40)
+	tank.SetChannelImmediate(1, 80)
+	if err := tank.SetLimit("feed", 50); err != nil {
+		t.Fatal(err)
+	}
+	if err := ble.writeLedState(); err != nil {
+		t.Fatal(err)
+	}
+	if v := lastWrite(display, 0); v != 250 {
+		t.Errorf("Expected the display untouched by the frag tank, got %d", v)
+	}
+	if v0, v1 := lastWrite(frag, 0), lastWrite(frag, 1); v0 != 100 || v1 != 125 {
+		t.Errorf("Expected the frag tank at 40%% and limited to 50%%, got %d and %d", v0, v1)
+	}
+	if len(ble.Limits()) != 0 {
+		t.Errorf("Expected the tank limit not to show on the controller, got %v", ble.Limits())
+	}
+
+	// Limits on the controller apply to every tank
+	ble.SetLimit("ups", 20)
+	tank.SetChannelImmediate(0, 40)
+	ble.SetChannelImmediate(0, 100)
+	if err := ble.writeLedState(); err != nil {
+		t.Fatal(err)
+	}
+	if v0, d0 := lastWrite(frag, 0), lastWrite(display, 0); v0 != 50 || d0 != 50 {
+		t.Errorf("Expected both tanks limited to 20%%, got %d and %d", v0, d0)
+	}
+
+	if p := tank.Perhipherals(); len(p) != 1 || p[0].ID() != "frag1" {
+		t.Errorf("Expected the frag tank to list frag1, got %v", p)
+	}
+	if names := ble.Tanks(); len(names) != 1 || names[0] != "frag" {
+		t.Errorf("Expected one tank, got %v", names)
+	}
+}
+
+func TestForceLimit(t *testing.T) {
+	defer func(r float64) { slewRate = r }(slewRate)
+	slewRate = 2
+	ble, _ := newTestChannel()
+	c := clock.NewFake(time.Date(2016, 1, 1, 0, 0, 0, 0, time.UTC))
+	ble.clock = c
+	f := newFakeFixture("f1")
+	connect(ble, f)
+	ble.SetChannelImmediate(0, 100)
+	ble.writeLedState()
+	ble.SetChannel(0, 100)
+
+	// A leak cuts the lights at once, not at the slew rate
+	if err := ble.ForceLimit("gpio:leak", 0); err != nil {
+		t.Fatal(err)
+	}
+	c.Advance(time.Second)
+	ble.writeLedState()
+	if v := lastWrite(f, 0); v != 0 {
+		t.Errorf("Expected the forced limit applied at once, got %d", v)
+	}
+
+	// and they come back up gently once it clears
+	ble.ClearLimit("gpio:leak")
+	c.Advance(time.Second)
+	ble.writeLedState()
+	if v := lastWrite(f, 0); v != 5 {
+		t.Errorf("Expected output to slew back to 2%%, got %d", v)
+	}
+}
+
+func TestTankScope(t *testing.T) {
+	defer func(r float64) { slewRate = r }(slewRate)
+	slewRate = 2
+	ble := newBLEChannel(&fakeDevice{}, map[string]FixtureConfig{"frag1": {Tank: "frag"}, "sump1": {Tank: "sump"}})
+	c := clock.NewFake(time.Date(2016, 1, 1, 0, 0, 0, 0, time.UTC))
+	ble.clock = c
+	frag1 := newFakeFixture("frag1")
+	sump1 := newFakeFixture("sump1")
+	connect(ble, frag1)
+	connect(ble, sump1)
+	frag, sump := ble.Tank("frag"), ble.Tank("sump")
+
+	// Maintenance on one tank holds off its effects alone
+	frag.SuspendEffects("maintenance")
+	if !frag.EffectsSuspended() || sump.EffectsSuspended() || ble.EffectsSuspended() {
+		t.Errorf("Expected only the frag tank's effects suspended, got %v, %v and %v",
+			frag.EffectsSuspended(), sump.EffectsSuspended(), ble.EffectsSuspended())
+	}
+	frag.SetChannelImmediate(0, 100)
+	sump.SetChannelImmediate(0, 100)
+	c.Advance(time.Second)
+	ble.writeLedState()
+	if f, s := lastWrite(frag1, 0), lastWrite(sump1, 0); f != 5 || s != 250 {
+		t.Errorf("Expected the frag tank slewed and the sump's lightning abrupt, got %d and %d", f, s)
+	}
+	frag.ResumeEffects("maintenance")
+	if frag.EffectsSuspended() {
+		t.Error("Expected the frag tank's effects resumed")
+	}
+
+	// A tank can't name or burn in another's fixtures
+	if err := frag.SetName("sump1", "Left"); err == nil {
+		t.Error("Expected naming another tank's fixture to fail")
+	}
+	if err := frag.SetName("frag1", "Left"); err != nil {
+		t.Errorf("Expected naming the tank's own fixture to work, got %v", err)
+	}
+	if err := frag.BurnIn("sump1", 100); err == nil {
+		t.Error("Expected burning in another tank's fixture to fail")
+	}
+	sump.BurnIn("sump1", 50)
+	frag.EndBurnIn("sump1")
+	ble.lock.Lock()
+	_, burning := ble.burnIns["sump1"]
+	ble.lock.Unlock()
+	if !burning {
+		t.Error("Expected another tank not to end a burn in")
+	}
+
+	// nor release the controller's fixtures
+	frag.Release()
+	ble.lock.Lock()
+	released := ble.released
+	ble.lock.Unlock()
+	if released {
+		t.Error("Expected a tank not to release the controller")
+	}
+}
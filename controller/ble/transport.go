@@ -0,0 +1,112 @@
+package ble
+
+import (
+	"io"
+	"log"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/paypal/gatt"
+)
+
+// LightChannel is what runs a lighting table: the channels it writes,
+// and the fixtures they reach with their telemetry. A BLEChannel is
+// one, driving every fixture whichever transport reaches it, and the
+// scheduler needs nothing more, so anything else standing in for the
+// fixtures can run the same tables.
+type LightChannel interface {
+	Perhipherals() []BLEPeripheral
+	SetChannel(channel int, percent float64) error
+	// Channels returns the percent each channel was last driven to
+	Channels() map[int]float64
+	SetProgram(prog Program)
+	Degraded() bool
+	Power(percents map[int]float64) float64
+}
+
+// A Transport reaches fixtures other than over bluetooth, carrying the
+// Wi-Fi fixtures' protocol over links of its own, such as UDP. Its
+// fixtures run under the channel like any other, with the same
+// features.
+type Transport interface {
+	// Find returns a dialer for each fixture the transport reaches,
+	// by ID. It is called every minute, so lost fixtures are dialed
+	// again.
+	Find() map[string]Dialer
+}
+
+// Dialer opens a link to a fixture. Each Write on a link sends one
+// request, and each Read returns one reply, as a UDP socket does.
+type Dialer func() (io.ReadWriteCloser, error)
+
+var transportLock sync.Mutex
+var transports = make(map[string]Transport)
+
+// RegisterTransport adds a transport to look for fixtures on, before
+// the channel starts.
+func RegisterTransport(name string, t Transport) {
+	transportLock.Lock()
+	defer transportLock.Unlock()
+	transports[name] = t
+}
+
+// fleetDevice connects fixtures on a transport itself, and everything
+// else through the bluetooth device.
+type fleetDevice struct {
+	device
+	ble *bleChannel
+}
+
+func (f fleetDevice) Connect(p peripheral) {
+	w, ok := p.(*wifiPeripheral)
+	if !ok {
+		f.device.Connect(p)
+		return
+	}
+	go func() {
+		f.ble.onPeriphConnected(w, w.dial())
+	}()
+}
+
+func (f fleetDevice) CancelConnection(p peripheral) {
+	w, ok := p.(*wifiPeripheral)
+	if !ok {
+		f.device.CancelConnection(p)
+		return
+	}
+	w.hangUp()
+}
+
+// startTransports looks for fixtures on every transport registered
+// every minute. Those found are discovered like bluetooth ones, which
+// reconnects any which were lost.
+func (ble *bleChannel) startTransports() {
+	ble.device = fleetDevice{device: ble.device, ble: ble}
+	lost := func(p peripheral, err error) { ble.onPeriphDisconnected(p, err) }
+	go func() {
+		for {
+			transportLock.Lock()
+			names := make([]string, 0, len(transports))
+			for name := range transports {
+				names = append(names, name)
+			}
+			sort.Strings(names)
+			found := make([]map[string]Dialer, len(names))
+			for i, name := range names {
+				found[i] = transports[name].Find()
+			}
+			transportLock.Unlock()
+			for i, dialers := range found {
+				for id, dial := range dialers {
+					if dial == nil {
+						log.Printf("%s transport found %s with no way to dial it", names[i], id)
+						continue
+					}
+					ble.onPeriphDiscovered(newWifiPeripheral(id, dial, lost), &gatt.Advertisement{LocalName: id}, 0)
+				}
+			}
+			time.Sleep(time.Minute)
+		}
+	}()
+}
@@ -0,0 +1,76 @@
+package ble
+
+import (
+	"flag"
+	"time"
+
+	"github.com/theatrus/ledbrick/controller/alert"
+)
+
+var offlineGrace time.Duration
+var offlineRemind time.Duration
+var offlineEscalate time.Duration
+
+func init() {
+	flag.DurationVar(&offlineGrace, "ble.offline.grace", 5*time.Minute,
+		"How long an expected fixture may be disconnected before alerting")
+	flag.DurationVar(&offlineRemind, "ble.offline.remind", time.Hour,
+		"How often to repeat the alert for a fixture which is still offline")
+	flag.DurationVar(&offlineEscalate, "ble.offline.escalate", 30*time.Minute,
+		"How long a fixture may be offline before the alert becomes critical")
+}
+
+// offline tracks the alert state of an expected fixture.
+type offline struct {
+	since     time.Time
+	alerted   time.Time
+	escalated bool
+}
+
+// checkOffline alerts on expected fixtures which have been disconnected
+// for longer than the grace period. The caller must hold the channel
+// lock.
+func (ble *bleChannel) checkOffline(now time.Time) {
+	for id, c := range ble.fixtures {
+		if !c.Expected {
+			continue
+		}
+		o, ok := ble.offline[id]
+		if !ok {
+			o = &offline{since: now}
+			ble.offline[id] = o
+		}
+
+		if _, connected := ble.connectedPeriph[id]; connected {
+			if !o.alerted.IsZero() {
+				alert.Raise(alert.Info, id, "fixture.offline",
+					"fixture back online after %s", now.Sub(o.since))
+			}
+			*o = offline{}
+			continue
+		}
+		if o.since.IsZero() {
+			o.since = now
+		}
+
+		down := now.Sub(o.since)
+		if down < offlineGrace {
+			continue
+		}
+		switch {
+		case !o.escalated && down >= offlineEscalate:
+			o.escalated = true
+			o.alerted = now
+			alert.Raise(alert.Critical, id, "fixture.offline",
+				"expected fixture still offline after %s", down)
+		case o.alerted.IsZero() || now.Sub(o.alerted) >= offlineRemind:
+			severity := alert.Warning
+			if o.escalated {
+				severity = alert.Critical
+			}
+			o.alerted = now
+			alert.Raise(severity, id, "fixture.offline",
+				"expected fixture offline for %s", down)
+		}
+	}
+}
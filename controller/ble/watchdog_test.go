@@ -0,0 +1,50 @@
+package ble
+
+import (
+	"testing"
+	"time"
+
+	"github.com/theatrus/ledbrick/controller/alert"
+)
+
+type recordNotifier struct {
+	alerts []alert.Alert
+}
+
+func (r *recordNotifier) Notify(a alert.Alert) error {
+	r.alerts = append(r.alerts, a)
+	return nil
+}
+
+func TestCheckOffline(t *testing.T) {
+	rec := &recordNotifier{}
+	alert.RegisterSync(rec)
+
+	ble := &bleChannel{
+		connectedPeriph: make(map[string]*blePeriph),
+		fixtures:        map[string]FixtureConfig{"a": {Expected: true}, "b": {}},
+		offline:         make(map[string]*offline),
+	}
+	start := time.Now()
+	ble.checkOffline(start)
+	ble.checkOffline(start.Add(offlineGrace - time.Second))
+	if len(rec.alerts) != 0 {
+		t.Fatalf("Alerted inside grace period: %v", rec.alerts)
+	}
+
+	ble.checkOffline(start.Add(offlineGrace))
+	if len(rec.alerts) != 1 || rec.alerts[0].Severity != alert.Warning {
+		t.Fatalf("Expected one warning, got %v", rec.alerts)
+	}
+
+	ble.checkOffline(start.Add(offlineEscalate))
+	if len(rec.alerts) != 2 || rec.alerts[1].Severity != alert.Critical {
+		t.Fatalf("Expected escalation, got %v", rec.alerts)
+	}
+
+	ble.connectedPeriph["a"] = &blePeriph{}
+	ble.checkOffline(start.Add(offlineEscalate + time.Minute))
+	if len(rec.alerts) != 3 || rec.alerts[2].Severity != alert.Info {
+		t.Fatalf("Expected recovery notice, got %v", rec.alerts)
+	}
+}
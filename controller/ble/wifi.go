@@ -0,0 +1,381 @@
+package ble
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/paypal/gatt"
+	"github.com/theatrus/ledbrick/controller/mdns"
+)
+
+// The service Wi-Fi fixtures advertise
+const wifiService = "_ledbrick._udp.local"
+
+var wifiFixtures string
+var wifiMDNS bool
+var wifiPoll time.Duration
+
+func init() {
+	flag.StringVar(&wifiFixtures, "ble.wifi.fixtures", "",
+		"Comma separated host:port addresses of Wi-Fi fixtures, found by mDNS as well")
+	flag.BoolVar(&wifiMDNS, "ble.wifi.mdns", true,
+		"Look for Wi-Fi fixtures advertising _ledbrick._udp with mDNS")
+	flag.DurationVar(&wifiPoll, "ble.wifi.poll", 5*time.Second,
+		"How often Wi-Fi fixtures are polled for telemetry")
+}
+
+// Wi-Fi fixtures run the same firmware features as bluetooth ones over
+// UDP, one request per datagram, each characteristic named by a code:
+//
+//	'L' LED, 'T' temperature, 'F' fan, 'C' fan duty, 'S' status,
+//	'P' schedule, 'K' capabilities, 'M' PWM config
+//
+// Requests and their replies are:
+//
+//	'?'                  -> '!' followed by the codes the fixture has
+//	'R' code             -> 'V' code value
+//	'W' code value          no reply
+//	'w' code value       -> 'A' code, or 'E' code reason on error
+//
+// Values are the characteristic values bluetooth fixtures use. There
+// are no notifications: the controller polls the temperature, fan and
+// status on its own.
+const (
+	wifiName = "LEDBrick-WiFi"
+	// How many polls in a row may go unanswered before the fixture
+	// counts as disconnected
+	wifiPollMiss = 3
+)
+
+// How long to wait for a reply
+var wifiTimeout = time.Second
+
+// How long to wait first before reading again after a read error, up
+// to wifiTimeout
+const wifiReadBackoff = 10 * time.Millisecond
+
+// wifiProfile is a Wi-Fi fixture, which behaves as the original board.
+var wifiProfile = DeviceProfile{Name: "ledbrick-wifi",
+	Advertised:    []string{wifiName},
+	Channels:      8,
+	PWMBits:       8,
+	FullScale:     250,
+	LedChar:       pwmLedChar,
+	TempChar:      pwmTempChar,
+	FanChar:       pwmFanChar,
+	FanConfigChar: pwmFanConfigChar,
+	StatusChar:    pwmStatusChar,
+	ScheduleChar:  pwmScheduleChar,
+	CapsChar:      pwmCapsChar,
+	PWMConfigChar: pwmConfigChar,
+	NameChar:      pwmNameChar,
+}
+
+var wifiCodes = map[byte]string{
+	'L': pwmLedChar,
+	'T': pwmTempChar,
+	'F': pwmFanChar,
+	'C': pwmFanConfigChar,
+	'S': pwmStatusChar,
+	'P': pwmScheduleChar,
+	'K': pwmCapsChar,
+	'M': pwmConfigChar,
+	'N': pwmNameChar,
+}
+
+// wifiPeripheral is a Wi-Fi fixture, or another speaking its protocol
+// over a transport's link, standing in for a bluetooth peripheral so it
+// runs under the same channel with the same features.
+type wifiPeripheral struct {
+	id     string
+	dialer Dialer
+	// lost is called when the fixture stops answering polls
+	lost func(peripheral, error)
+
+	conn    io.ReadWriteCloser
+	replies chan []byte
+	done    chan struct{}
+	closing sync.Once
+	// request is held for each request and its reply
+	request sync.Mutex
+
+	lock   sync.Mutex
+	codes  []byte
+	notify map[byte]func(*gatt.Characteristic, []byte, error)
+	chars  map[byte]*gatt.Characteristic
+}
+
+func newWifiPeripheral(id string, dial Dialer, lost func(peripheral, error)) *wifiPeripheral {
+	return &wifiPeripheral{id: id, dialer: dial, lost: lost,
+		notify: make(map[byte]func(*gatt.Characteristic, []byte, error)),
+		chars:  make(map[byte]*gatt.Characteristic),
+	}
+}
+
+func (w *wifiPeripheral) ID() string   { return w.id }
+func (w *wifiPeripheral) Name() string { return wifiName }
+
+// dial connects to the fixture and asks what it has.
+func (w *wifiPeripheral) dial() error {
+	conn, err := w.dialer()
+	if err != nil {
+		return err
+	}
+	w.conn = conn
+	w.replies = make(chan []byte, 8)
+	w.done = make(chan struct{})
+	go w.read()
+
+	b, err := w.exchange([]byte{'?'}, '!')
+	if err != nil {
+		w.hangUp()
+		return fmt.Errorf("no reply from %s: %v", w.id, err)
+	}
+	w.lock.Lock()
+	w.codes = b[1:]
+	w.lock.Unlock()
+	go w.poll()
+	return nil
+}
+
+// hangUp stops talking to the fixture.
+func (w *wifiPeripheral) hangUp() {
+	if w.conn == nil {
+		return
+	}
+	w.closing.Do(func() {
+		close(w.done)
+		w.conn.Close()
+	})
+}
+
+func (w *wifiPeripheral) read() {
+	buf := make([]byte, 1500)
+	backoff := wifiReadBackoff
+	for {
+		n, err := w.conn.Read(buf)
+		if err != nil {
+			if err == io.EOF {
+				// The link is gone, so polls go unanswered until
+				// the fixture is lost
+				return
+			}
+			// Nothing listening on the port yet, so wait a while
+			// before reading again, longer each time it fails
+			select {
+			case <-w.done:
+				return
+			case <-time.After(backoff):
+			}
+			if backoff *= 2; backoff > wifiTimeout {
+				backoff = wifiTimeout
+			}
+			continue
+		}
+		backoff = wifiReadBackoff
+		b := append([]byte(nil), buf[:n]...)
+		select {
+		case w.replies <- b:
+		default:
+		}
+	}
+}
+
+// exchange sends a request and waits for the reply starting with want,
+// or an error reply.
+func (w *wifiPeripheral) exchange(req []byte, want byte) ([]byte, error) {
+	w.request.Lock()
+	defer w.request.Unlock()
+	// Drop late replies to earlier requests
+	for len(w.replies) > 0 {
+		<-w.replies
+	}
+	if _, err := w.conn.Write(req); err != nil {
+		return nil, err
+	}
+	timeout := time.NewTimer(wifiTimeout)
+	defer timeout.Stop()
+	for {
+		select {
+		case b := <-w.replies:
+			if len(b) > 1 && b[0] == 'E' {
+				return nil, fmt.Errorf("fixture error: %s", b[2:])
+			}
+			if len(b) > 0 && b[0] == want && (len(req) < 2 || len(b) > 1 && b[1] == req[1]) {
+				return b, nil
+			}
+		case <-timeout.C:
+			return nil, errors.New("timed out")
+		case <-w.done:
+			return nil, errors.New("disconnected")
+		}
+	}
+}
+
+// poll reads the telemetry which bluetooth fixtures would notify,
+// handing it to the same callbacks. A fixture with none is asked what
+// it has instead, so a dead link is still noticed.
+func (w *wifiPeripheral) poll() {
+	ticker := time.NewTicker(wifiPoll)
+	defer ticker.Stop()
+	missed := 0
+	for {
+		select {
+		case <-ticker.C:
+		case <-w.done:
+			return
+		}
+		w.lock.Lock()
+		notify := make(map[byte]func(*gatt.Characteristic, []byte, error))
+		chars := make(map[byte]*gatt.Characteristic)
+		for code, fn := range w.notify {
+			notify[code] = fn
+			chars[code] = w.chars[code]
+		}
+		w.lock.Unlock()
+
+		var failed error
+		if len(notify) == 0 {
+			_, failed = w.exchange([]byte{'?'}, '!')
+		}
+		for code, fn := range notify {
+			b, err := w.exchange([]byte{'R', code}, 'V')
+			if err != nil {
+				failed = err
+				continue
+			}
+			fn(chars[code], b[2:], nil)
+		}
+		if failed == nil {
+			missed = 0
+			continue
+		}
+		if missed++; missed >= wifiPollMiss {
+			log.Printf("%s: no telemetry in %d polls: %v", w.id, missed, failed)
+			w.hangUp()
+			if w.lost != nil {
+				w.lost(w, failed)
+			}
+			return
+		}
+	}
+}
+
+func (w *wifiPeripheral) DiscoverServices(s []gatt.UUID) ([]*gatt.Service, error) {
+	return []*gatt.Service{gatt.NewService(gatt.MustParseUUID(pwmService))}, nil
+}
+
+func (w *wifiPeripheral) DiscoverCharacteristics(c []gatt.UUID, s *gatt.Service) ([]*gatt.Characteristic, error) {
+	w.lock.Lock()
+	defer w.lock.Unlock()
+	var cs []*gatt.Characteristic
+	for _, code := range w.codes {
+		uuid, ok := wifiCodes[code]
+		if !ok {
+			continue
+		}
+		props := gatt.CharRead
+		switch code {
+		case 'T', 'F', 'S':
+			props |= gatt.CharNotify
+		case 'L', 'C', 'P', 'M':
+			props |= gatt.CharWrite
+		}
+		ch := gatt.NewCharacteristic(gatt.MustParseUUID(uuid), s, props, 0, 0)
+		w.chars[code] = ch
+		cs = append(cs, ch)
+	}
+	return cs, nil
+}
+
+func (w *wifiPeripheral) DiscoverDescriptors(d []gatt.UUID, c *gatt.Characteristic) ([]*gatt.Descriptor, error) {
+	return nil, nil
+}
+
+func (w *wifiPeripheral) ReadDescriptor(d *gatt.Descriptor) ([]byte, error) { return nil, nil }
+
+// code returns the wire code of a characteristic.
+func (w *wifiPeripheral) code(c *gatt.Characteristic) (byte, error) {
+	uuid := c.UUID().String()
+	for code, u := range wifiCodes {
+		if u == uuid {
+			return code, nil
+		}
+	}
+	return 0, fmt.Errorf("no Wi-Fi code for characteristic %s", uuid)
+}
+
+func (w *wifiPeripheral) ReadCharacteristic(c *gatt.Characteristic) ([]byte, error) {
+	code, err := w.code(c)
+	if err != nil {
+		return nil, err
+	}
+	b, err := w.exchange([]byte{'R', code}, 'V')
+	if err != nil {
+		return nil, err
+	}
+	return b[2:], nil
+}
+
+func (w *wifiPeripheral) WriteCharacteristic(c *gatt.Characteristic, b []byte, noRsp bool) error {
+	code, err := w.code(c)
+	if err != nil {
+		return err
+	}
+	if noRsp {
+		_, err := w.conn.Write(append([]byte{'W', code}, b...))
+		return err
+	}
+	_, err = w.exchange(append([]byte{'w', code}, b...), 'A')
+	return err
+}
+
+func (w *wifiPeripheral) SetNotifyValue(c *gatt.Characteristic, fn func(*gatt.Characteristic, []byte, error)) error {
+	code, err := w.code(c)
+	if err != nil {
+		return err
+	}
+	w.lock.Lock()
+	defer w.lock.Unlock()
+	w.notify[code] = fn
+	return nil
+}
+
+// udpTransport finds Wi-Fi fixtures, configured and by mDNS.
+type udpTransport struct{}
+
+func init() {
+	RegisterTransport("wifi", udpTransport{})
+}
+
+func (udpTransport) Find() map[string]Dialer {
+	found := make(map[string]Dialer)
+	for _, addr := range strings.Split(wifiFixtures, ",") {
+		if addr = strings.TrimSpace(addr); addr != "" {
+			found[addr] = udpDialer(addr)
+		}
+	}
+	if wifiMDNS {
+		services, err := mdns.Browse(wifiService, 2*time.Second)
+		if err != nil {
+			log.Printf("mDNS browse failed: %v", err)
+		}
+		for name, addr := range services {
+			found[name] = udpDialer(addr)
+		}
+	}
+	return found
+}
+
+func udpDialer(addr string) Dialer {
+	return func() (io.ReadWriteCloser, error) {
+		return net.Dial("udp", addr)
+	}
+}
@@ -0,0 +1,222 @@
+package ble
+
+import (
+	"io"
+	"net"
+	"sync"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/paypal/gatt"
+	"github.com/theatrus/ledbrick/controller/clock"
+)
+
+// udpFixture answers the Wi-Fi fixture protocol on a local port.
+type udpFixture struct {
+	conn *net.UDPConn
+
+	lock   sync.Mutex
+	writes [][]byte
+	silent bool
+}
+
+func newUDPFixture(t *testing.T) *udpFixture {
+	conn, err := net.ListenUDP("udp4", &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	f := &udpFixture{conn: conn}
+	go f.serve()
+	return f
+}
+
+func (f *udpFixture) serve() {
+	buf := make([]byte, 1500)
+	for {
+		n, from, err := f.conn.ReadFromUDP(buf)
+		if err != nil {
+			return
+		}
+		req := append([]byte(nil), buf[:n]...)
+		f.lock.Lock()
+		silent := f.silent
+		if req[0] == 'W' || req[0] == 'w' {
+			f.writes = append(f.writes, req)
+		}
+		f.lock.Unlock()
+		if silent {
+			continue
+		}
+		var reply []byte
+		switch {
+		case req[0] == '?':
+			reply = []byte("!LTFK")
+		case req[0] == 'R' && req[1] == 'T':
+			reply = []byte{'V', 'T', 30, 0}
+		case req[0] == 'R' && req[1] == 'F':
+			reply = []byte{'V', 'F', 0xe8, 0x03}
+		case req[0] == 'R':
+			reply = []byte{'V', req[1], 0}
+		case req[0] == 'w':
+			reply = []byte{'A', req[1]}
+		}
+		if reply != nil {
+			f.conn.WriteToUDP(reply, from)
+		}
+	}
+}
+
+func TestWifiFixture(t *testing.T) {
+	defer func(poll, timeout time.Duration) { wifiPoll, wifiTimeout = poll, timeout }(wifiPoll, wifiTimeout)
+	wifiPoll = 10 * time.Millisecond
+	wifiTimeout = 20 * time.Millisecond
+
+	f := newUDPFixture(t)
+	defer f.conn.Close()
+	ble, _ := newTestChannel()
+	ble.device = fleetDevice{device: ble.device, ble: ble}
+	w := newWifiPeripheral("kitchen", udpDialer(f.conn.LocalAddr().String()), func(p peripheral, err error) {
+		ble.onPeriphDisconnected(p, err)
+	})
+	ble.onPeriphDiscovered(w, &gatt.Advertisement{}, 0)
+
+	waitFor := func(what string, done func() bool) {
+		deadline := time.Now().Add(2 * time.Second)
+		for {
+			ble.lock.Lock()
+			ok := done()
+			ble.lock.Unlock()
+			if ok {
+				return
+			}
+			if time.Now().After(deadline) {
+				t.Fatalf("Timed out waiting for %s", what)
+			}
+			time.Sleep(time.Millisecond)
+		}
+	}
+	waitFor("the fixture to connect", func() bool { return ble.connectedPeriph["kitchen"] != nil })
+	bp := ble.connectedPeriph["kitchen"]
+	if bp.profile.Name != wifiProfile.Name || bp.scheduleChar != nil || bp.ledChar == nil {
+		t.Error("Expected the Wi-Fi profile with the characteristics the fixture listed")
+	}
+	waitFor("telemetry", func() bool { return bp.temperature == 30 && bp.fanRpm == 1000 })
+
+	ble.SetChannel(0, 100)
+	ble.writeLedState()
+	deadline := time.Now().Add(2 * time.Second)
+	for {
+		f.lock.Lock()
+		var got []byte
+		for _, b := range f.writes {
+			if b[0] == 'W' && b[1] == 'L' && b[2] == 0 {
+				got = b
+			}
+		}
+		f.lock.Unlock()
+		if got != nil {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatal("Expected channel writes to reach the fixture")
+		}
+		time.Sleep(time.Millisecond)
+	}
+
+	f.lock.Lock()
+	f.silent = true
+	f.lock.Unlock()
+	waitFor("the fixture to be lost", func() bool { return ble.connectedPeriph["kitchen"] == nil })
+}
+
+// gatewayFixture answers as a gateway link does: LEDs only, and no
+// telemetry.
+func gatewayFixture(conn net.Conn) {
+	buf := make([]byte, 1500)
+	for {
+		n, err := conn.Read(buf)
+		if err != nil {
+			return
+		}
+		switch {
+		case buf[0] == '?':
+			conn.Write([]byte("!L"))
+		case n > 1 && buf[0] == 'w':
+			conn.Write([]byte{'A', buf[1]})
+		case n > 1 && buf[0] == 'R' && buf[1] == 'L':
+			conn.Write([]byte("VL"))
+		case n > 1 && buf[0] == 'R':
+			conn.Write([]byte{'E', buf[1], 'n', 'o'})
+		}
+	}
+}
+
+func TestGatewayFixtureNotStalled(t *testing.T) {
+	ble, _ := newTestChannel()
+	fake := clock.NewFake(time.Unix(1000, 0))
+	ble.clock = fake
+	ble.device = fleetDevice{device: ble.device, ble: ble}
+	link, gw := net.Pipe()
+	defer gw.Close()
+	go gatewayFixture(gw)
+	dial := func() (io.ReadWriteCloser, error) { return link, nil }
+	ble.onPeriphDiscovered(newWifiPeripheral("gw", dial, nil), &gatt.Advertisement{}, 0)
+
+	deadline := time.Now().Add(2 * time.Second)
+	for {
+		ble.lock.Lock()
+		bp := ble.connectedPeriph["gw"]
+		ble.lock.Unlock()
+		if bp != nil {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatal("Timed out waiting for the fixture to connect")
+		}
+		time.Sleep(time.Millisecond)
+	}
+
+	fake.Advance(6 * time.Minute)
+	ble.SetChannel(0, 50)
+	ble.writeLedState()
+	defer func() {
+		if r := recover(); r != nil {
+			t.Errorf("Expected a fixture without telemetry to run on, got %v", r)
+		}
+	}()
+	ble.lock.Lock()
+	defer ble.lock.Unlock()
+	ble.checkStalled(fake.Now())
+}
+
+// failingConn fails every read, counting them.
+type failingConn struct {
+	lock  sync.Mutex
+	reads int
+}
+
+func (c *failingConn) Read(b []byte) (int, error) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	c.reads++
+	return 0, &net.OpError{Op: "read", Net: "udp", Err: syscall.ECONNREFUSED}
+}
+
+func (c *failingConn) Write(b []byte) (int, error) { return len(b), nil }
+func (c *failingConn) Close() error                { return nil }
+
+func TestWifiReadBacksOff(t *testing.T) {
+	c := &failingConn{}
+	w := newWifiPeripheral("refused", nil, nil)
+	w.conn, w.done = c, make(chan struct{})
+	go w.read()
+	time.Sleep(200 * time.Millisecond)
+	w.hangUp()
+
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	if c.reads > 10 {
+		t.Errorf("Expected reads backing off after errors, got %d in 200ms", c.reads)
+	}
+}
@@ -0,0 +1,158 @@
+package ble
+
+import (
+	"flag"
+	"time"
+)
+
+var refresh time.Duration
+
+func init() {
+	flag.DurationVar(&refresh, "ble.refresh", time.Second,
+		"How often channel values are written to every fixture")
+}
+
+// Upper bounds of the write latency buckets
+var latencyBuckets = []time.Duration{
+	time.Millisecond, 2 * time.Millisecond, 5 * time.Millisecond,
+	10 * time.Millisecond, 20 * time.Millisecond, 50 * time.Millisecond,
+	100 * time.Millisecond, 200 * time.Millisecond, 500 * time.Millisecond,
+	time.Second,
+}
+
+// WriteStats counts the channel writes to one fixture and how long they
+// took, since the controller started. Channel writes aren't normally
+// acknowledged, so they only take as long as queueing them, except
+// while a Measurer measures them.
+type WriteStats struct {
+	Writes int `json:"writes"`
+	Errors int `json:"errors"`
+	// Total and Max latency, and Buckets counting writes at or under
+	// each of latencyBuckets with one more for anything slower
+	Total   time.Duration `json:"total_ns"`
+	Max     time.Duration `json:"max_ns"`
+	Buckets []int         `json:"buckets"`
+}
+
+func (s *WriteStats) record(latency time.Duration, err error) {
+	if s.Buckets == nil {
+		s.Buckets = make([]int, len(latencyBuckets)+1)
+	}
+	s.Writes++
+	if err != nil {
+		s.Errors++
+	}
+	s.Total += latency
+	if latency > s.Max {
+		s.Max = latency
+	}
+	i := 0
+	for i < len(latencyBuckets) && latency > latencyBuckets[i] {
+		i++
+	}
+	s.Buckets[i]++
+}
+
+// Since returns the writes made since an earlier copy of the stats. The
+// max is kept, as it can't be taken apart.
+func (s WriteStats) Since(earlier WriteStats) WriteStats {
+	d := WriteStats{Writes: s.Writes - earlier.Writes,
+		Errors:  s.Errors - earlier.Errors,
+		Total:   s.Total - earlier.Total,
+		Max:     s.Max,
+		Buckets: make([]int, len(latencyBuckets)+1),
+	}
+	for i := range d.Buckets {
+		if i < len(s.Buckets) {
+			d.Buckets[i] = s.Buckets[i]
+		}
+		if i < len(earlier.Buckets) {
+			d.Buckets[i] -= earlier.Buckets[i]
+		}
+	}
+	return d
+}
+
+func (s WriteStats) Mean() time.Duration {
+	if s.Writes == 0 {
+		return 0
+	}
+	return s.Total / time.Duration(s.Writes)
+}
+
+// ErrorRate is the fraction of writes which failed.
+func (s WriteStats) ErrorRate() float64 {
+	if s.Writes == 0 {
+		return 0
+	}
+	return float64(s.Errors) / float64(s.Writes)
+}
+
+// Percentile returns the bucket bound under which the fraction p of
+// writes completed, or the max if that is past the last bucket.
+func (s WriteStats) Percentile(p float64) time.Duration {
+	if s.Writes == 0 {
+		return 0
+	}
+	want := p * float64(s.Writes)
+	seen := 0
+	for i, n := range s.Buckets {
+		seen += n
+		if float64(seen) >= want && i < len(latencyBuckets) {
+			return latencyBuckets[i]
+		}
+	}
+	return s.Max
+}
+
+// A Measurer has the fixtures acknowledge channel writes while
+// measuring, so their latency is the round trip to the fixture rather
+// than the time taken to queue them. Calls nest, each MeasureWrites(true)
+// ended by a MeasureWrites(false).
+type Measurer interface {
+	MeasureWrites(on bool)
+}
+
+func (ble *bleChannel) MeasureWrites(on bool) {
+	ble.lock.Lock()
+	defer ble.lock.Unlock()
+	if on {
+		ble.measuring++
+	} else if ble.measuring > 0 {
+		ble.measuring--
+	}
+}
+
+// measure starts measuring writes on a channel which can, returning
+// the function ending it.
+func measure(b BLEChannel) func() {
+	m, ok := b.(Measurer)
+	if !ok {
+		return func() {}
+	}
+	m.MeasureWrites(true)
+	return func() { m.MeasureWrites(false) }
+}
+
+// writeStatsFor returns the write stats for a peripheral ID, creating
+// them if needed. The caller must hold the channel lock.
+func (ble *bleChannel) writeStatsFor(id string) *WriteStats {
+	s, ok := ble.writeStats[id]
+	if !ok {
+		s = &WriteStats{}
+		ble.writeStats[id] = s
+	}
+	return s
+}
+
+func (ble *bleChannel) WriteStats() map[string]WriteStats {
+	ble.lock.Lock()
+	defer ble.lock.Unlock()
+	stats := make(map[string]WriteStats)
+	for id, s := range ble.writeStats {
+		c := *s
+		c.Buckets = append([]int(nil), s.Buckets...)
+		stats[id] = c
+	}
+	return stats
+}
@@ -0,0 +1,175 @@
+package bridge
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/theatrus/ledbrick/controller/alert"
+	"github.com/theatrus/ledbrick/controller/ble"
+	"github.com/theatrus/ledbrick/controller/ups"
+)
+
+var mqttAddr string
+var mqttUser string
+var mqttPassword string
+var topicPrefix string
+var publishInterval time.Duration
+
+func init() {
+	flag.StringVar(&mqttAddr, "bridge.mqtt", "",
+		"Address of an MQTT broker to publish alarms and state to, e.g. localhost:1883")
+	flag.StringVar(&mqttUser, "bridge.mqtt.user", "", "MQTT user name")
+	flag.StringVar(&mqttPassword, "bridge.mqtt.password", "", "MQTT password")
+	flag.StringVar(&topicPrefix, "bridge.topic", "ledbrick", "Prefix for all published topics")
+	flag.DurationVar(&publishInterval, "bridge.interval", 30*time.Second,
+		"How often to publish state")
+}
+
+// Bridge publishes alarms and key states to an MQTT broker so they can
+// be picked up by a reef controller such as an Apex or Profilux, or
+// anything else already watching the broker.
+//
+// Every state topic is retained and carries a plain number, 0 or 1 for
+// flags, which is the easiest thing to map onto a controller input:
+//
+//	ledbrick/state/alarm           1 while any warning or critical alert is unacknowledged
+//	ledbrick/state/degraded        1 while in degraded mode
+//	ledbrick/state/on_battery      1 while the UPS is on battery
+//	ledbrick/fixture/<id>/temperature
+//	ledbrick/fixture/<id>/fan_rpm
+//	ledbrick/fixture/<id>/fan_failed
+//
+// Each alert is also published, not retained, as JSON to ledbrick/alarm,
+// and what the lights are doing as JSON to ledbrick/effects.
+//
+// With bridge.feed.topic set the bridge also follows the controller's
+// feed mode, and with bridge.zigbee.devices it drives Zigbee dimmers
+// through zigbee2mqtt. Bricks in the bridge.bricks file are driven over
+// MQTT as fixtures, whether or not the bridge publishes.
+type Bridge struct {
+	// UPS is optional
+	UPS *ups.Monitor
+
+	ble    ble.BLEChannel
+	client *mqttClient
+	lock   sync.Mutex
+}
+
+// Start connects the bridge if a broker is configured. It returns nil
+// if none is.
+func Start(b ble.BLEChannel, upsMonitor *ups.Monitor) *Bridge {
+	if mqttAddr == "" {
+		return nil
+	}
+	host, _ := os.Hostname()
+	br := &Bridge{
+		UPS: upsMonitor,
+		ble: b,
+		client: &mqttClient{
+			addr:     mqttAddr,
+			clientID: "ledbrick-" + host,
+			user:     mqttUser,
+			password: mqttPassword,
+		},
+	}
+	alert.Register(br)
+	if feedTopic != "" {
+		f := &feedMode{ble: b, client: &mqttClient{
+			addr:      mqttAddr,
+			clientID:  "ledbrick-feed-" + host,
+			user:      mqttUser,
+			password:  mqttPassword,
+			keepAlive: feedKeepAlive,
+		}}
+		go f.run()
+	}
+	if len(zigbeeDevices) > 0 {
+		z := &zigbeeOutput{ble: b, sent: make(map[string]int), client: &mqttClient{
+			addr:     mqttAddr,
+			clientID: "ledbrick-zigbee-" + host,
+			user:     mqttUser,
+			password: mqttPassword,
+		}}
+		go z.run()
+	}
+	if effectsInterval > 0 {
+		go br.publishEffects()
+	}
+	go func() {
+		br.publishState()
+		for _ = range time.Tick(publishInterval) {
+			br.publishState()
+		}
+	}()
+	return br
+}
+
+// Notify implements alert.Notifier.
+func (br *Bridge) Notify(a alert.Alert) error {
+	data, err := json.Marshal(a)
+	if err != nil {
+		return err
+	}
+	br.lock.Lock()
+	defer br.lock.Unlock()
+	if err := br.client.publish(topicPrefix+"/alarm", data, false); err != nil {
+		return err
+	}
+	return br.client.publish(topicPrefix+"/state/alarm", flagPayload(alarmActive(alert.Recent())), true)
+}
+
+func (br *Bridge) publishState() {
+	state := br.state()
+
+	br.lock.Lock()
+	defer br.lock.Unlock()
+	for topic, value := range state {
+		if err := br.client.publish(topicPrefix+"/"+topic, value, true); err != nil {
+			log.Printf("Failed to publish to MQTT broker %s: %v", mqttAddr, err)
+			return
+		}
+	}
+}
+
+// state returns the retained state payloads keyed by topic, without
+// the prefix.
+func (br *Bridge) state() map[string][]byte {
+	state := map[string][]byte{
+		"state/alarm":    flagPayload(alarmActive(alert.Recent())),
+		"state/degraded": flagPayload(br.ble.Degraded()),
+	}
+	if br.UPS != nil {
+		state["state/on_battery"] = flagPayload(br.UPS.OnBattery())
+	}
+	for _, p := range br.ble.Perhipherals() {
+		topic := fmt.Sprintf("fixture/%s/", p.ID())
+		state[topic+"temperature"] = []byte(strconv.Itoa(p.Temperature()))
+		state[topic+"fan_rpm"] = []byte(strconv.Itoa(p.FanRPM()))
+		state[topic+"fan_failed"] = flagPayload(p.FanFailed())
+	}
+	return state
+}
+
+// alarmActive reports whether any warning or critical alert is still
+// unacknowledged.
+func alarmActive(alerts []alert.Alert) bool {
+	for _, a := range alerts {
+		if a.Severity >= alert.Warning && !a.Acked {
+			return true
+		}
+	}
+	return false
+}
+
+func flagPayload(v bool) []byte {
+	if v {
+		return []byte("1")
+	}
+	return []byte("0")
+}
@@ -0,0 +1,347 @@
+package bridge
+
+import (
+	"bytes"
+	"io"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/theatrus/ledbrick/controller/alert"
+	"github.com/theatrus/ledbrick/controller/ble"
+)
+
+// rw replays a canned reply and records what was written.
+type rw struct {
+	io.Reader
+	bytes.Buffer
+}
+
+func (r *rw) Read(p []byte) (int, error) { return r.Reader.Read(p) }
+
+func TestConnect(t *testing.T) {
+	c := &mqttClient{clientID: "lb", user: "u", password: "p"}
+	conn := &rw{Reader: bytes.NewReader([]byte{0x20, 2, 0, 0})}
+	if err := c.connect(conn); err != nil {
+		t.Fatal(err)
+	}
+	want := []byte{0x10, 20,
+		0, 4, 'M', 'Q', 'T', 'T', 4, 0xc2, 0, 0,
+		0, 2, 'l', 'b',
+		0, 1, 'u',
+		0, 1, 'p'}
+	if !bytes.Equal(conn.Bytes(), want) {
+		t.Errorf("Expected connect % x, got % x", want, conn.Bytes())
+	}
+
+	conn = &rw{Reader: bytes.NewReader([]byte{0x20, 2, 0, 5})}
+	if err := c.connect(conn); err == nil {
+		t.Error("Expected refused connection to fail")
+	}
+}
+
+func TestPublish(t *testing.T) {
+	var buf bytes.Buffer
+	if err := writePublish(&buf, "a/b", []byte("1"), true); err != nil {
+		t.Fatal(err)
+	}
+	want := []byte{0x31, 6, 0, 3, 'a', '/', 'b', '1'}
+	if !bytes.Equal(buf.Bytes(), want) {
+		t.Errorf("Expected publish % x, got % x", want, buf.Bytes())
+	}
+
+	// Remaining lengths over 127 take more than one byte
+	buf.Reset()
+	writePacket(&buf, mqttPublish, make([]byte, 200))
+	if buf.Bytes()[1] != 0xc8 || buf.Bytes()[2] != 0x01 {
+		t.Errorf("Bad remaining length % x", buf.Bytes()[1:3])
+	}
+}
+
+func TestAlarmActive(t *testing.T) {
+	alerts := []alert.Alert{
+		{Severity: alert.Info},
+		{Severity: alert.Critical, Acked: true},
+	}
+	if alarmActive(alerts) {
+		t.Error("Expected no active alarm")
+	}
+	alerts = append(alerts, alert.Alert{Severity: alert.Warning})
+	if !alarmActive(alerts) {
+		t.Error("Expected an active alarm")
+	}
+}
+
+func TestReadPublish(t *testing.T) {
+	var buf bytes.Buffer
+	writePublish(&buf, "apex/feed", []byte("ON"), false)
+	header, body, err := readPacket(&buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	topic, payload, err := parsePublish(header, body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if topic != "apex/feed" || string(payload) != "ON" {
+		t.Errorf("Unexpected publish %q %q", topic, payload)
+	}
+
+	// QoS 1 carries a packet identifier
+	_, payload, err = parsePublish(0x32, []byte{0, 1, 'a', 0, 7, '1'})
+	if err != nil || string(payload) != "1" {
+		t.Errorf("Unexpected QoS 1 payload %q: %v", payload, err)
+	}
+}
+
+func TestParseFeed(t *testing.T) {
+	for _, v := range []string{"1", "ON", "true", "AON\n"} {
+		if !parseFeed(v) {
+			t.Errorf("Expected %q to be on", v)
+		}
+	}
+	for _, v := range []string{"0", "OFF", "AOF", ""} {
+		if parseFeed(v) {
+			t.Errorf("Expected %q to be off", v)
+		}
+	}
+}
+
+func TestFeedKeepAlive(t *testing.T) {
+	defer func(d time.Duration) { feedKeepAlive = d }(feedKeepAlive)
+	feedKeepAlive = 100 * time.Millisecond
+
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+	pinged := make(chan bool, 1)
+	go func() {
+		conn, err := l.Accept()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		// Answer the connect and the first ping, then go quiet
+		readPacket(conn)
+		conn.Write([]byte{mqttConnack, 2, 0, 0})
+		for {
+			header, _, err := readPacket(conn)
+			if err != nil {
+				return
+			}
+			if header == mqttPingreq {
+				select {
+				case pinged <- true:
+					conn.Write([]byte{mqttPingresp, 0})
+				default:
+				}
+			}
+		}
+	}()
+
+	f := &feedMode{client: &mqttClient{addr: l.Addr().String(), keepAlive: feedKeepAlive}}
+	result := make(chan error, 1)
+	go func() { result <- f.follow() }()
+	select {
+	case err := <-result:
+		if err == nil {
+			t.Error("Expected a broker gone quiet to fail the subscription")
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatal("Expected a broker gone quiet to be noticed")
+	}
+	select {
+	case <-pinged:
+	default:
+		t.Error("Expected the broker pinged")
+	}
+}
+
+// channels is a BLE channel reporting fixed channel outputs.
+type channels struct {
+	ble.BLEChannel
+	percents map[int]float64
+}
+
+func (c *channels) Channels() map[int]float64 { return c.percents }
+
+func TestZigbee(t *testing.T) {
+	var m zigbeeMap
+	for _, v := range []string{"moon", "moon=8", "=1"} {
+		if err := m.Set(v); err == nil {
+			t.Errorf("Expected error for %q", v)
+		}
+	}
+	if err := zigbeeDevices.Set("moon=7, strip=3"); err != nil {
+		t.Fatal(err)
+	}
+	defer func() { zigbeeDevices = nil }()
+
+	conn, broker := net.Pipe()
+	defer broker.Close()
+	published := make(chan string, 4)
+	go func() {
+		for {
+			header, body, err := readPacket(broker)
+			if err != nil {
+				return
+			}
+			topic, payload, _ := parsePublish(header, body)
+			published <- topic + " " + string(payload)
+		}
+	}()
+	c := &channels{percents: map[int]float64{7: 50, 3: 0}}
+	z := &zigbeeOutput{ble: c, client: &mqttClient{conn: conn}, sent: make(map[string]int)}
+	now := time.Now()
+	if err := z.update(now); err != nil {
+		t.Fatal(err)
+	}
+	got := map[string]bool{<-published: true, <-published: true}
+	for _, want := range []string{
+		`zigbee2mqtt/moon/set {"state":"ON","brightness":127,"transition":10}`,
+		`zigbee2mqtt/strip/set {"state":"OFF","brightness":0,"transition":10}`,
+	} {
+		if !got[want] {
+			t.Errorf("Expected %s, got %v", want, got)
+		}
+	}
+
+	// Only changes are sent until a refresh is due
+	c.percents = map[int]float64{7: 100, 3: 0}
+	z.update(now.Add(time.Second))
+	if p := <-published; p != `zigbee2mqtt/moon/set {"state":"ON","brightness":254,"transition":10}` {
+		t.Errorf("Expected only the moonlights changed, got %s", p)
+	}
+	select {
+	case p := <-published:
+		t.Errorf("Expected nothing more, got %s", p)
+	case <-time.After(10 * time.Millisecond):
+	}
+}
+
+func TestEffectState(t *testing.T) {
+	s := effectState{Channels: map[int]float64{0: 40, 1: 60}}
+	last := s
+	last.Channels = map[int]float64{0: 40, 1: 59.8}
+	if s.changed(last) {
+		t.Error("Expected a small change not to be published")
+	}
+	last.Channels = map[int]float64{0: 40, 1: 59}
+	if !s.changed(last) {
+		t.Error("Expected a percent change to be published")
+	}
+	last.Channels = s.Channels
+	last.Suspended = true
+	if !s.changed(last) {
+		t.Error("Expected suspending effects to be published")
+	}
+
+	c := &channels{percents: map[int]float64{0: 40, 1: 60}}
+	if got := effectsNow(&suspendable{c}); got.Intensity != 50 || !got.Suspended {
+		t.Errorf("Expected suspended at 50%% intensity, got %+v", got)
+	}
+}
+
+type suspendable struct{ *channels }
+
+func (suspendable) EffectsSuspended() bool { return true }
+
+func TestBricksConfig(t *testing.T) {
+	defer func(addr, user, password string) { mqttAddr, mqttUser, mqttPassword = addr, user, password }(mqttAddr, mqttUser, mqttPassword)
+	mqttAddr, mqttUser, mqttPassword = "", "", ""
+	for _, c := range []bricksConfig{
+		{},
+		{Broker: "mqtt://broker", Devices: []string{"a/b"}},
+		{Broker: "mqtt://broker", Devices: []string{"a", "a"}},
+		{Broker: "mqtts://broker", Devices: []string{"a"}},
+		{Devices: []string{"a"}},
+	} {
+		if err := c.check(); err == nil {
+			t.Errorf("Expected %+v refused", c)
+		}
+	}
+
+	c := bricksConfig{Broker: "mqtt://u:p@broker", Devices: []string{"left"}}
+	if err := c.check(); err != nil {
+		t.Fatal(err)
+	}
+	if c.addr != "broker:1883" || c.User != "u" || c.Password != "p" || c.Topic != "ledbrick/bricks" {
+		t.Errorf("Expected the broker's address and credentials from its URL, got %+v", c)
+	}
+	mqttAddr, mqttUser = "bridge:1883", "bridge"
+	c = bricksConfig{Topic: "tank/", Devices: []string{"left"}}
+	if err := c.check(); err != nil {
+		t.Fatal(err)
+	}
+	if c.addr != "bridge:1883" || c.User != "bridge" || c.Topic != "tank" {
+		t.Errorf("Expected the bridge's broker, got %+v", c)
+	}
+}
+
+func TestBrickLink(t *testing.T) {
+	conn, broker := net.Pipe()
+	defer broker.Close()
+	packets := make(chan string, 4)
+	go func() {
+		for {
+			header, body, err := readPacket(broker)
+			if err != nil {
+				return
+			}
+			switch header & 0xf0 {
+			case mqttSubscribe & 0xf0:
+				packets <- "subscribe " + string(body[4:len(body)-1])
+			case mqttPublish:
+				topic, payload, _ := parsePublish(header, body)
+				packets <- topic + " " + string(payload)
+			}
+		}
+	}()
+	l, err := newBrickLink(conn, "ledbrick/bricks/left")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+	if p := <-packets; p != "subscribe ledbrick/bricks/left/#" {
+		t.Errorf("Expected the brick's topics subscribed, got %s", p)
+	}
+
+	writePublish(broker, "ledbrick/bricks/left/temperature", []byte("30.4"), true)
+	writePublish(broker, "ledbrick/bricks/left/fan_rpm", []byte("1000"), true)
+	buf := make([]byte, 1500)
+	for _, want := range [][]byte{{'V', 'T', 30}, {'V', 'F', 0xe8, 0x03}} {
+		deadline := time.Now().Add(2 * time.Second)
+		for {
+			l.lock.Lock()
+			_, ok := l.telemetry[want[1]]
+			l.lock.Unlock()
+			if ok || time.Now().After(deadline) {
+				break
+			}
+			time.Sleep(time.Millisecond)
+		}
+		l.Write([]byte{'R', want[1]})
+		n, err := l.Read(buf)
+		if err != nil || !bytes.Equal(buf[:n], want) {
+			t.Errorf("Expected % x from telemetry, got % x %v", want, buf[:n], err)
+		}
+	}
+
+	// Anything else is a request to the brick
+	go l.Write([]byte{'w', 'L', 50})
+	if p := <-packets; p != "ledbrick/bricks/left/command wL2" {
+		t.Errorf("Expected the channel values published, got %q", p)
+	}
+	writePublish(broker, "ledbrick/bricks/left/reply", []byte("AL"), false)
+	n, err := l.Read(buf)
+	if err != nil || string(buf[:n]) != "AL" {
+		t.Errorf("Expected the brick's reply, got %q %v", buf[:n], err)
+	}
+
+	broker.Close()
+	if _, err := l.Read(buf); err != io.EOF {
+		t.Errorf("Expected the link closed with the connection, got %v", err)
+	}
+}
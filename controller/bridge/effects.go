@@ -0,0 +1,81 @@
+package bridge
+
+import (
+	"encoding/json"
+	"flag"
+	"log"
+	"math"
+	"time"
+
+	"github.com/theatrus/ledbrick/controller/ble"
+)
+
+var effectsInterval time.Duration
+
+func init() {
+	flag.DurationVar(&effectsInterval, "bridge.effects.interval", time.Second,
+		"How often to check for effect changes to publish for wavemakers and other gear, 0 to disable")
+}
+
+// effectState is published, retained, as JSON to ledbrick/effects
+// whenever it changes, so wavemakers and other gear can follow what the
+// lights are doing:
+//
+//	{"suspended": false, "intensity": 42.5, "channels": {"0": 40, ...}}
+//
+// Intensity is the mean of the channels, a rough measure of how bright
+// the tank is which is easy to map onto a flow rate.
+type effectState struct {
+	Suspended bool            `json:"suspended"`
+	Intensity float64         `json:"intensity"`
+	Channels  map[int]float64 `json:"channels"`
+}
+
+func effectsNow(b ble.BLEChannel) effectState {
+	s := effectState{Suspended: b.EffectsSuspended(), Channels: b.Channels()}
+	for _, v := range s.Channels {
+		s.Intensity += v
+	}
+	if len(s.Channels) > 0 {
+		s.Intensity /= float64(len(s.Channels))
+	}
+	return s
+}
+
+// changed reports whether two states differ by enough to publish,
+// ignoring changes below half a percent so a slow ramp isn't sent every
+// check.
+func (s effectState) changed(last effectState) bool {
+	if s.Suspended != last.Suspended || len(s.Channels) != len(last.Channels) {
+		return true
+	}
+	for channel, v := range s.Channels {
+		if math.Abs(v-last.Channels[channel]) >= 0.5 {
+			return true
+		}
+	}
+	return false
+}
+
+// publishEffects sends the effect state whenever it changes.
+func (br *Bridge) publishEffects() {
+	var last effectState
+	for _ = range time.Tick(effectsInterval) {
+		s := effectsNow(br.ble)
+		if last.Channels != nil && !s.changed(last) {
+			continue
+		}
+		data, err := json.Marshal(s)
+		if err != nil {
+			continue
+		}
+		br.lock.Lock()
+		err = br.client.publish(topicPrefix+"/effects", data, true)
+		br.lock.Unlock()
+		if err != nil {
+			log.Printf("Failed to publish effects to MQTT broker %s: %v", mqttAddr, err)
+			continue
+		}
+		last = s
+	}
+}
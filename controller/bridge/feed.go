@@ -0,0 +1,134 @@
+package bridge
+
+import (
+	"errors"
+	"flag"
+	"log"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/theatrus/ledbrick/controller/alert"
+	"github.com/theatrus/ledbrick/controller/ble"
+	"github.com/theatrus/ledbrick/controller/plugs"
+)
+
+var feedTopic string
+var feedPercent float64
+
+func init() {
+	flag.StringVar(&feedTopic, "bridge.feed.topic", "",
+		"MQTT topic carrying a reef controller's feed or maintenance mode, e.g. apex/feed")
+	flag.Float64Var(&feedPercent, "bridge.feed.percent", 30,
+		"Output percent to limit all fixtures to while feed mode is on")
+}
+
+const feedName = "feed"
+
+// How often the feed subscription is pinged. A broker which hasn't
+// sent anything for this long, a ping reply at least, is gone.
+var feedKeepAlive = 30 * time.Second
+
+// feedMode follows an external feed or maintenance mode signal, so one
+// button on the tank controller handles pumps and lights together.
+// While the mode is on output is limited and effects are held off.
+type feedMode struct {
+	ble    ble.BLEChannel
+	client *mqttClient
+	on     bool
+	lock   sync.Mutex
+}
+
+func (f *feedMode) run() {
+	for {
+		if err := f.follow(); err != nil {
+			log.Printf("Feed mode subscription to %s failed: %v", feedTopic, err)
+		}
+		time.Sleep(10 * time.Second)
+	}
+}
+
+// follow subscribes to the feed topic and applies every message until
+// the connection drops, or the broker stops answering pings. The mode
+// is left as it was on a dropped connection, much like a UPS daemon
+// restarting.
+func (f *feedMode) follow() error {
+	conn, err := f.client.dial()
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+	if err := writeSubscribe(conn, feedTopic); err != nil {
+		return err
+	}
+	done := make(chan struct{})
+	defer close(done)
+	go func() {
+		ticker := time.NewTicker(feedKeepAlive / 2)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ticker.C:
+			case <-done:
+				return
+			}
+			conn.SetWriteDeadline(time.Now().Add(5 * time.Second))
+			if err := writePing(conn); err != nil {
+				conn.Close()
+				return
+			}
+		}
+	}()
+	for {
+		conn.SetReadDeadline(time.Now().Add(feedKeepAlive))
+		header, body, err := readPacket(conn)
+		if err != nil {
+			return err
+		}
+		switch header & 0xf0 {
+		case mqttSuback:
+			if len(body) == 3 && body[2] == 0x80 {
+				return errors.New("subscription refused")
+			}
+		case mqttPublish:
+			topic, payload, err := parsePublish(header, body)
+			if err != nil {
+				return err
+			}
+			if topic == feedTopic {
+				f.set(parseFeed(string(payload)))
+			}
+		}
+	}
+}
+
+func (f *feedMode) set(on bool) {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+	if on == f.on {
+		return
+	}
+	f.on = on
+	if on {
+		f.ble.SetLimit(feedName, feedPercent)
+		f.ble.SuspendEffects(feedName)
+		plugs.Activate(feedName)
+		alert.Raise(alert.Info, feedName, "feed.mode", "feed mode on, limiting output to %.0f%%", feedPercent)
+	} else {
+		f.ble.ClearLimit(feedName)
+		f.ble.ResumeEffects(feedName)
+		plugs.Deactivate(feedName)
+		alert.Raise(alert.Info, feedName, "feed.mode", "feed mode off")
+	}
+}
+
+// parseFeed reads the common ways controllers publish an outlet or
+// switch state, such as "1", "ON" or "true". Apex outlets publishing
+// AON or TBL count as on.
+func parseFeed(payload string) bool {
+	switch strings.ToLower(strings.TrimSpace(payload)) {
+	case "1", "on", "true", "aon", "tbl", "feed":
+		return true
+	}
+	return false
+}
@@ -0,0 +1,204 @@
+package bridge
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"time"
+)
+
+// mqttClient is the small subset of MQTT 3.1.1 needed to publish state
+// and follow a few topics: connect, QoS 0 publishes and subscriptions,
+// and pings.
+type mqttClient struct {
+	addr     string
+	clientID string
+	user     string
+	password string
+	// keepAlive is how often the client promises to send something, 0
+	// for no keep alive
+	keepAlive time.Duration
+	conn      net.Conn
+}
+
+const (
+	mqttConnect   = 0x10
+	mqttConnack   = 0x20
+	mqttPublish   = 0x30
+	mqttRetain    = 0x01
+	mqttSubscribe = 0x82
+	mqttSuback    = 0x90
+	mqttPingreq   = 0xc0
+	mqttPingresp  = 0xd0
+)
+
+// publish sends a message, connecting first if needed. A failed write
+// drops the connection so the next publish reconnects.
+func (c *mqttClient) publish(topic string, payload []byte, retain bool) error {
+	if c.conn == nil {
+		conn, err := c.dial()
+		if err != nil {
+			return err
+		}
+		c.conn = conn
+	}
+	c.conn.SetWriteDeadline(time.Now().Add(5 * time.Second))
+	if err := writePublish(c.conn, topic, payload, retain); err != nil {
+		c.conn.Close()
+		c.conn = nil
+		return err
+	}
+	return nil
+}
+
+// dial opens a new connection to the broker and completes the connect
+// handshake.
+func (c *mqttClient) dial() (net.Conn, error) {
+	conn, err := net.DialTimeout("tcp", c.addr, 5*time.Second)
+	if err != nil {
+		return nil, err
+	}
+	conn.SetDeadline(time.Now().Add(5 * time.Second))
+	if err := c.connect(conn); err != nil {
+		conn.Close()
+		return nil, err
+	}
+	conn.SetDeadline(time.Time{})
+	return conn, nil
+}
+
+func (c *mqttClient) connect(rw io.ReadWriter) error {
+	var flags byte = 0x02 // clean session
+	if c.user != "" {
+		flags |= 0x80
+		if c.password != "" {
+			flags |= 0x40
+		}
+	}
+	body := appendString(nil, "MQTT")
+	// Protocol level 4, then the keep alive in seconds. Publishers
+	// leave it 0 as they publish regularly
+	keepAlive := int(c.keepAlive / time.Second)
+	body = append(body, 4, flags, byte(keepAlive>>8), byte(keepAlive))
+	body = appendString(body, c.clientID)
+	if c.user != "" {
+		body = appendString(body, c.user)
+		if c.password != "" {
+			body = appendString(body, c.password)
+		}
+	}
+	if err := writePacket(rw, mqttConnect, body); err != nil {
+		return err
+	}
+
+	ack := make([]byte, 4)
+	if _, err := io.ReadFull(rw, ack); err != nil {
+		return err
+	}
+	if ack[0] != mqttConnack || ack[1] != 2 {
+		return errors.New("mqtt: unexpected reply to connect")
+	}
+	if ack[3] != 0 {
+		return fmt.Errorf("mqtt: connection refused, code %d", ack[3])
+	}
+	return nil
+}
+
+func writePublish(w io.Writer, topic string, payload []byte, retain bool) error {
+	var header byte = mqttPublish
+	if retain {
+		header |= mqttRetain
+	}
+	body := appendString(nil, topic)
+	body = append(body, payload...)
+	return writePacket(w, header, body)
+}
+
+// writeSubscribe asks for QoS 0 delivery of a single topic filter.
+func writeSubscribe(w io.Writer, topic string) error {
+	// Packet identifier 1, we only ever have one subscription
+	body := appendString([]byte{0, 1}, topic)
+	body = append(body, 0)
+	return writePacket(w, mqttSubscribe, body)
+}
+
+func writePing(w io.Writer) error {
+	return writePacket(w, mqttPingreq, nil)
+}
+
+// readPacket reads one packet, returning the fixed header byte and the
+// rest of the packet.
+func readPacket(r io.Reader) (byte, []byte, error) {
+	var b [1]byte
+	if _, err := io.ReadFull(r, b[:]); err != nil {
+		return 0, nil, err
+	}
+	header := b[0]
+	n := 0
+	for shift := uint(0); ; shift += 7 {
+		if shift > 21 {
+			return 0, nil, errors.New("mqtt: bad remaining length")
+		}
+		if _, err := io.ReadFull(r, b[:]); err != nil {
+			return 0, nil, err
+		}
+		n |= int(b[0]&0x7f) << shift
+		if b[0]&0x80 == 0 {
+			break
+		}
+	}
+	body := make([]byte, n)
+	if _, err := io.ReadFull(r, body); err != nil {
+		return 0, nil, err
+	}
+	return header, body, nil
+}
+
+// parsePublish splits the body of a publish packet into its topic and
+// payload.
+func parsePublish(header byte, body []byte) (string, []byte, error) {
+	if len(body) < 2 {
+		return "", nil, errors.New("mqtt: short publish")
+	}
+	n := int(body[0])<<8 | int(body[1])
+	if len(body) < 2+n {
+		return "", nil, errors.New("mqtt: short publish")
+	}
+	topic := string(body[2 : 2+n])
+	payload := body[2+n:]
+	// QoS 1 and 2 messages carry a packet identifier before the payload
+	if header&0x06 != 0 {
+		if len(payload) < 2 {
+			return "", nil, errors.New("mqtt: short publish")
+		}
+		payload = payload[2:]
+	}
+	return topic, payload, nil
+}
+
+// writePacket writes a fixed header, with the remaining length encoded
+// 7 bits at a time, followed by the body.
+func writePacket(w io.Writer, header byte, body []byte) error {
+	packet := []byte{header}
+	n := len(body)
+	for {
+		b := byte(n % 128)
+		n /= 128
+		if n > 0 {
+			b |= 0x80
+		}
+		packet = append(packet, b)
+		if n == 0 {
+			break
+		}
+	}
+	packet = append(packet, body...)
+	_, err := w.Write(packet)
+	return err
+}
+
+func appendString(b []byte, s string) []byte {
+	b = append(b, byte(len(s)>>8), byte(len(s)))
+	return append(b, s...)
+}
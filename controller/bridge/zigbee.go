@@ -0,0 +1,119 @@
+package bridge
+
+import (
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"log"
+	"math"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/theatrus/ledbrick/controller/ble"
+)
+
+var zigbeeDevices zigbeeMap
+var zigbeeTopic string
+var zigbeeInterval time.Duration
+
+func init() {
+	flag.Var(&zigbeeDevices, "bridge.zigbee.devices",
+		"Comma separated zigbee2mqtt dimmers and the channel each follows, e.g. moonlights=7,accent=3")
+	flag.StringVar(&zigbeeTopic, "bridge.zigbee.topic", "zigbee2mqtt", "Base topic of zigbee2mqtt")
+	flag.DurationVar(&zigbeeInterval, "bridge.zigbee.interval", 10*time.Second,
+		"How often Zigbee dimmers are brought in line with their channels")
+}
+
+// zigbeeMap is a flag of Zigbee device friendly names and the channel
+// each follows.
+type zigbeeMap map[string]int
+
+func (z *zigbeeMap) String() string {
+	var parts []string
+	for name, channel := range *z {
+		parts = append(parts, fmt.Sprintf("%s=%d", name, channel))
+	}
+	sort.Strings(parts)
+	return strings.Join(parts, ",")
+}
+
+func (z *zigbeeMap) Set(value string) error {
+	m := make(zigbeeMap)
+	for _, part := range strings.Split(value, ",") {
+		kv := strings.SplitN(strings.TrimSpace(part), "=", 2)
+		if len(kv) != 2 || kv[0] == "" {
+			return errors.New("devices must be name=channel")
+		}
+		channel, err := strconv.Atoi(kv[1])
+		if err != nil || channel < 0 || channel > 7 {
+			return fmt.Errorf("bad channel for %s", kv[0])
+		}
+		m[kv[0]] = channel
+	}
+	*z = m
+	return nil
+}
+
+// zigbeeOutput drives Zigbee dimmers through zigbee2mqtt, each following
+// one channel, so moonlights or accent strips track the tank. A dimmer
+// is only sent its brightness when it changes, and again every publish
+// interval in case it missed one.
+type zigbeeOutput struct {
+	ble    ble.BLEChannel
+	client *mqttClient
+	sent   map[string]int
+	at     time.Time
+}
+
+// zigbeeSet is a zigbee2mqtt set payload.
+type zigbeeSet struct {
+	State      string  `json:"state"`
+	Brightness int     `json:"brightness"`
+	Transition float64 `json:"transition"`
+}
+
+// zigbeeBrightness maps a channel percent onto the 0-254 brightness
+// zigbee2mqtt uses.
+func zigbeeBrightness(percent float64) int {
+	return int(math.Min(math.Max(percent, 0), 100)/100*254 + 0.5)
+}
+
+func (z *zigbeeOutput) run() {
+	for _ = range time.Tick(zigbeeInterval) {
+		if err := z.update(time.Now()); err != nil {
+			log.Printf("Failed to set Zigbee devices through %s: %v", mqttAddr, err)
+		}
+	}
+}
+
+// update publishes the brightness of every dimmer whose channel has
+// changed, or all of them when a refresh is due.
+func (z *zigbeeOutput) update(now time.Time) error {
+	refresh := now.Sub(z.at) >= publishInterval
+	if refresh {
+		z.at = now
+	}
+	channels := z.ble.Channels()
+	for name, channel := range zigbeeDevices {
+		brightness := zigbeeBrightness(channels[channel])
+		if sent, ok := z.sent[name]; ok && sent == brightness && !refresh {
+			continue
+		}
+		set := zigbeeSet{State: "ON", Brightness: brightness, Transition: zigbeeInterval.Seconds()}
+		if brightness == 0 {
+			set.State = "OFF"
+		}
+		data, err := json.Marshal(set)
+		if err != nil {
+			return err
+		}
+		if err := z.client.publish(zigbeeTopic+"/"+name+"/set", data, false); err != nil {
+			return err
+		}
+		z.sent[name] = brightness
+	}
+	return nil
+}
@@ -0,0 +1,31 @@
+package main
+
+import (
+	"encoding/json"
+	"github.com/theatrus/ledbrick/controller/ble"
+	"github.com/theatrus/ledbrick/controller/clock"
+	"io"
+	"time"
+)
+
+// runBurnIn burns in a fixture in place of the schedule and prints the
+// report, as a table or JSON.
+func runBurnIn(b ble.BLEChannel, id string, w io.Writer) {
+	for channel := 0; channel <= 7; channel++ {
+		b.SetChannel(channel, 0)
+	}
+	r := ble.RunBurnIn(b, id, ble.BurnInConfig{
+		Duration:       *burnInDuration,
+		Percent:        *burnInPercent,
+		MaxTemperature: *burnInMax,
+		Sample:         time.Minute,
+		Silent:         5 * time.Minute,
+	}, clock.Real)
+	if *format == "json" {
+		e := json.NewEncoder(w)
+		e.SetIndent("", "  ")
+		e.Encode(r)
+		return
+	}
+	r.Write(w)
+}
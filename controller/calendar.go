@@ -0,0 +1,51 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"github.com/theatrus/ledbrick/controller/ltable"
+	"io/ioutil"
+)
+
+// followCalendar switches the lights between profiles by date if a
+// calendar is configured, returning how to reload the running table.
+// With a calendar that is the day's profile rather than -config.
+func followCalendar(lights *ltable.LightDriver) ([]func() error, error) {
+	if *calendar == "" {
+		return []func() error{applyFile(lights, *config)}, nil
+	}
+	data, err := ioutil.ReadFile(*calendar)
+	if err != nil {
+		return nil, err
+	}
+	c, err := ltable.ParseCalendar(data)
+	if err != nil {
+		return nil, err
+	}
+	return []func() error{lights.FollowCalendar(c, *config).Reload}, nil
+}
+
+// subscribeCalendar follows the -calendar.ical feed if one is set,
+// caching it beside -config.
+func subscribeCalendar(lights *ltable.LightDriver) error {
+	if *calendarFeed == "" {
+		return nil
+	}
+	scenes := make(map[string][]float64)
+	if *calendarScenes != "" {
+		data, err := ioutil.ReadFile(*calendarScenes)
+		if err != nil {
+			return err
+		}
+		if err := json.Unmarshal(data, &scenes); err != nil {
+			return fmt.Errorf("%s: %v", *calendarScenes, err)
+		}
+		for name, percents := range scenes {
+			if len(percents) != ltable.Channels {
+				return fmt.Errorf("%s: scene %s needs %d percents", *calendarScenes, name, ltable.Channels)
+			}
+		}
+	}
+	lights.Subscribe(*calendarFeed, *config+".ical", *calendarPoll, scenes)
+	return nil
+}
@@ -0,0 +1,34 @@
+package main
+
+import (
+	"github.com/theatrus/ledbrick/controller/ltable"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+)
+
+// runChart draws the configured schedule to a file, with the format
+// taken from its extension, or as SVG to stdout.
+func runChart(out string) error {
+	data, err := ioutil.ReadFile(*config)
+	if err != nil {
+		return err
+	}
+	if out == "" {
+		return ltable.RenderChart(data, "svg", os.Stdout)
+	}
+	f, err := os.Create(out)
+	if err != nil {
+		return err
+	}
+	format := filepath.Ext(out)
+	if format != "" {
+		format = format[1:]
+	}
+	if err := ltable.RenderChart(data, format, f); err != nil {
+		f.Close()
+		os.Remove(out)
+		return err
+	}
+	return f.Close()
+}
@@ -0,0 +1,142 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"github.com/theatrus/ledbrick/controller/ble"
+	"github.com/theatrus/ledbrick/controller/ltable"
+	"github.com/theatrus/ledbrick/controller/spectrum"
+	"io"
+	"io/ioutil"
+	"sort"
+)
+
+// checkReport is everything checkConfig found, as written for
+// -format=json.
+type checkReport struct {
+	File     string         `json:"file"`
+	OK       bool           `json:"ok"`
+	Problems []string       `json:"problems"`
+	Schedule *checkSchedule `json:"schedule,omitempty"`
+	Fixtures []checkFixture `json:"fixtures"`
+}
+
+type checkSchedule struct {
+	Points      int           `json:"points"`
+	Photoperiod ble.Duration  `json:"photoperiod"`
+	First       string        `json:"first,omitempty"`
+	Last        string        `json:"last,omitempty"`
+	Peaks       []float64     `json:"peaks"`
+	PeakAt      []string      `json:"peak_at"`
+	Lints       []ltable.Lint `json:"lints"`
+}
+
+type checkFixture struct {
+	ID       string `json:"id"`
+	Model    string `json:"model,omitempty"`
+	Expected bool   `json:"expected"`
+}
+
+// checkConfig validates the lighting table and fixture settings,
+// printing a summary in the given format. It returns false if there
+// were any problems.
+func checkConfig(w io.Writer, file string, format string) bool {
+	report := runChecks(file)
+	if format == "json" {
+		e := json.NewEncoder(w)
+		e.SetIndent("", "  ")
+		e.Encode(report)
+		return report.OK
+	}
+
+	for _, p := range report.Problems {
+		fmt.Fprintln(w, p)
+	}
+	if s := report.Schedule; s != nil {
+		fmt.Fprintf(w, "%s: %d setting points\n", file, s.Points)
+		if s.Photoperiod.Duration > 0 {
+			fmt.Fprintf(w, "Photoperiod %s, %s to %s\n", s.Photoperiod.Duration, s.First, s.Last)
+		} else {
+			fmt.Fprintf(w, "Photoperiod 0, lights never come on\n")
+		}
+		fmt.Fprintf(w, "Channel  Peak    At\n")
+		for channel := range s.Peaks {
+			fmt.Fprintf(w, "%7d  %5.1f%%  %s\n", channel, s.Peaks[channel], s.PeakAt[channel])
+		}
+		if len(s.Lints) > 0 {
+			fmt.Fprintf(w, "%d suggestions\n", len(s.Lints))
+		}
+		for _, l := range s.Lints {
+			fmt.Fprintf(w, "  %s: %s\n    %s\n", file, l, l.Suggestion)
+		}
+	}
+	if report.Fixtures != nil {
+		fmt.Fprintf(w, "%d fixtures configured\n", len(report.Fixtures))
+		for _, f := range report.Fixtures {
+			fmt.Fprintf(w, "  %s", f.ID)
+			if f.Model != "" {
+				fmt.Fprintf(w, " model %s", f.Model)
+			}
+			if f.Expected {
+				fmt.Fprintf(w, " expected")
+			}
+			fmt.Fprintln(w)
+		}
+	}
+	if report.OK {
+		fmt.Fprintln(w, "OK")
+	}
+	return report.OK
+}
+
+func runChecks(file string) checkReport {
+	report := checkReport{File: file, OK: true, Problems: []string{}}
+	problem := func(format string, args ...interface{}) {
+		report.OK = false
+		report.Problems = append(report.Problems, fmt.Sprintf(format, args...))
+	}
+
+	data, err := ioutil.ReadFile(file)
+	if err != nil {
+		problem("%s: %v", file, err)
+		return report
+	}
+
+	summary, errs := ltable.Check(data)
+	for _, err := range errs {
+		problem("%s: %v", file, err)
+	}
+	if len(errs) == 0 {
+		report.Schedule = &checkSchedule{Points: summary.Points,
+			Photoperiod: ble.Duration{Duration: summary.Photoperiod},
+			First:       summary.First,
+			Last:        summary.Last,
+			Peaks:       summary.Peaks[:],
+			PeakAt:      summary.PeakAt[:],
+			Lints:       summary.Lints,
+		}
+	}
+
+	if _, err := ble.LoadProfiles(); err != nil {
+		problem("Device profiles: %v", err)
+	}
+	if _, err := spectrum.Load(); err != nil {
+		problem("Channel spectra: %v", err)
+	}
+	fixtures, err := ble.LoadFixtures()
+	if err != nil {
+		problem("Fixture settings: %v", err)
+		return report
+	}
+	ids := make([]string, 0, len(fixtures))
+	for id := range fixtures {
+		ids = append(ids, id)
+	}
+	sort.Strings(ids)
+	report.Fixtures = make([]checkFixture, 0, len(ids))
+	for _, id := range ids {
+		c := fixtures[id]
+		report.Fixtures = append(report.Fixtures, checkFixture{ID: id, Model: c.Model, Expected: c.Expected})
+	}
+	return report
+}
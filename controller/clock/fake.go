@@ -0,0 +1,111 @@
+package clock
+
+import (
+	"sync"
+	"time"
+)
+
+// Fake is a Clock which only moves when told to, firing tickers and
+// timers as it passes them.
+type Fake struct {
+	mu      sync.Mutex
+	now     time.Time
+	waiters []*waiter
+}
+
+// waiter is a ticker if period is set, otherwise a timer.
+type waiter struct {
+	fake   *Fake
+	at     time.Time
+	period time.Duration
+	c      chan time.Time
+	f      func()
+}
+
+func NewFake(now time.Time) *Fake {
+	return &Fake{now: now}
+}
+
+func (f *Fake) Now() time.Time {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.now
+}
+
+func (f *Fake) NewTicker(d time.Duration) Ticker {
+	if d <= 0 {
+		panic("clock: non-positive interval for NewTicker")
+	}
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	w := &waiter{fake: f, at: f.now.Add(d), period: d, c: make(chan time.Time, 1)}
+	f.waiters = append(f.waiters, w)
+	return fakeTicker{w}
+}
+
+func (f *Fake) AfterFunc(d time.Duration, fn func()) Timer {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	w := &waiter{fake: f, at: f.now.Add(d), f: fn}
+	f.waiters = append(f.waiters, w)
+	return w
+}
+
+// Advance moves the clock forward, firing everything due on the way in
+// order. Like time.Ticker, a ticker whose last tick hasn't been read
+// drops the next. Timer functions run before Advance returns.
+func (f *Fake) Advance(d time.Duration) {
+	f.mu.Lock()
+	end := f.now.Add(d)
+	for {
+		var next *waiter
+		for _, w := range f.waiters {
+			if !w.at.After(end) && (next == nil || w.at.Before(next.at)) {
+				next = w
+			}
+		}
+		if next == nil {
+			break
+		}
+		f.now = next.at
+		if next.period > 0 {
+			next.at = next.at.Add(next.period)
+			select {
+			case next.c <- f.now:
+			default:
+			}
+			continue
+		}
+		f.remove(next)
+		f.mu.Unlock()
+		next.f()
+		f.mu.Lock()
+	}
+	f.now = end
+	f.mu.Unlock()
+}
+
+// remove must be called with the lock held, and reports whether the
+// waiter was still waiting.
+func (f *Fake) remove(w *waiter) bool {
+	for i, o := range f.waiters {
+		if o == w {
+			f.waiters = append(f.waiters[:i], f.waiters[i+1:]...)
+			return true
+		}
+	}
+	return false
+}
+
+type fakeTicker struct {
+	*waiter
+}
+
+func (t fakeTicker) C() <-chan time.Time { return t.c }
+func (t fakeTicker) Stop()               { t.waiter.Stop() }
+
+func (w *waiter) Stop() bool {
+	w.fake.mu.Lock()
+	defer w.fake.mu.Unlock()
+	return w.fake.remove(w)
+}
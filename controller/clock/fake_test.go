@@ -0,0 +1,45 @@
+package clock
+
+import (
+	"testing"
+	"time"
+)
+
+func TestFake(t *testing.T) {
+	start := time.Date(2016, 1, 1, 0, 0, 0, 0, time.UTC)
+	f := NewFake(start)
+	ticker := f.NewTicker(time.Second)
+	var fired []time.Time
+	f.AfterFunc(1500*time.Millisecond, func() { fired = append(fired, f.Now()) })
+	stopped := f.AfterFunc(time.Second, func() { t.Error("Expected a stopped timer not to fire") })
+	if !stopped.Stop() {
+		t.Error("Expected stopping a waiting timer to report it")
+	}
+
+	f.Advance(time.Second)
+	if got := <-ticker.C(); !got.Equal(start.Add(time.Second)) {
+		t.Errorf("Unexpected tick %s", got)
+	}
+	f.Advance(3 * time.Second)
+	if len(fired) != 1 || !fired[0].Equal(start.Add(1500*time.Millisecond)) {
+		t.Errorf("Expected the timer to fire once at its time, got %v", fired)
+	}
+	// Unread ticks are dropped
+	<-ticker.C()
+	select {
+	case <-ticker.C():
+		t.Error("Expected only one tick to be buffered")
+	default:
+	}
+	if !f.Now().Equal(start.Add(4 * time.Second)) {
+		t.Errorf("Unexpected time %s", f.Now())
+	}
+
+	ticker.Stop()
+	f.Advance(time.Second)
+	select {
+	case <-ticker.C():
+		t.Error("Expected a stopped ticker not to tick")
+	default:
+	}
+}
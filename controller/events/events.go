@@ -0,0 +1,158 @@
+// Package events is the controller's event bus: alerts, channel changes
+// and lighting tables applied are published to every registered sink,
+// such as a NATS server, in one stable schema, so several controllers
+// can be gathered up and analysed off the device.
+package events
+
+import (
+	"flag"
+	"log"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/theatrus/ledbrick/controller/alert"
+)
+
+var controller string
+
+func init() {
+	host, _ := os.Hostname()
+	flag.StringVar(&controller, "events.controller", host,
+		"Name of this controller in published events, the host name by default")
+}
+
+// Schema is the version of Event, changed only when a field changes
+// meaning or goes away.
+const Schema = "ledbrick.event.v1"
+
+// Types of event
+const (
+	// Alert events carry the alert.Alert
+	Alert = "alert"
+	// Channels events carry ChannelsData
+	Channels = "channels"
+	// Schedule events carry ScheduleData
+	Schedule = "schedule"
+	// Connection events carry ConnectionData
+	Connection = "connection"
+	// Effects events carry EffectsData
+	Effects = "effects"
+)
+
+// ChannelsData is every channel's new setting, when any has changed by
+// a percent or more.
+type ChannelsData struct {
+	Percents []float64 `json:"percents"`
+}
+
+// ScheduleData describes a lighting table applied.
+type ScheduleData struct {
+	Points int `json:"points"`
+}
+
+// ConnectionData is a fixture connecting or disconnecting.
+type ConnectionData struct {
+	Fixture   string `json:"fixture"`
+	Connected bool   `json:"connected"`
+}
+
+// EffectsData is effects, such as lightning, being suspended or
+// resumed by something.
+type EffectsData struct {
+	By        string `json:"by"`
+	Suspended bool   `json:"suspended"`
+	// Tank is set when only one tank's effects are
+	Tank string `json:"tank,omitempty"`
+}
+
+// Event is something which happened on a controller.
+type Event struct {
+	Schema     string      `json:"schema"`
+	Controller string      `json:"controller"`
+	Type       string      `json:"type"`
+	At         time.Time   `json:"at"`
+	Data       interface{} `json:"data"`
+}
+
+// Sink receives every event published, and must not block.
+type Sink interface {
+	Publish(e Event)
+}
+
+var sinks []Sink
+var alertsPublished bool
+var lock sync.Mutex
+
+// Register adds a sink which will receive every event published. The
+// first sink registered also starts alerts being published.
+func Register(s Sink) {
+	lock.Lock()
+	defer lock.Unlock()
+	if !alertsPublished {
+		alert.RegisterSync(alertNotifier{})
+		alertsPublished = true
+	}
+	sinks = append(sinks, s)
+}
+
+// Unregister stops a sink receiving events, for one which only listens
+// for a while.
+func Unregister(s Sink) {
+	lock.Lock()
+	defer lock.Unlock()
+	// Copied, as Publish may be ranging over the old slice
+	var kept []Sink
+	for _, other := range sinks {
+		if other != s {
+			kept = append(kept, other)
+		}
+	}
+	sinks = kept
+}
+
+// Publish sends an event of a type to every sink.
+func Publish(kind string, data interface{}) {
+	lock.Lock()
+	current := sinks
+	lock.Unlock()
+	if len(current) == 0 {
+		return
+	}
+	e := Event{Schema: Schema, Controller: controller, Type: kind, At: time.Now().UTC(), Data: data}
+	for _, s := range current {
+		s.Publish(e)
+	}
+}
+
+type alertNotifier struct{}
+
+func (alertNotifier) Notify(a alert.Alert) error {
+	Publish(Alert, a)
+	return nil
+}
+
+// queue hands events to a sender goroutine, dropping them rather than
+// holding up the controller when the sender falls behind.
+type queue struct {
+	events  chan Event
+	dropped int
+	mu      sync.Mutex
+}
+
+func newQueue(size int) *queue {
+	return &queue{events: make(chan Event, size)}
+}
+
+func (q *queue) Publish(e Event) {
+	select {
+	case q.events <- e:
+	default:
+		q.mu.Lock()
+		q.dropped++
+		if q.dropped == 1 || q.dropped%100 == 0 {
+			log.Printf("Event queue full, %d events dropped", q.dropped)
+		}
+		q.mu.Unlock()
+	}
+}
@@ -0,0 +1,92 @@
+package events
+
+import (
+	"bufio"
+	"encoding/json"
+	"net"
+	"net/url"
+	"strings"
+	"testing"
+
+	"github.com/theatrus/ledbrick/controller/alert"
+)
+
+type fakeSink []Event
+
+func (f *fakeSink) Publish(e Event) { *f = append(*f, e) }
+
+func TestPublish(t *testing.T) {
+	var sink fakeSink
+	Register(&sink)
+	defer func() { sinks = nil }()
+	Publish(Schedule, ScheduleData{Points: 4})
+	alert.Raise(alert.Warning, "test", "events.test", "published")
+	if len(sink) != 2 || sink[0].Schema != Schema || sink[1].Type != Alert {
+		t.Fatalf("Expected a schedule event then an alert, got %+v", sink)
+	}
+	data, _ := json.Marshal(sink[0])
+	if !strings.Contains(string(data), `"data":{"points":4}`) {
+		t.Errorf("Expected the schedule's points in %s", data)
+	}
+
+	// Registering again doesn't publish alerts twice, and a sink
+	// unregistered hears no more
+	var other fakeSink
+	Register(&other)
+	alert.Raise(alert.Info, "test", "events.test", "once")
+	Unregister(&sink)
+	Publish(Schedule, ScheduleData{Points: 5})
+	if len(other) != 2 || len(sink) != 3 {
+		t.Errorf("Expected one alert each then the schedule only to the other, got %d and %d", len(other), len(sink))
+	}
+}
+
+func TestNATS(t *testing.T) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+	received := make(chan string, 1)
+	go func() {
+		c, err := l.Accept()
+		if err != nil {
+			return
+		}
+		defer c.Close()
+		r := bufio.NewReader(c)
+		c.Write([]byte("INFO {}\r\n"))
+		var lines []string
+		for {
+			line, err := r.ReadString('\n')
+			if err != nil {
+				return
+			}
+			lines = append(lines, strings.TrimSpace(line))
+			if strings.HasPrefix(line, "PING") {
+				c.Write([]byte("PONG\r\n"))
+				if len(lines) > 2 {
+					received <- strings.Join(lines, "\n")
+					return
+				}
+			}
+		}
+	}()
+
+	u, _ := url.Parse("nats://token@" + l.Addr().String())
+	conn, err := dialNATS(u)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	e := Event{Controller: "reef.pi", Type: Channels}
+	if err := conn.publish(natsSubjectFor(e), []byte(`{"x":1}`)); err != nil {
+		t.Fatal(err)
+	}
+	got := <-received
+	for _, want := range []string{`"auth_token":"token"`, "PUB ledbrick.reef_pi.channels 7\n{\"x\":1}"} {
+		if !strings.Contains(got, want) {
+			t.Errorf("Expected %q in %s", want, got)
+		}
+	}
+}
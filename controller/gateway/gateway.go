@@ -0,0 +1,148 @@
+// Package gateway drives fixtures bridged through an Ethernet gateway
+// which takes channel, value pairs over TCP or UDP: a byte for the
+// channel and a byte for its value, 0 to 250 for full. Each channel
+// write is sent as one pair, a TCP stream or a UDP datagram at a time.
+//
+// Gateways don't answer, so the link plays the fixture's side of the
+// Wi-Fi fixtures' protocol itself, as a fixture with LEDs and nothing
+// else. They run under the bluetooth channel like any other fixture,
+// each with its address as its ID, but have no telemetry.
+package gateway
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"net"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/theatrus/ledbrick/controller/ble"
+)
+
+var addrs string
+
+func init() {
+	flag.StringVar(&addrs, "gateway.addrs", "",
+		"Comma separated Ethernet gateways taking channel, value pairs, as tcp://host:port or udp://host:port")
+	ble.RegisterTransport("gateway", transport{})
+}
+
+// transport finds the -gateway.addrs.
+type transport struct{}
+
+func (transport) Find() map[string]ble.Dialer {
+	found := make(map[string]ble.Dialer)
+	for _, addr := range strings.Split(addrs, ",") {
+		if addr = strings.TrimSpace(addr); addr == "" {
+			continue
+		}
+		network, host, err := parseAddr(addr)
+		if err != nil {
+			found[addr] = func() (io.ReadWriteCloser, error) { return nil, err }
+			continue
+		}
+		found[host] = func() (io.ReadWriteCloser, error) {
+			conn, err := net.DialTimeout(network, host, 5*time.Second)
+			if err != nil {
+				return nil, err
+			}
+			return newLink(conn), nil
+		}
+	}
+	return found
+}
+
+// parseAddr splits a gateway's URL into its network and address.
+func parseAddr(addr string) (network, host string, err error) {
+	u, err := url.Parse(addr)
+	if err != nil || (u.Scheme != "tcp" && u.Scheme != "udp") || u.Port() == "" {
+		return "", "", fmt.Errorf("bad gateway %q, expected tcp://host:port or udp://host:port", addr)
+	}
+	return u.Scheme, u.Host, nil
+}
+
+// link answers the fixture protocol for a gateway, sending on the
+// channel writes.
+type link struct {
+	conn    net.Conn
+	replies chan []byte
+	done    chan struct{}
+	closing sync.Once
+}
+
+func newLink(conn net.Conn) *link {
+	return &link{conn: conn, replies: make(chan []byte, 8), done: make(chan struct{})}
+}
+
+// Write takes a request, sending LED writes on to the gateway and
+// answering the rest as a fixture with only LEDs.
+func (l *link) Write(b []byte) (int, error) {
+	if len(b) == 0 {
+		return 0, nil
+	}
+	switch {
+	case b[0] == '?':
+		l.reply([]byte("!L"))
+	case len(b) < 2:
+		return 0, fmt.Errorf("short request %q", b)
+	case (b[0] == 'W' || b[0] == 'w') && b[1] == 'L':
+		if err := l.send(b[2:]); err != nil {
+			return 0, err
+		}
+		if b[0] == 'w' {
+			l.reply([]byte{'A', 'L'})
+		}
+	case b[0] == 'R' && b[1] == 'L':
+		// Gateways can't be read back, so the LEDs read as nothing
+		l.reply([]byte{'V', 'L'})
+	case b[0] == 'w' || b[0] == 'R':
+		l.reply(append([]byte{'E', b[1]}, "not on a gateway"...))
+	}
+	return len(b), nil
+}
+
+// send writes channel, value pairs to the gateway, dropping the link
+// if that fails.
+func (l *link) send(pairs []byte) error {
+	if len(pairs) == 0 || len(pairs)%2 != 0 {
+		return errors.New("LED writes must be channel, value pairs")
+	}
+	l.conn.SetWriteDeadline(time.Now().Add(5 * time.Second))
+	if _, err := l.conn.Write(pairs); err != nil {
+		l.Close()
+		return err
+	}
+	return nil
+}
+
+func (l *link) reply(b []byte) {
+	select {
+	case l.replies <- b:
+	default:
+	}
+}
+
+// Read returns the next reply, which must fit in b.
+func (l *link) Read(b []byte) (int, error) {
+	select {
+	case reply := <-l.replies:
+		if len(reply) > len(b) {
+			return 0, fmt.Errorf("%d byte reply, read with room for %d", len(reply), len(b))
+		}
+		return copy(b, reply), nil
+	case <-l.done:
+		return 0, io.EOF
+	}
+}
+
+func (l *link) Close() error {
+	l.closing.Do(func() {
+		close(l.done)
+		l.conn.Close()
+	})
+	return nil
+}
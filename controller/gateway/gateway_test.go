@@ -0,0 +1,97 @@
+package gateway
+
+import (
+	"bytes"
+	"io"
+	"net"
+	"testing"
+	"time"
+)
+
+func TestLink(t *testing.T) {
+	conn, gw := net.Pipe()
+	l := newLink(conn)
+	buf := make([]byte, 1500)
+	exchange := func(req []byte) []byte {
+		if _, err := l.Write(req); err != nil {
+			t.Fatalf("Write %q: %v", req, err)
+		}
+		n, err := l.Read(buf)
+		if err != nil {
+			t.Fatalf("Read after %q: %v", req, err)
+		}
+		return buf[:n]
+	}
+	if got := exchange([]byte("?")); string(got) != "!L" {
+		t.Errorf("Expected a fixture with only LEDs, got %q", got)
+	}
+	if got := exchange([]byte("RL")); string(got) != "VL" {
+		t.Errorf("Expected the LEDs read as nothing, got %q", got)
+	}
+	if got := exchange([]byte("RT")); got[0] != 'E' || got[1] != 'T' {
+		t.Errorf("Expected no temperature, got %q", got)
+	}
+
+	sent := make(chan []byte, 2)
+	go func() {
+		b := make([]byte, 16)
+		for {
+			n, err := gw.Read(b)
+			if err != nil {
+				return
+			}
+			sent <- append([]byte(nil), b[:n]...)
+		}
+	}()
+	if got := exchange([]byte{'w', 'L', 3, 125}); string(got) != "AL" {
+		t.Errorf("Expected the write acknowledged, got %q", got)
+	}
+	if p := <-sent; !bytes.Equal(p, []byte{3, 125}) {
+		t.Errorf("Expected channel 3 at half sent, got % x", p)
+	}
+	l.Write([]byte{'W', 'L', 0, 250})
+	if p := <-sent; !bytes.Equal(p, []byte{0, 250}) {
+		t.Errorf("Expected channel 0 at full sent, got % x", p)
+	}
+	if _, err := l.Write([]byte{'w', 'L', 3}); err == nil {
+		t.Error("Expected half a pair refused")
+	}
+
+	gw.Close()
+	if _, err := l.Write([]byte{'W', 'L', 1, 10}); err == nil {
+		t.Error("Expected a write to a closed gateway to fail")
+	}
+	if _, err := l.Read(buf); err != io.EOF {
+		t.Errorf("Expected the link dropped, got %v", err)
+	}
+}
+
+func TestUDP(t *testing.T) {
+	gw, err := net.ListenUDP("udp4", &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer gw.Close()
+	defer func(a string) { addrs = a }(addrs)
+	addrs = "udp://" + gw.LocalAddr().String() + ", tcp://nowhere"
+	found := transport{}.Find()
+	dial, ok := found[gw.LocalAddr().String()]
+	if !ok || len(found) != 2 {
+		t.Fatalf("Expected the gateway and the bad address, got %v", found)
+	}
+	if _, err := found["tcp://nowhere"](); err == nil {
+		t.Error("Expected an address without a port refused")
+	}
+	l, err := dial()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+	l.Write([]byte{'W', 'L', 7, 25})
+	gw.SetReadDeadline(time.Now().Add(2 * time.Second))
+	b := make([]byte, 16)
+	n, err := gw.Read(b)
+	if err != nil || !bytes.Equal(b[:n], []byte{7, 25}) {
+		t.Errorf("Expected one datagram with the pair, got % x %v", b[:n], err)
+	}
+}
@@ -0,0 +1,235 @@
+package gpio
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/theatrus/ledbrick/controller/alert"
+	"github.com/theatrus/ledbrick/controller/ble"
+)
+
+var sysfsDir string
+var configFile string
+var pollInterval time.Duration
+
+func init() {
+	flag.StringVar(&sysfsDir, "gpio.dir", "/sys/class/gpio",
+		"Directory of the sysfs GPIO interface")
+	flag.StringVar(&configFile, "gpio.config", "",
+		"JSON file listing GPIO interlock inputs, disabled if empty")
+	flag.DurationVar(&pollInterval, "gpio.interval", 100*time.Millisecond,
+		"How often to sample GPIO interlock inputs")
+}
+
+// Config describes one interlock input, such as a leak sensor or an
+// emergency stop button.
+type Config struct {
+	Name     string       `json:"name"`
+	Pin      int          `json:"pin"`
+	Invert   bool         `json:"invert"`
+	Debounce ble.Duration `json:"debounce"`
+	// Output percent to force while asserted, 0 for lights off
+	Percent float64 `json:"percent"`
+}
+
+// Input is the current state of an interlock input.
+type Input struct {
+	Name     string    `json:"name"`
+	Pin      int       `json:"pin"`
+	Asserted bool      `json:"asserted"`
+	Since    time.Time `json:"since"`
+}
+
+// debouncer only accepts a new state once it has held for the debounce
+// period.
+type debouncer struct {
+	stable    bool
+	candidate bool
+	since     time.Time
+}
+
+// update feeds a raw sample and reports whether the stable state changed.
+func (d *debouncer) update(raw bool, debounce time.Duration, now time.Time) bool {
+	if raw != d.candidate {
+		d.candidate = raw
+		d.since = now
+	}
+	if d.candidate != d.stable && now.Sub(d.since) >= debounce {
+		d.stable = d.candidate
+		return true
+	}
+	return false
+}
+
+type input struct {
+	config Config
+	state  debouncer
+	since  time.Time
+	failed bool
+}
+
+// Interlocks watches GPIO inputs and forces a safe output while any of
+// them is asserted.
+type Interlocks struct {
+	ble    ble.BLEChannel
+	inputs []*input
+	lock   sync.Mutex
+}
+
+// Start loads the interlock configuration and begins polling. It
+// returns nil if no interlocks are configured.
+func Start(ble ble.BLEChannel) (*Interlocks, error) {
+	if configFile == "" {
+		return nil, nil
+	}
+	data, err := ioutil.ReadFile(configFile)
+	if err != nil {
+		return nil, err
+	}
+	var configs []Config
+	if err := json.Unmarshal(data, &configs); err != nil {
+		return nil, err
+	}
+
+	il := &Interlocks{ble: ble}
+	for _, c := range configs {
+		if c.Name == "" {
+			c.Name = fmt.Sprintf("gpio%d", c.Pin)
+		}
+		if err := export(c.Pin, "in"); err != nil {
+			return nil, fmt.Errorf("exporting GPIO %d: %v", c.Pin, err)
+		}
+		il.inputs = append(il.inputs, &input{config: c, since: time.Now()})
+	}
+
+	go func() {
+		for _ = range time.Tick(pollInterval) {
+			il.poll(time.Now())
+		}
+	}()
+	return il, nil
+}
+
+// Inputs returns the debounced state of every interlock.
+func (il *Interlocks) Inputs() []Input {
+	il.lock.Lock()
+	defer il.lock.Unlock()
+	inputs := make([]Input, 0, len(il.inputs))
+	for _, in := range il.inputs {
+		inputs = append(inputs, Input{Name: in.config.Name,
+			Pin:      in.config.Pin,
+			Asserted: in.state.stable,
+			Since:    in.since,
+		})
+	}
+	return inputs
+}
+
+func (il *Interlocks) poll(now time.Time) {
+	il.lock.Lock()
+	defer il.lock.Unlock()
+
+	for _, in := range il.inputs {
+		c := in.config
+		name := "gpio:" + c.Name
+		value, err := read(c.Pin)
+		if err != nil {
+			// An input which can't be read may be a sensor which
+			// can't report, so fail safe as though it were asserted
+			if !in.failed {
+				in.failed = true
+				il.force(name, c)
+				alert.Raise(alert.Critical, c.Name, "gpio.failed", "failed to read GPIO %d, output forced to %.1f%%: %v",
+					c.Pin, c.Percent, err)
+			}
+			continue
+		}
+		if in.failed {
+			in.failed = false
+			if !in.state.stable {
+				il.ble.ClearLimit(name)
+			}
+			alert.Raise(alert.Info, c.Name, "gpio.failed", "GPIO %d readable again", c.Pin)
+		}
+
+		if !in.state.update(value != c.Invert, c.Debounce.Duration, now) {
+			continue
+		}
+		in.since = now
+		if in.state.stable {
+			il.force(name, c)
+			alert.Raise(alert.Critical, c.Name, "gpio.interlock",
+				"interlock asserted, output forced to %.1f%%", c.Percent)
+		} else {
+			il.ble.ClearLimit(name)
+			alert.Raise(alert.Info, c.Name, "gpio.interlock", "interlock released")
+		}
+	}
+}
+
+// force limits output for an interlock at once, bypassing the slew
+// limiter where the channel can, so a leak or emergency stop doesn't
+// wait on a fade.
+func (il *Interlocks) force(name string, c Config) {
+	var err error
+	if f, ok := il.ble.(ble.Forcer); ok {
+		err = f.ForceLimit(name, c.Percent)
+	} else {
+		log.Printf("%s: channel can't force limits, output slews to %.1f%%", c.Name, c.Percent)
+		err = il.ble.SetLimit(name, c.Percent)
+	}
+	if err != nil {
+		alert.Raise(alert.Critical, c.Name, "gpio.interlock", "interlock could not limit output: %v", err)
+	}
+}
+
+// export makes a pin available through sysfs as an input or output.
+func export(pin int, direction string) error {
+	dir := filepath.Join(sysfsDir, fmt.Sprintf("gpio%d", pin))
+	if _, err := os.Stat(dir); os.IsNotExist(err) {
+		err := ioutil.WriteFile(filepath.Join(sysfsDir, "export"), []byte(fmt.Sprint(pin)), 0200)
+		if err != nil {
+			return err
+		}
+		log.Printf("Exported GPIO %d", pin)
+	}
+	return ioutil.WriteFile(filepath.Join(dir, "direction"), []byte(direction), 0644)
+}
+
+// outputs are the pins exported as outputs by Write.
+var outputs = make(map[int]bool)
+var outputsLock sync.Mutex
+
+// Write drives an output pin, such as an accessory's relay, high or
+// low, exporting it as an output the first time.
+func Write(pin int, high bool) error {
+	outputsLock.Lock()
+	defer outputsLock.Unlock()
+	if !outputs[pin] {
+		if err := export(pin, "out"); err != nil {
+			return err
+		}
+		outputs[pin] = true
+	}
+	value := "0"
+	if high {
+		value = "1"
+	}
+	return ioutil.WriteFile(filepath.Join(sysfsDir, fmt.Sprintf("gpio%d", pin), "value"), []byte(value), 0644)
+}
+
+func read(pin int) (bool, error) {
+	data, err := ioutil.ReadFile(filepath.Join(sysfsDir, fmt.Sprintf("gpio%d", pin), "value"))
+	if err != nil {
+		return false, err
+	}
+	return strings.TrimSpace(string(data)) == "1", nil
+}
@@ -0,0 +1,119 @@
+package gpio
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/theatrus/ledbrick/controller/ble"
+)
+
+func TestDebounce(t *testing.T) {
+	var d debouncer
+	now := time.Now()
+	debounce := 50 * time.Millisecond
+
+	if d.update(true, debounce, now) {
+		t.Error("Changed state without holding for the debounce period")
+	}
+	// Bounce back before the period is up
+	d.update(false, debounce, now.Add(10*time.Millisecond))
+	if d.update(true, debounce, now.Add(20*time.Millisecond)) {
+		t.Error("Changed state on a bounce")
+	}
+	if !d.update(true, debounce, now.Add(70*time.Millisecond)) || !d.stable {
+		t.Error("Did not change state after holding")
+	}
+	if d.update(true, debounce, now.Add(200*time.Millisecond)) {
+		t.Error("Reported a change without one")
+	}
+}
+
+func TestWrite(t *testing.T) {
+	dir, err := ioutil.TempDir("", "gpio")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	defer func(d string) { sysfsDir = d }(sysfsDir)
+	sysfsDir = dir
+	// The kernel makes the pin's directory on export
+	os.Mkdir(filepath.Join(dir, "gpio17"), 0755)
+
+	read := func(name string) string {
+		data, _ := ioutil.ReadFile(filepath.Join(dir, "gpio17", name))
+		return string(data)
+	}
+	if err := Write(17, true); err != nil {
+		t.Fatal(err)
+	}
+	if d, v := read("direction"), read("value"); d != "out" || v != "1" {
+		t.Errorf("Expected an output driven high, got %q %q", d, v)
+	}
+	// Exported once, so switching doesn't reset the direction
+	ioutil.WriteFile(filepath.Join(dir, "gpio17", "direction"), nil, 0644)
+	if err := Write(17, false); err != nil {
+		t.Fatal(err)
+	}
+	if d, v := read("direction"), read("value"); d != "" || v != "0" {
+		t.Errorf("Expected the value alone written low, got %q %q", d, v)
+	}
+}
+
+// forcer records the limits forced on it.
+type forcer struct {
+	ble.BLEChannel
+	forced map[string]float64
+}
+
+func (f *forcer) ForceLimit(name string, percent float64) error {
+	f.forced[name] = percent
+	return nil
+}
+
+func (f *forcer) ClearLimit(name string) { delete(f.forced, name) }
+
+func TestPoll(t *testing.T) {
+	dir, err := ioutil.TempDir("", "gpio")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	defer func(d string) { sysfsDir = d }(sysfsDir)
+	sysfsDir = dir
+	os.Mkdir(filepath.Join(dir, "gpio4"), 0755)
+	value := filepath.Join(dir, "gpio4", "value")
+	ioutil.WriteFile(value, []byte("0\n"), 0644)
+
+	f := &forcer{forced: make(map[string]float64)}
+	il := &Interlocks{ble: f, inputs: []*input{{config: Config{Name: "leak", Pin: 4, Percent: 0}}}}
+	now := time.Now()
+	il.poll(now)
+	if len(f.forced) != 0 {
+		t.Fatalf("Expected no limit while released, got %v", f.forced)
+	}
+	ioutil.WriteFile(value, []byte("1\n"), 0644)
+	il.poll(now.Add(time.Second))
+	if p, ok := f.forced["gpio:leak"]; !ok || p != 0 {
+		t.Errorf("Expected output forced off on a leak, got %v", f.forced)
+	}
+	ioutil.WriteFile(value, []byte("0\n"), 0644)
+	il.poll(now.Add(2 * time.Second))
+	if len(f.forced) != 0 {
+		t.Errorf("Expected the limit cleared on release, got %v", f.forced)
+	}
+
+	// A sensor which can't be read fails safe
+	os.Remove(value)
+	il.poll(now.Add(3 * time.Second))
+	if _, ok := f.forced["gpio:leak"]; !ok {
+		t.Error("Expected output forced when the input can't be read")
+	}
+	ioutil.WriteFile(value, []byte("0\n"), 0644)
+	il.poll(now.Add(4 * time.Second))
+	if len(f.forced) != 0 {
+		t.Errorf("Expected the limit cleared once readable and released, got %v", f.forced)
+	}
+}
@@ -0,0 +1,219 @@
+package hue
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"log"
+	"math"
+	"net/http"
+	"net/url"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/theatrus/ledbrick/controller/ble"
+)
+
+var bridgeAddr string
+var key string
+var lights lightMap
+var interval time.Duration
+
+func init() {
+	flag.StringVar(&bridgeAddr, "hue.bridge", "", "Address of a Philips Hue bridge, disabled if empty")
+	flag.StringVar(&key, "hue.key", "",
+		"Hue bridge API key, the user name the bridge creates when its link button is pressed")
+	flag.Var(&lights, "hue.lights",
+		"Semicolon separated Hue light IDs and the channels each follows, e.g. 3=Blue+Deep Blue;5=*")
+	flag.DurationVar(&interval, "hue.interval", 10*time.Second,
+		"How often Hue lights are brought in line with their channels")
+}
+
+// lightMap is a flag of Hue light IDs and the channels each follows.
+type lightMap map[string][]int
+
+func (l *lightMap) String() string {
+	var parts []string
+	for id, channels := range *l {
+		parts = append(parts, fmt.Sprintf("%s=%v", id, channels))
+	}
+	sort.Strings(parts)
+	return strings.Join(parts, ";")
+}
+
+func (l *lightMap) Set(value string) error {
+	m := make(lightMap)
+	for _, part := range strings.Split(value, ";") {
+		kv := strings.SplitN(strings.TrimSpace(part), "=", 2)
+		if len(kv) != 2 || kv[0] == "" {
+			return errors.New("lights must be id=channel+channel")
+		}
+		var channels []int
+		if strings.TrimSpace(kv[1]) == "*" {
+			for channel := range ble.ChannelNames {
+				channels = append(channels, channel)
+			}
+			m[kv[0]] = channels
+			continue
+		}
+		for _, name := range strings.Split(kv[1], "+") {
+			channel, err := ble.ChannelNumber(strings.TrimSpace(name))
+			if err != nil {
+				return fmt.Errorf("light %s: %v", kv[0], err)
+			}
+			channels = append(channels, channel)
+		}
+		m[kv[0]] = channels
+	}
+	*l = m
+	return nil
+}
+
+// chromaticity is the CIE 1931 xy colour of each stock channel's
+// emitters and their relative luminous efficacy, used to work out what
+// colour a Hue light should be to look like the channels it follows.
+var chromaticity = [8]struct{ x, y, luminance float64 }{
+	{0.0743, 0.8338, 0.71},  // Green, 520nm
+	{0.0454, 0.2950, 0.21},  // Cyan, 490nm
+	{0.5752, 0.4242, 0.76},  // PC Amber, 590nm
+	{0.1241, 0.0578, 0.09},  // Blue, 470nm
+	{0.7079, 0.2920, 0.27},  // Red, 630nm
+	{0.1566, 0.0177, 0.04},  // Deep Blue, 450nm
+	{0.3127, 0.3290, 1},     // White, 6500K
+	{0.1733, 0.0048, 0.001}, // UV, 405nm
+}
+
+// state is a Hue light state update.
+type state struct {
+	On             bool      `json:"on"`
+	Brightness     int       `json:"bri,omitempty"`
+	XY             []float64 `json:"xy,omitempty"`
+	TransitionTime int       `json:"transitiontime"`
+}
+
+// mix returns the light state matching channels at the given percents:
+// as bright as the brightest of them, in the colour of their emitters
+// mixed in proportion.
+func mix(channels []int, percents map[int]float64) state {
+	var X, Y, Z, brightest float64
+	for _, channel := range channels {
+		p := percents[channel]
+		brightest = math.Max(brightest, p)
+		c := chromaticity[channel]
+		lum := p * c.luminance
+		X += c.x / c.y * lum
+		Y += lum
+		Z += (1 - c.x - c.y) / c.y * lum
+	}
+	s := state{TransitionTime: int(interval / (100 * time.Millisecond))}
+	if brightest <= 0 {
+		return s
+	}
+	s.On = true
+	s.Brightness = int(math.Max(1, math.Min(brightest, 100)/100*254+0.5))
+	if sum := X + Y + Z; sum > 0 {
+		round := func(v float64) float64 { return math.Floor(v*10000+0.5) / 10000 }
+		s.XY = []float64{round(X / sum), round(Y / sum)}
+	}
+	return s
+}
+
+// Hue keeps Hue lights, such as room accent lighting or strips behind
+// the tank, following schedule channels.
+type Hue struct {
+	ble    ble.BLEChannel
+	url    string
+	client *http.Client
+	sent   map[string]state
+}
+
+// Start begins driving the configured Hue lights. It returns nil if no
+// bridge is configured.
+func Start(b ble.BLEChannel) *Hue {
+	if bridgeAddr == "" || len(lights) == 0 {
+		return nil
+	}
+	h := &Hue{ble: b,
+		url:    fmt.Sprintf("http://%s/api/%s", bridgeAddr, key),
+		client: &http.Client{Timeout: 5 * time.Second},
+		sent:   make(map[string]state),
+	}
+	go func() {
+		for _ = range time.Tick(interval) {
+			if err := h.update(); err != nil {
+				log.Printf("Failed to set Hue lights: %v", err)
+			}
+		}
+	}()
+	return h
+}
+
+// update sets every light whose state has changed.
+func (h *Hue) update() error {
+	percents := h.ble.Channels()
+	for id, channels := range lights {
+		s := mix(channels, percents)
+		if last, ok := h.sent[id]; ok && same(last, s) {
+			continue
+		}
+		if err := h.set(id, s); err != nil {
+			return fmt.Errorf("light %s: %v", id, err)
+		}
+		h.sent[id] = s
+	}
+	return nil
+}
+
+func same(a, b state) bool {
+	if a.On != b.On || a.Brightness != b.Brightness || len(a.XY) != len(b.XY) {
+		return false
+	}
+	for i := range a.XY {
+		if math.Abs(a.XY[i]-b.XY[i]) > 0.002 {
+			return false
+		}
+	}
+	return true
+}
+
+func (h *Hue) set(id string, s state) error {
+	data, err := json.Marshal(s)
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequest("PUT", h.url+"/lights/"+id+"/state", bytes.NewReader(data))
+	if err != nil {
+		return err
+	}
+	resp, err := h.client.Do(req)
+	if ue, ok := err.(*url.Error); ok {
+		// Keep the key in the URL out of the logs
+		return ue.Err
+	}
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("bridge replied %s", resp.Status)
+	}
+	// The bridge replies 200 with a list of results, any of which may
+	// be an error such as an unknown light
+	var results []struct {
+		Error *struct {
+			Description string `json:"description"`
+		} `json:"error"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&results); err != nil {
+		return err
+	}
+	for _, r := range results {
+		if r.Error != nil {
+			return errors.New(r.Error.Description)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,94 @@
+package hue
+
+import (
+	"encoding/json"
+	"flag"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/theatrus/ledbrick/controller/ble"
+	"github.com/theatrus/ledbrick/controller/support"
+)
+
+// channels is a BLE channel reporting fixed channel outputs.
+type channels struct {
+	ble.BLEChannel
+	percents map[int]float64
+}
+
+func (c *channels) Channels() map[int]float64 { return c.percents }
+
+func TestLightMap(t *testing.T) {
+	var l lightMap
+	if err := l.Set("3=Blue+deep_blue; 5=*"); err != nil {
+		t.Fatal(err)
+	}
+	if len(l["3"]) != 2 || l["3"][1] != 5 || len(l["5"]) != 8 {
+		t.Errorf("Unexpected lights %v", l)
+	}
+	for _, v := range []string{"3", "=1", "3=Purple"} {
+		if err := l.Set(v); err == nil {
+			t.Errorf("Expected error for %q", v)
+		}
+	}
+}
+
+func TestMix(t *testing.T) {
+	interval = 10 * time.Second
+	s := mix([]int{6}, map[int]float64{6: 50})
+	if !s.On || s.Brightness != 127 || s.XY[0] != 0.3127 || s.XY[1] != 0.329 || s.TransitionTime != 100 {
+		t.Errorf("Expected white at half brightness, got %+v", s)
+	}
+	// Blues pull the white towards blue
+	s = mix([]int{3, 5, 6}, map[int]float64{3: 100, 5: 100, 6: 10})
+	if s.Brightness != 254 || s.XY[0] >= 0.3127 || s.XY[1] >= 0.329 {
+		t.Errorf("Expected a bluer white at full brightness, got %+v", s)
+	}
+	if s := mix([]int{0, 1}, map[int]float64{6: 100}); s.On {
+		t.Errorf("Expected off with the followed channels off, got %+v", s)
+	}
+}
+
+func TestUpdate(t *testing.T) {
+	var puts []string
+	bridge := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var s state
+		json.NewDecoder(r.Body).Decode(&s)
+		puts = append(puts, r.Method+" "+r.URL.Path)
+		if strings.Contains(r.URL.Path, "/lights/9/") {
+			w.Write([]byte(`[{"error": {"type": 3, "description": "resource, /lights/9, not available"}}]`))
+			return
+		}
+		w.Write([]byte(`[{"success": {}}]`))
+	}))
+	defer bridge.Close()
+
+	lights = lightMap{"3": {7}}
+	defer func() { lights = nil }()
+	c := &channels{percents: map[int]float64{7: 20}}
+	h := &Hue{ble: c, url: bridge.URL + "/api/me", client: bridge.Client(), sent: make(map[string]state)}
+	if err := h.update(); err != nil {
+		t.Fatal(err)
+	}
+	h.update()
+	if len(puts) != 1 || puts[0] != "PUT /api/me/lights/3/state" {
+		t.Errorf("Expected one update to light 3, got %v", puts)
+	}
+
+	lights = lightMap{"9": {7}}
+	if err := h.update(); err == nil {
+		t.Error("Expected the bridge's error for an unknown light")
+	}
+}
+
+func TestKeyRedacted(t *testing.T) {
+	defer flag.Set("hue.key", key)
+	flag.Set("hue.key", "hunter2")
+	if flags := support.Flags(); strings.Contains(flags, "hunter2") ||
+		!strings.Contains(flags, "-hue.key="+support.Redacted) {
+		t.Errorf("Expected the Hue key redacted from support bundles, got %s", flags)
+	}
+}
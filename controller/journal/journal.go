@@ -0,0 +1,196 @@
+// Package journal keeps a tank journal: timestamped notes on husbandry,
+// such as dosing or a new frag, tagged so they can be found again, and
+// the times lighting tables were applied, so notes can be read against
+// the lighting history.
+package journal
+
+import (
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/theatrus/ledbrick/controller/atomicfile"
+	"github.com/theatrus/ledbrick/controller/events"
+)
+
+var journalFile string
+var keepChanges int
+
+func init() {
+	flag.StringVar(&journalFile, "journal.file", "",
+		"File to keep the tank journal in, in-memory only if empty")
+	flag.IntVar(&keepChanges, "journal.changes", 1000,
+		"How many lighting table changes to keep for journal context")
+}
+
+// Entry is a note in the journal.
+type Entry struct {
+	ID   int       `json:"id"`
+	At   time.Time `json:"at"`
+	Note string    `json:"note"`
+	Tags []string  `json:"tags,omitempty"`
+	// Tank is the tank the note is about, if it was written for one
+	Tank string `json:"tank,omitempty"`
+}
+
+// saved is the journal as kept on disk.
+type saved struct {
+	Entries []Entry     `json:"entries"`
+	Changes []time.Time `json:"schedule_changes"`
+}
+
+// Journal is the tank journal, with the lighting table changes
+// published on the event bus.
+type Journal struct {
+	entries []Entry
+	changes []time.Time
+	nextID  int
+	lock    sync.Mutex
+}
+
+// Open loads the journal from -journal.file and starts recording
+// lighting table changes.
+func Open() (*Journal, error) {
+	j := &Journal{nextID: 1}
+	if journalFile != "" {
+		data, err := ioutil.ReadFile(journalFile)
+		switch {
+		case os.IsNotExist(err):
+		case err != nil:
+			return nil, err
+		default:
+			var s saved
+			if err := json.Unmarshal(data, &s); err != nil {
+				return nil, fmt.Errorf("%s: %v", journalFile, err)
+			}
+			j.entries, j.changes = s.Entries, s.Changes
+			for _, e := range j.entries {
+				if e.ID >= j.nextID {
+					j.nextID = e.ID + 1
+				}
+			}
+		}
+	}
+	events.Register(j)
+	return j, nil
+}
+
+// Publish records lighting tables being applied.
+func (j *Journal) Publish(e events.Event) {
+	if e.Type != events.Schedule {
+		return
+	}
+	j.lock.Lock()
+	defer j.lock.Unlock()
+	j.changes = append(j.changes, e.At)
+	if len(j.changes) > keepChanges {
+		j.changes = j.changes[len(j.changes)-keepChanges:]
+	}
+	j.save()
+}
+
+// Add writes a note, now if it has no time, returning it with its ID.
+// Tags are trimmed and lower cased so "Dosed" and "dosed " are one tag.
+func (j *Journal) Add(e Entry) (Entry, error) {
+	e.Note = strings.TrimSpace(e.Note)
+	if e.Note == "" {
+		return e, errors.New("a note can't be empty")
+	}
+	var tags []string
+	for _, tag := range e.Tags {
+		tag = strings.ToLower(strings.TrimSpace(tag))
+		if tag != "" && !contains(tags, tag) {
+			tags = append(tags, tag)
+		}
+	}
+	e.Tags = tags
+	if e.At.IsZero() {
+		e.At = time.Now()
+	}
+
+	j.lock.Lock()
+	defer j.lock.Unlock()
+	e.ID = j.nextID
+	j.nextID++
+	j.entries = append(j.entries, e)
+	// Notes may be written after the fact, so keep them in time order
+	sort.SliceStable(j.entries, func(a, b int) bool {
+		return j.entries[a].At.Before(j.entries[b].At)
+	})
+	j.save()
+	return e, nil
+}
+
+// Delete removes a note.
+func (j *Journal) Delete(id int) error {
+	j.lock.Lock()
+	defer j.lock.Unlock()
+	for i, e := range j.entries {
+		if e.ID == id {
+			j.entries = append(j.entries[:i:i], j.entries[i+1:]...)
+			j.save()
+			return nil
+		}
+	}
+	return fmt.Errorf("no journal entry %d", id)
+}
+
+// Entries returns the notes in time order, only those with the tag if
+// it isn't empty, and only those for the tank if it isn't empty.
+func (j *Journal) Entries(tag, tank string) []Entry {
+	tag = strings.ToLower(tag)
+	j.lock.Lock()
+	defer j.lock.Unlock()
+	entries := make([]Entry, 0)
+	for _, e := range j.entries {
+		if (tag == "" || contains(e.Tags, tag)) && (tank == "" || e.Tank == tank) {
+			entries = append(entries, e)
+		}
+	}
+	return entries
+}
+
+// Changes returns the times lighting tables were applied in [from, to).
+func (j *Journal) Changes(from, to time.Time) []time.Time {
+	j.lock.Lock()
+	defer j.lock.Unlock()
+	changes := make([]time.Time, 0)
+	for _, at := range j.changes {
+		if !at.Before(from) && at.Before(to) {
+			changes = append(changes, at)
+		}
+	}
+	return changes
+}
+
+// save writes the journal out. The caller must hold the lock.
+func (j *Journal) save() {
+	if journalFile == "" {
+		return
+	}
+	data, err := json.MarshalIndent(saved{j.entries, j.changes}, "", "  ")
+	if err != nil {
+		log.Printf("Failed to encode the journal: %v", err)
+		return
+	}
+	if err := atomicfile.WriteFile(journalFile, data, 0644); err != nil {
+		log.Printf("Failed to save the journal: %v", err)
+	}
+}
+
+func contains(list []string, s string) bool {
+	for _, v := range list {
+		if v == s {
+			return true
+		}
+	}
+	return false
+}
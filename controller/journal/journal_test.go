@@ -0,0 +1,80 @@
+package journal
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/theatrus/ledbrick/controller/events"
+)
+
+func TestJournal(t *testing.T) {
+	dir, err := ioutil.TempDir("", "journal")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	journalFile = filepath.Join(dir, "journal.json")
+	defer func() { journalFile = "" }()
+
+	j, err := Open()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer events.Unregister(j)
+	at := time.Date(2016, 3, 4, 9, 0, 0, 0, time.UTC)
+	if _, err := j.Add(Entry{Note: "  "}); err == nil {
+		t.Error("Expected an empty note to be refused")
+	}
+	later, err := j.Add(Entry{At: at.Add(time.Hour), Note: "Dosed alk", Tags: []string{"Dosed ", "dosed"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(later.Tags) != 1 || later.Tags[0] != "dosed" {
+		t.Errorf("Expected tags to be cleaned up, got %q", later.Tags)
+	}
+	if _, err := j.Add(Entry{At: at, Note: "New frag", Tags: []string{"new frag"}, Tank: "frag"}); err != nil {
+		t.Fatal(err)
+	}
+	j.Publish(events.Event{Type: events.Schedule, At: at.Add(-time.Hour)})
+	j.Publish(events.Event{Type: events.Channels, At: at})
+
+	// Reopened, the journal comes back from its file
+	again, err := Open()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer events.Unregister(again)
+	entries := again.Entries("", "")
+	if len(entries) != 2 || entries[0].Note != "New frag" || entries[1].ID != later.ID {
+		t.Fatalf("Expected both notes in time order, got %+v", entries)
+	}
+	if e := again.Entries("DOSED", ""); len(e) != 1 || e[0].Note != "Dosed alk" {
+		t.Errorf("Expected only the dosed note, got %+v", e)
+	}
+	if e := again.Entries("", "frag"); len(e) != 1 || e[0].Tank != "frag" {
+		t.Errorf("Expected only the frag tank's note, got %+v", e)
+	}
+	if c := again.Changes(at.Add(-24*time.Hour), at); len(c) != 1 || !c[0].Equal(at.Add(-time.Hour)) {
+		t.Errorf("Expected the one table applied, got %v", c)
+	}
+	if c := again.Changes(at, at.Add(time.Hour)); len(c) != 0 {
+		t.Errorf("Expected no changes after the table applied, got %v", c)
+	}
+
+	next, _ := again.Add(Entry{Note: "Algae"})
+	if next.ID != 3 {
+		t.Errorf("Expected IDs to carry on from the file, got %d", next.ID)
+	}
+	if err := again.Delete(later.ID); err != nil {
+		t.Fatal(err)
+	}
+	if err := again.Delete(later.ID); err == nil {
+		t.Error("Expected deleting a missing note to fail")
+	}
+	if e := again.Entries("dosed", ""); len(e) != 0 {
+		t.Errorf("Expected the deleted note gone, got %+v", e)
+	}
+}
@@ -0,0 +1,232 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"net/url"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// manifest is the lighting tables a controller and its tanks should
+// run, as JSON. Only the tables are managed: devices, aliases, zones,
+// scenes and alert policies have no API to set them, so apply leaves
+// them to the controller's own files.
+type manifest struct {
+	// Schedule is the controller's lighting table, left alone if empty
+	Schedule json.RawMessage `json:"schedule"`
+	// Tanks are the tanks with their own lighting tables, by name
+	Tanks map[string]tankManifest `json:"tanks"`
+}
+
+type tankManifest struct {
+	Schedule json.RawMessage `json:"schedule"`
+}
+
+// unmanaged are sections of a full desired state apply doesn't manage,
+// so a manifest naming them is refused rather than half applied.
+var unmanaged = map[string]bool{"devices": true, "aliases": true, "zones": true, "scenes": true, "alerts": true}
+
+// readManifest reads and checks a manifest file.
+func readManifest(file string) (*manifest, error) {
+	if ext := filepath.Ext(file); ext == ".yaml" || ext == ".yml" {
+		return nil, fmt.Errorf("%s: manifests are JSON, not YAML", file)
+	}
+	data, err := ioutil.ReadFile(file)
+	if err != nil {
+		return nil, err
+	}
+	var sections map[string]json.RawMessage
+	if err := json.Unmarshal(data, &sections); err != nil {
+		return nil, fmt.Errorf("%s: %v", file, err)
+	}
+	for name := range sections {
+		switch {
+		case unmanaged[name]:
+			return nil, fmt.Errorf("%s: apply only manages lighting tables, not %s", file, name)
+		case name != "schedule" && name != "tanks":
+			return nil, fmt.Errorf("%s: unknown section %q", file, name)
+		}
+	}
+	var m manifest
+	if err := json.Unmarshal(data, &m); err != nil {
+		return nil, fmt.Errorf("%s: %v", file, err)
+	}
+	return &m, nil
+}
+
+// tablePoint is a point of a lighting table, as the controller reads
+// them.
+type tablePoint struct {
+	At       string    `json:"at"`
+	Percents []float64 `json:"percents"`
+	Zone     string    `json:"zone,omitempty"`
+}
+
+func (p tablePoint) key() string {
+	if p.Zone != "" {
+		return p.At + " " + p.Zone
+	}
+	return p.At
+}
+
+// step is a lighting table the plan changes.
+type step struct {
+	// Target is the controller or a tank, and Path where its table is
+	Target string   `json:"target"`
+	Path   string   `json:"-"`
+	Table  []byte   `json:"-"`
+	Diff   []string `json:"diff"`
+}
+
+// diffTables describes how a table differs from the running one, point
+// by point, nil if they are the same.
+func diffTables(running, want []tablePoint) []string {
+	have := make(map[string]tablePoint)
+	for _, p := range running {
+		have[p.key()] = p
+	}
+	var diff []string
+	for _, p := range want {
+		old, ok := have[p.key()]
+		delete(have, p.key())
+		switch {
+		case !ok:
+			diff = append(diff, fmt.Sprintf("+ %s %v", p.key(), p.Percents))
+		case !samePercents(old.Percents, p.Percents):
+			diff = append(diff, fmt.Sprintf("~ %s %v -> %v", p.key(), old.Percents, p.Percents))
+		}
+	}
+	for key, p := range have {
+		diff = append(diff, fmt.Sprintf("- %s %v", key, p.Percents))
+	}
+	sort.SliceStable(diff, func(i, j int) bool { return diff[i][2:] < diff[j][2:] })
+	return diff
+}
+
+// samePercents compares percents, taking missing channels as off.
+func samePercents(a, b []float64) bool {
+	for i := 0; i < len(a) || i < len(b); i++ {
+		var x, y float64
+		if i < len(a) {
+			x = a[i]
+		}
+		if i < len(b) {
+			y = b[i]
+		}
+		if x != y {
+			return false
+		}
+	}
+	return true
+}
+
+// plan compares the manifest with the running controller, returning
+// the tables to apply.
+func plan(c *client, m *manifest) ([]step, error) {
+	type target struct {
+		name, path string
+		table      json.RawMessage
+	}
+	var targets []target
+	if len(m.Schedule) > 0 {
+		targets = append(targets, target{"controller", "/schedule", m.Schedule})
+	}
+	var names []string
+	for name := range m.Tanks {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		if t := m.Tanks[name]; len(t.Schedule) > 0 {
+			targets = append(targets, target{"tank " + name, "/tanks/" + url.PathEscape(name) + "/schedule", t.Schedule})
+		}
+	}
+
+	var steps []step
+	for _, t := range targets {
+		var want []tablePoint
+		if err := json.Unmarshal(t.table, &want); err != nil {
+			return nil, fmt.Errorf("%s schedule: %v", t.name, err)
+		}
+		var running struct {
+			Table []tablePoint `json:"table"`
+		}
+		if err := c.get(t.path, &running); err != nil {
+			return nil, err
+		}
+		if diff := diffTables(running.Table, want); len(diff) > 0 {
+			steps = append(steps, step{Target: t.name, Path: t.path, Table: t.table, Diff: diff})
+		}
+	}
+	return steps, nil
+}
+
+// runApply makes the controller's lighting tables match a manifest. It
+// shows the plan,
+// and applies only what differs, so running it again changes nothing.
+func runApply(c *client, args []string) error {
+	fs := flag.NewFlagSet("apply", flag.ExitOnError)
+	file := fs.String("f", "", "JSON manifest of the lighting tables to apply")
+	dryRun := fs.Bool("dry-run", false, "Only show the plan")
+	confirm := fs.Bool("confirm", false, "Apply changes big enough to need confirming")
+	fs.Parse(args)
+	if *file == "" {
+		return fmt.Errorf("apply needs a manifest given with -f")
+	}
+	m, err := readManifest(*file)
+	if err != nil {
+		return err
+	}
+	if len(m.Tanks) > 0 && *tank != "" {
+		return fmt.Errorf("the manifest names tanks, so can't be applied with -tank")
+	}
+	steps, err := plan(c, m)
+	if err != nil {
+		return err
+	}
+
+	if *format == "json" {
+		if steps == nil {
+			steps = []step{}
+		}
+		if err := writeJSON(os.Stdout, steps); err != nil {
+			return err
+		}
+	} else {
+		if len(steps) == 0 {
+			fmt.Println("Nothing to change")
+		}
+		for _, s := range steps {
+			fmt.Printf("%s schedule:\n    %s\n", s.Target, strings.Join(s.Diff, "\n    "))
+		}
+	}
+	if *dryRun {
+		return nil
+	}
+
+	for _, s := range steps {
+		path := s.Path
+		if *confirm {
+			path += "?confirm=true"
+		}
+		resp, err := c.send("POST", path, bytes.NewReader(s.Table))
+		if err != nil {
+			return err
+		}
+		_, err = c.decode(s.Path, resp)
+		resp.Body.Close()
+		if err != nil {
+			return err
+		}
+		if *format != "json" {
+			fmt.Printf("Applied %s schedule\n", s.Target)
+		}
+	}
+	return nil
+}
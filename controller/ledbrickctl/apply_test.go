@@ -0,0 +1,65 @@
+package main
+
+import (
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestDiffTables(t *testing.T) {
+	running := []tablePoint{{At: "08:00", Percents: []float64{0, 0}}, {At: "12:00", Percents: []float64{50}}, {At: "20:00"}}
+	want := []tablePoint{{At: "08:00"}, {At: "12:00", Percents: []float64{60}}, {At: "14:00", Percents: []float64{10}}}
+	diff := diffTables(running, want)
+	expected := []string{"~ 12:00 [50] -> [60]", "+ 14:00 [10]", "- 20:00 []"}
+	if !reflect.DeepEqual(diff, expected) {
+		t.Errorf("Expected %q, got %q", expected, diff)
+	}
+	if diff := diffTables(running, running); diff != nil {
+		t.Errorf("Expected no difference, got %q", diff)
+	}
+}
+
+func TestApply(t *testing.T) {
+	var posted []string
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method == "POST" {
+			posted = append(posted, r.URL.Path)
+			w.Write([]byte(`{"data":{"applied":true}}`))
+			return
+		}
+		w.Write([]byte(`{"data":{"table":[{"at":"08:00","percents":[10]}]}}`))
+	}))
+	defer srv.Close()
+	c := &client{base: srv.URL, http: srv.Client()}
+
+	dir, err := ioutil.TempDir("", "apply")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	file := filepath.Join(dir, "manifest.json")
+	ioutil.WriteFile(file, []byte(`{"schedule":[{"at":"08:00","percents":[10]}],
+		"tanks":{"frag":{"schedule":[{"at":"09:00","percents":[20]}]}}}`), 0644)
+
+	if err := runApply(c, []string{"-f", file}); err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(posted, []string{"/tanks/frag/schedule"}) {
+		t.Errorf("Expected only the frag tank's table applied, got %v", posted)
+	}
+
+	ioutil.WriteFile(file, []byte(`{"scenes":{}}`), 0644)
+	if err := runApply(c, []string{"-f", file}); err == nil || !strings.Contains(err.Error(), "scenes") {
+		t.Errorf("Expected scenes to be refused, got %v", err)
+	}
+	yaml := filepath.Join(dir, "manifest.yaml")
+	ioutil.WriteFile(yaml, []byte("schedule: []\n"), 0644)
+	if err := runApply(c, []string{"-f", yaml}); err == nil || !strings.Contains(err.Error(), "JSON") {
+		t.Errorf("Expected YAML to be refused, got %v", err)
+	}
+}
@@ -0,0 +1,189 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"strings"
+)
+
+// completeVerb is the hidden command the completion scripts run with
+// the words typed so far, the last being the one to complete. It prints
+// one candidate per line, with a description after a tab if there is
+// one.
+const completeVerb = "__complete"
+
+const bashCompletion = `_ledbrickctl() {
+    local IFS=$'\n'
+    COMPREPLY=($(ledbrickctl __complete "${COMP_WORDS[@]:1:COMP_CWORD}" 2>/dev/null | cut -f1))
+}
+complete -F _ledbrickctl ledbrickctl
+`
+
+const zshCompletion = `#compdef ledbrickctl
+_ledbrickctl() {
+    local -a candidates
+    candidates=(${(f)"$(ledbrickctl __complete "${(@)words[2,CURRENT]}" 2>/dev/null)"})
+    candidates=(${candidates//:/\\:})
+    candidates=(${candidates//$'\t'/:})
+    _describe ledbrickctl candidates
+}
+compdef _ledbrickctl ledbrickctl
+`
+
+const fishCompletion = `function __ledbrickctl_complete
+    set -l words (commandline -opc)
+    set -e words[1]
+    ledbrickctl __complete $words (commandline -ct) 2>/dev/null
+end
+complete -c ledbrickctl -f -a '(__ledbrickctl_complete)'
+`
+
+var shells = map[string]string{
+	"bash": bashCompletion,
+	"zsh":  zshCompletion,
+	"fish": fishCompletion,
+}
+
+// runCompletion prints the completion script for a shell, to be
+// sourced from its startup file.
+func runCompletion(c *client, args []string) error {
+	if len(args) != 1 {
+		return fmt.Errorf("usage: ledbrickctl completion bash|zsh|fish")
+	}
+	script, ok := shells[args[0]]
+	if !ok {
+		return fmt.Errorf("no completion for shell %q, expected bash, zsh or fish", args[0])
+	}
+	fmt.Print(script)
+	return nil
+}
+
+// flagValues are the candidates for global flags with a fixed set of
+// values.
+var flagValues = map[string][]string{
+	"format": {"table", "json"},
+}
+
+// complete returns the candidates for the last of words, which are the
+// command line after ledbrickctl. Fixture IDs and channel names come
+// from the controller, so -api and -token flags already typed are
+// honoured.
+func complete(c *client, words []string) []string {
+	if len(words) == 0 {
+		return nil
+	}
+	current := words[len(words)-1]
+	args := words[:len(words)-1]
+
+	for len(args) > 0 && strings.HasPrefix(args[0], "-") {
+		name := strings.TrimLeft(args[0], "-")
+		args = args[1:]
+		if i := strings.Index(name, "="); i >= 0 {
+			c.setFlag(name[:i], name[i+1:])
+			continue
+		}
+		if len(args) == 0 {
+			// Completing this flag's value
+			if name == "context" || name == "on" {
+				return filter(contextNames(), current)
+			}
+			return filter(flagValues[name], current)
+		}
+		c.setFlag(name, args[0])
+		args = args[1:]
+	}
+
+	if len(args) == 0 {
+		if strings.HasPrefix(current, "-") {
+			var names []string
+			flag.VisitAll(func(f *flag.Flag) {
+				names = append(names, "-"+f.Name+"\t"+f.Usage)
+			})
+			return filter(names, current)
+		}
+		return filter(completeCommands(c, nil), current)
+	}
+	cmd, ok := commands[args[0]]
+	if !ok || cmd.complete == nil {
+		return nil
+	}
+	return filter(cmd.complete(c, args[1:]), current)
+}
+
+// setFlag applies a global flag typed on the command line being
+// completed to the client.
+func (c *client) setFlag(name, value string) {
+	switch name {
+	case "api":
+		c.base = strings.TrimSuffix(value, "/")
+	case "token":
+		c.token = value
+	case "context":
+		if cs, err := loadContexts(); err == nil {
+			if ctx, ok := cs.Contexts[value]; ok {
+				c.base, c.token = ctx.API, ctx.Token
+			}
+		}
+	}
+}
+
+// filter returns the candidates starting with prefix.
+func filter(candidates []string, prefix string) []string {
+	var matched []string
+	for _, candidate := range candidates {
+		if strings.HasPrefix(strings.ToLower(candidate), strings.ToLower(prefix)) {
+			matched = append(matched, candidate)
+		}
+	}
+	return matched
+}
+
+func completeCommands(c *client, args []string) []string {
+	if len(args) > 0 {
+		return nil
+	}
+	var names []string
+	for _, name := range commandNames() {
+		names = append(names, name+"\t"+commands[name].help)
+	}
+	return names
+}
+
+func completeShells(c *client, args []string) []string {
+	if len(args) > 0 {
+		return nil
+	}
+	return []string{"bash", "zsh", "fish"}
+}
+
+// completeChannels offers the channel names not already given, without
+// spaces so they complete as one word.
+func completeChannels(c *client, args []string) []string {
+	var s status
+	if err := c.get("/status", &s); err != nil {
+		return nil
+	}
+	var names []string
+	for _, ch := range s.Channels {
+		if !matches(args, ch.Name) {
+			names = append(names, squash(ch.Name)+fmt.Sprintf("\tchannel %d, %.1f%%", ch.Channel, ch.Percent))
+		}
+	}
+	return names
+}
+
+// completeFixtures offers the fixture IDs not already given.
+func completeFixtures(c *client, args []string) []string {
+	var fixtures []fixture
+	if err := c.get("/peripherals", &fixtures); err != nil {
+		return nil
+	}
+	unit := temperatureUnit(c)
+	var ids []string
+	for _, f := range fixtures {
+		if !matches(args, f.ID) {
+			ids = append(ids, f.ID+fmt.Sprintf("\t%s, %.1f W", temperature(f.Temperature, unit), f.Watts))
+		}
+	}
+	return ids
+}
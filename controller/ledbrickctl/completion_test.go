@@ -0,0 +1,59 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestComplete(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/status":
+			w.Write([]byte(`{"data":{"channels":[{"channel":2,"name":"PC Amber","percent":10},{"channel":3,"name":"Blue","percent":50}]}}`))
+		case "/peripherals":
+			w.Write([]byte(`{"data":[{"id":"abc"},{"id":"abd"},{"id":"xyz"}]}`))
+		}
+	}))
+	defer srv.Close()
+	c := newClient("http://unused", time.Second)
+
+	values := func(candidates []string) []string {
+		var v []string
+		for _, c := range candidates {
+			v = append(v, strings.SplitN(c, "\t", 2)[0])
+		}
+		return v
+	}
+	for _, test := range []struct {
+		words []string
+		want  []string
+	}{
+		{[]string{"st"}, []string{"status"}},
+		{[]string{"-format", ""}, []string{"table", "json"}},
+		{[]string{"-api", srv.URL, "fixtures", "ab"}, []string{"abc", "abd"}},
+		{[]string{"-api=" + srv.URL, "fixtures", "abc", "ab"}, []string{"abd"}},
+		{[]string{"-api", srv.URL, "status", "pc"}, []string{"PCAmber"}},
+		{[]string{"completion", "f"}, []string{"fish"}},
+		{[]string{"top", ""}, nil},
+	} {
+		if got := values(complete(c, test.words)); !reflect.DeepEqual(got, test.want) {
+			t.Errorf("complete(%q) = %q, expected %q", test.words, got, test.want)
+		}
+	}
+}
+
+func TestMatches(t *testing.T) {
+	if !matches([]string{"pcamber"}, "2", "PC Amber") {
+		t.Error("Expected a channel name to match without spaces or case")
+	}
+	if !matches([]string{"2"}, "2", "PC Amber") {
+		t.Error("Expected a channel number to match")
+	}
+	if matches([]string{"blue"}, "5", "Deep Blue") {
+		t.Error("Expected only whole names to match")
+	}
+}
@@ -0,0 +1,265 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/theatrus/ledbrick/controller/atomicfile"
+	"github.com/theatrus/ledbrick/controller/mdns"
+)
+
+// The service controllers advertise their API as
+const apiService = "_ledbrick._tcp.local"
+
+// contextsPath is the file contexts are kept in, under the user's config
+// directory if empty.
+var contextsPath string
+
+// savedContext is a named controller to run commands against.
+type savedContext struct {
+	API   string `json:"api"`
+	Token string `json:"token,omitempty"`
+}
+
+// contexts are the controllers ledbrickctl knows, and the one commands
+// run against by default.
+type contexts struct {
+	Current  string                  `json:"current,omitempty"`
+	Contexts map[string]savedContext `json:"contexts"`
+}
+
+func contextsFile() (string, error) {
+	if contextsPath != "" {
+		return contextsPath, nil
+	}
+	dir, err := os.UserConfigDir()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(dir, "ledbrickctl", "contexts.json"), nil
+}
+
+// loadContexts reads the contexts, none if there is no file yet.
+func loadContexts() (*contexts, error) {
+	cs := &contexts{Contexts: make(map[string]savedContext)}
+	file, err := contextsFile()
+	if err != nil {
+		return nil, err
+	}
+	data, err := ioutil.ReadFile(file)
+	if os.IsNotExist(err) {
+		return cs, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	if err := json.Unmarshal(data, cs); err != nil {
+		return nil, fmt.Errorf("%s: %v", file, err)
+	}
+	if cs.Contexts == nil {
+		cs.Contexts = make(map[string]savedContext)
+	}
+	return cs, nil
+}
+
+func (cs *contexts) save() error {
+	file, err := contextsFile()
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(file), 0700); err != nil {
+		return err
+	}
+	data, err := json.MarshalIndent(cs, "", "  ")
+	if err != nil {
+		return err
+	}
+	// Tokens are kept here, so only the user may read it
+	return atomicfile.WriteFile(file, data, 0600)
+}
+
+func (cs *contexts) names() []string {
+	var names []string
+	for name := range cs.Contexts {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// target is a controller a command runs against.
+type target struct {
+	name  string
+	api   string
+	token string
+}
+
+// targets works out the controllers to run a command against: those
+// named by -on, or all, else -context, else the current context unless
+// -api is given, else -api.
+func targets() ([]target, error) {
+	set := make(map[string]bool)
+	flag.Visit(func(f *flag.Flag) { set[f.Name] = true })
+	cs, err := loadContexts()
+	if err != nil {
+		return nil, err
+	}
+	var names []string
+	switch {
+	case *on == "all":
+		names = cs.names()
+		if len(names) == 0 {
+			return nil, fmt.Errorf("no contexts to run on, add them with context add or discover -save")
+		}
+	case *on != "":
+		names = strings.Split(*on, ",")
+	case *contextName != "":
+		names = []string{*contextName}
+	case !set["api"] && cs.Current != "":
+		names = []string{cs.Current}
+	default:
+		return []target{{api: *apiAddr, token: *token}}, nil
+	}
+	var ts []target
+	for _, name := range names {
+		ctx, ok := cs.Contexts[name]
+		if !ok {
+			return nil, fmt.Errorf("no context %q", name)
+		}
+		t := target{name: name, api: ctx.API, token: ctx.Token}
+		if set["token"] {
+			t.token = *token
+		}
+		ts = append(ts, t)
+	}
+	return ts, nil
+}
+
+// runContext lists the contexts, or changes them.
+func runContext(c *client, args []string) error {
+	cs, err := loadContexts()
+	if err != nil {
+		return err
+	}
+	if len(args) == 0 {
+		args = []string{"list"}
+	}
+	switch {
+	case args[0] == "list" && len(args) == 1:
+		if *format == "json" {
+			return writeJSON(os.Stdout, cs)
+		}
+		fmt.Printf("  %-20s %s\n", "NAME", "API")
+		for _, name := range cs.names() {
+			current := " "
+			if name == cs.Current {
+				current = "*"
+			}
+			fmt.Printf("%s %-20s %s\n", current, name, cs.Contexts[name].API)
+		}
+		return nil
+	case args[0] == "use" && len(args) == 2:
+		if _, ok := cs.Contexts[args[1]]; !ok {
+			return fmt.Errorf("no context %q", args[1])
+		}
+		cs.Current = args[1]
+	case args[0] == "add" && len(args) == 3:
+		cs.Contexts[args[1]] = savedContext{API: strings.TrimSuffix(args[2], "/"), Token: *token}
+		if cs.Current == "" {
+			cs.Current = args[1]
+		}
+	case args[0] == "rm" && len(args) == 2:
+		if _, ok := cs.Contexts[args[1]]; !ok {
+			return fmt.Errorf("no context %q", args[1])
+		}
+		delete(cs.Contexts, args[1])
+		if cs.Current == args[1] {
+			cs.Current = ""
+		}
+	default:
+		return fmt.Errorf("usage: ledbrickctl context [list | use <name> | add <name> <api> | rm <name>]")
+	}
+	return cs.save()
+}
+
+func completeContext(c *client, args []string) []string {
+	switch {
+	case len(args) == 0:
+		return []string{"list", "use", "add", "rm"}
+	case len(args) == 1 && (args[0] == "use" || args[0] == "rm"):
+		return contextNames()
+	}
+	return nil
+}
+
+// contextNames returns the names of the contexts, for completion.
+func contextNames() []string {
+	cs, err := loadContexts()
+	if err != nil {
+		return nil
+	}
+	return cs.names()
+}
+
+// discovered is a controller found advertising its API.
+type discovered struct {
+	Name string `json:"name"`
+	API  string `json:"api"`
+}
+
+// runDiscover lists the controllers advertising their API on the local
+// network, optionally saving each as a context.
+func runDiscover(c *client, args []string) error {
+	fs := flag.NewFlagSet("discover", flag.ExitOnError)
+	wait := fs.Duration("wait", 2*time.Second, "How long to wait for controllers to answer")
+	save := fs.Bool("save", false, "Save each controller found as a context named after it")
+	fs.Parse(args)
+
+	found, err := mdns.Browse(apiService, *wait)
+	if err != nil {
+		return err
+	}
+	list := make([]discovered, 0, len(found))
+	for name, addr := range found {
+		list = append(list, discovered{Name: name, API: "http://" + addr})
+	}
+	sort.Slice(list, func(i, j int) bool { return list[i].Name < list[j].Name })
+
+	if *save {
+		cs, err := loadContexts()
+		if err != nil {
+			return err
+		}
+		for _, d := range list {
+			ctx := cs.Contexts[d.Name]
+			ctx.API = d.API
+			cs.Contexts[d.Name] = ctx
+		}
+		if cs.Current == "" && len(list) > 0 {
+			cs.Current = list[0].Name
+		}
+		if err := cs.save(); err != nil {
+			return err
+		}
+	}
+
+	if *format == "json" {
+		return writeJSON(os.Stdout, list)
+	}
+	if len(list) == 0 {
+		fmt.Println("No controllers found")
+		return nil
+	}
+	fmt.Printf("%-20s %s\n", "NAME", "API")
+	for _, d := range list {
+		fmt.Printf("%-20s %s\n", d.Name, d.API)
+	}
+	return nil
+}
@@ -0,0 +1,64 @@
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func TestContexts(t *testing.T) {
+	dir, err := ioutil.TempDir("", "contexts")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	defer func() { contextsPath, *on, *contextName = "", "", "" }()
+	contextsPath = filepath.Join(dir, "ledbrickctl", "contexts.json")
+
+	// Nothing saved runs against -api
+	ts, err := targets()
+	if err != nil || len(ts) != 1 || ts[0].api != *apiAddr {
+		t.Fatalf("Expected -api, got %+v, %v", ts, err)
+	}
+
+	for _, args := range [][]string{
+		{"add", "reef", "http://reef.local:8080/"},
+		{"add", "frag", "http://frag.local:8080"},
+		{"add", "nano", "http://nano.local:8080"},
+		{"rm", "nano"},
+	} {
+		if err := runContext(nil, args); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := runContext(nil, []string{"use", "nano"}); err == nil {
+		t.Error("Expected a removed context not to be usable")
+	}
+
+	names := func(ts []target) []string {
+		var n []string
+		for _, t := range ts {
+			n = append(n, t.name)
+		}
+		return n
+	}
+	// The first added is current
+	ts, err = targets()
+	if err != nil || !reflect.DeepEqual(names(ts), []string{"reef"}) || ts[0].api != "http://reef.local:8080" {
+		t.Errorf("Expected the current context, got %+v, %v", ts, err)
+	}
+	*contextName = "frag"
+	if ts, err = targets(); err != nil || !reflect.DeepEqual(names(ts), []string{"frag"}) {
+		t.Errorf("Expected -context, got %+v, %v", ts, err)
+	}
+	*on = "all"
+	if ts, err = targets(); err != nil || !reflect.DeepEqual(names(ts), []string{"frag", "reef"}) {
+		t.Errorf("Expected every context, got %+v, %v", ts, err)
+	}
+	*on = "reef,nano"
+	if _, err = targets(); err == nil {
+		t.Error("Expected an unknown context to fail")
+	}
+}
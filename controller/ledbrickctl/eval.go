@@ -0,0 +1,54 @@
+package main
+
+import (
+	"fmt"
+	"net/url"
+	"strings"
+	"time"
+)
+
+type evalChannel struct {
+	Channel int     `json:"channel"`
+	Name    string  `json:"name"`
+	Setting float64 `json:"setting"`
+	Output  float64 `json:"output"`
+}
+
+type evaluation struct {
+	At       time.Time     `json:"at"`
+	Channels []evalChannel `json:"channels"`
+	Profile  string        `json:"profile"`
+	Effects  []string      `json:"effects"`
+}
+
+// runEval shows what the schedule gives at a time, as RFC 3339 or
+// hours:minutes today.
+func runEval(c *client, args []string) error {
+	path := "/schedule/eval"
+	if len(args) > 0 {
+		path += "?at=" + url.QueryEscape(args[0])
+	}
+	if *format == "json" {
+		return printRaw(c, path)
+	}
+	var e evaluation
+	if err := c.get(path, &e); err != nil {
+		return err
+	}
+	fmt.Printf("Schedule at %s", e.At.Local().Format("2006-01-02 15:04"))
+	if e.Profile != "" {
+		fmt.Printf(", profile %s", e.Profile)
+	}
+	if len(e.Effects) > 0 {
+		fmt.Printf(", with %s", strings.Join(e.Effects, ", "))
+	}
+	fmt.Println()
+	for _, ch := range e.Channels {
+		fmt.Printf("%d %-10s %s %5.1f%%", ch.Channel, ch.Name, bar(ch.Output, 40), ch.Output)
+		if ch.Output != ch.Setting {
+			fmt.Printf(" (table %.1f%%)", ch.Setting)
+		}
+		fmt.Println()
+	}
+	return nil
+}
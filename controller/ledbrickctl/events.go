@@ -0,0 +1,290 @@
+package main
+
+import (
+	"bufio"
+	"crypto/rand"
+	"crypto/tls"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+)
+
+// busEvent is an event from the controller's event bus.
+type busEvent struct {
+	Type string          `json:"type"`
+	At   time.Time       `json:"at"`
+	Data json.RawMessage `json:"data"`
+}
+
+// describe returns an event as a line of the log.
+func (e busEvent) describe() string {
+	at := e.At.Local().Format("15:04:05")
+	switch e.Type {
+	case "alert":
+		var a event
+		json.Unmarshal(e.Data, &a)
+		return fmt.Sprintf("%s %-8s %s %s: %s", at, severities[a.Severity], a.Source, a.Kind, a.Message)
+	case "connection":
+		var c struct {
+			Fixture   string `json:"fixture"`
+			Connected bool   `json:"connected"`
+		}
+		json.Unmarshal(e.Data, &c)
+		what := "disconnected"
+		if c.Connected {
+			what = "connected"
+		}
+		return fmt.Sprintf("%s %-8s %s %s", at, "info", c.Fixture, what)
+	case "channels":
+		var c struct {
+			Percents []float64 `json:"percents"`
+		}
+		json.Unmarshal(e.Data, &c)
+		percents := make([]string, len(c.Percents))
+		for i, p := range c.Percents {
+			percents[i] = fmt.Sprintf("%.1f%%", p)
+		}
+		return fmt.Sprintf("%s %-8s channels %s", at, "info", strings.Join(percents, " "))
+	case "schedule":
+		var s struct {
+			Points int `json:"points"`
+		}
+		json.Unmarshal(e.Data, &s)
+		return fmt.Sprintf("%s %-8s schedule applied, %d points", at, "info", s.Points)
+	case "effects":
+		var s struct {
+			By        string `json:"by"`
+			Suspended bool   `json:"suspended"`
+		}
+		json.Unmarshal(e.Data, &s)
+		what := "resumed"
+		if s.Suspended {
+			what = "suspended"
+		}
+		return fmt.Sprintf("%s %-8s effects %s by %s", at, "info", what, s.By)
+	}
+	return fmt.Sprintf("%s %-8s %s %s", at, "info", e.Type, e.Data)
+}
+
+// runEvents prints recent alerts, then with -follow streams the event
+// bus as it happens, like tail -f of the controller's log.
+func runEvents(c *client, args []string) error {
+	fs := flag.NewFlagSet("events", flag.ExitOnError)
+	follow := fs.Bool("follow", false, "Keep streaming events as they happen")
+	severity := fs.String("severity", "", "Only alerts at least this severe: info, warning or critical")
+	device := fs.String("device", "", "Only events from these comma separated fixtures or sources")
+	kind := fs.String("type", "", "Only these comma separated event types: alert, connection, channels, schedule, effects")
+	fs.Parse(args)
+
+	q := url.Values{}
+	for name, v := range map[string]string{"severity": *severity, "device": *device, "type": *kind} {
+		if v != "" {
+			q.Set(name, v)
+		}
+	}
+	// The recent history is alerts only, so skip it if they're
+	// filtered out
+	if *kind == "" || matches(strings.Split(*kind, ","), "alert") {
+		if err := printAlerts(c, q); err != nil {
+			return err
+		}
+	}
+	if !*follow {
+		return nil
+	}
+
+	conn, r, err := c.dialEvents(q)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+	for {
+		op, payload, err := readFrame(r)
+		if err != nil {
+			return err
+		}
+		switch op {
+		case opText:
+			if *format == "json" {
+				fmt.Printf("%s\n", payload)
+				continue
+			}
+			var e busEvent
+			if err := json.Unmarshal(payload, &e); err != nil {
+				return err
+			}
+			fmt.Println(e.describe())
+		case opPing:
+			if err := writeFrame(conn, opPong, payload); err != nil {
+				return err
+			}
+		case opClose:
+			return errors.New("the controller closed the event stream")
+		}
+	}
+}
+
+// printAlerts prints the recent alerts passing the filter, oldest first.
+func printAlerts(c *client, q url.Values) error {
+	var alerts []event
+	if err := c.get("/alerts", &alerts); err != nil {
+		return err
+	}
+	least := 0
+	for level, name := range severities {
+		if name == q.Get("severity") {
+			least = level
+		}
+	}
+	var devices []string
+	if d := q.Get("device"); d != "" {
+		devices = strings.Split(d, ",")
+	}
+	for i := len(alerts) - 1; i >= 0; i-- {
+		a := alerts[i]
+		if a.Severity < least || devices != nil && !matches(devices, a.Source) {
+			continue
+		}
+		if *format == "json" {
+			data, _ := json.Marshal(a)
+			fmt.Printf("%s\n", data)
+			continue
+		}
+		data, _ := json.Marshal(a)
+		fmt.Println(busEvent{Type: "alert", At: a.At, Data: data}.describe())
+	}
+	return nil
+}
+
+// dialEvents opens the controller's event stream WebSocket.
+func (c *client) dialEvents(q url.Values) (net.Conn, *bufio.Reader, error) {
+	u, err := url.Parse(c.base + "/events")
+	if err != nil {
+		return nil, nil, err
+	}
+	u.RawQuery = q.Encode()
+	host := u.Host
+	if u.Port() == "" {
+		host = net.JoinHostPort(u.Hostname(), map[string]string{"http": "80", "https": "443"}[u.Scheme])
+	}
+	dialer := &net.Dialer{Timeout: 5 * time.Second}
+	var conn net.Conn
+	if u.Scheme == "https" {
+		conn, err = tls.DialWithDialer(dialer, "tcp", host, &tls.Config{ServerName: u.Hostname()})
+	} else {
+		conn, err = dialer.Dial("tcp", host)
+	}
+	if err != nil {
+		return nil, nil, err
+	}
+
+	var nonce [16]byte
+	rand.Read(nonce[:])
+	req, err := http.NewRequest("GET", u.String(), nil)
+	if err != nil {
+		conn.Close()
+		return nil, nil, err
+	}
+	req.Header.Set("Upgrade", "websocket")
+	req.Header.Set("Connection", "Upgrade")
+	req.Header.Set("Sec-WebSocket-Version", "13")
+	req.Header.Set("Sec-WebSocket-Key", base64.StdEncoding.EncodeToString(nonce[:]))
+	if c.token != "" {
+		req.Header.Set("Authorization", "Bearer "+c.token)
+	}
+	if err := req.Write(conn); err != nil {
+		conn.Close()
+		return nil, nil, err
+	}
+	r := bufio.NewReader(conn)
+	resp, err := http.ReadResponse(r, req)
+	if err != nil {
+		conn.Close()
+		return nil, nil, err
+	}
+	if resp.StatusCode != http.StatusSwitchingProtocols {
+		defer conn.Close()
+		if _, err := c.decode("/events", resp); err != nil {
+			return nil, nil, err
+		}
+		return nil, nil, fmt.Errorf("GET /events: %s", resp.Status)
+	}
+	return conn, r, nil
+}
+
+// WebSocket opcodes
+const (
+	opText  = 0x1
+	opClose = 0x8
+	opPing  = 0x9
+	opPong  = 0xa
+)
+
+// writeFrame writes a masked frame, as a client must.
+func writeFrame(w io.Writer, op byte, payload []byte) error {
+	var mask [4]byte
+	rand.Read(mask[:])
+	// Control frames carry at most 125 bytes
+	b := append([]byte{0x80 | op, 0x80 | byte(len(payload))}, mask[:]...)
+	for i, v := range payload {
+		b = append(b, v^mask[i%4])
+	}
+	_, err := w.Write(b)
+	return err
+}
+
+// readFrame reads one of the server's frames, which aren't masked.
+func readFrame(r *bufio.Reader) (byte, []byte, error) {
+	var head [2]byte
+	if _, err := io.ReadFull(r, head[:]); err != nil {
+		return 0, nil, err
+	}
+	n := uint64(head[1] & 0x7f)
+	switch n {
+	case 126:
+		var ext [2]byte
+		if _, err := io.ReadFull(r, ext[:]); err != nil {
+			return 0, nil, err
+		}
+		n = uint64(binary.BigEndian.Uint16(ext[:]))
+	case 127:
+		var ext [8]byte
+		if _, err := io.ReadFull(r, ext[:]); err != nil {
+			return 0, nil, err
+		}
+		n = binary.BigEndian.Uint64(ext[:])
+	}
+	if n > 1<<24 {
+		return 0, nil, errors.New("event too large")
+	}
+	payload := make([]byte, n)
+	if _, err := io.ReadFull(r, payload); err != nil {
+		return 0, nil, err
+	}
+	return head[0] & 0x0f, payload, nil
+}
+
+// completeEventFlags offers the flags, and the values of the one
+// being given.
+func completeEventFlags(c *client, args []string) []string {
+	if len(args) > 0 {
+		switch args[len(args)-1] {
+		case "-severity":
+			return []string{"info", "warning", "critical"}
+		case "-type":
+			return []string{"alert", "connection", "channels", "schedule", "effects"}
+		case "-device":
+			return completeFixtures(c, nil)
+		}
+	}
+	return []string{"-follow", "-severity", "-device", "-type"}
+}
@@ -0,0 +1,67 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestDescribe(t *testing.T) {
+	at := time.Date(2016, 1, 1, 12, 0, 0, 0, time.Local)
+	for _, tc := range []struct {
+		e    busEvent
+		want string
+	}{
+		{busEvent{Type: "alert", At: at, Data: []byte(`{"severity":1,"source":"a","kind":"fan.slow","message":"fan at 400 RPM"}`)},
+			"12:00:00 warning  a fan.slow: fan at 400 RPM"},
+		{busEvent{Type: "connection", At: at, Data: []byte(`{"fixture":"b","connected":false}`)},
+			"12:00:00 info     b disconnected"},
+		{busEvent{Type: "channels", At: at, Data: []byte(`{"percents":[25,50.5]}`)},
+			"12:00:00 info     channels 25.0% 50.5%"},
+		{busEvent{Type: "effects", At: at, Data: []byte(`{"by":"feeding","suspended":true}`)},
+			"12:00:00 info     effects suspended by feeding"},
+	} {
+		if got := tc.e.describe(); got != tc.want {
+			t.Errorf("Expected %q, got %q", tc.want, got)
+		}
+	}
+}
+
+func TestReadFrame(t *testing.T) {
+	// A server's frames aren't masked, and longer ones carry their
+	// length after the header
+	payload := strings.Repeat("x", 300)
+	var buf bytes.Buffer
+	buf.Write([]byte{0x81, 126, 1, 44})
+	buf.WriteString(payload)
+	buf.Write([]byte{0x89, 0})
+	r := bufio.NewReader(&buf)
+
+	op, got, err := readFrame(r)
+	if err != nil || op != opText || string(got) != payload {
+		t.Fatalf("Unexpected frame %x %d bytes: %v", op, len(got), err)
+	}
+	if op, got, err = readFrame(r); err != nil || op != opPing || len(got) != 0 {
+		t.Fatalf("Expected a ping, got %x %q: %v", op, got, err)
+	}
+	if _, _, err = readFrame(r); err == nil {
+		t.Error("Expected an error at the end of the stream")
+	}
+}
+
+func TestWriteFrame(t *testing.T) {
+	var buf bytes.Buffer
+	if err := writeFrame(&buf, opPong, []byte("hi")); err != nil {
+		t.Fatal(err)
+	}
+	b := buf.Bytes()
+	if len(b) != 8 || b[0] != 0x8a || b[1] != 0x82 {
+		t.Fatalf("Unexpected frame %x", b)
+	}
+	// Clients mask their frames
+	if got := []byte{b[6] ^ b[2], b[7] ^ b[3]}; string(got) != "hi" {
+		t.Errorf("Expected the payload to unmask to hi, got %q", got)
+	}
+}
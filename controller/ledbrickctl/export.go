@@ -0,0 +1,73 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+)
+
+// exportSeries are the series the controller can export.
+var exportSeries = []string{"temperature", "fan", "channels", "dli"}
+
+// runExport writes telemetry from the controller's history as CSV, to
+// stdout or a file, for analysis in a spreadsheet.
+func runExport(c *client, args []string) error {
+	fs := flag.NewFlagSet("export", flag.ExitOnError)
+	from := fs.String("from", "", "Start of the range, as RFC 3339 or YYYY-MM-DD, a day ago if empty")
+	to := fs.String("to", "", "End of the range, as RFC 3339 or YYYY-MM-DD inclusive, now if empty")
+	out := fs.String("o", "", "File to write the CSV to, stdout if empty")
+	fs.Parse(args)
+
+	q := url.Values{}
+	if *from != "" {
+		q.Set("from", *from)
+	}
+	if *to != "" {
+		q.Set("to", *to)
+	}
+	if fs.NArg() > 0 {
+		q.Set("series", strings.Join(fs.Args(), ","))
+	}
+	path := "/export"
+	if len(q) > 0 {
+		path += "?" + q.Encode()
+	}
+	resp, err := c.fetch(path)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		// Errors still come in the envelope
+		_, err := c.decode(path, resp)
+		if err == nil {
+			err = fmt.Errorf("GET %s: %s", path, resp.Status)
+		}
+		return err
+	}
+
+	if *out == "" {
+		_, err := io.Copy(os.Stdout, resp.Body)
+		return err
+	}
+	f, err := os.Create(*out)
+	if err != nil {
+		return err
+	}
+	_, err = io.Copy(f, resp.Body)
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		os.Remove(*out)
+	}
+	return err
+}
+
+func completeSeries(c *client, args []string) []string {
+	return exportSeries
+}
@@ -0,0 +1,163 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// note is a journal entry with the lighting leading up to it.
+type note struct {
+	ID       int       `json:"id"`
+	At       time.Time `json:"at"`
+	Note     string    `json:"note"`
+	Tags     []string  `json:"tags"`
+	Tank     string    `json:"tank"`
+	Lighting struct {
+		DLI             *float64    `json:"dli"`
+		AverageDLI      *float64    `json:"average_dli"`
+		ScheduleChanges []time.Time `json:"schedule_changes"`
+	} `json:"lighting"`
+}
+
+// runJournal lists the tank journal, or with add or rm writes or
+// removes a note.
+func runJournal(c *client, args []string) error {
+	if len(args) > 0 {
+		switch args[0] {
+		case "add":
+			return addNote(c, args[1:])
+		case "rm":
+			if len(args) != 2 {
+				return fmt.Errorf("usage: ledbrickctl journal rm <id>")
+			}
+			if _, err := strconv.Atoi(args[1]); err != nil {
+				return fmt.Errorf("bad journal entry id %q", args[1])
+			}
+			path := "/journal/" + args[1]
+			resp, err := c.send("DELETE", path, nil)
+			if err != nil {
+				return err
+			}
+			defer resp.Body.Close()
+			_, err = c.decode(path, resp)
+			return err
+		}
+	}
+	fs := flag.NewFlagSet("journal", flag.ExitOnError)
+	tag := fs.String("tag", "", "Only notes with this tag")
+	days := fs.Int("days", 7, "Days of lighting before each note to show")
+	fs.Parse(args)
+
+	q := url.Values{}
+	q.Set("days", strconv.Itoa(*days))
+	if *tag != "" {
+		q.Set("tag", *tag)
+	}
+	path := "/journal?" + q.Encode()
+	if *format == "json" {
+		return printRaw(c, path)
+	}
+	var notes []note
+	if err := c.get(path, &notes); err != nil {
+		return err
+	}
+	renderJournal(os.Stdout, notes)
+	return nil
+}
+
+// addNote writes the rest of the arguments as a note.
+func addNote(c *client, args []string) error {
+	fs := flag.NewFlagSet("journal add", flag.ExitOnError)
+	tags := fs.String("tag", "", "Comma separated tags, e.g. dosed,new frag")
+	at := fs.String("at", "", "When it happened, as RFC 3339 or hours:minutes today, now if empty")
+	fs.Parse(args)
+	if fs.NArg() == 0 {
+		return fmt.Errorf("usage: ledbrickctl journal add [-tag t,...] [-at time] <note>")
+	}
+	entry := struct {
+		At   *time.Time `json:"at,omitempty"`
+		Note string     `json:"note"`
+		Tags []string   `json:"tags,omitempty"`
+	}{Note: strings.Join(fs.Args(), " ")}
+	if *tags != "" {
+		entry.Tags = strings.Split(*tags, ",")
+	}
+	if *at != "" {
+		t, err := parseWhen(*at)
+		if err != nil {
+			return err
+		}
+		entry.At = &t
+	}
+	body, err := json.Marshal(entry)
+	if err != nil {
+		return err
+	}
+	resp, err := c.send("POST", "/journal", bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	data, err := c.decode("/journal", resp)
+	if err != nil {
+		return err
+	}
+	var added note
+	if err := json.Unmarshal(data, &added); err != nil {
+		return err
+	}
+	if *format != "json" {
+		fmt.Printf("Added note %d\n", added.ID)
+	}
+	return nil
+}
+
+// renderJournal prints each note under the lighting leading up to it.
+func renderJournal(w io.Writer, notes []note) {
+	for _, n := range notes {
+		tags := ""
+		if len(n.Tags) > 0 {
+			tags = " [" + strings.Join(n.Tags, ", ") + "]"
+		}
+		fmt.Fprintf(w, "%4d %s %s%s\n", n.ID, n.At.Local().Format("2006-01-02 15:04"), n.Note, tags)
+		var context []string
+		if n.Lighting.DLI != nil {
+			context = append(context, fmt.Sprintf("DLI %.1f", *n.Lighting.DLI))
+		}
+		if n.Lighting.AverageDLI != nil {
+			context = append(context, fmt.Sprintf("%.1f the days before", *n.Lighting.AverageDLI))
+		}
+		switch changes := n.Lighting.ScheduleChanges; len(changes) {
+		case 0:
+		case 1:
+			context = append(context, "schedule changed "+changes[0].Local().Format("Jan 2 15:04"))
+		default:
+			context = append(context, fmt.Sprintf("schedule changed %d times, last %s",
+				len(changes), changes[len(changes)-1].Local().Format("Jan 2 15:04")))
+		}
+		if len(context) > 0 {
+			fmt.Fprintf(w, "     %s\n", strings.Join(context, ", "))
+		}
+	}
+}
+
+// parseWhen reads a time as RFC 3339, or hours:minutes today.
+func parseWhen(s string) (time.Time, error) {
+	if t, err := time.Parse(time.RFC3339, s); err == nil {
+		return t, nil
+	}
+	t, err := time.ParseInLocation("15:04", s, time.Local)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("bad time %q, expected RFC 3339 or hours:minutes", s)
+	}
+	now := time.Now()
+	return time.Date(now.Year(), now.Month(), now.Day(), t.Hour(), t.Minute(), 0, 0, time.Local), nil
+}
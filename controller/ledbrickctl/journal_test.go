@@ -0,0 +1,47 @@
+package main
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestRenderJournal(t *testing.T) {
+	at := time.Date(2016, 3, 4, 9, 30, 0, 0, time.Local)
+	dli, avg := 24.5, 30.0
+	n := note{ID: 3, At: at, Note: "Algae on the rocks", Tags: []string{"algae bloom"}}
+	n.Lighting.DLI = &dli
+	n.Lighting.AverageDLI = &avg
+	n.Lighting.ScheduleChanges = []time.Time{at.Add(-48 * time.Hour), at.Add(-24 * time.Hour)}
+	plain := note{ID: 4, At: at, Note: "Dosed"}
+
+	var buf bytes.Buffer
+	renderJournal(&buf, []note{n, plain})
+	out := buf.String()
+	for _, want := range []string{
+		"   3 2016-03-04 09:30 Algae on the rocks [algae bloom]\n",
+		"     DLI 24.5, 30.0 the days before, schedule changed 2 times, last Mar 3 09:30\n",
+		"   4 2016-03-04 09:30 Dosed\n",
+	} {
+		if !strings.Contains(out, want) {
+			t.Errorf("Expected %q in:\n%s", want, out)
+		}
+	}
+	if strings.Count(out, "\n") != 3 {
+		t.Errorf("Expected no lighting line for a note without any:\n%s", out)
+	}
+}
+
+func TestParseWhen(t *testing.T) {
+	got, err := parseWhen("07:15")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got.Hour() != 7 || got.Minute() != 15 || got.YearDay() != time.Now().YearDay() {
+		t.Errorf("Expected 07:15 today, got %v", got)
+	}
+	if _, err := parseWhen("yesterday"); err == nil {
+		t.Error("Expected an error for a bad time")
+	}
+}
@@ -0,0 +1,272 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"sort"
+	"strings"
+	"time"
+)
+
+var apiAddr = flag.String("api", "http://localhost:8080", "Address of the controller's HTTP API")
+var format = flag.String("format", "table", "Output format, table or json")
+var token = flag.String("token", os.Getenv("LEDBRICK_TOKEN"), "Bearer token for the controller's API, defaulting to $LEDBRICK_TOKEN")
+var tank = flag.String("tank", "", "Tank to scope commands to, empty for the whole controller")
+var contextName = flag.String("context", "", "Saved controller to run commands against, instead of the current context")
+var on = flag.String("on", "", "Comma separated saved controllers to run the command against one after another, or all")
+
+// command is one ledbrickctl verb.
+type command struct {
+	run func(c *client, args []string) error
+	// args and help describe the command for usage and help
+	args string
+	help string
+	// complete returns the candidates for an argument given those
+	// before it, or nil if it takes none
+	complete func(c *client, args []string) []string
+	// local commands don't talk to a controller, so run once even
+	// with -on
+	local bool
+}
+
+// commands maps each verb to its command, which gets the remaining
+// arguments.
+var commands map[string]command
+
+func init() {
+	commands = map[string]command{
+		"top": {run: runTop, args: "[-interval 2s]",
+			help: "live view of fixtures, channels and events"},
+		"events": {run: runEvents, args: "[-follow] [-severity s] [-device id,...] [-type t,...]",
+			help:     "recent alerts, or with -follow every event as it happens",
+			complete: completeEventFlags},
+		"status": {run: runStatus, args: "[channel...]",
+			help:     "channel outputs, limits and modes, optionally only the named channels",
+			complete: completeChannels},
+		"eval": {run: runEval, args: "[time]",
+			help: "what the schedule gives at a time, as RFC 3339 or hours:minutes today"},
+		"fixtures": {run: runFixtures, args: "[id...]",
+			help:     "connected fixtures, optionally only the given IDs",
+			complete: completeFixtures},
+		"name": {run: runName, args: "<id> [name]",
+			help:     "name a fixture, kept on the fixture where it can be, or clear its name",
+			complete: completeFixtures},
+		"journal": {run: runJournal, args: "[-tag t] [-days 7] | add [-tag t,...] [-at time] <note> | rm <id>",
+			help: "the tank journal with the lighting before each note, or add or remove a note"},
+		"support": {run: runSupport, args: "[file]",
+			help: "save a support bundle of config, logs and fixture state to attach to bug reports"},
+		"export": {run: runExport, args: "[-from date] [-to date] [-o file] [series...]",
+			help:     "telemetry history as CSV: temperature, fan, channels and dli, all by default",
+			complete: completeSeries},
+		"apply": {run: runApply, args: "-f tables.json [-dry-run] [-confirm]",
+			help: "make the controller's lighting tables match a JSON manifest, showing the plan first"},
+		"discover": {run: runDiscover, args: "[-wait 2s] [-save]",
+			help:  "find controllers on the local network, optionally saving them as contexts",
+			local: true},
+		"context": {run: runContext, args: "[list | use <name> | add <name> <api> | rm <name>]",
+			help:     "list or change the saved controllers, added with the -token given",
+			complete: completeContext, local: true},
+		"help": {run: runHelp, args: "[command]",
+			help:     "describe a command",
+			complete: completeCommands, local: true},
+		"completion": {run: runCompletion, args: "bash|zsh|fish",
+			help:     "print a shell completion script",
+			complete: completeShells, local: true},
+	}
+}
+
+// commandNames returns the verbs in order.
+func commandNames() []string {
+	names := make([]string, 0, len(commands))
+	for name := range commands {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+func usage() {
+	fmt.Fprintf(os.Stderr, "Usage: ledbrickctl [flags] <command> [args]\n\nCommands:\n")
+	for _, name := range commandNames() {
+		fmt.Fprintf(os.Stderr, "  %-11s %s\n", name, commands[name].help)
+	}
+	fmt.Fprintf(os.Stderr, "\nFlags:\n")
+	flag.PrintDefaults()
+}
+
+// runHelp describes one command, or all of them.
+func runHelp(c *client, args []string) error {
+	if len(args) == 0 {
+		usage()
+		return nil
+	}
+	cmd, ok := commands[args[0]]
+	if !ok {
+		return fmt.Errorf("unknown command %q", args[0])
+	}
+	fmt.Printf("Usage: ledbrickctl [flags] %s %s\n\n%s\n", args[0], cmd.args, cmd.help)
+	return nil
+}
+
+func main() {
+	flag.Usage = usage
+	flag.Parse()
+	// Completion runs on every tab press, so it has its own short
+	// timeout and never reports errors
+	if flag.Arg(0) == completeVerb {
+		c := newClient(*apiAddr, time.Second)
+		if cs, err := loadContexts(); err == nil && cs.Current != "" {
+			c.setFlag("context", cs.Current)
+		}
+		for _, candidate := range complete(c, flag.Args()[1:]) {
+			fmt.Println(candidate)
+		}
+		return
+	}
+	cmd, ok := commands[flag.Arg(0)]
+	if !ok || (*format != "table" && *format != "json") {
+		usage()
+		os.Exit(2)
+	}
+	if cmd.local {
+		if err := cmd.run(newClient(*apiAddr, 5*time.Second), flag.Args()[1:]); err != nil {
+			fmt.Fprintf(os.Stderr, "ledbrickctl: %v\n", err)
+			os.Exit(1)
+		}
+		return
+	}
+	ts, err := targets()
+	if err == nil && len(ts) > 1 && (flag.Arg(0) == "top" || flag.Arg(0) == "events") {
+		err = fmt.Errorf("%s runs against one controller at a time", flag.Arg(0))
+	}
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "ledbrickctl: %v\n", err)
+		os.Exit(2)
+	}
+	failed := false
+	for _, t := range ts {
+		c := newClient(t.api, 5*time.Second)
+		c.token = t.token
+		if *tank != "" {
+			c.base += "/tanks/" + url.PathEscape(*tank)
+		}
+		if *on != "" && *format != "json" {
+			fmt.Printf("== %s (%s)\n", t.name, t.api)
+		}
+		if err := cmd.run(c, flag.Args()[1:]); err != nil {
+			prefix := "ledbrickctl"
+			if *on != "" {
+				prefix += ": " + t.name
+			}
+			fmt.Fprintf(os.Stderr, "%s: %v\n", prefix, err)
+			failed = true
+		}
+	}
+	if failed {
+		os.Exit(1)
+	}
+}
+
+// client fetches JSON from the controller API.
+type client struct {
+	base  string
+	token string
+	http  *http.Client
+}
+
+func newClient(addr string, timeout time.Duration) *client {
+	return &client{base: strings.TrimSuffix(addr, "/"),
+		token: *token,
+		http:  &http.Client{Timeout: timeout},
+	}
+}
+
+// fetch GETs a path, with the token if there is one.
+func (c *client) fetch(path string) (*http.Response, error) {
+	return c.send("GET", path, nil)
+}
+
+// send makes a request with the token if there is one.
+func (c *client) send(method, path string, body io.Reader) (*http.Response, error) {
+	req, err := http.NewRequest(method, c.base+path, body)
+	if err != nil {
+		return nil, err
+	}
+	if c.token != "" {
+		req.Header.Set("Authorization", "Bearer "+c.token)
+	}
+	if body != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	return c.http.Do(req)
+}
+
+// envelope is how the API wraps every response.
+type envelope struct {
+	Data  json.RawMessage `json:"data"`
+	Error string          `json:"error"`
+}
+
+// raw fetches a path and returns the data from its envelope.
+func (c *client) raw(path string) (json.RawMessage, error) {
+	resp, err := c.fetch(path)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	return c.decode(path, resp)
+}
+
+// decode reads a response's envelope, returning its data or its error.
+func (c *client) decode(path string, resp *http.Response) (json.RawMessage, error) {
+	method := "GET"
+	if resp.Request != nil {
+		method = resp.Request.Method
+	}
+	var e envelope
+	if err := json.NewDecoder(resp.Body).Decode(&e); err != nil {
+		return nil, fmt.Errorf("%s %s: %s", method, path, resp.Status)
+	}
+	if e.Error != "" {
+		return nil, fmt.Errorf("%s %s: %s", method, path, e.Error)
+	}
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("%s %s: %s", method, path, resp.Status)
+	}
+	return e.Data, nil
+}
+
+func (c *client) get(path string, v interface{}) error {
+	data, err := c.raw(path)
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(data, v)
+}
+
+// writeJSON prints a value for -format=json, indented so it is
+// readable as well as parseable.
+func writeJSON(w io.Writer, v interface{}) error {
+	e := json.NewEncoder(w)
+	e.SetIndent("", "  ")
+	return e.Encode(v)
+}
+
+// printRaw writes the API's data for a path unchanged, so json output
+// carries every field even where the table leaves some out.
+func printRaw(c *client, path string) error {
+	data, err := c.raw(path)
+	if err != nil {
+		return err
+	}
+	var v interface{}
+	if err := json.Unmarshal(data, &v); err != nil {
+		return fmt.Errorf("GET %s: %v", path, err)
+	}
+	return writeJSON(os.Stdout, v)
+}
@@ -0,0 +1,34 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestClientEnvelope(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/missing" {
+			w.WriteHeader(http.StatusNotFound)
+			w.Write([]byte(`{"error":"no such peripheral","meta":{}}`))
+			return
+		}
+		w.Write([]byte(`{"data":[{"id":"a","temperature":40}],"meta":{"count":1}}`))
+	}))
+	defer srv.Close()
+	c := &client{base: srv.URL, http: srv.Client()}
+
+	var fixtures []fixture
+	if err := c.get("/peripherals", &fixtures); err != nil {
+		t.Fatal(err)
+	}
+	if len(fixtures) != 1 || fixtures[0].ID != "a" || fixtures[0].Temperature != 40 {
+		t.Errorf("Unexpected fixtures %+v", fixtures)
+	}
+
+	err := c.get("/missing", &fixtures)
+	if err == nil || !strings.Contains(err.Error(), "no such peripheral") {
+		t.Errorf("Expected the envelope's error, got %v", err)
+	}
+}
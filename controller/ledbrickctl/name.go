@@ -0,0 +1,40 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/url"
+)
+
+// runName names a fixture, or clears its name without one.
+func runName(c *client, args []string) error {
+	if len(args) < 1 || len(args) > 2 {
+		return fmt.Errorf("usage: ledbrickctl name <id> [name]")
+	}
+	name := ""
+	if len(args) == 2 {
+		name = args[1]
+	}
+	body, err := json.Marshal(map[string]string{"name": name})
+	if err != nil {
+		return err
+	}
+	path := "/peripherals/" + url.PathEscape(args[0]) + "/name"
+	resp, err := c.send("PUT", path, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if _, err := c.decode(path, resp); err != nil {
+		return err
+	}
+	if *format != "json" {
+		if name == "" {
+			fmt.Printf("Cleared the name of %s\n", args[0])
+		} else {
+			fmt.Printf("Named %s %q\n", args[0], name)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,98 @@
+package main
+
+import (
+	"os"
+	"strconv"
+	"strings"
+)
+
+// runStatus prints the channel outputs and anything limiting them, only
+// for the channels named if any are.
+func runStatus(c *client, args []string) error {
+	if *format == "json" {
+		var s map[string]interface{}
+		if err := c.get("/status", &s); err != nil {
+			return err
+		}
+		if len(args) > 0 {
+			channels, _ := s["channels"].([]interface{})
+			var matched []interface{}
+			for _, ch := range channels {
+				m, _ := ch.(map[string]interface{})
+				n, _ := m["channel"].(float64)
+				name, _ := m["name"].(string)
+				if matches(args, strconv.Itoa(int(n)), name) {
+					matched = append(matched, ch)
+				}
+			}
+			s["channels"] = matched
+		}
+		return writeJSON(os.Stdout, s)
+	}
+	var s status
+	if err := c.get("/status", &s); err != nil {
+		return err
+	}
+	var channels []channel
+	for _, ch := range s.Channels {
+		if len(args) == 0 || matches(args, strconv.Itoa(ch.Channel), ch.Name) {
+			channels = append(channels, ch)
+		}
+	}
+	renderModes(os.Stdout, s)
+	renderChannels(os.Stdout, channels)
+	if len(args) == 0 {
+		renderAccessories(os.Stdout, s.Accessories)
+	}
+	return nil
+}
+
+// runFixtures lists the connected fixtures, only those with the IDs
+// given if any are.
+func runFixtures(c *client, args []string) error {
+	if *format == "json" {
+		// Decoded loosely so json output carries every field, even
+		// those the table leaves out
+		var fixtures []map[string]interface{}
+		if err := c.get("/peripherals", &fixtures); err != nil {
+			return err
+		}
+		matched := make([]map[string]interface{}, 0, len(fixtures))
+		for _, f := range fixtures {
+			id, _ := f["id"].(string)
+			if len(args) == 0 || matches(args, id) {
+				matched = append(matched, f)
+			}
+		}
+		return writeJSON(os.Stdout, matched)
+	}
+	var fixtures []fixture
+	if err := c.get("/peripherals", &fixtures); err != nil {
+		return err
+	}
+	var matched []fixture
+	for _, f := range fixtures {
+		if len(args) == 0 || matches(args, f.ID) {
+			matched = append(matched, f)
+		}
+	}
+	renderFixtures(os.Stdout, matched, temperatureUnit(c))
+	return nil
+}
+
+// matches reports whether any argument is one of the names, ignoring
+// case and spaces so "pcamber" finds "PC Amber".
+func matches(args []string, names ...string) bool {
+	for _, arg := range args {
+		for _, name := range names {
+			if strings.EqualFold(squash(arg), squash(name)) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+func squash(s string) string {
+	return strings.Replace(s, " ", "", -1)
+}
@@ -0,0 +1,49 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"time"
+)
+
+// runSupport saves a support bundle from the controller, to attach to a
+// bug report.
+func runSupport(c *client, args []string) error {
+	file := fmt.Sprintf("ledbrick-support-%s.tar.gz", time.Now().Format("20060102-150405"))
+	if len(args) > 0 {
+		file = args[0]
+	}
+	resp, err := c.fetch("/support/bundle")
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		// Errors still come in the envelope
+		_, err := c.decode("/support/bundle", resp)
+		if err == nil {
+			err = fmt.Errorf("GET /support/bundle: %s", resp.Status)
+		}
+		return err
+	}
+
+	f, err := os.Create(file)
+	if err != nil {
+		return err
+	}
+	n, err := io.Copy(f, resp.Body)
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		os.Remove(file)
+		return err
+	}
+	if *format == "json" {
+		return writeJSON(os.Stdout, map[string]interface{}{"file": file, "bytes": n})
+	}
+	fmt.Printf("Wrote %s (%d bytes)\n", file, n)
+	return nil
+}
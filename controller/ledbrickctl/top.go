@@ -0,0 +1,270 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"strings"
+	"time"
+)
+
+// The API's JSON, only the fields shown here.
+type fixture struct {
+	ID            string         `json:"id"`
+	Name          string         `json:"name"`
+	Temperature   int            `json:"temperature"`
+	FanRPM        int            `json:"fan_rpm"`
+	FanFailed     bool           `json:"fan_failed"`
+	Watts         float64        `json:"watts"`
+	ChannelFaults map[int]string `json:"channel_faults"`
+}
+
+type channel struct {
+	Channel int     `json:"channel"`
+	Name    string  `json:"name"`
+	Percent float64 `json:"percent"`
+}
+
+type status struct {
+	Channels         []channel          `json:"channels"`
+	Degraded         bool               `json:"degraded"`
+	EffectsSuspended bool               `json:"effects_suspended"`
+	OnBattery        bool               `json:"on_battery"`
+	Limits           map[string]float64 `json:"limits"`
+	Scales           map[string]float64 `json:"scales"`
+	// TemperatureUnit is C or F, temperatures themselves are always C
+	TemperatureUnit string      `json:"temperature_unit"`
+	Accessories     []accessory `json:"accessories"`
+	// Network are the internet-dependent features, and how each is
+	// getting by if it can't reach its server
+	Network []service `json:"network"`
+}
+
+type service struct {
+	Name     string `json:"name"`
+	Online   bool   `json:"online"`
+	Fallback string `json:"fallback"`
+}
+
+// accessory is an output other than the LEDs the table schedules.
+type accessory struct {
+	Name    string  `json:"name"`
+	Type    string  `json:"type"`
+	Percent float64 `json:"percent"`
+	On      *bool   `json:"on"`
+	Error   string  `json:"error"`
+}
+
+type event struct {
+	Severity int       `json:"severity"`
+	Source   string    `json:"source"`
+	Kind     string    `json:"kind"`
+	Message  string    `json:"message"`
+	At       time.Time `json:"at"`
+	Acked    bool      `json:"acked"`
+}
+
+type snapshot struct {
+	fixtures []fixture
+	status   status
+	events   []event
+}
+
+// export is the snapshot as written for -format=json.
+func (s *snapshot) export() interface{} {
+	return struct {
+		Fixtures []fixture `json:"fixtures"`
+		Status   status    `json:"status"`
+		Events   []event   `json:"events"`
+	}{s.fixtures, s.status, s.events}
+}
+
+func (c *client) snapshot() (*snapshot, error) {
+	s := &snapshot{}
+	if err := c.get("/peripherals", &s.fixtures); err != nil {
+		return nil, err
+	}
+	if err := c.get("/status", &s.status); err != nil {
+		return nil, err
+	}
+	if err := c.get("/alerts", &s.events); err != nil {
+		return nil, err
+	}
+	return s, nil
+}
+
+// How many recent events to show
+const topEvents = 8
+
+func runTop(c *client, args []string) error {
+	fs := flag.NewFlagSet("top", flag.ExitOnError)
+	interval := fs.Duration("interval", 2*time.Second, "How often to refresh")
+	fs.Parse(args)
+
+	for {
+		var buf bytes.Buffer
+		s, err := c.snapshot()
+		if *format == "json" {
+			// One line per refresh, so it can be piped
+			if err != nil {
+				return err
+			}
+			if err := json.NewEncoder(os.Stdout).Encode(s.export()); err != nil {
+				return err
+			}
+			time.Sleep(*interval)
+			continue
+		}
+		if err != nil {
+			fmt.Fprintf(&buf, "ledbrickctl: %v\n", err)
+		} else {
+			render(&buf, s, time.Now())
+		}
+		// Home the cursor and clear, then draw the frame in one write
+		// so it doesn't flicker
+		fmt.Fprint(os.Stdout, "\x1b[H\x1b[2J")
+		os.Stdout.Write(buf.Bytes())
+		time.Sleep(*interval)
+	}
+}
+
+func render(w io.Writer, s *snapshot, now time.Time) {
+	fmt.Fprintf(w, "ledbrick  %s  %d fixtures\n", now.Format("15:04:05"), len(s.fixtures))
+	renderModes(w, s.status)
+	fmt.Fprintln(w)
+	renderFixtures(w, s.fixtures, s.status.TemperatureUnit)
+	fmt.Fprintln(w)
+	renderChannels(w, s.status.Channels)
+	renderAccessories(w, s.status.Accessories)
+
+	fmt.Fprintf(w, "\nRECENT EVENTS\n")
+	events := s.events
+	if len(events) > topEvents {
+		events = events[len(events)-topEvents:]
+	}
+	for i := len(events) - 1; i >= 0; i-- {
+		e := events[i]
+		ack := ""
+		if e.Acked {
+			ack = " (acked)"
+		}
+		fmt.Fprintf(w, "%s %-8s %s %s: %s%s\n", e.At.Local().Format("15:04:05"),
+			severities[e.Severity], e.Source, e.Kind, e.Message, ack)
+	}
+}
+
+// renderModes writes a line of anything holding the output back, if
+// there is any.
+func renderModes(w io.Writer, s status) {
+	var modes []string
+	if s.Degraded {
+		modes = append(modes, "DEGRADED")
+	}
+	if s.OnBattery {
+		modes = append(modes, "ON BATTERY")
+	}
+	if s.EffectsSuspended {
+		modes = append(modes, "effects suspended")
+	}
+	for _, sv := range s.Network {
+		if !sv.Online {
+			modes = append(modes, fmt.Sprintf("OFFLINE %s, %s", sv.Name, sv.Fallback))
+		}
+	}
+	for _, name := range sortedKeys(s.Limits) {
+		modes = append(modes, fmt.Sprintf("limit %s %.0f%%", name, s.Limits[name]))
+	}
+	for _, name := range sortedKeys(s.Scales) {
+		modes = append(modes, fmt.Sprintf("scale %s x%.2f", name, s.Scales[name]))
+	}
+	if len(modes) > 0 {
+		fmt.Fprintf(w, "%s\n", strings.Join(modes, ", "))
+	}
+}
+
+func renderFixtures(w io.Writer, fixtures []fixture, unit string) {
+	fmt.Fprintf(w, "%-20s %-16s %6s %8s %7s  %s\n", "FIXTURE", "NAME", "TEMP", "FAN", "WATTS", "FAULTS")
+	sort.Slice(fixtures, func(i, j int) bool { return fixtures[i].ID < fixtures[j].ID })
+	for _, f := range fixtures {
+		fan := fmt.Sprintf("%d", f.FanRPM)
+		if f.FanFailed {
+			fan = "FAILED"
+		}
+		var faults []string
+		for ch, fault := range f.ChannelFaults {
+			faults = append(faults, fmt.Sprintf("ch%d %s", ch, fault))
+		}
+		sort.Strings(faults)
+		fmt.Fprintf(w, "%-20s %-16s %6s %8s %7.1f  %s\n", f.ID, f.Name, temperature(f.Temperature, unit), fan, f.Watts,
+			strings.Join(faults, ", "))
+	}
+}
+
+// temperature shows a fixture temperature in the controller's unit.
+func temperature(celsius int, unit string) string {
+	if unit == "F" {
+		return fmt.Sprintf("%.0f F", float64(celsius)*9/5+32)
+	}
+	return fmt.Sprintf("%d C", celsius)
+}
+
+// temperatureUnit asks the controller which unit temperatures are shown
+// in, C if it can't say.
+func temperatureUnit(c *client) string {
+	var s status
+	if err := c.get("/status", &s); err != nil {
+		return "C"
+	}
+	return s.TemperatureUnit
+}
+
+func renderChannels(w io.Writer, channels []channel) {
+	for _, ch := range channels {
+		fmt.Fprintf(w, "%d %-10s %s %5.1f%%\n", ch.Channel, ch.Name, bar(ch.Percent, 40), ch.Percent)
+	}
+}
+
+// renderAccessories shows relays as on or off, and the rest as channels
+// are.
+func renderAccessories(w io.Writer, accessories []accessory) {
+	for _, a := range accessories {
+		setting := fmt.Sprintf("%s %5.1f%%", bar(a.Percent, 40), a.Percent)
+		if a.On != nil {
+			setting = "off"
+			if *a.On {
+				setting = "on"
+			}
+		}
+		if a.Error != "" {
+			setting += " FAILED: " + a.Error
+		}
+		fmt.Fprintf(w, "- %-10s %s\n", a.Name, setting)
+	}
+}
+
+var severities = map[int]string{0: "info", 1: "warning", 2: "critical"}
+
+// bar draws a percent as a bar of width characters.
+func bar(percent float64, width int) string {
+	n := int(percent/100*float64(width) + 0.5)
+	if n > width {
+		n = width
+	}
+	if n < 0 {
+		n = 0
+	}
+	return "[" + strings.Repeat("#", n) + strings.Repeat(" ", width-n) + "]"
+}
+
+func sortedKeys(m map[string]float64) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
@@ -0,0 +1,67 @@
+package main
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestBar(t *testing.T) {
+	if b := bar(50, 10); b != "[#####     ]" {
+		t.Errorf("Unexpected bar %q", b)
+	}
+	if b := bar(120, 4); b != "[####]" {
+		t.Errorf("Expected a full bar, got %q", b)
+	}
+}
+
+func TestRender(t *testing.T) {
+	now := time.Date(2016, 1, 1, 12, 0, 0, 0, time.Local)
+	on := true
+	s := &snapshot{
+		fixtures: []fixture{
+			{ID: "b", Temperature: 41, FanRPM: 1200},
+			{ID: "a", Temperature: 38, FanFailed: true, ChannelFaults: map[int]string{3: "open"}},
+		},
+		status: status{
+			Channels:  []channel{{Channel: 0, Name: "Green", Percent: 25}},
+			OnBattery: true,
+			Limits:    map[string]float64{"ups": 20},
+			// Fixtures still report C
+			TemperatureUnit: "F",
+			Accessories: []accessory{
+				{Name: "pump", Type: "relay", Percent: 100, On: &on},
+				{Name: "port", Type: "voltage", Percent: 50, Error: "no PWM"},
+			},
+			Network: []service{
+				{Name: "calendar.ical", Fallback: "running the 2 events cached"},
+				{Name: "push", Online: true},
+			},
+		},
+		events: []event{
+			{Severity: 2, Source: "a", Kind: "fan.failed", Message: "fan stopped", At: now},
+		},
+	}
+	var buf bytes.Buffer
+	render(&buf, s, now)
+	out := buf.String()
+	for _, want := range []string{
+		"2 fixtures",
+		"ON BATTERY, OFFLINE calendar.ical, running the 2 events cached, limit ups 20%",
+		"FAILED",
+		"ch3 open",
+		"106 F",
+		"0 Green      [##########                              ]  25.0%",
+		"critical a fan.failed: fan stopped",
+		"- pump       on\n",
+		"- port       [####################                    ]  50.0% FAILED: no PWM",
+	} {
+		if !strings.Contains(out, want) {
+			t.Errorf("Expected %q in:\n%s", want, out)
+		}
+	}
+	if strings.Index(out, "\na ") > strings.Index(out, "\nb ") {
+		t.Error("Expected fixtures sorted by ID")
+	}
+}
@@ -0,0 +1,87 @@
+package ltable
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"log"
+	"time"
+
+	"github.com/theatrus/ledbrick/controller/alert"
+	"github.com/theatrus/ledbrick/controller/events"
+)
+
+var grace time.Duration
+
+func init() {
+	flag.DurationVar(&grace, "ltable.grace", 2*time.Minute,
+		"How long a newly applied table is watched for errors before it is kept")
+}
+
+// Apply replaces the running table with a new one in two phases. The
+// table is parsed and validated first, leaving the running one alone if
+// it has any problems. It is then swapped in whole and put on trial: if
+// writing it fails, or the link becomes degraded, before the grace
+// window ends, the last known good table is put back and an alert
+// raised.
+func (ld *LightDriver) Apply(data []byte) error {
+	sc, err := ParseSchedule(data)
+	if err != nil {
+		return err
+	}
+	ld.mu.Lock()
+	if ld.reverse {
+		sc = sc.Reversed()
+	}
+	ld.mu.Unlock()
+	return ld.try(sc)
+}
+
+// try swaps in a running table and puts it on trial.
+func (ld *LightDriver) try(sc *Schedule) error {
+	ld.mu.Lock()
+	// A table replacing one still on trial falls back to the last one
+	// which was kept, not the one on trial
+	if ld.trial.IsZero() {
+		ld.good = ld.schedule
+	}
+	ld.schedule = sc
+	ld.trial = ld.clock.Now().Add(grace)
+	ld.trialDegraded = ld.ble.Degraded()
+	ld.mu.Unlock()
+
+	log.Printf("Applied a new lighting table, keeping it after %s without errors", grace)
+	events.Publish(events.Schedule, events.ScheduleData{Points: len(sc.at)})
+	return ld.updateChannels()
+}
+
+// checkTrial rolls back a table on trial if writing it failed or the
+// link has become degraded since it was applied, or keeps it once the
+// grace window is over. It returns why it rolled back, if it did.
+func (ld *LightDriver) checkTrial(failed error) error {
+	ld.mu.Lock()
+	if ld.trial.IsZero() {
+		ld.mu.Unlock()
+		return nil
+	}
+	if failed == nil && !ld.trialDegraded && ld.ble.Degraded() {
+		failed = errors.New("the link became degraded")
+	}
+	if failed == nil {
+		if ld.clock.Now().After(ld.trial) {
+			ld.good = nil
+			ld.trial = time.Time{}
+			log.Printf("New lighting table kept")
+		}
+		ld.mu.Unlock()
+		return nil
+	}
+	ld.schedule = ld.good
+	ld.good = nil
+	ld.trial = time.Time{}
+	ld.mu.Unlock()
+
+	alert.Raise(alert.Critical, "schedule", "schedule.rollback",
+		"new lighting table rolled back to the last good one: %v", failed)
+	return fmt.Errorf("rolled back: %v", failed)
+}
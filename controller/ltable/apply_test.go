@@ -0,0 +1,182 @@
+package ltable
+
+import (
+	"errors"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/theatrus/ledbrick/controller/ble"
+	"github.com/theatrus/ledbrick/controller/ble/bletest"
+	"github.com/theatrus/ledbrick/controller/clock"
+)
+
+// fakeChannel records channel writes, failing them if fail is set.
+type fakeChannel struct {
+	ble.LightChannel
+
+	mu       sync.Mutex
+	fail     error
+	degraded bool
+	set      map[int]float64
+	programs []ble.Program
+}
+
+func (f *fakeChannel) SetChannel(channel int, percent float64) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if f.fail != nil {
+		return f.fail
+	}
+	if f.set == nil {
+		f.set = make(map[int]float64)
+	}
+	f.set[channel] = percent
+	return nil
+}
+
+func (f *fakeChannel) SetProgram(prog ble.Program) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.programs = append(f.programs, prog)
+}
+
+func (f *fakeChannel) get(channel int) float64 {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.set[channel]
+}
+
+func (f *fakeChannel) Channels() map[int]float64 {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	channels := make(map[int]float64)
+	for channel, v := range f.set {
+		channels[channel] = v
+	}
+	return channels
+}
+
+func (f *fakeChannel) Degraded() bool {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.degraded
+}
+
+// Power is 1 W per percent on channel 0.
+func (f *fakeChannel) Power(percents map[int]float64) float64 {
+	return percents[0]
+}
+
+func flat(percent string) []byte {
+	return []byte(`[{"at": "00:00", "percents": [` + percent + `, 0, 0, 0, 0, 0, 0, 0]}]`)
+}
+
+func newTestDriver(t *testing.T, f *fakeChannel) (*LightDriver, *clock.Fake) {
+	sc, err := ParseSchedule(flat("10"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	c := clock.NewFake(time.Date(2016, 1, 1, 12, 0, 0, 0, time.UTC))
+	ld := &LightDriver{ble: f, schedule: sc, clock: c}
+	ld.updateChannels()
+	return ld, c
+}
+
+func TestApply(t *testing.T) {
+	f := &fakeChannel{}
+	ld, c := newTestDriver(t, f)
+
+	if err := ld.Apply([]byte(`[{"at": "00:00"}]`)); err == nil {
+		t.Error("Expected a table with problems not to apply")
+	}
+	if f.set[0] != 10 {
+		t.Errorf("Expected the running table to be left alone, got %.0f", f.set[0])
+	}
+
+	if err := ld.Apply(flat("20")); err != nil {
+		t.Fatal(err)
+	}
+	if f.set[0] != 20 {
+		t.Errorf("Expected the new table to be written, got %.0f", f.set[0])
+	}
+	if len(f.programs) != 2 || f.programs[1].Points[0].Percents[0] != 20 {
+		t.Errorf("Expected the new table sent for fixtures to run, got %v", f.programs)
+	}
+	c.Advance(grace)
+	ld.updateChannels()
+	if ld.trial.IsZero() {
+		t.Error("Expected the table on trial until after the grace window")
+	}
+	c.Advance(time.Second)
+	ld.updateChannels()
+	if !ld.trial.IsZero() || ld.good != nil {
+		t.Error("Expected the table to be kept after the grace window")
+	}
+}
+
+func TestApplyRollback(t *testing.T) {
+	f := &fakeChannel{}
+	ld, _ := newTestDriver(t, f)
+
+	if err := ld.Apply(flat("20")); err != nil {
+		t.Fatal(err)
+	}
+	// A second table on trial rolls back to the last kept one
+	if err := ld.Apply(flat("30")); err != nil {
+		t.Fatal(err)
+	}
+	f.degraded = true
+	if err := ld.updateChannels(); err == nil {
+		t.Error("Expected the link degrading to roll back")
+	}
+	if f.set[0] != 10 {
+		t.Errorf("Expected the last good table back, got %.0f", f.set[0])
+	}
+	if p := f.programs[len(f.programs)-1]; p.Points[0].Percents[0] != 10 {
+		t.Errorf("Expected fixtures sent the last good table back, got %v", p)
+	}
+
+	f.degraded = false
+	f.fail = errors.New("write failed")
+	if err := ld.Apply(flat("40")); err == nil {
+		t.Error("Expected a failed write to roll back")
+	}
+	if ld.current() != ld.schedule || !ld.trial.IsZero() {
+		t.Error("Expected the trial to be over")
+	}
+	f.fail = nil
+	ld.updateChannels()
+	if f.set[0] != 10 {
+		t.Errorf("Expected the last good table back, got %.0f", f.set[0])
+	}
+}
+
+func TestApplyOnFixtures(t *testing.T) {
+	b := bletest.NewChannel()
+	left := bletest.NewPeripheral("left")
+	b.Connect(left)
+	sc, err := ParseSchedule(flat("10"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	ld := &LightDriver{ble: b, schedule: sc, clock: clock.NewFake(time.Date(2016, 1, 1, 12, 0, 0, 0, time.UTC))}
+	ld.updateChannels()
+
+	if err := ld.Apply(flat("20")); err != nil {
+		t.Fatal(err)
+	}
+	if p, _ := b.Setting(0); p != 20 || left.Writes() == 0 {
+		t.Errorf("Expected the table written to the fixture, got %.0f in %d writes", p, left.Writes())
+	}
+	if prog, ok := b.Program(); !ok || prog.Points[0].Percents[0] != 20 {
+		t.Errorf("Expected the fixture sent the table, got %v", prog)
+	}
+	b.SetDegraded(true)
+	if err := ld.updateChannels(); err == nil {
+		t.Error("Expected the link degrading to roll back")
+	}
+	if p, _ := b.Setting(0); p != 10 {
+		t.Errorf("Expected the last good table back, got %.0f", p)
+	}
+}
@@ -0,0 +1,225 @@
+package ltable
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"math"
+	"sort"
+	"time"
+)
+
+// BiotopeMonth is a month's climate at a reef.
+type BiotopeMonth struct {
+	// Photoperiod is the day length in hours
+	Photoperiod float64 `json:"photoperiod"`
+	// Intensity is the clear sky light at noon, relative to the
+	// brightest month
+	Intensity float64 `json:"intensity"`
+	// Cloud is the mean cloud cover, from 0 to 1
+	Cloud float64 `json:"cloud"`
+}
+
+// Biotope is a seasonal data pack describing a real reef's year, month
+// by month from January.
+type Biotope struct {
+	Name   string           `json:"name"`
+	Months [12]BiotopeMonth `json:"months"`
+}
+
+// Biotopes are the data packs built in, from sunrise tables and cloud
+// climatology near each reef.
+var Biotopes = map[string]*Biotope{
+	"gbr": {Name: "Great Barrier Reef, Lizard Island", Months: [12]BiotopeMonth{
+		{12.8, 1.00, 0.65}, {12.5, 0.99, 0.70}, {12.1, 0.97, 0.65}, {11.7, 0.92, 0.55},
+		{11.4, 0.86, 0.50}, {11.2, 0.82, 0.45}, {11.3, 0.83, 0.40}, {11.6, 0.88, 0.35},
+		{12.0, 0.94, 0.30}, {12.4, 0.98, 0.35}, {12.7, 1.00, 0.45}, {12.9, 1.00, 0.55},
+	}},
+	"fiji": {Name: "Fiji, Viti Levu", Months: [12]BiotopeMonth{
+		{12.9, 1.00, 0.70}, {12.6, 0.99, 0.72}, {12.1, 0.96, 0.68}, {11.6, 0.90, 0.60},
+		{11.3, 0.83, 0.50}, {11.1, 0.79, 0.45}, {11.2, 0.80, 0.42}, {11.5, 0.86, 0.40},
+		{12.0, 0.93, 0.42}, {12.5, 0.98, 0.50}, {12.8, 1.00, 0.58}, {13.0, 1.00, 0.65},
+	}},
+	"red-sea": {Name: "Red Sea, Gulf of Aqaba", Months: [12]BiotopeMonth{
+		{10.4, 0.62, 0.25}, {11.1, 0.72, 0.22}, {11.9, 0.83, 0.20}, {12.8, 0.92, 0.15},
+		{13.6, 0.98, 0.08}, {14.0, 1.00, 0.02}, {13.8, 0.99, 0.02}, {13.2, 0.95, 0.02},
+		{12.3, 0.87, 0.03}, {11.5, 0.76, 0.08}, {10.7, 0.65, 0.15}, {10.2, 0.59, 0.22},
+	}},
+}
+
+// LoadBiotope returns a built in data pack by name, or reads one from a
+// JSON file.
+func LoadBiotope(name string) (*Biotope, error) {
+	if b, ok := Biotopes[name]; ok {
+		return b, nil
+	}
+	data, err := ioutil.ReadFile(name)
+	if err != nil {
+		return nil, fmt.Errorf("%s is not a built in biotope or a data pack: %v", name, err)
+	}
+	var b Biotope
+	if err := json.Unmarshal(data, &b); err != nil {
+		return nil, fmt.Errorf("%s: %v", name, err)
+	}
+	for i, m := range b.Months {
+		if m.Photoperiod <= 0 || m.Photoperiod >= 24 || m.Intensity <= 0 || m.Cloud < 0 || m.Cloud > 1 {
+			return nil, fmt.Errorf("%s: month %d needs a photoperiod under 24 hours, an intensity and cloud from 0 to 1",
+				name, i+1)
+		}
+	}
+	return &b, nil
+}
+
+// light returns the mean light of a month, allowing for cloud by
+// Kasten and Czeplak's fit.
+func (m BiotopeMonth) light() float64 {
+	return m.Intensity * (1 - 0.75*math.Pow(m.Cloud, 3.4))
+}
+
+// Day returns the photoperiod and light of the day of t, relative to
+// the longest and brightest months, interpolated between the middles
+// of the months.
+func (b *Biotope) Day(t time.Time) (photoperiod, light float64) {
+	var longest, brightest float64
+	for _, m := range b.Months {
+		longest = math.Max(longest, m.Photoperiod)
+		brightest = math.Max(brightest, m.light())
+	}
+	// Mid month is about the 15th
+	month := int(t.Month()) - 1
+	f := float64(t.Day()-15) / 30
+	next := month + 1
+	if f < 0 {
+		next = month - 1
+		f = -f
+	}
+	a, c := b.Months[month], b.Months[(next+12)%12]
+	photoperiod = a.Photoperiod + f*(c.Photoperiod-a.Photoperiod)
+	light = a.light() + f*(c.light()-a.light())
+	return photoperiod / longest, light / brightest
+}
+
+// Seasonal returns the schedule with its photoperiod stretched by
+// length around its middle, and its daylight scaled by light, so a
+// table written for the longest, brightest day runs any other. Points
+// outside the photoperiod, such as moonlight, are left alone unless
+// the stretched day covers them.
+func (sc *Schedule) Seasonal(length, light float64) *Schedule {
+	initLtables()
+	n := len(sc.at)
+	var lit [24 * 60]bool
+	count := 0
+	for minute := range lit {
+		at := time.Date(0, 0, 0, minute/60, minute%60, 0, 0, timeLocation)
+		for channel := 0; channel < Channels; channel++ {
+			if sc.Percent(at, channel) > photoperiodPercent {
+				lit[minute] = true
+			}
+		}
+		if lit[minute] {
+			count++
+		}
+	}
+	if count == 0 || count == len(lit) {
+		return sc
+	}
+	first := 0
+	for lit[first] || !lit[(first+1)%len(lit)] {
+		first++
+	}
+	// The day runs from the last point before the lights come on to the
+	// first after they go off
+	start := (first + 1) * 60
+	from := n - 1
+	for i, at := range sc.at {
+		if at <= start {
+			from = i
+		}
+	}
+	since := func(i int) int { return (sc.at[i] - sc.at[from] + secondsPerDay) % secondsPerDay }
+	end := (start-sc.at[from]+secondsPerDay)%secondsPerDay + count*60
+	to := from
+	for since(to) < end && (to+1)%n != from {
+		to = (to + 1) % n
+	}
+	span := (sc.at[to] - sc.at[from] + secondsPerDay) % secondsPerDay
+	length = math.Min(length, float64(secondsPerDay-120)/float64(span))
+	mid := sc.at[from] + span/2
+
+	type point struct {
+		at       int
+		percents []float64
+		meta     pointMeta
+	}
+	var points []point
+	for i := 0; i < n; i++ {
+		offset := (sc.at[i]-mid+secondsPerDay+secondsPerDay/2)%secondsPerDay - secondsPerDay/2
+		inDay := (i-from+n)%n <= (to-from+n)%n
+		var meta pointMeta
+		if sc.meta != nil {
+			meta = sc.meta[i]
+		}
+		switch {
+		case inDay:
+			percents := make([]float64, Channels)
+			for channel := range percents {
+				percents[channel] = math.Min(100, sc.percents[i][channel]*light)
+			}
+			at := mid + int(float64(offset)*length)
+			points = append(points, point{(at%secondsPerDay + secondsPerDay) % secondsPerDay, percents, meta})
+		case math.Abs(float64(offset)) > float64(span)*length/2:
+			points = append(points, point{sc.at[i], sc.percents[i], meta})
+		}
+	}
+	sort.SliceStable(points, func(i, j int) bool { return points[i].at < points[j].at })
+	// Accessories keep to the table's own day
+	s := &Schedule{offsets: sc.offsets, accessories: sc.accessories}
+	for i, p := range points {
+		if i > 0 && p.at == s.at[len(s.at)-1] {
+			continue
+		}
+		s.at = append(s.at, p.at)
+		s.percents = append(s.percents, p.percents)
+		if sc.meta != nil {
+			s.meta = append(s.meta, p.meta)
+		}
+	}
+	return s
+}
+
+// FollowBiotope runs the table through a reef's year, each day's
+// photoperiod and light scaled from the table's by the data pack. The
+// table stands for the reef's longest, brightest day.
+func (ld *LightDriver) FollowBiotope(b *Biotope) {
+	ld.mu.Lock()
+	ld.biotope = b
+	ld.seasonalFrom = nil
+	ld.mu.Unlock()
+	log.Printf("Following the year of %s", b.Name)
+	ld.updateChannels()
+}
+
+// seasonal returns the table for the day of t when following a
+// biotope. Today's is prepared once a day, and other days' each time
+// they are asked for.
+func (ld *LightDriver) seasonal(sc *Schedule, t time.Time) *Schedule {
+	ld.mu.Lock()
+	defer ld.mu.Unlock()
+	if ld.biotope == nil {
+		return sc
+	}
+	day := t.Format("2006-01-02")
+	if sc == ld.seasonalFrom && day == ld.seasonalDay {
+		return ld.seasonalSc
+	}
+	length, light := ld.biotope.Day(t)
+	seasonal := sc.Seasonal(length, light)
+	if day != ld.clock.Now().In(timeLocation).Format("2006-01-02") {
+		return seasonal
+	}
+	ld.seasonalFrom, ld.seasonalDay, ld.seasonalSc = sc, day, seasonal
+	log.Printf("%s photoperiod at %.0f%% and light at %.0f%% of the longest, brightest day",
+		ld.biotope.Name, length*100, light*100)
+	return seasonal
+}
@@ -0,0 +1,76 @@
+package ltable
+
+import (
+	"math"
+	"testing"
+	"time"
+
+	"github.com/theatrus/ledbrick/controller/clock"
+)
+
+func TestSeasonal(t *testing.T) {
+	sc, err := ParseSchedule([]byte(`[
+		{"at": "00:00", "percents": [0, 0, 0, 0, 0, 1, 0, 0]},
+		{"at": "08:00", "percents": [0, 0, 0, 0, 0, 0, 0, 0]},
+		{"at": "10:00", "percents": [100, 0, 0, 0, 0, 0, 0, 0]},
+		{"at": "16:00", "percents": [100, 0, 0, 0, 0, 0, 0, 0]},
+		{"at": "18:00", "percents": [0, 0, 0, 0, 0, 0, 0, 0]}
+	]`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	s := sc.Seasonal(0.5, 0.5)
+	at := func(clock string) time.Time {
+		t, _ := time.ParseInLocation("15:04", clock, timeLocation)
+		return t
+	}
+	for _, tc := range []struct {
+		clock   string
+		channel int
+		want    float64
+	}{
+		{"10:00", 0, 0},
+		{"11:00", 0, 25},
+		{"12:00", 0, 50},
+		{"14:30", 0, 50},
+		{"15:30", 0, 0},
+		{"00:00", 5, 1},
+	} {
+		if got := s.Percent(at(tc.clock), tc.channel); math.Abs(got-tc.want) > 0.01 {
+			t.Errorf("%s channel %d: expected %.1f, got %.1f", tc.clock, tc.channel, tc.want, got)
+		}
+	}
+	if got := sc.Seasonal(1, 1).Percent(at("09:00"), 0); got != 50 {
+		t.Errorf("Expected the table unchanged on the longest, brightest day, got %.1f", got)
+	}
+}
+
+func TestBiotopeDay(t *testing.T) {
+	redSea := Biotopes["red-sea"]
+	length, light := redSea.Day(time.Date(2016, 6, 15, 12, 0, 0, 0, time.UTC))
+	if length != 1 || light != 1 {
+		t.Errorf("Expected the Red Sea's longest, brightest day in June, got %.2f and %.2f", length, light)
+	}
+	length, light = redSea.Day(time.Date(2016, 12, 31, 12, 0, 0, 0, time.UTC))
+	if math.Abs(length-(10.2+10.4)/2/14) > 0.01 || light > 0.62 {
+		t.Errorf("Expected a short, dim winter day at new year, got %.2f and %.2f", length, light)
+	}
+	if _, err := LoadBiotope("atlantis"); err == nil {
+		t.Errorf("Expected an unknown biotope to fail")
+	}
+}
+
+func TestFollowBiotope(t *testing.T) {
+	f := &fakeChannel{}
+	ld, _ := newTestDriver(t, f)
+	ld.clock = clock.NewFake(time.Date(2016, 1, 15, 20, 0, 0, 0, time.UTC))
+	ld.schedule, _ = ParseSchedule([]byte(`[
+		{"at": "06:00", "percents": [0, 0, 0, 0, 0, 0, 0, 0]},
+		{"at": "12:00", "percents": [100, 0, 0, 0, 0, 0, 0, 0]},
+		{"at": "18:00", "percents": [0, 0, 0, 0, 0, 0, 0, 0]}
+	]`))
+	ld.FollowBiotope(Biotopes["red-sea"])
+	if got, want := f.get(0), 100*Biotopes["red-sea"].Months[0].light()/Biotopes["red-sea"].Months[5].light(); math.Abs(got-want) > 0.1 {
+		t.Errorf("Expected noon output scaled to the Red Sea's January, %.1f, got %.1f", want, got)
+	}
+}
@@ -0,0 +1,278 @@
+package ltable
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Calendar picks which lighting table, or profile, runs on each day.
+// Rules are tried in order and the first matching the day wins; days no
+// rule matches run the default table.
+type Calendar struct {
+	// Profiles are lighting table files by name
+	Profiles map[string]string `json:"profiles"`
+	Rules    []CalendarRule    `json:"rules"`
+}
+
+// CalendarRule matches days from From to To inclusive, given as
+// YYYY-MM-DD for a one-off range or MM-DD to repeat every year, which
+// may wrap over the new year. Either end may be left out, and Weekdays
+// narrows the days further, such as ["sat", "sun"].
+type CalendarRule struct {
+	Profile  string   `json:"profile"`
+	From     string   `json:"from"`
+	To       string   `json:"to"`
+	Weekdays []string `json:"weekdays"`
+}
+
+var weekdays = map[string]time.Weekday{
+	"sun": time.Sunday, "mon": time.Monday, "tue": time.Tuesday, "wed": time.Wednesday,
+	"thu": time.Thursday, "fri": time.Friday, "sat": time.Saturday,
+}
+
+// ParseCalendar parses and validates a calendar.
+func ParseCalendar(data []byte) (*Calendar, error) {
+	var c Calendar
+	if err := json.Unmarshal(data, &c); err != nil {
+		return nil, err
+	}
+	for i, r := range c.Rules {
+		if _, ok := c.Profiles[r.Profile]; !ok {
+			return nil, fmt.Errorf("rule %d: unknown profile %q", i+1, r.Profile)
+		}
+		from, err := parseDay(r.From)
+		if err != nil {
+			return nil, fmt.Errorf("rule %d: %v", i+1, err)
+		}
+		to, err := parseDay(r.To)
+		if err != nil {
+			return nil, fmt.Errorf("rule %d: %v", i+1, err)
+		}
+		if from.year != to.year && r.From != "" && r.To != "" {
+			return nil, fmt.Errorf("rule %d: from and to must both have a year or both not", i+1)
+		}
+		for _, d := range r.Weekdays {
+			if _, ok := weekdays[strings.ToLower(d)]; !ok {
+				return nil, fmt.Errorf("rule %d: unknown weekday %q, expected mon to sun", i+1, d)
+			}
+		}
+	}
+	return &c, nil
+}
+
+// day is a date from a rule, with year 0 if it repeats every year.
+type day struct {
+	year, month, day int
+}
+
+func parseDay(s string) (day, error) {
+	if s == "" {
+		return day{}, nil
+	}
+	if t, err := time.Parse("2006-01-02", s); err == nil {
+		return day{t.Year(), int(t.Month()), t.Day()}, nil
+	}
+	// A leap year, so 02-29 parses
+	if t, err := time.Parse("2006-01-02", "2000-"+s); err == nil {
+		return day{0, int(t.Month()), t.Day()}, nil
+	}
+	return day{}, fmt.Errorf("bad date %q, expected YYYY-MM-DD or MM-DD", s)
+}
+
+// key orders days, ignoring the year for those which repeat.
+func (d day) key(year bool) int {
+	k := d.month*100 + d.day
+	if year {
+		k += d.year * 10000
+	}
+	return k
+}
+
+func (r CalendarRule) matches(t time.Time) bool {
+	if len(r.Weekdays) > 0 {
+		found := false
+		for _, d := range r.Weekdays {
+			if weekdays[strings.ToLower(d)] == t.Weekday() {
+				found = true
+			}
+		}
+		if !found {
+			return false
+		}
+	}
+	from, _ := parseDay(r.From)
+	to, _ := parseDay(r.To)
+	yearly := from.year == 0 && to.year == 0
+	now := day{t.Year(), int(t.Month()), t.Day()}.key(!yearly)
+	switch {
+	case r.From == "" && r.To == "":
+		return true
+	case r.From == "":
+		return now <= to.key(!yearly)
+	case r.To == "":
+		return now >= from.key(!yearly)
+	case yearly && from.key(false) > to.key(false):
+		// Wraps over the new year
+		return now >= from.key(false) || now <= to.key(false)
+	}
+	return now >= from.key(!yearly) && now <= to.key(!yearly)
+}
+
+// Profile returns the profile for the day of t in the controller's
+// location, or "" for the default table.
+func (c *Calendar) Profile(t time.Time) string {
+	initLtables()
+	t = t.In(timeLocation)
+	for _, r := range c.Rules {
+		if r.matches(t) {
+			return r.Profile
+		}
+	}
+	return ""
+}
+
+// CalendarFollower switches a light driver between profiles as the
+// calendar says, checking each minute for a new day.
+type CalendarFollower struct {
+	ld       *LightDriver
+	calendar *Calendar
+	def      string
+
+	mu      sync.Mutex
+	day     string
+	profile string
+	// override is run instead of the day's profile while overriding
+	override   string
+	overriding bool
+}
+
+// FollowCalendar applies the calendar's profile for today, then keeps
+// the driver on the right one as days pass. def is the default table
+// file. A profile which fails to load or apply leaves the running table
+// alone and is tried again the next day.
+func (ld *LightDriver) FollowCalendar(c *Calendar, def string) *CalendarFollower {
+	f := &CalendarFollower{ld: ld, calendar: c, def: def}
+	ld.mu.Lock()
+	ld.follower = f
+	ld.mu.Unlock()
+	f.check()
+	ticker := ld.clock.NewTicker(time.Minute)
+	done := ld.stopped()
+	ld.running.Add(1)
+	go func() {
+		defer ld.running.Done()
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ticker.C():
+				f.check()
+			case <-done:
+				return
+			}
+		}
+	}()
+	return f
+}
+
+// check switches profile on a new day.
+func (f *CalendarFollower) check() {
+	initLtables()
+	now := f.ld.clock.Now().In(timeLocation)
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	today := now.Format("2006-01-02")
+	if today == f.day {
+		return
+	}
+	f.day = today
+	f.switchTo(f.want(now))
+}
+
+// want returns the profile to run, an override if there is one, or
+// else the day's. The caller must hold the follower lock.
+func (f *CalendarFollower) want(now time.Time) string {
+	if f.overriding {
+		return f.override
+	}
+	return f.calendar.Profile(now)
+}
+
+// switchTo applies a profile if it isn't running. The caller must hold
+// the follower lock.
+func (f *CalendarFollower) switchTo(profile string) {
+	if profile == f.profile {
+		return
+	}
+	if err := f.apply(profile); err != nil {
+		log.Printf("Calendar profile %s not applied: %v", profileName(profile), err)
+		return
+	}
+	log.Printf("Calendar switched from profile %s to %s", profileName(f.profile), profileName(profile))
+	f.profile = profile
+}
+
+// Override runs a profile, "" for the default table, instead of the
+// day's until ClearOverride, such as during a calendar event.
+func (f *CalendarFollower) Override(profile string) error {
+	if _, ok := f.calendar.Profiles[profile]; !ok && profile != "" {
+		return fmt.Errorf("unknown profile %q", profile)
+	}
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.override, f.overriding = profile, true
+	f.switchTo(f.want(f.ld.clock.Now()))
+	return nil
+}
+
+// ClearOverride goes back to the day's profile.
+func (f *CalendarFollower) ClearOverride() {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.overriding = false
+	f.switchTo(f.want(f.ld.clock.Now()))
+}
+
+// apply loads a profile's table and applies it. The caller must hold
+// the follower lock.
+func (f *CalendarFollower) apply(profile string) error {
+	data, err := f.table(profile)
+	if err != nil {
+		return err
+	}
+	return f.ld.Apply(data)
+}
+
+// table reads a profile's table file.
+func (f *CalendarFollower) table(profile string) ([]byte, error) {
+	file := f.def
+	if profile != "" {
+		file = f.calendar.Profiles[profile]
+	}
+	return ioutil.ReadFile(file)
+}
+
+// Reload applies the current profile's table file again.
+func (f *CalendarFollower) Reload() error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.apply(f.profile)
+}
+
+// Profile returns the profile running, "" for the default table.
+func (f *CalendarFollower) Profile() string {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.profile
+}
+
+func profileName(profile string) string {
+	if profile == "" {
+		return "default"
+	}
+	return profile
+}
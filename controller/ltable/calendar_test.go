@@ -0,0 +1,87 @@
+package ltable
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestCalendarRules(t *testing.T) {
+	initLtables()
+	c, err := ParseCalendar([]byte(`{
+		"profiles": {"vacation": "v.json", "winter": "w.json", "weekend": "we.json"},
+		"rules": [
+			{"profile": "vacation", "from": "2016-07-01", "to": "2016-07-14"},
+			{"profile": "winter", "from": "11-01", "to": "02-28"},
+			{"profile": "weekend", "weekdays": ["sat", "Sun"]}
+		]
+	}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, tc := range []struct {
+		date, want string
+	}{
+		{"2016-07-01", "vacation"},
+		{"2016-07-14", "vacation"},
+		{"2016-07-15", ""},
+		{"2017-07-03", ""},
+		{"2016-12-25", "winter"},
+		{"2017-01-10", "winter"},
+		{"2016-10-31", ""},
+		{"2016-07-16", "weekend"},
+	} {
+		day, _ := time.ParseInLocation("2006-01-02 15:04", tc.date+" 12:00", timeLocation)
+		if got := c.Profile(day); got != tc.want {
+			t.Errorf("%s: expected profile %q, got %q", tc.date, tc.want, got)
+		}
+	}
+
+	for _, bad := range []string{
+		`{"rules": [{"profile": "missing"}]}`,
+		`{"profiles": {"a": "a.json"}, "rules": [{"profile": "a", "from": "13-01"}]}`,
+		`{"profiles": {"a": "a.json"}, "rules": [{"profile": "a", "from": "2016-01-01", "to": "02-01"}]}`,
+		`{"profiles": {"a": "a.json"}, "rules": [{"profile": "a", "weekdays": ["someday"]}]}`,
+	} {
+		if _, err := ParseCalendar([]byte(bad)); err == nil {
+			t.Errorf("Expected %s to fail", bad)
+		}
+	}
+}
+
+func TestFollowCalendar(t *testing.T) {
+	dir, err := ioutil.TempDir("", "calendar")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	def := filepath.Join(dir, "default.json")
+	vacation := filepath.Join(dir, "vacation.json")
+	ioutil.WriteFile(def, flat("10"), 0644)
+	ioutil.WriteFile(vacation, flat("30"), 0644)
+
+	c := &Calendar{Profiles: map[string]string{"vacation": vacation},
+		Rules: []CalendarRule{{Profile: "vacation", From: "2016-01-02", To: "2016-01-02"}},
+	}
+	f := &fakeChannel{}
+	ld, clk := newTestDriver(t, f)
+	defer ld.Stop()
+	follower := ld.FollowCalendar(c, def)
+	if follower.Profile() != "" || f.get(0) != 10 {
+		t.Fatalf("Expected the default table on the first day, got %q at %.0f", follower.Profile(), f.get(0))
+	}
+
+	clk.Advance(24 * time.Hour)
+	follower.check()
+	if follower.Profile() != "vacation" || f.get(0) != 30 {
+		t.Errorf("Expected the vacation table on the second day, got %q at %.0f", follower.Profile(), f.get(0))
+	}
+
+	clk.Advance(24 * time.Hour)
+	follower.check()
+	if follower.Profile() != "" || f.get(0) != 10 {
+		t.Errorf("Expected the default table back on the third day, got %q at %.0f", follower.Profile(), f.get(0))
+	}
+}
@@ -0,0 +1,154 @@
+package ltable
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"math"
+	"sort"
+	"time"
+)
+
+// with returns the schedule with a point added at a second of the day,
+// replacing any point already there but keeping its easing and tags.
+func (sc *Schedule) with(at int, percents []float64) *Schedule {
+	i := sort.SearchInts(sc.at, at)
+	r := &Schedule{
+		at:       append(append([]int(nil), sc.at[:i]...), at),
+		percents: append(append([][]float64(nil), sc.percents[:i]...), percents),
+		offsets:  sc.offsets,
+		// A point captured is the lights alone
+		accessories: sc.accessories,
+	}
+	if sc.meta != nil {
+		r.meta = append([]pointMeta(nil), sc.meta[:i]...)
+		var meta pointMeta
+		if i < len(sc.at) && sc.at[i] == at {
+			meta = sc.meta[i]
+		}
+		r.meta = append(r.meta, meta)
+	}
+	if i < len(sc.at) && sc.at[i] == at {
+		i++
+	}
+	r.at = append(r.at, sc.at[i:]...)
+	r.percents = append(r.percents, sc.percents[i:]...)
+	if sc.meta != nil {
+		r.meta = append(r.meta, sc.meta[i:]...)
+	}
+	return r
+}
+
+// Table returns the schedule as a lighting table, in the controller's
+// time zone.
+func (sc *Schedule) Table() ([]byte, error) {
+	if sc.meta != nil || sc.offsets != nil || sc.accessories != nil {
+		return sc.TableV2()
+	}
+	settings := make(settingPoints, 0, len(sc.at))
+	for i, at := range sc.at {
+		settings = append(settings, settingPoint{
+			At:       fmt.Sprintf("%02d:%02d", at/3600, at%3600/60),
+			Percents: sc.percents[i],
+		})
+	}
+	return json.MarshalIndent(settings, "", "    ")
+}
+
+// TableV2 returns the schedule as a version 2 table, naming each
+// point's channels.
+func (sc *Schedule) TableV2() ([]byte, error) {
+	table := tableV2{Version: 2, Points: make([]pointV2, 0, len(sc.at))}
+	for channel, offset := range sc.offsets {
+		if offset != 0 {
+			if table.Offsets == nil {
+				table.Offsets = make(map[string]string)
+			}
+			table.Offsets[ChannelNames[channel]] = (time.Duration(offset) * time.Second).String()
+		}
+	}
+	for i, at := range sc.at {
+		p := pointV2{
+			At:       fmt.Sprintf("%02d:%02d", at/3600, at%3600/60),
+			Channels: make(map[string]float64),
+		}
+		var omitted []int
+		if sc.meta != nil {
+			p.Ease, p.Tags, omitted = sc.meta[i].ease, sc.meta[i].tags, sc.meta[i].omitted
+		}
+		for channel, v := range sc.percents[i] {
+			if !hasChannel(omitted, channel) {
+				p.Channels[ChannelNames[channel]] = v
+			}
+		}
+		table.Points = append(table.Points, p)
+	}
+	// Each accessory's points are some of the table's
+	for name, a := range sc.accessories {
+		for j, at := range a.at {
+			if i := sort.SearchInts(sc.at, at); i < len(sc.at) && sc.at[i] == at {
+				table.Points[i].Channels[name] = a.percents[j][0]
+			}
+		}
+	}
+	return json.MarshalIndent(table, "", "    ")
+}
+
+// ConvertTable validates a lighting table of either version, returning
+// it as a version 2 table.
+func ConvertTable(data []byte) ([]byte, error) {
+	sc, err := ParseSchedule(data)
+	if err != nil {
+		return nil, err
+	}
+	return sc.TableV2()
+}
+
+// Capture adds the channels' current settings to the running table as
+// a point at this minute, replacing any point already there, so a live
+// tuning session is kept rather than lost at the next update. A hold
+// ends, as the table now gives what it held. It can be undone like an
+// edit, and returns the new table to be saved over the one the
+// controller loads.
+func (ld *LightDriver) Capture() ([]byte, error) {
+	initLtables()
+	channels := ld.ble.Channels()
+	percents := make([]float64, Channels)
+	for channel := range percents {
+		v, ok := channels[channel]
+		if !ok {
+			return nil, fmt.Errorf("channel %d hasn't been set yet", channel)
+		}
+		percents[channel] = math.Floor(v*10+0.5) / 10
+	}
+	now := ld.clock.Now().In(timeLocation)
+	at := now.Hour()*3600 + now.Minute()*60
+
+	ld.mu.Lock()
+	ld.record(ld.schedule)
+	ld.schedule = ld.schedule.with(at, percents)
+	ld.held = nil
+	sc := ld.schedule
+	if ld.reverse {
+		// Saved tables are written for the display and reversed on load
+		sc = sc.Reversed()
+	}
+	ld.mu.Unlock()
+
+	log.Printf("Captured %.1f at %02d:%02d into the lighting table", percents, now.Hour(), now.Minute())
+	ld.updateChannels()
+	ld.saveEdits()
+	return sc.Table()
+}
+
+// Table returns the running table as it would be saved, written for the
+// display if the tank is reversed.
+func (ld *LightDriver) Table() ([]byte, error) {
+	ld.mu.Lock()
+	sc := ld.schedule
+	if ld.reverse {
+		sc = sc.Reversed()
+	}
+	ld.mu.Unlock()
+	return sc.Table()
+}
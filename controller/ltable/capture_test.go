@@ -0,0 +1,56 @@
+package ltable
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestScheduleWith(t *testing.T) {
+	sc, err := ParseSchedule([]byte(`[
+		{"at": "08:00", "percents": [0, 0, 0, 0, 0, 0, 0, 0]},
+		{"at": "12:00", "percents": [80, 0, 0, 0, 0, 0, 0, 0]}
+	]`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	added := sc.with(10*3600, []float64{20, 0, 0, 0, 0, 0, 0, 0})
+	if len(added.at) != 3 || added.at[1] != 10*3600 || added.percents[1][0] != 20 {
+		t.Errorf("Expected a point inserted at 10:00, got %v %v", added.at, added.percents)
+	}
+	replaced := added.with(12*3600, []float64{60, 0, 0, 0, 0, 0, 0, 0})
+	if len(replaced.at) != 3 || replaced.percents[2][0] != 60 {
+		t.Errorf("Expected the 12:00 point replaced, got %v %v", replaced.at, replaced.percents)
+	}
+	if len(sc.at) != 2 {
+		t.Error("Expected the original schedule left alone")
+	}
+}
+
+func TestCapture(t *testing.T) {
+	f := &fakeChannel{}
+	ld, _ := newTestDriver(t, f)
+	ld.Hold([]float64{42, 0, 0, 0, 0, 0, 0, 0}, time.Hour)
+
+	table, err := ld.Capture()
+	if err != nil {
+		t.Fatal(err)
+	}
+	// The fake clock is at 04:00 in the table's time zone
+	if !strings.Contains(string(table), `"at": "04:00"`) {
+		t.Errorf("Expected a point at 04:00, got %s", table)
+	}
+	if _, err := ParseSchedule(table); err != nil {
+		t.Errorf("Expected the captured table to load, got %v", err)
+	}
+	if ld.held != nil {
+		t.Error("Expected the hold to end")
+	}
+	if f.get(0) != 42 {
+		t.Errorf("Expected the captured setting to be kept, got %.0f", f.get(0))
+	}
+	at, _ := ParseTime("04:00", ld.clock.Now())
+	if v := percentsAt(t, ld, at)[0]; v != 42 {
+		t.Errorf("Expected the captured setting in the table, got %.0f", v)
+	}
+}
@@ -0,0 +1,158 @@
+package ltable
+
+import (
+	"fmt"
+	"image"
+	"image/color"
+	"image/png"
+	"io"
+	"math"
+	"time"
+)
+
+// ChannelColors are how each channel is drawn, matching ChannelNames.
+var ChannelColors = [Channels]color.RGBA{
+	{0x2c, 0xa0, 0x2c, 0xff}, // Green
+	{0x17, 0xbe, 0xcf, 0xff}, // Cyan
+	{0xff, 0xa5, 0x00, 0xff}, // PC Amber
+	{0x1f, 0x5f, 0xff, 0xff}, // Blue
+	{0xd6, 0x27, 0x28, 0xff}, // Red
+	{0x2a, 0x1f, 0xa8, 0xff}, // Deep Blue
+	{0x99, 0x99, 0x99, 0xff}, // White, grey so it shows
+	{0x8a, 0x2b, 0xe2, 0xff}, // UV
+}
+
+const (
+	chartWidth  = 960
+	chartHeight = 400
+	chartMargin = 40
+	// Minutes between plotted samples
+	chartStep = 5
+)
+
+// RenderChart draws the 24 hour curve of every channel in a lighting
+// table as "svg" or "png".
+func RenderChart(data []byte, format string, w io.Writer) error {
+	sc, err := ParseSchedule(data)
+	if err != nil {
+		return err
+	}
+	return sc.chart(format, w)
+}
+
+// Chart draws the driver's current table, see RenderChart.
+func (ld *LightDriver) Chart(format string, w io.Writer) error {
+	return ld.current().chart(format, w)
+}
+
+func (sc *Schedule) chart(format string, w io.Writer) error {
+	initLtables()
+	var curves [Channels][]float64
+	for minute := 0; minute <= 24*60; minute += chartStep {
+		at := time.Date(0, 0, 0, 0, minute, 0, 0, timeLocation)
+		for channel := range curves {
+			curves[channel] = append(curves[channel], sc.Percent(at, channel))
+		}
+	}
+	switch format {
+	case "svg":
+		return chartSVG(curves, w)
+	case "png":
+		return chartPNG(curves, w)
+	}
+	return fmt.Errorf("unknown chart format %q", format)
+}
+
+// chartXY maps sample i and a percent to chart coordinates.
+func chartXY(i int, percent float64) (float64, float64) {
+	plotW := float64(chartWidth - 2*chartMargin)
+	plotH := float64(chartHeight - 2*chartMargin)
+	x := chartMargin + float64(i*chartStep)/(24*60)*plotW
+	y := chartMargin + (1-percent/100)*plotH
+	return x, y
+}
+
+func hexColor(c color.RGBA) string {
+	return fmt.Sprintf("#%02x%02x%02x", c.R, c.G, c.B)
+}
+
+func chartSVG(curves [Channels][]float64, w io.Writer) error {
+	fmt.Fprintf(w, `<svg xmlns="http://www.w3.org/2000/svg" width="%d" height="%d" font-family="sans-serif" font-size="12">`+"\n",
+		chartWidth, chartHeight)
+	fmt.Fprintf(w, `<rect width="%d" height="%d" fill="white"/>`+"\n", chartWidth, chartHeight)
+	for hour := 0; hour <= 24; hour += 3 {
+		x, _ := chartXY(hour*60/chartStep, 0)
+		fmt.Fprintf(w, `<line x1="%.1f" y1="%d" x2="%.1f" y2="%d" stroke="#e0e0e0"/>`+"\n",
+			x, chartMargin, x, chartHeight-chartMargin)
+		fmt.Fprintf(w, `<text x="%.1f" y="%d" text-anchor="middle">%02d:00</text>`+"\n",
+			x, chartHeight-chartMargin+16, hour)
+	}
+	for percent := 0; percent <= 100; percent += 25 {
+		_, y := chartXY(0, float64(percent))
+		fmt.Fprintf(w, `<line x1="%d" y1="%.1f" x2="%d" y2="%.1f" stroke="#e0e0e0"/>`+"\n",
+			chartMargin, y, chartWidth-chartMargin, y)
+		fmt.Fprintf(w, `<text x="%d" y="%.1f" text-anchor="end">%d%%</text>`+"\n",
+			chartMargin-4, y+4, percent)
+	}
+	for channel, curve := range curves {
+		fmt.Fprintf(w, `<polyline fill="none" stroke-width="2" stroke="%s" points="`, hexColor(ChannelColors[channel]))
+		for i, v := range curve {
+			x, y := chartXY(i, v)
+			fmt.Fprintf(w, "%.1f,%.1f ", x, y)
+		}
+		fmt.Fprintf(w, `"/>`+"\n")
+		fmt.Fprintf(w, `<text x="%d" y="%d" fill="%s">%s</text>`+"\n",
+			chartMargin+channel*(chartWidth-2*chartMargin)/Channels, chartMargin-12,
+			hexColor(ChannelColors[channel]), ChannelNames[channel])
+	}
+	_, err := fmt.Fprintln(w, "</svg>")
+	return err
+}
+
+// chartPNG draws the same chart without text, as the standard library
+// has no fonts. The channel colors are shown as swatches along the top
+// in channel order.
+func chartPNG(curves [Channels][]float64, w io.Writer) error {
+	img := image.NewRGBA(image.Rect(0, 0, chartWidth, chartHeight))
+	fill(img, img.Bounds(), color.RGBA{0xff, 0xff, 0xff, 0xff})
+	grid := color.RGBA{0xe0, 0xe0, 0xe0, 0xff}
+	for hour := 0; hour <= 24; hour += 3 {
+		x, _ := chartXY(hour*60/chartStep, 0)
+		fill(img, image.Rect(int(x), chartMargin, int(x)+1, chartHeight-chartMargin), grid)
+	}
+	for percent := 0; percent <= 100; percent += 25 {
+		_, y := chartXY(0, float64(percent))
+		fill(img, image.Rect(chartMargin, int(y), chartWidth-chartMargin, int(y)+1), grid)
+	}
+	for channel, curve := range curves {
+		c := ChannelColors[channel]
+		left := chartMargin + channel*(chartWidth-2*chartMargin)/Channels
+		fill(img, image.Rect(left, chartMargin-20, left+30, chartMargin-10), c)
+		for i := 1; i < len(curve); i++ {
+			x0, y0 := chartXY(i-1, curve[i-1])
+			x1, y1 := chartXY(i, curve[i])
+			line(img, x0, y0, x1, y1, c)
+		}
+	}
+	return png.Encode(w, img)
+}
+
+func fill(img *image.RGBA, r image.Rectangle, c color.RGBA) {
+	for y := r.Min.Y; y < r.Max.Y; y++ {
+		for x := r.Min.X; x < r.Max.X; x++ {
+			img.SetRGBA(x, y, c)
+		}
+	}
+}
+
+// line draws a two pixel wide line by stepping along its longer axis.
+func line(img *image.RGBA, x0, y0, x1, y1 float64, c color.RGBA) {
+	dx, dy := x1-x0, y1-y0
+	steps := int(math.Max(math.Abs(dx), math.Abs(dy))) + 1
+	for i := 0; i <= steps; i++ {
+		t := float64(i) / float64(steps)
+		x := int(x0 + dx*t)
+		y := int(y0 + dy*t)
+		fill(img, image.Rect(x, y, x+2, y+2), c)
+	}
+}
@@ -0,0 +1,56 @@
+package ltable
+
+import (
+	"bytes"
+	"image/png"
+	"strings"
+	"testing"
+)
+
+var chartTable = []byte(`[
+	{"at": "09:00", "percents": [0, 0, 0, 0, 0, 0, 0, 0]},
+	{"at": "12:00", "percents": [50, 60, 10, 80, 20, 90, 30, 100]},
+	{"at": "18:00", "percents": [0, 0, 0, 0, 0, 0, 0, 0]}
+]`)
+
+func TestRenderChartSVG(t *testing.T) {
+	var buf bytes.Buffer
+	if err := RenderChart(chartTable, "svg", &buf); err != nil {
+		t.Fatal(err)
+	}
+	svg := buf.String()
+	if n := strings.Count(svg, "<polyline"); n != Channels {
+		t.Errorf("Expected %d curves, got %d", Channels, n)
+	}
+	if !strings.Contains(svg, "Deep Blue") {
+		t.Error("Expected channel names in the legend")
+	}
+}
+
+func TestRenderChartPNG(t *testing.T) {
+	var buf bytes.Buffer
+	if err := RenderChart(chartTable, "png", &buf); err != nil {
+		t.Fatal(err)
+	}
+	img, err := png.Decode(&buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if b := img.Bounds(); b.Dx() != chartWidth || b.Dy() != chartHeight {
+		t.Errorf("Unexpected size %v", b)
+	}
+	// UV peaks at 100% at noon, the top of the plot
+	x, y := chartXY(12*60/chartStep, 100)
+	r, g, b, _ := img.At(int(x), int(y)).RGBA()
+	uv := ChannelColors[7]
+	if uint8(r>>8) != uv.R || uint8(g>>8) != uv.G || uint8(b>>8) != uv.B {
+		t.Errorf("Expected the UV curve at noon, got %d,%d,%d", r>>8, g>>8, b>>8)
+	}
+}
+
+func TestRenderChartFormat(t *testing.T) {
+	var buf bytes.Buffer
+	if err := RenderChart(chartTable, "gif", &buf); err == nil {
+		t.Error("Expected an unknown format to fail")
+	}
+}
@@ -0,0 +1,109 @@
+package ltable
+
+import (
+	"fmt"
+	"time"
+)
+
+// Channels is the number of channels every setting point must give.
+const Channels = 8
+
+// Output at or below this percent on every channel counts as dark when
+// working out the photoperiod, so moonlight doesn't count as day.
+const photoperiodPercent = 1
+
+// Summary describes a parsed lighting table.
+type Summary struct {
+	Points int
+	// Photoperiod is the time any channel is above photoperiodPercent,
+	// from First to Last
+	Photoperiod time.Duration
+	First       string
+	Last        string
+	Peaks       [Channels]float64
+	PeakAt      [Channels]string
+	// Lints are suspicious but valid settings
+	Lints []Lint
+}
+
+// Check parses and validates a lighting table, returning a summary of
+// it and every problem found. The summary is only filled in if there
+// are no problems.
+func Check(data []byte) (Summary, []error) {
+	var summary Summary
+	settings, offsets, problems := parseTable(data)
+	if len(problems) > 0 {
+		return summary, problems
+	}
+	initLtables()
+
+	summary.Points = len(settings)
+	sc := newSchedule(settings)
+	sc.offsets = offsets
+	var lit [24 * 60]bool
+	for minute := range lit {
+		at := time.Date(0, 0, 0, minute/60, minute%60, 0, 0, timeLocation)
+		for channel := 0; channel < Channels; channel++ {
+			v := sc.Percent(at, channel)
+			if v > summary.Peaks[channel] || summary.PeakAt[channel] == "" {
+				summary.Peaks[channel] = v
+				summary.PeakAt[channel] = at.Format("15:04")
+			}
+			if v > photoperiodPercent {
+				lit[minute] = true
+			}
+		}
+	}
+
+	// First and Last are where the lights come on and go off, wrapping
+	// around midnight
+	for minute, on := range lit {
+		if !on {
+			continue
+		}
+		summary.Photoperiod += time.Minute
+		clock := fmt.Sprintf("%02d:%02d", minute/60, minute%60)
+		if !lit[(minute+len(lit)-1)%len(lit)] && summary.First == "" {
+			summary.First = clock
+		}
+		if !lit[(minute+1)%len(lit)] {
+			summary.Last = clock
+		}
+	}
+	summary.Lints = settings.lint(summary)
+	return summary, nil
+}
+
+// validate returns every problem with the setting points.
+func (s settingPoints) validate() []error {
+	var errs []error
+	if len(s) == 0 {
+		return []error{fmt.Errorf("no setting points")}
+	}
+	seen := make(map[string]int)
+	for i, sp := range s {
+		where := fmt.Sprintf("point %d", i)
+		if sp.line > 0 {
+			where = fmt.Sprintf("line %d: %s", sp.line, where)
+		}
+		hours, minutes, err := sp.clock(time.Now())
+		if err != nil {
+			errs = append(errs, fmt.Errorf("%s: %s", where, err))
+		} else {
+			clock := fmt.Sprintf("%02d:%02d", hours, minutes)
+			if j, ok := seen[clock]; ok {
+				errs = append(errs, fmt.Errorf("%s: time %s repeats point %d", where, clock, j))
+			}
+			seen[clock] = i
+		}
+		if len(sp.Percents) != Channels {
+			errs = append(errs, fmt.Errorf("%s: %d percents, expected %d", where, len(sp.Percents), Channels))
+		}
+		for channel, v := range sp.Percents {
+			if v < 0 || v > 100 {
+				errs = append(errs, fmt.Errorf("%s: channel %d percent %v out of range (0-100)", where, channel, v))
+			}
+		}
+	}
+	return errs
+}
@@ -0,0 +1,129 @@
+package ltable
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestCheck(t *testing.T) {
+	summary, errs := Check([]byte(`[
+		{"at": "00:00", "percents": [0, 0, 0, 0, 0, 0.5, 0, 0]},
+		{"at": "12:00", "percents": [50, 0, 0, 0, 0, 80, 0, 0]},
+		{"at": "09:00", "percents": [0, 0, 0, 0, 0, 0.5, 0, 0]},
+		{"at": "15:00", "percents": [0, 0, 0, 0, 0, 0.5, 0, 0]}
+	]`))
+	if len(errs) > 0 {
+		t.Fatal(errs)
+	}
+	if summary.Points != 4 {
+		t.Errorf("Expected 4 points, got %d", summary.Points)
+	}
+	if summary.Peaks[5] != 80 || summary.PeakAt[5] != "12:00" {
+		t.Errorf("Expected channel 5 to peak at 80%% at 12:00, got %f at %s", summary.Peaks[5], summary.PeakAt[5])
+	}
+	// Moonlight doesn't count towards the photoperiod
+	if summary.First != "09:02" || summary.Last != "14:58" {
+		t.Errorf("Expected lights on 09:02 to 14:58, got %s to %s", summary.First, summary.Last)
+	}
+	if summary.Photoperiod != 5*time.Hour+57*time.Minute {
+		t.Errorf("Unexpected photoperiod %s", summary.Photoperiod)
+	}
+}
+
+func TestCheckProblems(t *testing.T) {
+	_, errs := Check([]byte(`[
+		{"at": "25:00", "percents": [0, 0, 0, 0, 0, 0, 0, 0]},
+		{"at": "10", "percents": [0, 0, 0, 0, 0, 0, 0, 0]},
+		{"at": "11:00", "percents": [0, 0, 0, 0, 0, 0, 0]},
+		{"at": "12:00", "percents": [0, 0, 0, 0, 0, 0, 0, 120]},
+		{"at": "12:00", "percents": [0, 0, 0, 0, 0, 0, 0, 0]}
+	]`))
+	if len(errs) != 5 {
+		t.Errorf("Expected 5 problems, got %d: %v", len(errs), errs)
+	}
+
+	if _, errs := Check([]byte(`[]`)); len(errs) != 1 {
+		t.Errorf("Expected an empty table to be a problem, got %v", errs)
+	}
+	if _, errs := Check([]byte(`{`)); len(errs) != 1 {
+		t.Errorf("Expected bad JSON to be a problem, got %v", errs)
+	}
+}
+
+func TestCheckPositions(t *testing.T) {
+	_, errs := Check([]byte(`[
+    {"at": "09:00", "percents": [0, 0, 0, 0, 0, 0, 0, 0]},
+    {"at": "10:00", "precents": [0, 0, 0, 0, 0, 0, 0, 0]},
+    {"at": 11, "percents": [0, 0, 0, 0, 0, 0, 0, 0]}
+]`))
+	if len(errs) != 2 {
+		t.Fatalf("Expected 2 problems, got %v", errs)
+	}
+	if errs[0].Error() != `line 3: point 1: unknown field "precents"` {
+		t.Errorf("Unexpected unknown field error %q", errs[0])
+	}
+	if errs[1].Error() != "line 4: point 2: at should be a string, not a number" {
+		t.Errorf("Unexpected type error %q", errs[1])
+	}
+
+	_, errs = Check([]byte("[\n  {\"at\": \"09:00\",\n  \"percents\": [0, 0,]}\n]"))
+	if len(errs) != 1 || !strings.HasPrefix(errs[0].Error(), "line 3: ") {
+		t.Errorf("Expected a syntax error on line 3, got %v", errs)
+	}
+
+	_, errs = Check([]byte("[\n  {\"at\": \"09:00\", \"percents\": [0, 0, 0, 0, 0, 0, 0, 101]}\n]"))
+	if len(errs) != 1 || !strings.HasPrefix(errs[0].Error(), "line 2: point 0: ") {
+		t.Errorf("Expected a validation error on line 2, got %v", errs)
+	}
+}
+
+func TestLint(t *testing.T) {
+	summary, errs := Check([]byte(`[
+		{"at": "08:00", "percents": [0, 0, 0, 0, 0, 0, 0, 0]},
+		{"at": "06:00", "percents": [0, 0, 0, 0, 0, 0, 0, 0]},
+		{"at": "08:02", "percents": [80, 10, 10, 10, 10, 10, 10, 0]},
+		{"at": "22:30", "percents": [80, 10, 10, 10, 10, 10, 10, 0]},
+		{"at": "23:00", "percents": [0, 0, 0, 5, 0, 0, 0, 0]}
+	]`))
+	if len(errs) > 0 {
+		t.Fatal(errs)
+	}
+	var found []string
+	for _, l := range summary.Lints {
+		found = append(found, l.String())
+		if l.Suggestion == "" {
+			t.Errorf("Expected a suggestion for %s", l)
+		}
+	}
+	all := strings.Join(found, "\n")
+	for _, want := range []string{
+		"line 3: point at 06:00 is after the point at 08:00 in the file",
+		"line 4: Green by 80% changes in 2m0s from 08:00 to 08:02",
+		"line 3: Blue ramp across midnight from 23:00 to 06:00",
+		"channel 7 (UV) is always 0%",
+		"photoperiod is 20h35m0s, over 14h0m0s",
+	} {
+		if !strings.Contains(all, want) {
+			t.Errorf("Expected %q in:\n%s", want, all)
+		}
+	}
+	if len(found) != 5 {
+		t.Errorf("Expected 5 lints, got:\n%s", all)
+	}
+}
+
+func TestLintInterval(t *testing.T) {
+	defer func(d time.Duration) { interval = d }(interval)
+	interval = 2 * time.Minute
+	summary, errs := Check([]byte(`[
+		{"at": "10:00", "percents": [1, 1, 1, 1, 1, 1, 1, 1]},
+		{"at": "10:01", "percents": [1, 1, 1, 1, 1, 1, 1, 1]}
+	]`))
+	if len(errs) > 0 {
+		t.Fatal(errs)
+	}
+	if len(summary.Lints) != 1 || !strings.Contains(summary.Lints[0].Message, "less than the update interval") {
+		t.Errorf("Expected points closer than the interval, got %v", summary.Lints)
+	}
+}
@@ -0,0 +1,53 @@
+package ltable
+
+import (
+	"log"
+	"time"
+
+	"github.com/theatrus/ledbrick/controller/astro"
+)
+
+// SimulateEclipses dims the table's output through real eclipses seen
+// from a location: by how much of the sun is covered in a solar
+// eclipse, and, while the moon is up at night, as moonlight turns dim
+// and red in a lunar eclipse.
+func (ld *LightDriver) SimulateEclipses(l astro.Location) {
+	ld.mu.Lock()
+	ld.eclipses = &l
+	ld.mu.Unlock()
+	log.Printf("Simulating eclipses seen from %s", l)
+	ld.updateChannels()
+}
+
+// eclipsed returns percents dimmed for any eclipse at now, and whether
+// there was one.
+func (ld *LightDriver) eclipsed(now time.Time, percents []float64) ([]float64, bool) {
+	ld.mu.Lock()
+	l := ld.eclipses
+	ld.mu.Unlock()
+	if l == nil {
+		return percents, false
+	}
+	if covered := l.SolarEclipse(now); covered > 0 {
+		for i := range percents {
+			percents[i] *= 1 - covered
+		}
+		return percents, true
+	}
+	if l.SunAltitude(now) >= 0 || l.MoonAltitude(now) <= 0 {
+		return percents, false
+	}
+	umbra := astro.LunarEclipse(now)
+	if umbra <= 0 {
+		return percents, false
+	}
+	for i := range percents {
+		// The eclipsed moon glows red, so the red channels fade least
+		dim := 0.9
+		if ChannelNames[i] == "Red" || ChannelNames[i] == "PC Amber" {
+			dim = 0.5
+		}
+		percents[i] *= 1 - dim*umbra
+	}
+	return percents, true
+}
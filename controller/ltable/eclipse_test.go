@@ -0,0 +1,35 @@
+package ltable
+
+import (
+	"testing"
+	"time"
+
+	"github.com/theatrus/ledbrick/controller/astro"
+	"github.com/theatrus/ledbrick/controller/clock"
+)
+
+func TestSimulateEclipses(t *testing.T) {
+	f := &fakeChannel{}
+	ld, _ := newTestDriver(t, f)
+	madras := astro.Location{Latitude: 44.63, Longitude: -121.13}
+
+	// Totality of the 2017 solar eclipse
+	ld.clock = clock.NewFake(time.Date(2017, 8, 21, 17, 20, 30, 0, time.UTC))
+	ld.SimulateEclipses(madras)
+	if f.get(0) > 1 {
+		t.Errorf("Expected the lights almost out in totality, got %.1f", f.get(0))
+	}
+
+	// The 2019 lunar eclipse, with the moon high over Oregon
+	ld.clock = clock.NewFake(time.Date(2019, 1, 21, 5, 12, 0, 0, time.UTC))
+	ld.updateChannels()
+	if f.get(0) > 2 {
+		t.Errorf("Expected moonlight dimmed in a total lunar eclipse, got %.1f", f.get(0))
+	}
+
+	ld.clock = clock.NewFake(time.Date(2019, 1, 22, 5, 12, 0, 0, time.UTC))
+	ld.updateChannels()
+	if f.get(0) != 10 {
+		t.Errorf("Expected the table without an eclipse, got %.1f", f.get(0))
+	}
+}
@@ -0,0 +1,119 @@
+package ltable
+
+import (
+	"fmt"
+	"time"
+)
+
+// Evaluation is what the lights are set to at a time.
+type Evaluation struct {
+	// Percents are every channel's setting
+	Percents []float64
+	// Profile is the calendar profile whose table runs, "" for the
+	// default table
+	Profile string
+	// Effects name what changes the table's settings: reverse,
+	// biotope, mirror, hold and eclipse
+	Effects []string
+}
+
+// Eval returns every channel's setting at a time, which may be in the
+// past or the future, as the driver would write it then: from the
+// calendar's profile for the day, through whichever effects are on.
+func (ld *LightDriver) Eval(t time.Time) (Evaluation, error) {
+	initLtables()
+	t = t.In(timeLocation)
+	sc, profile, err := ld.tableAt(t)
+	if err != nil {
+		return Evaluation{}, err
+	}
+	_, percents, effects := ld.settings(sc, t)
+	return Evaluation{Percents: percents, Profile: profile, Effects: effects}, nil
+}
+
+// settings returns the table sc as it runs at t, for the biotope's
+// season if one is followed, and every channel's setting from it: the
+// mirrored sky instead of the table if there is one, or the held
+// percents, dimmed through any eclipse. The effects applied are named.
+func (ld *LightDriver) settings(sc *Schedule, t time.Time) (*Schedule, []float64, []string) {
+	var effects []string
+	ld.mu.Lock()
+	if ld.reverse {
+		effects = append(effects, "reverse")
+	}
+	if ld.biotope != nil {
+		effects = append(effects, "biotope")
+	}
+	ld.mu.Unlock()
+
+	sc = ld.seasonal(sc.on(t), t)
+	percents := ld.mirrored(sc, t)
+	if percents != nil {
+		effects = append(effects, "mirror")
+	} else {
+		percents = make([]float64, Channels)
+		for i := range percents {
+			percents[i] = sc.Percent(t, i)
+		}
+	}
+	if held := ld.holding(t); held != nil {
+		copy(percents, held)
+		return sc, percents, append(effects, "hold")
+	}
+	percents, eclipsed := ld.eclipsed(t, percents)
+	if eclipsed {
+		effects = append(effects, "eclipse")
+	}
+	return sc, percents, effects
+}
+
+// tableAt returns the table run on the day of t and its calendar
+// profile: the running table, unless a calendar followed switches to
+// another profile by then.
+func (ld *LightDriver) tableAt(t time.Time) (*Schedule, string, error) {
+	ld.mu.Lock()
+	f, sc, reverse := ld.follower, ld.schedule, ld.reverse
+	ld.mu.Unlock()
+	if f == nil {
+		return sc, "", nil
+	}
+	f.mu.Lock()
+	running, profile := f.profile, f.want(t)
+	var data []byte
+	var err error
+	if profile != running {
+		data, err = f.table(profile)
+	}
+	f.mu.Unlock()
+	if profile == running {
+		return sc, profile, nil
+	}
+	if err == nil {
+		sc, err = ParseSchedule(data)
+	}
+	if err != nil {
+		return nil, "", fmt.Errorf("calendar profile %s: %v", profileName(profile), err)
+	}
+	if reverse {
+		sc = sc.Reversed()
+	}
+	return sc, profile, nil
+}
+
+// ParseTime reads a time as RFC 3339, or as hours:minutes on the day of
+// now in the table's time zone. An empty string is now.
+func ParseTime(s string, now time.Time) (time.Time, error) {
+	if s == "" {
+		return now, nil
+	}
+	if t, err := time.Parse(time.RFC3339, s); err == nil {
+		return t, nil
+	}
+	hours, minutes, err := parseAt(s)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("bad time %q, expected RFC 3339 or hours:minutes", s)
+	}
+	initLtables()
+	day := now.In(timeLocation)
+	return time.Date(day.Year(), day.Month(), day.Day(), hours, minutes, 0, 0, timeLocation), nil
+}
@@ -0,0 +1,132 @@
+package ltable
+
+import (
+	"io/ioutil"
+	"math"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/theatrus/ledbrick/controller/astro"
+)
+
+func TestParseTime(t *testing.T) {
+	now := time.Date(2016, 6, 1, 8, 0, 0, 0, time.UTC)
+	if got, _ := ParseTime("", now); !got.Equal(now) {
+		t.Errorf("Expected no time to be now, got %s", got)
+	}
+	got, err := ParseTime("2016-06-02T19:00:00Z", now)
+	if err != nil || !got.Equal(time.Date(2016, 6, 2, 19, 0, 0, 0, time.UTC)) {
+		t.Errorf("Unexpected RFC 3339 time %s, %v", got, err)
+	}
+	got, err = ParseTime("19:00", now)
+	if err != nil || got.In(timeLocation).Hour() != 19 || got.In(timeLocation).Day() != now.In(timeLocation).Day() {
+		t.Errorf("Expected 19:00 today, got %s, %v", got, err)
+	}
+	if _, err := ParseTime("tomorrow", now); err == nil {
+		t.Error("Expected a bad time to fail")
+	}
+}
+
+func TestEval(t *testing.T) {
+	sc, err := ParseSchedule(chartTable)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ld := &LightDriver{schedule: sc}
+	noon, _ := ParseTime("12:00", time.Now())
+	percents := percentsAt(t, ld, noon)
+	if len(percents) != Channels || percents[7] != 100 {
+		t.Errorf("Expected UV at 100%% at noon, got %v", percents)
+	}
+}
+
+// percentsAt returns the driver's settings at a time.
+func percentsAt(t *testing.T, ld *LightDriver, at time.Time) []float64 {
+	e, err := ld.Eval(at)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return e.Percents
+}
+
+func TestEvalMatchesWrite(t *testing.T) {
+	f := &fakeChannel{}
+	ld, c := newTestDriver(t, f)
+	ld.schedule, _ = ParseSchedule([]byte(`[
+		{"at": "06:00", "percents": [0, 0, 0, 0, 0, 2, 0, 0]},
+		{"at": "12:00", "percents": [80, 0, 0, 0, 0, 50, 0, 0]},
+		{"at": "18:00", "percents": [0, 0, 0, 0, 0, 2, 0, 0]}
+	]`))
+	ld.FollowBiotope(Biotopes["red-sea"])
+
+	check := func(effects string) {
+		at := c.Now().Add(26 * time.Hour)
+		e, err := ld.Eval(at)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got := strings.Join(e.Effects, ","); got != effects {
+			t.Errorf("Expected effects %s, got %s", effects, got)
+		}
+		c.Advance(26 * time.Hour)
+		ld.updateChannels()
+		for i, want := range e.Percents {
+			if got := f.get(i); math.Abs(got-want) > 1e-9 {
+				t.Errorf("%s channel %d: evaluated %.2f ahead, written %.2f", effects, i, want, got)
+			}
+		}
+	}
+	check("biotope")
+	ld.Mirror(astro.Location{Latitude: -19.6, Longitude: 37.2}, 6*time.Hour)
+	check("biotope,mirror")
+}
+
+func TestEvalHoldAndProfile(t *testing.T) {
+	dir, err := ioutil.TempDir("", "calendar")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	def := filepath.Join(dir, "default.json")
+	vacation := filepath.Join(dir, "vacation.json")
+	ioutil.WriteFile(def, flat("10"), 0644)
+	ioutil.WriteFile(vacation, flat("30"), 0644)
+
+	f := &fakeChannel{}
+	ld, c := newTestDriver(t, f)
+	defer ld.Stop()
+	ld.FollowCalendar(&Calendar{Profiles: map[string]string{"vacation": vacation},
+		Rules: []CalendarRule{{Profile: "vacation", From: "2016-01-02", To: "2016-01-02"}},
+	}, def)
+	if err := ld.Hold([]float64{50, 0, 0, 0, 0, 0, 0, 0}, time.Hour); err != nil {
+		t.Fatal(err)
+	}
+
+	e, err := ld.Eval(c.Now().Add(30 * time.Minute))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if e.Profile != "" || e.Percents[0] != 50 || strings.Join(e.Effects, ",") != "hold" {
+		t.Errorf("Expected the hold on the default table, got %+v", e)
+	}
+	e, err = ld.Eval(c.Now().Add(2 * time.Hour))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if e.Percents[0] != 10 || len(e.Effects) != 0 {
+		t.Errorf("Expected the table once the hold is over, got %+v", e)
+	}
+	if ld.held == nil {
+		t.Error("Expected evaluating past the hold to leave it running")
+	}
+	e, err = ld.Eval(c.Now().Add(24 * time.Hour))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if e.Profile != "vacation" || e.Percents[0] != 30 {
+		t.Errorf("Expected the vacation table tomorrow, got %+v", e)
+	}
+}
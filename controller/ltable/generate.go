@@ -0,0 +1,109 @@
+package ltable
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math"
+	"time"
+
+	"github.com/theatrus/ledbrick/controller/ble"
+)
+
+// ChannelNames are the emitters on each channel of a stock LEDBrick.
+var ChannelNames = ble.ChannelNames
+
+// Plan describes a simple day for Generate: a sunrise ramp up to each
+// channel's peak, and a matching sunset, with optional moonlight.
+type Plan struct {
+	On    string
+	Off   string
+	Ramp  time.Duration
+	Peaks [Channels]float64
+	// Moonlight is the channel left on overnight, -1 for none
+	Moonlight   int
+	MoonPercent float64
+	// Offsets are how long each channel's sunrise lags the others and
+	// its sunset leads them, such as whites 45 minutes behind the blues.
+	// Negative offsets come up sooner and go down later.
+	Offsets [Channels]time.Duration
+}
+
+// How many steps to draw each ramp with
+const rampSteps = 4
+
+// Generate writes a lighting table for a plan.
+func Generate(p Plan) ([]byte, error) {
+	onH, onM, err := parseAt(p.On)
+	if err != nil {
+		return nil, err
+	}
+	offH, offM, err := parseAt(p.Off)
+	if err != nil {
+		return nil, err
+	}
+	on := onH*60 + onM
+	off := offH*60 + offM
+	ramp := int(p.Ramp / time.Minute)
+	if off <= on {
+		return nil, errors.New("lights must go off after they come on")
+	}
+	if ramp < rampSteps || on+2*ramp >= off {
+		return nil, fmt.Errorf("ramp must be at least %d minutes and under half the photoperiod", rampSteps)
+	}
+	var offsets []int
+	for channel, d := range p.Offsets {
+		if d == 0 {
+			continue
+		}
+		minutes := int(d / time.Minute)
+		if d%time.Minute != 0 || on-minutes < 0 || off+minutes > 24*60 ||
+			minutes > 0 && on+2*ramp+2*minutes >= off {
+			return nil, fmt.Errorf("%s offset %s must be whole minutes, keep the lights within the day and leave "+
+				"room for both ramps", ChannelNames[channel], d)
+		}
+		if offsets == nil {
+			offsets = make([]int, Channels)
+		}
+		offsets[channel] = minutes * 60
+	}
+
+	night := make([]float64, Channels)
+	if p.Moonlight >= 0 && p.Moonlight < Channels {
+		night[p.Moonlight] = p.MoonPercent
+	}
+	at := func(minute int, f float64) settingPoint {
+		percents := make([]float64, Channels)
+		for channel := range percents {
+			v := night[channel] + (p.Peaks[channel]-night[channel])*f
+			percents[channel] = math.Floor(v*10+0.5) / 10
+		}
+		return settingPoint{At: fmt.Sprintf("%02d:%02d", minute/60, minute%60), Percents: percents}
+	}
+
+	var settings settingPoints
+	if on > 0 {
+		settings = append(settings, at(0, 0))
+	}
+	// Ease in and out, as linear ramps between the points look abrupt
+	// at the ends
+	for step := 0; step <= rampSteps; step++ {
+		settings = append(settings, at(on+ramp*step/rampSteps, smoothstep(float64(step)/rampSteps)))
+	}
+	for step := 0; step <= rampSteps; step++ {
+		settings = append(settings, at(off-ramp+ramp*step/rampSteps, smoothstep(1-float64(step)/rampSteps)))
+	}
+	if errs := settings.validate(); len(errs) > 0 {
+		return nil, errs[0]
+	}
+	if offsets != nil {
+		sc := newSchedule(settings)
+		sc.offsets = offsets
+		return sc.TableV2()
+	}
+	return json.MarshalIndent(settings, "", "    ")
+}
+
+func smoothstep(x float64) float64 {
+	return x * x * (3 - 2*x)
+}
@@ -0,0 +1,143 @@
+package ltable
+
+import (
+	"math"
+	"testing"
+	"time"
+)
+
+func TestGenerate(t *testing.T) {
+	p := Plan{On: "09:00", Off: "21:00", Ramp: 2 * time.Hour, Moonlight: 5, MoonPercent: 0.5}
+	for channel := range p.Peaks {
+		p.Peaks[channel] = 60
+	}
+	data, err := Generate(p)
+	if err != nil {
+		t.Fatal(err)
+	}
+	summary, errs := Check(data)
+	if len(errs) > 0 {
+		t.Fatal(errs)
+	}
+	if summary.Points != 11 {
+		t.Errorf("Expected 11 points, got %d", summary.Points)
+	}
+	if summary.Peaks[0] != 60 || summary.PeakAt[0] != "11:00" {
+		t.Errorf("Expected a peak of 60%% from 11:00, got %f at %s", summary.Peaks[0], summary.PeakAt[0])
+	}
+	if summary.Peaks[5] != 60 {
+		t.Errorf("Expected moonlight channel to still peak at 60%%, got %f", summary.Peaks[5])
+	}
+
+	p.Off = "08:00"
+	if _, err := Generate(p); err == nil {
+		t.Error("Expected lights off before on to fail")
+	}
+	p.Off = "12:00"
+	if _, err := Generate(p); err == nil {
+		t.Error("Expected ramps longer than the photoperiod to fail")
+	}
+}
+
+func TestSolve(t *testing.T) {
+	p := Plan{On: "09:00", Off: "19:00", Ramp: time.Hour, Moonlight: -1}
+	target := Target{DLI: 8}
+	target.Weights[3] = 1
+	target.Weights[5] = 2
+	target.PAR[3] = 200
+	target.PAR[5] = 300
+
+	p, err := Solve(p, target)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if p.Peaks[5] != 2*p.Peaks[3] || p.Peaks[0] != 0 {
+		t.Errorf("Expected the peaks to follow the weights, got %v", p.Peaks)
+	}
+	data, err := Generate(p)
+	if err != nil {
+		t.Fatal(err)
+	}
+	sc, err := ParseSchedule(data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if dli, _ := sc.Light(target.PAR); dli < 7.9 || dli > 8.1 {
+		t.Errorf("Expected a DLI of about 8, got %.2f", dli)
+	}
+
+	target.DLI = 0
+	target.PeakPAR = 250
+	if p, err = Solve(p, target); err != nil {
+		t.Fatal(err)
+	}
+	if par := p.Peaks[3]/100*200 + p.Peaks[5]/100*300; par < 249 || par > 251 {
+		t.Errorf("Expected a peak PAR of 250, got %.1f", par)
+	}
+
+	target.PeakPAR = 1000
+	if _, err := Solve(p, target); err == nil {
+		t.Error("Expected a target past 100% to fail")
+	}
+	target.PAR = [Channels]float64{}
+	if _, err := Solve(p, target); err == nil {
+		t.Error("Expected a target without calibration to fail")
+	}
+}
+
+func TestGenerateOffsets(t *testing.T) {
+	initLtables()
+	p := Plan{On: "09:00", Off: "21:00", Ramp: 2 * time.Hour, Moonlight: -1}
+	for channel := range p.Peaks {
+		p.Peaks[channel] = 60
+	}
+	// White lags the blues at dawn and leads them at dusk, UV the reverse
+	p.Offsets[6] = 45 * time.Minute
+	p.Offsets[7] = -30 * time.Minute
+	data, err := Generate(p)
+	if err != nil {
+		t.Fatal(err)
+	}
+	sc, err := ParseSchedule(data)
+	if err != nil {
+		t.Fatalf("%v in\n%s", err, data)
+	}
+	at := func(hour, minute int) time.Time {
+		now := time.Now().In(timeLocation)
+		return time.Date(now.Year(), now.Month(), now.Day(), hour, minute, 0, 0, timeLocation)
+	}
+	for _, test := range []struct {
+		hour, minute, channel int
+		want                  float64
+	}{
+		{9, 45, 6, 0},
+		{11, 45, 6, 60},
+		{20, 15, 6, 0},
+		{8, 30, 7, 0},
+		{21, 15, 7, sc.Percent(at(20, 45), 3)},
+		{12, 0, 3, 60},
+	} {
+		if v := sc.Percent(at(test.hour, test.minute), test.channel); math.Abs(v-test.want) > 0.01 {
+			t.Errorf("Expected channel %d at %02d:%02d to be %v, got %v", test.channel, test.hour, test.minute, test.want, v)
+		}
+	}
+	if sc.Percent(at(10, 30), 6) != sc.Percent(at(9, 45), 3) {
+		t.Errorf("Expected white to follow blue 45 minutes behind")
+	}
+
+	// Fixtures get the offsets baked into the program
+	prog := sc.Program()
+	for _, pt := range prog.Points {
+		when := at(pt.Minute/60, pt.Minute%60)
+		for channel, v := range pt.Percents {
+			if math.Abs(v-sc.Percent(when, channel)) > 0.01 {
+				t.Errorf("Expected the program at minute %d to match the schedule, got %v", pt.Minute, pt.Percents)
+			}
+		}
+	}
+
+	p.Offsets[6] = 5 * time.Hour
+	if _, err := Generate(p); err == nil {
+		t.Error("Expected an offset leaving no room for the ramps to fail")
+	}
+}
@@ -0,0 +1,52 @@
+package ltable
+
+import (
+	"errors"
+	"log"
+	"time"
+)
+
+// Hold drives the channels at fixed percents instead of the table for a
+// while, such as for a photograph, then goes back to the table. Holding
+// again replaces the last hold.
+func (ld *LightDriver) Hold(percents []float64, d time.Duration) error {
+	if len(percents) != Channels {
+		return errors.New("a hold needs a percent for every channel")
+	}
+	for _, p := range percents {
+		if p < 0 || p > 100 {
+			return errors.New("Out of range percent (0-100)")
+		}
+	}
+	ld.mu.Lock()
+	ld.held = append([]float64(nil), percents...)
+	ld.heldFrom = ld.clock.Now()
+	ld.heldUntil = ld.heldFrom.Add(d)
+	ld.mu.Unlock()
+	log.Printf("Holding channels at %.1f for %s", percents, d)
+	ld.updateChannels()
+	return nil
+}
+
+// ClearHold goes back to the table straight away.
+func (ld *LightDriver) ClearHold() {
+	ld.mu.Lock()
+	ld.held = nil
+	ld.mu.Unlock()
+	ld.updateChannels()
+}
+
+// holding returns the percents held at t, or nil if no hold covers it.
+// A hold over by now is dropped.
+func (ld *LightDriver) holding(t time.Time) []float64 {
+	ld.mu.Lock()
+	defer ld.mu.Unlock()
+	if ld.held != nil && !ld.clock.Now().Before(ld.heldUntil) {
+		log.Printf("Hold over, back to the table")
+		ld.held = nil
+	}
+	if ld.held == nil || t.Before(ld.heldFrom) || !t.Before(ld.heldUntil) {
+		return nil
+	}
+	return ld.held
+}
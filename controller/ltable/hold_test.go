@@ -0,0 +1,34 @@
+package ltable
+
+import (
+	"testing"
+	"time"
+)
+
+func TestHold(t *testing.T) {
+	f := &fakeChannel{}
+	ld, c := newTestDriver(t, f)
+	if err := ld.Hold([]float64{50}, time.Minute); err == nil {
+		t.Error("Expected a hold missing channels to fail")
+	}
+	if err := ld.Hold([]float64{50, 0, 0, 0, 0, 0, 0, 101}, time.Minute); err == nil {
+		t.Error("Expected an out of range hold to fail")
+	}
+	if err := ld.Hold([]float64{50, 0, 0, 0, 0, 0, 0, 0}, time.Minute); err != nil {
+		t.Fatal(err)
+	}
+	if f.get(0) != 50 {
+		t.Errorf("Expected the held percent written, got %.0f", f.get(0))
+	}
+	c.Advance(time.Minute)
+	ld.updateChannels()
+	if f.get(0) != 10 {
+		t.Errorf("Expected the table back after the hold, got %.0f", f.get(0))
+	}
+
+	ld.Hold([]float64{50, 0, 0, 0, 0, 0, 0, 0}, time.Hour)
+	ld.ClearHold()
+	if f.get(0) != 10 {
+		t.Errorf("Expected the table back after clearing the hold, got %.0f", f.get(0))
+	}
+}
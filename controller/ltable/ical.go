@@ -0,0 +1,153 @@
+package ltable
+
+import (
+	"fmt"
+	"io"
+	"strings"
+	"time"
+)
+
+// Thresholds, as fractions of the day's peak total output, below which
+// the tank counts as dark, and above which it is at its peak
+const (
+	darkFraction = 0.05
+	peakFraction = 0.9
+)
+
+// Event is a part of the lighting program worth knowing about, such as
+// when the tank is at its best for viewing.
+type Event struct {
+	Start   time.Time
+	End     time.Time
+	Summary string
+	// AllDay events cover the days from Start up to End
+	AllDay bool
+}
+
+// Events returns when a schedule ramps up, is at its peak, and ramps
+// down on the day of t, judged by every channel's output added up.
+func (sc *Schedule) Events(t time.Time) []Event {
+	initLtables()
+	t = t.In(timeLocation)
+	midnight := time.Date(t.Year(), t.Month(), t.Day(), 0, 0, 0, 0, timeLocation)
+	var minutes []time.Time
+	var totals []float64
+	peak := 0.0
+	for at := midnight; at.Day() == midnight.Day(); at = at.Add(time.Minute) {
+		total := 0.0
+		for channel := 0; channel < Channels; channel++ {
+			total += sc.Percent(at, channel)
+		}
+		minutes = append(minutes, at)
+		totals = append(totals, total)
+		if total > peak {
+			peak = total
+		}
+	}
+	if peak <= 0 {
+		return nil
+	}
+	first := func(above float64) int {
+		for i, total := range totals {
+			if total > above {
+				return i
+			}
+		}
+		return -1
+	}
+	last := func(above float64) int {
+		for i := len(totals) - 1; i >= 0; i-- {
+			if totals[i] > above {
+				return i
+			}
+		}
+		return -1
+	}
+	end := func(i int) time.Time { return minutes[i].Add(time.Minute) }
+
+	lit, fall := first(darkFraction*peak), last(darkFraction*peak)
+	top, drop := first(peakFraction*peak-1e-9), last(peakFraction*peak-1e-9)
+	var events []Event
+	if lit < top {
+		events = append(events, Event{Start: minutes[lit], End: minutes[top], Summary: "Lights ramping up"})
+	}
+	events = append(events, Event{Start: minutes[top], End: end(drop), Summary: "Peak light, best for viewing and photos"})
+	if drop < fall {
+		events = append(events, Event{Start: end(drop), End: end(fall), Summary: "Lights ramping down"})
+	}
+	return events
+}
+
+// Events returns the lighting program's events for a number of days
+// from the day of t. With a calendar each later day's events come from
+// its profile's table, and a day switching profile has an all day event
+// saying so.
+func (ld *LightDriver) Events(t time.Time, days int) []Event {
+	initLtables()
+	ld.mu.Lock()
+	f, running, reverse := ld.follower, ld.schedule, ld.reverse
+	ld.mu.Unlock()
+
+	var events []Event
+	prev := ""
+	for i := 0; i < days; i++ {
+		day := t.In(timeLocation).AddDate(0, 0, i)
+		sc := running
+		if f != nil {
+			profile := f.calendar.Profile(day)
+			// Today runs whatever is applied, edits and all
+			if i > 0 {
+				if loaded, err := f.schedule(profile); err == nil {
+					sc = loaded
+					if reverse {
+						sc = sc.Reversed()
+					}
+				}
+				if profile != prev {
+					midnight := time.Date(day.Year(), day.Month(), day.Day(), 0, 0, 0, 0, timeLocation)
+					events = append(events, Event{Start: midnight, End: midnight.AddDate(0, 0, 1), AllDay: true,
+						Summary: "Lighting profile " + profileName(profile)})
+				}
+			}
+			prev = profile
+		}
+		events = append(events, sc.Events(day)...)
+	}
+	return events
+}
+
+// schedule reads and parses a profile's table.
+func (f *CalendarFollower) schedule(profile string) (*Schedule, error) {
+	data, err := f.table(profile)
+	if err != nil {
+		return nil, err
+	}
+	return ParseSchedule(data)
+}
+
+// icalEscape escapes text for an iCalendar property.
+var icalEscape = strings.NewReplacer(`\`, `\\`, ";", `\;`, ",", `\,`, "\n", `\n`)
+
+// WriteICal writes events as an iCalendar feed, named for calendar
+// apps to show. Times are in UTC, so apps show them in their own zone.
+func WriteICal(w io.Writer, name string, events []Event) error {
+	lines := []string{"BEGIN:VCALENDAR", "VERSION:2.0", "PRODID:-//LEDBrick//Lighting program//EN",
+		"CALSCALE:GREGORIAN", "X-WR-CALNAME:" + icalEscape.Replace(name)}
+	stamp := time.Now().UTC().Format("20060102T150405Z")
+	for _, e := range events {
+		uid := fmt.Sprintf("%d-%s@%s", e.Start.Unix(), strings.Replace(strings.ToLower(e.Summary), " ", "-", -1),
+			strings.Replace(strings.ToLower(name), " ", "-", -1))
+		lines = append(lines, "BEGIN:VEVENT", "UID:"+icalEscape.Replace(uid), "DTSTAMP:"+stamp)
+		if e.AllDay {
+			lines = append(lines, "DTSTART;VALUE=DATE:"+e.Start.Format("20060102"),
+				"DTEND;VALUE=DATE:"+e.End.Format("20060102"))
+		} else {
+			lines = append(lines, "DTSTART:"+e.Start.UTC().Format("20060102T150405Z"),
+				"DTEND:"+e.End.UTC().Format("20060102T150405Z"))
+		}
+		lines = append(lines, "SUMMARY:"+icalEscape.Replace(e.Summary), "TRANSP:TRANSPARENT", "END:VEVENT")
+	}
+	lines = append(lines, "END:VCALENDAR")
+	_, err := io.WriteString(w, strings.Join(lines, "\r\n")+"\r\n")
+	return err
+}
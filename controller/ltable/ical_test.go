@@ -0,0 +1,55 @@
+package ltable
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestScheduleEvents(t *testing.T) {
+	initLtables()
+	sc, err := ParseSchedule([]byte(`[
+		{"at": "08:00", "percents": [0, 0, 0, 0, 0, 0, 0, 0]},
+		{"at": "10:00", "percents": [100, 0, 0, 0, 0, 0, 0, 0]},
+		{"at": "16:00", "percents": [100, 0, 0, 0, 0, 0, 0, 0]},
+		{"at": "18:00", "percents": [0, 0, 0, 0, 0, 0, 0, 0]}
+	]`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	day := time.Date(2016, 7, 1, 12, 0, 0, 0, timeLocation)
+	events := sc.Events(day)
+	if len(events) != 3 {
+		t.Fatalf("Expected ramp up, peak and ramp down, got %v", events)
+	}
+	clock := func(e time.Time) string { return e.In(timeLocation).Format("15:04") }
+	for i, want := range [][2]string{{"08:07", "09:48"}, {"09:48", "16:13"}, {"16:13", "17:54"}} {
+		if got := [2]string{clock(events[i].Start), clock(events[i].End)}; got != want {
+			t.Errorf("%s: expected %v, got %v", events[i].Summary, want, got)
+		}
+	}
+
+	dark, err := ParseSchedule(flat("0"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if events := dark.Events(day); events != nil {
+		t.Errorf("Expected no events when dark, got %v", events)
+	}
+
+	var buf bytes.Buffer
+	if err := WriteICal(&buf, "LEDBrick, reef", events); err != nil {
+		t.Fatal(err)
+	}
+	out := buf.String()
+	for _, want := range []string{"BEGIN:VCALENDAR\r\n", "X-WR-CALNAME:LEDBrick\\, reef\r\n",
+		"DTSTART:" + events[1].Start.UTC().Format("20060102T150405Z") + "\r\n", "END:VCALENDAR\r\n"} {
+		if !strings.Contains(out, want) {
+			t.Errorf("Expected %q in %s", want, out)
+		}
+	}
+	if strings.Count(out, "BEGIN:VEVENT") != 3 {
+		t.Errorf("Expected 3 events in %s", out)
+	}
+}
@@ -0,0 +1,101 @@
+package ltable
+
+import (
+	"flag"
+	"fmt"
+	"math"
+	"time"
+)
+
+var confirmChange float64
+var confirmDLI float64
+
+func init() {
+	flag.Float64Var(&confirmChange, "ltable.confirm.change", 25,
+		"Percent any channel may differ from the running table over the next day before a new table needs confirming")
+	flag.Float64Var(&confirmDLI, "ltable.confirm.dli", 20,
+		"Percent the DLI may change by before a new table needs confirming, with -report.par set")
+}
+
+// Impact is how a new table would differ from the running one, now and
+// over the next 24 hours.
+type Impact struct {
+	// Now is the change to each channel on applying it
+	Now [Channels]float64 `json:"now"`
+	// MaxChange is the largest difference on any channel over the next
+	// day, and where it is
+	MaxChange        float64   `json:"max_change"`
+	MaxChangeAt      time.Time `json:"max_change_at"`
+	MaxChangeChannel int       `json:"max_change_channel"`
+	// DLI of each table, if there is a PAR calibration
+	DLIBefore float64 `json:"dli_before,omitempty"`
+	DLIAfter  float64 `json:"dli_after,omitempty"`
+	// Peak watts and energy over the day of each table
+	PeakWattsBefore float64 `json:"peak_watts_before"`
+	PeakWattsAfter  float64 `json:"peak_watts_after"`
+	EnergyBefore    float64 `json:"energy_before_wh"`
+	EnergyAfter     float64 `json:"energy_after_wh"`
+	// Confirm gives the reasons the change is big enough to need
+	// confirming, if it is
+	Confirm []string `json:"confirm,omitempty"`
+}
+
+// Impact works out what applying a new table would change, with a PAR
+// calibration for the DLI, which may be nil.
+func (ld *LightDriver) Impact(data []byte, par []float64) (Impact, error) {
+	var impact Impact
+	next, err := ParseSchedule(data)
+	if err != nil {
+		return impact, err
+	}
+	initLtables()
+	running := ld.current()
+	now := ld.clock.Now().In(timeLocation)
+
+	for minute := 0; minute <= 24*60; minute++ {
+		at := now.Add(time.Duration(minute) * time.Minute)
+		before := make(map[int]float64)
+		after := make(map[int]float64)
+		for channel := 0; channel < Channels; channel++ {
+			before[channel] = running.Percent(at, channel)
+			after[channel] = next.Percent(at, channel)
+			change := after[channel] - before[channel]
+			if minute == 0 {
+				impact.Now[channel] = change
+			}
+			if math.Abs(change) > math.Abs(impact.MaxChange) {
+				impact.MaxChange = change
+				impact.MaxChangeAt = at
+				impact.MaxChangeChannel = channel
+			}
+		}
+		if minute == 24*60 {
+			break
+		}
+		wattsBefore, wattsAfter := ld.ble.Power(before), ld.ble.Power(after)
+		impact.PeakWattsBefore = math.Max(impact.PeakWattsBefore, wattsBefore)
+		impact.PeakWattsAfter = math.Max(impact.PeakWattsAfter, wattsAfter)
+		impact.EnergyBefore += wattsBefore / 60
+		impact.EnergyAfter += wattsAfter / 60
+	}
+
+	if len(par) > 0 {
+		var calibration [Channels]float64
+		copy(calibration[:], par)
+		impact.DLIBefore, _ = running.Light(calibration)
+		impact.DLIAfter, _ = next.Light(calibration)
+	}
+
+	if math.Abs(impact.MaxChange) > confirmChange {
+		impact.Confirm = append(impact.Confirm, fmt.Sprintf("%s changes by %+.0f%% at %s",
+			ChannelNames[impact.MaxChangeChannel], impact.MaxChange, impact.MaxChangeAt.Format("15:04")))
+	}
+	if impact.DLIBefore > 0 {
+		change := (impact.DLIAfter - impact.DLIBefore) / impact.DLIBefore * 100
+		if math.Abs(change) > confirmDLI {
+			impact.Confirm = append(impact.Confirm, fmt.Sprintf("DLI changes by %+.0f%% from %.1f to %.1f",
+				change, impact.DLIBefore, impact.DLIAfter))
+		}
+	}
+	return impact, nil
+}
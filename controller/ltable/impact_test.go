@@ -0,0 +1,43 @@
+package ltable
+
+import (
+	"testing"
+)
+
+func TestImpact(t *testing.T) {
+	f := &fakeChannel{}
+	ld, _ := newTestDriver(t, f)
+
+	impact, err := ld.Impact(flat("20"), []float64{100})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if impact.Now[0] != 10 || impact.MaxChange != 10 || impact.MaxChangeChannel != 0 {
+		t.Errorf("Expected channel 0 up 10%%, got %+v", impact)
+	}
+	if impact.PeakWattsBefore != 10 || impact.PeakWattsAfter != 20 {
+		t.Errorf("Expected peak watts of 10 and 20, got %.1f and %.1f", impact.PeakWattsBefore, impact.PeakWattsAfter)
+	}
+	if impact.EnergyAfter < 479 || impact.EnergyAfter > 481 {
+		t.Errorf("Expected 480 Wh a day, got %.1f", impact.EnergyAfter)
+	}
+	// A doubling of the DLI needs confirming, the change itself doesn't
+	if len(impact.Confirm) != 1 {
+		t.Errorf("Expected the DLI change to need confirming, got %v", impact.Confirm)
+	}
+
+	impact, err = ld.Impact(flat("60"), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if impact.DLIBefore != 0 || len(impact.Confirm) != 1 {
+		t.Errorf("Expected a 50%% jump to need confirming without a DLI, got %+v", impact)
+	}
+	if f.get(0) != 10 {
+		t.Errorf("Expected the running table untouched, got %.0f", f.get(0))
+	}
+
+	if _, err := ld.Impact([]byte(`[{"at": "00:00"}]`), nil); err == nil {
+		t.Error("Expected a table with problems to fail")
+	}
+}
@@ -0,0 +1,112 @@
+package ltable
+
+import (
+	"fmt"
+	"math"
+	"strings"
+	"time"
+)
+
+// Limits past which a valid table is probably a mistake.
+const (
+	// Percent per minute any channel may change by
+	lintMaxSlope       = 5
+	lintMaxPhotoperiod = 14 * time.Hour
+)
+
+// Lint is something suspicious about a valid lighting table, with a
+// suggestion for fixing it.
+type Lint struct {
+	// Line is where the point involved starts, 0 for the whole table
+	Line       int    `json:"line,omitempty"`
+	Message    string `json:"message"`
+	Suggestion string `json:"suggestion"`
+}
+
+func (l Lint) String() string {
+	if l.Line > 0 {
+		return fmt.Sprintf("line %d: %s", l.Line, l.Message)
+	}
+	return l.Message
+}
+
+// lint checks sorted, valid setting points for suspicious settings, with
+// the summary Check has worked out for them.
+func (s settingPoints) lint(summary Summary) []Lint {
+	var lints []Lint
+	add := func(line int, suggestion string, format string, args ...interface{}) {
+		lints = append(lints, Lint{Line: line,
+			Message:    fmt.Sprintf(format, args...),
+			Suggestion: suggestion,
+		})
+	}
+
+	// Points are sorted by time by now, so their lines going backwards
+	// means the file wasn't
+	for i := 1; i < len(s); i++ {
+		if s[i].line < s[i-1].line {
+			add(s[i-1].line, "Sort the points by time so the file reads in the order it runs",
+				"point at %s is after the point at %s in the file", s[i-1].At, s[i].At)
+			break
+		}
+	}
+
+	sc := newSchedule(s)
+	for i := range s {
+		// Each point and the next, wrapping around midnight
+		next := (i + 1) % len(s)
+		if next == i {
+			break
+		}
+		gap := sc.at[next] - sc.at[i]
+		if gap <= 0 {
+			gap += secondsPerDay
+		}
+		if time.Duration(gap)*time.Second < interval {
+			add(s[next].line, fmt.Sprintf("Move the points at least %s apart, or lower -ltable.interval", interval),
+				"point at %s is only %s after %s, less than the update interval, so it may never be used",
+				s[next].At, time.Duration(gap)*time.Second, s[i].At)
+		}
+
+		var steep, changing []string
+		minutes := float64(gap) / 60
+		needed := 0.0
+		for channel := 0; channel < Channels; channel++ {
+			change := s[next].Percents[channel] - s[i].Percents[channel]
+			if change < 0 {
+				change = -change
+			}
+			if change > 0 {
+				changing = append(changing, ChannelNames[channel])
+			}
+			if change/minutes > lintMaxSlope {
+				steep = append(steep, fmt.Sprintf("%s by %.0f%%", ChannelNames[channel], change))
+				if change/lintMaxSlope > needed {
+					needed = change / lintMaxSlope
+				}
+			}
+		}
+		if len(steep) > 0 {
+			add(s[next].line, fmt.Sprintf("Spread the change over at least %s, sudden changes stress livestock",
+				time.Duration(math.Ceil(needed))*time.Minute),
+				"%s changes in %s from %s to %s", strings.Join(steep, ", "),
+				time.Duration(gap)*time.Second, s[i].At, s[next].At)
+		}
+		if next == 0 && sc.at[0] != 0 && len(changing) > 0 {
+			add(s[0].line, "Add points at the start and end of the night so it holds steady, or a point at 00:00 if the ramp is meant",
+				"%s ramp across midnight from %s to %s", strings.Join(changing, ", "), s[i].At, s[0].At)
+		}
+	}
+
+	for channel := 0; channel < Channels; channel++ {
+		if summary.Peaks[channel] == 0 {
+			add(0, "Give the channel a setting if it is wired, otherwise ignore this",
+				"channel %d (%s) is always 0%%", channel, ChannelNames[channel])
+		}
+	}
+	if summary.Photoperiod > lintMaxPhotoperiod {
+		add(0, "Most tanks do well on 8 to 12 hours, long days encourage algae",
+			"photoperiod is %s, over %s", summary.Photoperiod, lintMaxPhotoperiod)
+	}
+	return lints
+}
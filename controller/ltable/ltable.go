@@ -1,56 +1,165 @@
 package ltable
 
 import (
-	"encoding/json"
+	"errors"
 	"flag"
 	"fmt"
 	"log"
+	"math"
 	"strconv"
 	"strings"
+	"sync"
 	"time"
 
+	"github.com/theatrus/ledbrick/controller/accessory"
+	"github.com/theatrus/ledbrick/controller/astro"
 	"github.com/theatrus/ledbrick/controller/ble"
+	"github.com/theatrus/ledbrick/controller/clock"
+	"github.com/theatrus/ledbrick/controller/events"
 )
 
 var timeLocation *time.Location
 var flagLocation string
+var interval = 10 * time.Second
 
 func init() {
 	// Setup a flag and provide a default location.
 	flag.StringVar(&flagLocation, "ltable.location",
 		"America/Los_Angeles", "The time zone to use for the location table")
+	flag.Var((*period)(&interval), "ltable.interval",
+		"How often to update the channels from the table")
 }
 
-func initLtables() {
-	var err error
-	timeLocation, err = time.LoadLocation(flagLocation)
+// period is a duration flag which must be more than zero, as it sets a
+// ticker.
+type period time.Duration
+
+func (p *period) String() string {
+	return time.Duration(*p).String()
+}
+
+func (p *period) Set(value string) error {
+	d, err := time.ParseDuration(value)
 	if err != nil {
-		panic(fmt.Sprintf("Bad location in flag (%s): %v", flagLocation, err))
+		return err
+	}
+	if d <= 0 {
+		return errors.New("must be more than zero")
 	}
+	*p = period(d)
+	return nil
+}
+
+// Accessories are looked up and set through these, so tests can stand
+// in for them
+var accessoryKnown = accessory.Known
+var setAccessory = accessory.Set
+
+var locationOnce sync.Once
+
+// initLtables loads -ltable.location into timeLocation the first time
+// it is called. Everything reading timeLocation calls it first, as
+// tables are loaded and run from several goroutines.
+func initLtables() {
+	locationOnce.Do(func() {
+		var err error
+		timeLocation, err = time.LoadLocation(flagLocation)
+		if err != nil {
+			panic(fmt.Sprintf("Bad location in flag (%s): %v", flagLocation, err))
+		}
+	})
 }
 
 type settingPoint struct {
 	At       string    `json:"at"`
 	Percents []float64 `json:"percents"`
+	// Zone is the time zone At is in, if not -ltable.location
+	Zone string `json:"zone,omitempty"`
+	// ease, tags, the channels a point leaves to its neighbours and
+	// the accessories it gives come from version 2 tables
+	ease        string
+	tags        []string
+	omitted     []int
+	accessories map[string]float64
+
+	// Where the point was read from, for error messages
+	line int
 }
 
 func (sp settingPoint) TimeAt() time.Time {
-	// Todo: make this more time parsey
-	if timeLocation == nil {
-		initLtables() // Lazy init
-	}
+	initLtables()
 
-	hm := strings.Split(sp.At, ":")
-	hours, err := strconv.ParseInt(hm[0], 10, 32)
+	hours, minutes, err := sp.clock(time.Now())
 	if err != nil {
-		log.Println("Bad hours, using 0: %s", err)
+		log.Printf("%s, using 00:00", err)
 	}
-	minutes, err := strconv.ParseInt(hm[1], 10, 32)
+
+	return time.Date(0, 0, 0, hours, minutes, 0, 0, timeLocation)
+}
+
+// clock returns the point's time of day in the controller's time zone
+// on a day. A point in another zone is converted as of that day, as the
+// zones may change for daylight saving on different days.
+func (sp settingPoint) clock(day time.Time) (int, int, error) {
+	hours, minutes, err := parseAt(sp.At)
+	if err != nil || sp.Zone == "" {
+		return hours, minutes, err
+	}
+	zone, err := time.LoadLocation(sp.Zone)
 	if err != nil {
-		log.Println("bad minutes, using 0: %s", err)
+		return 0, 0, fmt.Errorf("bad zone %q", sp.Zone)
+	}
+	initLtables()
+	d := day.In(zone)
+	t := time.Date(d.Year(), d.Month(), d.Day(), hours, minutes, 0, 0, zone).In(timeLocation)
+	return t.Hour(), t.Minute(), nil
+}
+
+// meridiems are the 12 hour clock suffixes, longest first so "a.m."
+// isn't taken for "m.".
+var meridiems = []string{"a.m.", "p.m.", "am", "pm"}
+
+// parseAt reads a time of day as hours:minutes on a 24 hour clock, or a
+// 12 hour clock with AM or PM such as 9:30 PM or 9pm. Hours and minutes
+// may also be separated by a dot or an h, as in 21.30 or 21h30.
+func parseAt(at string) (int, int, error) {
+	s := strings.ToLower(strings.TrimSpace(at))
+	pm, twelve := false, false
+	for _, m := range meridiems {
+		if strings.HasSuffix(s, m) {
+			s = strings.TrimSpace(strings.TrimSuffix(s, m))
+			pm, twelve = m[0] == 'p', true
+			break
+		}
 	}
 
-	return time.Date(0, 0, 0, int(hours), int(minutes), 0, 0, timeLocation)
+	hm := strings.FieldsFunc(s, func(r rune) bool { return r == ':' || r == '.' || r == 'h' })
+	if strings.Count(s, ":")+strings.Count(s, ".")+strings.Count(s, "h") > 1 {
+		hm = nil
+	}
+	if len(hm) == 1 && twelve && !strings.ContainsAny(s, ":.h") {
+		hm = append(hm, "00")
+	}
+	if len(hm) != 2 {
+		return 0, 0, fmt.Errorf("bad time %q, expected hours:minutes such as 21:30 or 9:30 PM", at)
+	}
+	hours, err := strconv.ParseInt(hm[0], 10, 32)
+	if twelve {
+		if err != nil || hours < 1 || hours > 12 {
+			return 0, 0, fmt.Errorf("bad hours in %q, expected 1 to 12 with AM or PM", at)
+		}
+		hours %= 12
+		if pm {
+			hours += 12
+		}
+	} else if err != nil || hours < 0 || hours > 23 {
+		return 0, 0, fmt.Errorf("bad hours in %q", at)
+	}
+	minutes, err := strconv.ParseInt(hm[1], 10, 32)
+	if err != nil || minutes < 0 || minutes > 59 {
+		return 0, 0, fmt.Errorf("bad minutes in %q", at)
+	}
+	return int(hours), int(minutes), nil
 }
 
 type settingPoints []settingPoint
@@ -61,92 +170,175 @@ func (s settingPoints) Less(i, j int) bool {
 	return s[i].TimeAt().Before(s[j].TimeAt())
 }
 
+// percentForTime evaluates sorted setting points at a time. Anything
+// evaluating more than once should prepare a schedule instead.
 func (ld settingPoints) percentForTime(t time.Time, channel int) float64 {
-	if timeLocation == nil {
-		initLtables() // Lazy init
-	}
-
-	// All the math is done in "local" time which may not be system
-	// local time, so adjust everything to our location
-	lt := t.In(timeLocation)
-	// Grab a zero m/d/y version for comparison
-	compareTime := time.Date(0, 0, 0, lt.Hour(), lt.Minute(), lt.Second(), 0, timeLocation)
+	return newSchedule(ld).Percent(t, channel)
+}
 
-	var iBefore int = len(ld) - 1 // Allow time to wrap
-	var iAfter int = len(ld) - 1
-	for i, v := range ld {
-		if v.TimeAt().Equal(compareTime) {
-			return v.Percents[channel]
-		}
+// LightDriver runs lighting tables on a channel, which needn't be
+// bluetooth's: anything standing in for the fixtures as a LightChannel
+// runs them the same.
+type LightDriver struct {
+	ble    ble.LightChannel
+	clock  clock.Clock
+	ticker clock.Ticker
+	// updating is held while writing the channels
+	updating sync.Mutex
+	// Last percents logged, so only changes are logged
+	logged []float64
+	// The table last sent to fixtures to run on their own
+	programmed *Schedule
 
-		if v.TimeAt().Before(compareTime) {
-			iBefore = i
-		} else {
-			iAfter = i
-			break
-		}
-	}
+	mu       sync.Mutex
+	schedule *Schedule
+	// While a newly applied table is on trial, until trial, good is
+	// the table to roll back to
+	good          *Schedule
+	trial         time.Time
+	trialDegraded bool
+	// reverse shifts every table run by twelve hours
+	reverse bool
+	// held are percents written instead of the table from heldFrom
+	// until heldUntil
+	held      []float64
+	heldFrom  time.Time
+	heldUntil time.Time
+	// undo and redo are the tables before and after edits made through
+	// Edit and Capture, kept in edits if it is set
+	undo  []*Schedule
+	redo  []*Schedule
+	edits string
+	// follower is the calendar switching tables, if there is one
+	follower *CalendarFollower
+	// eclipses are simulated as seen from here, if set
+	eclipses *astro.Location
+	// biotope scales the table through a reef's year, if set, with the
+	// seasonal table for seasonalDay prepared from seasonalFrom
+	biotope      *Biotope
+	seasonalDay  string
+	seasonalFrom *Schedule
+	seasonalSc   *Schedule
+	// mirror is the location whose sky is followed instead of the
+	// table's times, if set
+	mirror *mirror
+	// done is closed by Stop, ending the goroutines driving the table,
+	// and running counts them
+	done     chan struct{}
+	stopping sync.Once
+	running  sync.WaitGroup
+}
 
-	valueBefore := ld[iBefore].Percents[channel]
-	valueAfter := ld[iAfter].Percents[channel]
+func NewLightDriverFromJson(ble ble.LightChannel, data []byte) (*LightDriver, error) {
+	initLtables()
 
-	// Don't interpolate
-	if valueBefore == valueAfter {
-		return valueAfter
+	sc, problems := parseSchedule(data)
+	if len(problems) > 0 {
+		return nil, problems
 	}
+	return newLightDriver(ble, sc, clock.Real), nil
+}
 
-	difference := ld[iAfter].TimeAt().Sub(ld[iBefore].TimeAt()) / time.Second
-	if difference < 0 {
-		difference = difference * -1
-	}
-	nowDifference := compareTime.Sub(ld[iBefore].TimeAt()) / time.Second
-	if nowDifference < 0 {
-		nowDifference = nowDifference * -1
+func newLightDriver(b ble.LightChannel, sc *Schedule, c clock.Clock) *LightDriver {
+	ld := &LightDriver{ble: b,
+		clock:    c,
+		schedule: sc,
+		ticker:   c.NewTicker(interval),
 	}
 
-	lerpMult := float64(nowDifference) / float64(difference)
-	return valueBefore + lerpMult*(valueAfter-valueBefore)
+	ld.running.Add(1)
+	go ld.run()
+	ld.updateChannels()
+	return ld
 }
 
-type LightDriver struct {
-	ble      ble.BLEChannel
-	settings settingPoints
-	ticker   *time.Ticker
+// current returns the running table.
+func (ld *LightDriver) current() *Schedule {
+	ld.mu.Lock()
+	defer ld.mu.Unlock()
+	return ld.schedule
 }
 
-func NewLightDriverFromJson(ble ble.BLEChannel, data []byte) (*LightDriver, error) {
-	if timeLocation == nil {
-		initLtables() // Lazy init
+// updateChannels writes the table's settings for now, returning an
+// error if that rolled back a table on trial.
+func (ld *LightDriver) updateChannels() error {
+	ld.updating.Lock()
+	defer ld.updating.Unlock()
+	err := ld.checkTrial(ld.write())
+	if err != nil {
+		// Put the good table's settings back straight away
+		ld.write()
 	}
+	return err
+}
 
-	var settings settingPoints
-	err := json.Unmarshal(data, &settings)
-	if err != nil {
-		return nil, err
+// write sets every channel from the running table, returning the first
+// write error.
+func (ld *LightDriver) write() error {
+	initLtables()
+	now := ld.clock.Now().In(timeLocation)
+	sc, percents, _ := ld.settings(ld.current(), now)
+	if sc != ld.programmed {
+		// Keep fixtures which run the table on their own in sync
+		ld.ble.SetProgram(sc.Program())
+		ld.programmed = sc
+	}
+	changed := ld.logged == nil
+	var failed error
+	for i := range percents {
+		if err := ld.ble.SetChannel(i, percents[i]); err != nil && failed == nil {
+			failed = fmt.Errorf("channel %d: %v", i, err)
+		}
+		if !changed && math.Abs(percents[i]-ld.logged[i]) >= 1 {
+			changed = true
+		}
 	}
-	ld := &LightDriver{ble: ble,
-		settings: settings,
-		ticker:   time.NewTicker(10 * time.Second),
+	// Accessories follow the table alone, untouched by holds and
+	// effects meant for the lights
+	for name, a := range sc.accessories {
+		setAccessory(name, a.Percent(now, 0))
 	}
-
-	go ld.run()
-	ld.updateChannels()
-	return ld, nil
+	if changed {
+		log.Printf("Channel settings now %.1f", percents)
+		ld.logged = percents
+		events.Publish(events.Channels, events.ChannelsData{Percents: percents})
+	}
+	return failed
 }
 
-func (ld *LightDriver) updateChannels() {
-	log.Println("Updating channel settings")
-	now := time.Now().In(timeLocation)
-	for i := 0; i < 8; i++ {
-		percent := ld.settings.percentForTime(now, i)
-		log.Printf("    ---- channel %d percent %f", i, percent)
-		ld.ble.SetChannel(i, percent)
+func (ld *LightDriver) run() {
+	defer ld.running.Done()
+	done := ld.stopped()
+	for {
+		select {
+		case <-ld.ticker.C():
+			ld.updateChannels()
+		case <-done:
+			return
+		}
 	}
-
 }
 
-func (ld *LightDriver) run() {
-	for _ = range ld.ticker.C {
-		ld.updateChannels()
+// stopped returns the channel Stop closes.
+func (ld *LightDriver) stopped() <-chan struct{} {
+	ld.mu.Lock()
+	defer ld.mu.Unlock()
+	if ld.done == nil {
+		ld.done = make(chan struct{})
 	}
+	return ld.done
+}
+
+// Stop ends the driver: the table is no longer written, and calendars
+// followed and subscribed to are no longer checked. It waits for an
+// update under way to finish.
+func (ld *LightDriver) Stop() {
+	ld.stopped()
+	ld.stopping.Do(func() {
+		if ld.ticker != nil {
+			ld.ticker.Stop()
+		}
+		close(ld.done)
+	})
+	ld.running.Wait()
 }
@@ -0,0 +1,82 @@
+package ltable
+
+import (
+	"log"
+	"math"
+	"time"
+
+	"github.com/theatrus/ledbrick/controller/astro"
+)
+
+// Below the horizon the sky still glows until the sun is this far down,
+// the end of civil twilight, to this fraction of full sun
+const (
+	twilightAltitude = -6
+	twilightLight    = 0.02
+)
+
+// mirror is a location whose sky is followed, offset later.
+type mirror struct {
+	location astro.Location
+	offset   time.Duration
+}
+
+// Mirror follows the sun at a location in real time instead of the
+// table's times, with the sky offset later so, say, Fiji's noon lands
+// in the evening. Each channel runs from its level at the table's
+// darkest point at night up to its peak in the table with the sun
+// overhead, by how high the sun is.
+func (ld *LightDriver) Mirror(l astro.Location, offset time.Duration) {
+	ld.mu.Lock()
+	ld.mirror = &mirror{location: l, offset: offset}
+	ld.mu.Unlock()
+	log.Printf("Mirroring the sky at %s, %s later", l, offset)
+	ld.updateChannels()
+}
+
+// sunlight returns the fraction of full sun for the sun's altitude.
+func sunlight(altitude float64) float64 {
+	switch {
+	case altitude <= twilightAltitude:
+		return 0
+	case altitude <= 0:
+		return twilightLight * (altitude - twilightAltitude) / -twilightAltitude
+	}
+	return twilightLight + (1-twilightLight)*math.Sin(altitude*math.Pi/180)
+}
+
+// mirrored returns the channels for the mirrored sky at now, or nil if
+// no location is mirrored.
+func (ld *LightDriver) mirrored(sc *Schedule, now time.Time) []float64 {
+	ld.mu.Lock()
+	m := ld.mirror
+	ld.mu.Unlock()
+	if m == nil {
+		return nil
+	}
+	night, peak := sc.extremes()
+	f := sunlight(m.location.SunAltitude(now.Add(-m.offset)))
+	percents := make([]float64, Channels)
+	for i := range percents {
+		percents[i] = night[i] + (peak[i]-night[i])*f
+	}
+	return percents
+}
+
+// extremes returns the percents of the table's darkest point, and each
+// channel's peak.
+func (sc *Schedule) extremes() (night, peak []float64) {
+	peak = make([]float64, Channels)
+	darkest := math.Inf(1)
+	for _, percents := range sc.percents {
+		total := 0.0
+		for i, p := range percents {
+			total += p
+			peak[i] = math.Max(peak[i], p)
+		}
+		if total < darkest {
+			darkest, night = total, percents
+		}
+	}
+	return night, peak
+}
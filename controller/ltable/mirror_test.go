@@ -0,0 +1,48 @@
+package ltable
+
+import (
+	"math"
+	"testing"
+	"time"
+
+	"github.com/theatrus/ledbrick/controller/astro"
+	"github.com/theatrus/ledbrick/controller/clock"
+)
+
+func TestMirror(t *testing.T) {
+	f := &fakeChannel{}
+	ld, _ := newTestDriver(t, f)
+	ld.schedule, _ = ParseSchedule([]byte(`[
+		{"at": "06:00", "percents": [0, 0, 0, 0, 0, 2, 0, 0]},
+		{"at": "12:00", "percents": [80, 0, 0, 0, 0, 50, 0, 0]},
+		{"at": "18:00", "percents": [0, 0, 0, 0, 0, 2, 0, 0]}
+	]`))
+	greenwich := astro.Location{Latitude: 51.48, Longitude: 0}
+
+	// Midsummer noon in Greenwich, mirrored six hours later
+	ld.clock = clock.NewFake(time.Date(2017, 6, 21, 18, 2, 0, 0, time.UTC))
+	ld.Mirror(greenwich, 6*time.Hour)
+	want := sunlight(61.96)
+	if got := f.get(0); math.Abs(got-80*want) > 0.5 {
+		t.Errorf("Expected %.1f with the sun at 62 degrees, got %.1f", 80*want, got)
+	}
+	if got := f.get(5); math.Abs(got-(2+48*want)) > 0.5 {
+		t.Errorf("Expected %.1f on the moonlight channel, got %.1f", 2+48*want, got)
+	}
+
+	ld.clock = clock.NewFake(time.Date(2017, 6, 22, 6, 0, 0, 0, time.UTC))
+	ld.updateChannels()
+	if f.get(0) != 0 || f.get(5) != 2 {
+		t.Errorf("Expected the night levels at Greenwich midnight, got %.1f and %.1f", f.get(0), f.get(5))
+	}
+}
+
+func TestSunlight(t *testing.T) {
+	for _, tc := range []struct{ altitude, want float64 }{
+		{-10, 0}, {-3, 0.01}, {0, 0.02}, {90, 1},
+	} {
+		if got := sunlight(tc.altitude); math.Abs(got-tc.want) > 1e-9 {
+			t.Errorf("Sun at %.0f: expected %.2f, got %.2f", tc.altitude, tc.want, got)
+		}
+	}
+}
@@ -0,0 +1,328 @@
+package ltable
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/theatrus/ledbrick/controller/ble"
+)
+
+// Problems is every error found in a lighting table.
+type Problems []error
+
+func (p Problems) Error() string {
+	msgs := make([]string, len(p))
+	for i, err := range p {
+		msgs[i] = err.Error()
+	}
+	return strings.Join(msgs, "\n")
+}
+
+// pointV2 is a setting point of a version 2 table, giving each channel
+// by name.
+type pointV2 struct {
+	At       string             `json:"at"`
+	Zone     string             `json:"zone,omitempty"`
+	Channels map[string]float64 `json:"channels"`
+	// Ease is how the channels move to the next point: linear, step,
+	// ease-in, ease-out or smooth
+	Ease string   `json:"ease,omitempty"`
+	Tags []string `json:"tags,omitempty"`
+}
+
+// tableV2 is a version 2 table.
+type tableV2 struct {
+	Version int `json:"version"`
+	// Offsets are how long channels, by name, lag the table on the way
+	// up and lead it on the way down, such as "45m"
+	Offsets map[string]string `json:"offsets,omitempty"`
+	Points  []pointV2         `json:"points"`
+}
+
+// parseTable decodes and validates a lighting table, returning it
+// sorted by time. A table is a list of points giving every channel's
+// percent in order, or a version 2 object whose points name their
+// channels. Unknown fields, such as a misspelt "precents", are
+// rejected, and every problem found is reported with its line. A
+// version 2 table may give channels offsets, returned in seconds.
+func parseTable(data []byte) (settingPoints, []int, Problems) {
+	dec := json.NewDecoder(bytes.NewReader(data))
+	dec.DisallowUnknownFields()
+
+	var settings settingPoints
+	var offsets []int
+	var problems Problems
+	t, err := dec.Token()
+	switch {
+	case err != nil:
+		return nil, nil, Problems{positioned(data, dec.InputOffset(), err)}
+	case t == json.Delim('['):
+		settings, problems = decodePoints(data, dec, func(dec *json.Decoder) (settingPoint, error) {
+			var sp settingPoint
+			return sp, dec.Decode(&sp)
+		})
+	case t == json.Delim('{'):
+		settings, offsets, problems = decodeV2(data, dec)
+	default:
+		return nil, nil, Problems{fmt.Errorf("line %d: expected a list of setting points", lineAt(data, 0))}
+	}
+	if settings == nil && len(problems) > 0 {
+		return nil, nil, problems
+	}
+
+	if len(problems) == 0 {
+		problems = append(problems, settings.validate()...)
+	}
+	if len(problems) > 0 {
+		return nil, nil, problems
+	}
+	sort.Sort(settings)
+	if problems := settings.fill(); len(problems) > 0 {
+		return nil, nil, problems
+	}
+	return settings, offsets, nil
+}
+
+// fill gives the channels points omit the value their own points
+// either side reach at that time, so each channel moves between the
+// points that name it as if the others weren't there. The settings
+// must be valid and sorted.
+func (s settingPoints) fill() Problems {
+	var problems Problems
+	at := make([]int, len(s))
+	for i, sp := range s {
+		hours, minutes, _ := sp.clock(time.Now())
+		at[i] = hours*3600 + minutes*60
+	}
+	for channel := 0; channel < Channels; channel++ {
+		var keys []int
+		for i, sp := range s {
+			if !hasChannel(sp.omitted, channel) {
+				keys = append(keys, i)
+			}
+		}
+		if len(keys) == 0 {
+			problems = append(problems, fmt.Errorf("channel %s isn't given at any point", ChannelNames[channel]))
+			continue
+		}
+		if len(keys) == len(s) {
+			continue
+		}
+		// Walk round the day from the last key, the one before the first
+		prev, k := keys[len(keys)-1], 0
+		for i := range s {
+			if k < len(keys) && keys[k] == i {
+				prev = i
+				k++
+				continue
+			}
+			next := keys[k%len(keys)]
+			span := (at[next] - at[prev] + secondsPerDay) % secondsPerDay
+			from, to := s[prev].Percents[channel], s[next].Percents[channel]
+			if span == 0 {
+				// Given once, the channel holds all day
+				s[i].Percents[channel] = from
+				continue
+			}
+			f := float64((at[i]-at[prev]+secondsPerDay)%secondsPerDay) / float64(span)
+			if ease := s[prev].ease; ease != "" {
+				f = eases[ease](f)
+			}
+			s[i].Percents[channel] = from + f*(to-from)
+		}
+	}
+	return problems
+}
+
+func hasChannel(channels []int, channel int) bool {
+	for _, c := range channels {
+		if c == channel {
+			return true
+		}
+	}
+	return false
+}
+
+// decodePoints decodes the points of a list whose opening bracket has
+// been read, through its closing bracket. A syntax error returns nil
+// settings, as nothing past it can be read.
+func decodePoints(data []byte, dec *json.Decoder, decode func(*json.Decoder) (settingPoint, error)) (settingPoints, Problems) {
+	settings := settingPoints{}
+	var problems Problems
+	for i := 0; dec.More(); i++ {
+		start := valueStart(data, dec.InputOffset())
+		sp, err := decode(dec)
+		sp.line = lineAt(data, start)
+		if _, ok := err.(*json.SyntaxError); ok {
+			// The rest of the file can't be read past a syntax error
+			return nil, append(problems, positioned(data, dec.InputOffset(), err))
+		}
+		if err != nil {
+			problems = append(problems, fmt.Errorf("line %d: point %d: %s", sp.line, i, describe(err)))
+			continue
+		}
+		settings = append(settings, sp)
+	}
+	if _, err := dec.Token(); err != nil {
+		return nil, append(problems, positioned(data, dec.InputOffset(), err))
+	}
+	return settings, problems
+}
+
+// decodeV2 decodes a version 2 table whose opening brace has been read.
+func decodeV2(data []byte, dec *json.Decoder) (settingPoints, []int, Problems) {
+	settings := settingPoints{}
+	var offsets []int
+	var problems Problems
+	version := 0
+	for dec.More() {
+		t, err := dec.Token()
+		if err != nil {
+			return nil, nil, append(problems, positioned(data, dec.InputOffset(), err))
+		}
+		switch key, _ := t.(string); key {
+		case "version":
+			if err := dec.Decode(&version); err != nil {
+				return nil, nil, append(problems, positioned(data, dec.InputOffset(), err))
+			}
+		case "offsets":
+			start := valueStart(data, dec.InputOffset())
+			var named map[string]string
+			if err := dec.Decode(&named); err != nil {
+				return nil, nil, append(problems, positioned(data, dec.InputOffset(), err))
+			}
+			var err error
+			if offsets, err = parseOffsets(named); err != nil {
+				problems = append(problems, fmt.Errorf("line %d: offsets: %v", lineAt(data, start), err))
+			}
+		case "points":
+			if t, err := dec.Token(); err != nil || t != json.Delim('[') {
+				return nil, nil, append(problems, fmt.Errorf("line %d: points should be a list",
+					lineAt(data, dec.InputOffset())))
+			}
+			var more Problems
+			settings, more = decodePoints(data, dec, decodePointV2)
+			problems = append(problems, more...)
+			if settings == nil {
+				return nil, nil, problems
+			}
+		default:
+			var skip json.RawMessage
+			dec.Decode(&skip)
+			problems = append(problems, fmt.Errorf("line %d: unknown field %q",
+				lineAt(data, dec.InputOffset()), key))
+		}
+	}
+	if _, err := dec.Token(); err != nil {
+		return nil, nil, append(problems, positioned(data, dec.InputOffset(), err))
+	}
+	if version != 2 {
+		problems = append(problems, fmt.Errorf("line %d: a table of named channels needs \"version\": 2",
+			lineAt(data, 0)))
+	}
+	return settings, offsets, problems
+}
+
+// parseOffsets resolves offsets by channel name to seconds by channel
+// number, nil if there are none.
+func parseOffsets(named map[string]string) ([]int, error) {
+	if len(named) == 0 {
+		return nil, nil
+	}
+	offsets := make([]int, Channels)
+	for name, s := range named {
+		channel, err := ble.ChannelNumber(name)
+		if err != nil || channel >= Channels {
+			return nil, fmt.Errorf("unknown channel %q", name)
+		}
+		d, err := time.ParseDuration(s)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %v", name, err)
+		}
+		// Fixtures run whole minutes
+		if d%time.Minute != 0 || d <= -12*time.Hour || d >= 12*time.Hour {
+			return nil, fmt.Errorf("%s: offset %s should be whole minutes under 12h", name, s)
+		}
+		offsets[channel] = int(d / time.Second)
+	}
+	return offsets, nil
+}
+
+// decodePointV2 decodes a version 2 point into a setting point. Each
+// channel may be named once, by name or number, and those left out are
+// filled in later. Accessories are given by their name too.
+func decodePointV2(dec *json.Decoder) (settingPoint, error) {
+	var p pointV2
+	if err := dec.Decode(&p); err != nil {
+		return settingPoint{}, err
+	}
+	sp := settingPoint{At: p.At, Zone: p.Zone, ease: p.Ease, tags: p.Tags}
+	if _, ok := eases[p.Ease]; p.Ease != "" && !ok {
+		return sp, fmt.Errorf("unknown ease %q, expected linear, step, ease-in, ease-out or smooth", p.Ease)
+	}
+	percents := make([]float64, Channels)
+	named := make(map[int]string)
+	for name, v := range p.Channels {
+		channel, err := ble.ChannelNumber(name)
+		if err != nil && accessoryKnown(name) {
+			if v < 0 || v > 100 {
+				return sp, fmt.Errorf("accessory %s: %.1f is out of range (0-100)", name, v)
+			}
+			if sp.accessories == nil {
+				sp.accessories = make(map[string]float64)
+			}
+			sp.accessories[name] = v
+			continue
+		}
+		if err != nil || channel >= Channels {
+			return sp, fmt.Errorf("unknown channel %q", name)
+		}
+		if other, ok := named[channel]; ok {
+			return sp, fmt.Errorf("channel %q given twice, as %q too", name, other)
+		}
+		named[channel] = name
+		percents[channel] = v
+	}
+	for channel := 0; channel < Channels; channel++ {
+		if _, ok := named[channel]; !ok {
+			sp.omitted = append(sp.omitted, channel)
+		}
+	}
+	sp.Percents = percents
+	return sp, nil
+}
+
+// describe rewords decoding errors in terms of the table.
+func describe(err error) string {
+	if te, ok := err.(*json.UnmarshalTypeError); ok {
+		return fmt.Sprintf("%s should be a %s, not a %s", te.Field, te.Type, te.Value)
+	}
+	return strings.TrimPrefix(err.Error(), "json: ")
+}
+
+func positioned(data []byte, offset int64, err error) error {
+	if se, ok := err.(*json.SyntaxError); ok {
+		offset = se.Offset
+	}
+	return fmt.Errorf("line %d: %s", lineAt(data, offset), strings.TrimPrefix(err.Error(), "json: "))
+}
+
+// valueStart skips the separators before the next value, so its line is
+// reported rather than the end of the last one.
+func valueStart(data []byte, offset int64) int64 {
+	for offset < int64(len(data)) && strings.IndexByte(" \t\r\n,", data[offset]) >= 0 {
+		offset++
+	}
+	return offset
+}
+
+func lineAt(data []byte, offset int64) int {
+	if offset > int64(len(data)) {
+		offset = int64(len(data))
+	}
+	return bytes.Count(data[:offset], []byte("\n")) + 1
+}
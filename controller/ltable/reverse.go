@@ -0,0 +1,55 @@
+package ltable
+
+// Reversed returns the schedule shifted by twelve hours, so a table
+// written for the display runs overnight instead. This is the reverse
+// photoperiod of a refugium, which is lit while the display is dark to
+// keep the pH swing down.
+func (sc *Schedule) Reversed() *Schedule {
+	n := len(sc.at)
+	// Lagging on the way up and leading on the way down is the same
+	// backwards, so offsets are kept
+	r := &Schedule{at: make([]int, 0, n), percents: make([][]float64, 0, n), offsets: sc.offsets}
+	if sc.zoned != nil {
+		r.zoned = &zoned{points: sc.zoned.points, reversed: !sc.zoned.reversed}
+	}
+	// Points in the afternoon wrap to the early morning, so start from
+	// the first of those to keep the result sorted
+	first := 0
+	for first < n && sc.at[first] < secondsPerDay/2 {
+		first++
+	}
+	for name, a := range sc.accessories {
+		if r.accessories == nil {
+			r.accessories = make(map[string]*Schedule)
+		}
+		r.accessories[name] = a.Reversed()
+	}
+	for i := 0; i < n; i++ {
+		j := (first + i) % n
+		r.at = append(r.at, (sc.at[j]+secondsPerDay/2)%secondsPerDay)
+		r.percents = append(r.percents, sc.percents[j])
+		if sc.meta != nil {
+			r.meta = append(r.meta, sc.meta[j])
+		}
+	}
+	return r
+}
+
+// Reverse makes the driver run its table, and any applied later, with
+// a reverse photoperiod.
+func (ld *LightDriver) Reverse() {
+	ld.mu.Lock()
+	if !ld.reverse {
+		ld.reverse = true
+		ld.schedule = ld.schedule.Reversed()
+	}
+	ld.mu.Unlock()
+	ld.updateChannels()
+}
+
+// Reversed reports whether the driver runs a reverse photoperiod.
+func (ld *LightDriver) Reversed() bool {
+	ld.mu.Lock()
+	defer ld.mu.Unlock()
+	return ld.reverse
+}
@@ -0,0 +1,47 @@
+package ltable
+
+import (
+	"testing"
+	"time"
+)
+
+func TestReversed(t *testing.T) {
+	sc, err := ParseSchedule(chartTable)
+	if err != nil {
+		t.Fatal(err)
+	}
+	r := sc.Reversed()
+	want := []int{0, 6 * 3600, 21 * 3600}
+	for i, at := range r.at {
+		if at != want[i] {
+			t.Fatalf("Expected points at %v, got %v", want, r.at)
+		}
+	}
+	midnight, _ := ParseTime("00:00", time.Now())
+	noon, _ := ParseTime("12:00", time.Now())
+	if r.Percent(midnight, 7) != 100 || r.Percent(noon, 7) != 0 {
+		t.Errorf("Expected UV on at midnight and off at noon, got %.0f and %.0f",
+			r.Percent(midnight, 7), r.Percent(noon, 7))
+	}
+	if p := r.Program(); p.Points[0].Minute != 0 || p.Points[0].Percents[7] != 100 {
+		t.Errorf("Expected the reversed table uploaded, got %v", p.Points)
+	}
+}
+
+func TestReverseDriver(t *testing.T) {
+	f := &fakeChannel{}
+	ld, _ := newTestDriver(t, f)
+	ld.Reverse()
+	if !ld.Reversed() {
+		t.Error("Expected the driver to be reversed")
+	}
+	if err := ld.Apply(chartTable); err != nil {
+		t.Fatal(err)
+	}
+	noon, _ := ParseTime("12:00", time.Now())
+	midnight, _ := ParseTime("00:00", time.Now())
+	if percentsAt(t, ld, noon)[7] != 0 || percentsAt(t, ld, midnight)[7] != 100 {
+		t.Errorf("Expected an applied table to be reversed too, got UV at %.0f%% at noon and %.0f%% at midnight",
+			percentsAt(t, ld, noon)[7], percentsAt(t, ld, midnight)[7])
+	}
+}
@@ -0,0 +1,255 @@
+package ltable
+
+import (
+	"math"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/theatrus/ledbrick/controller/ble"
+)
+
+const secondsPerDay = 24 * 60 * 60
+
+// Schedule is a table prepared for evaluation, with the times parsed
+// once into seconds after midnight rather than on every lookup.
+type Schedule struct {
+	at       []int
+	percents [][]float64
+	// meta are the points' easing and tags, nil if no point has any
+	meta []pointMeta
+	// offsets are how many seconds each channel lags the table on the
+	// way up and leads it on the way down, nil if none do
+	offsets []int
+	// accessories are a schedule of one channel for each accessory the
+	// table gives, from the points giving it, nil if it gives none
+	accessories map[string]*Schedule
+	// zoned has the points if any is in another time zone, so the table
+	// is prepared again for each day it runs, nil if none are
+	zoned *zoned
+}
+
+// zoned are the points of a table mixing time zones, with the table
+// last prepared from them for a day.
+type zoned struct {
+	points settingPoints
+	// reversed prepares the reverse photoperiod of the points
+	reversed bool
+
+	mu  sync.Mutex
+	day string
+	sc  *Schedule
+}
+
+// pointMeta is what a version 2 table adds to a point.
+type pointMeta struct {
+	// ease is how the channels move from the point to the next, linear
+	// if empty
+	ease string
+	tags []string
+	// omitted are the channels the table left to be filled in from
+	// their own points either side
+	omitted []int
+}
+
+// eases shape the move from one point to the next: a straight line,
+// holding until the next point, or a curve starting slow, ending slow
+// or both.
+var eases = map[string]func(f float64) float64{
+	"linear":   func(f float64) float64 { return f },
+	"step":     func(f float64) float64 { return 0 },
+	"ease-in":  func(f float64) float64 { return f * f },
+	"ease-out": func(f float64) float64 { return 1 - (1-f)*(1-f) },
+	"smooth":   func(f float64) float64 { return 0.5 - 0.5*math.Cos(f*math.Pi) },
+}
+
+// ParseSchedule parses and validates a lighting table.
+func ParseSchedule(data []byte) (*Schedule, error) {
+	sc, problems := parseSchedule(data)
+	if len(problems) > 0 {
+		return nil, problems
+	}
+	return sc, nil
+}
+
+// parseSchedule parses and validates a lighting table, returning every
+// problem found.
+func parseSchedule(data []byte) (*Schedule, Problems) {
+	settings, offsets, problems := parseTable(data)
+	if len(problems) > 0 {
+		return nil, problems
+	}
+	sc := newSchedule(settings)
+	sc.offsets = offsets
+	return sc, nil
+}
+
+// newSchedule prepares sorted, valid setting points.
+func newSchedule(s settingPoints) *Schedule {
+	sc := newScheduleOn(s, time.Now())
+	for _, sp := range s {
+		if sp.Zone != "" {
+			sc.zoned = &zoned{points: s}
+			break
+		}
+	}
+	return sc
+}
+
+// newScheduleOn prepares sorted, valid setting points with their times
+// converted as of a day.
+func newScheduleOn(s settingPoints, day time.Time) *Schedule {
+	sc := &Schedule{}
+	accessories := make(map[string]settingPoints)
+	for i, sp := range s {
+		for name, v := range sp.accessories {
+			accessories[name] = append(accessories[name],
+				settingPoint{At: sp.At, Zone: sp.Zone, Percents: []float64{v}, ease: sp.ease})
+		}
+		hours, minutes, _ := sp.clock(day)
+		sc.at = append(sc.at, hours*3600+minutes*60)
+		sc.percents = append(sc.percents, sp.Percents)
+		if (sp.ease != "" || len(sp.tags) > 0 || len(sp.omitted) > 0) && sc.meta == nil {
+			sc.meta = make([]pointMeta, len(s))
+		}
+		if sc.meta != nil {
+			sc.meta[i] = pointMeta{ease: sp.ease, tags: sp.tags, omitted: sp.omitted}
+		}
+	}
+	for name, points := range accessories {
+		if sc.accessories == nil {
+			sc.accessories = make(map[string]*Schedule)
+		}
+		sc.accessories[name] = newScheduleOn(points, day)
+	}
+	return sc
+}
+
+// on returns the table as it runs on a day, the table itself unless
+// it mixes time zones.
+func (sc *Schedule) on(t time.Time) *Schedule {
+	if sc.zoned == nil {
+		return sc
+	}
+	initLtables()
+	z := sc.zoned
+	day := t.In(timeLocation).Format("2006-01-02")
+	z.mu.Lock()
+	defer z.mu.Unlock()
+	if z.day != day {
+		points := append(settingPoints(nil), z.points...)
+		at := func(i int) int {
+			hours, minutes, _ := points[i].clock(t)
+			return hours*60 + minutes
+		}
+		sort.SliceStable(points, func(i, j int) bool { return at(i) < at(j) })
+		daily := newScheduleOn(points, t)
+		daily.offsets = sc.offsets
+		if z.reversed {
+			daily = daily.Reversed()
+		}
+		z.day, z.sc = day, daily
+	}
+	return z.sc
+}
+
+// Percent interpolates a channel's setting at a time, wrapping from
+// the last point of the day to the first.
+func (sc *Schedule) Percent(t time.Time, channel int) float64 {
+	initLtables()
+
+	// All the math is done in "local" time which may not be system
+	// local time, so adjust everything to our location
+	lt := t.In(timeLocation)
+	return sc.on(lt).percentAt(lt.Hour()*3600+lt.Minute()*60+lt.Second(), channel)
+}
+
+// percentAt returns a channel's percent at a second of the day. A
+// channel with an offset takes the lesser of the table either side of
+// the time, so it comes up later and goes down sooner, or the greater
+// for a negative offset.
+func (sc *Schedule) percentAt(now, channel int) float64 {
+	if sc.offsets == nil || sc.offsets[channel] == 0 {
+		return sc.interpolate(now, channel)
+	}
+	offset := sc.offsets[channel]
+	early := sc.interpolate((now+offset+secondsPerDay)%secondsPerDay, channel)
+	late := sc.interpolate((now-offset+secondsPerDay)%secondsPerDay, channel)
+	if offset > 0 {
+		return math.Min(early, late)
+	}
+	return math.Max(early, late)
+}
+
+// interpolate returns a channel's percent at a second of the day
+// between the points either side.
+func (sc *Schedule) interpolate(now, channel int) float64 {
+	after := sort.SearchInts(sc.at, now)
+	if after < len(sc.at) && sc.at[after] == now {
+		return sc.percents[after][channel]
+	}
+	before := after - 1
+	if before < 0 {
+		before = len(sc.at) - 1
+	}
+	if after == len(sc.at) {
+		after = 0
+	}
+
+	valueBefore := sc.percents[before][channel]
+	valueAfter := sc.percents[after][channel]
+	// Don't interpolate
+	if valueBefore == valueAfter {
+		return valueAfter
+	}
+
+	span := sc.at[after] - sc.at[before]
+	if span <= 0 {
+		span += secondsPerDay
+	}
+	elapsed := now - sc.at[before]
+	if elapsed < 0 {
+		elapsed += secondsPerDay
+	}
+	f := float64(elapsed) / float64(span)
+	if sc.meta != nil && sc.meta[before].ease != "" {
+		f = eases[sc.meta[before].ease](f)
+	}
+	return valueBefore + f*(valueAfter-valueBefore)
+}
+
+// Program returns the table as uploaded to fixtures which can run it
+// on their own.
+func (sc *Schedule) Program() ble.Program {
+	initLtables()
+	prog := ble.Program{Location: timeLocation}
+	if sc.offsets == nil {
+		for i, at := range sc.at {
+			prog.Points = append(prog.Points, ble.ProgramPoint{Minute: at / 60, Percents: sc.percents[i]})
+		}
+		return prog
+	}
+	// Fixtures know nothing of offsets, so each point is also given at
+	// every offset either side, where the channels' corners now fall
+	minutes := make(map[int]bool)
+	for _, at := range sc.at {
+		minutes[at/60] = true
+		for _, offset := range sc.offsets {
+			minutes[(at+offset+secondsPerDay)%secondsPerDay/60] = true
+			minutes[(at-offset+secondsPerDay)%secondsPerDay/60] = true
+		}
+	}
+	var sorted []int
+	for minute := range minutes {
+		sorted = append(sorted, minute)
+	}
+	sort.Ints(sorted)
+	for _, minute := range sorted {
+		percents := make([]float64, len(sc.percents[0]))
+		for channel := range percents {
+			percents[channel] = sc.percentAt(minute*60, channel)
+		}
+		prog.Points = append(prog.Points, ble.ProgramPoint{Minute: minute, Percents: percents})
+	}
+	return prog
+}
@@ -0,0 +1,86 @@
+package ltable
+
+import (
+	"fmt"
+	"io"
+	"time"
+)
+
+// simClock runs scale times faster than real time from start.
+type simClock struct {
+	start time.Time
+	real  time.Time
+	scale float64
+}
+
+func newSimClock(start time.Time, scale float64) *simClock {
+	return &simClock{start: start, real: time.Now(), scale: scale}
+}
+
+func (c *simClock) Now() time.Time {
+	elapsed := float64(time.Since(c.real)) * c.scale
+	return c.start.Add(time.Duration(elapsed))
+}
+
+// sleepUntil waits for the simulated time t.
+func (c *simClock) sleepUntil(t time.Time) {
+	wait := time.Duration(float64(t.Sub(c.Now())) / c.scale)
+	if wait > 0 {
+		time.Sleep(wait)
+	}
+}
+
+// Simulate runs a lighting table through a whole day from midnight,
+// with time sped up by scale, writing every channel's output each
+// simulated step.
+func Simulate(data []byte, scale float64, step time.Duration, w io.Writer) error {
+	header := false
+	return SimulateFunc(data, scale, step, func(at time.Time, percents []float64) error {
+		if !header {
+			fmt.Fprintf(w, "time ")
+			for _, name := range ChannelNames {
+				fmt.Fprintf(w, " %9.9s", name)
+			}
+			fmt.Fprintln(w)
+			header = true
+		}
+		fmt.Fprintf(w, "%s", at.Format("15:04"))
+		for _, v := range percents {
+			fmt.Fprintf(w, " %8.1f%%", v)
+		}
+		_, err := fmt.Fprintln(w)
+		return err
+	})
+}
+
+// SimulateFunc is Simulate calling f with each step's output instead of
+// printing it, stopping at the first error f returns.
+func SimulateFunc(data []byte, scale float64, step time.Duration, f func(at time.Time, percents []float64) error) error {
+	if scale <= 0 {
+		return fmt.Errorf("time scale must be above 0")
+	}
+	if step <= 0 {
+		return fmt.Errorf("simulation step must be above 0")
+	}
+	sc, err := ParseSchedule(data)
+	if err != nil {
+		return err
+	}
+	initLtables()
+
+	now := time.Now().In(timeLocation)
+	start := time.Date(now.Year(), now.Month(), now.Day(), 0, 0, 0, 0, timeLocation)
+	clock := newSimClock(start, scale)
+
+	for at := start; at.Before(start.Add(24 * time.Hour)); at = at.Add(step) {
+		clock.sleepUntil(at)
+		percents := make([]float64, Channels)
+		for channel := range percents {
+			percents[channel] = sc.Percent(at, channel)
+		}
+		if err := f(at, percents); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,52 @@
+package ltable
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestSimulate(t *testing.T) {
+	var buf bytes.Buffer
+	if err := Simulate(chartTable, 1e9, time.Hour, &buf); err != nil {
+		t.Fatal(err)
+	}
+	lines := strings.Split(strings.TrimSpace(buf.String()), "\n")
+	if len(lines) != 25 {
+		t.Fatalf("Expected a header and 24 hours, got %d lines", len(lines))
+	}
+	if !strings.HasPrefix(lines[13], "12:00") || !strings.HasSuffix(lines[13], "100.0%") {
+		t.Errorf("Expected UV at 100%% at noon, got %q", lines[13])
+	}
+
+	if err := Simulate(chartTable, 0, time.Hour, &buf); err == nil {
+		t.Error("Expected a zero time scale to fail")
+	}
+}
+
+func TestSimClock(t *testing.T) {
+	start := time.Date(2016, 1, 1, 0, 0, 0, 0, time.UTC)
+	c := newSimClock(start, 3600)
+	time.Sleep(10 * time.Millisecond)
+	if elapsed := c.Now().Sub(start); elapsed < 30*time.Second {
+		t.Errorf("Expected at least 30s of simulated time, got %s", elapsed)
+	}
+}
+
+func TestSimulateFunc(t *testing.T) {
+	steps := 0
+	err := SimulateFunc(chartTable, 1e9, 6*time.Hour, func(at time.Time, percents []float64) error {
+		if len(percents) != Channels {
+			t.Errorf("Expected %d percents, got %d", Channels, len(percents))
+		}
+		steps++
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if steps != 4 {
+		t.Errorf("Expected 4 steps, got %d", steps)
+	}
+}
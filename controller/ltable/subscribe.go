@@ -0,0 +1,485 @@
+package ltable
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/theatrus/ledbrick/controller/alert"
+	"github.com/theatrus/ledbrick/controller/atomicfile"
+	"github.com/theatrus/ledbrick/controller/plugs"
+	"github.com/theatrus/ledbrick/controller/wan"
+)
+
+// tagPattern finds the tags in an event's summary, description or
+// categories, such as ledbrick:scene=photo or ledbrick:profile=vacation.
+var tagPattern = regexp.MustCompile(`ledbrick:(scene|profile)=([\w-]+)`)
+
+// calendarEvent is a tagged event from a subscribed calendar.
+type calendarEvent struct {
+	start, end time.Time
+	// kind is scene or profile, and name which one
+	kind, name string
+}
+
+// How far ahead recurring events are expanded
+const icalHorizon = 366 * 24 * time.Hour
+
+// parseICal reads the tagged events from an iCalendar feed, leaving out
+// untagged and cancelled events. Daily and weekly recurring events are
+// expanded from now until icalHorizon, without the dates they exclude
+// or which are moved; other recurrences only count their first
+// occurrence.
+func parseICal(data []byte, now time.Time) ([]calendarEvent, error) {
+	initLtables()
+	// Long lines are folded onto lines starting with a space or tab
+	data = bytes.Replace(data, []byte("\r\n"), []byte("\n"), -1)
+	data = bytes.Replace(data, []byte("\n "), nil, -1)
+	data = bytes.Replace(data, []byte("\n\t"), nil, -1)
+
+	var vevents []*vevent
+	var v *vevent
+	seen := false
+	scanner := bufio.NewScanner(bytes.NewReader(data))
+	scanner.Buffer(nil, 1<<20)
+	for n := 1; scanner.Scan(); n++ {
+		line := strings.TrimSpace(scanner.Text())
+		colon := strings.Index(line, ":")
+		if colon < 0 {
+			continue
+		}
+		name, value := line[:colon], line[colon+1:]
+		param := ""
+		if semi := strings.Index(name, ";"); semi >= 0 {
+			name, param = name[:semi], name[semi+1:]
+		}
+		name = strings.ToUpper(name)
+		switch {
+		case name == "BEGIN" && value == "VCALENDAR":
+			seen = true
+		case name == "BEGIN" && value == "VEVENT":
+			v = &vevent{props: make(map[string]string), params: make(map[string]string)}
+		case name == "END" && value == "VEVENT" && v != nil:
+			v.line = n
+			vevents = append(vevents, v)
+			v = nil
+		case name == "EXDATE" && v != nil:
+			for _, d := range strings.Split(value, ",") {
+				t, err := parseICalTime(d, param)
+				if err != nil {
+					return nil, fmt.Errorf("line %d: %v", n, err)
+				}
+				v.exdates = append(v.exdates, t)
+			}
+		case v != nil:
+			v.props[name], v.params[name] = value, param
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	if !seen {
+		return nil, fmt.Errorf("not an iCalendar feed")
+	}
+
+	// Occurrences moved or cancelled on their own are left out of the
+	// events they recur from
+	moved := make(map[string]bool)
+	for _, v := range vevents {
+		if id := v.props["RECURRENCE-ID"]; id != "" {
+			t, err := parseICalTime(id, v.params["RECURRENCE-ID"])
+			if err != nil {
+				return nil, fmt.Errorf("line %d: %v", v.line, err)
+			}
+			moved[v.props["UID"]+" "+t.UTC().Format(time.RFC3339)] = true
+		}
+	}
+
+	var events []calendarEvent
+	for _, v := range vevents {
+		if strings.EqualFold(v.props["STATUS"], "CANCELLED") {
+			continue
+		}
+		text := v.props["SUMMARY"] + " " + v.props["DESCRIPTION"] + " " + v.props["CATEGORIES"]
+		tags := tagPattern.FindAllStringSubmatch(text, -1)
+		if len(tags) == 0 {
+			continue
+		}
+		e, err := eventFrom(v.props, v.params)
+		if err != nil {
+			return nil, fmt.Errorf("line %d: %v", v.line, err)
+		}
+		occurrences := []calendarEvent{e}
+		if rule := v.props["RRULE"]; rule != "" && v.props["RECURRENCE-ID"] == "" {
+			allDay := len(v.props["DTSTART"]) == len("20060102")
+			if occurrences, err = recur(e, rule, allDay, now); err != nil {
+				log.Printf("Calendar event %q at line %d only counts its first occurrence: %v",
+					v.props["SUMMARY"], v.line, err)
+				occurrences = []calendarEvent{e}
+			}
+		}
+		for _, o := range occurrences {
+			if moved[v.props["UID"]+" "+o.start.UTC().Format(time.RFC3339)] && v.props["RECURRENCE-ID"] == "" ||
+				excluded(o.start, v.exdates) {
+				continue
+			}
+			for _, tag := range tags {
+				o.kind, o.name = tag[1], tag[2]
+				events = append(events, o)
+			}
+		}
+	}
+	sort.SliceStable(events, func(i, j int) bool { return events[i].start.Before(events[j].start) })
+	return events, nil
+}
+
+// vevent is an event as read from a feed.
+type vevent struct {
+	props, params map[string]string
+	exdates       []time.Time
+	// line is where the event ends, for error messages
+	line int
+}
+
+func excluded(t time.Time, exdates []time.Time) bool {
+	for _, x := range exdates {
+		if x.Equal(t) {
+			return true
+		}
+	}
+	return false
+}
+
+// icalDays are the weekdays as BYDAY gives them.
+var icalDays = map[string]time.Weekday{
+	"SU": time.Sunday, "MO": time.Monday, "TU": time.Tuesday, "WE": time.Wednesday,
+	"TH": time.Thursday, "FR": time.Friday, "SA": time.Saturday,
+}
+
+// recur expands a daily or weekly RRULE, with its INTERVAL, COUNT,
+// UNTIL and, for weekly rules, BYDAY, returning the occurrences which
+// haven't ended by now, up to icalHorizon ahead. Anything else is an
+// error.
+func recur(e calendarEvent, rule string, allDay bool, now time.Time) ([]calendarEvent, error) {
+	freq, every, count := "", 1, 0
+	var until time.Time
+	var days []int
+	for _, part := range strings.Split(rule, ";") {
+		kv := strings.SplitN(part, "=", 2)
+		if len(kv) != 2 {
+			return nil, fmt.Errorf("bad rule part %q", part)
+		}
+		var err error
+		switch k, v := strings.ToUpper(kv[0]), strings.ToUpper(kv[1]); k {
+		case "FREQ":
+			freq = v
+		case "INTERVAL":
+			if every, err = strconv.Atoi(v); err != nil || every < 1 {
+				return nil, fmt.Errorf("bad interval %q", v)
+			}
+		case "COUNT":
+			if count, err = strconv.Atoi(v); err != nil || count < 1 {
+				return nil, fmt.Errorf("bad count %q", v)
+			}
+		case "UNTIL":
+			if until, err = parseICalTime(v, ""); err != nil {
+				return nil, err
+			}
+		case "BYDAY":
+			for _, d := range strings.Split(v, ",") {
+				wd, ok := icalDays[d]
+				if !ok {
+					return nil, fmt.Errorf("BYDAY %s isn't supported", d)
+				}
+				// Days from the Monday starting the week
+				days = append(days, (int(wd)+6)%7)
+			}
+		case "WKST":
+		default:
+			return nil, fmt.Errorf("%s isn't supported", k)
+		}
+	}
+	switch {
+	case freq == "DAILY" && days == nil:
+	case freq == "WEEKLY":
+		if days == nil {
+			days = []int{(int(e.start.Weekday()) + 6) % 7}
+		}
+		sort.Ints(days)
+	default:
+		return nil, fmt.Errorf("FREQ=%s isn't supported", freq)
+	}
+
+	length := e.end.Sub(e.start)
+	occurrence := func(start time.Time) calendarEvent {
+		o := calendarEvent{start: start, end: start.Add(length)}
+		if allDay {
+			// Days are whole days, whatever daylight saving does
+			o.end = start.AddDate(0, 0, int((length+12*time.Hour)/(24*time.Hour)))
+		}
+		return o
+	}
+	horizon := now.Add(icalHorizon)
+	var occurrences []calendarEvent
+	n := 0
+	add := func(start time.Time) bool {
+		if start.Before(e.start) {
+			return true
+		}
+		if !until.IsZero() && start.After(until) || count > 0 && n >= count || start.After(horizon) {
+			return false
+		}
+		n++
+		if o := occurrence(start); o.end.After(now) {
+			occurrences = append(occurrences, o)
+		}
+		return true
+	}
+	if freq == "DAILY" {
+		for i := 0; ; i++ {
+			if !add(e.start.AddDate(0, 0, i*every)) {
+				return occurrences, nil
+			}
+		}
+	}
+	monday := e.start.AddDate(0, 0, -((int(e.start.Weekday()) + 6) % 7))
+	for week := 0; ; week += every {
+		for _, d := range days {
+			if !add(monday.AddDate(0, 0, 7*week+d)) {
+				return occurrences, nil
+			}
+		}
+	}
+}
+
+// eventFrom reads an event's start and end. An event without an end
+// lasts the day if it is all day, or else no time at all.
+func eventFrom(props, params map[string]string) (calendarEvent, error) {
+	var e calendarEvent
+	var err error
+	if e.start, err = parseICalTime(props["DTSTART"], params["DTSTART"]); err != nil {
+		return e, err
+	}
+	switch {
+	case props["DTEND"] != "":
+		if e.end, err = parseICalTime(props["DTEND"], params["DTEND"]); err != nil {
+			return e, err
+		}
+	case len(props["DTSTART"]) == len("20060102"):
+		e.end = e.start.AddDate(0, 0, 1)
+	default:
+		e.end = e.start
+	}
+	return e, nil
+}
+
+// parseICalTime reads a date or time, in UTC, the zone given by a TZID
+// parameter, or the local zone.
+func parseICalTime(value, param string) (time.Time, error) {
+	loc := timeLocation
+	for _, p := range strings.Split(param, ";") {
+		if strings.HasPrefix(strings.ToUpper(p), "TZID=") {
+			if l, err := time.LoadLocation(strings.Trim(p[len("TZID="):], `"`)); err == nil {
+				loc = l
+			}
+		}
+	}
+	for _, layout := range []string{"20060102T150405Z", "20060102T150405", "20060102"} {
+		if len(value) != len(layout) {
+			continue
+		}
+		if strings.HasSuffix(layout, "Z") {
+			return time.Parse(layout, value)
+		}
+		return time.ParseInLocation(layout, value, loc)
+	}
+	return time.Time{}, fmt.Errorf("bad date %q", value)
+}
+
+// Subscription follows an external iCalendar feed, such as a shared
+// Google calendar or a CalDAV calendar's export, so tagged events run
+// a scene or a profile while they last: ledbrick:scene=photo for a frag
+// swap, or ledbrick:profile=vacation. A scene holds the channels at its
+// percents, if it has any, and switches the plugs configured for it.
+// Profiles need a calendar being followed. The feed is polled, and kept
+// in a cache file, so events still run when it can't be reached, even
+// after a restart.
+type Subscription struct {
+	ld     *LightDriver
+	url    string
+	cache  string
+	scenes map[string][]float64
+
+	mu     sync.Mutex
+	events []calendarEvent
+	// active are the scenes running, by name, and profile the profile
+	// run instead of the day's, "" if none
+	active  map[string]calendarEvent
+	profile string
+	failing bool
+}
+
+// Subscribe follows the feed at url, polling it every poll. Basic auth
+// credentials may be given in the url. scenes are percents to hold the
+// channels at for each scene.
+func (ld *LightDriver) Subscribe(url, cache string, poll time.Duration, scenes map[string][]float64) *Subscription {
+	s := &Subscription{ld: ld, url: url, cache: cache, scenes: scenes, active: make(map[string]calendarEvent)}
+	if data, err := ioutil.ReadFile(cache); err == nil {
+		if events, err := parseICal(data, ld.clock.Now()); err == nil {
+			s.events = events
+		}
+	}
+	s.poll()
+	s.check()
+	ticker := ld.clock.NewTicker(time.Minute)
+	done := ld.stopped()
+	ld.running.Add(1)
+	go func() {
+		defer ld.running.Done()
+		defer ticker.Stop()
+		last := ld.clock.Now()
+		for {
+			select {
+			case now := <-ticker.C():
+				if now.Sub(last) >= poll {
+					s.poll()
+					last = now
+				}
+				s.check()
+			case <-done:
+				return
+			}
+		}
+	}()
+	return s
+}
+
+// poll fetches the feed, keeping the last events fetched if it fails.
+func (s *Subscription) poll() {
+	data, err := s.fetch()
+	var events []calendarEvent
+	if err == nil {
+		events, err = parseICal(data, s.ld.clock.Now())
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if err != nil {
+		if !s.failing {
+			alert.Raise(alert.Warning, "calendar", "calendar.subscription",
+				"calendar subscription failed, running the %d events cached: %v", len(s.events), err)
+		}
+		s.failing = true
+		wan.Report("calendar.ical", err, fmt.Sprintf("running the %d events cached", len(s.events)))
+		return
+	}
+	s.failing = false
+	wan.Report("calendar.ical", nil, "")
+	s.events = events
+	if err := atomicfile.WriteFile(s.cache, data, 0644); err != nil {
+		log.Printf("Calendar subscription not cached: %v", err)
+	}
+}
+
+func (s *Subscription) fetch() ([]byte, error) {
+	client := http.Client{Timeout: 30 * time.Second}
+	resp, err := client.Get(s.url)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("%s", resp.Status)
+	}
+	return ioutil.ReadAll(resp.Body)
+}
+
+// check starts the scenes which have begun and ends those which are
+// over, or have gone from the calendar. Of the profile events running,
+// the one which began first wins.
+func (s *Subscription) check() {
+	now := s.ld.clock.Now()
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	running := make(map[string]calendarEvent)
+	profile := ""
+	for _, e := range s.events {
+		if now.Before(e.start) || !now.Before(e.end) {
+			continue
+		}
+		if _, ok := running[e.name]; e.kind == "scene" && !ok {
+			running[e.name] = e
+		}
+		if e.kind == "profile" && profile == "" {
+			profile = e.name
+		}
+	}
+	for name := range s.active {
+		if _, ok := running[name]; !ok {
+			s.endScene(name)
+			delete(s.active, name)
+		}
+	}
+	for name, e := range running {
+		if _, ok := s.active[name]; !ok {
+			s.startScene(e, now)
+			s.active[name] = e
+		}
+	}
+	if profile != s.profile {
+		s.runProfile(profile)
+		s.profile = profile
+	}
+}
+
+// startScene holds a scene's percents until its event ends and switches
+// its plugs. The caller must hold the subscription lock.
+func (s *Subscription) startScene(e calendarEvent, now time.Time) {
+	log.Printf("Calendar scene %s until %s", e.name, e.end.Format(time.RFC3339))
+	if percents, ok := s.scenes[e.name]; ok {
+		if err := s.ld.Hold(percents, e.end.Sub(now)); err != nil {
+			log.Printf("Calendar scene %s not held: %v", e.name, err)
+		}
+	}
+	plugs.Activate(e.name)
+}
+
+// endScene puts back what startScene changed. The caller must hold the
+// subscription lock.
+func (s *Subscription) endScene(name string) {
+	log.Printf("Calendar scene %s over", name)
+	if _, ok := s.scenes[name]; ok {
+		s.ld.ClearHold()
+	}
+	plugs.Deactivate(name)
+}
+
+// runProfile runs a profile instead of the day's, or goes back to the
+// day's for "". The caller must hold the subscription lock.
+func (s *Subscription) runProfile(profile string) {
+	s.ld.mu.Lock()
+	f := s.ld.follower
+	s.ld.mu.Unlock()
+	switch {
+	case f == nil:
+		if profile != "" {
+			log.Printf("Calendar profile %s needs -calendar to be set", profile)
+		}
+	case profile == "":
+		log.Printf("Calendar profile event over")
+		f.ClearOverride()
+	default:
+		log.Printf("Calendar profile event running %s", profile)
+		if err := f.Override(profile); err != nil {
+			log.Printf("Calendar profile %s not run: %v", profile, err)
+		}
+	}
+}
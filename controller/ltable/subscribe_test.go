@@ -0,0 +1,138 @@
+package ltable
+
+import (
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/theatrus/ledbrick/controller/wan"
+)
+
+const testFeed = "BEGIN:VCALENDAR\r\nVERSION:2.0\r\n" +
+	"BEGIN:VEVENT\r\nDTSTART:20160101T120000Z\r\nDTEND:20160101T130000Z\r\nSUMMARY:Frag swap ledbrick:scene=photo\r\nEND:VEVENT\r\n" +
+	"BEGIN:VEVENT\r\nDTSTART;VALUE=DATE:20160102\r\nSUMMARY:Away\r\nDESCRIPTION:Gone for the day ledbrick:profile=\r\n vacation\r\nEND:VEVENT\r\n" +
+	"BEGIN:VEVENT\r\nDTSTART;TZID=America/New_York:20160103T090000\r\nDTEND;TZID=America/New_York:20160103T100000\r\nSUMMARY:Dentist\r\nEND:VEVENT\r\n" +
+	"END:VCALENDAR\r\n"
+
+func TestParseICal(t *testing.T) {
+	events, err := parseICal([]byte(testFeed), time.Date(2016, 1, 1, 0, 0, 0, 0, time.UTC))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(events) != 2 {
+		t.Fatalf("Expected the 2 tagged events, got %v", events)
+	}
+	if e := events[0]; e.kind != "scene" || e.name != "photo" || e.end.Sub(e.start) != time.Hour {
+		t.Errorf("Expected an hour long photo scene, got %v", e)
+	}
+	if e := events[1]; e.kind != "profile" || e.name != "vacation" || e.end.Sub(e.start) != 24*time.Hour {
+		t.Errorf("Expected a day long vacation profile from a folded line, got %v", e)
+	}
+	if _, err := parseICal([]byte("<html></html>"), time.Now()); err == nil {
+		t.Errorf("Expected a page which isn't a feed to fail")
+	}
+}
+
+const recurringFeed = "BEGIN:VCALENDAR\r\nVERSION:2.0\r\n" +
+	// Feeding every day at 18:00 but the 6th, and the 4th moved to 19:00
+	"BEGIN:VEVENT\r\nUID:feed\r\nDTSTART:20160101T180000Z\r\nDTEND:20160101T181000Z\r\n" +
+	"RRULE:FREQ=DAILY;COUNT=10\r\nEXDATE:20160106T180000Z\r\nSUMMARY:ledbrick:scene=feed\r\nEND:VEVENT\r\n" +
+	"BEGIN:VEVENT\r\nUID:feed\r\nRECURRENCE-ID:20160104T180000Z\r\nDTSTART:20160104T190000Z\r\n" +
+	"DTEND:20160104T191000Z\r\nSUMMARY:ledbrick:scene=feed\r\nEND:VEVENT\r\n" +
+	// Photos every other Tuesday and Thursday until the end of the month
+	"BEGIN:VEVENT\r\nUID:photo\r\nDTSTART:20160105T200000Z\r\nDTEND:20160105T210000Z\r\n" +
+	"RRULE:FREQ=WEEKLY;INTERVAL=2;BYDAY=TU,TH;UNTIL=20160131T000000Z\r\nSUMMARY:ledbrick:scene=photo\r\nEND:VEVENT\r\n" +
+	"BEGIN:VEVENT\r\nUID:gone\r\nDTSTART:20160102T120000Z\r\nSTATUS:CANCELLED\r\nSUMMARY:ledbrick:scene=gone\r\nEND:VEVENT\r\n" +
+	"BEGIN:VEVENT\r\nUID:monthly\r\nDTSTART:20160101T100000Z\r\nRRULE:FREQ=MONTHLY\r\nSUMMARY:ledbrick:scene=monthly\r\nEND:VEVENT\r\n" +
+	"END:VCALENDAR\r\n"
+
+func TestParseICalRecurring(t *testing.T) {
+	events, err := parseICal([]byte(recurringFeed), time.Date(2016, 1, 1, 0, 0, 0, 0, time.UTC))
+	if err != nil {
+		t.Fatal(err)
+	}
+	got := make(map[string][]string)
+	for _, e := range events {
+		got[e.name] = append(got[e.name], e.start.UTC().Format("02 15:04"))
+	}
+	want := map[string][]string{
+		"feed":    {"01 18:00", "02 18:00", "03 18:00", "04 19:00", "05 18:00", "07 18:00", "08 18:00", "09 18:00", "10 18:00"},
+		"photo":   {"05 20:00", "07 20:00", "19 20:00", "21 20:00"},
+		"monthly": {"01 10:00"},
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("Expected %v, got %v", want, got)
+	}
+
+	// Occurrences already over are left out
+	events, _ = parseICal([]byte(recurringFeed), time.Date(2016, 1, 9, 0, 0, 0, 0, time.UTC))
+	var feeds []string
+	for _, e := range events {
+		if e.name == "feed" && e.start.Day() > 4 {
+			feeds = append(feeds, e.start.UTC().Format("02 15:04"))
+		}
+	}
+	if !reflect.DeepEqual(feeds, []string{"09 18:00", "10 18:00"}) {
+		t.Errorf("Expected the 2 feeds left on the 9th, got %v", feeds)
+	}
+}
+
+func TestSubscription(t *testing.T) {
+	dir, err := ioutil.TempDir("", "subscribe")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	def := filepath.Join(dir, "default.json")
+	vacation := filepath.Join(dir, "vacation.json")
+	ioutil.WriteFile(def, flat("10"), 0644)
+	ioutil.WriteFile(vacation, flat("30"), 0644)
+	cache := filepath.Join(dir, "feed.ical")
+
+	feed := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(testFeed))
+	}))
+	f := &fakeChannel{}
+	ld, clk := newTestDriver(t, f)
+	defer ld.Stop()
+	follower := ld.FollowCalendar(&Calendar{Profiles: map[string]string{"vacation": vacation}}, def)
+	scenes := map[string][]float64{"photo": {50, 0, 0, 0, 0, 0, 0, 0}}
+	ld.Subscribe(feed.URL, cache, time.Hour, scenes)
+	feed.Close()
+	if f.get(0) != 50 {
+		t.Errorf("Expected the photo scene held during its event, got %.0f", f.get(0))
+	}
+
+	// Fetching now fails, so the cached events run
+	clk.Advance(time.Hour)
+	s := ld.Subscribe(feed.URL, cache, time.Hour, scenes)
+	if len(s.events) != 2 {
+		t.Fatalf("Expected the cached events, got %v", s.events)
+	}
+	if !wan.Offline() {
+		t.Errorf("Expected the feed reported offline")
+	}
+	if f.get(0) != 10 {
+		t.Errorf("Expected the table back after the scene, got %.0f", f.get(0))
+	}
+
+	clk.Advance(20 * time.Hour)
+	s.check()
+	if follower.Profile() != "vacation" || f.get(0) != 30 {
+		t.Errorf("Expected the vacation profile during its event, got %q at %.0f", follower.Profile(), f.get(0))
+	}
+	clk.Advance(24 * time.Hour)
+	s.check()
+	if follower.Profile() != "" || f.get(0) != 10 {
+		t.Errorf("Expected the day's profile after the event, got %q at %.0f", follower.Profile(), f.get(0))
+	}
+	if data, _ := ioutil.ReadFile(cache); !strings.Contains(string(data), "photo") {
+		t.Errorf("Expected the feed cached, got %s", data)
+	}
+}
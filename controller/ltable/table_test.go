@@ -1,9 +1,14 @@
 package ltable
 
 import (
+	"flag"
+	"math"
 	"sort"
+	"strings"
 	"testing"
 	"time"
+
+	"github.com/theatrus/ledbrick/controller/clock"
 )
 
 var percents = []float64{1.0, 2.0}
@@ -73,3 +78,337 @@ func TestPercentForTime(t *testing.T) {
 		t.Errorf("Value was not 0, got %f", value)
 	}
 }
+
+func TestPercentAcrossMidnight(t *testing.T) {
+	initLtables()
+
+	sc := newSchedule(settingPoints{
+		settingPoint{At: "01:00", Percents: percents1},
+		settingPoint{At: "12:00", Percents: percents1},
+		settingPoint{At: "22:00", Percents: percents2},
+	})
+
+	// Two of the three hours from 22:00 to 01:00
+	now := time.Date(2016, 1, 1, 0, 0, 0, 0, timeLocation)
+	if value := sc.Percent(now, 0); math.Abs(value-100.0/3) > 1e-9 {
+		t.Errorf("Value was not 33.3, got %f", value)
+	}
+	now = time.Date(2016, 1, 1, 23, 0, 0, 0, timeLocation)
+	if value := sc.Percent(now, 0); math.Abs(value-200.0/3) > 1e-9 {
+		t.Errorf("Value was not 66.7, got %f", value)
+	}
+	now = time.Date(2016, 1, 1, 22, 0, 30, 0, timeLocation)
+	if value := sc.Percent(now, 1); math.Abs(value-(50-50*30.0/10800)) > 1e-9 {
+		t.Errorf("Expected seconds to interpolate just below 50, got %f", value)
+	}
+}
+
+// driveAt runs a driver for a table on a fake clock from start, returning
+// channel 0 as written then and after advancing.
+func driveAt(t *testing.T, table string, start time.Time, advance time.Duration) (float64, float64) {
+	sc, err := ParseSchedule([]byte(table))
+	if err != nil {
+		t.Fatal(err)
+	}
+	f := &fakeChannel{}
+	c := clock.NewFake(start)
+	ld := newLightDriver(f, sc, c)
+	defer ld.Stop()
+	before := f.get(0)
+	c.Advance(advance)
+	ld.updateChannels()
+	return before, f.get(0)
+}
+
+func TestDriverDST(t *testing.T) {
+	initLtables()
+	// The table follows the wall clock, so the hour skipped when DST
+	// starts is skipped in the ramp too
+	start := time.Date(2016, 3, 13, 1, 59, 0, 0, timeLocation)
+	before, after := driveAt(t, `[
+		{"at": "01:00", "percents": [0, 0, 0, 0, 0, 0, 0, 0]},
+		{"at": "04:00", "percents": [90, 0, 0, 0, 0, 0, 0, 0]}
+	]`, start, time.Minute)
+	if before != 29.5 || after != 60 {
+		t.Errorf("Expected 29.5%% at 01:59 PST and 60%% at 03:00 PDT, got %.2f and %.2f", before, after)
+	}
+}
+
+func TestDriverMidnight(t *testing.T) {
+	initLtables()
+	start := time.Date(2016, 6, 1, 23, 0, 0, 0, timeLocation)
+	before, after := driveAt(t, `[
+		{"at": "02:00", "percents": [0, 0, 0, 0, 0, 0, 0, 0]},
+		{"at": "22:00", "percents": [40, 0, 0, 0, 0, 0, 0, 0]}
+	]`, start, 2*time.Hour)
+	if before != 30 || after != 10 {
+		t.Errorf("Expected 30%% at 23:00 and 10%% at 01:00, got %.2f and %.2f", before, after)
+	}
+}
+
+func TestParseAt(t *testing.T) {
+	for at, want := range map[string][2]int{
+		"10:12":     {10, 12},
+		"9:05":      {9, 5},
+		"21.30":     {21, 30},
+		"21h30":     {21, 30},
+		"9:30 PM":   {21, 30},
+		"9:30pm":    {21, 30},
+		"12:15 am":  {0, 15},
+		"12:00 PM":  {12, 0},
+		"7 a.m.":    {7, 0},
+		" 7.45 AM ": {7, 45},
+	} {
+		hours, minutes, err := parseAt(at)
+		if err != nil {
+			t.Errorf("%q: %v", at, err)
+			continue
+		}
+		if hours != want[0] || minutes != want[1] {
+			t.Errorf("%q: expected %02d:%02d, got %02d:%02d", at, want[0], want[1], hours, minutes)
+		}
+	}
+	for _, at := range []string{"", "24:00", "13:00 PM", "0:30 am", "9", "9:", "9:30:00", "9:60", "noon"} {
+		if _, _, err := parseAt(at); err == nil {
+			t.Errorf("Expected %q to fail", at)
+		}
+	}
+}
+
+func TestPointZone(t *testing.T) {
+	initLtables()
+	sp := settingPoint{At: "12:00", Zone: "UTC"}
+	for _, day := range []time.Time{
+		time.Date(2016, 1, 15, 0, 0, 0, 0, time.UTC),
+		time.Date(2016, 7, 15, 0, 0, 0, 0, time.UTC),
+	} {
+		hours, minutes, err := sp.clock(day)
+		if err != nil {
+			t.Fatal(err)
+		}
+		want := time.Date(day.Year(), day.Month(), day.Day(), 12, 0, 0, 0, time.UTC).In(timeLocation)
+		if hours != want.Hour() || minutes != 0 {
+			t.Errorf("Expected noon UTC on %s converted to %s, got %02d:%02d",
+				day.Format("2006-01-02"), timeLocation, hours, minutes)
+		}
+	}
+
+	sp.Zone = "Nowhere/Special"
+	if _, _, err := sp.clock(time.Now()); err == nil {
+		t.Error("Expected an unknown zone to fail")
+	}
+}
+
+func TestZonedScheduleFollowsDate(t *testing.T) {
+	defer func(l *time.Location) { timeLocation = l }(timeLocation)
+	initLtables()
+	timeLocation, _ = time.LoadLocation("America/Los_Angeles")
+	sc := newSchedule(settingPoints{
+		{At: "00:00", Percents: []float64{0}, ease: "step"},
+		{At: "12:00", Zone: "UTC", Percents: []float64{100}, ease: "step"},
+	})
+	for _, tt := range []struct {
+		at   time.Time
+		want float64
+	}{
+		// Noon UTC is 04:00 in the winter and 05:00 in the summer
+		{time.Date(2016, 1, 15, 4, 30, 0, 0, timeLocation), 100},
+		{time.Date(2016, 7, 15, 4, 30, 0, 0, timeLocation), 0},
+		{time.Date(2016, 7, 15, 5, 30, 0, 0, timeLocation), 100},
+	} {
+		if got := sc.Percent(tt.at, 0); math.Abs(got-tt.want) > 1 {
+			t.Errorf("At %s expected %.0f%%, got %.1f%%", tt.at, tt.want, got)
+		}
+		if got := sc.Reversed().Percent(tt.at.Add(12*time.Hour), 0); math.Abs(got-tt.want) > 1 {
+			t.Errorf("Reversed at %s expected %.0f%%, got %.1f%%", tt.at.Add(12*time.Hour), tt.want, got)
+		}
+	}
+}
+
+const tableV2Text = `{
+    "version": 2,
+    "points": [
+        {"at": "08:00", "ease": "smooth", "tags": ["sunrise"],
+         "channels": {"green": 0, "cyan": 0, "pc_amber": 0, "Blue": 0, "Red": 0, "deep blue": 0, "White": 0, "UV": 0}},
+        {"at": "12:00",
+         "channels": {"green": 100, "cyan": 100, "pc_amber": 100, "Blue": 100, "Red": 100, "deep blue": 100, "White": 100, "UV": 100}}
+    ]
+}`
+
+func TestParseV2(t *testing.T) {
+	initLtables()
+	sc, err := ParseSchedule([]byte(tableV2Text))
+	if err != nil {
+		t.Fatal(err)
+	}
+	at := func(hour, minute int) time.Time {
+		now := time.Now().In(timeLocation)
+		return time.Date(now.Year(), now.Month(), now.Day(), hour, minute, 0, 0, timeLocation)
+	}
+	// Smooth is half way at the middle, but slow at the start
+	if v := sc.Percent(at(10, 0), 3); math.Abs(v-50) > 0.01 {
+		t.Errorf("Expected 50%% at 10:00, got %v", v)
+	}
+	if v := sc.Percent(at(8, 30), 3); v >= 12.5 {
+		t.Errorf("Expected less than a linear 12.5%% at 08:30, got %v", v)
+	}
+
+	// Writing it back keeps the ease and tags, and reads the same
+	data, err := sc.Table()
+	if err != nil {
+		t.Fatal(err)
+	}
+	again, err := ParseSchedule(data)
+	if err != nil {
+		t.Fatalf("%v in\n%s", err, data)
+	}
+	if again.meta[0].ease != "smooth" || len(again.meta[0].tags) != 1 || again.Percent(at(8, 30), 3) != sc.Percent(at(8, 30), 3) {
+		t.Errorf("Expected the table to survive writing out, got\n%s", data)
+	}
+}
+
+func TestConvertTable(t *testing.T) {
+	initLtables()
+	v1 := `[{"at": "08:00", "percents": [0, 1, 2, 3, 4, 5, 6, 7]}, {"at": "20:00", "percents": [7, 6, 5, 4, 3, 2, 1, 0]}]`
+	data, err := ConvertTable([]byte(v1))
+	if err != nil {
+		t.Fatal(err)
+	}
+	sc, err := ParseSchedule(data)
+	if err != nil {
+		t.Fatalf("%v in\n%s", err, data)
+	}
+	if sc.meta != nil || sc.percents[0][5] != 5 || sc.percents[1][0] != 7 {
+		t.Errorf("Expected the v1 table converted, got\n%s", data)
+	}
+	// Without ease or tags it's written as v1 again
+	if data, _ := sc.Table(); data[0] != '[' {
+		t.Errorf("Expected a v1 table, got\n%s", data)
+	}
+}
+
+func TestParseV2Problems(t *testing.T) {
+	initLtables()
+	for _, test := range []struct {
+		table, want string
+	}{
+		{`{"points": []}`, `needs "version": 2`},
+		{`{"version": 2, "points": [], "extra": 1}`, `unknown field "extra"`},
+		{`{"version": 2, "points": [{"at": "08:00", "channels": {"Green": 1}}]}`, "channel Cyan isn't given at any point"},
+		{`{"version": 2, "points": [{"at": "08:00", "channels": {"Purple": 1}}]}`, `unknown channel "Purple"`},
+		{`{"version": 2, "points": [{"at": "08:00", "ease": "bouncy", "channels": {}}]}`, `unknown ease "bouncy"`},
+		{`{"version": 2, "points": [{"at": "08:00", "percents": [], "channels": {}}]}`, `"percents"`},
+	} {
+		_, err := ParseSchedule([]byte(test.table))
+		if err == nil || !strings.Contains(err.Error(), test.want) {
+			t.Errorf("Expected %s to fail with %q, got %v", test.table, test.want, err)
+		}
+	}
+}
+
+func TestSparseChannels(t *testing.T) {
+	initLtables()
+	// Blue has four points, UV only two, and the rest one each
+	sc, err := ParseSchedule([]byte(`{"version": 2, "points": [
+		{"at": "06:00", "channels": {"Green": 10, "Cyan": 10, "PC Amber": 10, "Red": 10, "Deep Blue": 10, "White": 10, "Blue": 0}},
+		{"at": "09:00", "channels": {"Blue": 60}},
+		{"at": "12:00", "channels": {"Blue": 100, "UV": 40}},
+		{"at": "18:00", "channels": {"Blue": 0}},
+		{"at": "00:00", "channels": {"UV": 0}}
+	]}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	at := func(hour int) time.Time {
+		now := time.Now().In(timeLocation)
+		return time.Date(now.Year(), now.Month(), now.Day(), hour, 0, 0, 0, timeLocation)
+	}
+	for _, test := range []struct {
+		hour, channel int
+		want          float64
+	}{
+		{9, 3, 60},
+		{15, 3, 50},
+		// UV runs straight from midnight to noon, through the others
+		{6, 7, 20},
+		{9, 7, 30},
+		{18, 7, 20},
+		// Given once, Green holds all day
+		{15, 0, 10},
+	} {
+		if v := sc.Percent(at(test.hour), test.channel); math.Abs(v-test.want) > 0.01 {
+			t.Errorf("Expected channel %d at %d:00 to be %v, got %v", test.channel, test.hour, test.want, v)
+		}
+	}
+
+	// The table is written back as sparse as it was read
+	data, err := sc.Table()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if strings.Count(string(data), `"UV"`) != 2 {
+		t.Errorf("Expected UV written at two points, got\n%s", data)
+	}
+}
+
+func TestAccessories(t *testing.T) {
+	initLtables()
+	defer func(known func(string) bool) { accessoryKnown = known }(accessoryKnown)
+	accessoryKnown = func(name string) bool { return name == "refugium pump" }
+
+	// The pump runs on the display's night, only at the points giving it
+	table := `{"version": 2, "points": [
+		{"at": "08:00", "channels": {"Green": 0, "Cyan": 0, "PC Amber": 0, "Blue": 0, "Red": 0,
+			"Deep Blue": 0, "White": 0, "UV": 0, "refugium pump": 0}, "ease": "step"},
+		{"at": "12:00", "channels": {"Blue": 100}},
+		{"at": "20:00", "channels": {"Blue": 0, "refugium pump": 100}, "ease": "step"}
+	]}`
+	sc, err := ParseSchedule([]byte(table))
+	if err != nil {
+		t.Fatal(err)
+	}
+	at := func(hour int) time.Time {
+		now := time.Now().In(timeLocation)
+		return time.Date(now.Year(), now.Month(), now.Day(), hour, 0, 0, 0, timeLocation)
+	}
+	pump := sc.accessories["refugium pump"]
+	if pump == nil {
+		t.Fatal("Expected a schedule for the pump")
+	}
+	for hour, want := range map[int]float64{7: 100, 12: 0, 21: 100} {
+		if v := pump.Percent(at(hour), 0); v != want {
+			t.Errorf("Expected the pump at %v at %d:00, got %v", want, hour, v)
+		}
+	}
+	if v := sc.Percent(at(12), 3); v != 100 {
+		t.Errorf("Expected the accessory to leave Blue alone, got %v", v)
+	}
+	if v := sc.Reversed().accessories["refugium pump"].Percent(at(9), 0); v != 100 {
+		t.Errorf("Expected the pump reversed with the table, got %v", v)
+	}
+
+	data, err := sc.Table()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if strings.Count(string(data), `"refugium pump"`) != 2 {
+		t.Errorf("Expected the pump written at its two points, got\n%s", data)
+	}
+
+	accessoryKnown = func(string) bool { return false }
+	if _, err := ParseSchedule([]byte(table)); err == nil || !strings.Contains(err.Error(), `unknown channel "refugium pump"`) {
+		t.Errorf("Expected an unconfigured accessory to be refused, got %v", err)
+	}
+}
+
+func TestIntervalFlag(t *testing.T) {
+	defer func(d time.Duration) { interval = d }(interval)
+	for _, v := range []string{"0s", "-1s", "often"} {
+		if err := flag.Set("ltable.interval", v); err == nil {
+			t.Errorf("Expected an interval of %s refused", v)
+		}
+	}
+	if err := flag.Set("ltable.interval", "5s"); err != nil || interval != 5*time.Second {
+		t.Errorf("Expected an interval of 5s, got %s and %v", interval, err)
+	}
+}
@@ -0,0 +1,112 @@
+package ltable
+
+import (
+	"errors"
+	"fmt"
+	"time"
+)
+
+// Target describes a day by how much light it gives rather than channel
+// percents, for Solve.
+type Target struct {
+	// DLI is the daily light integral in mol/m2/d. If it is 0, PeakPAR
+	// in umol/m2/s is used instead.
+	DLI     float64
+	PeakPAR float64
+	// Weights is the relative mix of the channels at the peak
+	Weights [Channels]float64
+	// PAR is the calibration: what each channel adds at the tank at
+	// 100%, in umol/m2/s
+	PAR [Channels]float64
+}
+
+// Light works out the DLI and peak PAR a schedule gives with a PAR
+// calibration, a minute at a time.
+func (sc *Schedule) Light(par [Channels]float64) (dli, peak float64) {
+	initLtables()
+	for minute := 0; minute < 24*60; minute++ {
+		at := time.Date(0, 0, 0, minute/60, minute%60, 0, 0, timeLocation)
+		ppfd := 0.0
+		for channel := 0; channel < Channels; channel++ {
+			ppfd += sc.Percent(at, channel) / 100 * par[channel]
+		}
+		if ppfd > peak {
+			peak = ppfd
+		}
+		dli += ppfd * 60 / 1e6
+	}
+	return dli, peak
+}
+
+// Solve sets the peaks of a plan to the channel mix meeting a target,
+// keeping the plan's photoperiod, ramps and moonlight. The strongest
+// channel in the mix is at most 100%, so a target the fixtures can't
+// reach is an error saying what they can.
+func Solve(p Plan, t Target) (Plan, error) {
+	strongest := 0.0
+	lit := false
+	for channel, w := range t.Weights {
+		if w < 0 {
+			return p, fmt.Errorf("channel %d has a negative weight", channel)
+		}
+		if w > strongest {
+			strongest = w
+		}
+		if w > 0 && t.PAR[channel] > 0 {
+			lit = true
+		}
+	}
+	if !lit {
+		return p, errors.New("no weighted channel has a PAR calibration")
+	}
+
+	// Light is linear in the peaks, apart from moonlight, so the light
+	// of the brightest mix and of moonlight alone give the scale
+	light := func(scale float64) (float64, float64, error) {
+		q := p
+		for channel, w := range t.Weights {
+			q.Peaks[channel] = scale * 100 * w / strongest
+		}
+		data, err := Generate(q)
+		if err != nil {
+			return 0, 0, err
+		}
+		sc, err := ParseSchedule(data)
+		if err != nil {
+			return 0, 0, err
+		}
+		dli, peak := sc.Light(t.PAR)
+		return dli, peak, nil
+	}
+	dliMoon, peakMoon, err := light(0)
+	if err != nil {
+		return p, err
+	}
+	dliFull, peakFull, err := light(1)
+	if err != nil {
+		return p, err
+	}
+
+	var scale float64
+	switch {
+	case t.DLI > 0:
+		if t.DLI > dliFull {
+			return p, fmt.Errorf("DLI %.1f is out of reach, the most this mix gives is %.1f", t.DLI, dliFull)
+		}
+		scale = (t.DLI - dliMoon) / (dliFull - dliMoon)
+	case t.PeakPAR > 0:
+		if t.PeakPAR > peakFull {
+			return p, fmt.Errorf("peak PAR %.0f is out of reach, the most this mix gives is %.0f", t.PeakPAR, peakFull)
+		}
+		scale = (t.PeakPAR - peakMoon) / (peakFull - peakMoon)
+	default:
+		return p, errors.New("target needs a DLI or peak PAR")
+	}
+	if scale < 0 {
+		scale = 0
+	}
+	for channel, w := range t.Weights {
+		p.Peaks[channel] = scale * 100 * w / strongest
+	}
+	return p, nil
+}
@@ -0,0 +1,183 @@
+package ltable
+
+import (
+	"encoding/json"
+	"errors"
+	"flag"
+	"io/ioutil"
+	"log"
+	"os"
+
+	"github.com/theatrus/ledbrick/controller/atomicfile"
+)
+
+var undoDepth int
+
+func init() {
+	flag.IntVar(&undoDepth, "ltable.undo", 20,
+		"How many schedule edits made through the API can be undone")
+}
+
+// savedEdits is the edit history as kept on disk, with the table it
+// leads up to.
+type savedEdits struct {
+	Current json.RawMessage   `json:"current"`
+	Undo    []json.RawMessage `json:"undo"`
+	Redo    []json.RawMessage `json:"redo"`
+}
+
+// Edit applies a table as Apply does, and records the one it replaces
+// so it can be undone.
+func (ld *LightDriver) Edit(data []byte) error {
+	prev := ld.current()
+	if err := ld.Apply(data); err != nil {
+		return err
+	}
+	ld.mu.Lock()
+	ld.record(prev)
+	ld.mu.Unlock()
+	ld.saveEdits()
+	return nil
+}
+
+// record pushes the table an edit replaced onto the undo history,
+// dropping the oldest past the depth, and forgets anything undone. The
+// caller must hold the lock.
+func (ld *LightDriver) record(prev *Schedule) {
+	ld.undo = append(ld.undo, prev)
+	if len(ld.undo) > undoDepth {
+		ld.undo = ld.undo[len(ld.undo)-undoDepth:]
+	}
+	ld.redo = nil
+}
+
+// Undo puts back the table from before the last edit.
+func (ld *LightDriver) Undo() error {
+	return ld.step(&ld.undo, &ld.redo, "undo")
+}
+
+// Redo applies the last edit undone again.
+func (ld *LightDriver) Redo() error {
+	return ld.step(&ld.redo, &ld.undo, "redo")
+}
+
+// step takes the last table from one history, keeping the running one
+// on the other, and puts it on trial.
+func (ld *LightDriver) step(from, to *[]*Schedule, what string) error {
+	ld.mu.Lock()
+	if len(*from) == 0 {
+		ld.mu.Unlock()
+		return errors.New("nothing to " + what)
+	}
+	sc := (*from)[len(*from)-1]
+	*from = (*from)[:len(*from)-1]
+	*to = append(*to, ld.schedule)
+	ld.mu.Unlock()
+
+	log.Printf("Schedule edit %s", what)
+	err := ld.try(sc)
+	ld.saveEdits()
+	return err
+}
+
+// Edits returns how many edits can be undone and redone.
+func (ld *LightDriver) Edits() (undo, redo int) {
+	ld.mu.Lock()
+	defer ld.mu.Unlock()
+	return len(ld.undo), len(ld.redo)
+}
+
+// KeepEdits keeps the edit history in a file so it survives restarts,
+// loading what is there. If the table running isn't the one the history
+// led up to, as when the controller restarts on its config file after
+// edits which weren't saved to it, that table can be undone back to.
+func (ld *LightDriver) KeepEdits(file string) error {
+	ld.mu.Lock()
+	ld.edits = file
+	ld.mu.Unlock()
+
+	data, err := ioutil.ReadFile(file)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	var saved savedEdits
+	if err := json.Unmarshal(data, &saved); err != nil {
+		return err
+	}
+	load := func(tables []json.RawMessage) ([]*Schedule, error) {
+		var schedules []*Schedule
+		for _, table := range tables {
+			sc, err := ParseSchedule(table)
+			if err != nil {
+				return nil, err
+			}
+			schedules = append(schedules, sc)
+		}
+		return schedules, nil
+	}
+	undo, err := load(saved.Undo)
+	if err != nil {
+		return err
+	}
+	redo, err := load(saved.Redo)
+	if err != nil {
+		return err
+	}
+
+	ld.mu.Lock()
+	defer ld.mu.Unlock()
+	ld.undo, ld.redo = undo, redo
+	if current, err := ld.schedule.Table(); err == nil && !sameTable(current, saved.Current) {
+		if sc, err := ParseSchedule(saved.Current); err == nil {
+			ld.record(sc)
+		}
+	}
+	return nil
+}
+
+// sameTable reports whether two tables as JSON are the same, ignoring
+// layout.
+func sameTable(a, b []byte) bool {
+	var ta, tb settingPoints
+	if json.Unmarshal(a, &ta) != nil || json.Unmarshal(b, &tb) != nil {
+		return false
+	}
+	ja, _ := json.Marshal(ta)
+	jb, _ := json.Marshal(tb)
+	return string(ja) == string(jb)
+}
+
+// tables returns schedules as tables to save.
+func tables(schedules []*Schedule) []json.RawMessage {
+	var saved []json.RawMessage
+	for _, sc := range schedules {
+		if table, err := sc.Table(); err == nil {
+			saved = append(saved, table)
+		}
+	}
+	return saved
+}
+
+// saveEdits writes the edit history to its file, if it is kept in one.
+func (ld *LightDriver) saveEdits() {
+	ld.mu.Lock()
+	file := ld.edits
+	saved := savedEdits{Undo: tables(ld.undo), Redo: tables(ld.redo)}
+	saved.Current, _ = ld.schedule.Table()
+	ld.mu.Unlock()
+	if file == "" {
+		return
+	}
+
+	data, err := json.Marshal(saved)
+	if err != nil {
+		log.Printf("Failed to encode schedule edits: %v", err)
+		return
+	}
+	if err := atomicfile.WriteFile(file, data, 0644); err != nil {
+		log.Printf("Failed to save schedule edits: %v", err)
+	}
+}
@@ -0,0 +1,90 @@
+package ltable
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestUndoRedo(t *testing.T) {
+	f := &fakeChannel{}
+	ld, _ := newTestDriver(t, f)
+	if err := ld.Undo(); err == nil {
+		t.Error("Expected nothing to undo")
+	}
+	ld.Edit(flat("20"))
+	ld.Edit(flat("30"))
+	if undo, redo := ld.Edits(); undo != 2 || redo != 0 {
+		t.Errorf("Expected 2 edits to undo, got %d and %d to redo", undo, redo)
+	}
+
+	if err := ld.Undo(); err != nil {
+		t.Fatal(err)
+	}
+	if f.get(0) != 20 {
+		t.Errorf("Expected the edit undone, got %.0f", f.get(0))
+	}
+	ld.Undo()
+	if f.get(0) != 10 {
+		t.Errorf("Expected the first table back, got %.0f", f.get(0))
+	}
+	if err := ld.Redo(); err != nil {
+		t.Fatal(err)
+	}
+	if f.get(0) != 20 {
+		t.Errorf("Expected the edit redone, got %.0f", f.get(0))
+	}
+
+	// A new edit forgets what was undone
+	ld.Edit(flat("40"))
+	if _, redo := ld.Edits(); redo != 0 {
+		t.Errorf("Expected nothing to redo after an edit, got %d", redo)
+	}
+}
+
+func TestUndoDepth(t *testing.T) {
+	defer func(d int) { undoDepth = d }(undoDepth)
+	undoDepth = 2
+	f := &fakeChannel{}
+	ld, _ := newTestDriver(t, f)
+	for _, p := range []string{"20", "30", "40"} {
+		ld.Edit(flat(p))
+	}
+	ld.Undo()
+	ld.Undo()
+	if undo, _ := ld.Edits(); undo != 0 || f.get(0) != 20 {
+		t.Errorf("Expected the oldest edit dropped, got %d left at %.0f", undo, f.get(0))
+	}
+}
+
+func TestKeepEdits(t *testing.T) {
+	dir, err := ioutil.TempDir("", "ledbrick")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	file := filepath.Join(dir, "edits.json")
+
+	f := &fakeChannel{}
+	ld, _ := newTestDriver(t, f)
+	if err := ld.KeepEdits(file); err != nil {
+		t.Fatal(err)
+	}
+	ld.Edit(flat("20"))
+	ld.Edit(flat("30"))
+
+	// Restarting on the config file, which still has the first table,
+	// can undo back to the last edit
+	restarted, _ := newTestDriver(t, f)
+	if err := restarted.KeepEdits(file); err != nil {
+		t.Fatal(err)
+	}
+	if undo, _ := restarted.Edits(); undo != 3 {
+		t.Errorf("Expected 3 edits to undo, got %d", undo)
+	}
+	restarted.Undo()
+	if f.get(0) != 30 {
+		t.Errorf("Expected the edited table back, got %.0f", f.get(0))
+	}
+}
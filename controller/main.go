@@ -2,30 +2,303 @@ package main
 
 import (
 	"flag"
+	"github.com/theatrus/ledbrick/controller/accessory"
+	"github.com/theatrus/ledbrick/controller/agent"
+	"github.com/theatrus/ledbrick/controller/ambient"
+	"github.com/theatrus/ledbrick/controller/api"
+	"github.com/theatrus/ledbrick/controller/astro"
 	"github.com/theatrus/ledbrick/controller/ble"
+	"github.com/theatrus/ledbrick/controller/bridge"
+	"github.com/theatrus/ledbrick/controller/events"
+	// Fixtures behind -gateway.addrs run alongside bluetooth ones
+	_ "github.com/theatrus/ledbrick/controller/gateway"
+	"github.com/theatrus/ledbrick/controller/gpio"
+	"github.com/theatrus/ledbrick/controller/hue"
+	"github.com/theatrus/ledbrick/controller/journal"
 	"github.com/theatrus/ledbrick/controller/ltable"
+	"github.com/theatrus/ledbrick/controller/plugs"
+	"github.com/theatrus/ledbrick/controller/probe"
+	"github.com/theatrus/ledbrick/controller/push"
+	"github.com/theatrus/ledbrick/controller/report"
+	// Boards on -serial.ports run alongside bluetooth fixtures
+	_ "github.com/theatrus/ledbrick/controller/serial"
+	"github.com/theatrus/ledbrick/controller/spectrum"
+	"github.com/theatrus/ledbrick/controller/support"
+	"github.com/theatrus/ledbrick/controller/units"
+	"github.com/theatrus/ledbrick/controller/ups"
+	"io"
 	"io/ioutil"
 	"log"
+	"os"
+	"time"
 )
 
+// version is set when building a release with
+// -ldflags "-X main.version=..."
+var version = "dev"
+
 var done = make(chan struct{})
 var config = flag.String("config", "/etc/ledbrick-table.json", "Config file name")
+var check = flag.Bool("check", false, "Check the config and fixture settings, then exit")
+var simulate = flag.Bool("simulate", false, "Run the config through a day without fixtures, printing the output")
+var timeScale = flag.Float64("time-scale", 720, "How many times faster than real time to simulate, 720 is a day in 2 minutes")
+var simulateStep = flag.Duration("simulate.step", 10*time.Minute, "Simulated time between printed outputs")
+var format = flag.String("format", "table", "Output format for check-config, -simulate, -soak and replay, table or json")
+var calendar = flag.String("calendar", "", "JSON file of profiles and the dates to run them on instead of -config")
+var calendarFeed = flag.String("calendar.ical", "", "URL of an iCalendar feed whose events tagged ledbrick:scene=<name> or ledbrick:profile=<name> run a scene or profile while they last")
+var calendarPoll = flag.Duration("calendar.ical.poll", 15*time.Minute, "How often the -calendar.ical feed is fetched")
+var biotope = flag.String("biotope", "", "Follow a reef's year, scaling the table's photoperiod and light each day: gbr, fiji, red-sea, or a JSON data pack file")
+var mirrorSky = flag.String("mirror", "", "Latitude and longitude of a place whose sun the lights follow in real time instead of the table's times, e.g. -17.7,178.1 for Fiji")
+var mirrorOffset = flag.Duration("mirror.offset", 0, "How much later the -mirror sky runs, e.g. 6h for a noon in the evening")
+var eclipses = flag.Bool("eclipses", false, "Dim the lights through real solar and lunar eclipses seen from -astro.location")
+var calendarScenes = flag.String("calendar.scenes", "", "JSON file of the channel percents to hold for each calendar scene, such as photo")
+var soak = flag.Duration("soak", 0, "Run a soak test cycling every channel of the connected fixtures for this long instead of the schedule, then print a report")
+var soakStep = flag.Duration("soak.step", time.Second, "How often the soak test changes channel values")
+var soakPeriod = flag.Duration("soak.period", 2*time.Minute, "How long each channel takes to sweep up and down in the soak test")
+var selfTest = flag.Bool("selftest", false, "Ramp each channel of every fixture at start, checking writes and telemetry, before running the schedule")
+var burnInPercent = flag.Float64("burnin.percent", 80, "Percent every channel is driven at during a burn-in")
+var burnInDuration = flag.Duration("burnin.duration", 8*time.Hour, "How long a burn-in runs")
+var burnInMax = flag.Int("burnin.max", 65, "Fixture temperature in C at which a burn-in is aborted")
+var agentListen = flag.String("agent.listen", "", "Run only as the agent doing the bluetooth I/O for a server elsewhere, listening on this address, e.g. :7070 with -agent.token")
+var agentAddr = flag.String("agent", "", "Address of an agent to drive the fixtures through, instead of this machine's bluetooth")
+var selfTestWait = flag.Duration("selftest.wait", time.Minute, "How long the self-test waits for fixtures to connect")
 
 func main() {
 	flag.Parse()
-	log.Println("LEDBrick Controller Master")
-	log.Printf("Parsing config file %s", config)
+	if *format != "table" && *format != "json" {
+		log.Printf("Error: -format must be table or json, not %q", *format)
+		os.Exit(2)
+	}
+	if err := units.Check(); err != nil {
+		log.Printf("Error: %v", err)
+		os.Exit(2)
+	}
+	// Loaded first, as tables can't be read without knowing the
+	// accessories they may name
+	if err := accessory.Load(); err != nil {
+		log.Printf("Error: %v", err)
+		os.Exit(2)
+	}
+	if err := bridge.LoadBricks(); err != nil {
+		log.Printf("Error: %v", err)
+		os.Exit(2)
+	}
+	if flag.Arg(0) == "init" {
+		if err := runInit(os.Stdin, os.Stdout); err != nil {
+			log.Printf("Error: %v", err)
+			os.Exit(1)
+		}
+		return
+	}
+	// ledbrick chart [file.svg|file.png] draws the schedule, as SVG on
+	// stdout without a file
+	if flag.Arg(0) == "chart" {
+		if err := runChart(flag.Arg(1)); err != nil {
+			log.Printf("Error: %v", err)
+			os.Exit(1)
+		}
+		return
+	}
+	// ledbrick convert writes the schedule as a version 2 table, naming
+	// each point's channels
+	if flag.Arg(0) == "convert" {
+		data, err := ioutil.ReadFile(*config)
+		if err == nil {
+			data, err = ltable.ConvertTable(data)
+		}
+		if err != nil {
+			log.Printf("Error: %v", err)
+			os.Exit(1)
+		}
+		os.Stdout.Write(append(data, '\n'))
+		return
+	}
+	// ledbrick replay <history file> runs saved telemetry back through
+	// the fixture checks
+	if flag.Arg(0) == "replay" {
+		if err := runReplay(flag.Arg(1), *format); err != nil {
+			log.Printf("Error: %v", err)
+			os.Exit(1)
+		}
+		return
+	}
+	// ledbrick check-config [file] is the same as -check
+	if flag.Arg(0) == "check-config" {
+		*check = true
+		if flag.NArg() > 1 {
+			*config = flag.Arg(1)
+		}
+	}
+	if *simulate {
+		data, err := ioutil.ReadFile(*config)
+		if err == nil {
+			err = runSimulate(data, os.Stdout)
+		}
+		if err != nil {
+			log.Printf("Error: %v", err)
+			os.Exit(1)
+		}
+		return
+	}
+	if *check {
+		if !checkConfig(os.Stdout, *config, *format) {
+			os.Exit(1)
+		}
+		return
+	}
+
+	log.SetOutput(io.MultiWriter(os.Stderr, support.Logs))
+	log.Printf("LEDBrick Controller Master %s", version)
+	if *agentListen != "" {
+		b := ble.NewBLEChannel()
+		go releaseOnExit(b)
+		log.Printf("Error: %v", agent.NewAgent(b).ListenAndServe(*agentListen))
+		return
+	}
+	log.Printf("Parsing config file %s", *config)
 
 	file, err := ioutil.ReadFile(*config)
 	if err != nil {
 		log.Printf("Error: %v", err)
 		return
 	}
-	bleChannel := ble.NewBLEChannel()
-	_, err = ltable.NewLightDriverFromJson(bleChannel, file)
+	var bleChannel ble.BLEChannel
+	if *agentAddr != "" {
+		bleChannel = agent.Dial(*agentAddr)
+	} else {
+		bleChannel = ble.NewBLEChannel()
+	}
+	if *soak > 0 {
+		runSoak(bleChannel, os.Stdout)
+		return
+	}
+	// ledbrick burn-in <fixture> proves a new build's cooling, with
+	// the other fixtures off
+	if flag.Arg(0) == "burn-in" {
+		runBurnIn(bleChannel, flag.Arg(1), os.Stdout)
+		return
+	}
+	go releaseOnExit(bleChannel)
+	if err := accessory.Start(bleChannel); err != nil {
+		log.Printf("error in starting accessories: %v", err)
+		return
+	}
+	if *selfTest {
+		runSelfTest(bleChannel)
+	}
+	lights, err := ltable.NewLightDriverFromJson(bleChannel, file)
 	if err != nil {
 		log.Printf("error in loading driver: %v", err)
 		return
 	}
+	if err := lights.KeepEdits(*config + ".edits"); err != nil {
+		log.Printf("error in loading schedule edits: %v", err)
+		return
+	}
+	reloads, err := followCalendar(lights)
+	if err != nil {
+		log.Printf("error in loading calendar: %v", err)
+		return
+	}
+	if err := subscribeCalendar(lights); err != nil {
+		log.Printf("error in loading calendar scenes: %v", err)
+		return
+	}
+	if *biotope != "" {
+		b, err := ltable.LoadBiotope(*biotope)
+		if err != nil {
+			log.Printf("error in loading biotope: %v", err)
+			return
+		}
+		lights.FollowBiotope(b)
+	}
+	if *mirrorSky != "" {
+		l, err := astro.ParseLocation(*mirrorSky)
+		if err != nil {
+			log.Printf("error in mirroring the sky: %v", err)
+			return
+		}
+		lights.Mirror(l, *mirrorOffset)
+	}
+	if *eclipses {
+		here, err := astro.Here()
+		if err != nil {
+			log.Printf("error in simulating eclipses: %v", err)
+			return
+		}
+		lights.SimulateEclipses(here)
+	}
+	probes, err := probe.Start(bleChannel)
+	if err != nil {
+		log.Printf("error in starting temperature probes: %v", err)
+		return
+	}
+
+	interlocks, err := gpio.Start(bleChannel)
+	if err != nil {
+		log.Printf("error in starting interlocks: %v", err)
+		return
+	}
+
+	if err := plugs.Start(); err != nil {
+		log.Printf("error in loading smart plugs: %v", err)
+		return
+	}
+
+	if err := push.Start(); err != nil {
+		log.Printf("error in starting push notifications: %v", err)
+		return
+	}
+
+	if err := events.Start(); err != nil {
+		log.Printf("error in starting event publishing: %v", err)
+		return
+	}
+
+	spectra, err := spectrum.Load()
+	if err != nil {
+		log.Printf("error in loading channel spectra: %v", err)
+		return
+	}
+
+	notes, err := journal.Open()
+	if err != nil {
+		log.Printf("error in loading the journal: %v", err)
+		return
+	}
+
+	upsMonitor := ups.Start(bleChannel)
+	bridge.Start(bleChannel, upsMonitor)
+	hue.Start(bleChannel)
+
+	server := api.NewServer(bleChannel)
+	server.Probes = probes
+	server.Interlocks = interlocks
+	server.Reporter = report.Start(bleChannel)
+	server.UPS = upsMonitor
+	server.Ambient = ambient.Start(bleChannel)
+	server.Lights = lights
+	server.Spectra = spectra
+	server.Journal = notes
+	server.Version = version
+	tankReloads, err := startTanks(bleChannel, server)
+	if err != nil {
+		log.Printf("error in starting tanks: %v", err)
+		return
+	}
+	go reloadOnHangup(append(reloads, tankReloads...))
+	if err := api.StartRemoteWrite(server); err != nil {
+		log.Printf("error in starting metrics push: %v", err)
+		return
+	}
+	if err := api.StartStatsD(server); err != nil {
+		log.Printf("error in starting StatsD metrics: %v", err)
+		return
+	}
+	if err := api.StartRedis(server); err != nil {
+		log.Printf("error in starting Redis mirror: %v", err)
+		return
+	}
+	api.ListenAndServe(server)
 	<-done
 }
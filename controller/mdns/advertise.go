@@ -0,0 +1,116 @@
+package mdns
+
+import (
+	"log"
+	"net"
+	"os"
+	"strings"
+)
+
+// How long answers may be cached, in seconds
+const ttl = 120
+
+// Advertise answers queries for a service with an instance of it on
+// this host's addresses at port, until the process exits.
+func Advertise(instance, service string, port int) error {
+	conn, err := net.ListenMulticastUDP("udp4", nil, mdnsGroup)
+	if err != nil {
+		return err
+	}
+	host, _ := os.Hostname()
+	if i := strings.Index(host, "."); i >= 0 {
+		host = host[:i]
+	}
+	if host == "" {
+		host = "ledbrick"
+	}
+	host += ".local"
+	go func() {
+		defer conn.Close()
+		buf := make([]byte, 9000)
+		for {
+			n, from, err := conn.ReadFromUDP(buf)
+			if err != nil {
+				log.Printf("mDNS advertisement of %s stopped: %v", instance, err)
+				return
+			}
+			unicast, ok := asksFor(buf[:n], service)
+			if !ok {
+				continue
+			}
+			reply := answer(buf[:n], instance, service, host, port, localIPs())
+			// Queries from a port other than mDNS's own are from simple
+			// resolvers, such as Browse, which only hear unicast
+			to := mdnsGroup
+			if unicast || from.Port != mdnsGroup.Port {
+				to = from
+			}
+			if _, err := conn.WriteToUDP(reply, to); err != nil {
+				log.Printf("mDNS reply to %s failed: %v", from, err)
+			}
+		}
+	}()
+	return nil
+}
+
+// asksFor reports whether a query asks for a service's instances, and
+// whether it wants a unicast reply.
+func asksFor(b []byte, service string) (unicast, ok bool) {
+	// Queries have the top bit of the flags clear
+	if len(b) < 12 || b[2]&0x80 != 0 {
+		return false, false
+	}
+	questions := int(b[4])<<8 | int(b[5])
+	off := 12
+	for i := 0; i < questions; i++ {
+		name, next, err := readName(b, off)
+		if err != nil || next+4 > len(b) {
+			return false, false
+		}
+		kind := int(b[next])<<8 | int(b[next+1])
+		class := int(b[next+2])<<8 | int(b[next+3])
+		if strings.EqualFold(name, service) && (kind == dnsPTR || kind == dnsANY) {
+			return class&0x8000 != 0, true
+		}
+		off = next + 4
+	}
+	return false, false
+}
+
+// answer builds the reply to a query: the service's PTR to the
+// instance, the instance's SRV to the host and port, and the host's A
+// records.
+func answer(query []byte, instance, service, host string, port int, ips []net.IP) []byte {
+	full := instance + "." + service
+	// The query's ID, a response with authority, and the answers
+	b := []byte{query[0], query[1], 0x84, 0, 0, 0, 0, byte(2 + len(ips)), 0, 0, 0, 0}
+	b = appendRecord(b, service, dnsPTR, 1, appendName(nil, full))
+	srv := []byte{0, 0, 0, 0, byte(port >> 8), byte(port)}
+	b = appendRecord(b, full, dnsSRV, dnsClassQU, appendName(srv, host))
+	for _, ip := range ips {
+		b = appendRecord(b, host, dnsA, dnsClassQU, ip.To4())
+	}
+	return b
+}
+
+func appendRecord(b []byte, name string, kind, class int, data []byte) []byte {
+	b = appendName(b, name)
+	b = append(b, byte(kind>>8), byte(kind), byte(class>>8), byte(class),
+		0, 0, ttl>>8, ttl&0xff, byte(len(data)>>8), byte(len(data)))
+	return append(b, data...)
+}
+
+// localIPs returns the host's IPv4 addresses, other than loopback.
+func localIPs() []net.IP {
+	addrs, err := net.InterfaceAddrs()
+	if err != nil {
+		return nil
+	}
+	var ips []net.IP
+	for _, a := range addrs {
+		if n, ok := a.(*net.IPNet); ok && !n.IP.IsLoopback() && n.IP.To4() != nil {
+			ips = append(ips, n.IP.To4())
+		}
+	}
+	return ips
+}
@@ -0,0 +1,199 @@
+// Package mdns finds services on the local network by multicast DNS,
+// and advertises them, with just enough of DNS to follow a service to
+// its instances' addresses.
+package mdns
+
+import (
+	"errors"
+	"net"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	dnsA   = 1
+	dnsPTR = 12
+	dnsSRV = 33
+	dnsANY = 255
+	// Class IN, with the top bit asking for a unicast reply
+	dnsClassQU = 0x8001
+)
+
+var mdnsGroup = &net.UDPAddr{IP: net.IPv4(224, 0, 0, 251), Port: 5353}
+
+// Browse asks the local network for instances of a service, such as
+// _ledbrick._udp.local, waiting up to wait for replies, and returns
+// their host:port addresses by instance name.
+func Browse(service string, wait time.Duration) (map[string]string, error) {
+	conn, err := net.ListenUDP("udp4", nil)
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close()
+	if _, err := conn.WriteTo(mdnsQuery(service), mdnsGroup); err != nil {
+		return nil, err
+	}
+
+	found := make(map[string]string)
+	conn.SetReadDeadline(time.Now().Add(wait))
+	buf := make([]byte, 9000)
+	for {
+		n, _, err := conn.ReadFrom(buf)
+		if err != nil {
+			// The deadline passing ends the browse
+			return found, nil
+		}
+		records, err := parseDNS(buf[:n])
+		if err != nil {
+			continue
+		}
+		for name, addr := range records.resolve(service) {
+			found[name] = addr
+		}
+	}
+}
+
+// mdnsQuery builds a query for the PTR records of a service.
+func mdnsQuery(service string) []byte {
+	// ID, flags, one question, no records
+	b := []byte{0, 0, 0, 0, 0, 1, 0, 0, 0, 0, 0, 0}
+	b = appendName(b, service)
+	return append(b, 0, dnsPTR, dnsClassQU>>8, dnsClassQU&0xff)
+}
+
+func appendName(b []byte, name string) []byte {
+	for _, label := range strings.Split(strings.TrimSuffix(name, "."), ".") {
+		b = append(b, byte(len(label)))
+		b = append(b, label...)
+	}
+	return append(b, 0)
+}
+
+type srvRecord struct {
+	target string
+	port   int
+}
+
+// dnsRecords are the records of a reply which browsing needs.
+type dnsRecords struct {
+	ptr map[string][]string
+	srv map[string]srvRecord
+	a   map[string]net.IP
+}
+
+// resolve follows a service's PTR records to instances, their SRV
+// records to hosts and ports, and the hosts' A records to addresses.
+func (r dnsRecords) resolve(service string) map[string]string {
+	found := make(map[string]string)
+	for _, instance := range r.ptr[service] {
+		srv, ok := r.srv[instance]
+		if !ok {
+			continue
+		}
+		host := srv.target
+		if ip, ok := r.a[host]; ok {
+			host = ip.String()
+		}
+		name := strings.TrimSuffix(instance, "."+service)
+		found[name] = net.JoinHostPort(host, strconv.Itoa(srv.port))
+	}
+	return found
+}
+
+var errShortDNS = errors.New("short DNS message")
+
+// parseDNS reads the PTR, SRV and A records from a DNS message.
+func parseDNS(b []byte) (dnsRecords, error) {
+	r := dnsRecords{ptr: make(map[string][]string),
+		srv: make(map[string]srvRecord),
+		a:   make(map[string]net.IP),
+	}
+	if len(b) < 12 {
+		return r, errShortDNS
+	}
+	questions := int(b[4])<<8 | int(b[5])
+	records := (int(b[6])<<8 | int(b[7])) + (int(b[8])<<8 | int(b[9])) + (int(b[10])<<8 | int(b[11]))
+	off := 12
+	for i := 0; i < questions; i++ {
+		_, next, err := readName(b, off)
+		if err != nil {
+			return r, err
+		}
+		off = next + 4
+	}
+	for i := 0; i < records; i++ {
+		name, next, err := readName(b, off)
+		if err != nil {
+			return r, err
+		}
+		if next+10 > len(b) {
+			return r, errShortDNS
+		}
+		kind := int(b[next])<<8 | int(b[next+1])
+		length := int(b[next+8])<<8 | int(b[next+9])
+		data := next + 10
+		if data+length > len(b) {
+			return r, errShortDNS
+		}
+		switch kind {
+		case dnsPTR:
+			target, _, err := readName(b, data)
+			if err != nil {
+				return r, err
+			}
+			r.ptr[name] = append(r.ptr[name], target)
+		case dnsSRV:
+			if length < 7 {
+				return r, errShortDNS
+			}
+			target, _, err := readName(b, data+6)
+			if err != nil {
+				return r, err
+			}
+			r.srv[name] = srvRecord{target: target, port: int(b[data+4])<<8 | int(b[data+5])}
+		case dnsA:
+			if length == 4 {
+				r.a[name] = net.IP(append([]byte(nil), b[data:data+4]...))
+			}
+		}
+		off = data + length
+	}
+	return r, nil
+}
+
+// readName reads a possibly compressed name at off, returning it and
+// the offset after it.
+func readName(b []byte, off int) (string, int, error) {
+	var labels []string
+	next := -1
+	for jumps := 0; jumps < 16; {
+		if off >= len(b) {
+			return "", 0, errShortDNS
+		}
+		l := int(b[off])
+		switch {
+		case l == 0:
+			if next < 0 {
+				next = off + 1
+			}
+			return strings.Join(labels, "."), next, nil
+		case l&0xc0 == 0xc0:
+			if off+1 >= len(b) {
+				return "", 0, errShortDNS
+			}
+			if next < 0 {
+				next = off + 2
+			}
+			off = (l&0x3f)<<8 | int(b[off+1])
+			jumps++
+		default:
+			if off+1+l > len(b) {
+				return "", 0, errShortDNS
+			}
+			labels = append(labels, string(b[off+1:off+1+l]))
+			off += 1 + l
+		}
+	}
+	return "", 0, errors.New("DNS name loops")
+}
@@ -0,0 +1,83 @@
+package mdns
+
+import (
+	"bytes"
+	"net"
+	"testing"
+)
+
+const wifiService = "_ledbrick._udp.local"
+
+func TestMDNSQuery(t *testing.T) {
+	q := mdnsQuery(wifiService)
+	want := append([]byte{0, 0, 0, 0, 0, 1, 0, 0, 0, 0, 0, 0},
+		"\x09_ledbrick\x04_udp\x05local\x00\x00\x0c\x80\x01"...)
+	if !bytes.Equal(q, want) {
+		t.Errorf("Expected % x, got % x", want, q)
+	}
+}
+
+func TestParseDNS(t *testing.T) {
+	// A reply with the service PTR, the instance SRV pointing back into
+	// it, and the host's A record
+	b := []byte{0, 0, 0x84, 0, 0, 0, 0, 3, 0, 0, 0, 0}
+	service := len(b)
+	b = appendName(b, wifiService)
+	b = append(b, 0, dnsPTR, 0, 1, 0, 0, 0, 120)
+	instance := len(b) + 2
+	b = append(b, 0, 10, 7)
+	b = append(b, "Kitchen"...)
+	b = append(b, 0xc0, byte(service))
+
+	b = append(b, 0xc0, byte(instance))
+	b = append(b, 0, dnsSRV, 0x80, 1, 0, 0, 0, 120, 0, 14, 0, 0, 0, 0, 0x10, 0x72)
+	host := len(b)
+	b = append(b, 5)
+	b = append(b, "light"...)
+	b = append(b, 0xc0, byte(service+15))
+
+	b = append(b, 0xc0, byte(host))
+	b = append(b, 0, dnsA, 0x80, 1, 0, 0, 0, 120, 0, 4, 192, 168, 1, 20)
+
+	r, err := parseDNS(b)
+	if err != nil {
+		t.Fatal(err)
+	}
+	found := r.resolve(wifiService)
+	if found["Kitchen"] != "192.168.1.20:4210" || len(found) != 1 {
+		t.Errorf("Expected Kitchen at 192.168.1.20:4210, got %v", found)
+	}
+
+	if _, err := parseDNS(b[:len(b)-3]); err == nil {
+		t.Error("Expected a truncated message to fail")
+	}
+	loop := []byte{0, 0, 0, 0, 0, 1, 0, 0, 0, 0, 0, 0, 0xc0, 12}
+	if _, err := parseDNS(loop); err == nil {
+		t.Error("Expected a looping name to fail")
+	}
+}
+
+func TestAnswer(t *testing.T) {
+	const service = "_ledbrick._tcp.local"
+	query := mdnsQuery(service)
+	unicast, ok := asksFor(query, service)
+	if !ok || !unicast {
+		t.Fatalf("Expected a unicast query for %s", service)
+	}
+	if _, ok := asksFor(mdnsQuery(wifiService), service); ok {
+		t.Errorf("Expected a query for another service to be ignored")
+	}
+
+	reply := answer(query, "Reef", service, "pi.local", 8080, []net.IP{net.IPv4(192, 168, 1, 30)})
+	if _, ok := asksFor(reply, service); ok {
+		t.Errorf("Expected a reply not to be taken for a query")
+	}
+	r, err := parseDNS(reply)
+	if err != nil {
+		t.Fatal(err)
+	}
+	found := r.resolve(service)
+	if found["Reef"] != "192.168.1.30:8080" || len(found) != 1 {
+		t.Errorf("Expected Reef at 192.168.1.30:8080, got %v", found)
+	}
+}
@@ -0,0 +1,162 @@
+package plugs
+
+import (
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+)
+
+const timeout = 5 * time.Second
+
+// kasa is a TP-Link Kasa plug, spoken to over its local TCP protocol on
+// port 9999: JSON with a length prefix, obscured with an autokey XOR.
+type kasa struct {
+	host string
+}
+
+func kasaEncrypt(b []byte) []byte {
+	out := make([]byte, 4+len(b))
+	binary.BigEndian.PutUint32(out, uint32(len(b)))
+	key := byte(171)
+	for i, v := range b {
+		key ^= v
+		out[4+i] = key
+	}
+	return out
+}
+
+func kasaDecrypt(b []byte) []byte {
+	out := make([]byte, len(b))
+	key := byte(171)
+	for i, v := range b {
+		out[i] = key ^ v
+		key = v
+	}
+	return out
+}
+
+func (k kasa) request(cmd string) ([]byte, error) {
+	host := k.host
+	if _, _, err := net.SplitHostPort(host); err != nil {
+		host = net.JoinHostPort(host, "9999")
+	}
+	conn, err := net.DialTimeout("tcp", host, timeout)
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close()
+	conn.SetDeadline(time.Now().Add(timeout))
+	if _, err := conn.Write(kasaEncrypt([]byte(cmd))); err != nil {
+		return nil, err
+	}
+	var length [4]byte
+	if _, err := io.ReadFull(conn, length[:]); err != nil {
+		return nil, err
+	}
+	n := binary.BigEndian.Uint32(length[:])
+	if n > 1<<16 {
+		return nil, errors.New("kasa: reply too long")
+	}
+	b := make([]byte, n)
+	if _, err := io.ReadFull(conn, b); err != nil {
+		return nil, err
+	}
+	return kasaDecrypt(b), nil
+}
+
+func (k kasa) Get() (bool, error) {
+	b, err := k.request(`{"system":{"get_sysinfo":{}}}`)
+	if err != nil {
+		return false, err
+	}
+	var reply struct {
+		System struct {
+			SysInfo struct {
+				RelayState *int `json:"relay_state"`
+			} `json:"get_sysinfo"`
+		} `json:"system"`
+	}
+	if err := json.Unmarshal(b, &reply); err != nil {
+		return false, err
+	}
+	if reply.System.SysInfo.RelayState == nil {
+		return false, errors.New("kasa: no relay state")
+	}
+	return *reply.System.SysInfo.RelayState == 1, nil
+}
+
+func (k kasa) Set(on bool) error {
+	state := 0
+	if on {
+		state = 1
+	}
+	b, err := k.request(fmt.Sprintf(`{"system":{"set_relay_state":{"state":%d}}}`, state))
+	if err != nil {
+		return err
+	}
+	var reply struct {
+		System struct {
+			SetRelayState struct {
+				ErrCode int `json:"err_code"`
+			} `json:"set_relay_state"`
+		} `json:"system"`
+	}
+	if err := json.Unmarshal(b, &reply); err != nil {
+		return err
+	}
+	if code := reply.System.SetRelayState.ErrCode; code != 0 {
+		return fmt.Errorf("kasa: error %d", code)
+	}
+	return nil
+}
+
+// tasmota is a plug running Tasmota, spoken to over its HTTP command
+// interface.
+type tasmota struct {
+	host string
+}
+
+var tasmotaClient = &http.Client{Timeout: timeout}
+
+func (t tasmota) command(cmd string) (bool, error) {
+	resp, err := tasmotaClient.Get("http://" + t.host + "/cm?cmnd=" + url.QueryEscape(cmd))
+	if err != nil {
+		return false, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return false, fmt.Errorf("tasmota: %s", resp.Status)
+	}
+	var reply struct {
+		Power string `json:"POWER"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&reply); err != nil {
+		return false, err
+	}
+	switch strings.ToUpper(reply.Power) {
+	case "ON":
+		return true, nil
+	case "OFF":
+		return false, nil
+	}
+	return false, errors.New("tasmota: no power state")
+}
+
+func (t tasmota) Get() (bool, error) {
+	return t.command("Power")
+}
+
+func (t tasmota) Set(on bool) error {
+	got, err := t.command("Power " + onOff(on))
+	if err == nil && got != on {
+		err = fmt.Errorf("tasmota: still %s", onOff(got))
+	}
+	return err
+}
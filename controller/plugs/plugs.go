@@ -0,0 +1,349 @@
+// Package plugs switches smart plugs while lighting scenes such as feed
+// mode are active, so other tank equipment goes along with the lights:
+// a skimmer's light off, or a pump on a slower mode. Every plug switched
+// is put back how it was when the scene ends, retrying until it is, and
+// across a restart with plugs.state.
+package plugs
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/theatrus/ledbrick/controller/alert"
+	"github.com/theatrus/ledbrick/controller/atomicfile"
+	"github.com/theatrus/ledbrick/controller/clock"
+)
+
+var configFile string
+var maxActive time.Duration
+var retry time.Duration
+var stateFile string
+
+func init() {
+	flag.StringVar(&configFile, "plugs.config", "",
+		"JSON file of the smart plugs to switch while each scene, such as feed, is active")
+	flag.DurationVar(&maxActive, "plugs.max", 30*time.Minute,
+		"Longest a scene may keep plugs switched before they are put back anyway")
+	flag.DurationVar(&retry, "plugs.retry", 30*time.Second,
+		"How often putting a plug back is retried until it works")
+	flag.StringVar(&stateFile, "plugs.state", "",
+		"File recording plugs switched by a scene and not yet put back, so they are put back after a restart, none if empty")
+}
+
+// plug is a smart plug's relay.
+type plug interface {
+	Get() (bool, error)
+	Set(on bool) error
+}
+
+// Action switches one plug while a scene is active.
+type Action struct {
+	// Type is kasa or tasmota
+	Type string `json:"type"`
+	Host string `json:"host"`
+	// On is the state the plug is switched to
+	On bool `json:"on"`
+
+	plug plug
+}
+
+func (a *Action) String() string { return a.Type + " " + a.Host }
+
+// scene is the plugs switched for one scene, and how they were before.
+type scene struct {
+	actions []*Action
+	active  bool
+	prior   map[*Action]bool
+	expire  clock.Timer
+}
+
+// Coordinator switches plugs as scenes start and end.
+type Coordinator struct {
+	clock  clock.Clock
+	scenes map[string]*scene
+	// pending are plugs still to be put back, and the state to put
+	// them back to
+	pending map[*Action]bool
+	lock    sync.Mutex
+	// state is the file plugs still to be put back are saved in
+	state string
+}
+
+// owed is a plug still to be put back, as saved in the state file.
+type owed struct {
+	Type string `json:"type"`
+	Host string `json:"host"`
+	On   bool   `json:"on"`
+}
+
+var coordinator *Coordinator
+
+// Start loads the plugs for each scene. Without a config scenes switch
+// nothing.
+func Start() error {
+	if configFile == "" {
+		return nil
+	}
+	data, err := ioutil.ReadFile(configFile)
+	if err != nil {
+		return err
+	}
+	var config map[string][]*Action
+	if err := json.Unmarshal(data, &config); err != nil {
+		return fmt.Errorf("%s: %v", configFile, err)
+	}
+	c, err := newCoordinator(config, clock.Real)
+	if err != nil {
+		return fmt.Errorf("%s: %v", configFile, err)
+	}
+	c.state = stateFile
+	if err := c.resume(); err != nil {
+		return fmt.Errorf("%s: %v", stateFile, err)
+	}
+	coordinator = c
+	return nil
+}
+
+func newCoordinator(config map[string][]*Action, c clock.Clock) (*Coordinator, error) {
+	co := &Coordinator{clock: c,
+		scenes:  make(map[string]*scene),
+		pending: make(map[*Action]bool),
+	}
+	for name, actions := range config {
+		for _, a := range actions {
+			if err := a.connect(); err != nil {
+				return nil, fmt.Errorf("scene %s: %v", name, err)
+			}
+		}
+		co.scenes[name] = &scene{actions: actions}
+	}
+	return co, nil
+}
+
+// connect makes the action's plug from its type, unless it has one.
+func (a *Action) connect() error {
+	if a.plug != nil {
+		return nil
+	}
+	switch a.Type {
+	case "kasa":
+		a.plug = kasa{a.Host}
+	case "tasmota":
+		a.plug = tasmota{a.Host}
+	default:
+		return fmt.Errorf("unknown plug type %q", a.Type)
+	}
+	return nil
+}
+
+// resume puts back the plugs the state file says were left switched
+// when the controller last stopped.
+func (c *Coordinator) resume() error {
+	if c.state == "" {
+		return nil
+	}
+	data, err := ioutil.ReadFile(c.state)
+	if os.IsNotExist(err) {
+		return nil
+	} else if err != nil {
+		return err
+	}
+	var saved []owed
+	if err := json.Unmarshal(data, &saved); err != nil {
+		return err
+	}
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	for _, o := range saved {
+		a := c.find(o.Type, o.Host)
+		if a == nil {
+			// No longer configured, but still switched
+			a = &Action{Type: o.Type, Host: o.Host}
+			if err := a.connect(); err != nil {
+				log.Printf("Plug %s %s left switched before the restart: %v", o.Type, o.Host, err)
+				continue
+			}
+		}
+		c.restore(a, o.On, "a restart", false)
+	}
+	c.save()
+	return nil
+}
+
+// find returns the configured action for a plug, if any. The caller
+// must hold the lock.
+func (c *Coordinator) find(typ, host string) *Action {
+	for _, s := range c.scenes {
+		for _, a := range s.actions {
+			if a.Type == typ && a.Host == host {
+				return a
+			}
+		}
+	}
+	return nil
+}
+
+// save records every plug switched by a scene and not yet put back,
+// written aside and renamed so a crash mid-write can't lose it. The
+// caller must hold the lock.
+func (c *Coordinator) save() {
+	if c.state == "" {
+		return
+	}
+	saved := []owed{}
+	for _, s := range c.scenes {
+		for a, on := range s.prior {
+			saved = append(saved, owed{Type: a.Type, Host: a.Host, On: on})
+		}
+	}
+	for a, on := range c.pending {
+		saved = append(saved, owed{Type: a.Type, Host: a.Host, On: on})
+	}
+	data, err := json.Marshal(saved)
+	if err != nil {
+		log.Printf("Failed to encode plug state: %v", err)
+		return
+	}
+	if err := atomicfile.WriteFile(c.state, data, 0644); err != nil {
+		log.Printf("Failed to save plug state: %v", err)
+	}
+}
+
+// Activate switches the plugs for a scene, if any are configured.
+func Activate(name string) {
+	if coordinator != nil {
+		coordinator.Activate(name)
+	}
+}
+
+// Deactivate puts back the plugs for a scene.
+func Deactivate(name string) {
+	if coordinator != nil {
+		coordinator.Deactivate(name)
+	}
+}
+
+// RestoreAll puts back the plugs of every active scene, before the
+// controller stops.
+func RestoreAll() {
+	if coordinator != nil {
+		coordinator.RestoreAll()
+	}
+}
+
+// Activate switches a scene's plugs, remembering how each was. A plug
+// whose state can't be read is left alone, as it couldn't be put back.
+// The plugs are put back after plugs.max even if the scene is still
+// active, so equipment isn't left off by a scene which never ends.
+func (c *Coordinator) Activate(name string) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	s, ok := c.scenes[name]
+	if !ok || s.active {
+		return
+	}
+	s.active = true
+	s.prior = make(map[*Action]bool)
+	for _, a := range s.actions {
+		// A plug not put back yet is still as the last activation
+		// left it, so keep what it was before that
+		on, pending := c.pending[a]
+		delete(c.pending, a)
+		var err error
+		if !pending {
+			on, err = a.plug.Get()
+		}
+		if err != nil {
+			alert.Raise(alert.Warning, a.Host, "plug.switch",
+				"%s: not switched for %s, its state couldn't be read: %v", a, name, err)
+			continue
+		}
+		if err := a.plug.Set(a.On); err != nil {
+			alert.Raise(alert.Warning, a.Host, "plug.switch", "%s: not switched for %s: %v", a, name, err)
+			continue
+		}
+		s.prior[a] = on
+		log.Printf("Plug %s switched %s for %s", a, onOff(a.On), name)
+	}
+	s.expire = c.clock.AfterFunc(maxActive, func() {
+		log.Printf("Scene %s has had plugs switched for %s, putting them back", name, maxActive)
+		c.Deactivate(name)
+	})
+	c.save()
+}
+
+// Deactivate puts a scene's plugs back how they were, retrying any
+// which fail until they succeed.
+func (c *Coordinator) Deactivate(name string) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	s, ok := c.scenes[name]
+	if !ok || !s.active {
+		return
+	}
+	s.active = false
+	s.expire.Stop()
+	prior := s.prior
+	s.prior = nil
+	for a, on := range prior {
+		c.restore(a, on, name, false)
+	}
+	c.save()
+}
+
+// RestoreAll puts back the plugs of every active scene. Any which fail
+// stay in the state file, to be put back when the controller starts
+// again.
+func (c *Coordinator) RestoreAll() {
+	c.lock.Lock()
+	var active []string
+	for name, s := range c.scenes {
+		if s.active {
+			active = append(active, name)
+		}
+	}
+	c.lock.Unlock()
+	for _, name := range active {
+		c.Deactivate(name)
+	}
+}
+
+// restore puts a plug back, trying again every plugs.retry until it
+// works. The caller must hold the lock.
+func (c *Coordinator) restore(a *Action, on bool, name string, retrying bool) {
+	if err := a.plug.Set(on); err != nil {
+		if !retrying {
+			alert.Raise(alert.Critical, a.Host, "plug.restore",
+				"%s: not put back %s after %s, retrying: %v", a, onOff(on), name, err)
+		}
+		c.pending[a] = on
+		c.clock.AfterFunc(retry, func() {
+			c.lock.Lock()
+			defer c.lock.Unlock()
+			// The scene starting again takes the plug over
+			if _, ok := c.pending[a]; ok {
+				c.restore(a, on, name, true)
+				c.save()
+			}
+		})
+		return
+	}
+	delete(c.pending, a)
+	if retrying {
+		alert.Raise(alert.Info, a.Host, "plug.restore", "%s: put back %s after %s", a, onOff(on), name)
+	}
+	log.Printf("Plug %s put back %s after %s", a, onOff(on), name)
+}
+
+func onOff(on bool) string {
+	if on {
+		return "on"
+	}
+	return "off"
+}
@@ -0,0 +1,206 @@
+package plugs
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/theatrus/ledbrick/controller/clock"
+)
+
+// fakePlug is a relay which fails while err is set.
+type fakePlug struct {
+	on   bool
+	err  error
+	sets int
+}
+
+func (p *fakePlug) Get() (bool, error) { return p.on, p.err }
+
+func (p *fakePlug) Set(on bool) error {
+	p.sets++
+	if p.err != nil {
+		return p.err
+	}
+	p.on = on
+	return nil
+}
+
+func newTest(t *testing.T, plugs ...*fakePlug) (*Coordinator, *clock.Fake) {
+	var actions []*Action
+	for i, p := range plugs {
+		actions = append(actions, &Action{Type: "fake", Host: fmt.Sprint("plug", i), On: false, plug: p})
+	}
+	c := clock.NewFake(time.Date(2016, 1, 1, 12, 0, 0, 0, time.UTC))
+	co, err := newCoordinator(map[string][]*Action{"feed": actions}, c)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return co, c
+}
+
+func TestScene(t *testing.T) {
+	skimmer, pump := &fakePlug{on: true}, &fakePlug{on: false}
+	co, c := newTest(t, skimmer, pump)
+
+	co.Activate("storm")
+	co.Activate("feed")
+	if skimmer.on || pump.on {
+		t.Error("Expected both plugs off during feed")
+	}
+	co.Deactivate("feed")
+	if !skimmer.on || pump.on {
+		t.Error("Expected each plug put back how it was")
+	}
+
+	// Plugs are put back after plugs.max even if the scene goes on
+	co.Activate("feed")
+	c.Advance(maxActive)
+	if !skimmer.on {
+		t.Error("Expected the skimmer back on after plugs.max")
+	}
+}
+
+func TestRestoreRetry(t *testing.T) {
+	skimmer := &fakePlug{on: true}
+	co, c := newTest(t, skimmer)
+	co.Activate("feed")
+	skimmer.err = errors.New("unreachable")
+	co.Deactivate("feed")
+	if skimmer.on {
+		t.Fatal("Expected the failing plug to stay off")
+	}
+	c.Advance(retry)
+	if skimmer.sets != 3 {
+		t.Errorf("Expected a retry, got %d sets", skimmer.sets)
+	}
+
+	// Starting again while a plug is still to be put back keeps what
+	// it was before the first time
+	skimmer.err = nil
+	co.Activate("feed")
+	co.Deactivate("feed")
+	if !skimmer.on {
+		t.Error("Expected the skimmer back on")
+	}
+	sets := skimmer.sets
+	c.Advance(retry)
+	if skimmer.sets != sets {
+		t.Error("Expected no retry once the plug is back")
+	}
+
+	// A plug which can't be read isn't switched
+	unread := &fakePlug{on: true, err: errors.New("timeout")}
+	co, _ = newTest(t, unread)
+	co.Activate("feed")
+	if unread.sets != 0 {
+		t.Error("Expected a plug whose state couldn't be read to be left alone")
+	}
+}
+
+func TestUnknownPlug(t *testing.T) {
+	_, err := newCoordinator(map[string][]*Action{"feed": {{Type: "x10"}}}, clock.Real)
+	if err == nil {
+		t.Error("Expected an unknown plug type to fail")
+	}
+}
+
+func TestKasa(t *testing.T) {
+	if got := string(kasaDecrypt(kasaEncrypt([]byte("hello"))[4:])); got != "hello" {
+		t.Errorf("Expected the round trip to decrypt, got %q", got)
+	}
+
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+	go func() {
+		for {
+			conn, err := l.Accept()
+			if err != nil {
+				return
+			}
+			var length [4]byte
+			io.ReadFull(conn, length[:])
+			b := make([]byte, int(length[3]))
+			io.ReadFull(conn, b)
+			reply := `{"system":{"set_relay_state":{"err_code":0}}}`
+			if strings.Contains(string(kasaDecrypt(b)), "get_sysinfo") {
+				reply = `{"system":{"get_sysinfo":{"relay_state":1}}}`
+			}
+			conn.Write(kasaEncrypt([]byte(reply)))
+			conn.Close()
+		}
+	}()
+	k := kasa{l.Addr().String()}
+	if on, err := k.Get(); err != nil || !on {
+		t.Errorf("Expected the plug on, got %v %v", on, err)
+	}
+	if err := k.Set(false); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestTasmota(t *testing.T) {
+	power := "ON"
+	s := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Query().Get("cmnd") {
+		case "Power off":
+			power = "OFF"
+		case "Power on":
+			power = "ON"
+		}
+		w.Write([]byte(`{"POWER":"` + power + `"}`))
+	}))
+	defer s.Close()
+	p := tasmota{strings.TrimPrefix(s.URL, "http://")}
+	if on, err := p.Get(); err != nil || !on {
+		t.Errorf("Expected the plug on, got %v %v", on, err)
+	}
+	if err := p.Set(false); err != nil || power != "OFF" {
+		t.Errorf("Expected the plug switched off, got %s %v", power, err)
+	}
+}
+
+func TestRestoreAll(t *testing.T) {
+	dir, err := ioutil.TempDir("", "plugs")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	skimmer, pump := &fakePlug{on: true}, &fakePlug{on: true}
+	co, _ := newTest(t, skimmer, pump)
+	co.state = filepath.Join(dir, "plugs.json")
+	co.Activate("feed")
+
+	// A plug which can't be put back at shutdown is saved
+	pump.err = errors.New("unreachable")
+	co.RestoreAll()
+	if !skimmer.on || pump.on {
+		t.Fatal("Expected the skimmer put back and the pump still off")
+	}
+
+	// and put back on the next start
+	pump.err = nil
+	next, _ := newTest(t, skimmer, pump)
+	next.state = co.state
+	if err := next.resume(); err != nil {
+		t.Fatal(err)
+	}
+	if !pump.on {
+		t.Error("Expected the pump put back after the restart")
+	}
+	if data, _ := ioutil.ReadFile(co.state); string(data) != "[]" {
+		t.Errorf("Expected nothing left to put back, got %s", data)
+	}
+}
@@ -0,0 +1,218 @@
+package probe
+
+import (
+	"encoding/json"
+	"errors"
+	"flag"
+	"io/ioutil"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/theatrus/ledbrick/controller/alert"
+	"github.com/theatrus/ledbrick/controller/ble"
+	"github.com/theatrus/ledbrick/controller/units"
+)
+
+var w1Dir string
+var configFile string
+var pollInterval time.Duration
+
+func init() {
+	flag.StringVar(&w1Dir, "probe.dir", "/sys/bus/w1/devices",
+		"Directory of 1-wire devices exposed by the w1-therm kernel driver")
+	flag.StringVar(&configFile, "probe.config", "",
+		"JSON file of temperature probe settings keyed by 1-wire ID, probes disabled if empty")
+	flag.DurationVar(&pollInterval, "probe.interval", 30*time.Second,
+		"How often to read temperature probes")
+}
+
+// Config describes a single temperature probe. A zero limit is unset.
+// Limits are in Celsius, or given with their unit as "80F".
+type Config struct {
+	Name string        `json:"name"`
+	Min  units.Celsius `json:"min"`
+	Max  units.Celsius `json:"max"`
+
+	// Above this temperature all fixtures are capped at ThrottlePercent
+	ThrottleAbove   units.Celsius `json:"throttle_above"`
+	ThrottlePercent float64       `json:"throttle_percent"`
+}
+
+// Reading is the last value read from a probe.
+type Reading struct {
+	ID      string    `json:"id"`
+	Name    string    `json:"name"`
+	Celsius float64   `json:"celsius"`
+	At      time.Time `json:"at"`
+	Error   string    `json:"error,omitempty"`
+}
+
+type probeState struct {
+	reading   Reading
+	low       bool
+	high      bool
+	throttled bool
+	failed    bool
+}
+
+// Probes polls the configured 1-wire temperature sensors on the
+// controller host.
+type Probes struct {
+	ble     ble.BLEChannel
+	configs map[string]Config
+	states  map[string]*probeState
+	lock    sync.Mutex
+}
+
+// Start loads the probe configuration and begins polling. It returns
+// nil if no probes are configured.
+func Start(ble ble.BLEChannel) (*Probes, error) {
+	if configFile == "" {
+		return nil, nil
+	}
+	data, err := ioutil.ReadFile(configFile)
+	if err != nil {
+		return nil, err
+	}
+	configs := make(map[string]Config)
+	if err := json.Unmarshal(data, &configs); err != nil {
+		return nil, err
+	}
+
+	p := &Probes{ble: ble,
+		configs: configs,
+		states:  make(map[string]*probeState),
+	}
+	for id, c := range configs {
+		if c.Name == "" {
+			c.Name = id
+			configs[id] = c
+		}
+		p.states[id] = &probeState{reading: Reading{ID: id, Name: c.Name}}
+	}
+
+	go func() {
+		p.poll()
+		for _ = range time.Tick(pollInterval) {
+			p.poll()
+		}
+	}()
+	return p, nil
+}
+
+// Readings returns the last reading from every probe, sorted by name.
+func (p *Probes) Readings() []Reading {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+	readings := make([]Reading, 0, len(p.states))
+	for _, s := range p.states {
+		readings = append(readings, s.reading)
+	}
+	sort.Sort(byName(readings))
+	return readings
+}
+
+type byName []Reading
+
+func (r byName) Len() int           { return len(r) }
+func (r byName) Swap(i, j int)      { r[i], r[j] = r[j], r[i] }
+func (r byName) Less(i, j int) bool { return r[i].Name < r[j].Name }
+
+func (p *Probes) poll() {
+	for id, c := range p.configs {
+		celsius, err := read(id)
+		p.lock.Lock()
+		p.update(id, c, celsius, err, time.Now())
+		p.lock.Unlock()
+	}
+}
+
+// update records a reading and acts on any limits it crosses. The
+// caller must hold the lock.
+func (p *Probes) update(id string, c Config, celsius float64, err error, now time.Time) {
+	s := p.states[id]
+	if err != nil {
+		s.reading.Error = err.Error()
+		if !s.failed {
+			s.failed = true
+			alert.Raise(alert.Warning, c.Name, "probe.failed", "failed to read probe %s: %v", id, err)
+		}
+		return
+	}
+	if s.failed {
+		s.failed = false
+		alert.Raise(alert.Info, c.Name, "probe.failed", "probe %s reading again", id)
+	}
+	s.reading = Reading{ID: id, Name: c.Name, Celsius: celsius, At: now}
+
+	low := c.Min != 0 && celsius < float64(c.Min)
+	if low != s.low {
+		s.low = low
+		if low {
+			alert.Raise(alert.Critical, c.Name, "probe.low", "%s is below %s",
+				units.Format(celsius, 2), units.Format(float64(c.Min), 2))
+		} else {
+			alert.Raise(alert.Info, c.Name, "probe.low", "back to %s", units.Format(celsius, 2))
+		}
+	}
+	high := c.Max != 0 && celsius > float64(c.Max)
+	if high != s.high {
+		s.high = high
+		if high {
+			alert.Raise(alert.Critical, c.Name, "probe.high", "%s is above %s",
+				units.Format(celsius, 2), units.Format(float64(c.Max), 2))
+		} else {
+			alert.Raise(alert.Info, c.Name, "probe.high", "back to %s", units.Format(celsius, 2))
+		}
+	}
+
+	throttle := c.ThrottleAbove != 0 && celsius > float64(c.ThrottleAbove)
+	if throttle != s.throttled && p.ble != nil {
+		s.throttled = throttle
+		name := "probe:" + c.Name
+		if throttle {
+			p.ble.SetLimit(name, c.ThrottlePercent)
+		} else {
+			p.ble.ClearLimit(name)
+		}
+	}
+}
+
+func read(id string) (float64, error) {
+	data, err := ioutil.ReadFile(filepath.Join(w1Dir, id, "w1_slave"))
+	if err != nil {
+		return 0, err
+	}
+	return parseW1Slave(data)
+}
+
+// parseW1Slave decodes the w1-therm driver output, which looks like:
+//
+//	72 01 4b 46 7f ff 0e 10 57 : crc=57 YES
+//	72 01 4b 46 7f ff 0e 10 57 t=23125
+func parseW1Slave(data []byte) (float64, error) {
+	lines := strings.Split(strings.TrimSpace(string(data)), "\n")
+	if len(lines) != 2 {
+		return 0, errors.New("unexpected w1_slave format")
+	}
+	if !strings.HasSuffix(strings.TrimSpace(lines[0]), "YES") {
+		return 0, errors.New("probe CRC check failed")
+	}
+	i := strings.Index(lines[1], "t=")
+	if i < 0 {
+		return 0, errors.New("no temperature in w1_slave")
+	}
+	milli, err := strconv.Atoi(strings.TrimSpace(lines[1][i+2:]))
+	if err != nil {
+		return 0, err
+	}
+	// The DS18B20 reports 85 C on power-on reset before a conversion
+	if milli == 85000 {
+		return 0, errors.New("probe returned power-on reset value")
+	}
+	return float64(milli) / 1000.0, nil
+}
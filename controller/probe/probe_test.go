@@ -0,0 +1,25 @@
+package probe
+
+import "testing"
+
+func TestParseW1Slave(t *testing.T) {
+	good := "72 01 4b 46 7f ff 0e 10 57 : crc=57 YES\n72 01 4b 46 7f ff 0e 10 57 t=23125\n"
+	c, err := parseW1Slave([]byte(good))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if c != 23.125 {
+		t.Errorf("Expected 23.125, got %f", c)
+	}
+
+	bad := []string{
+		"72 01 4b 46 7f ff 0e 10 57 : crc=57 NO\n72 01 4b 46 7f ff 0e 10 57 t=23125\n",
+		"72 01 4b 46 7f ff 0e 10 57 : crc=57 YES\n72 01 4b 46 7f ff 0e 10 57 t=85000\n",
+		"garbage",
+	}
+	for _, b := range bad {
+		if _, err := parseW1Slave([]byte(b)); err == nil {
+			t.Errorf("Expected error for %q", b)
+		}
+	}
+}
@@ -0,0 +1,238 @@
+// Package push sends alerts to phones through ntfy or Gotify. Each
+// notification's priority follows the alert's severity, and ntfy ones
+// carry buttons calling back into the API to acknowledge the alert or
+// put the tank into maintenance mode, so an alert can be answered with
+// one tap. Each button is a signed URL good for one use within an
+// hour, so no API key ever passes through the push service.
+package push
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/theatrus/ledbrick/controller/alert"
+	"github.com/theatrus/ledbrick/controller/wan"
+)
+
+var ntfyURL string
+var ntfyToken string
+var gotifyURL string
+var gotifyToken string
+var minSeverity string
+var apiURL string
+var actionKey string
+
+func init() {
+	flag.StringVar(&ntfyURL, "push.ntfy", "",
+		"ntfy topic URL to push alerts to, e.g. https://ntfy.sh/my-reef")
+	flag.StringVar(&ntfyToken, "push.ntfy.token", "", "Access token for the ntfy topic, if it needs one")
+	flag.StringVar(&gotifyURL, "push.gotify", "",
+		"Gotify server URL to push alerts to, e.g. https://gotify.example.com")
+	flag.StringVar(&gotifyToken, "push.gotify.token", "", "Gotify application token")
+	flag.StringVar(&minSeverity, "push.severity", "warning",
+		"Least severe alerts to push: info, warning or critical")
+	flag.StringVar(&apiURL, "push.api", "",
+		"URL the phone reaches this controller's API at, for action buttons, none if empty")
+	flag.StringVar(&actionKey, "push.api.key", "",
+		"Secret signing action button URLs, no buttons if empty")
+}
+
+var client = &http.Client{Timeout: 10 * time.Second}
+
+// ntfyPriorities and gotifyPriorities map each severity onto the
+// provider's scale: ntfy's 1 to 5, where 5 overrides do not disturb,
+// and Gotify's 0 to 10, where Android raises 8 and up as a heads-up.
+var ntfyPriorities = map[alert.Severity]int{alert.Info: 2, alert.Warning: 4, alert.Critical: 5}
+var gotifyPriorities = map[alert.Severity]int{alert.Info: 2, alert.Warning: 5, alert.Critical: 8}
+
+var ntfyTags = map[alert.Severity]string{alert.Info: "information_source", alert.Warning: "warning", alert.Critical: "rotating_light"}
+
+// notifier pushes alerts at or above a severity.
+type notifier struct {
+	min  alert.Severity
+	send func(a alert.Alert) error
+}
+
+func (n notifier) Notify(a alert.Alert) error {
+	if a.Severity < n.min {
+		return nil
+	}
+	return n.send(a)
+}
+
+func parseSeverity(s string) (alert.Severity, error) {
+	for _, sev := range []alert.Severity{alert.Info, alert.Warning, alert.Critical} {
+		if sev.String() == s {
+			return sev, nil
+		}
+	}
+	return 0, fmt.Errorf("bad -push.severity %q, expected info, warning or critical", s)
+}
+
+// Start registers a notifier for each provider configured.
+func Start() error {
+	min, err := parseSeverity(minSeverity)
+	if err != nil {
+		return err
+	}
+	if gotifyURL != "" && gotifyToken == "" {
+		return errors.New("-push.gotify needs -push.gotify.token")
+	}
+	if ntfyURL != "" {
+		alert.Register(notifier{min: min, send: ntfy})
+	}
+	if gotifyURL != "" {
+		alert.Register(notifier{min: min, send: gotify})
+	}
+	return nil
+}
+
+func title(a alert.Alert) string {
+	return fmt.Sprintf("LEDBrick %s: %s", a.Severity, a.Source)
+}
+
+// Actions a button can take.
+const (
+	Ack         = "ack"
+	Maintenance = "maintenance"
+)
+
+// actionLifetime is how long a button works after its alert is pushed.
+const actionLifetime = time.Hour
+
+var now = time.Now
+
+// signature is the HMAC of a button's alert, action and expiry.
+func signature(id int, action string, expires int64) string {
+	mac := hmac.New(sha256.New, []byte(actionKey))
+	fmt.Fprintf(mac, "%d\n%s\n%d", id, action, expires)
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// actionURL is the signed URL a button POSTs to.
+func actionURL(id int, action string) string {
+	expires := now().Add(actionLifetime).Unix()
+	return fmt.Sprintf("%s/push/%s/%d?expires=%d&sig=%s", strings.TrimSuffix(apiURL, "/"),
+		action, id, expires, signature(id, action, expires))
+}
+
+// used holds signatures already redeemed, until they expire.
+var used = struct {
+	sync.Mutex
+	sigs map[string]int64
+}{sigs: map[string]int64{}}
+
+var errBadAction = errors.New("action link invalid, expired or already used")
+
+// Redeem checks the parts of a button's signed URL, failing unless the
+// signature matches and is neither expired nor used before.
+func Redeem(action string, id int, expires, sig string) error {
+	exp, err := strconv.ParseInt(expires, 10, 64)
+	if actionKey == "" || err != nil || now().Unix() > exp ||
+		!hmac.Equal([]byte(sig), []byte(signature(id, action, exp))) {
+		return errBadAction
+	}
+	used.Lock()
+	defer used.Unlock()
+	for s, e := range used.sigs {
+		if now().Unix() > e {
+			delete(used.sigs, s)
+		}
+	}
+	if _, ok := used.sigs[sig]; ok {
+		return errBadAction
+	}
+	used.sigs[sig] = exp
+	return nil
+}
+
+// actions returns ntfy's header for the alert's buttons: acknowledge,
+// and for anything but info, maintenance mode. Each is an HTTP request
+// the ntfy app makes straight to the API, authorized by its signature
+// alone. Without -push.api.key there are no buttons.
+func actions(a alert.Alert) string {
+	if apiURL == "" || actionKey == "" {
+		return ""
+	}
+	buttons := []string{fmt.Sprintf("http, Acknowledge, %s, method=POST, clear=true", actionURL(a.ID, Ack))}
+	if a.Severity > alert.Info {
+		buttons = append(buttons, fmt.Sprintf("http, Maintenance mode, %s, method=POST", actionURL(a.ID, Maintenance)))
+	}
+	return strings.Join(buttons, "; ")
+}
+
+func ntfy(a alert.Alert) error {
+	req, err := http.NewRequest("POST", ntfyURL, strings.NewReader(a.Message))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Title", title(a))
+	req.Header.Set("Priority", fmt.Sprint(ntfyPriorities[a.Severity]))
+	req.Header.Set("Tags", ntfyTags[a.Severity])
+	if act := actions(a); act != "" {
+		req.Header.Set("Actions", act)
+	}
+	if ntfyToken != "" {
+		req.Header.Set("Authorization", "Bearer "+ntfyToken)
+	}
+	return do(req)
+}
+
+// gotify pushes an alert as a Gotify message. Gotify has no action
+// buttons, so tapping it opens the API's alerts instead.
+func gotify(a alert.Alert) error {
+	msg := map[string]interface{}{
+		"title":    title(a),
+		"message":  a.Message,
+		"priority": gotifyPriorities[a.Severity],
+	}
+	if apiURL != "" {
+		msg["extras"] = map[string]interface{}{
+			"client::notification": map[string]interface{}{
+				"click": map[string]string{"url": strings.TrimSuffix(apiURL, "/") + "/alerts"},
+			},
+		}
+	}
+	data, err := json.Marshal(msg)
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequest("POST", strings.TrimSuffix(gotifyURL, "/")+"/message", bytes.NewReader(data))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("X-Gotify-Key", gotifyToken)
+	return do(req)
+}
+
+// do sends a push, reporting whether it got through. Alerts not
+// pushed are still listed by the API.
+func do(req *http.Request) error {
+	err := send(req)
+	wan.Report("push", err, "alerts only listed by the API")
+	return err
+}
+
+func send(req *http.Request) error {
+	resp, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	if resp.StatusCode/100 != 2 {
+		return fmt.Errorf("push to %s: %s", req.URL.Host, resp.Status)
+	}
+	return nil
+}
@@ -0,0 +1,127 @@
+package push
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/theatrus/ledbrick/controller/alert"
+)
+
+// capture records the last request a test server got.
+type capture struct {
+	header http.Header
+	path   string
+	body   string
+}
+
+func serve(c *capture) *httptest.Server {
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := ioutil.ReadAll(r.Body)
+		c.header, c.path, c.body = r.Header, r.URL.Path, string(body)
+	}))
+}
+
+func TestNtfy(t *testing.T) {
+	var c capture
+	srv := serve(&c)
+	defer srv.Close()
+	defer func() { ntfyURL, apiURL, actionKey = "", "", "" }()
+	ntfyURL, apiURL, actionKey = srv.URL+"/reef", "https://reef.example/", "secret"
+
+	a := alert.Alert{ID: 7, Severity: alert.Critical, Source: "f1", Message: "fan failed"}
+	if err := ntfy(a); err != nil {
+		t.Fatal(err)
+	}
+	if c.path != "/reef" || c.body != "fan failed" || c.header.Get("Priority") != "5" {
+		t.Errorf("Unexpected push %s %q priority %s", c.path, c.body, c.header.Get("Priority"))
+	}
+	act := c.header.Get("Actions")
+	if !strings.Contains(act, "https://reef.example/push/ack/7?expires=") ||
+		!strings.Contains(act, "/push/maintenance/7?") || strings.Contains(act, "secret") {
+		t.Errorf("Unexpected actions %q", act)
+	}
+
+	a.Severity = alert.Info
+	ntfy(a)
+	if strings.Contains(c.header.Get("Actions"), "maintenance") {
+		t.Errorf("Expected no maintenance button for info, got %q", c.header.Get("Actions"))
+	}
+}
+
+func TestActionsNeedKey(t *testing.T) {
+	defer func() { apiURL = "" }()
+	apiURL = "https://reef.example/"
+	if act := actions(alert.Alert{ID: 7, Severity: alert.Critical}); act != "" {
+		t.Errorf("Expected no buttons without a signing key, got %q", act)
+	}
+}
+
+func TestRedeem(t *testing.T) {
+	defer func(n func() time.Time) { now, apiURL, actionKey = n, "", "" }(now)
+	at := time.Unix(1000, 0)
+	now = func() time.Time { return at }
+	apiURL, actionKey = "https://reef.example", "secret"
+
+	u, err := url.Parse(actionURL(7, Ack))
+	if err != nil {
+		t.Fatal(err)
+	}
+	exp, sig := u.Query().Get("expires"), u.Query().Get("sig")
+	if err := Redeem(Maintenance, 7, exp, sig); err == nil {
+		t.Error("Expected an ack signature to fail for maintenance")
+	}
+	if err := Redeem(Ack, 8, exp, sig); err == nil {
+		t.Error("Expected the signature to fail for another alert")
+	}
+	if err := Redeem(Ack, 7, "99999", sig); err == nil {
+		t.Error("Expected a changed expiry to fail")
+	}
+	if err := Redeem(Ack, 7, exp, sig); err != nil {
+		t.Fatal(err)
+	}
+	if err := Redeem(Ack, 7, exp, sig); err == nil {
+		t.Error("Expected a second use to fail")
+	}
+
+	u, _ = url.Parse(actionURL(7, Maintenance))
+	at = at.Add(actionLifetime + time.Second)
+	if err := Redeem(Maintenance, 7, u.Query().Get("expires"), u.Query().Get("sig")); err == nil {
+		t.Error("Expected an expired link to fail")
+	}
+}
+
+func TestGotify(t *testing.T) {
+	var c capture
+	srv := serve(&c)
+	defer srv.Close()
+	defer func() { gotifyURL, gotifyToken = "", "" }()
+	gotifyURL, gotifyToken = srv.URL, "app"
+
+	if err := gotify(alert.Alert{Severity: alert.Warning, Source: "f1", Message: "hot"}); err != nil {
+		t.Fatal(err)
+	}
+	var msg struct {
+		Message  string `json:"message"`
+		Priority int    `json:"priority"`
+	}
+	json.Unmarshal([]byte(c.body), &msg)
+	if c.path != "/message" || c.header.Get("X-Gotify-Key") != "app" || msg.Message != "hot" || msg.Priority != 5 {
+		t.Errorf("Unexpected push %s %s", c.path, c.body)
+	}
+}
+
+func TestMinSeverity(t *testing.T) {
+	sent := 0
+	n := notifier{min: alert.Warning, send: func(alert.Alert) error { sent++; return nil }}
+	n.Notify(alert.Alert{Severity: alert.Info})
+	n.Notify(alert.Alert{Severity: alert.Critical})
+	if sent != 1 {
+		t.Errorf("Expected only the critical alert pushed, got %d", sent)
+	}
+}
@@ -0,0 +1,36 @@
+package main
+
+import (
+	"github.com/theatrus/ledbrick/controller/ltable"
+	"io/ioutil"
+	"log"
+	"os"
+	"os/signal"
+	"syscall"
+)
+
+// reloadOnHangup runs every reload each time the process gets SIGHUP.
+// A reload failing keeps the running table.
+func reloadOnHangup(reloads []func() error) {
+	hup := make(chan os.Signal, 1)
+	signal.Notify(hup, syscall.SIGHUP)
+	for range hup {
+		for _, reload := range reloads {
+			if err := reload(); err != nil {
+				log.Printf("Config not reloaded: %v", err)
+			}
+		}
+	}
+}
+
+// applyFile returns a reload applying a table file to a light driver.
+func applyFile(lights *ltable.LightDriver, file string) func() error {
+	return func() error {
+		log.Printf("Reloading config file %s", file)
+		data, err := ioutil.ReadFile(file)
+		if err != nil {
+			return err
+		}
+		return lights.Apply(data)
+	}
+}
@@ -0,0 +1,65 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"github.com/theatrus/ledbrick/controller/alert"
+	"github.com/theatrus/ledbrick/controller/ble"
+	"github.com/theatrus/ledbrick/controller/ltable"
+	"io"
+	"io/ioutil"
+	"os"
+	"time"
+)
+
+// printNotifier writes alerts with their own time rather than the log's,
+// as a line of JSON each if json is set.
+type printNotifier struct {
+	w    io.Writer
+	json bool
+}
+
+func (p printNotifier) Notify(a alert.Alert) error {
+	if p.json {
+		return json.NewEncoder(p.w).Encode(a)
+	}
+	_, err := fmt.Fprintf(p.w, "%s [%s] %s %s: %s\n", a.At.Format(time.RFC3339), a.Severity, a.Source, a.Kind, a.Message)
+	return err
+}
+
+// runReplay runs a saved history file through the fixture checks
+// against the configured schedule, printing the alerts it raises.
+func runReplay(file string, format string) error {
+	if file == "" {
+		return fmt.Errorf("usage: ledbrick replay <history file>")
+	}
+	data, err := ioutil.ReadFile(file)
+	if err != nil {
+		return err
+	}
+	samples := make(map[string][]ble.Sample)
+	if err := json.Unmarshal(data, &samples); err != nil {
+		return fmt.Errorf("%s: %v", file, err)
+	}
+
+	table, err := ioutil.ReadFile(*config)
+	if err != nil {
+		return err
+	}
+	schedule, err := ltable.ParseSchedule(table)
+	if err != nil {
+		return err
+	}
+	output := func(at time.Time) float64 {
+		max := 0.0
+		for channel := 0; channel < ltable.Channels; channel++ {
+			if v := schedule.Percent(at, channel); v > max {
+				max = v
+			}
+		}
+		return max
+	}
+
+	alert.RegisterSync(printNotifier{w: os.Stdout, json: format == "json"})
+	return ble.Replay(samples, output)
+}
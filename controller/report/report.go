@@ -0,0 +1,265 @@
+package report
+
+import (
+	"flag"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/theatrus/ledbrick/controller/alert"
+	"github.com/theatrus/ledbrick/controller/ble"
+	"github.com/theatrus/ledbrick/controller/units"
+)
+
+var par perChannel
+var photoperiodThreshold float64
+var keepDays int
+var rate float64
+var sampleInterval = time.Minute
+
+func init() {
+	flag.Var(&par, "report.par",
+		"Comma separated PAR (umol/m2/s) each channel adds at the tank at 100%, for DLI")
+	flag.Float64Var(&photoperiodThreshold, "report.threshold", 1,
+		"Output percent above which a channel counts towards the photoperiod")
+	flag.IntVar(&keepDays, "report.keep", 14, "How many daily reports to keep")
+	flag.Float64Var(&rate, "report.rate", 0,
+		"Electricity price per kWh for cost estimates, disabled if 0")
+}
+
+// perChannel is a comma separated flag of one value per channel.
+type perChannel []float64
+
+func (c *perChannel) String() string {
+	parts := make([]string, 0, len(*c))
+	for _, v := range *c {
+		parts = append(parts, strconv.FormatFloat(v, 'g', -1, 64))
+	}
+	return strings.Join(parts, ",")
+}
+
+func (c *perChannel) Set(value string) error {
+	values := perChannel{}
+	for _, part := range strings.Split(value, ",") {
+		v, err := strconv.ParseFloat(strings.TrimSpace(part), 64)
+		if err != nil {
+			return err
+		}
+		values = append(values, v)
+	}
+	*c = values
+	return nil
+}
+
+// PAR returns what each channel adds at the tank at 100% in umol/m2/s,
+// as calibrated with -report.par, or nil if it isn't.
+func PAR() []float64 {
+	return append([]float64(nil), par...)
+}
+
+// Cost estimates what energy in watt hours costs at the -report.rate, or
+// returns 0 if no rate is set.
+func Cost(wh float64) float64 {
+	return wh / 1000 * rate
+}
+
+// Fixture summarizes one fixture over a day.
+type Fixture struct {
+	MaxTemperature int     `json:"max_temperature"`
+	MinFanRPM      int     `json:"min_fan_rpm"`
+	MaxFanRPM      int     `json:"max_fan_rpm"`
+	FanFailed      bool    `json:"fan_failed"`
+	EnergyWh       float64 `json:"energy_wh"`
+	Cost           float64 `json:"cost,omitempty"`
+	seen           bool
+}
+
+// Report is a summary of a single day of lighting.
+type Report struct {
+	Day         string             `json:"day"`
+	Reverse     bool               `json:"reverse,omitempty"`
+	Photoperiod float64            `json:"photoperiod_hours"`
+	DLI         float64            `json:"dli"`
+	PeakPercent map[int]float64    `json:"peak_percent"`
+	Fixtures    map[string]Fixture `json:"fixtures"`
+	Disconnects int                `json:"disconnects"`
+	Alerts      []alert.Alert      `json:"alerts"`
+	// EnergyWh and Cost total every fixture
+	EnergyWh float64 `json:"energy_wh"`
+	Cost     float64 `json:"cost,omitempty"`
+}
+
+// String renders a report as a short human readable summary.
+func (r Report) String() string {
+	kind := "Daily"
+	if r.Reverse {
+		kind = "Overnight"
+	}
+	lines := []string{fmt.Sprintf("%s lighting summary for %s", kind, r.Day),
+		fmt.Sprintf("  photoperiod %.1f h, DLI %.1f mol/m2/d, %d disconnects, %d alerts",
+			r.Photoperiod, r.DLI, r.Disconnects, len(r.Alerts)),
+	}
+	if r.Cost > 0 {
+		lines = append(lines, fmt.Sprintf("  energy %.2f kWh, cost %.2f", r.EnergyWh/1000, r.Cost))
+	}
+	ids := make([]string, 0, len(r.Fixtures))
+	for id := range r.Fixtures {
+		ids = append(ids, id)
+	}
+	sort.Strings(ids)
+	for _, id := range ids {
+		f := r.Fixtures[id]
+		failed := ""
+		if f.FanFailed {
+			failed = ", FAN FAILED"
+		}
+		cost := ""
+		if f.Cost > 0 {
+			cost = fmt.Sprintf(" (%.2f)", f.Cost)
+		}
+		lines = append(lines, fmt.Sprintf("  %s: max %s, fan %d-%d rpm%s, %.0f Wh%s",
+			id, units.Format(float64(f.MaxTemperature), 0), f.MinFanRPM, f.MaxFanRPM, failed, f.EnergyWh, cost))
+	}
+	return strings.Join(lines, "\n")
+}
+
+// Reporter samples controller state through the day and produces a
+// Report at local midnight, delivered as an info alert.
+type Reporter struct {
+	ble ble.BLEChannel
+	// reverse runs days from noon to noon, for a reverse photoperiod
+	// lit overnight
+	reverse bool
+
+	current         Report
+	lastSample      time.Time
+	disconnectStart int
+	reports         []Report
+	lock            sync.Mutex
+}
+
+func Start(b ble.BLEChannel) *Reporter {
+	return StartTank(b, false)
+}
+
+// StartTank reports on a tank. A tank with a reverse photoperiod has its
+// days run from noon to noon, so each night's lighting is summarized
+// whole, under the day it started.
+func StartTank(b ble.BLEChannel, reverse bool) *Reporter {
+	r := &Reporter{ble: b, reverse: reverse}
+	r.reset(time.Now())
+	go func() {
+		for now := range time.Tick(sampleInterval) {
+			r.sample(now)
+		}
+	}()
+	return r
+}
+
+func dayKey(t time.Time) string {
+	return fmt.Sprintf("%04d-%02d-%02d", t.Year(), t.Month(), t.Day())
+}
+
+// day returns the key of the reporting day a time falls in.
+func (r *Reporter) day(t time.Time) string {
+	if r.reverse {
+		t = t.Add(-12 * time.Hour)
+	}
+	return dayKey(t)
+}
+
+func (r *Reporter) reset(now time.Time) {
+	r.current = Report{Day: r.day(now),
+		Reverse:     r.reverse,
+		PeakPercent: make(map[int]float64),
+		Fixtures:    make(map[string]Fixture),
+	}
+	r.lastSample = now
+	r.disconnectStart = r.ble.DisconnectCount()
+}
+
+func (r *Reporter) sample(now time.Time) {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+
+	if r.day(now) != r.current.Day {
+		r.finish()
+		r.reset(now)
+		return
+	}
+	r.add(r.ble.Channels(), r.ble.Perhipherals(), now.Sub(r.lastSample))
+	r.current.Disconnects = r.ble.DisconnectCount() - r.disconnectStart
+	r.lastSample = now
+}
+
+// add accumulates one sample covering elapsed time.
+func (r *Reporter) add(channels map[int]float64, periphs []ble.BLEPeripheral, elapsed time.Duration) {
+	lit := false
+	ppfd := 0.0
+	for channel, percent := range channels {
+		if percent > r.current.PeakPercent[channel] {
+			r.current.PeakPercent[channel] = percent
+		}
+		if percent > photoperiodThreshold {
+			lit = true
+		}
+		if channel < len(par) {
+			ppfd += percent / 100.0 * par[channel]
+		}
+	}
+	if lit {
+		r.current.Photoperiod += elapsed.Hours()
+	}
+	// umol/m2/s over the interval, in mol/m2
+	r.current.DLI += ppfd * elapsed.Seconds() / 1e6
+
+	for _, p := range periphs {
+		f := r.current.Fixtures[p.ID()]
+		if !f.seen || p.Temperature() > f.MaxTemperature {
+			f.MaxTemperature = p.Temperature()
+		}
+		if !f.seen || p.FanRPM() < f.MinFanRPM {
+			f.MinFanRPM = p.FanRPM()
+		}
+		if p.FanRPM() > f.MaxFanRPM {
+			f.MaxFanRPM = p.FanRPM()
+		}
+		f.FanFailed = f.FanFailed || p.FanFailed()
+		f.EnergyWh = p.EnergyToday()
+		f.Cost = Cost(f.EnergyWh)
+		f.seen = true
+		r.current.Fixtures[p.ID()] = f
+	}
+	r.current.EnergyWh = 0
+	for _, f := range r.current.Fixtures {
+		r.current.EnergyWh += f.EnergyWh
+	}
+	r.current.Cost = Cost(r.current.EnergyWh)
+}
+
+// finish completes the current day and delivers it. The caller must
+// hold the lock.
+func (r *Reporter) finish() {
+	day := r.current.Day
+	for _, a := range alert.Recent() {
+		if r.day(a.At) == day {
+			r.current.Alerts = append(r.current.Alerts, a)
+		}
+	}
+	r.reports = append(r.reports, r.current)
+	if len(r.reports) > keepDays {
+		r.reports = r.reports[len(r.reports)-keepDays:]
+	}
+	alert.Raise(alert.Info, "report", "daily.summary", "%s", r.current)
+}
+
+// Reports returns the stored daily reports, oldest first, followed by
+// the partial report for today.
+func (r *Reporter) Reports() []Report {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	return append(append([]Report(nil), r.reports...), r.current)
+}
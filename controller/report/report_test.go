@@ -0,0 +1,61 @@
+package report
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestAdd(t *testing.T) {
+	par = perChannel{100, 200}
+	r := &Reporter{current: Report{Day: "2016-01-01",
+		PeakPercent: make(map[int]float64),
+		Fixtures:    make(map[string]Fixture),
+	}}
+
+	// One hour at half power, one hour dark
+	r.add(map[int]float64{0: 50, 1: 50}, nil, time.Hour)
+	r.add(map[int]float64{0: 0, 1: 0}, nil, time.Hour)
+
+	if r.current.Photoperiod != 1 {
+		t.Errorf("Expected a 1 h photoperiod, got %f", r.current.Photoperiod)
+	}
+	// 150 umol/m2/s for 3600 s
+	if d := r.current.DLI - 0.54; d > 1e-9 || d < -1e-9 {
+		t.Errorf("Expected DLI of 0.54, got %f", r.current.DLI)
+	}
+	if r.current.PeakPercent[0] != 50 {
+		t.Errorf("Expected peak of 50, got %f", r.current.PeakPercent[0])
+	}
+	if !strings.Contains(r.current.String(), "photoperiod 1.0 h") {
+		t.Errorf("Unexpected summary: %s", r.current)
+	}
+}
+
+func TestCost(t *testing.T) {
+	defer func(r float64) { rate = r }(rate)
+	rate = 0.30
+	if c := Cost(2500); c < 0.75-1e-9 || c > 0.75+1e-9 {
+		t.Errorf("Expected 2.5 kWh to cost 0.75, got %f", c)
+	}
+	r := Report{Day: "2016-01-01", EnergyWh: 2500, Cost: 0.75,
+		Fixtures: map[string]Fixture{"a": {EnergyWh: 2500, Cost: 0.75}}}
+	if s := r.String(); !strings.Contains(s, "energy 2.50 kWh, cost 0.75") || !strings.Contains(s, "2500 Wh (0.75)") {
+		t.Errorf("Unexpected summary: %s", s)
+	}
+}
+
+func TestReverseDay(t *testing.T) {
+	r := &Reporter{reverse: true}
+	evening := time.Date(2016, 1, 1, 20, 0, 0, 0, time.UTC)
+	morning := time.Date(2016, 1, 2, 8, 0, 0, 0, time.UTC)
+	if r.day(evening) != "2016-01-01" || r.day(morning) != "2016-01-01" {
+		t.Errorf("Expected a night in one report, got %s and %s", r.day(evening), r.day(morning))
+	}
+	if d := r.day(morning.Add(4 * time.Hour)); d != "2016-01-02" {
+		t.Errorf("Expected the next report from noon, got %s", d)
+	}
+	if s := (Report{Day: "2016-01-01", Reverse: true}).String(); !strings.HasPrefix(s, "Overnight") {
+		t.Errorf("Unexpected summary: %s", s)
+	}
+}
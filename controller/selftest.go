@@ -0,0 +1,25 @@
+package main
+
+import (
+	"bytes"
+	"github.com/theatrus/ledbrick/controller/ble"
+	"github.com/theatrus/ledbrick/controller/clock"
+	"log"
+	"time"
+)
+
+// runSelfTest runs the power-on self-test and logs its report. Fixtures
+// which fail have alerts raised, but the schedule runs either way so a
+// bad fixture doesn't leave the rest dark.
+func runSelfTest(b ble.BLEChannel) {
+	r := ble.SelfTest(b, ble.SelfTestConfig{
+		Wait:      *selfTestWait,
+		Ramp:      5 * time.Second,
+		Percent:   30,
+		Step:      250 * time.Millisecond,
+		Telemetry: *selfTestWait + time.Minute,
+	}, clock.Real)
+	var buf bytes.Buffer
+	r.Write(&buf)
+	log.Print(buf.String())
+}
@@ -0,0 +1,169 @@
+// Package serial drives LEDBrick boards wired over USB-serial or a UART
+// instead of bluetooth. They speak the Wi-Fi fixtures' protocol, each
+// request and reply framed on the byte stream as
+//
+//	0x7e, length as 2 bytes big endian, payload, checksum
+//
+// where the checksum is the payload's bytes summed modulo 256. Bytes
+// outside a good frame are skipped, so the link picks up again after
+// noise. Boards run under the bluetooth channel like any other fixture,
+// with the same features, each with the device's file name as its ID.
+package serial
+
+import (
+	"bufio"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"path/filepath"
+	"strings"
+	"sync"
+
+	"github.com/theatrus/ledbrick/controller/ble"
+)
+
+var ports string
+var baud int
+
+func init() {
+	flag.StringVar(&ports, "serial.ports", "",
+		"Comma separated serial devices with LEDBrick boards on them, e.g. /dev/ttyUSB0")
+	flag.IntVar(&baud, "serial.baud", 115200, "Baud rate of the -serial.ports")
+	ble.RegisterTransport("serial", transport{})
+}
+
+const (
+	frameStart = 0x7e
+	// The longest payload, as long as a Wi-Fi fixture's datagram
+	maxPayload = 1500
+)
+
+// transport finds the boards on the -serial.ports.
+type transport struct{}
+
+func (transport) Find() map[string]ble.Dialer {
+	found := make(map[string]ble.Dialer)
+	for _, port := range strings.Split(ports, ",") {
+		if port = strings.TrimSpace(port); port != "" {
+			path := port
+			found[filepath.Base(port)] = func() (io.ReadWriteCloser, error) {
+				tty, err := openTTY(path, baud)
+				if err != nil {
+					return nil, err
+				}
+				return newLink(tty), nil
+			}
+		}
+	}
+	return found
+}
+
+// link frames the fixture protocol on a byte stream, one request
+// written or reply read at a time.
+type link struct {
+	rwc io.ReadWriteCloser
+	r   *bufio.Reader
+
+	lock sync.Mutex
+}
+
+func newLink(rwc io.ReadWriteCloser) *link {
+	return &link{rwc: rwc, r: bufio.NewReader(rwc)}
+}
+
+// Write sends b as one frame.
+func (l *link) Write(b []byte) (int, error) {
+	frame, err := encodeFrame(b)
+	if err != nil {
+		return 0, err
+	}
+	l.lock.Lock()
+	defer l.lock.Unlock()
+	if _, err := l.rwc.Write(frame); err != nil {
+		return 0, err
+	}
+	return len(b), nil
+}
+
+// Read returns the payload of the next good frame, which must fit in b.
+func (l *link) Read(b []byte) (int, error) {
+	payload, err := readFrame(l.r)
+	if err != nil {
+		return 0, err
+	}
+	if len(payload) > len(b) {
+		return 0, fmt.Errorf("%d byte frame, read with room for %d", len(payload), len(b))
+	}
+	return copy(b, payload), nil
+}
+
+func (l *link) Close() error {
+	return l.rwc.Close()
+}
+
+func encodeFrame(payload []byte) ([]byte, error) {
+	if len(payload) > maxPayload {
+		return nil, fmt.Errorf("%d byte request, at most %d fit in a frame", len(payload), maxPayload)
+	}
+	frame := make([]byte, 0, len(payload)+4)
+	frame = append(frame, frameStart, byte(len(payload)>>8), byte(len(payload)))
+	frame = append(frame, payload...)
+	return append(frame, checksum(payload)), nil
+}
+
+func checksum(payload []byte) byte {
+	var sum byte
+	for _, b := range payload {
+		sum += b
+	}
+	return sum
+}
+
+var errBadFrame = errors.New("bad frame")
+
+// readFrame returns the next good frame's payload, skipping anything
+// else.
+func readFrame(r *bufio.Reader) ([]byte, error) {
+	for {
+		payload, err := tryFrame(r)
+		if err != errBadFrame {
+			return payload, err
+		}
+	}
+}
+
+// tryFrame reads from the next frame start, returning errBadFrame if
+// what follows isn't a good frame. Only the start byte is consumed
+// then, so a frame starting within a bad one is still found.
+func tryFrame(r *bufio.Reader) ([]byte, error) {
+	for {
+		b, err := r.ReadByte()
+		if err != nil {
+			return nil, err
+		}
+		if b == frameStart {
+			break
+		}
+	}
+	header, err := r.Peek(2)
+	if err != nil {
+		return nil, err
+	}
+	n := int(header[0])<<8 | int(header[1])
+	if n > maxPayload {
+		return nil, errBadFrame
+	}
+	// The reader's buffer holds the longest frame
+	frame, err := r.Peek(2 + n + 1)
+	if err != nil {
+		return nil, err
+	}
+	payload := frame[2 : 2+n]
+	if checksum(payload) != frame[2+n] {
+		return nil, errBadFrame
+	}
+	payload = append([]byte(nil), payload...)
+	r.Discard(2 + n + 1)
+	return payload, nil
+}
@@ -0,0 +1,82 @@
+package serial
+
+import (
+	"bufio"
+	"bytes"
+	"io"
+	"net"
+	"testing"
+)
+
+func frame(t *testing.T, payload string) []byte {
+	f, err := encodeFrame([]byte(payload))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return f
+}
+
+func TestReadFrame(t *testing.T) {
+	var stream []byte
+	stream = append(stream, "noise"...)
+	stream = append(stream, frame(t, "!LTF")...)
+	bad := frame(t, "VT\x1e\x00")
+	bad[len(bad)-1]++
+	stream = append(stream, bad...)
+	// A start byte in the noise, claiming more than a frame can hold
+	stream = append(stream, frameStart, 0xff, 0xff)
+	stream = append(stream, frame(t, "VF\xe8\x03")...)
+	// A frame which starts inside a bad one's payload
+	stream = append(stream, frameStart, 0, 2, 1)
+	stream = append(stream, frame(t, "AL")...)
+
+	r := bufio.NewReader(bytes.NewReader(stream))
+	for _, want := range []string{"!LTF", "VF\xe8\x03", "AL"} {
+		got, err := readFrame(r)
+		if err != nil {
+			t.Fatalf("Expected %q, got %v", want, err)
+		}
+		if string(got) != want {
+			t.Errorf("Expected %q, got %q", want, got)
+		}
+	}
+	if _, err := readFrame(r); err != io.EOF {
+		t.Errorf("Expected the end of the stream, got %v", err)
+	}
+	if _, err := encodeFrame(make([]byte, maxPayload+1)); err == nil {
+		t.Error("Expected too long a request refused")
+	}
+}
+
+func TestLink(t *testing.T) {
+	controller, board := net.Pipe()
+	l := newLink(controller)
+	defer l.Close()
+
+	go l.Write([]byte("RT"))
+	r := bufio.NewReader(board)
+	req, err := readFrame(r)
+	if err != nil || string(req) != "RT" {
+		t.Fatalf("Expected the request framed, got %q %v", req, err)
+	}
+
+	go board.Write(append([]byte{0, 0}, frame(t, "VT\x1e\x00")...))
+	buf := make([]byte, 1500)
+	n, err := l.Read(buf)
+	if err != nil || string(buf[:n]) != "VT\x1e\x00" {
+		t.Errorf("Expected one reply per read, got %q %v", buf[:n], err)
+	}
+}
+
+func TestFind(t *testing.T) {
+	defer func(p string) { ports = p }(ports)
+	ports = "/dev/ttyUSB0, /dev/serial/by-id/usb-FTDI-if00-port0,"
+	found := transport{}.Find()
+	if len(found) != 2 || found["ttyUSB0"] == nil || found["usb-FTDI-if00-port0"] == nil {
+		t.Errorf("Expected a board on each port, got %v", found)
+	}
+	ports = ""
+	if found := (transport{}).Find(); len(found) != 0 {
+		t.Errorf("Expected no boards without ports, got %v", found)
+	}
+}
@@ -0,0 +1,75 @@
+package serial
+
+import (
+	"fmt"
+	"os"
+	"syscall"
+	"unsafe"
+)
+
+// Linux's terminal ioctls and flags, from asm-generic/termbits.h
+const (
+	tcgets = 0x5401
+	tcsets = 0x5402
+
+	iflagRaw = 0001 | 0002 | 0010 | 0040 | 0100 | 0200 | 0400 | 02000 // IGNBRK BRKINT PARMRK ISTRIP INLCR IGNCR ICRNL IXON
+	oflagRaw = 0001                                                   // OPOST
+	lflagRaw = 0010 | 0100 | 0002 | 0001 | 0100000                    // ECHO ECHONL ICANON ISIG IEXTEN
+
+	cbaud  = 010017
+	csize  = 0060
+	cs8    = 0060
+	parenb = 0400
+	cread  = 0200
+	clocal = 04000
+
+	vtime = 5
+	vmin  = 6
+)
+
+var bauds = map[int]uint32{
+	9600:   0015,
+	19200:  0016,
+	38400:  0017,
+	57600:  010001,
+	115200: 010002,
+	230400: 010003,
+	460800: 010004,
+	921600: 010007,
+}
+
+// termios is the kernel's struct termios.
+type termios struct {
+	iflag, oflag, cflag, lflag uint32
+	line                       uint8
+	cc                         [19]uint8
+}
+
+// openTTY opens a serial device raw, 8N1 at a baud rate, with reads
+// waiting for at least a byte.
+func openTTY(path string, baud int) (*os.File, error) {
+	speed, ok := bauds[baud]
+	if !ok {
+		return nil, fmt.Errorf("unsupported -serial.baud %d", baud)
+	}
+	f, err := os.OpenFile(path, os.O_RDWR|syscall.O_NOCTTY, 0)
+	if err != nil {
+		return nil, err
+	}
+	var t termios
+	if _, _, errno := syscall.Syscall(syscall.SYS_IOCTL, f.Fd(), tcgets, uintptr(unsafe.Pointer(&t))); errno != 0 {
+		f.Close()
+		return nil, fmt.Errorf("%s is not a serial device: %v", path, errno)
+	}
+	t.iflag &^= iflagRaw
+	t.oflag &^= oflagRaw
+	t.lflag &^= lflagRaw
+	t.cflag &^= cbaud | csize | parenb
+	t.cflag |= speed | cs8 | cread | clocal
+	t.cc[vmin], t.cc[vtime] = 1, 0
+	if _, _, errno := syscall.Syscall(syscall.SYS_IOCTL, f.Fd(), tcsets, uintptr(unsafe.Pointer(&t))); errno != 0 {
+		f.Close()
+		return nil, fmt.Errorf("setting up %s: %v", path, errno)
+	}
+	return f, nil
+}
@@ -0,0 +1,25 @@
+package main
+
+import (
+	"github.com/theatrus/ledbrick/controller/ble"
+	"github.com/theatrus/ledbrick/controller/plugs"
+	"log"
+	"os"
+	"os/signal"
+	"syscall"
+)
+
+// releaseOnExit hands fixtures back to their own schedules when the
+// process is asked to stop, rather than leaving them holding for a
+// controller which is gone until their lease runs out. Plugs switched
+// by a scene are put back first, and any which can't be are put back
+// on the next start.
+func releaseOnExit(b ble.BLEChannel) {
+	stop := make(chan os.Signal, 1)
+	signal.Notify(stop, syscall.SIGINT, syscall.SIGTERM)
+	s := <-stop
+	log.Printf("Got %s, releasing fixtures", s)
+	plugs.RestoreAll()
+	b.Release()
+	os.Exit(0)
+}
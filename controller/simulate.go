@@ -0,0 +1,26 @@
+package main
+
+import (
+	"encoding/json"
+	"github.com/theatrus/ledbrick/controller/ltable"
+	"io"
+	"time"
+)
+
+// simulatedStep is one line of -simulate -format=json output.
+type simulatedStep struct {
+	At       string    `json:"at"`
+	Percents []float64 `json:"percents"`
+}
+
+// runSimulate prints a simulated day of the table, as a table or a
+// line of JSON per step.
+func runSimulate(data []byte, w io.Writer) error {
+	if *format != "json" {
+		return ltable.Simulate(data, *timeScale, *simulateStep, w)
+	}
+	e := json.NewEncoder(w)
+	return ltable.SimulateFunc(data, *timeScale, *simulateStep, func(at time.Time, percents []float64) error {
+		return e.Encode(simulatedStep{At: at.Format("15:04"), Percents: percents})
+	})
+}
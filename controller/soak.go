@@ -0,0 +1,27 @@
+package main
+
+import (
+	"encoding/json"
+	"github.com/theatrus/ledbrick/controller/ble"
+	"github.com/theatrus/ledbrick/controller/clock"
+	"io"
+	"time"
+)
+
+// runSoak runs a soak test in place of the schedule and prints the
+// report, as a table or JSON.
+func runSoak(b ble.BLEChannel, w io.Writer) {
+	r := ble.Soak(b, ble.SoakConfig{
+		Duration: *soak,
+		Step:     *soakStep,
+		Period:   *soakPeriod,
+		Report:   10 * time.Minute,
+	}, clock.Real)
+	if *format == "json" {
+		e := json.NewEncoder(w)
+		e.SetIndent("", "  ")
+		e.Encode(r)
+		return
+	}
+	r.Write(w)
+}
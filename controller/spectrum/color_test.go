@@ -0,0 +1,47 @@
+package spectrum
+
+import (
+	"math"
+	"testing"
+)
+
+func TestCCT(t *testing.T) {
+	for _, c := range []struct {
+		name     string
+		x, y     float64
+		kelvin   float64
+		duv      float64
+		tolerant float64
+	}{
+		// Illuminant A is a black body at 2856 K. The observer fit and
+		// 5 nm sampling are good to about a percent
+		{"A", 0.44757, 0.40745, 2856, 0, 0.015},
+		{"D65", 0.31271, 0.32902, 6504, 0.0032, 0.01},
+	} {
+		kelvin, duv := CCT(c.x, c.y)
+		if math.Abs(kelvin-c.kelvin)/c.kelvin > c.tolerant || math.Abs(duv-c.duv) > 0.001 {
+			t.Errorf("%s: expected %.0f K Duv %.4f, got %.0f K Duv %.4f", c.name, c.kelvin, c.duv, kelvin, duv)
+		}
+	}
+	// Magenta sits below the locus
+	if _, duv := CCT(0.35, 0.28); duv >= 0 {
+		t.Errorf("Expected a negative Duv below the locus, got %.4f", duv)
+	}
+}
+
+func TestChromaticity(t *testing.T) {
+	s, _ := Load()
+	var dark SPD
+	if _, _, err := dark.Chromaticity(); err == nil {
+		t.Error("Expected no chromaticity without light")
+	}
+	// A blue heavy reef mix is well above 10000 K
+	mix := s.Combine(map[int]float64{3: 100, 5: 100, 6: 40})
+	x, y, err := mix.Chromaticity()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if kelvin, _ := CCT(x, y); kelvin < 10000 {
+		t.Errorf("Expected a blue mix above 10000 K, got %.0f K at %.3f, %.3f", kelvin, x, y)
+	}
+}
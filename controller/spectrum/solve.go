@@ -0,0 +1,117 @@
+package spectrum
+
+import (
+	"errors"
+	"math"
+)
+
+// Target returns the xy of a colour temperature, tinted by a Duv: away
+// from the black body locus towards green for positive tints, magenta
+// for negative ones.
+func Target(kelvin, tint float64) (x, y float64, err error) {
+	if kelvin < 1000 || kelvin > 100000 {
+		return 0, 0, errors.New("colour temperature must be 1000-100000 K")
+	}
+	u, v := blackBody(kelvin)
+	// The locus runs towards lower u and v as it heats, so its normal
+	// pointing above it is the tangent turned clockwise
+	u2, v2 := blackBody(1e6 / (1e6/kelvin - 1))
+	du, dv := u2-u, v2-v
+	length := math.Hypot(du, dv)
+	u += tint * dv / length
+	v -= tint * du / length
+	d := 2*u - 8*v + 4
+	return 3 * u / d, 2 * v / d, nil
+}
+
+// Solution is a channel mix found to give a colour.
+type Solution struct {
+	Percents [len(stock)]float64
+	// X and Y are the chromaticity the mix gives
+	X, Y float64
+	// Intensity is the mix's luminance as a percent of every channel at
+	// its maximum
+	Intensity float64
+	// Residual is how far the mix misses the target in CIE 1960 uv
+	Residual float64
+}
+
+// Solve finds channel percents, each no more than its maximum, which
+// give the colour x, y at an intensity: a percent of the luminance of
+// every channel at its maximum. Where the target can't be reached the
+// nearest mix is returned, with the residual telling how far off it is.
+func (s *Spectra) Solve(x, y, intensity float64, max [len(stock)]float64) (Solution, error) {
+	if x <= 0 || y <= 0 || x+y >= 1 {
+		return Solution{}, errors.New("chromaticity out of range")
+	}
+	if intensity <= 0 || intensity > 100 {
+		return Solution{}, errors.New("intensity must be 0-100%")
+	}
+
+	// Each channel's tristimulus at its maximum
+	var cols [len(stock)][3]float64
+	full := 0.0
+	for channel := range cols {
+		X, Y, Z := s.Channels[channel].Tristimulus()
+		cols[channel] = [3]float64{X * max[channel] / 100, Y * max[channel] / 100, Z * max[channel] / 100}
+		full += cols[channel][1]
+	}
+	if full <= 0 {
+		return Solution{}, errors.New("no light to mix")
+	}
+	Y := intensity / 100 * full
+	target := [3]float64{x / y * Y, Y, (1 - x - y) / y * Y}
+
+	// Bounded least squares by coordinate descent over the fraction of
+	// each channel's maximum, starting from even output. A little ridge
+	// picks the lowest output mix of the many which give the colour.
+	const ridge = 1e-6
+	var f [len(stock)]float64
+	for channel := range f {
+		f[channel] = intensity / 100
+	}
+	var mix [3]float64
+	for channel := range f {
+		for k := range mix {
+			mix[k] += cols[channel][k] * f[channel]
+		}
+	}
+	for pass := 0; pass < 1000; pass++ {
+		moved := 0.0
+		for channel := range f {
+			norm := ridge * Y * Y
+			grad := ridge * Y * Y * f[channel]
+			for k := range mix {
+				norm += cols[channel][k] * cols[channel][k]
+				grad += cols[channel][k] * (mix[k] - target[k])
+			}
+			if norm <= 0 {
+				continue
+			}
+			next := math.Max(0, math.Min(1, f[channel]-grad/norm))
+			for k := range mix {
+				mix[k] += cols[channel][k] * (next - f[channel])
+			}
+			moved = math.Max(moved, math.Abs(next-f[channel]))
+			f[channel] = next
+		}
+		if moved < 1e-9 {
+			break
+		}
+	}
+
+	var sol Solution
+	for channel := range f {
+		sol.Percents[channel] = f[channel] * max[channel]
+	}
+	sum := mix[0] + mix[1] + mix[2]
+	if sum <= 0 {
+		return sol, errors.New("no mix gives any light")
+	}
+	sol.X, sol.Y = mix[0]/sum, mix[1]/sum
+	sol.Intensity = mix[1] / full * 100
+	u, v := uv(x, y)
+	gu, gv := uv(sol.X, sol.Y)
+	sol.Residual = math.Hypot(u-gu, v-gv)
+	return sol, nil
+}
@@ -0,0 +1,59 @@
+package spectrum
+
+import (
+	"math"
+	"testing"
+)
+
+func TestTarget(t *testing.T) {
+	x, y, err := Target(6500, 0.01)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if kelvin, duv := CCT(x, y); math.Abs(kelvin-6500) > 10 || math.Abs(duv-0.01) > 1e-4 {
+		t.Errorf("Expected 6500 K Duv 0.01 back, got %.0f K Duv %.4f", kelvin, duv)
+	}
+	if _, _, err := Target(500, 0); err == nil {
+		t.Error("Expected 500 K to be out of range")
+	}
+}
+
+func full() (max [8]float64) {
+	for channel := range max {
+		max[channel] = 100
+	}
+	return
+}
+
+func TestSolve(t *testing.T) {
+	s, _ := Load()
+	x, y, _ := Target(15000, 0)
+	sol, err := s.Solve(x, y, 30, full())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if sol.Residual > 1e-4 || math.Abs(sol.Intensity-30) > 0.1 {
+		t.Errorf("Expected 15000 K at 30%%, got %.4f, %.4f at %.1f%%, residual %.5f",
+			sol.X, sol.Y, sol.Intensity, sol.Residual)
+	}
+	for channel, p := range sol.Percents {
+		if p < 0 || p > 100 {
+			t.Errorf("Channel %d out of range at %.1f%%", channel, p)
+		}
+	}
+
+	// With everything but deep blue capped at nothing only deep blue
+	// can be had
+	var max [8]float64
+	max[5] = 100
+	sol, err = s.Solve(x, y, 50, max)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if sol.Residual < 0.05 || sol.Percents[0] != 0 {
+		t.Errorf("Expected deep blue alone to miss the target, got %v residual %.4f", sol.Percents, sol.Residual)
+	}
+	if _, err := s.Solve(0.6, 0.6, 50, full()); err == nil {
+		t.Error("Expected an impossible chromaticity to fail")
+	}
+}
@@ -0,0 +1,195 @@
+package spectrum
+
+import (
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"math"
+
+	"github.com/theatrus/ledbrick/controller/ble"
+	"github.com/theatrus/ledbrick/controller/report"
+)
+
+var spectrumFile string
+
+func init() {
+	flag.StringVar(&spectrumFile, "spectrum.file", "",
+		"JSON file of measured channel spectra keyed by channel name, replacing the stock emitters' spectra")
+}
+
+// Spectra are sampled every Step nm from Start nm to End nm.
+const (
+	Start   = 380
+	End     = 780
+	Step    = 5
+	Samples = (End-Start)/Step + 1
+)
+
+// SPD is a spectral power distribution, sampled every Step nm.
+type SPD [Samples]float64
+
+// Wavelength returns the wavelength of sample i in nm.
+func Wavelength(i int) float64 {
+	return float64(Start + i*Step)
+}
+
+// Constants for counting photons
+const (
+	planck   = 6.62607015e-34
+	light    = 2.99792458e8
+	avogadro = 6.02214076e23
+)
+
+// PPFD returns the photosynthetic photon flux, 400-700 nm, of a spectrum
+// in W/m2/nm as umol/m2/s.
+func (s *SPD) PPFD() float64 {
+	sum := 0.0
+	for i, e := range s {
+		if nm := Wavelength(i); nm >= 400 && nm <= 700 {
+			sum += e * Step * nm * 1e-9 / (planck * light)
+		}
+	}
+	return sum / avogadro * 1e6
+}
+
+// emitter approximates a stock channel's spectrum as a peak of the given
+// width, plus for phosphor converted emitters a broad second peak of
+// the given power relative to the first.
+type emitter struct {
+	peak, fwhm                     float64
+	phosphor, phosphorFWHM, excess float64
+}
+
+var stock = [len(ble.ChannelNames)]emitter{
+	{peak: 520, fwhm: 30}, // Green
+	{peak: 490, fwhm: 25}, // Cyan
+	{peak: 450, fwhm: 20, phosphor: 595, phosphorFWHM: 80, excess: 12}, // PC Amber
+	{peak: 470, fwhm: 22}, // Blue
+	{peak: 630, fwhm: 18}, // Red
+	{peak: 450, fwhm: 20}, // Deep Blue
+	{peak: 450, fwhm: 20, phosphor: 555, phosphorFWHM: 110, excess: 2}, // White
+	{peak: 405, fwhm: 15}, // UV
+}
+
+func gaussian(nm, peak, fwhm float64) float64 {
+	sigma := fwhm / (2 * math.Sqrt(2*math.Ln2))
+	return math.Exp(-(nm - peak) * (nm - peak) / (2 * sigma * sigma))
+}
+
+func (e emitter) spd() SPD {
+	var s SPD
+	for i := range s {
+		nm := Wavelength(i)
+		s[i] = gaussian(nm, e.peak, e.fwhm)
+		if e.phosphor > 0 {
+			// Scale the phosphor peak by the ratio of the widths so
+			// excess is a ratio of power rather than height
+			s[i] += e.excess * e.fwhm / e.phosphorFWHM * gaussian(nm, e.phosphor, e.phosphorFWHM)
+		}
+	}
+	return s
+}
+
+// measured is a channel's spectrum as published, at any spacing, in any
+// units: only its shape is used.
+type measured struct {
+	Start  float64   `json:"start"`
+	Step   float64   `json:"step"`
+	Values []float64 `json:"values"`
+}
+
+// resample interpolates a measured spectrum onto our samples, as zero
+// outside what was measured.
+func (m measured) resample() (SPD, error) {
+	var s SPD
+	if m.Step <= 0 || len(m.Values) < 2 {
+		return s, errors.New("needs a positive step and at least two values")
+	}
+	for i := range s {
+		pos := (Wavelength(i) - m.Start) / m.Step
+		if pos < 0 || pos > float64(len(m.Values)-1) {
+			continue
+		}
+		lo := int(pos)
+		if lo == len(m.Values)-1 {
+			s[i] = m.Values[lo]
+			continue
+		}
+		frac := pos - float64(lo)
+		s[i] = m.Values[lo]*(1-frac) + m.Values[lo+1]*frac
+	}
+	return s, nil
+}
+
+// Spectra are each channel's spectrum at full output. They are scaled so
+// each channel gives the PAR calibrated with -report.par, in W/m2/nm at
+// the tank, or where that isn't calibrated to a photon flux of 1 each,
+// which still compares the channels fairly.
+type Spectra struct {
+	Channels [len(ble.ChannelNames)]SPD
+	// Absolute is whether the spectra are calibrated in W/m2/nm
+	Absolute bool
+}
+
+// Load prepares the channel spectra, from -spectrum.file for any
+// channels it gives and the stock emitters for the rest.
+func Load() (*Spectra, error) {
+	s := &Spectra{}
+	for channel, e := range stock {
+		s.Channels[channel] = e.spd()
+	}
+	if spectrumFile != "" {
+		data, err := ioutil.ReadFile(spectrumFile)
+		if err != nil {
+			return nil, err
+		}
+		var file map[string]measured
+		if err := json.Unmarshal(data, &file); err != nil {
+			return nil, fmt.Errorf("%s: %v", spectrumFile, err)
+		}
+		for name, m := range file {
+			channel, err := ble.ChannelNumber(name)
+			if err != nil {
+				return nil, fmt.Errorf("%s: %v", spectrumFile, err)
+			}
+			if s.Channels[channel], err = m.resample(); err != nil {
+				return nil, fmt.Errorf("%s: channel %s %v", spectrumFile, name, err)
+			}
+		}
+	}
+
+	par := report.PAR()
+	s.Absolute = len(par) > 0
+	for channel := range s.Channels {
+		want := 1.0
+		if s.Absolute {
+			want = 0
+			if channel < len(par) {
+				want = par[channel]
+			}
+		}
+		flux := s.Channels[channel].PPFD()
+		for i := range s.Channels[channel] {
+			if flux > 0 {
+				s.Channels[channel][i] *= want / flux
+			}
+		}
+	}
+	return s, nil
+}
+
+// Combine returns the spectrum of channels at the given percents.
+func (s *Spectra) Combine(percents map[int]float64) SPD {
+	var mix SPD
+	for channel, percent := range percents {
+		if channel < 0 || channel >= len(s.Channels) {
+			continue
+		}
+		for i, e := range s.Channels[channel] {
+			mix[i] += e * percent / 100
+		}
+	}
+	return mix
+}
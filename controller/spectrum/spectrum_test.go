@@ -0,0 +1,69 @@
+package spectrum
+
+import (
+	"io/ioutil"
+	"math"
+	"os"
+	"testing"
+)
+
+func peak(s SPD) float64 {
+	best := 0
+	for i := range s {
+		if s[i] > s[best] {
+			best = i
+		}
+	}
+	return Wavelength(best)
+}
+
+func TestLoad(t *testing.T) {
+	s, err := Load()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if s.Absolute {
+		t.Error("Expected relative spectra without -report.par")
+	}
+	if p := peak(s.Channels[4]); p != 630 {
+		t.Errorf("Expected red to peak at 630 nm, got %.0f", p)
+	}
+	for channel, c := range s.Channels[:7] {
+		if flux := c.PPFD(); math.Abs(flux-1) > 1e-9 {
+			t.Errorf("Expected channel %d scaled to a flux of 1, got %f", channel, flux)
+		}
+	}
+}
+
+func TestMeasured(t *testing.T) {
+	f, err := ioutil.TempFile("", "spectra")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.Remove(f.Name())
+	f.WriteString(`{"deep blue": {"start": 440, "step": 10, "values": [0, 1, 0]}}`)
+	f.Close()
+	spectrumFile = f.Name()
+	defer func() { spectrumFile = "" }()
+
+	s, err := Load()
+	if err != nil {
+		t.Fatal(err)
+	}
+	c := s.Channels[5]
+	if peak(c) != 450 || c[0] != 0 || c[(445-Start)/Step] != c[(450-Start)/Step]/2 {
+		t.Errorf("Expected the measured spectrum interpolated, got %v", c)
+	}
+	spectrumFile = "/nonexistent"
+	if _, err := Load(); err == nil {
+		t.Error("Expected a missing file to fail")
+	}
+}
+
+func TestCombine(t *testing.T) {
+	s, _ := Load()
+	mix := s.Combine(map[int]float64{3: 50, 4: 100, 9: 100})
+	if flux := mix.PPFD(); math.Abs(flux-1.5) > 1e-9 {
+		t.Errorf("Expected a flux of 1.5, got %f", flux)
+	}
+}
@@ -0,0 +1,116 @@
+package support
+
+import (
+	"archive/tar"
+	"compress/gzip"
+	"flag"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/url"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+)
+
+// Flags naming files which are copied into a bundle.
+var configFlags = []string{"config", "ble.fixtures", "ble.models"}
+
+// Words in a flag's name which mean its value is a secret.
+var secretWords = []string{"password", "secret", "token", "key"}
+
+// Redacted replaces secrets in a bundle.
+const Redacted = "REDACTED"
+
+// Bundle collects files for a support bundle, written as a gzipped tar.
+type Bundle struct {
+	names []string
+	files map[string][]byte
+}
+
+func NewBundle() *Bundle {
+	return &Bundle{files: make(map[string][]byte)}
+}
+
+// Add puts a file in the bundle, replacing any of the same name.
+func (b *Bundle) Add(name string, data []byte) {
+	if _, ok := b.files[name]; !ok {
+		b.names = append(b.names, name)
+	}
+	b.files[name] = data
+}
+
+// AddConfig adds the command line flags, with secrets redacted, and the
+// config files they name.
+func (b *Bundle) AddConfig() {
+	b.Add("flags.txt", []byte(Flags()))
+	for _, name := range configFlags {
+		f := flag.Lookup(name)
+		if f == nil || f.Value.String() == "" {
+			continue
+		}
+		file := f.Value.String()
+		data, err := ioutil.ReadFile(file)
+		if err != nil {
+			data = []byte(fmt.Sprintf("%s: %v\n", file, err))
+		}
+		b.Add("config/"+filepath.Base(file), data)
+	}
+}
+
+// Write writes the bundle as a gzipped tar.
+func (b *Bundle) Write(w io.Writer) error {
+	gz := gzip.NewWriter(w)
+	tw := tar.NewWriter(gz)
+	now := time.Now()
+	for _, name := range b.names {
+		data := b.files[name]
+		err := tw.WriteHeader(&tar.Header{Name: name,
+			Mode:    0644,
+			Size:    int64(len(data)),
+			ModTime: now,
+		})
+		if err != nil {
+			return err
+		}
+		if _, err := tw.Write(data); err != nil {
+			return err
+		}
+	}
+	if err := tw.Close(); err != nil {
+		return err
+	}
+	return gz.Close()
+}
+
+// Flags lists every flag and its value, one per line, with secrets
+// redacted.
+func Flags() string {
+	var lines []string
+	flag.VisitAll(func(f *flag.Flag) {
+		lines = append(lines, fmt.Sprintf("-%s=%s", f.Name, sanitize(f.Name, f.Value.String())))
+	})
+	sort.Strings(lines)
+	return strings.Join(lines, "\n") + "\n"
+}
+
+// sanitize redacts a flag's value if its name says it is a secret, or
+// the password in it if it is a URL with one.
+func sanitize(name, value string) string {
+	if value == "" {
+		return value
+	}
+	for _, word := range secretWords {
+		if strings.Contains(strings.ToLower(name), word) {
+			return Redacted
+		}
+	}
+	if u, err := url.Parse(value); err == nil && u.User != nil {
+		if _, ok := u.User.Password(); ok {
+			u.User = url.UserPassword(u.User.Username(), Redacted)
+			return u.String()
+		}
+	}
+	return value
+}
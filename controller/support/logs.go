@@ -0,0 +1,60 @@
+package support
+
+import (
+	"bytes"
+	"sync"
+)
+
+// Logs keeps the most recent log lines for support bundles. The log
+// package writes to it alongside stderr.
+var Logs = NewRing(2000)
+
+// Ring is an io.Writer keeping the last lines written to it.
+type Ring struct {
+	mu    sync.Mutex
+	lines []string
+	next  int
+	// partial holds a line not yet ended by a newline
+	partial []byte
+}
+
+func NewRing(size int) *Ring {
+	return &Ring{lines: make([]string, 0, size)}
+}
+
+func (r *Ring) Write(p []byte) (int, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	data := append(r.partial, p...)
+	for {
+		i := bytes.IndexByte(data, '\n')
+		if i < 0 {
+			break
+		}
+		r.add(string(data[:i+1]))
+		data = data[i+1:]
+	}
+	r.partial = append([]byte(nil), data...)
+	return len(p), nil
+}
+
+func (r *Ring) add(line string) {
+	if len(r.lines) < cap(r.lines) {
+		r.lines = append(r.lines, line)
+		return
+	}
+	r.lines[r.next] = line
+	r.next = (r.next + 1) % len(r.lines)
+}
+
+// Bytes returns the lines kept, oldest first.
+func (r *Ring) Bytes() []byte {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	var buf bytes.Buffer
+	for i := range r.lines {
+		buf.WriteString(r.lines[(r.next+i)%len(r.lines)])
+	}
+	buf.Write(r.partial)
+	return buf.Bytes()
+}
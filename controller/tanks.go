@@ -0,0 +1,82 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"github.com/theatrus/ledbrick/controller/api"
+	"github.com/theatrus/ledbrick/controller/ble"
+	"github.com/theatrus/ledbrick/controller/ltable"
+	"github.com/theatrus/ledbrick/controller/report"
+	"io/ioutil"
+	"log"
+)
+
+var tanksFile = flag.String("tanks", "",
+	"JSON file of lighting table files keyed by tank name, for fixtures assigned a tank in -ble.fixtures")
+
+// tankConfig is a tank's entry in -tanks: either the table file name,
+// or an object naming it and whether the tank has a reverse
+// photoperiod. A reverse tank such as a refugium runs its table twelve
+// hours out, and with no table runs the display's -config, so it is lit
+// while the display is dark.
+type tankConfig struct {
+	Table   string `json:"table"`
+	Reverse bool   `json:"reverse"`
+}
+
+func (c *tankConfig) UnmarshalJSON(data []byte) error {
+	if err := json.Unmarshal(data, &c.Table); err == nil {
+		return nil
+	}
+	type plain tankConfig
+	return json.Unmarshal(data, (*plain)(c))
+}
+
+// startTanks runs each tank's lighting table on the fixtures assigned
+// to it and serves its scoped API, returning how to reload the tables.
+func startTanks(b ble.BLEChannel, server *api.Server) ([]func() error, error) {
+	if *tanksFile == "" {
+		return nil, nil
+	}
+	data, err := ioutil.ReadFile(*tanksFile)
+	if err != nil {
+		return nil, err
+	}
+	var tanks map[string]tankConfig
+	if err := json.Unmarshal(data, &tanks); err != nil {
+		return nil, fmt.Errorf("%s: %v", *tanksFile, err)
+	}
+	var reloads []func() error
+	for name, tank := range tanks {
+		if name == "" {
+			return nil, fmt.Errorf("%s: tank names can't be empty", *tanksFile)
+		}
+		file := tank.Table
+		if file == "" && tank.Reverse {
+			file = *config
+		}
+		data, err := ioutil.ReadFile(file)
+		if err != nil {
+			return nil, err
+		}
+		lights, err := ltable.NewLightDriverFromJson(b.Tank(name), data)
+		if err != nil {
+			return nil, fmt.Errorf("tank %s: %v", name, err)
+		}
+		if tank.Reverse {
+			lights.Reverse()
+		}
+		if err := lights.KeepEdits(fmt.Sprintf("%s.%s.edits", *tanksFile, name)); err != nil {
+			return nil, fmt.Errorf("tank %s: %v", name, err)
+		}
+		server.AddTank(name, lights, report.StartTank(b.Tank(name), tank.Reverse))
+		reloads = append(reloads, applyFile(lights, file))
+		if tank.Reverse {
+			log.Printf("Tank %s running %s with a reverse photoperiod", name, file)
+		} else {
+			log.Printf("Tank %s running %s", name, file)
+		}
+	}
+	return reloads, nil
+}
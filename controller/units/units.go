@@ -0,0 +1,100 @@
+// Package units shows temperatures in the unit the user reads them in.
+// Temperatures are always kept in Celsius, and only converted where
+// people see them.
+package units
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+var temperatureUnit string
+
+func init() {
+	flag.StringVar(&temperatureUnit, "units.temperature", "C",
+		"Unit temperatures are shown and alerted in, C or F. Settings may give either, as \"80F\"")
+}
+
+// Check returns an error if the unit flag isn't one we know.
+func Check() error {
+	if temperatureUnit != "C" && temperatureUnit != "F" {
+		return fmt.Errorf("-units.temperature must be C or F, not %q", temperatureUnit)
+	}
+	return nil
+}
+
+// Temperature returns the unit temperatures are shown in, C or F.
+func Temperature() string {
+	if temperatureUnit == "F" {
+		return "F"
+	}
+	return "C"
+}
+
+// FromCelsius converts a temperature to the unit it's shown in.
+func FromCelsius(c float64) float64 {
+	if Temperature() == "F" {
+		return c*9/5 + 32
+	}
+	return c
+}
+
+// Format shows a temperature in Celsius in the unit it's shown in, with
+// as many decimals, such as "77.0 F".
+func Format(c float64, decimals int) string {
+	return strconv.FormatFloat(FromCelsius(c), 'f', decimals, 64) + " " + Temperature()
+}
+
+// Celsius is a temperature setting. In JSON it's a number of degrees
+// Celsius, or a string giving its unit, such as "80F" or "26.5C", so a
+// threshold means what the person who wrote it meant whatever the
+// unit flag says.
+type Celsius float64
+
+func (c *Celsius) UnmarshalJSON(b []byte) error {
+	var s string
+	if err := json.Unmarshal(b, &s); err != nil {
+		var v float64
+		if err := json.Unmarshal(b, &v); err != nil {
+			return fmt.Errorf("expected a temperature such as 26.5 or \"80F\", got %s", b)
+		}
+		*c = Celsius(v)
+		return nil
+	}
+	v, err := ParseTemperature(s)
+	if err != nil {
+		return err
+	}
+	*c = v
+	return nil
+}
+
+// ParseTemperature reads a temperature with its unit, such as "80F" or
+// "26.5 C", returning it in Celsius.
+func ParseTemperature(s string) (Celsius, error) {
+	s = strings.ToUpper(strings.TrimSpace(s))
+	if s == "" {
+		return 0, fmt.Errorf("expected a temperature such as \"80F\" or \"26.5C\"")
+	}
+	unit := s[len(s)-1:]
+	v, err := strconv.ParseFloat(strings.TrimSpace(strings.TrimSuffix(s[:len(s)-1], "°")), 64)
+	if err != nil || unit != "C" && unit != "F" {
+		return 0, fmt.Errorf("expected a temperature such as \"80F\" or \"26.5C\", got %q", s)
+	}
+	if unit == "F" {
+		v = (v - 32) * 5 / 9
+	}
+	return Celsius(v), nil
+}
+
+// FormatChange shows a change in temperature in Celsius in the unit
+// it's shown in, such as "9 F" for a rise of 5 C.
+func FormatChange(c float64, decimals int) string {
+	if Temperature() == "F" {
+		c = c * 9 / 5
+	}
+	return strconv.FormatFloat(c, 'f', decimals, 64) + " " + Temperature()
+}
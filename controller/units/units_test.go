@@ -0,0 +1,52 @@
+package units
+
+import (
+	"encoding/json"
+	"math"
+	"testing"
+)
+
+func TestFormat(t *testing.T) {
+	defer func(u string) { temperatureUnit = u }(temperatureUnit)
+	temperatureUnit = "C"
+	if s := Format(25, 1); s != "25.0 C" {
+		t.Errorf("Expected 25.0 C, got %q", s)
+	}
+	temperatureUnit = "F"
+	if s := Format(25, 1); s != "77.0 F" {
+		t.Errorf("Expected 77.0 F, got %q", s)
+	}
+	if s := FormatChange(5, 0); s != "9 F" {
+		t.Errorf("Expected a rise of 9 F, got %q", s)
+	}
+	temperatureUnit = "K"
+	if Check() == nil {
+		t.Error("Expected an unknown unit to fail")
+	}
+}
+
+func TestCelsiusJSON(t *testing.T) {
+	for _, test := range []struct {
+		json string
+		want float64
+	}{
+		{`26.5`, 26.5},
+		{`"26.5C"`, 26.5},
+		{`"80F"`, 26.67},
+		{`"80 °F"`, 26.67},
+		{`"-40f"`, -40},
+	} {
+		var c Celsius
+		if err := json.Unmarshal([]byte(test.json), &c); err != nil {
+			t.Errorf("%s: %v", test.json, err)
+		} else if math.Abs(float64(c)-test.want) > 0.01 {
+			t.Errorf("Expected %s to be %v C, got %v", test.json, test.want, c)
+		}
+	}
+	for _, bad := range []string{`"80"`, `"hot"`, `""`, `true`} {
+		var c Celsius
+		if err := json.Unmarshal([]byte(bad), &c); err == nil {
+			t.Errorf("Expected %s to fail", bad)
+		}
+	}
+}
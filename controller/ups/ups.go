@@ -0,0 +1,184 @@
+package ups
+
+import (
+	"bufio"
+	"encoding/binary"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"net"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/theatrus/ledbrick/controller/alert"
+	"github.com/theatrus/ledbrick/controller/ble"
+)
+
+var nutAddr string
+var nutName string
+var apcupsdAddr string
+var batteryPercent float64
+var pollInterval time.Duration
+
+func init() {
+	flag.StringVar(&nutAddr, "ups.nut", "",
+		"Address of a NUT upsd server, e.g. localhost:3493")
+	flag.StringVar(&nutName, "ups.name", "ups", "Name of the UPS on the NUT server")
+	flag.StringVar(&apcupsdAddr, "ups.apcupsd", "",
+		"Address of an apcupsd network server, e.g. localhost:3551")
+	flag.Float64Var(&batteryPercent, "ups.percent", 20,
+		"Output percent to limit all fixtures to while running on battery")
+	flag.DurationVar(&pollInterval, "ups.interval", 10*time.Second,
+		"How often to poll the UPS status")
+}
+
+const limitName = "ups"
+
+// Monitor polls a UPS daemon and reduces output while on battery.
+type Monitor struct {
+	ble       ble.BLEChannel
+	query     func() (bool, error)
+	onBattery bool
+	failed    bool
+	lock      sync.Mutex
+}
+
+// Start begins polling whichever UPS daemon is configured. It returns
+// nil if none is.
+func Start(b ble.BLEChannel) *Monitor {
+	m := &Monitor{ble: b}
+	switch {
+	case nutAddr != "":
+		m.query = func() (bool, error) { return withConn(nutAddr, queryNut) }
+	case apcupsdAddr != "":
+		m.query = func() (bool, error) { return withConn(apcupsdAddr, queryApcupsd) }
+	default:
+		return nil
+	}
+	go func() {
+		m.poll()
+		for _ = range time.Tick(pollInterval) {
+			m.poll()
+		}
+	}()
+	return m
+}
+
+// OnBattery reports whether the UPS was on battery at the last poll.
+func (m *Monitor) OnBattery() bool {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	return m.onBattery
+}
+
+func (m *Monitor) poll() {
+	onBattery, err := m.query()
+
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	if err != nil {
+		// Leave the current state alone, the UPS daemon may be restarting
+		if !m.failed {
+			m.failed = true
+			alert.Raise(alert.Warning, limitName, "ups.failed", "failed to read UPS status: %v", err)
+		}
+		return
+	}
+	m.failed = false
+	m.set(onBattery)
+}
+
+// set applies a new power state. The caller must hold the lock.
+func (m *Monitor) set(onBattery bool) {
+	if onBattery == m.onBattery {
+		return
+	}
+	m.onBattery = onBattery
+	if onBattery {
+		m.ble.SetLimit(limitName, batteryPercent)
+		m.ble.SuspendEffects(limitName)
+		alert.Raise(alert.Critical, limitName, "ups.battery",
+			"mains power lost, limiting output to %.0f%%", batteryPercent)
+	} else {
+		m.ble.ClearLimit(limitName)
+		m.ble.ResumeEffects(limitName)
+		alert.Raise(alert.Info, limitName, "ups.battery", "mains power restored")
+	}
+}
+
+func withConn(addr string, query func(io.ReadWriter) (bool, error)) (bool, error) {
+	conn, err := net.DialTimeout("tcp", addr, 5*time.Second)
+	if err != nil {
+		return false, err
+	}
+	defer conn.Close()
+	conn.SetDeadline(time.Now().Add(5 * time.Second))
+	return query(conn)
+}
+
+// queryNut asks upsd for the UPS status, which is a list of flags such
+// as "OL" (online), "OB" (on battery) and "LB" (low battery).
+func queryNut(rw io.ReadWriter) (bool, error) {
+	if _, err := fmt.Fprintf(rw, "GET VAR %s ups.status\n", nutName); err != nil {
+		return false, err
+	}
+	line, err := bufio.NewReader(rw).ReadString('\n')
+	if err != nil {
+		return false, err
+	}
+	line = strings.TrimSpace(line)
+	if strings.HasPrefix(line, "ERR ") {
+		return false, errors.New("upsd: " + line)
+	}
+	prefix := fmt.Sprintf("VAR %s ups.status ", nutName)
+	if !strings.HasPrefix(line, prefix) {
+		return false, fmt.Errorf("unexpected upsd reply %q", line)
+	}
+	status := strings.Trim(strings.TrimPrefix(line, prefix), `"`)
+	for _, f := range strings.Fields(status) {
+		if f == "OB" {
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+// queryApcupsd runs the NIS "status" command. Messages both ways are
+// prefixed with a big endian 16 bit length, and the reply ends with an
+// empty message.
+func queryApcupsd(rw io.ReadWriter) (bool, error) {
+	cmd := "status"
+	if err := binary.Write(rw, binary.BigEndian, uint16(len(cmd))); err != nil {
+		return false, err
+	}
+	if _, err := io.WriteString(rw, cmd); err != nil {
+		return false, err
+	}
+
+	found := false
+	onBattery := false
+	for {
+		var n uint16
+		if err := binary.Read(rw, binary.BigEndian, &n); err != nil {
+			return false, err
+		}
+		if n == 0 {
+			break
+		}
+		buf := make([]byte, n)
+		if _, err := io.ReadFull(rw, buf); err != nil {
+			return false, err
+		}
+		kv := strings.SplitN(string(buf), ":", 2)
+		if len(kv) == 2 && strings.TrimSpace(kv[0]) == "STATUS" {
+			found = true
+			onBattery = strings.Contains(kv[1], "ONBATT")
+		}
+	}
+	if !found {
+		return false, errors.New("apcupsd reply had no STATUS")
+	}
+	return onBattery, nil
+}
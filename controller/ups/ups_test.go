@@ -0,0 +1,77 @@
+package ups
+
+import (
+	"bytes"
+	"encoding/binary"
+	"io"
+	"testing"
+)
+
+// rw replays a canned reply and records what was written.
+type rw struct {
+	io.Reader
+	bytes.Buffer
+}
+
+func (r *rw) Read(p []byte) (int, error) { return r.Reader.Read(p) }
+
+func TestQueryNut(t *testing.T) {
+	cases := map[string]bool{
+		"VAR ups ups.status \"OL\"\n":          false,
+		"VAR ups ups.status \"OB DISCHRG\"\n":  true,
+		"VAR ups ups.status \"OL CHRG LB\"\n":  false,
+		"VAR ups ups.status \"OB LB DISCHRG\"": true,
+	}
+	for reply, want := range cases {
+		conn := &rw{Reader: bytes.NewBufferString(reply + "\n")}
+		got, err := queryNut(conn)
+		if err != nil {
+			t.Fatalf("%q: %v", reply, err)
+		}
+		if got != want {
+			t.Errorf("%q: expected %v, got %v", reply, want, got)
+		}
+		if conn.String() != "GET VAR ups ups.status\n" {
+			t.Errorf("Unexpected request %q", conn.String())
+		}
+	}
+
+	conn := &rw{Reader: bytes.NewBufferString("ERR UNKNOWN-UPS\n")}
+	if _, err := queryNut(conn); err == nil {
+		t.Error("Expected an error")
+	}
+}
+
+func apcReply(lines ...string) *bytes.Buffer {
+	b := &bytes.Buffer{}
+	for _, l := range lines {
+		binary.Write(b, binary.BigEndian, uint16(len(l)))
+		b.WriteString(l)
+	}
+	binary.Write(b, binary.BigEndian, uint16(0))
+	return b
+}
+
+func TestQueryApcupsd(t *testing.T) {
+	conn := &rw{Reader: apcReply("APC      : 001,036,0857\n", "STATUS   : ONBATT \n")}
+	onBattery, err := queryApcupsd(conn)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !onBattery {
+		t.Error("Expected to be on battery")
+	}
+	if !bytes.Equal(conn.Bytes(), []byte("\x00\x06status")) {
+		t.Errorf("Unexpected request % x", conn.Bytes())
+	}
+
+	conn = &rw{Reader: apcReply("STATUS   : ONLINE \n")}
+	if onBattery, _ := queryApcupsd(conn); onBattery {
+		t.Error("Expected to be online")
+	}
+
+	conn = &rw{Reader: apcReply("APC      : 001,036,0857\n")}
+	if _, err := queryApcupsd(conn); err == nil {
+		t.Error("Expected an error without STATUS")
+	}
+}
@@ -0,0 +1,96 @@
+// Package wan tracks the features which reach out over the internet,
+// such as calendar feeds, push notifications and metrics pushes, so the
+// controller can say when it is running without them. The lights never
+// wait on any of them: each falls back to what it last fetched, or does
+// without, and reports here how it went.
+package wan
+
+import (
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/theatrus/ledbrick/controller/clock"
+)
+
+// Service is an internet-dependent feature and how it last went.
+type Service struct {
+	Name   string `json:"name"`
+	Online bool   `json:"online"`
+	// LastSuccess and LastFailure are when it last reached its server,
+	// and last failed to
+	LastSuccess *time.Time `json:"last_success,omitempty"`
+	LastFailure *time.Time `json:"last_failure,omitempty"`
+	Error       string     `json:"error,omitempty"`
+	// Fallback is what is done instead while it is offline
+	Fallback string `json:"fallback,omitempty"`
+}
+
+// Tracker records how each service last went.
+type Tracker struct {
+	clock    clock.Clock
+	lock     sync.Mutex
+	services map[string]*Service
+}
+
+func NewTracker(c clock.Clock) *Tracker {
+	return &Tracker{clock: c, services: make(map[string]*Service)}
+}
+
+var tracker = NewTracker(clock.Real)
+
+// Report records whether a service reached its server, err being nil
+// if it did, and if not what it does instead.
+func Report(name string, err error, fallback string) {
+	tracker.Report(name, err, fallback)
+}
+
+func (t *Tracker) Report(name string, err error, fallback string) {
+	t.lock.Lock()
+	defer t.lock.Unlock()
+	s, ok := t.services[name]
+	if !ok {
+		s = &Service{Name: name}
+		t.services[name] = s
+	}
+	now := t.clock.Now()
+	if err == nil {
+		s.Online, s.Error, s.Fallback = true, "", ""
+		s.LastSuccess = &now
+		return
+	}
+	s.Online, s.Error, s.Fallback = false, err.Error(), fallback
+	s.LastFailure = &now
+}
+
+// Offline reports whether any service can't reach its server.
+func Offline() bool {
+	return tracker.Offline()
+}
+
+func (t *Tracker) Offline() bool {
+	t.lock.Lock()
+	defer t.lock.Unlock()
+	for _, s := range t.services {
+		if !s.Online {
+			return true
+		}
+	}
+	return false
+}
+
+// Services returns every service reported, by name.
+func Services() []Service {
+	return tracker.Services()
+}
+
+func (t *Tracker) Services() []Service {
+	t.lock.Lock()
+	defer t.lock.Unlock()
+	services := make([]Service, 0, len(t.services))
+	for _, s := range t.services {
+		services = append(services, *s)
+	}
+	sort.Slice(services, func(i, j int) bool { return services[i].Name < services[j].Name })
+	return services
+}
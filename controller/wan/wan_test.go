@@ -0,0 +1,43 @@
+package wan
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/theatrus/ledbrick/controller/clock"
+)
+
+func TestTracker(t *testing.T) {
+	clk := clock.NewFake(time.Date(2026, 3, 1, 12, 0, 0, 0, time.UTC))
+	tr := NewTracker(clk)
+	if tr.Offline() || len(tr.Services()) != 0 {
+		t.Fatalf("Expected nothing reported, got %v", tr.Services())
+	}
+
+	tr.Report("push", nil, "")
+	tr.Report("calendar", errors.New("no route to host"), "running 3 cached events")
+	if !tr.Offline() {
+		t.Errorf("Expected offline with the calendar failing")
+	}
+	services := tr.Services()
+	if len(services) != 2 || services[0].Name != "calendar" || services[1].Name != "push" {
+		t.Fatalf("Expected calendar and push, got %v", services)
+	}
+	cal := services[0]
+	if cal.Online || cal.Error != "no route to host" || cal.Fallback != "running 3 cached events" ||
+		cal.LastFailure == nil || cal.LastSuccess != nil {
+		t.Errorf("Expected the calendar failing on its cache, got %+v", cal)
+	}
+
+	clk.Advance(time.Hour)
+	tr.Report("calendar", nil, "")
+	if tr.Offline() {
+		t.Errorf("Expected online again")
+	}
+	cal = tr.Services()[0]
+	if !cal.Online || cal.Error != "" || cal.Fallback != "" || !cal.LastSuccess.Equal(clk.Now()) ||
+		!cal.LastFailure.Equal(clk.Now().Add(-time.Hour)) {
+		t.Errorf("Expected the calendar back with its failure kept, got %+v", cal)
+	}
+}
@@ -0,0 +1,182 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"github.com/theatrus/ledbrick/controller/atomicfile"
+	"github.com/theatrus/ledbrick/controller/ltable"
+	"github.com/theatrus/ledbrick/controller/report"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Default peaks for a reef tank, heavy on the blues
+var defaultPeaks = [ltable.Channels]float64{30, 50, 30, 65, 20, 85, 30, 90}
+
+// prompter asks questions, re-asking until an answer parses.
+type prompter struct {
+	in  *bufio.Reader
+	out io.Writer
+}
+
+func (p *prompter) ask(question, def string, parse func(string) error) (string, error) {
+	for {
+		fmt.Fprintf(p.out, "%s [%s]: ", question, def)
+		line, err := p.in.ReadString('\n')
+		if err != nil && (err != io.EOF || line == "") {
+			return "", err
+		}
+		answer := strings.TrimSpace(line)
+		if answer == "" {
+			answer = def
+		}
+		if err := parse(answer); err != nil {
+			fmt.Fprintf(p.out, "  %v\n", err)
+			continue
+		}
+		return answer, nil
+	}
+}
+
+// runInit asks a few questions and writes a starter lighting table,
+// only replacing one already there if told to.
+func runInit(in io.Reader, out io.Writer) error {
+	p := &prompter{in: bufio.NewReader(in), out: out}
+	fmt.Fprintln(out, "This writes a starter lighting table. Press enter to take the default.")
+
+	file, err := p.ask("Config file", *config, func(string) error { return nil })
+	if err != nil {
+		return err
+	}
+	if _, err := os.Stat(file); err == nil {
+		replace, err := p.ask(file+" exists, replace it", "no", func(s string) error {
+			if s != "yes" && s != "no" {
+				return fmt.Errorf("expected yes or no")
+			}
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+		if replace != "yes" {
+			return fmt.Errorf("left %s as it was", file)
+		}
+	}
+	zone, err := p.ask("Time zone", "America/Los_Angeles", func(s string) error {
+		_, err := time.LoadLocation(s)
+		return err
+	})
+	if err != nil {
+		return err
+	}
+
+	var plan ltable.Plan
+	if plan.On, err = p.ask("Lights on", "09:00", checkClock); err != nil {
+		return err
+	}
+	if plan.Off, err = p.ask("Lights off", "21:00", checkClock); err != nil {
+		return err
+	}
+	ramp, err := p.ask("Sunrise and sunset length", "1h30m", func(s string) error {
+		_, err := time.ParseDuration(s)
+		return err
+	})
+	if err != nil {
+		return err
+	}
+	plan.Ramp, _ = time.ParseDuration(ramp)
+
+	// With a PAR calibration the day can be given as a DLI, and the
+	// channel answers are the mix rather than percents
+	var target ltable.Target
+	if par := report.PAR(); len(par) > 0 {
+		dli, err := p.ask("Target DLI in mol/m2/d, none to give channel peaks", "none", func(s string) error {
+			if s == "none" {
+				return nil
+			}
+			v, err := strconv.ParseFloat(s, 64)
+			if err != nil || v <= 0 {
+				return fmt.Errorf("expected a DLI such as 8, or none")
+			}
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+		if dli != "none" {
+			target.DLI, _ = strconv.ParseFloat(dli, 64)
+			copy(target.PAR[:], par)
+		}
+	}
+
+	for channel, name := range ltable.ChannelNames {
+		def := strconv.FormatFloat(defaultPeaks[channel], 'f', -1, 64)
+		question := fmt.Sprintf("Channel %d (%s) peak percent", channel, name)
+		if target.DLI > 0 {
+			question = fmt.Sprintf("Channel %d (%s) share of the mix", channel, name)
+		}
+		v, err := p.ask(question, def, checkPercent)
+		if err != nil {
+			return err
+		}
+		plan.Peaks[channel], _ = strconv.ParseFloat(v, 64)
+	}
+
+	moon, err := p.ask("Moonlight channel, none for no moonlight", "5", func(s string) error {
+		if s == "none" {
+			return nil
+		}
+		channel, err := strconv.Atoi(s)
+		if err != nil || channel < 0 || channel >= ltable.Channels {
+			return fmt.Errorf("expected a channel from 0 to %d, or none", ltable.Channels-1)
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	plan.Moonlight = -1
+	if moon != "none" {
+		plan.Moonlight, _ = strconv.Atoi(moon)
+		v, err := p.ask("Moonlight percent", "0.5", checkPercent)
+		if err != nil {
+			return err
+		}
+		plan.MoonPercent, _ = strconv.ParseFloat(v, 64)
+	}
+
+	if target.DLI > 0 {
+		target.Weights = plan.Peaks
+		if plan, err = ltable.Solve(plan, target); err != nil {
+			return err
+		}
+	}
+	data, err := ltable.Generate(plan)
+	if err != nil {
+		return err
+	}
+	if err := atomicfile.WriteFile(file, append(data, '\n'), 0644); err != nil {
+		return err
+	}
+	fmt.Fprintf(out, "Wrote %s. Start the controller with:\n", file)
+	fmt.Fprintf(out, "  ledbrick -config=%s -ltable.location=%s\n", file, zone)
+	return nil
+}
+
+func checkClock(s string) error {
+	if _, err := time.Parse("15:04", s); err != nil {
+		return fmt.Errorf("expected a time such as 09:30")
+	}
+	return nil
+}
+
+func checkPercent(s string) error {
+	v, err := strconv.ParseFloat(s, 64)
+	if err != nil || v < 0 || v > 100 {
+		return fmt.Errorf("expected a percent from 0 to 100")
+	}
+	return nil
+}
@@ -0,0 +1,48 @@
+package main
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestRunInit(t *testing.T) {
+	dir, err := ioutil.TempDir("", "init")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	file := filepath.Join(dir, "ltable.json")
+
+	// The file name, then the defaults for the rest
+	script := file + "\n" + strings.Repeat("\n", 20)
+	var out bytes.Buffer
+	if err := runInit(strings.NewReader(script), &out); err != nil {
+		t.Fatal(err)
+	}
+	table, err := ioutil.ReadFile(file)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Contains(table, []byte(`"09:00"`)) {
+		t.Errorf("Expected a table lit from 09:00, got %s", table)
+	}
+
+	// An existing table is kept unless replacing it is confirmed
+	for _, answer := range []string{"", "no"} {
+		err := runInit(strings.NewReader(file+"\n"+answer+"\n"+strings.Repeat("\n", 20)), &out)
+		if err == nil {
+			t.Errorf("Expected %q not to replace the table", answer)
+		}
+	}
+	script = file + "\nmaybe\nyes\n\n10:00\n" + strings.Repeat("\n", 20)
+	if err := runInit(strings.NewReader(script), &out); err != nil {
+		t.Fatal(err)
+	}
+	if replaced, _ := ioutil.ReadFile(file); bytes.Equal(replaced, table) {
+		t.Error("Expected the table replaced once confirmed")
+	}
+}